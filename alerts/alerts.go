@@ -0,0 +1,197 @@
+// Package alerts notifies the pool operators of pool events through webhooks, Telegram and Slack.
+//
+// Every module fires its own events into the instance of its pool, set up by Init.
+package alerts
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// Events of the pool alerts are fired for.
+const (
+	EventUnlockerHalted  = "unlockerHalted"
+	EventBlockFound      = "blockFound"
+	EventBlockOrphaned   = "blockOrphaned"
+	EventBlockStuck      = "blockStuck"
+	EventPayoutsFailed   = "payoutsFailed"
+	EventNodeOutOfSync   = "nodeOutOfSync"
+	EventNodeInSync      = "nodeInSync"
+	EventHashrateLow     = "hashrateLow"
+	EventHashrateRestore = "hashrateRestored"
+	EventChainHalted     = "chainHalted"
+	EventChainResumed    = "chainResumed"
+	EventWithholding     = "shareWithholding"
+	EventWalletLow       = "hotWalletLow"
+)
+
+var events = []string{
+	EventUnlockerHalted, EventBlockFound, EventBlockOrphaned, EventBlockStuck, EventPayoutsFailed,
+	EventNodeOutOfSync, EventNodeInSync, EventHashrateLow, EventHashrateRestore,
+	EventChainHalted, EventChainResumed, EventWithholding, EventWalletLow,
+}
+
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// The same alert for the same subject, e.g. a node, is sent once per cooldown
+	Cooldown string `json:"cooldown"`
+	// Alert when the pool hashrate in H/s falls below, 0 disables it
+	HashrateThreshold int64 `json:"hashrateThreshold"`
+	// A node whose height didn't change for this long is out of sync
+	NodeSyncTimeout string `json:"nodeSyncTimeout"`
+	// A node more blocks than this behind the highest node is out of sync
+	NodeHeightLag int64           `json:"nodeHeightLag"`
+	Channels      []ChannelConfig `json:"channels"`
+}
+
+type ChannelConfig struct {
+	Name string `json:"name"`
+	// webhook, telegram or slack
+	Type string `json:"type"`
+	// webhook: URL alerts are POSTed to. slack: incoming webhook URL
+	URL string `json:"url"`
+	// telegram: bot token and chat to send to
+	BotToken string `json:"botToken"`
+	ChatId   string `json:"chatId"`
+	// Events sent to the channel, all if empty
+	Events  []string `json:"events"`
+	Timeout string   `json:"timeout"`
+}
+
+// Alert is an event of the pool, as sent to webhooks.
+type Alert struct {
+	Event     string `json:"event"`
+	Coin      string `json:"coin"`
+	Pool      string `json:"pool"`
+	Subject   string `json:"subject"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Text formats the alert for chat channels.
+func (a *Alert) Text() string {
+	return fmt.Sprintf("[%v %v] %v", a.Pool, a.Coin, a.Message)
+}
+
+type Alerts struct {
+	config   *Config
+	coin     string
+	pool     string
+	cooldown time.Duration
+	channels []*channel
+	queue    chan *Alert
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+var (
+	poolsMu sync.RWMutex
+	pools   = make(map[string]*Alerts)
+)
+
+// Init sets up the alerts of pool, returned by For from now on.
+func Init(cfg *Config, coin, pool string) {
+	if !cfg.Enabled {
+		return
+	}
+	a := New(cfg, coin, pool)
+	go a.run()
+	poolsMu.Lock()
+	pools[pool] = a
+	poolsMu.Unlock()
+	log.Printf("Sending alerts of %v to %v channels", pool, len(a.channels))
+}
+
+// For returns the alerts of pool, nil before Init or if they are disabled. Fire does nothing on nil.
+func For(pool string) *Alerts {
+	poolsMu.RLock()
+	defer poolsMu.RUnlock()
+	return pools[pool]
+}
+
+func New(cfg *Config, coin, pool string) *Alerts {
+	a := &Alerts{
+		config:   cfg,
+		coin:     coin,
+		pool:     pool,
+		cooldown: 10 * time.Minute,
+		queue:    make(chan *Alert, 100),
+		sent:     make(map[string]time.Time),
+	}
+	if len(cfg.Cooldown) > 0 {
+		a.cooldown = util.MustParseDuration(cfg.Cooldown)
+	}
+	for i := range cfg.Channels {
+		c, err := newChannel(&cfg.Channels[i])
+		if err != nil {
+			log.Fatalf("Alert channel %v: %v", cfg.Channels[i].Name, err)
+		}
+		a.channels = append(a.channels, c)
+	}
+	return a
+}
+
+// Settings returns the config of the alerts, nil if disabled.
+func (a *Alerts) Settings() *Config {
+	if a == nil {
+		return nil
+	}
+	return a.config
+}
+
+// Fire sends an alert of event about subject, e.g. a block height or node name, unless the same
+// alert was sent within the cooldown. It doesn't block, alerts are dropped if the queue is full.
+func (a *Alerts) Fire(event, subject, format string, v ...interface{}) {
+	if a == nil {
+		return
+	}
+	alert := a.alert(event, subject, fmt.Sprintf(format, v...))
+	if alert == nil {
+		return
+	}
+	select {
+	case a.queue <- alert:
+	default:
+		log.Printf("Alert queue is full, dropped: %v", alert.Message)
+	}
+}
+
+// alert returns the alert to send, nil if it's in the cooldown.
+func (a *Alerts) alert(event, subject, message string) *Alert {
+	now := time.Now()
+	key := event + ":" + subject
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if last, ok := a.sent[key]; ok && now.Sub(last) < a.cooldown {
+		return nil
+	}
+	a.sent[key] = now
+	for k, t := range a.sent {
+		if now.Sub(t) >= a.cooldown {
+			delete(a.sent, k)
+		}
+	}
+	return &Alert{Event: event, Coin: a.coin, Pool: a.pool, Subject: subject, Message: message, Timestamp: now.Unix()}
+}
+
+func (a *Alerts) run() {
+	for alert := range a.queue {
+		a.send(alert)
+	}
+}
+
+func (a *Alerts) send(alert *Alert) {
+	for _, c := range a.channels {
+		if !c.accepts(alert.Event) {
+			continue
+		}
+		if err := c.send(alert); err != nil {
+			log.Printf("Failed to send %v alert to %v: %v", alert.Event, c.config.Name, err)
+		}
+	}
+}
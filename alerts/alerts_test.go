@@ -0,0 +1,95 @@
+package alerts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type received struct {
+	path string
+	body map[string]interface{}
+}
+
+func recorder(t *testing.T) (*httptest.Server, chan received) {
+	ch := make(chan received, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		ch <- received{r.URL.Path, body}
+	}))
+	return srv, ch
+}
+
+func TestChannels(t *testing.T) {
+	srv, ch := recorder(t)
+	defer srv.Close()
+	telegramAPI = srv.URL
+
+	a := New(&Config{Channels: []ChannelConfig{
+		{Name: "hook", Type: "webhook", URL: srv.URL + "/hook"},
+		{Name: "slack", Type: "slack", URL: srv.URL + "/slack", Events: []string{EventBlockFound}},
+		{Name: "tg", Type: "telegram", BotToken: "123:abc", ChatId: "-100", Events: []string{EventUnlockerHalted}},
+	}}, "dgc", "pool")
+
+	a.send(a.alert(EventBlockFound, "100", "Block 100 found"))
+	got := map[string]map[string]interface{}{}
+	for i := 0; i < 2; i++ {
+		r := <-ch
+		got[r.path] = r.body
+	}
+	if got["/hook"]["event"] != EventBlockFound || got["/hook"]["subject"] != "100" || got["/hook"]["coin"] != "dgc" {
+		t.Errorf("Webhook received %v", got["/hook"])
+	}
+	if got["/slack"]["text"] != "[pool dgc] Block 100 found" {
+		t.Errorf("Slack received %v", got["/slack"])
+	}
+
+	a.send(a.alert(EventUnlockerHalted, "unlocker", "halted"))
+	for i := 0; i < 2; i++ {
+		r := <-ch
+		got[r.path] = r.body
+	}
+	if tg := got["/bot123:abc/sendMessage"]; tg == nil || tg["chat_id"] != "-100" {
+		t.Errorf("Telegram received %v", got)
+	}
+	select {
+	case r := <-ch:
+		t.Errorf("Unexpected alert to %v", r.path)
+	default:
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	a := New(&Config{Cooldown: "1h"}, "dgc", "pool")
+	if a.alert(EventNodeOutOfSync, "node1", "out of sync") == nil {
+		t.Fatal("First alert must be sent")
+	}
+	if a.alert(EventNodeOutOfSync, "node1", "out of sync") != nil {
+		t.Error("Repeated alert must wait for the cooldown")
+	}
+	if a.alert(EventNodeOutOfSync, "node2", "out of sync") == nil {
+		t.Error("Alert about another subject must be sent")
+	}
+	a.sent[EventNodeOutOfSync+":node1"] = time.Now().Add(-2 * time.Hour)
+	if a.alert(EventNodeOutOfSync, "node1", "out of sync") == nil {
+		t.Error("Alert must be sent again after the cooldown")
+	}
+}
+
+func TestChannelValidates(t *testing.T) {
+	for _, cfg := range []ChannelConfig{
+		{Name: "a", Type: "webhook"},
+		{Name: "b", Type: "telegram", BotToken: "x"},
+		{Name: "c", Type: "email", URL: "x"},
+		{Name: "d", Type: "slack", URL: "x", Events: []string{"blockfound"}},
+	} {
+		if _, err := newChannel(&cfg); err == nil {
+			t.Errorf("Channel %v must be rejected", cfg.Name)
+		}
+	}
+}
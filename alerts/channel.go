@@ -0,0 +1,83 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// telegramAPI is the Bot API base URL, replaced in tests.
+var telegramAPI = "https://api.telegram.org"
+
+type channel struct {
+	config *ChannelConfig
+	client *http.Client
+}
+
+func newChannel(cfg *ChannelConfig) (*channel, error) {
+	switch cfg.Type {
+	case "webhook", "slack":
+		if len(cfg.URL) == 0 {
+			return nil, fmt.Errorf("%v needs a url", cfg.Type)
+		}
+	case "telegram":
+		if len(cfg.BotToken) == 0 || len(cfg.ChatId) == 0 {
+			return nil, fmt.Errorf("telegram needs a botToken and chatId")
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q", cfg.Type)
+	}
+	for _, e := range cfg.Events {
+		if !util.StringInSlice(e, events) {
+			return nil, fmt.Errorf("unknown event %q", e)
+		}
+	}
+	timeout := 10 * time.Second
+	if len(cfg.Timeout) > 0 {
+		timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	return &channel{config: cfg, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (c *channel) accepts(event string) bool {
+	return len(c.config.Events) == 0 || util.StringInSlice(event, c.config.Events)
+}
+
+func (c *channel) send(alert *Alert) error {
+	switch c.config.Type {
+	case "slack":
+		return c.post(c.config.URL, map[string]interface{}{"text": alert.Text()})
+	case "telegram":
+		endpoint := telegramAPI + "/bot" + c.config.BotToken + "/sendMessage"
+		return c.post(endpoint, map[string]interface{}{"chat_id": c.config.ChatId, "text": alert.Text()})
+	}
+	return c.post(c.config.URL, alert)
+}
+
+func (c *channel) post(endpoint string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The URL may hold a token
+		if uerr, ok := err.(*url.Error); ok {
+			return uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("replied %v: %s", resp.Status, msg)
+	}
+	return nil
+}
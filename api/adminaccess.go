@@ -0,0 +1,166 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type AdminAccessConfig struct {
+	// CIDR ranges or IPs the admin routes may be called from, anywhere when empty
+	AllowedNets []string `json:"allowedNets"`
+	// Take the client IP from the X-Forwarded-For the reverse proxy in front of the API adds
+	BehindReverseProxy bool `json:"behindReverseProxy"`
+	// Admin routes need a client certificate signed by api.tls.clientCAFile
+	RequireClientCert bool `json:"requireClientCert"`
+}
+
+type ApiTLSConfig struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// CA of the client certificates. They are asked for on every connection and verified when
+	// given, but only required on the admin routes with adminAccess.requireClientCert
+	ClientCAFile string `json:"clientCAFile"`
+}
+
+// Admin pages only read from, the other admin routes are the ones needing more than read-only.
+var adminPages = map[string]bool{
+	"/api/inbounds":                  true,
+	"/api/idbounds":                  true,
+	"/api/devsearch":                 true,
+	"/api/payoutreports":             true,
+	"/api/payoutreports/{id:[0-9]+}": true,
+	"/api/payoutcontracts":           true,
+	"/api/compensations":             true,
+	"/api/compensations/{id:[0-9]+}": true,
+	"/api/features":                  true,
+	"/api/redismemory":               true,
+	"/api/exchanges":                 true,
+	"/api/withholding":               true,
+	"/api/bans":                      true,
+	"/api/loglevels":                 true,
+	"/api/admin/health":              true,
+}
+
+func isAdminRoute(r *http.Request) bool {
+	if requiredRole(r) != roleReadOnly {
+		return true
+	}
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ := route.GetPathTemplate()
+		return adminPages[template]
+	}
+	return false
+}
+
+func (s *ApiServer) initAdminAccess() {
+	cfg := s.config.AdminAccess
+	if cfg == nil {
+		return
+	}
+	for _, value := range cfg.AllowedNets {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				log.Fatalf("Invalid IP %v in api.adminAccess.allowedNets", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			s.adminNets = append(s.adminNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			log.Fatalf("Invalid range %v in api.adminAccess.allowedNets: %v", value, err)
+		}
+		s.adminNets = append(s.adminNets, ipNet)
+	}
+	if cfg.RequireClientCert && (s.config.TLS == nil || len(s.config.TLS.ClientCAFile) == 0) {
+		log.Fatalf("api.adminAccess.requireClientCert needs api.tls.clientCAFile")
+	}
+}
+
+// clientIP is the address of the caller. Behind a reverse proxy it's the last one of
+// X-Forwarded-For, the one the proxy appended, as the caller may send the header too.
+func (s *ApiServer) clientIP(r *http.Request) net.IP {
+	if s.config.AdminAccess.BehindReverseProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := net.ParseIP(strings.TrimSpace(forwarded[len(forwarded)-1])); ip != nil {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// checkAdminAccess refuses admin routes called from outside the allowed ranges or without a
+// verified client certificate when one is required.
+func (s *ApiServer) checkAdminAccess(r *http.Request) (bool, string) {
+	cfg := s.config.AdminAccess
+	if cfg == nil || !isAdminRoute(r) {
+		return true, ""
+	}
+	if len(s.adminNets) > 0 {
+		ip := s.clientIP(r)
+		allowed := false
+		for _, ipNet := range s.adminNets {
+			if ip != nil && ipNet.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false, fmt.Sprintf("forbidden: Address %v not allowed", ip)
+		}
+	}
+	if cfg.RequireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		return false, "forbidden: Client certificate required"
+	}
+	return true, ""
+}
+
+func (s *ApiServer) newTLSConfig() (*tls.Config, error) {
+	cfg := s.config.TLS
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+	if len(cfg.ClientCAFile) > 0 {
+		pem, err := ioutil.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %v", cfg.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// serve listens for the API, over TLS when api.tls is set.
+func (s *ApiServer) serve(handler http.Handler) error {
+	if s.config.TLS == nil {
+		return http.ListenAndServe(s.config.Listen, handler)
+	}
+	config, err := s.newTLSConfig()
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: s.config.Listen, Handler: handler, TLSConfig: config}
+	return server.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newAdminAccessServer(cfg *AdminAccessConfig) *ApiServer {
+	s := &ApiServer{config: &ApiConfig{AdminAccess: cfg}}
+	s.initAdminAccess()
+	return s
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		behindProxy       bool
+		remote, forwarded string
+		expected          string
+	}{
+		{false, "10.0.0.5:4000", "", "10.0.0.5"},
+		// A caller can't pick its address without the reverse proxy
+		{false, "203.0.113.9:4000", "10.0.0.5", "203.0.113.9"},
+		// The proxy appends the caller to what it sent
+		{true, "127.0.0.1:4000", "10.0.0.5, 203.0.113.9", "203.0.113.9"},
+		{true, "127.0.0.1:4000", "", "127.0.0.1"},
+		{true, "127.0.0.1:4000", "garbage", "127.0.0.1"},
+		{false, "[2001:db8::7]:4000", "", "2001:db8::7"},
+		{true, "127.0.0.1:4000", "2001:db8::7", "2001:db8::7"},
+	}
+	for _, test := range tests {
+		s := newAdminAccessServer(&AdminAccessConfig{BehindReverseProxy: test.behindProxy})
+		r := httptest.NewRequest("GET", "/api/addaccount", nil)
+		r.RemoteAddr = test.remote
+		if len(test.forwarded) > 0 {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if ip := s.clientIP(r); ip.String() != test.expected {
+			t.Errorf("Client of %v forwarded for %q must be %v, got %v", test.remote, test.forwarded, test.expected, ip)
+		}
+	}
+}
+
+func TestCheckAdminAccess(t *testing.T) {
+	s := newAdminAccessServer(&AdminAccessConfig{AllowedNets: []string{"10.0.0.0/8", "192.168.1.7", "2001:db8::/32", "fd00::1"}})
+
+	var allowed bool
+	router := mux.NewRouter()
+	for _, template := range []string{"/api/addaccount", "/api/stats"} {
+		router.HandleFunc(template, func(w http.ResponseWriter, r *http.Request) {
+			allowed, _ = s.checkAdminAccess(r)
+		})
+	}
+
+	tests := []struct {
+		path, remote, forwarded string
+		expected                bool
+	}{
+		{"/api/addaccount", "10.1.2.3:4000", "", true},
+		{"/api/addaccount", "192.168.1.7:4000", "", true},
+		{"/api/addaccount", "192.168.1.8:4000", "", false},
+		{"/api/addaccount", "203.0.113.9:4000", "", false},
+		// X-Forwarded-For is ignored without the reverse proxy
+		{"/api/addaccount", "203.0.113.9:4000", "10.1.2.3", false},
+		{"/api/addaccount", "[::ffff:10.1.2.3]:4000", "", true},
+		{"/api/addaccount", "[2001:db8:1::1]:4000", "", true},
+		{"/api/addaccount", "[fd00::1]:4000", "", true},
+		{"/api/addaccount", "[fd00::2]:4000", "", false},
+		// Not an admin route
+		{"/api/stats", "203.0.113.9:4000", "", true},
+	}
+	for _, test := range tests {
+		allowed = false
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.RemoteAddr = test.remote
+		if len(test.forwarded) > 0 {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if allowed != test.expected {
+			t.Errorf("%v from %v forwarded for %q must be allowed %v", test.path, test.remote, test.forwarded, test.expected)
+		}
+	}
+
+	s = &ApiServer{config: &ApiConfig{AdminAccess: &AdminAccessConfig{RequireClientCert: true}, TLS: &ApiTLSConfig{ClientCAFile: "ca.pem"}}}
+	s.initAdminAccess()
+	if ok, _ := s.checkAdminAccess(httptest.NewRequest("POST", "/api/addaccount", nil)); ok {
+		t.Error("Must require a client certificate on admin routes")
+	}
+}
@@ -2,6 +2,7 @@ package alarm
 
 import (
 	"fmt"
+	"github.com/cellcrypto/open-dangnn-pool/api/i18n"
 	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
 	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
 	"github.com/cellcrypto/open-dangnn-pool/storage/types"
@@ -19,6 +20,9 @@ type Config struct {
 	AlarmCheckWaitInterval 	string  	`json:"alarmCheckWaitInterval"`	// Alarm waiting time
 	SlackBotToken 			string 		`json:"slackBotToken"`
 	SlackChannelId			string 		`json:"slackChannelId"`
+	// Locale of notifications, defaults to the API default locale
+	Locale					string		`json:"locale"`
+	Messages				*i18n.Catalog	`json:"-"`
 }
 
 type Entry struct {
@@ -130,7 +134,7 @@ func (a *AlramServer) alarmProcess()  {
 		//
 		switch alarmInfo.Alarm {
 		case "slack":
-			sendSlackList += "occurrence of abnormal system: (" + a.config.Coin + ")"+alarmInfo.Desc + "[" + alarmInfo.Id + "]\n"
+			sendSlackList += a.Translate("occurrence of abnormal system: (%v)%v[%v]", a.config.Coin, alarmInfo.Desc, alarmInfo.Id) + "\n"
 		case "mail":
 		}
 	}
@@ -146,7 +150,7 @@ func (a *AlramServer) alarmProcess()  {
 func (a *AlramServer) SendMessageToSlack(msg string) error {
 
 	attachment := slack.Attachment{
-		Pretext: "*It's work time HUMAN!!!!!* (" + a.config.Coin + ")",
+		Pretext: "*" + a.Translate("It's work time HUMAN!!!!! (%v)", a.config.Coin) + "*",
 		Text:    msg,
 	}
 
@@ -164,6 +168,22 @@ func (a *AlramServer) SendMessageToSlack(msg string) error {
 	return nil
 }
 
+// Notify sends a translated message to Slack.
+func (a *AlramServer) Notify(key string, v ...interface{}) error {
+	return a.SendMessageToSlack(a.Translate(key, v...))
+}
+
+func (a *AlramServer) Translate(key string, v ...interface{}) string {
+	if a.config.Messages == nil {
+		return fmt.Sprintf(key, v...)
+	}
+	locale := a.config.Locale
+	if len(locale) == 0 {
+		locale = a.config.Messages.Default()
+	}
+	return a.config.Messages.Translate(locale, key, v...)
+}
+
 func (a *AlramServer) MakeAlarmList() {
 	tmpAlarmList, err := a.db.GetAlarmInfo()
 	if err != nil {
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// alertState is what the pool-wide alerts checked after every stats collection last saw.
+type alertState struct {
+	nodes       map[string]*nodeProgress
+	hashrateLow bool
+	syncTimeout time.Duration
+}
+
+type nodeProgress struct {
+	height    int64
+	changedAt time.Time
+	outOfSync bool
+}
+
+func newAlertState(cfg *alerts.Config) *alertState {
+	if cfg.NodeHeightLag <= 0 {
+		cfg.NodeHeightLag = 10
+	}
+	st := &alertState{nodes: make(map[string]*nodeProgress), syncTimeout: 5 * time.Minute}
+	if len(cfg.NodeSyncTimeout) > 0 {
+		st.syncTimeout = util.MustParseDuration(cfg.NodeSyncTimeout)
+	}
+	return st
+}
+
+// checkAlerts fires the alerts of the pool's nodes and hashrate.
+func (s *ApiServer) checkAlerts(stats map[string]interface{}) {
+	a := alerts.For(s.config.Name)
+	cfg := a.Settings()
+	if cfg == nil {
+		return
+	}
+	if s.alertState == nil {
+		s.alertState = newAlertState(cfg)
+	}
+	s.checkNodeSync(a, cfg)
+
+	hashrate, ok := stats["hashrate"].(int64)
+	if !ok || cfg.HashrateThreshold <= 0 {
+		return
+	}
+	st := s.alertState
+	if hashrate < cfg.HashrateThreshold && !st.hashrateLow {
+		st.hashrateLow = true
+		a.Fire(alerts.EventHashrateLow, "pool", "Pool hashrate %v H/s is below %v H/s", hashrate, cfg.HashrateThreshold)
+	} else if hashrate >= cfg.HashrateThreshold && st.hashrateLow {
+		st.hashrateLow = false
+		a.Fire(alerts.EventHashrateRestore, "pool", "Pool hashrate is back at %v H/s", hashrate)
+	}
+}
+
+// checkNodeSync tells a node out of sync when its height, as reported by the proxies, stalls or
+// falls behind the highest node.
+func (s *ApiServer) checkNodeSync(a *alerts.Alerts, cfg *alerts.Config) {
+	nodes, err := s.backend.GetNodeStates()
+	if err != nil {
+		return
+	}
+	st := s.alertState
+	now := time.Now()
+	heights := make(map[string]int64)
+	var top int64
+	for _, node := range nodes {
+		name, _ := node["name"].(string)
+		height, _ := strconv.ParseInt(toString(node["height"]), 10, 64)
+		if len(name) == 0 {
+			continue
+		}
+		heights[name] = height
+		if height > top {
+			top = height
+		}
+	}
+
+	for name, height := range heights {
+		p, ok := st.nodes[name]
+		if !ok {
+			st.nodes[name] = &nodeProgress{height: height, changedAt: now}
+			continue
+		}
+		if height != p.height {
+			p.height = height
+			p.changedAt = now
+		}
+		stalled := now.Sub(p.changedAt) >= st.syncTimeout
+		behind := top-height > cfg.NodeHeightLag
+		if (stalled || behind) && !p.outOfSync {
+			p.outOfSync = true
+			if stalled {
+				a.Fire(alerts.EventNodeOutOfSync, name, "Node %v is out of sync, stuck at height %v for %v", name, height, now.Sub(p.changedAt).Truncate(time.Second))
+			} else {
+				a.Fire(alerts.EventNodeOutOfSync, name, "Node %v is out of sync, %v blocks behind at height %v", name, top-height, height)
+			}
+		} else if !stalled && !behind && p.outOfSync {
+			p.outOfSync = false
+			a.Fire(alerts.EventNodeInSync, name, "Node %v is in sync again at height %v", name, height)
+		}
+	}
+}
+
+func toString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,143 @@
+// Package anomaly flags sudden pool hashrate changes against the recent rate of change,
+// so an outage or a large farm joining stands out from normal variance.
+package anomaly
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	KindSpike = "spike"
+	KindDrop  = "drop"
+)
+
+// Changes needed before the baseline is trusted.
+const minBaseline = 5
+
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Pool chart samples the baseline is computed over
+	Window int `json:"window"`
+	// Flag a change this many standard deviations away from the mean change
+	Sigma float64 `json:"sigma"`
+	// Ignore changes smaller than this fraction, 0.2 is 20%
+	MinChange float64 `json:"minChange"`
+}
+
+type Anomaly struct {
+	Timestamp int64
+	Kind      string
+	// Relative change from the previous sample
+	Change   float64
+	Previous int64
+	Hashrate int64
+	// Distance from the mean change in standard deviations
+	Sigma float64
+}
+
+type Detector struct {
+	config  Config
+	mu      sync.Mutex
+	samples []int64
+}
+
+func New(cfg *Config) *Detector {
+	d := &Detector{config: *cfg}
+	if d.config.Window < minBaseline+1 {
+		d.config.Window = 24
+	}
+	if d.config.Sigma <= 0 {
+		d.config.Sigma = 3
+	}
+	if d.config.MinChange <= 0 {
+		d.config.MinChange = 0.2
+	}
+	return d
+}
+
+// Seed fills the baseline with past samples, oldest first, without checking them.
+func (d *Detector) Seed(hashrates []int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, h := range hashrates {
+		d.push(h)
+	}
+}
+
+// Add records a sample and returns an anomaly if its change is out of the baseline.
+func (d *Detector) Add(ts int64, hashrate int64) *Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	baseline := changes(d.samples)
+	var previous int64
+	if len(d.samples) > 0 {
+		previous = d.samples[len(d.samples)-1]
+	}
+	d.push(hashrate)
+
+	if previous <= 0 || len(baseline) < minBaseline {
+		return nil
+	}
+	change := float64(hashrate-previous) / float64(previous)
+	if math.Abs(change) < d.config.MinChange {
+		return nil
+	}
+
+	mean, stddev := meanStddev(baseline)
+	deviation := math.Abs(change - mean)
+	sigma := math.Inf(1)
+	if stddev > 0 {
+		sigma = deviation / stddev
+	}
+	if sigma < d.config.Sigma {
+		return nil
+	}
+
+	kind := KindSpike
+	if change < 0 {
+		kind = KindDrop
+	}
+	return &Anomaly{
+		Timestamp: ts,
+		Kind:      kind,
+		Change:    change,
+		Previous:  previous,
+		Hashrate:  hashrate,
+		Sigma:     sigma,
+	}
+}
+
+func (d *Detector) push(hashrate int64) {
+	d.samples = append(d.samples, hashrate)
+	if len(d.samples) > d.config.Window+1 {
+		d.samples = d.samples[len(d.samples)-d.config.Window-1:]
+	}
+}
+
+// changes returns the relative change between successive samples, skipping zero hashrate.
+func changes(samples []int64) []float64 {
+	var result []float64
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] <= 0 {
+			continue
+		}
+		result = append(result, float64(samples[i]-samples[i-1])/float64(samples[i-1]))
+	}
+	return result
+}
+
+func meanStddev(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/gorilla/mux"
+)
+
+type ApiKeysConfig struct {
+	Enabled bool `json:"enabled"`
+	// Requests a minute of a key without its own limit, 60 by default
+	RateLimit int `json:"rateLimit"`
+}
+
+// Header of the requests made with an API key instead of a token
+const apiKeyHeader = "X-Api-Key"
+
+// Keys are the prefix and 32 random bytes in hex, the prefix and the first hex digits are kept to tell them apart.
+const (
+	apiKeyPrefix    = "dgp_"
+	apiKeyShownSize = len(apiKeyPrefix) + 8
+)
+
+// keyLimiter holds a token bucket of requests per API key.
+type keyLimiter struct {
+	sync.Mutex
+	buckets map[int64]*requestBucket
+}
+
+type requestBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the key's bucket, refilled with perMinute tokens a minute, false if
+// it's empty.
+func (l *keyLimiter) allow(id int64, perMinute int, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &requestBucket{tokens: float64(perMinute), last: now}
+		l.buckets[id] = b
+	}
+	b.tokens += now.Sub(b.last).Minutes() * float64(perMinute)
+	if b.tokens > float64(perMinute) {
+		b.tokens = float64(perMinute)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (s *ApiServer) initApiKeys() {
+	cfg := s.config.ApiKeys
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+	if cfg.RateLimit <= 0 {
+		cfg.RateLimit = 60
+	}
+	s.apiKeys = &keyLimiter{buckets: make(map[int64]*requestBucket)}
+}
+
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkApiKey authenticates a request made with an API key and applies the key's rate limit. It
+// returns the status to reply with when the request is refused.
+func (s *ApiServer) checkApiKey(r *http.Request, key string) (int, string) {
+	if s.apiKeys == nil {
+		return http.StatusUnauthorized, "unauthorized: API keys are disabled"
+	}
+	apiKey, err := s.db.GetApiKey(hashApiKey(key))
+	if err != nil {
+		log.Errorf("Failed to read API key: %v", err)
+		return http.StatusUnauthorized, "unauthorized: Invalid API key"
+	}
+	if apiKey == nil {
+		return http.StatusUnauthorized, "unauthorized: Invalid API key"
+	}
+
+	limit := apiKey.RateLimit
+	if limit <= 0 {
+		limit = s.config.ApiKeys.RateLimit
+	}
+	now := time.Now()
+	if !s.apiKeys.allow(apiKey.Id, limit, now) {
+		return http.StatusTooManyRequests, "Rate limit exceeded"
+	}
+	// Recorded once a minute at most, not on every request.
+	if now.Unix()-apiKey.LastUsed >= 60 {
+		if err := s.db.TouchApiKey(apiKey.Id, now.Unix()); err != nil {
+			log.Errorf("Failed to record use of API key %v: %v", apiKey.Id, err)
+		}
+	}
+	r.Header.Set("login", "key:"+apiKey.Name)
+	r.Header.Set("role", apiKey.Role)
+	return http.StatusOK, ""
+}
+
+// ApiKeysIndex lists the API keys, without the keys themselves.
+func (s *ApiServer) ApiKeysIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	keys, err := s.db.GetApiKeys()
+	if err != nil {
+		log.Errorf("Failed to read API keys: %v", err)
+		s.ErrorWrite(w, "Failed to read API keys")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": keys,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+type apiKeyRequest struct {
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	RateLimit int    `json:"rateLimit"`
+}
+
+// ApiKeyCreateIndex creates an API key with a role. The key is only returned here, the pool keeps
+// its hash.
+func (s *ApiServer) ApiKeyCreateIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	var req apiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Warnf("failed to Decode: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !util.IsValidUsername(req.Name) || !validRole(req.Role) || req.RateLimit < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		log.Errorf("Failed to generate API key: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	key := apiKeyPrefix + hex.EncodeToString(random)
+	apiKey := &mysql.ApiKey{
+		Name:      req.Name,
+		Prefix:    key[:apiKeyShownSize],
+		Role:      req.Role,
+		RateLimit: req.RateLimit,
+		CreatedBy: r.Header.Get("login"),
+		CreatedAt: time.Now().Unix(),
+	}
+	id, err := s.db.CreateApiKey(apiKey, hashApiKey(key))
+	if err != nil {
+		log.Errorf("Failed to create API key: %v", err)
+		s.ErrorWrite(w, "Failed to create API key")
+		return
+	}
+	apiKey.Id = id
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"key":    key,
+		"apiKey": apiKey,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// ApiKeyRevokeIndex disables an API key for good.
+func (s *ApiServer) ApiKeyRevokeIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	ok, err := s.db.RevokeApiKey(id)
+	if err != nil {
+		log.Errorf("Failed to revoke API key %v: %v", id, err)
+	}
+	if err != nil || !ok {
+		s.ErrorWrite(w, fmt.Sprintf("API key %v is not active", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// ChangeRoleIndex sets the role of an admin account, it applies to the tokens signed in after.
+func (s *ApiServer) ChangeRoleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	var user User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		log.Warnf("failed to Decode: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !util.IsValidUsername(user.Username) || !validRole(user.Role) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	role, err := s.db.GetAccountRole(user.Username)
+	if err == nil && len(role) == 0 {
+		s.ErrorWrite(w, "Account not found")
+		return
+	}
+	if err == nil {
+		err = s.db.ChangeAccountRole(user.Username, user.Role)
+	}
+	if err != nil {
+		log.Errorf("Failed to change role of %v: %v", user.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string{
+		"msg": "success",
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
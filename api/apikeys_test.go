@@ -0,0 +1,45 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyLimiterAllow(t *testing.T) {
+	l := &keyLimiter{buckets: make(map[int64]*requestBucket)}
+	now := time.Unix(1600000000, 0)
+
+	for i := 0; i < 3; i++ {
+		if !l.allow(1, 3, now) {
+			t.Fatalf("Must allow %v requests of 3 a minute", i+1)
+		}
+	}
+	if l.allow(1, 3, now) {
+		t.Error("Must refuse a request over the limit")
+	}
+	if !l.allow(2, 3, now) {
+		t.Error("Must limit every key on its own")
+	}
+
+	// One token back every 20 seconds
+	if l.allow(1, 3, now.Add(10*time.Second)) {
+		t.Error("Must refuse a request before a token is back")
+	}
+	if !l.allow(1, 3, now.Add(20*time.Second)) {
+		t.Error("Must allow a request once a token is back")
+	}
+	if l.allow(1, 3, now.Add(20*time.Second)) {
+		t.Error("Must take the token back")
+	}
+
+	// Refilled up to the limit, not beyond, after a quiet period
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !l.allow(1, 3, later) {
+			t.Fatalf("Must allow %v requests after a quiet period", i+1)
+		}
+	}
+	if l.allow(1, 3, later) {
+		t.Error("Must not save up more than a minute of requests")
+	}
+}
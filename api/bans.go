@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+	"github.com/gorilla/mux"
+)
+
+// BansIndex lists the temporary bans of IPs and logins applied by the proxies, latest ending first.
+func (s *ApiServer) BansIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	bans, err := s.backend.GetBans(util.MakeTimestamp() / 1000)
+	if err != nil {
+		log.Errorf("Failed to fetch bans: %v", err)
+		s.ErrorWrite(w, "Failed to fetch bans")
+		return
+	}
+	if bans == nil {
+		bans = []*redis.Ban{}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until > bans[j].Until })
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"bans": bans,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// BanLiftIndex ends a ban before its time on every proxy, e.g. once the miner fixed its rig.
+func (s *ApiServer) BanLiftIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	kind := mux.Vars(r)["kind"]
+	value := mux.Vars(r)["value"]
+
+	lifted, err := s.backend.LiftBan(kind, value)
+	if err != nil {
+		log.Errorf("Failed to lift the ban of %v %v: %v", kind, value, err)
+		s.ErrorWrite(w, "Failed to lift ban")
+		return
+	}
+	if !lifted {
+		s.ErrorWrite(w, "Ban not found")
+		return
+	}
+	_, err = s.backend.Publish(redis.ChannelProxy, redis.OpcodeBanLift, redis.BanPayload(kind, value), redis.ChannelApi)
+	if err != nil {
+		log.Errorf("Failed to publish the lift of the ban of %v %v: %v", kind, value, err)
+	}
+	var login string
+	if kind == redis.BanKindLogin {
+		login = value
+	}
+	s.logs.InsertLog(fmt.Sprintf("BAN LIFTED %v %v by %v", kind, value, r.Header.Get("login")), plogger.LogTypeSystem, plogger.LogSubTypeBan, 0, 0, login, "")
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
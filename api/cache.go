@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// statsVersionKey keeps the time the stats were collected, in milliseconds, the version of every
+// reply derived from them.
+const statsVersionKey = "version"
+
+// notModified sets the validators of a reply derived from aggregates of version, the time they were
+// updated in milliseconds. The parts tell apart replies of the same version, e.g. the login. It writes
+// 304 and returns true if the client has the reply already.
+//
+// Replies also carry the time they were served, which doesn't make them differ, so the ETag is weak.
+func notModified(w http.ResponseWriter, r *http.Request, version int64, parts ...interface{}) bool {
+	if version <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	fmt.Fprint(h, r.URL.Path, "|", w.Header().Get("Content-Language"), "|", version)
+	for _, p := range parts {
+		fmt.Fprint(h, "|", p)
+	}
+	etag := fmt.Sprintf("W/\"%x\"", h.Sum64())
+	modified := time.Unix(version/1000, 0).UTC()
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	w.Header().Add("Vary", "Accept-Language")
+
+	if match := r.Header.Get("If-None-Match"); len(match) > 0 {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || modified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches tells whether an If-None-Match list has etag, compared weakly.
+func etagMatches(list, etag string) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// statsVersion returns the version of collected stats, 0 before the first collection.
+func statsVersion(stats map[string]interface{}) int64 {
+	version, _ := stats[statsVersionKey].(int64)
+	return version
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type HashrateChartsConfig struct {
+	Enabled bool `json:"enabled"`
+	// How long the buckets of each width, 1m, 1h or 1d, are kept. Forever without one
+	Retention map[string]string `json:"retention"`
+	// Points a chart returns at most
+	MaxPoints int64 `json:"maxPoints"`
+}
+
+// Range of a chart request without from.
+const defaultChartRange = 24 * 3600
+
+// initHashrateCharts sets the defaults of the hashrate rollups.
+func (s *ApiServer) initHashrateCharts() {
+	if s.config.HashrateCharts == nil {
+		s.config.HashrateCharts = &HashrateChartsConfig{}
+	}
+	cfg := s.config.HashrateCharts
+	if cfg.Retention == nil {
+		cfg.Retention = map[string]string{"1m": "48h", "1h": "2160h"}
+	}
+	for bucket, retention := range cfg.Retention {
+		if _, ok := mysql.ChartBuckets[bucket]; !ok {
+			log.Fatalf("Unknown hashrate chart bucket %v", bucket)
+		}
+		if len(retention) > 0 {
+			util.MustParseDuration(retention)
+		}
+	}
+	if cfg.MaxPoints <= 0 {
+		cfg.MaxPoints = 2000
+	}
+}
+
+// retention returns for how many seconds the buckets of a width are kept, 0 when forever.
+func (cfg *HashrateChartsConfig) retention(bucket string) int64 {
+	if len(cfg.Retention[bucket]) == 0 {
+		return 0
+	}
+	return int64(util.MustParseDuration(cfg.Retention[bucket]) / time.Second)
+}
+
+// addHashrateSample adds the hashrate of login, the pool's when it is empty, to the chart buckets.
+func (s *ApiServer) addHashrateSample(login string, ts int64, hashrate int64) {
+	if !s.config.HashrateCharts.Enabled {
+		return
+	}
+	if err := s.db.AddHashrateSample(login, ts, hashrate); err != nil {
+		log.Errorf("Failed to add hashrate sample of %q: %v", login, err)
+	}
+}
+
+// pruneHashrateCharts drops the buckets older than their retention.
+func (s *ApiServer) pruneHashrateCharts(ts int64) {
+	cfg := s.config.HashrateCharts
+	if !cfg.Enabled {
+		return
+	}
+	for bucket, width := range mysql.ChartBuckets {
+		retention := cfg.retention(bucket)
+		if retention == 0 {
+			continue
+		}
+		n, err := s.db.DeleteHashrateRollups(width, ts-retention)
+		if err != nil {
+			log.Errorf("Failed to delete %v hashrate buckets: %v", bucket, err)
+			continue
+		}
+		if n > 0 {
+			log.Infof("Deleted %v hashrate buckets of %v older than %v", n, bucket, cfg.Retention[bucket])
+		}
+	}
+}
+
+// chartBucket returns the width of ?bucket=, or the narrowest one still kept at from that fits the range
+// in maxPoints without one. It returns false when the bucket is unknown or too narrow for the range.
+func (cfg *HashrateChartsConfig) chartBucket(bucket string, from, to, now int64) (int64, bool) {
+	if len(bucket) > 0 {
+		width, ok := mysql.ChartBuckets[bucket]
+		return width, ok && (to-from)/width <= cfg.MaxPoints
+	}
+	names := make([]string, 0, len(mysql.ChartBuckets))
+	for name := range mysql.ChartBuckets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return mysql.ChartBuckets[names[i]] < mysql.ChartBuckets[names[j]] })
+	for _, name := range names {
+		width := mysql.ChartBuckets[name]
+		if retention := cfg.retention(name); retention > 0 && from < now-retention {
+			continue
+		}
+		if (to-from)/width <= cfg.MaxPoints {
+			return width, true
+		}
+	}
+	widest := mysql.ChartBuckets[names[len(names)-1]]
+	return widest, (to-from)/widest <= cfg.MaxPoints
+}
+
+// PoolHashrateChartIndex returns the average pool hashrate per bucket, see hashrateChart.
+func (s *ApiServer) PoolHashrateChartIndex(w http.ResponseWriter, r *http.Request) {
+	s.hashrateChart(w, r, "")
+}
+
+// MinerHashrateChartIndex returns the average hashrate of a miner per bucket, see hashrateChart.
+func (s *ApiServer) MinerHashrateChartIndex(w http.ResponseWriter, r *http.Request) {
+	s.hashrateChart(w, r, strings.ToLower(mux.Vars(r)["login"]))
+}
+
+// hashrateChart writes the buckets of login between ?from= and ?to=, unix times defaulting to the last
+// 24 hours, in ?bucket= or the narrowest bucket that fits.
+func (s *ApiServer) hashrateChart(w http.ResponseWriter, r *http.Request, login string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	cfg := s.config.HashrateCharts
+	if !cfg.Enabled {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	now := util.MakeTimestamp() / 1000
+	query := r.URL.Query()
+	to, _ := strconv.ParseInt(query.Get("to"), 10, 64)
+	if to <= 0 {
+		to = now
+	}
+	from, _ := strconv.ParseInt(query.Get("from"), 10, 64)
+	if from <= 0 {
+		from = to - defaultChartRange
+	}
+	if from >= to {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	width, ok := cfg.chartBucket(query.Get("bucket"), from, to, now)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	points, err := s.db.GetHashrateChart(login, width, from, to)
+	if err != nil {
+		log.Errorf("Failed to load hashrate chart of %q: %v", login, err)
+		s.ErrorWrite(w, "Failed to load history")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"bucket": width,
+		"from":   from,
+		"to":     to,
+		"points": points,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type CompressionConfig struct {
+	Enabled bool `json:"enabled"`
+	// 1 (fastest) to 9 (smallest), 0 for the default
+	Level int `json:"level"`
+	// Replies shorter than this are sent as they are, in bytes
+	MinSize int `json:"minSize"`
+}
+
+// compressHandler compresses the replies of clients that accept gzip or deflate. A reply is held back
+// until it reaches minSize, so small ones are sent uncompressed.
+func compressHandler(cfg *CompressionConfig, next http.Handler) http.Handler {
+	level := cfg.Level
+	if level < flate.BestSpeed || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+	minSize := cfg.MinSize
+	if minSize <= 0 {
+		minSize = 1024
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		// WebSockets hijack the connection
+		if len(encoding) == 0 || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, level: level, minSize: minSize}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks gzip, else deflate, from an Accept-Encoding header, or returns "".
+func acceptedEncoding(header string) string {
+	var deflate bool
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(fields) > 1 && strings.Replace(strings.TrimSpace(fields[1]), " ", "", -1) == "q=0" {
+			continue
+		}
+		switch name {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	level    int
+	minSize  int
+
+	status int
+	buf    []byte
+	// Set once the headers are sent, nil if the reply is sent as it is
+	writer  io.WriteCloser
+	started bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.started {
+		return cw.write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *compressWriter) write(p []byte) (int, error) {
+	if cw.writer != nil {
+		return cw.writer.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// start sends the headers, and the reply held back so far.
+func (cw *compressWriter) start(compress bool) error {
+	cw.started = true
+	h := cw.Header()
+	// Bodiless replies and ones already encoded by the handler
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified || len(h.Get("Content-Encoding")) > 0 {
+		compress = false
+	}
+	if compress {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if cw.encoding == "gzip" {
+			cw.writer, _ = gzip.NewWriterLevel(cw.ResponseWriter, cw.level)
+		} else {
+			cw.writer, _ = flate.NewWriter(cw.ResponseWriter, cw.level)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// Flush sends what was written so far, compressing it whatever its size, for streamed replies.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		return
+	}
+	if !cw.started {
+		cw.start(true)
+	}
+	if f, ok := cw.writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends a reply shorter than minSize as it is, or ends the compressed stream.
+func (cw *compressWriter) Close() error {
+	if !cw.started {
+		if cw.status == 0 {
+			return nil
+		}
+		return cw.start(false)
+	}
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
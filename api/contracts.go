@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+// PayoutContractsIndex lists the payees found to be contracts by the payer, the latest first.
+func (s *ApiServer) PayoutContractsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	contracts, err := s.db.GetPayoutContracts()
+	if err != nil {
+		log.Errorf("Failed to load contract payees: %v", err)
+		s.ErrorWrite(w, "Failed to load contract payees")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"contracts": contracts,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// PayoutContractActionIndex approves the payouts to a contract held for review, from the next payout
+// run, or holds them again.
+func (s *ApiServer) PayoutContractActionIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	address := strings.ToLower(mux.Vars(r)["login"])
+	login := r.Header.Get("login")
+
+	state := mysql.ContractApproved
+	if mux.Vars(r)["action"] == "hold" {
+		state = mysql.ContractHeld
+	}
+	ok, err := s.db.SetPayoutContractState(address, state, login)
+	if err != nil || !ok {
+		s.ErrorWrite(w, "Payee is not a known contract")
+		return
+	}
+	s.logs.InsertLog(fmt.Sprintf("CONTRACT PAYEE %v %v by %v", address, state, login), plogger.LogTypePaymentWork, plogger.LogErrorNothing, 0, 0, address, "")
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+		"state":  state,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// network is the chain head the estimates are computed from, refreshed with the stats.
+type network struct {
+	Height     int64
+	Difficulty *big.Int
+}
+
+// earningsEstimate is what a hashrate is expected to earn in the pool. A miner finds hashrate /
+// difficulty blocks a second, stretched or shortened by the pool luck, each paying the block reward
+// of the schedule after the pool fee.
+type earningsEstimate struct {
+	// In H/s
+	Hashrate   float64 `json:"hashrate"`
+	Height     int64   `json:"height"`
+	Difficulty int64   `json:"difficulty"`
+	// Reward of the next block after the pool fee, in Shannon
+	BlockReward int64   `json:"blockReward"`
+	PoolFee     float64 `json:"poolFee"`
+	// Average shares per difficulty of the recent blocks, above 1 when unlucky
+	Luck         float64 `json:"luck"`
+	BlocksPerDay float64 `json:"blocksPerDay"`
+	// In Shannon
+	EarnPerDay  int64 `json:"earnPerDay"`
+	EarnPerWeek int64 `json:"earnPerWeek"`
+}
+
+// collectNetwork reads the chain head from the unlocker's node, or the one the proxy last stored without it.
+func (s *ApiServer) collectNetwork() (*network, error) {
+	if s.rpc != nil {
+		net, err := s.nodeHead()
+		if err == nil {
+			return net, nil
+		}
+		log.Warnf("Failed to get the chain head from the node, using the last node state: %v", err)
+	}
+	height, err := s.backend.GetNodeHeight(s.config.Name)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := s.backend.GetNodeDifficulty(s.config.Name)
+	if err != nil || diff == nil {
+		return nil, err
+	}
+	return &network{Height: height, Difficulty: diff}, nil
+}
+
+func (s *ApiServer) nodeHead() (*network, error) {
+	block, err := s.rpc.GetPendingBlock()
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("no pending block")
+	}
+	height, err := strconv.ParseInt(strings.Replace(block.Number, "0x", "", -1), 16, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &network{Height: height, Difficulty: util.String2Big(block.Difficulty)}, nil
+}
+
+func (s *ApiServer) estimateEarnings(hashrate float64, net *network, luck float64) *earningsEstimate {
+	reward := new(big.Int).Div(s.forks.ConstReward(net.Height+1), util.Shannon)
+	est := &earningsEstimate{
+		Hashrate:    hashrate,
+		Height:      net.Height,
+		Difficulty:  net.Difficulty.Int64(),
+		BlockReward: int64(float64(reward.Int64()) * (1 - s.config.PoolFee/100)),
+		PoolFee:     s.config.PoolFee,
+		Luck:        luck,
+	}
+	if est.Difficulty <= 0 || luck <= 0 {
+		return est
+	}
+	est.BlocksPerDay = hashrate * 86400 / float64(est.Difficulty) / luck
+	est.EarnPerDay = int64(est.BlocksPerDay * float64(est.BlockReward))
+	est.EarnPerWeek = int64(est.BlocksPerDay * 7 * float64(est.BlockReward))
+	return est
+}
+
+// EstimateIndex returns the expected earnings of ?hashrate=, in H/s, at the current difficulty, pool luck,
+// block reward and pool fee.
+func (s *ApiServer) EstimateIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	hashrate, err := strconv.ParseFloat(r.URL.Query().Get("hashrate"), 64)
+	if err != nil || hashrate < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	net, _ := s.network.Load().(*network)
+	if net == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	luck := 1.0
+	if rates, ok := s.getStats()["payoutRates"].(*payoutRates); ok && rates.Luck > 0 {
+		luck = rates.Luck
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(s.estimateEarnings(hashrate, net, luck))
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+)
+
+// A miner expects hashrate / network difficulty blocks a second, each paying the average reward
+// of the recent pool blocks after the fee, and the luck of those blocks stretches or shortens
+// that. Rewards are paid once they mature, on the next scheduled payout run.
+
+// Recent pool blocks the rates are taken from
+const payoutRateBlocks = 64
+
+// payoutRates are the pool-wide inputs of payout ETAs, refreshed with the stats.
+type payoutRates struct {
+	// Current network difficulty
+	Difficulty int64 `json:"difficulty"`
+	// Average reward of the recent blocks after the pool fee, orphans paying nothing, in Shannon
+	BlockReward float64 `json:"blockReward"`
+	// Average shares per difficulty of the recent blocks, above 1 when unlucky
+	Luck float64 `json:"luck"`
+	// Seconds per chain block between the recent blocks, 0 if unknown
+	BlockTime float64 `json:"blockTime"`
+}
+
+type payoutEta struct {
+	// Expected payout in unix seconds, 0 without an estimate
+	Timestamp int64 `json:"timestamp"`
+	// Shannon to earn before the payout limit, beyond the immature balance
+	Remaining int64 `json:"remaining"`
+	// Payout limit of the account, in Shannon
+	PayoutLimit int64 `json:"payoutLimit"`
+	// Expected earnings at the current hashrate, in Shannon
+	EarnPerDay int64 `json:"earnPerDay"`
+	// Next scheduled payout run in unix seconds, 0 if unknown
+	NextRun int64 `json:"nextRun"`
+	// Why there is no estimate: "noHashrate", "noRates" or "noPayoutRun"
+	Reason string `json:"reason,omitempty"`
+}
+
+func (s *ApiServer) collectPayoutRates() (*payoutRates, error) {
+	diff, err := s.backend.GetNodeDifficulty(s.config.Name)
+	if err != nil || diff == nil {
+		return nil, err
+	}
+	blocks, err := s.db.CollectLuckStats(payoutRateBlocks)
+	if err != nil {
+		return nil, err
+	}
+	return newPayoutRates(diff, blocks, s.config.PoolFee), nil
+}
+
+func newPayoutRates(diff *big.Int, blocks []*types.BlockData, poolFee float64) *payoutRates {
+	rates := &payoutRates{Difficulty: diff.Int64(), Luck: 1}
+	if len(blocks) > payoutRateBlocks {
+		blocks = blocks[:payoutRateBlocks]
+	}
+	var rewards, luck float64
+	var lucky int
+	var newest, oldest *types.BlockData
+	for _, block := range blocks {
+		if reward, ok := new(big.Float).SetString(block.RewardString); ok && !block.Orphan {
+			wei, _ := reward.Float64()
+			rewards += wei / 1e9
+		}
+		if block.Difficulty > 0 && block.TotalShares > 0 {
+			luck += float64(block.TotalShares) / float64(block.Difficulty)
+			lucky++
+		}
+		if newest == nil || block.Height > newest.Height {
+			newest = block
+		}
+		if oldest == nil || block.Height < oldest.Height {
+			oldest = block
+		}
+	}
+	if len(blocks) > 0 {
+		rates.BlockReward = rewards / float64(len(blocks)) * (1 - poolFee/100)
+	}
+	if lucky > 0 {
+		rates.Luck = luck / float64(lucky)
+	}
+	if newest != nil && newest.Height > oldest.Height && newest.Timestamp > oldest.Timestamp {
+		rates.BlockTime = float64(newest.Timestamp-oldest.Timestamp) / float64(newest.Height-oldest.Height)
+	}
+	return rates
+}
+
+// payoutLimit is the limit the payer holds the account to, setPayout kept within the bounds.
+func (s *ApiServer) payoutLimit(setPayout int64) int64 {
+	if setPayout <= 0 {
+		return s.config.Threshold
+	}
+	if setPayout < s.config.MinPayoutLimit {
+		return s.config.MinPayoutLimit
+	}
+	if s.config.MaxPayoutLimit > 0 && setPayout > s.config.MaxPayoutLimit {
+		return s.config.MaxPayoutLimit
+	}
+	return setPayout
+}
+
+// estimatePayout is when the account is expected to be paid, at its current hashrate.
+func (s *ApiServer) estimatePayout(info map[string]interface{}, setPayout, hashrate int64, rates *payoutRates, now time.Time) *payoutEta {
+	balance, immature := statInt64(info, "balance"), statInt64(info, "immature")
+	eta := &payoutEta{PayoutLimit: s.payoutLimit(setPayout)}
+	if remaining := eta.PayoutLimit - balance - immature; remaining > 0 {
+		eta.Remaining = remaining
+	}
+
+	// Shannon a second
+	var rate float64
+	if rates != nil && rates.Difficulty > 0 && rates.Luck > 0 {
+		rate = float64(hashrate) / float64(rates.Difficulty) * rates.BlockReward / rates.Luck
+		eta.EarnPerDay = int64(rate * 86400)
+	}
+
+	var wait time.Duration
+	switch {
+	case balance >= eta.PayoutLimit:
+	case eta.Remaining > 0 && hashrate <= 0:
+		eta.Reason = "noHashrate"
+		return eta
+	case eta.Remaining > 0 && rate <= 0:
+		eta.Reason = "noRates"
+		return eta
+	default:
+		seconds := float64(eta.Remaining) / math.Max(rate, 1e-9)
+		if rates != nil {
+			// The last reward needed still has to mature
+			seconds += float64(s.config.Depth) * rates.BlockTime
+		}
+		if seconds > float64(10*365*24*3600) {
+			eta.Reason = "noRates"
+			return eta
+		}
+		wait = time.Duration(seconds * float64(time.Second))
+	}
+
+	if s.payoutSchedule == nil {
+		eta.Reason = "noPayoutRun"
+		return eta
+	}
+	last, err := s.backend.GetLastPayoutRun()
+	if err != nil {
+		log.Errorf("Failed to get the last payout run: %v", err)
+	}
+	var lastRun time.Time
+	if last > 0 {
+		lastRun = time.Unix(last, 0)
+	}
+	if next := s.payoutSchedule.Next(lastRun, now); !next.IsZero() {
+		eta.NextRun = next.Unix()
+	}
+	run := s.payoutSchedule.Next(lastRun, now.Add(wait))
+	if run.IsZero() {
+		eta.Reason = "noPayoutRun"
+		return eta
+	}
+	eta.Timestamp = run.Unix()
+	return eta
+}
+
+func statInt64(stats map[string]interface{}, key string) int64 {
+	if v, ok := stats[key].(int64); ok {
+		return v
+	}
+	return 0
+}
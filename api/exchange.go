@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type ExchangeConfig struct {
+	// Flag addresses that look like exchange deposit addresses shared by many miners
+	Enabled bool `json:"enabled"`
+	// Workers an address has in the hashrate window at least
+	MinWorkers int `json:"minWorkers"`
+	// Percent of the pool hashrate an address has at least
+	MinHashratePercent float64 `json:"minHashratePercent"`
+}
+
+// Warnings of the account API.
+const (
+	warningExchangeAddress = "exchangeAddress"
+	warningUnnamedWorkers  = "unnamedWorkers"
+)
+
+var memoPattern = regexp.MustCompile("^[0-9a-zA-Z-_:.]{0,64}$")
+
+func (s *ApiServer) initExchange() {
+	if s.config.Exchange == nil {
+		s.config.Exchange = &ExchangeConfig{}
+	}
+	cfg := s.config.Exchange
+	if cfg.MinWorkers <= 0 {
+		cfg.MinWorkers = 50
+	}
+	if cfg.MinHashratePercent <= 0 {
+		cfg.MinHashratePercent = 10
+	}
+}
+
+// detectExchanges flags the miners with many workers and a large share of the pool hashrate.
+// Newly flagged addresses are reported and the proxies reload them.
+func (s *ApiServer) detectExchanges(stats map[string]interface{}) {
+	cfg := s.config.Exchange
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+	miners, _ := stats["miners"].(map[string]redis.Miner)
+	total, _ := stats["hashrate"].(int64)
+	if total <= 0 {
+		return
+	}
+
+	ts := util.MakeTimestamp() / 1000
+	flagged := 0
+	for login, miner := range miners {
+		percent := float64(miner.HR) * 100 / float64(total)
+		if miner.Offline || miner.Workers < cfg.MinWorkers || percent < cfg.MinHashratePercent {
+			continue
+		}
+		ok, err := s.backend.FlagExchangeAddress(login, ts)
+		if err != nil {
+			log.Errorf("Failed to flag exchange address %v: %v", login, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		flagged++
+		key := "Possible exchange deposit address %v: %v workers, %.1f%% of pool hashrate"
+		s.logs.InsertLog(fmt.Sprintf(key, login, miner.Workers, percent), plogger.LogTypeSystem, plogger.LogSubTypeExchange, 0, 0, login, "")
+		if s.alarm != nil {
+			s.alarm.Notify(key, login, miner.Workers, percent)
+		}
+	}
+	if flagged > 0 {
+		s.publishExchanges()
+	}
+}
+
+func (s *ApiServer) publishExchanges() {
+	_, err := s.backend.Publish(redis.ChannelProxy, redis.OpcodeExchange, "", redis.ChannelApi)
+	if err != nil {
+		log.Errorf("Failed to publish exchange addresses: %v", err)
+	}
+}
+
+// accountWarnings returns the warnings of a miner's account for its workers.
+func accountWarnings(exchange bool, workers map[string]redis.Worker) []string {
+	warnings := make([]string, 0)
+	if !exchange {
+		return warnings
+	}
+	warnings = append(warnings, warningExchangeAddress)
+	if w, ok := workers["0"]; ok && !w.Offline {
+		warnings = append(warnings, warningUnnamedWorkers)
+	}
+	return warnings
+}
+
+// ExchangesIndex lists the addresses flagged as exchange deposit addresses.
+func (s *ApiServer) ExchangesIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	addresses, err := s.backend.GetExchangeAddresses()
+	if err != nil {
+		log.Errorf("Failed to fetch exchange addresses: %v", err)
+		s.ErrorWrite(w, "Failed to fetch exchange addresses")
+		return
+	}
+	result := make([]map[string]interface{}, 0, len(addresses))
+	for login, ts := range addresses {
+		result = append(result, map[string]interface{}{
+			"login":     login,
+			"flaggedAt": ts,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i]["flaggedAt"].(int64) > result[j]["flaggedAt"].(int64) })
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"exchanges": result,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// ExchangeActionIndex flags or unflags an address by hand, e.g. a false positive.
+func (s *ApiServer) ExchangeActionIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login := strings.ToLower(mux.Vars(r)["login"])
+	action := mux.Vars(r)["action"]
+
+	var err error
+	if action == "flag" {
+		_, err = s.backend.FlagExchangeAddress(login, util.MakeTimestamp()/1000)
+	} else {
+		err = s.backend.UnflagExchangeAddress(login)
+	}
+	if err != nil {
+		log.Errorf("Failed to %v exchange address %v: %v", action, login, err)
+		s.ErrorWrite(w, "Failed to update exchange address")
+		return
+	}
+	s.publishExchanges()
+	s.logs.InsertLog(fmt.Sprintf("EXCHANGE %v %v by %v", login, action, r.Header.Get("login")), plogger.LogTypeSystem, plogger.LogSubTypeExchange, 0, 0, login, "")
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// PayoutMemoIndex sets the memo passed to the post payout hook with the miner's payouts.
+func (s *ApiServer) PayoutMemoIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login := strings.ToLower(mux.Vars(r)["login"])
+
+	var req struct {
+		Memo string `json:"memo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.WirteResponseData(w, http.StatusBadRequest, "Failed to set payout memo error:%v", err)
+		return
+	}
+	if !memoPattern.MatchString(req.Memo) {
+		s.WirteResponseData(w, http.StatusBadRequest, "Invalid payout memo (%v)", login)
+		return
+	}
+	if !s.db.UpdatePayoutMemo(login, req.Memo) {
+		s.WirteResponseData(w, http.StatusInternalServerError, "Failed to UpdatePayoutMemo (%v)", login)
+		return
+	}
+
+	s.minersMu.Lock()
+	delete(s.miners, login)
+	s.minersMu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"msg": "success",
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
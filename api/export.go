@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+)
+
+// Rows an export reads per query
+const exportPageSize = 1000
+
+// exporter writes the rows of an export as CSV, or as JSON like the history lists, while they are read.
+// A CSV export failing after it started reports the error in the X-Export-Error trailer.
+type exporter struct {
+	w    http.ResponseWriter
+	csv  *csv.Writer
+	json *listStream
+	rows int
+	// Rows between ?from= and ?to=, oldest first
+	filter mysql.HistoryFilter
+}
+
+// newExporter starts an export in the ?format= of the request, csv or json by default. It returns false
+// after replying 400 to another format or an invalid filter.
+func newExporter(w http.ResponseWriter, r *http.Request, name string, header []string) (*exporter, bool) {
+	w.Header().Set("Cache-Control", "no-cache")
+	filter, _, ok := historyFilter(r, nil)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	filter.Ascending = true
+	e := &exporter{w: w, filter: filter}
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		e.json = newListStream(w, name)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		w.Header().Set("Trailer", "X-Export-Error")
+		e.csv = csv.NewWriter(w)
+		e.csv.Write(header)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	return e, true
+}
+
+// add writes a row, v in JSON or record in CSV.
+func (e *exporter) add(v interface{}, record []string) error {
+	if e.json != nil {
+		return e.json.add(v)
+	}
+	e.rows++
+	e.csv.Write(record)
+	if e.rows%historyFlushEvery == 0 {
+		e.csv.Flush()
+		if f, ok := e.w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return e.csv.Error()
+}
+
+// end finishes the export, with the error that stopped it if any.
+func (e *exporter) end(failed string) {
+	if e.json != nil {
+		e.json.end(0, failed)
+		return
+	}
+	e.csv.Flush()
+	if len(failed) > 0 {
+		e.w.Header().Set("X-Export-Error", failed)
+	}
+}
+
+// export reads the pages of an export until one isn't full. Each page returns the cursor of its last row and
+// its number of rows.
+func (s *ApiServer) export(w http.ResponseWriter, r *http.Request, e *exporter, page func(cursor int64, filter mysql.HistoryFilter) (int64, int, error)) {
+	var cursor int64
+	for {
+		last, n, err := page(cursor, e.filter)
+		if err != nil {
+			log.Errorf("Failed to export %v: %v", r.URL.Path, err)
+			e.end(s.localize(w, "Failed to load history"))
+			return
+		}
+		if n < exportPageSize {
+			break
+		}
+		cursor = last
+	}
+	e.end("")
+}
+
+func exportTime(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+// PaymentsExportAccountIndex exports the payouts of a miner between ?from= and ?to=, oldest first, as ?format=csv or json.
+func (s *ApiServer) PaymentsExportAccountIndex(w http.ResponseWriter, r *http.Request) {
+	login := strings.ToLower(mux.Vars(r)["login"])
+	e, ok := newExporter(w, r, "payments", []string{"seq", "time", "login", "tx", "amount", "txFee", "state", "rate", "rateCurrency"})
+	if !ok {
+		return
+	}
+	s.export(w, r, e, func(cursor int64, filter mysql.HistoryFilter) (int64, int, error) {
+		var last int64
+		n := 0
+		err := s.db.StreamPayments(login, cursor, exportPageSize, filter, func(p *mysql.Payment) error {
+			last = p.Seq
+			n++
+			return e.add(p, []string{strconv.FormatInt(p.Seq, 10), exportTime(p.Timestamp), p.Login, p.TxHash, strconv.FormatInt(p.Amount, 10),
+				strconv.FormatInt(p.TxFee, 10), strconv.Itoa(p.State), p.Rate, p.RateCurrency})
+		})
+		return last, n, err
+	})
+}
+
+// CreditsExportIndex exports the block credits of a miner between ?from= and ?to=, oldest first, as ?format=csv or json.
+func (s *ApiServer) CreditsExportIndex(w http.ResponseWriter, r *http.Request) {
+	login := strings.ToLower(mux.Vars(r)["login"])
+	e, ok := newExporter(w, r, "credits", []string{"seq", "time", "reason", "amount", "amountWei", "height", "hash"})
+	if !ok {
+		return
+	}
+	s.export(w, r, e, func(cursor int64, filter mysql.HistoryFilter) (int64, int, error) {
+		var last int64
+		n := 0
+		err := s.db.StreamMinerLedger(login, mysql.LedgerCredit, cursor, exportPageSize, filter, func(entry *mysql.LedgerEntry) error {
+			last = entry.Seq
+			n++
+			return e.add(entry, []string{strconv.FormatInt(entry.Seq, 10), exportTime(entry.Timestamp), entry.Reason,
+				strconv.FormatInt(entry.Amount, 10), entry.AmountWei, strconv.FormatInt(entry.Height, 10), entry.Ref})
+		})
+		return last, n, err
+	})
+}
+
+// FeesExportIndex exports the pool's income of each matured block between ?from= and ?to=, oldest first, as
+// ?format=csv or json.
+func (s *ApiServer) FeesExportIndex(w http.ResponseWriter, r *http.Request) {
+	e, ok := newExporter(w, r, "fees", []string{"seq", "time", "height", "hash", "uncle", "revenue", "poolProfit", "donation", "txFees"})
+	if !ok {
+		return
+	}
+	s.export(w, r, e, func(cursor int64, filter mysql.HistoryFilter) (int64, int, error) {
+		var last int64
+		n := 0
+		err := s.db.StreamBlockProfits(cursor, exportPageSize, filter, func(p *mysql.BlockProfit) error {
+			last = p.Seq
+			n++
+			return e.add(p, []string{strconv.FormatInt(p.Seq, 10), exportTime(p.Timestamp), strconv.FormatInt(p.Height, 10), p.Hash,
+				strconv.FormatBool(p.Uncle), strconv.FormatInt(p.Revenue, 10), strconv.FormatInt(p.PoolProfit, 10),
+				strconv.FormatInt(p.Donation, 10), strconv.FormatInt(p.TxFees, 10)})
+		})
+		return last, n, err
+	})
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// Header the peers authenticate with on /federation
+const federationKeyHeader = "X-Federation-Key"
+
+type FederationConfig struct {
+	// Combine the account view with the sibling pools of the operator
+	Enabled bool `json:"enabled"`
+	// Name of this pool in the combined view, the coin by default
+	Name string `json:"name"`
+	// Key the peers send to read the accounts of this pool, /federation is closed without it
+	ApiKey string `json:"apiKey"`
+	// Sibling pools, each with the apiKey of its own federation config
+	Peers []*FederationPeer `json:"peers"`
+	// Time to wait for a peer. 5s by default
+	Timeout string `json:"timeout"`
+}
+
+type FederationPeer struct {
+	Name string `json:"name"`
+	// Base url of the peer's API, like "https://eu.pool.example"
+	Url    string `json:"url"`
+	ApiKey string `json:"apiKey"`
+}
+
+// federationAccount is the summary of an account on one pool. Amounts are in Shannon of its coin.
+type federationAccount struct {
+	Pool string `json:"pool"`
+	Coin string `json:"coin"`
+	// False when the pool doesn't know the account
+	Found           bool  `json:"found"`
+	Hashrate        int64 `json:"hashrate"`
+	CurrentHashrate int64 `json:"currentHashrate"`
+	WorkersOnline   int64 `json:"workersOnline"`
+	WorkersTotal    int64 `json:"workersTotal"`
+	Balance         int64 `json:"balance"`
+	Immature        int64 `json:"immature"`
+	Pending         int64 `json:"pending"`
+	Paid            int64 `json:"paid"`
+	UpdatedAt       int64 `json:"updatedAt"`
+	// Why the pool couldn't be read, the account is left out of the totals
+	Error string `json:"error,omitempty"`
+}
+
+// federationTotal sums the accounts of one coin, amounts of different coins don't add up.
+type federationTotal struct {
+	Coin            string `json:"coin"`
+	Pools           int    `json:"pools"`
+	Hashrate        int64  `json:"hashrate"`
+	CurrentHashrate int64  `json:"currentHashrate"`
+	WorkersOnline   int64  `json:"workersOnline"`
+	WorkersTotal    int64  `json:"workersTotal"`
+	Balance         int64  `json:"balance"`
+	Immature        int64  `json:"immature"`
+	Pending         int64  `json:"pending"`
+	Paid            int64  `json:"paid"`
+}
+
+type federation struct {
+	config *FederationConfig
+	client *http.Client
+}
+
+func (s *ApiServer) initFederation() {
+	cfg := s.config.Federation
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+	if len(cfg.Name) == 0 {
+		cfg.Name = s.config.Coin
+	}
+	for _, peer := range cfg.Peers {
+		if len(peer.Name) == 0 || len(peer.Url) == 0 {
+			log.Fatalf("Federation peers need a name and a url")
+		}
+		peer.Url = strings.TrimRight(peer.Url, "/")
+	}
+	timeout := 5 * time.Second
+	if len(cfg.Timeout) > 0 {
+		timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	s.federation = &federation{config: cfg, client: &http.Client{Timeout: timeout}}
+	log.Infof("Federation of %v with %v peers", cfg.Name, len(cfg.Peers))
+}
+
+// localAccount summarizes the account on this pool.
+func (s *ApiServer) localAccount(login string) (*federationAccount, error) {
+	account := &federationAccount{Pool: s.federation.config.Name, Coin: s.config.Coin, UpdatedAt: util.MakeTimestamp() / 1000}
+	exist, _, err := s.db.IsMinerExists(login)
+	if err != nil || !exist {
+		return account, err
+	}
+	account.Found = true
+
+	stats, err := s.backend.GetMinerStats(login, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, _ := stats["stats"].(map[string]interface{})
+	account.Balance = statInt64(info, "balance")
+	account.Immature = statInt64(info, "immature")
+	account.Pending = statInt64(info, "pending")
+	account.Paid = statInt64(info, "paid")
+
+	reportedHash, _ := s.backend.GetReportedtHashrate(login)
+	workers, err := s.backend.CollectWorkersAllStats(s.hashrateWindow, s.hashrateLargeWindow, login, reportedHash)
+	if err != nil {
+		return nil, err
+	}
+	account.Hashrate = statInt64(workers, "hashrate")
+	account.CurrentHashrate = statInt64(workers, "currentHashrate")
+	account.WorkersOnline = statInt64(workers, "workersOnline")
+	if total, ok := workers["workersTotal"].(int); ok {
+		account.WorkersTotal = int64(total)
+	}
+	return account, nil
+}
+
+func (f *federation) peerAccount(peer *FederationPeer, login string) (*federationAccount, error) {
+	req, err := http.NewRequest("GET", peer.Url+"/federation/accounts/"+login, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(federationKeyHeader, peer.ApiKey)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %v", resp.Status)
+	}
+	var account federationAccount
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return nil, err
+	}
+	// Named as configured here, whatever the peer calls itself
+	account.Pool = peer.Name
+	return &account, nil
+}
+
+// combinedAccount reads the account from this pool and all peers at once. A peer that fails is
+// listed with its error instead of failing the view.
+func (s *ApiServer) combinedAccount(login string) map[string]interface{} {
+	peers := s.federation.config.Peers
+	accounts := make([]*federationAccount, len(peers)+1)
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer *FederationPeer) {
+			defer wg.Done()
+			account, err := s.federation.peerAccount(peer, login)
+			if err != nil {
+				log.Warnf("Failed to fetch %v from federation peer %v: %v", login, peer.Name, err)
+				account = &federationAccount{Pool: peer.Name, Error: err.Error()}
+			}
+			accounts[i+1] = account
+		}(i, peer)
+	}
+	account, err := s.localAccount(login)
+	if err != nil {
+		log.Errorf("Failed to fetch stats of %v for the federation: %v", login, err)
+		account = &federationAccount{Pool: s.federation.config.Name, Coin: s.config.Coin, Error: err.Error()}
+	}
+	accounts[0] = account
+	wg.Wait()
+
+	totals := make([]*federationTotal, 0)
+	byCoin := make(map[string]*federationTotal)
+	for _, account := range accounts {
+		if !account.Found || len(account.Error) > 0 {
+			continue
+		}
+		total, ok := byCoin[account.Coin]
+		if !ok {
+			total = &federationTotal{Coin: account.Coin}
+			byCoin[account.Coin] = total
+			totals = append(totals, total)
+		}
+		total.Pools++
+		total.Hashrate += account.Hashrate
+		total.CurrentHashrate += account.CurrentHashrate
+		total.WorkersOnline += account.WorkersOnline
+		total.WorkersTotal += account.WorkersTotal
+		total.Balance += account.Balance
+		total.Immature += account.Immature
+		total.Pending += account.Pending
+		total.Paid += account.Paid
+	}
+	return map[string]interface{}{
+		"login":  login,
+		"pools":  accounts,
+		"totals": totals,
+	}
+}
+
+// FederationIndex is the combined view of an account over this pool and its peers.
+func (s *ApiServer) FederationIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if s.federation == nil {
+		s.ErrorWrite(w, "Federation is disabled")
+		return
+	}
+	login := strings.ToLower(mux.Vars(r)["login"])
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(s.combinedAccount(login))
+	if err != nil {
+		log.Errorf("Error serializing API response: %v", err)
+	}
+}
+
+// FederationAccountIndex serves the summary of an account on this pool to the peers.
+func (s *ApiServer) FederationAccountIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if s.federation == nil || len(s.federation.config.ApiKey) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := r.Header.Get(federationKeyHeader)
+	if subtle.ConstantTimeCompare([]byte(key), []byte(s.federation.config.ApiKey)) != 1 {
+		log.Warnf("Federation request for %v with an invalid key from %v", r.URL.Path, r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	login := strings.ToLower(mux.Vars(r)["login"])
+	account, err := s.localAccount(login)
+	if err != nil {
+		log.Errorf("Failed to fetch stats of %v for the federation: %v", login, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(account)
+	if err != nil {
+		log.Errorf("Error serializing API response: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+)
+
+// backendHealth is a round trip to a backend, in milliseconds, or the error it failed with.
+type backendHealth struct {
+	Latency float64 `json:"latency"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func measure(ping func() error) *backendHealth {
+	start := time.Now()
+	err := ping()
+	h := &backendHealth{Latency: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		h.Error = err.Error()
+	}
+	return h
+}
+
+// AdminHealthIndex returns everything an ops dashboard follows: the unlocker and payer state after
+// their last pass, the blocks by stage, the payout queue, how far the pool is behind the node and the
+// latency of Redis and MySQL.
+func (s *ApiServer) AdminHealthIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	reply := map[string]interface{}{
+		"redis": measure(func() error {
+			_, err := s.backend.Check()
+			return err
+		}),
+		"mysql": measure(s.db.Conn.Ping),
+	}
+
+	for _, module := range []string{redis.HealthUnlocker, redis.HealthPayer} {
+		h, err := s.backend.GetModuleHealth(module)
+		if err != nil {
+			log.Errorf("Failed to read the %v health: %v", module, err)
+		}
+		reply[module] = h
+	}
+
+	blocks, err := s.db.CountBlocks()
+	if err != nil {
+		log.Errorf("Failed to count blocks: %v", err)
+		blocks = &mysql.BlockCounts{}
+	}
+	reply["blocks"] = blocks
+	pending, err := s.db.CountPendingPayments()
+	if err != nil {
+		log.Errorf("Failed to count pending payments: %v", err)
+	}
+	reply["pendingPayments"] = pending
+
+	poolHeight, err := s.backend.GetNodeHeight(s.config.Name)
+	if err != nil {
+		log.Errorf("Failed to read the pool height: %v", err)
+	}
+	reply["poolHeight"] = poolHeight
+	if s.rpc != nil {
+		if net, err := s.nodeHead(); err != nil {
+			log.Errorf("Failed to get the chain head from the node: %v", err)
+		} else {
+			reply["nodeHeight"] = net.Height
+			reply["heightLag"] = net.Height - poolHeight
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(reply)
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
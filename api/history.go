@@ -0,0 +1,273 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type HistoryConfig struct {
+	// Items per page when the request has no limit
+	PageSize int `json:"pageSize"`
+	// Largest limit a request may ask for
+	MaxPageSize int `json:"maxPageSize"`
+}
+
+// Items written between flushes of a streamed list
+const historyFlushEvery = 200
+
+// pageLimit returns the limit of a list request, bounded by the config.
+func (s *ApiServer) pageLimit(r *http.Request) int {
+	pageSize, maxPageSize := 500, 10000
+	if cfg := s.config.History; cfg != nil {
+		if cfg.PageSize > 0 {
+			pageSize = cfg.PageSize
+		}
+		if cfg.MaxPageSize > 0 {
+			maxPageSize = cfg.MaxPageSize
+		}
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = pageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit
+}
+
+// historyFilter reads the filter of a list request: ?status= with names of statuses, comma separated,
+// ?from= and ?to= in unix time and ?order=asc for the oldest first. It returns the cursor, ?after= in
+// ascending order and ?before= otherwise, and false when a status isn't one of statuses.
+func historyFilter(r *http.Request, statuses map[string][]int) (mysql.HistoryFilter, int64, bool) {
+	query := r.URL.Query()
+	var filter mysql.HistoryFilter
+	for _, status := range strings.Split(query.Get("status"), ",") {
+		status = strings.ToLower(strings.TrimSpace(status))
+		if len(status) == 0 {
+			continue
+		}
+		states, ok := statuses[status]
+		if !ok {
+			return filter, 0, false
+		}
+		filter.States = append(filter.States, states...)
+	}
+	filter.From, _ = strconv.ParseInt(query.Get("from"), 10, 64)
+	filter.To, _ = strconv.ParseInt(query.Get("to"), 10, 64)
+	filter.Ascending = strings.EqualFold(query.Get("order"), "asc")
+	cursor := "before"
+	if filter.Ascending {
+		cursor = "after"
+	}
+	n, _ := strconv.ParseInt(query.Get(cursor), 10, 64)
+	return filter, n, true
+}
+
+// listStream writes {"<name>": [...], "next": <cursor>} while the items are read, flushing every
+// historyFlushEvery items, so a long list is sent in chunks instead of built in memory first.
+type listStream struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	buf     bytes.Buffer
+	count   int
+	started bool
+}
+
+func newListStream(w http.ResponseWriter, name string) *listStream {
+	ls := &listStream{w: w}
+	ls.enc = json.NewEncoder(&ls.buf)
+	ls.buf.WriteString(`{"`)
+	ls.buf.WriteString(name)
+	ls.buf.WriteString(`":[`)
+	return ls
+}
+
+func (ls *listStream) add(v interface{}) error {
+	if ls.count > 0 {
+		ls.buf.WriteByte(',')
+	}
+	if err := ls.enc.Encode(v); err != nil {
+		return err
+	}
+	ls.count++
+	if ls.count%historyFlushEvery == 0 {
+		return ls.flush()
+	}
+	return nil
+}
+
+func (ls *listStream) flush() error {
+	if !ls.started {
+		ls.started = true
+		ls.w.WriteHeader(http.StatusOK)
+	}
+	_, err := ls.w.Write(ls.buf.Bytes())
+	ls.buf.Reset()
+	if f, ok := ls.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return err
+}
+
+// end closes the list with the cursor of the next page, 0 when this one wasn't full. After a failure,
+// the list is closed with the error instead.
+func (ls *listStream) end(next int64, failed string) {
+	ls.buf.WriteString(`],"next":`)
+	ls.buf.WriteString(strconv.FormatInt(next, 10))
+	if len(failed) > 0 {
+		ls.buf.WriteString(`,"error":`)
+		msg, _ := json.Marshal(failed)
+		ls.buf.Write(msg)
+	}
+	ls.buf.WriteString("}\n")
+	ls.flush()
+}
+
+// BlocksHistoryIndex lists matured and orphaned blocks, newest first, filtered as historyFilter reads. The
+// next pages are asked with ?before=<next of the previous page>, ?after= in ascending order.
+func (s *ApiServer) BlocksHistoryIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	filter, cursor, ok := historyFilter(r, mysql.BlockStatuses)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit := s.pageLimit(r)
+
+	ls := newListStream(w, "blocks")
+	var last int64
+	err := s.db.StreamBlockHistory(cursor, limit, filter, func(block *types.BlockData) error {
+		last = block.Height
+		return ls.add(block)
+	})
+	if err != nil {
+		log.Errorf("Failed to stream blocks history: %v", err)
+		if !ls.started {
+			s.ErrorWrite(w, "Failed to load history")
+			return
+		}
+		ls.end(0, s.localize(w, "Failed to load history"))
+		return
+	}
+	var next int64
+	if ls.count == limit {
+		next = last
+	}
+	ls.end(next, "")
+}
+
+// BlockProfitsIndex lists how the revenue of each matured block was split, newest first, filtered by ?from=,
+// ?to= and ?order= as historyFilter reads. The next pages are asked with ?before=<next of the previous page>,
+// ?after= in ascending order.
+func (s *ApiServer) BlockProfitsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	filter, cursor, ok := historyFilter(r, nil)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit := s.pageLimit(r)
+
+	ls := newListStream(w, "profits")
+	var last int64
+	err := s.db.StreamBlockProfits(cursor, limit, filter, func(p *mysql.BlockProfit) error {
+		last = p.Seq
+		return ls.add(p)
+	})
+	if err != nil {
+		log.Errorf("Failed to stream block profits: %v", err)
+		if !ls.started {
+			s.ErrorWrite(w, "Failed to load history")
+			return
+		}
+		ls.end(0, s.localize(w, "Failed to load history"))
+		return
+	}
+	var next int64
+	if ls.count == limit {
+		next = last
+	}
+	ls.end(next, "")
+}
+
+// BlockDistributionIndex returns how the shares of the rounds matured at a height were distributed:
+// the miner count, the Gini coefficient, the fraction of the 10 largest miners and a histogram.
+func (s *ApiServer) BlockDistributionIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	height, _ := strconv.ParseInt(mux.Vars(r)["height"], 10, 64)
+	distributions, err := s.db.GetRoundDistributions(height)
+	if err != nil {
+		log.Errorf("Failed to load share distribution at %v: %v", height, err)
+		s.ErrorWrite(w, "Failed to load history")
+		return
+	}
+	if len(distributions) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"rounds": distributions,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// PaymentsExportIndex lists payouts, newest first, only the ones of ?login= if it is set, filtered as
+// historyFilter reads. The next pages are asked with ?before=<next of the previous page>, ?after= in
+// ascending order.
+func (s *ApiServer) PaymentsExportIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login := strings.ToLower(r.URL.Query().Get("login"))
+	if len(login) > 0 && !util.IsValidHexAddress(login) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	filter, cursor, ok := historyFilter(r, mysql.PaymentStatuses)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit := s.pageLimit(r)
+
+	ls := newListStream(w, "payments")
+	var last int64
+	err := s.db.StreamPayments(login, cursor, limit, filter, func(p *mysql.Payment) error {
+		last = p.Seq
+		return ls.add(p)
+	})
+	if err != nil {
+		log.Errorf("Failed to stream payments: %v", err)
+		if !ls.started {
+			s.ErrorWrite(w, "Failed to load history")
+			return
+		}
+		ls.end(0, s.localize(w, "Failed to load history"))
+		return
+	}
+	var next int64
+	if ls.count == limit {
+		next = last
+	}
+	ls.end(next, "")
+}
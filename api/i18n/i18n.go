@@ -0,0 +1,185 @@
+// Package i18n translates user-visible API and notification strings.
+// Messages are keyed by their English text, so an untranslated key falls back to English as is.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DefaultLocale = "en"
+
+type Config struct {
+	DefaultLocale string `json:"defaultLocale"`
+	// Directory of <locale>.json files, each a map of English key to translation.
+	// Files add locales or override built-in messages.
+	Dir string `json:"dir"`
+}
+
+type Catalog struct {
+	defaultLocale string
+	messages      map[string]map[string]string
+}
+
+func New(cfg *Config) (*Catalog, error) {
+	c := &Catalog{
+		defaultLocale: DefaultLocale,
+		messages:      make(map[string]map[string]string),
+	}
+	for locale, messages := range builtin {
+		c.add(locale, messages)
+	}
+	if cfg == nil {
+		return c, nil
+	}
+
+	if len(cfg.Dir) > 0 {
+		files, err := filepath.Glob(filepath.Join(cfg.Dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			var messages map[string]string
+			err = json.Unmarshal(data, &messages)
+			if err != nil {
+				return nil, fmt.Errorf("invalid locale file %v: %v", file, err)
+			}
+			c.add(strings.TrimSuffix(filepath.Base(file), ".json"), messages)
+		}
+	}
+
+	if len(cfg.DefaultLocale) > 0 {
+		locale := strings.ToLower(cfg.DefaultLocale)
+		if _, ok := c.messages[locale]; !ok {
+			return nil, fmt.Errorf("unknown default locale %v", cfg.DefaultLocale)
+		}
+		c.defaultLocale = locale
+	}
+	return c, nil
+}
+
+func (c *Catalog) add(locale string, messages map[string]string) {
+	locale = strings.ToLower(locale)
+	m, ok := c.messages[locale]
+	if !ok {
+		m = make(map[string]string)
+		c.messages[locale] = m
+	}
+	for key, value := range messages {
+		m[key] = value
+	}
+}
+
+func (c *Catalog) Default() string {
+	return c.defaultLocale
+}
+
+func (c *Catalog) Locales() []string {
+	locales := make([]string, 0, len(c.messages))
+	for locale := range c.messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Negotiate picks the locale of a request from the "lang" query parameter, then Accept-Language.
+func (c *Catalog) Negotiate(r *http.Request) string {
+	if locale, ok := c.Match(r.URL.Query().Get("lang")); ok {
+		return locale
+	}
+
+	type tag struct {
+		name string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if len(fields[0]) == 0 {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{name: fields[0], q: q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if locale, ok := c.Match(t.name); ok {
+			return locale
+		}
+	}
+	return c.defaultLocale
+}
+
+// Match finds a known locale for a language tag, "ko-KR" matches "ko".
+func (c *Catalog) Match(tag string) (string, bool) {
+	tag = strings.ToLower(strings.Replace(strings.TrimSpace(tag), "_", "-", -1))
+	if len(tag) == 0 {
+		return "", false
+	}
+	if _, ok := c.messages[tag]; ok {
+		return tag, true
+	}
+	if i := strings.Index(tag, "-"); i > 0 {
+		if _, ok := c.messages[tag[:i]]; ok {
+			return tag[:i], true
+		}
+	}
+	return "", false
+}
+
+// Translate formats the message for key in locale, falling back to the default locale and then the key.
+func (c *Catalog) Translate(locale, key string, v ...interface{}) string {
+	format := c.lookup(locale, key)
+	if len(v) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, v...)
+}
+
+func (c *Catalog) lookup(locale, key string) string {
+	if m, ok := c.messages[locale]; ok {
+		if s, ok := m[key]; ok {
+			return s
+		}
+	}
+	if m, ok := c.messages[c.defaultLocale]; ok {
+		if s, ok := m[key]; ok {
+			return s
+		}
+	}
+	return key
+}
+
+// Messages returns every message of locale, completed from the default locale.
+func (c *Catalog) Messages(locale string) map[string]string {
+	result := make(map[string]string)
+	for key, value := range c.messages[c.defaultLocale] {
+		result[key] = value
+	}
+	for key, value := range c.messages[locale] {
+		result[key] = value
+	}
+	return result
+}
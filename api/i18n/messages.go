@@ -0,0 +1,182 @@
+package i18n
+
+// Built-in messages. The English table lists every key, other locales may leave keys out.
+var builtin = map[string]map[string]string{
+	"en": {
+		// Status names
+		"success":   "Success",
+		"failed":    "Failed",
+		"ok":        "OK",
+		"candidate": "Candidate",
+		"immature":  "Immature",
+		"matured":   "Matured",
+		"orphan":    "Orphan",
+		"duplicate": "Duplicate",
+		"pending":   "Pending",
+		"confirmed": "Confirmed",
+
+		// Units
+		"H/s":     "H/s",
+		"Shannon": "Shannon",
+		"Wei":     "Wei",
+
+		// API errors
+		"unauthorized: non cookie":        "Unauthorized: no access token",
+		"unauthorized: nothing access":    "Unauthorized: no access in token",
+		"unauthorized: non argument":      "Unauthorized: token is not valid for this request",
+		"unauthorized: diff argument":     "Unauthorized: token belongs to another account",
+		"unauthorized: Invalid Access":    "Unauthorized: access denied",
+		"unauthorized: Invalid Claims":    "Unauthorized: invalid token claims",
+		"unauthorized: Invalid login":     "Unauthorized: unknown login",
+		"unauthorized: Invalid sign":      "Unauthorized: token has expired or was revoked",
+		"unauthorized: no challenge":      "Unauthorized: no challenge to answer, ask for a new one",
+		"unauthorized: Invalid signature": "Unauthorized: the challenge wasn't signed by this address",
+		"nothing page URI":                "Page not found",
+		"Exceeding max dev count":         "Too many devices registered",
+		"Failed to send to proxy server":  "Failed to send to the proxy server",
+		"Failed to send to payout server": "No payout module is running",
+		"No process received the reload":  "No pool process is listening for reloads",
+		"Failed to fetch bans":            "Failed to fetch bans",
+		"Failed to lift ban":              "Failed to lift the ban",
+		"Ban not found":                   "No running ban of this IP or login",
+
+		"Failed to load payout reports":                                             "Failed to load payout reports",
+		"Payout report is not waiting for approval":                                 "Payout report is not waiting for approval",
+		"Unknown feature:%v":                                                        "Unknown feature: %v",
+		"Failed to update feature":                                                  "Failed to update the feature flag",
+		"Failed to report Redis memory usage":                                       "Failed to report Redis memory usage",
+		"Failed to fetch worker stats":                                              "Failed to fetch worker stats",
+		"Redis memory at %.1f%% of maxmemory, largest: %v":                          "Redis memory at %.1f%% of maxmemory, largest key family: %v",
+		"Invalid correction file":                                                   "Invalid correction file",
+		"Correction file already imported":                                          "A correction file with this key was already imported",
+		"Failed to load compensations":                                              "Failed to load compensations",
+		"Failed to load ledger":                                                     "Failed to load the ledger",
+		"Failed to load history":                                                    "Failed to load the history",
+		"Compensation is not waiting for approval":                                  "Compensation is not waiting for approval",
+		"Compensation needs a second approver":                                      "A compensation must be approved by someone other than who created it",
+		"Invalid adjustment":                                                        "Invalid balance adjustment",
+		"Failed to store adjustment":                                                "Failed to store the balance adjustment",
+		"Failed to fetch exchange addresses":                                        "Failed to fetch exchange addresses",
+		"Failed to update exchange address":                                         "Failed to update the exchange address",
+		"Withholding detection is disabled":                                         "Share withholding detection is disabled",
+		"Possible exchange deposit address %v: %v workers, %.1f%% of pool hashrate": "Possible exchange deposit address %v: %v workers, %.1f%% of pool hashrate",
+		"exchangeAddress":                                                           "This looks like an exchange deposit address shared by many miners. Name your workers to tell them apart, and set a payout memo if your exchange needs one.",
+		"unnamedWorkers":                                                            "Some workers of this address have no name.",
+
+		"Failed to fetch stats from backend: %v":                  "Failed to fetch stats: %v",
+		"non-existent minor:%v":                                   "Unknown miner: %v",
+		"Failed to no minor information: %v":                      "Failed to get miner information: %v",
+		"Failed to set payout value error:%v":                     "Invalid payout threshold: %v",
+		"Failed to UpdatePayoutLimit:payout out of range(min:%v)": "Payout threshold must be at least %v Shannon",
+		"Failed to UpdatePayoutLimit:payout out of range(max:%v)": "Payout threshold must be at most %v Shannon",
+		"Failed to UpdatePayoutLimit (%v)":                        "Failed to update the payout threshold of %v",
+		"Failed to set payout memo error:%v":                      "Failed to set the payout memo: %v",
+		"Invalid payout memo (%v)":                                "Invalid payout memo of %v, use up to 64 letters, digits and -_:.",
+		"Failed to UpdatePayoutMemo (%v)":                         "Failed to update the payout memo of %v",
+
+		"Invalid login (%v)":                        "Invalid address %v",
+		"Failed to create challenge":                "Failed to create a sign-in challenge",
+		"Failed to sign in":                         "Failed to sign in",
+		"Failed to load settings":                   "Failed to load the settings",
+		"Failed to set notification email error:%v": "Failed to set the notification email: %v",
+		"Invalid notification email (%v)":           "Invalid notification email of %v",
+		"Failed to UpdateNotifyEmail (%v)":          "Failed to update the notification email of %v",
+		"Failed to set notifications error:%v":      "Failed to set the notifications: %v",
+		"Failed to UpdateNotifyOptions (%v)":        "Failed to update the notifications of %v",
+
+		// Notifications
+		"It's work time HUMAN!!!!! (%v)":            "It's work time HUMAN!!!!! (%v)",
+		"occurrence of abnormal system: (%v)%v[%v]": "occurrence of abnormal system: (%v)%v[%v]",
+		"Pool hashrate %v: %+.1f%% (%v -> %v H/s)":  "Pool hashrate %v: %+.1f%% (%v -> %v H/s)",
+		"spike": "Spike",
+		"drop":  "Drop",
+	},
+	"ko": {
+		"success":   "성공",
+		"failed":    "실패",
+		"ok":        "정상",
+		"candidate": "후보",
+		"immature":  "미성숙",
+		"matured":   "성숙",
+		"orphan":    "고아",
+		"duplicate": "중복",
+		"pending":   "대기",
+		"confirmed": "확인됨",
+
+		"Shannon": "섀넌",
+
+		"unauthorized: non cookie":        "인증 실패: 액세스 토큰이 없습니다",
+		"unauthorized: nothing access":    "인증 실패: 토큰에 접근 권한이 없습니다",
+		"unauthorized: non argument":      "인증 실패: 이 요청에 사용할 수 없는 토큰입니다",
+		"unauthorized: diff argument":     "인증 실패: 다른 계정의 토큰입니다",
+		"unauthorized: Invalid Access":    "인증 실패: 접근이 거부되었습니다",
+		"unauthorized: Invalid Claims":    "인증 실패: 잘못된 토큰입니다",
+		"unauthorized: Invalid login":     "인증 실패: 알 수 없는 로그인입니다",
+		"unauthorized: Invalid sign":      "인증 실패: 토큰이 만료되었거나 취소되었습니다",
+		"unauthorized: no challenge":      "인증 실패: 응답할 챌린지가 없습니다. 새로 요청하세요",
+		"unauthorized: Invalid signature": "인증 실패: 이 주소로 서명된 챌린지가 아닙니다",
+		"nothing page URI":                "페이지를 찾을 수 없습니다",
+		"Exceeding max dev count":         "등록 가능한 장치 수를 초과했습니다",
+		"Failed to send to proxy server":  "프록시 서버로 전송하지 못했습니다",
+		"Failed to send to payout server": "실행 중인 지급 모듈이 없습니다",
+		"No process received the reload":  "설정을 다시 읽을 풀 프로세스가 없습니다",
+		"Failed to fetch bans":            "차단 목록을 불러오지 못했습니다",
+		"Failed to lift ban":              "차단을 해제하지 못했습니다",
+		"Ban not found":                   "이 IP 또는 로그인에 대한 차단이 없습니다",
+
+		"Failed to load payout reports":                                             "지급 보고서를 가져오지 못했습니다",
+		"Payout report is not waiting for approval":                                 "승인 대기 중인 지급 보고서가 아닙니다",
+		"Unknown feature:%v":                                                        "알 수 없는 기능: %v",
+		"Failed to update feature":                                                  "기능 플래그를 변경하지 못했습니다",
+		"Failed to report Redis memory usage":                                       "Redis 메모리 사용량을 가져오지 못했습니다",
+		"Failed to fetch worker stats":                                              "워커 통계를 가져오지 못했습니다",
+		"Redis memory at %.1f%% of maxmemory, largest: %v":                          "Redis 메모리가 maxmemory의 %.1f%%에 도달했습니다, 가장 큰 키 그룹: %v",
+		"Invalid correction file":                                                   "잘못된 보정 파일입니다",
+		"Correction file already imported":                                          "같은 키의 보정 파일이 이미 등록되었습니다",
+		"Failed to load compensations":                                              "보상 내역을 가져오지 못했습니다",
+		"Failed to load ledger":                                                     "원장 내역을 가져오지 못했습니다",
+		"Failed to load history":                                                    "기록을 가져오지 못했습니다",
+		"Compensation is not waiting for approval":                                  "승인 대기 중인 보상이 아닙니다",
+		"Compensation needs a second approver":                                      "보상은 등록한 사람이 아닌 다른 사람이 승인해야 합니다",
+		"Invalid adjustment":                                                        "잘못된 잔액 조정 요청입니다",
+		"Failed to store adjustment":                                                "잔액 조정 요청을 저장하지 못했습니다",
+		"Failed to fetch exchange addresses":                                        "거래소 주소 목록을 가져오지 못했습니다",
+		"Failed to update exchange address":                                         "거래소 주소를 변경하지 못했습니다",
+		"Withholding detection is disabled":                                         "블록 보류 탐지가 꺼져 있습니다",
+		"Possible exchange deposit address %v: %v workers, %.1f%% of pool hashrate": "거래소 입금 주소로 보이는 주소 %v: 워커 %v개, 풀 해시레이트의 %.1f%%",
+		"exchangeAddress":                                                           "여러 채굴자가 함께 쓰는 거래소 입금 주소로 보입니다. 워커 이름을 지정해 구분하고, 거래소에서 필요하면 지급 메모를 설정하세요.",
+		"unnamedWorkers":                                                            "이 주소의 일부 워커에 이름이 없습니다.",
+
+		"Failed to fetch stats from backend: %v":                  "통계를 가져오지 못했습니다: %v",
+		"non-existent minor:%v":                                   "알 수 없는 채굴자: %v",
+		"Failed to no minor information: %v":                      "채굴자 정보를 가져오지 못했습니다: %v",
+		"Failed to set payout value error:%v":                     "잘못된 지급 기준액: %v",
+		"Failed to UpdatePayoutLimit:payout out of range(min:%v)": "지급 기준액은 %v 섀넌 이상이어야 합니다",
+		"Failed to UpdatePayoutLimit:payout out of range(max:%v)": "지급 기준액은 %v 섀넌 이하여야 합니다",
+		"Failed to UpdatePayoutLimit (%v)":                        "%v의 지급 기준액을 변경하지 못했습니다",
+		"Failed to set payout memo error:%v":                      "지급 메모를 설정하지 못했습니다: %v",
+		"Invalid payout memo (%v)":                                "%v의 지급 메모가 올바르지 않습니다. 영문, 숫자, -_:. 로 64자까지 입력하세요",
+		"Failed to UpdatePayoutMemo (%v)":                         "%v의 지급 메모를 변경하지 못했습니다",
+
+		"Invalid login (%v)":                        "잘못된 주소입니다: %v",
+		"Failed to create challenge":                "로그인 챌린지를 만들지 못했습니다",
+		"Failed to sign in":                         "로그인하지 못했습니다",
+		"Failed to load settings":                   "설정을 가져오지 못했습니다",
+		"Failed to set notification email error:%v": "알림 이메일을 설정하지 못했습니다: %v",
+		"Invalid notification email (%v)":           "%v의 알림 이메일이 올바르지 않습니다",
+		"Failed to UpdateNotifyEmail (%v)":          "%v의 알림 이메일을 변경하지 못했습니다",
+		"Failed to set notifications error:%v":      "알림을 설정하지 못했습니다: %v",
+		"Failed to UpdateNotifyOptions (%v)":        "%v의 알림 설정을 변경하지 못했습니다",
+
+		"Invalid age":                 "잘못된 기간입니다",
+		"Failed to load stuck blocks": "멈춘 블록 목록을 가져오지 못했습니다",
+
+		"Federation is disabled": "통합 조회가 비활성화되어 있습니다",
+
+		"It's work time HUMAN!!!!! (%v)":            "확인이 필요합니다! (%v)",
+		"occurrence of abnormal system: (%v)%v[%v]": "시스템 이상 발생: (%v)%v[%v]",
+		"Pool hashrate %v: %+.1f%% (%v -> %v H/s)":  "풀 해시레이트 %v: %+.1f%% (%v -> %v H/s)",
+		"spike": "급증",
+		"drop":  "급감",
+	},
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type RedisMemoryConfig struct {
+	Enabled bool `json:"enabled"`
+	// How often the keys are scanned and the sample is stored for the charts
+	Interval string `json:"interval"`
+	// MEMORY USAGE is called on one key in sampleEvery per family
+	SampleEvery int64 `json:"sampleEvery"`
+	// Keys scanned per report at most
+	MaxKeys int64 `json:"maxKeys"`
+	// Alarm when used memory reaches this percent of maxmemory
+	AlarmPercent float64 `json:"alarmPercent"`
+}
+
+// initRedisMemory sets the defaults and starts the periodic reports. Reports on request work without it enabled.
+func (s *ApiServer) initRedisMemory() {
+	if s.config.RedisMemory == nil {
+		s.config.RedisMemory = &RedisMemoryConfig{}
+	}
+	cfg := s.config.RedisMemory
+	if cfg.SampleEvery <= 0 {
+		cfg.SampleEvery = 100
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = 1000000
+	}
+	if cfg.AlarmPercent <= 0 {
+		cfg.AlarmPercent = 90
+	}
+	if !cfg.Enabled {
+		return
+	}
+	intv := time.Hour
+	if len(cfg.Interval) > 0 {
+		intv = util.MustParseDuration(cfg.Interval)
+	}
+	log.Infof("Set Redis memory report interval to %v", intv)
+
+	go func() {
+		for {
+			s.collectRedisMemory()
+			time.Sleep(intv)
+		}
+	}()
+}
+
+func (s *ApiServer) collectRedisMemory() *redis.MemoryReport {
+	start := time.Now()
+	report, err := s.backend.MemoryUsage(s.config.RedisMemory.SampleEvery, s.config.RedisMemory.MaxKeys)
+	if err != nil {
+		log.Errorf("Failed to report Redis memory usage: %v", err)
+		return nil
+	}
+	s.redisMemory.Store(report)
+	if err := s.backend.WriteMemoryCharts(report); err != nil {
+		log.Errorf("Failed to write Redis memory charts: %v", err)
+	}
+	log.Infof("Redis memory: %v bytes used, %v keys scanned in %v", report.UsedMemory, report.ScannedKeys, time.Since(start))
+
+	if report.MaxMemory > 0 {
+		percent := float64(report.UsedMemory) * 100 / float64(report.MaxMemory)
+		if percent >= s.config.RedisMemory.AlarmPercent {
+			key := "Redis memory at %.1f%% of maxmemory, largest: %v"
+			largest := ""
+			if len(report.Families) > 0 {
+				largest = report.Families[0].Family
+			}
+			s.logs.InsertLog(fmt.Sprintf(key, percent, largest), plogger.LogTypeSystem, plogger.LogSubTypeRedisMemory, 0, 0, "", "")
+			if s.alarm != nil {
+				s.alarm.Notify(key, percent, largest)
+			}
+		}
+	}
+	return report
+}
+
+// RedisMemoryIndex returns the latest Redis memory report per key family and the stored samples.
+// "?refresh=1" scans now instead of returning the periodic report.
+func (s *ApiServer) RedisMemoryIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	report, _ := s.redisMemory.Load().(*redis.MemoryReport)
+	if report == nil || r.URL.Query().Get("refresh") == "1" {
+		report = s.collectRedisMemory()
+	}
+	if report == nil {
+		s.ErrorWrite(w, "Failed to report Redis memory usage")
+		return
+	}
+	charts, err := s.backend.GetMemoryCharts()
+	if err != nil {
+		log.Errorf("Failed to read Redis memory charts: %v", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"report": report,
+		"charts": charts,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/mux"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type MinerAuthConfig struct {
+	Enabled bool `json:"enabled"`
+	// Time a miner has to sign a challenge
+	ChallengeTTL string `json:"challengeTTL"`
+	// Lifetime of the token a signed challenge gets, in minutes
+	TokenExpiration int64 `json:"tokenExpiration"`
+}
+
+var emailPattern = regexp.MustCompile(`^[^@\s]{1,64}@[^@\s]+\.[^@\s]+$`)
+
+const maxEmailLength = 254
+
+// authChallenge is the message a miner signs to prove it owns login. It names the pool and the
+// address, so a signature can't be replayed elsewhere, and carries a random nonce.
+func (s *ApiServer) authChallenge(login string, expires time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Sign in to %v as %v\nNonce: %v\nExpires: %v",
+		s.config.Name, login, hex.EncodeToString(nonce), expires.UTC().Format(time.RFC3339)), nil
+}
+
+// recoverSigner returns the address that signed msg with personal_sign (eth_sign), a 65 byte
+// signature in hex, V being 0/1 or 27/28.
+func recoverSigner(msg, signature string) (string, error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return "", err
+	}
+	if len(sig) != 65 {
+		return "", errors.New("signature must be 65 bytes")
+	}
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	if sig[64] > 1 {
+		return "", errors.New("invalid recovery id")
+	}
+	hash := crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(msg), msg)))
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(crypto.PubkeyToAddress(*pub).Hex()), nil
+}
+
+// AuthChallengeIndex returns a challenge for {"login": "0x..."} to sign.
+func (s *ApiServer) AuthChallengeIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	var req struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	login := strings.ToLower(req.Login)
+	if !util.IsValidHexAddress(login) {
+		s.WirteResponseData(w, http.StatusBadRequest, "Invalid login (%v)", login)
+		return
+	}
+
+	ttl := 5 * time.Minute
+	if len(s.config.MinerAuth.ChallengeTTL) > 0 {
+		ttl = util.MustParseDuration(s.config.MinerAuth.ChallengeTTL)
+	}
+	expires := time.Now().Add(ttl)
+	challenge, err := s.authChallenge(login, expires)
+	if err == nil {
+		err = s.backend.SetAuthChallenge(login, challenge, ttl)
+	}
+	if err != nil {
+		log.Errorf("Failed to store challenge of %v: %v", login, err)
+		s.ErrorWrite(w, "Failed to create challenge")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"challenge": challenge,
+		"expires":   expires.Unix(),
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// AuthVerifyIndex checks {"login": "0x...", "signature": "0x..."} against the challenge of login and
+// issues a token for the /user endpoints of that address. A challenge is answered once.
+func (s *ApiServer) AuthVerifyIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	var req struct {
+		Login     string `json:"login"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	login := strings.ToLower(req.Login)
+	if !util.IsValidHexAddress(login) {
+		s.WirteResponseData(w, http.StatusBadRequest, "Invalid login (%v)", login)
+		return
+	}
+
+	challenge, err := s.backend.TakeAuthChallenge(login)
+	if err != nil {
+		log.Errorf("Failed to load challenge of %v: %v", login, err)
+		s.ErrorWrite(w, "Failed to create challenge")
+		return
+	}
+	if len(challenge) == 0 {
+		s.ServerError(w, r, "unauthorized: no challenge")
+		return
+	}
+	signer, err := recoverSigner(challenge, req.Signature)
+	if err != nil || signer != login {
+		log.Warnf("Rejected signature of %v, signed by %v: %v", login, signer, err)
+		s.ServerError(w, r, "unauthorized: Invalid signature")
+		return
+	}
+
+	expiration := s.config.MinerAuth.TokenExpiration
+	if expiration <= 0 {
+		expiration = 24 * 60
+	}
+	token, err := s.CreateToken(login, "user", expiration)
+	if err != nil {
+		log.Errorf("Failed to create token of %v: %v", login, err)
+		s.ErrorWrite(w, "Failed to sign in")
+		return
+	}
+	tokenSplit := strings.Split(token, ".")
+	if len(tokenSplit) != 3 {
+		return
+	}
+	s.backend.SetToken(util.Join(s.config.Coin, login), tokenSplit[2], expiration)
+	log.Infof("Miner %v signed in", login)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "access-token",
+		Value:    token,
+		HttpOnly: true,
+		Expires:  time.Now().Add(time.Minute * time.Duration(expiration)),
+	})
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"msg":   "success",
+		"token": token,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// MinerSettingsIndex returns the payout threshold, payout memo and notification email of a miner.
+func (s *ApiServer) MinerSettingsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login := strings.ToLower(mux.Vars(r)["login"])
+	settings, err := s.db.GetMinerSettings(login)
+	if err != nil {
+		log.Errorf("Failed to load settings of %v: %v", login, err)
+		s.ErrorWrite(w, "Failed to load settings")
+		return
+	}
+	if settings == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"settings":  settings,
+		"minPayout": s.config.MinPayoutLimit,
+		"maxPayout": s.config.MaxPayoutLimit,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// NotifyEmailIndex sets the notification email of a miner from {"email": "..."}, "" to clear it.
+func (s *ApiServer) NotifyEmailIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login := strings.ToLower(mux.Vars(r)["login"])
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.WirteResponseData(w, http.StatusBadRequest, "Failed to set notification email error:%v", err)
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if len(email) > 0 && (len(email) > maxEmailLength || !emailPattern.MatchString(email)) {
+		s.WirteResponseData(w, http.StatusBadRequest, "Invalid notification email (%v)", login)
+		return
+	}
+	err := s.db.UpdateNotifyEmail(login, email)
+	if err != nil {
+		log.Errorf("Failed to set notification email of %v: %v", login, err)
+		s.WirteResponseData(w, http.StatusInternalServerError, "Failed to UpdateNotifyEmail (%v)", login)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"msg": "success",
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// NotifyOptionsIndex sets the events a miner is emailed about from {"payout": true, "offline": false, "hashrate": true}.
+// Emails go to the notification email, nothing is sent without one.
+func (s *ApiServer) NotifyOptionsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login := strings.ToLower(mux.Vars(r)["login"])
+
+	var options mysql.NotifyOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		s.WirteResponseData(w, http.StatusBadRequest, "Failed to set notifications error:%v", err)
+		return
+	}
+	err := s.db.UpdateNotifyOptions(login, options)
+	if err != nil {
+		log.Errorf("Failed to set notifications of %v: %v", login, err)
+		s.WirteResponseData(w, http.StatusInternalServerError, "Failed to UpdateNotifyOptions (%v)", login)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"msg":    "success",
+		"notify": options,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
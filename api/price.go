@@ -0,0 +1,41 @@
+package api
+
+import (
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/payouts"
+)
+
+// initPrice starts the price feed of the fiat values shown next to balances and rewards.
+func (s *ApiServer) initPrice() {
+	if s.config.Price == nil || !s.config.Price.Enabled {
+		return
+	}
+	feed, err := payouts.NewPriceFeed(s.config.Price)
+	if err != nil {
+		log.Fatalf("Invalid price feed: %v", err)
+	}
+	s.price = feed
+}
+
+// priceStats returns the coin price for the stats, refreshed when it is due. Without any rate the
+// price isn't available instead of failing the stats, and a rate kept while the providers fail is stale.
+func (s *ApiServer) priceStats() map[string]interface{} {
+	price := map[string]interface{}{
+		"currency":  s.config.Price.Currency,
+		"available": false,
+		"stale":     true,
+	}
+	quote, err := s.price.Quote()
+	if err != nil {
+		log.Errorf("No %v price to show: %v", s.config.Price.Currency, err)
+		return price
+	}
+	price["available"] = true
+	price["stale"] = quote.Stale
+	price["rate"] = quote.Rate.FloatString(8)
+	price["sources"] = quote.Sources
+	price["updatedAt"] = quote.UpdatedAt.Unix()
+	price["age"] = int64(time.Since(quote.UpdatedAt) / time.Second)
+	return price
+}
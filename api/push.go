@@ -0,0 +1,290 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type PushConfig struct {
+	Enabled bool `json:"enabled"`
+	// Connected clients at most
+	MaxClients int `json:"maxClients"`
+	// Miners one client may subscribe to
+	MaxMiners int `json:"maxMiners"`
+}
+
+const (
+	pushWriteWait  = 10 * time.Second
+	pushPongWait   = 60 * time.Second
+	pushPingPeriod = pushPongWait * 9 / 10
+	// Messages queued per client, a client that falls further behind is dropped
+	pushBufferSize = 64
+)
+
+// Topics of the push API. Subscribed miners also get their hashrate and payments.
+const (
+	topicStats    = "stats"
+	topicBlocks   = "blocks"
+	topicPayments = "payments"
+)
+
+type pushMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// pushRequest changes the subscriptions of a client.
+type pushRequest struct {
+	Subscribe         []string `json:"subscribe"`
+	Unsubscribe       []string `json:"unsubscribe"`
+	Miners            []string `json:"miners"`
+	UnsubscribeMiners []string `json:"unsubscribeMiners"`
+}
+
+// pushHub fans out pool stats from the API and events of the other modules, received on
+// ChannelPush, to the WebSocket clients subscribed to them.
+type pushHub struct {
+	config   *PushConfig
+	upgrader websocket.Upgrader
+
+	mu      sync.RWMutex
+	clients map[*pushClient]struct{}
+}
+
+type pushClient struct {
+	hub  *pushHub
+	conn *websocket.Conn
+	send chan []byte
+	done chan struct{}
+
+	mu     sync.RWMutex
+	topics map[string]bool
+	miners map[string]bool
+}
+
+func newPushHub(cfg *PushConfig, allowedOrigins []string) *pushHub {
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = 1000
+	}
+	if cfg.MaxMiners <= 0 {
+		cfg.MaxMiners = 10
+	}
+	h := &pushHub{config: cfg, clients: make(map[*pushClient]struct{})}
+	h.upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return len(allowedOrigins) == 0 || len(origin) == 0 || util.StringInSlice(origin, allowedOrigins)
+	}
+	return h
+}
+
+func (s *ApiServer) initPush() {
+	if s.config.Push == nil || !s.config.Push.Enabled {
+		return
+	}
+	s.push = newPushHub(s.config.Push, s.config.AllowedOrigins)
+	s.backend.InitPubSub(redis.ChannelPush, s.push)
+	log.Infof("Push API for up to %v clients", s.push.config.MaxClients)
+}
+
+// RedisMessage pushes an event of another module.
+func (h *pushHub) RedisMessage(payload string) {
+	e, ok := redis.ParsePushEvent(payload)
+	if !ok {
+		log.Warnf("Malformed push event: %v", payload)
+		return
+	}
+	topic := topicBlocks
+	if e.Type == redis.PushPaymentSent {
+		topic = topicPayments
+	}
+	h.broadcast(topic, e.Login, pushMessage{Type: e.Type, Data: e.Data})
+}
+
+// broadcast sends v to the clients subscribed to topic, or to login if it isn't empty.
+func (h *pushHub) broadcast(topic, login string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("Failed to serialize push message: %v", err)
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		if c.subscribed(topic, login) {
+			c.push(data)
+		}
+	}
+}
+
+// pushStats sends the pool stats and the hashrate of the subscribed miners after stats are collected.
+func (s *ApiServer) pushStats(stats map[string]interface{}) {
+	if s.push == nil {
+		return
+	}
+	now := util.MakeTimestamp()
+	s.push.broadcast(topicStats, "", pushMessage{Type: "stats", Data: map[string]interface{}{
+		"now":         now,
+		"stats":       stats["stats"],
+		"hashrate":    stats["hashrate"],
+		"minersTotal": stats["minersTotal"],
+	}})
+
+	miners, _ := stats["miners"].(map[string]redis.Miner)
+	s.push.mu.RLock()
+	defer s.push.mu.RUnlock()
+	messages := make(map[string][]byte)
+	for c := range s.push.clients {
+		for _, login := range c.subscribedMiners() {
+			data, ok := messages[login]
+			if !ok {
+				miner, online := miners[login]
+				data, _ = json.Marshal(pushMessage{Type: "hashrate", Data: map[string]interface{}{
+					"now":      now,
+					"login":    login,
+					"hashrate": miner.HR,
+					"workers":  miner.Workers,
+					"offline":  !online || miner.Offline,
+				}})
+				messages[login] = data
+			}
+			c.push(data)
+		}
+	}
+}
+
+// PushIndex upgrades to a WebSocket pushing the subscribed topics and miners, e.g. after
+// {"subscribe": ["stats", "blocks"], "miners": ["0x..."]}.
+func (s *ApiServer) PushIndex(w http.ResponseWriter, r *http.Request) {
+	if s.push == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	h := s.push
+	h.mu.RLock()
+	full := len(h.clients) >= h.config.MaxClients
+	h.mu.RUnlock()
+	if full {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Errorf("Failed to upgrade push connection: %v", err)
+		return
+	}
+	c := &pushClient{
+		hub:    h,
+		conn:   conn,
+		send:   make(chan []byte, pushBufferSize),
+		done:   make(chan struct{}),
+		topics: make(map[string]bool),
+		miners: make(map[string]bool),
+	}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	go c.writeLoop()
+	c.readLoop()
+
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+	close(c.done)
+	conn.Close()
+}
+
+func (c *pushClient) readLoop() {
+	c.conn.SetReadLimit(4096)
+	c.conn.SetReadDeadline(time.Now().Add(pushPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pushPongWait))
+	})
+	for {
+		var req pushRequest
+		if err := c.conn.ReadJSON(&req); err != nil {
+			return
+		}
+		c.update(&req)
+	}
+}
+
+func (c *pushClient) writeLoop() {
+	ticker := time.NewTicker(pushPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(pushWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(pushWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// push queues data, or drops a client that doesn't keep up.
+func (c *pushClient) push(data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (c *pushClient) update(req *pushRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range req.Subscribe {
+		switch topic {
+		case topicStats, topicBlocks, topicPayments:
+			c.topics[topic] = true
+		}
+	}
+	for _, topic := range req.Unsubscribe {
+		delete(c.topics, topic)
+	}
+	for _, login := range req.Miners {
+		login = strings.ToLower(login)
+		if util.IsValidHexAddress(login) && len(c.miners) < c.hub.config.MaxMiners {
+			c.miners[login] = true
+		}
+	}
+	for _, login := range req.UnsubscribeMiners {
+		delete(c.miners, strings.ToLower(login))
+	}
+}
+
+func (c *pushClient) subscribed(topic, login string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.topics[topic] || (len(login) > 0 && c.miners[login])
+}
+
+func (c *pushClient) subscribedMiners() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	logins := make([]string, 0, len(c.miners))
+	for login := range c.miners {
+		logins = append(logins, login)
+	}
+	return logins
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+	"github.com/gorilla/mux"
+)
+
+// Roles of the admin accounts and API keys, each allowed everything the ones before it are.
+const (
+	roleReadOnly = "read-only"
+	roleOperator = "operator"
+	roleAdmin    = "admin"
+)
+
+var roleRanks = map[string]int{roleReadOnly: 1, roleOperator: 2, roleAdmin: 3}
+
+// Routes left to admins: the accounts, the API keys and everything moving funds. A route is
+// listed with its method when only that one needs the role.
+var adminRoutes = map[string]bool{
+	"/api/reglist":                    true,
+	"/api/addaccount":                 true,
+	"/api/changeacc":                  true,
+	"/api/changepass":                 true,
+	"/api/changerole":                 true,
+	"/api/delaccount":                 true,
+	"/api/apikeys":                    true,
+	"/api/apikeys/{id:[0-9]+}/revoke": true,
+	"POST /api/compensations":         true,
+	"/api/compensations/{id:[0-9]+}/{action:approve|reject}":               true,
+	"/api/payoutreports/{id:[0-9]+}/{action:approve|reject}":               true,
+	"/api/payoutcontracts/{login:0x[0-9a-fA-F]{40}}/{action:approve|hold}": true,
+	"/api/adjustments": true,
+}
+
+// Routes changing the pool whatever their method, the frontend calls some of them with GET.
+var writeRoutes = map[string]bool{
+	"/api/saveinbound": true,
+	"/api/delinbound":  true,
+	"/api/saveidbound": true,
+	"/api/delidbound":  true,
+	"/api/addsubid":    true,
+	"/api/delsubid":    true,
+	"/api/changealarm": true,
+	"/api/changedesc":  true,
+	"/api/applyid":     true,
+	"/api/applyip":     true,
+	"/api/applysub":    true,
+}
+
+// requiredRole is the least role allowed to call the route of r: reads are open to every role and
+// changes to operators, unless the route is left to admins.
+func requiredRole(r *http.Request) string {
+	template := ""
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ = route.GetPathTemplate()
+	}
+	if adminRoutes[template] || adminRoutes[r.Method+" "+template] {
+		return roleAdmin
+	}
+	if writeRoutes[template] {
+		return roleOperator
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return roleReadOnly
+	}
+	return roleOperator
+}
+
+func hasRole(role, required string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[required]
+}
+
+func validRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// statusRecorder keeps the status of a response for the audit log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// audit logs an admin action, or an attempt refused for the caller's role, with who made it.
+func (s *ApiServer) audit(r *http.Request, status int) {
+	login := r.Header.Get("login")
+	s.logs.InsertLog(fmt.Sprintf("ADMIN %v %v by %v (%v): %v", r.Method, r.URL.Path, login, r.Header.Get("role"), status),
+		plogger.LogTypeSystem, plogger.LogSubTypeAdminAction, 0, 0, login, "")
+}
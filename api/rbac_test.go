@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRequiredRole(t *testing.T) {
+	var role string
+	router := mux.NewRouter()
+	for _, template := range []string{
+		"/api/addaccount",
+		"/api/apikeys/{id:[0-9]+}/revoke",
+		"/api/compensations",
+		"/api/payoutreports/{id:[0-9]+}/{action:approve|reject}",
+		"/api/saveinbound",
+		"/api/stats",
+	} {
+		router.HandleFunc(template, func(w http.ResponseWriter, r *http.Request) {
+			role = requiredRole(r)
+		})
+	}
+
+	tests := []struct {
+		method, path, expected string
+	}{
+		// Admin routes whatever the method
+		{"GET", "/api/addaccount", roleAdmin},
+		{"POST", "/api/apikeys/12/revoke", roleAdmin},
+		{"GET", "/api/payoutreports/3/approve", roleAdmin},
+		// Admin route for one method only
+		{"POST", "/api/compensations", roleAdmin},
+		{"GET", "/api/compensations", roleReadOnly},
+		// Write routes called with GET
+		{"GET", "/api/saveinbound", roleOperator},
+		// Method fallbacks
+		{"GET", "/api/stats", roleReadOnly},
+		{"HEAD", "/api/stats", roleReadOnly},
+		{"OPTIONS", "/api/stats", roleReadOnly},
+		{"POST", "/api/stats", roleOperator},
+		{"DELETE", "/api/stats", roleOperator},
+	}
+	for _, test := range tests {
+		role = ""
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, test.path, nil))
+		if role != test.expected {
+			t.Errorf("%v %v must need %v, got %q", test.method, test.path, test.expected, role)
+		}
+	}
+
+	// Without a matched route only the method counts
+	if role := requiredRole(httptest.NewRequest("PUT", "/api/unknown", nil)); role != roleOperator {
+		t.Errorf("Must need %v for a change outside the router, got %v", roleOperator, role)
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role, required string
+		expected       bool
+	}{
+		{roleAdmin, roleAdmin, true},
+		{roleAdmin, roleReadOnly, true},
+		{roleOperator, roleOperator, true},
+		{roleOperator, roleAdmin, false},
+		{roleReadOnly, roleOperator, false},
+		{roleReadOnly, roleReadOnly, true},
+		{"", roleReadOnly, false},
+		{"root", roleReadOnly, false},
+	}
+	for _, test := range tests {
+		if ok := hasRole(test.role, test.required); ok != test.expected {
+			t.Errorf("Role %q with %v required must be %v, got %v", test.role, test.required, test.expected, ok)
+		}
+	}
+}
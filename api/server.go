@@ -3,10 +3,15 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"github.com/cellcrypto/open-dangnn-pool/api/alarm"
+	"github.com/cellcrypto/open-dangnn-pool/api/anomaly"
+	"github.com/cellcrypto/open-dangnn-pool/api/i18n"
 	"github.com/cellcrypto/open-dangnn-pool/hook"
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
 	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
-	"log"
 	"net/http"
 	"sort"
 	"strconv"
@@ -15,14 +20,21 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/cellcrypto/open-dangnn-pool/feature"
+	"github.com/cellcrypto/open-dangnn-pool/payouts"
+	"github.com/cellcrypto/open-dangnn-pool/rpc"
 	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
 	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
 	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/xlog"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
 
+var log = xlog.Module("api")
+
 type ApiConfig struct {
 	Enabled                 bool   `json:"enabled"`
 	Listen                  string `json:"listen"`
@@ -35,6 +47,8 @@ type ApiConfig struct {
 	DeleteMaxRecord			int64  `json:"deleteMaxRecord"`
 	DeleteKeepRecord		int64  `json:"deleteKeepRecord"`
 	MinerPoolTimeout        string `json:"minerPoolTimeout"`
+	// Worker stats rolled up every minerChartInterval are kept this long
+	WorkerStatsRetention    string `json:"workerStatsRetention"`
 	StatsCollectInterval    string `json:"statsCollectInterval"`
 	HashrateWindow          string `json:"hashrateWindow"`
 	HashrateLargeWindow     string `json:"hashrateLargeWindow"`
@@ -47,9 +61,43 @@ type ApiConfig struct {
 	Coin                    string
 	Name                    string
 	Depth                   int64
+	// Age of the blocks listed by /api/blocks/stuck, unlocker.maxCandidateAge
+	MaxCandidateAge         string
+	// For payout ETAs, unlocker.poolFee, payouts.interval and payouts.windows
+	PoolFee                 float64
+	PayoutInterval          string
+	PayoutWindows           []string
+	// For earnings estimates, unlocker.daemon and unlocker.timeout
+	Daemon                  string
+	DaemonTimeout           string
+	NetId                   int64
 	Alarm					*alarm.Config	`json:"alarm"`
+	I18n					*i18n.Config	`json:"i18n"`
+	Anomaly					*anomaly.Config	`json:"anomaly"`
+	RedisMemory				*RedisMemoryConfig	`json:"redisMemory"`
+	Exchange				*ExchangeConfig	`json:"exchange"`
+	// Workers flagged for improbably few near-block shares or blocks
+	Withholding				*WithholdingConfig	`json:"withholding"`
+	Push					*PushConfig	`json:"push"`
+	Compression				*CompressionConfig	`json:"compression"`
+	History					*HistoryConfig	`json:"history"`
+	// Pool and miner hashrates rolled up per minute, hour and day
+	HashrateCharts			*HashrateChartsConfig	`json:"hashrateCharts"`
+	MinerAuth				*MinerAuthConfig	`json:"minerAuth"`
+	// Coin price for fiat values, shown stale while the providers fail
+	Price					*payouts.PriceFeedConfig	`json:"price"`
+	// Combined account view with the sibling pools of the operator
+	Federation				*FederationConfig	`json:"federation"`
+	// Keys with a role for scripts calling the admin API
+	ApiKeys					*ApiKeysConfig	`json:"apiKeys"`
+	// Admin routes limited to some networks or to client certificates
+	AdminAccess				*AdminAccessConfig	`json:"adminAccess"`
+	TLS						*ApiTLSConfig	`json:"tls"`
 	// In Shannon
 	Threshold      int64  `json:"threshold"`
+	MinPayoutLimit int64
+	MaxPayoutLimit int64
+	CompensationMaxTotal int64
 	AccessSecret   string `json:"AccessSecret"`
 }
 
@@ -62,6 +110,7 @@ type ApiServer struct {
 	miners              map[string]*Entry
 	apiMiners           map[string]*Entry
 	db                  *mysql.Database
+	logs                *plogger.Logger
 	minersMu            sync.RWMutex
 	apiMinersMu         sync.RWMutex
 	statsIntv           time.Duration
@@ -70,6 +119,34 @@ type ApiServer struct {
 	allowedOrigins      []string
 
 	alarm     *alarm.AlramServer
+	i18n      *i18n.Catalog
+	anomaly   *anomaly.Detector
+	// Latest *redis.MemoryReport
+	redisMemory atomic.Value
+	// WebSocket clients, nil when the push API is disabled
+	push      *pushHub
+	// Nodes and hashrate as of the last alert checks
+	alertState *alertState
+	// Coin price feed, nil when disabled
+	price     *payouts.PriceFeed
+	// Nil without a payout interval
+	payoutSchedule *payouts.PayoutSchedule
+	// Nil when the federation is disabled
+	federation *federation
+	// Share withholding checks, nil when disabled
+	withholding *withholdingDetector
+	// Served by StartShared instead of its own listener
+	shared bool
+	// Node of the unlocker for the chain head, nil without one
+	rpc       *rpc.RPCClient
+	// Latest *network
+	network   atomic.Value
+	// Requests of each API key, nil when API keys are disabled
+	apiKeys   *keyLimiter
+	// Networks of api.adminAccess, any when empty
+	adminNets []*net.IPNet
+	// Fork table of the pool's network, for the block reward
+	forks     *types.Forks
 
 	//poolChartIntv       time.Duration
 	//minerChartIntv      time.Duration
@@ -91,8 +168,9 @@ func (s *ApiServer) RedisMessage(payload string) {
 	case redis.OpcodeLoadIP:
 	case redis.OpcodeWhiteList:
 	case redis.OpcodeMinerSub:
+	case redis.OpcodeExchange:
 	default:
-		log.Printf("not defined opcode: %v", opcode)
+		log.Errorf("not defined opcode: %v", opcode)
 	}
 
 	fmt.Printf("(opcode:%v from:%s)RedisMessage: %s\n", opcode, from, msg)
@@ -108,10 +186,31 @@ const (
 	unLimitTokenExpiration = int64(26280000)
 )
 
-func NewApiServer(cfg *ApiConfig, coin string, name string, backend *redis.RedisClient, db *mysql.Database) *ApiServer {
+func NewApiServer(cfg *ApiConfig, coin string, name string, backend *redis.RedisClient, db *mysql.Database, forks *types.Forks) *ApiServer {
 	hashrateWindow := util.MustParseDuration(cfg.HashrateWindow)
 	hashrateLargeWindow := util.MustParseDuration(cfg.HashrateLargeWindow)
+	catalog, err := i18n.New(cfg.I18n)
+	if err != nil {
+		log.Fatalf("Failed to load locales: %v", err)
+	}
+	var schedule *payouts.PayoutSchedule
+	if len(cfg.PayoutInterval) > 0 {
+		if schedule, err = payouts.NewPayoutSchedule(cfg.PayoutInterval, cfg.PayoutWindows); err != nil {
+			log.Warnf("Payout ETAs are disabled: %v", err)
+		}
+	}
+	var client *rpc.RPCClient
+	if len(cfg.Daemon) > 0 {
+		timeout := cfg.DaemonTimeout
+		if len(timeout) == 0 {
+			timeout = "10s"
+		}
+		client = rpc.NewRPCClient(name, "Api", cfg.Daemon, timeout, cfg.NetId)
+	}
 	return &ApiServer{
+		forks:               forks,
+		rpc:                 client,
+		payoutSchedule:      schedule,
 		config:              cfg,
 		backend:             backend,
 		hashrateWindow:      hashrateWindow,
@@ -119,48 +218,55 @@ func NewApiServer(cfg *ApiConfig, coin string, name string, backend *redis.Redis
 		miners:              make(map[string]*Entry),
 		apiMiners:           make(map[string]*Entry),
 		db:					db,
+		logs:				plogger.For(name),
+		i18n:				catalog,
 	}
 }
 
 func (s *ApiServer) Start() {
 	if s.config.PurgeOnly {
-		log.Printf("Starting API in purge-only mode")
+		log.Infof("Starting API in purge-only mode")
 	} else {
-		log.Printf("Starting API on %v", s.config.Listen)
+		log.Infof("Starting API on %v", s.config.Listen)
 	}
 
 	quit := make(chan struct{})
 	hooks := make(chan struct{})
 
-	plogger.InsertLog("START API SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
-	hook.RegistryHook("server.go", func(name string) {
-		plogger.InsertLog("SHUTDOWN API SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+	s.logs.InsertLog("START API SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+	hook.RegistryHook(util.Join("server.go", s.config.Name), func(name string) {
+		s.logs.InsertLog("SHUTDOWN API SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
 		close(quit)
 		<- hooks
 	})
 
 	s.statsIntv = util.MustParseDuration(s.config.StatsCollectInterval)
 	statsTimer := time.NewTimer(s.statsIntv)
-	log.Printf("Set stats collect interval to %v", s.statsIntv)
+	log.Infof("Set stats collect interval to %v", s.statsIntv)
 
 	purgeIntv := util.MustParseDuration(s.config.PurgeInterval)
 	purgeTimer := time.NewTimer(purgeIntv)
-	log.Printf("Set purge interval to %v", purgeIntv)
+	log.Infof("Set purge interval to %v", purgeIntv)
 
 	poolChartIntv := util.MustParseDuration(s.config.PoolChartInterval)
 	poolChartTimer := time.NewTimer(poolChartIntv)
 	s.minerPoolChartIntv = poolChartIntv.Milliseconds() / 1000
-	log.Printf("Set pool chart interval to %v", poolChartIntv)
+	log.Infof("Set pool chart interval to %v", poolChartIntv)
 
 	minerChartCheckIntv := util.MustParseDuration(s.config.MinerChartCheckInterval)
 	minerChartTimer := time.NewTimer(minerChartCheckIntv)
 
 	minerChartIntv := util.MustParseDuration(s.config.MinerChartInterval)
 	minerChartIntvSec := int64(minerChartIntv.Minutes() * 60)
-	log.Printf("Set miner chart interval to %v %v", minerChartCheckIntv, minerChartIntvSec)
+	log.Infof("Set miner chart interval to %v %v", minerChartCheckIntv, minerChartIntvSec)
 
 	s.minerPoolTimeout = util.MustParseDuration(s.config.MinerPoolTimeout)
 
+	if len(s.config.WorkerStatsRetention) == 0 {
+		s.config.WorkerStatsRetention = "168h"
+	}
+	workerStatsRetention := int64(util.MustParseDuration(s.config.WorkerStatsRetention) / time.Second)
+
 	var (
 		deleteCheckIntv time.Duration
 		deleteTimer *time.Timer
@@ -175,10 +281,28 @@ func (s *ApiServer) Start() {
 	s.backend.InitPubSub("api",s)
 
 	s.config.Alarm.Coin = s.config.Coin
+	s.config.Alarm.Messages = s.i18n
 	if s.config.Alarm.Enabled == true {
 		s.alarm = alarm.Start(s.config.Alarm,s.backend,s.db)
 	}
 
+	if s.config.Anomaly != nil && s.config.Anomaly.Enabled {
+		s.startAnomalyDetector()
+	}
+
+	s.initHashrateCharts()
+	s.initApiKeys()
+	s.initAdminAccess()
+
+	if !s.config.PurgeOnly {
+		s.initRedisMemory()
+		s.initExchange()
+		s.initWithholding()
+		s.initPush()
+		s.initPrice()
+		s.initFederation()
+	}
+
 	if s.config.PurgeOnly {
 		s.purgeStale()
 	} else {
@@ -217,7 +341,7 @@ func (s *ApiServer) Start() {
 			case <-minerChartTimer.C:
 				miners, err := s.db.GetAllMinerAccount(s.minerPoolTimeout, minerChartIntvSec)
 				if err != nil {
-					log.Println("Get all miners account error: ", err)
+					log.Error("Get all miners account error: ", err)
 				}
 
 				ts := util.MakeTimestamp() / 1000
@@ -230,8 +354,18 @@ func (s *ApiServer) Start() {
 						online, _, totalHashrate , currentHashrate := s.backend.CollectWorkersStatsEx(s.hashrateWindow, s.hashrateLargeWindow, miner.Addr)
 						// stats, _ := s.backend.CollectWorkersAllStats(s.hashrateWindow, s.hashrateLargeWindow, miner.Addr)
 						s.collectMinerCharts(miner.Addr, currentHashrate, totalHashrate, online, int64(miner.Share), reportedHash)
+
+						elapsed := ts - miner.ShareCheckTime
+						if elapsed <= 0 || elapsed > 2*minerChartIntvSec {
+							elapsed = minerChartIntvSec
+						}
+						s.rollupWorkerStats(miner.Addr, ts, elapsed)
 					}
 				}
+				if n := s.db.DeleteWorkerStats(ts - workerStatsRetention); n > 0 {
+					log.Infof("Deleted %v worker stats older than %v", n, s.config.WorkerStatsRetention)
+				}
+				s.pruneHashrateCharts(ts)
 				minerChartTimer.Reset(minerChartCheckIntv)
 			}
 		}
@@ -249,7 +383,7 @@ func (s *ApiServer) Start() {
 		}()
 	}
 
-	if !s.config.PurgeOnly {
+	if !s.config.PurgeOnly && !s.shared {
 		s.listen()
 	}
 }
@@ -281,20 +415,36 @@ func (s *ApiServer) TokenValid(accessToken string) (*jwt.Token,error) {
 func (s *ApiServer) authenticationMiddleware (next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//token := r.Header.Get("access-token")
+		w.Header().Set("Content-Language", s.i18n.Negotiate(r))
 
-		requestURL := strings.Split(r.RequestURI,"/")
+		requestURL := strings.Split(r.URL.Path,"/")
 		if len(requestURL) > 1 {
 			switch requestURL[1] {
-			case "signin","token","health":
+			case "signin","token","health","i18n","auth","federation":
 				fmt.Println(requestURL[1])
 				next.ServeHTTP(w, r)
 				return
 			}
-			passed, errStr := s.CheckJwtToken(r, requestURL[1])
-			if !passed {
-				fmt.Println("CheckJwtToken Error:",errStr)
-				s.ServerError(w, r, errStr)
-				return
+			if requestURL[1] == "api" {
+				if allowed, errStr := s.checkAdminAccess(r); !allowed {
+					log.Warnf("Admin request %v %v refused: %v", r.Method, r.URL.Path, errStr)
+					s.authError(w, r, http.StatusForbidden, errStr)
+					return
+				}
+			}
+			if key := r.Header.Get(apiKeyHeader); len(key) > 0 && requestURL[1] == "api" {
+				status, errStr := s.checkApiKey(r, key)
+				if status != http.StatusOK {
+					s.authError(w, r, status, errStr)
+					return
+				}
+			} else {
+				passed, errStr := s.CheckJwtToken(r, requestURL[1])
+				if !passed {
+					fmt.Println("CheckJwtToken Error:",errStr)
+					s.ServerError(w, r, errStr)
+					return
+				}
 			}
 		} else {
 			s.ServerError(w, r, "nothing page URI")
@@ -308,6 +458,21 @@ func (s *ApiServer) authenticationMiddleware (next http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
+
+		if requestURL[1] == "api" {
+			required := requiredRole(r)
+			if !hasRole(r.Header.Get("role"), required) {
+				s.audit(r, http.StatusForbidden)
+				s.authError(w, r, http.StatusForbidden, "forbidden: Requires the " + required + " role")
+				return
+			}
+			if required != roleReadOnly {
+				recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(recorder, r)
+				s.audit(r, recorder.status)
+				return
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -332,7 +497,7 @@ func (s *ApiServer) CheckJwtToken(r *http.Request, requestURI string) (bool,stri
 		return false, "unauthorized: nothing access"
 	}
 
-	var login string
+	var login, role string
 
 	if devId, ok := token.Claims.(jwt.MapClaims)["DevId"]; ok {
 		if access != "user" {
@@ -356,8 +521,14 @@ func (s *ApiServer) CheckJwtToken(r *http.Request, requestURI string) (bool,stri
 		}
 
 		login, _ = token.Claims.(jwt.MapClaims)["user_id"].(string)
+		// Tokens signed in before the roles keep full access until they expire.
+		role = roleAdmin
+		if claim, ok := token.Claims.(jwt.MapClaims)["role"].(string); ok {
+			role = claim
+		}
 	}
 	r.Header.Set("login", login)
+	r.Header.Set("role", role)
 
 	accessFlag := false
 	if access, ok := token.Claims.(jwt.MapClaims)["access"]; ok {
@@ -385,6 +556,11 @@ func (s *ApiServer) CheckJwtToken(r *http.Request, requestURI string) (bool,stri
 }
 
 func (s *ApiServer) ServerError(w http.ResponseWriter, r *http.Request, errMsg string) {
+	s.authError(w, r, http.StatusUnauthorized, errMsg)
+}
+
+// authError refuses a request with status, 401 when it isn't authenticated.
+func (s *ApiServer) authError(w http.ResponseWriter, r *http.Request, status int, errMsg string) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	origin := r.Header.Get("Origin")
 	if origin == "" {
@@ -396,25 +572,63 @@ func (s *ApiServer) ServerError(w http.ResponseWriter, r *http.Request, errMsg s
 	//w.Header().Set("Access-Control-Allow-Header", "access-token")
 	w.Header().Set("Cache-Control", "no-cache")
 
-	w.WriteHeader(http.StatusUnauthorized)
+	w.WriteHeader(status)
 	//w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
 		"msg": errMsg,
+		"text": s.localize(w, errMsg),
 	})
 	return
 }
 
+// localize translates a message into the locale negotiated for the response.
+func (s *ApiServer) localize(w http.ResponseWriter, key string, v ...interface{}) string {
+	return s.i18n.Translate(w.Header().Get("Content-Language"), key, v...)
+}
+
 func (s *ApiServer) listen() {
+	err := s.serve(s.handler())
+	if err != nil {
+		log.Fatalf("Failed to start API: %v", err)
+	}
+}
+
+func (s *ApiServer) handler() http.Handler {
 	r := mux.NewRouter()
 	//apiRouter := r.GetRoute("api")
 	//apiRouter.
 	r.HandleFunc("/api/stats", s.StatsIndex)
+	r.HandleFunc("/api/push", s.PushIndex)
 	r.HandleFunc("/api/miners", s.MinersIndex)
 	r.HandleFunc("/api/blocks", s.BlocksIndex)
+	r.HandleFunc("/api/blocks/history", s.BlocksHistoryIndex)
+	r.HandleFunc("/api/blocks/stuck", s.StuckBlocksIndex)
+	r.HandleFunc("/api/blocks/profits", s.BlockProfitsIndex)
+	r.HandleFunc("/api/blocks/{height:[0-9]+}/distribution", s.BlockDistributionIndex)
 	r.HandleFunc("/api/payments", s.PaymentsIndex)
+	r.HandleFunc("/api/payments/export", s.PaymentsExportIndex)
+	r.HandleFunc("/api/estimate", s.EstimateIndex)
 	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}", s.AccountIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/workers", s.WorkersIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/workers/{worker:[0-9a-zA-Z-_]{1,8}}", s.WorkerIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/ledger", s.LedgerIndex)
+	r.HandleFunc("/api/export/accounts/{login:0x[0-9a-fA-F]{40}}/payments", s.PaymentsExportAccountIndex)
+	r.HandleFunc("/api/export/accounts/{login:0x[0-9a-fA-F]{40}}/credits", s.CreditsExportIndex)
+	r.HandleFunc("/api/export/fees", s.FeesExportIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/charts/hashrate", s.MinerHashrateChartIndex)
+	r.HandleFunc("/api/charts/hashrate", s.PoolHashrateChartIndex)
+	r.HandleFunc("/api/federation/accounts/{login:0x[0-9a-fA-F]{40}}", s.FederationIndex)
+	r.HandleFunc("/federation/accounts/{login:0x[0-9a-fA-F]{40}}", s.FederationAccountIndex)
 	r.HandleFunc("/user/accounts/{login:0x[0-9a-fA-F]{40}}", s.AccountExIndex)
 	r.HandleFunc("/user/payout/{login:0x[0-9a-fA-F]{40}}/{value:[0-9]+}", s.PayoutLimitIndex)
+	r.HandleFunc("/user/memo/{login:0x[0-9a-fA-F]{40}}", s.PayoutMemoIndex).Methods("POST")
+	if s.config.MinerAuth != nil && s.config.MinerAuth.Enabled {
+		r.HandleFunc("/auth/challenge", s.AuthChallengeIndex).Methods("POST")
+		r.HandleFunc("/auth/verify", s.AuthVerifyIndex).Methods("POST")
+		r.HandleFunc("/user/settings/{login:0x[0-9a-fA-F]{40}}", s.MinerSettingsIndex)
+		r.HandleFunc("/user/email/{login:0x[0-9a-fA-F]{40}}", s.NotifyEmailIndex).Methods("POST")
+		r.HandleFunc("/user/notify/{login:0x[0-9a-fA-F]{40}}", s.NotifyOptionsIndex).Methods("POST")
+	}
 	r.HandleFunc("/signin", s.SignInIndex)
 	r.HandleFunc("/signup", s.SignupIndex)
 	r.HandleFunc("/api/reglist", s.GetAccountListIndex)
@@ -433,6 +647,10 @@ func (s *ApiServer) listen() {
 	r.HandleFunc("/api/changeacc", s.ChangeAccessIndex)
 	r.HandleFunc("/api/changepass", s.ChangePasswordIndex)
 	r.HandleFunc("/api/delaccount", s.DelAccounIndex)
+	r.HandleFunc("/api/changerole", s.ChangeRoleIndex).Methods("POST")
+	r.HandleFunc("/api/apikeys", s.ApiKeyCreateIndex).Methods("POST")
+	r.HandleFunc("/api/apikeys", s.ApiKeysIndex)
+	r.HandleFunc("/api/apikeys/{id:[0-9]+}/revoke", s.ApiKeyRevokeIndex).Methods("POST")
 
 	r.HandleFunc("/api/changealarm", s.ChangeAlarmIndex)
 	r.HandleFunc("/api/changedesc", s.ChangeDescIndex)
@@ -440,8 +658,32 @@ func (s *ApiServer) listen() {
 	r.HandleFunc("/api/applyid", s.ApplyInboundIDIndex)
 	r.HandleFunc("/api/applyip", s.ApplyInboundIPIndex)
 	r.HandleFunc("/api/applysub", s.ApplyMinerSbuIndex)
+	r.HandleFunc("/api/payoutrun", s.PayoutRunIndex).Methods("POST")
+	r.HandleFunc("/api/admin/reload", s.ConfigReloadIndex).Methods("POST")
+	r.HandleFunc("/api/admin/health", s.AdminHealthIndex)
+	r.HandleFunc("/api/payoutreports", s.PayoutReportsIndex)
+	r.HandleFunc("/api/features", s.FeaturesIndex)
+	r.HandleFunc("/api/redismemory", s.RedisMemoryIndex)
+	r.HandleFunc("/api/exchanges", s.ExchangesIndex)
+	r.HandleFunc("/api/withholding", s.WithholdingIndex)
+	r.HandleFunc("/api/exchanges/{login:0x[0-9a-fA-F]{40}}/{action:flag|unflag}", s.ExchangeActionIndex).Methods("POST")
+	r.HandleFunc("/api/features/{name}/{action:enable|disable|reset}", s.FeatureToggleIndex).Methods("POST")
+	r.HandleFunc("/api/bans", s.BansIndex)
+	r.HandleFunc("/api/bans/{kind:ip|login}/{value}/lift", s.BanLiftIndex).Methods("POST")
+	r.HandleFunc("/api/loglevels", s.LogLevelsIndex)
+	r.HandleFunc("/api/loglevels/{module}/{level:debug|info|warn|error|reset}", s.LogLevelIndex).Methods("POST")
+	r.HandleFunc("/api/payoutreports/{id:[0-9]+}", s.PayoutReportIndex)
+	r.HandleFunc("/api/payoutreports/{id:[0-9]+}/{action:approve|reject}", s.PayoutReportActionIndex).Methods("POST")
+	r.HandleFunc("/api/payoutcontracts", s.PayoutContractsIndex)
+	r.HandleFunc("/api/payoutcontracts/{login:0x[0-9a-fA-F]{40}}/{action:approve|hold}", s.PayoutContractActionIndex).Methods("POST")
+	r.HandleFunc("/api/compensations", s.CompensationImportIndex).Methods("POST")
+	r.HandleFunc("/api/compensations", s.CompensationsIndex)
+	r.HandleFunc("/api/compensations/{id:[0-9]+}", s.CompensationIndex)
+	r.HandleFunc("/api/compensations/{id:[0-9]+}/{action:approve|reject}", s.CompensationActionIndex).Methods("POST")
+	r.HandleFunc("/api/adjustments", s.AdjustmentIndex).Methods("POST")
 
 	r.HandleFunc("/health", s.Health)
+	r.HandleFunc("/i18n", s.I18nIndex)
 
 	var c *cors.Cors
 	s.allowedOrigins = make([]string, len(s.config.AllowedOrigins))
@@ -462,16 +704,15 @@ func (s *ApiServer) listen() {
 	r.NotFoundHandler = http.HandlerFunc(notFound)
 	r.Use(s.authenticationMiddleware )
 
-	var err error
-	if c != nil {
-		err = http.ListenAndServe(s.config.Listen, c.Handler(r))
-	} else {
-		err = http.ListenAndServe(s.config.Listen, r)
+	var handler http.Handler = r
+	if s.config.Compression != nil && s.config.Compression.Enabled {
+		handler = compressHandler(s.config.Compression, handler)
 	}
 
-	if err != nil {
-		log.Fatalf("Failed to start API: %v", err)
+	if c != nil {
+		return c.Handler(handler)
 	}
+	return handler
 }
 
 func notFound(w http.ResponseWriter, r *http.Request) {
@@ -485,9 +726,9 @@ func (s *ApiServer) purgeStale() {
 	start := time.Now()
 	total, err := s.backend.FlushStaleStats(s.hashrateWindow, s.hashrateLargeWindow)
 	if err != nil {
-		log.Println("Failed to purge stale data from backend:", err)
+		log.Error("Failed to purge stale data from backend:", err)
 	} else {
-		log.Printf("Purged stale stats from backend, %v shares affected, elapsed time %v", total, time.Since(start))
+		log.Infof("Purged stale stats from backend, %v shares affected, elapsed time %v", total, time.Since(start))
 	}
 }
 
@@ -518,7 +759,7 @@ func (s *ApiServer) deleteDB() {
 		queryStart := time.Now()
 		result := s.db.DeleteBlockBalance(minSeq, tmpMax)
 
-		log.Printf("(%v) Deletes data from %d to %d in the credits_balance table. Rows Affected: %v", time.Since(queryStart), minSeq, tmpMax, result)
+		log.Infof("(%v) Deletes data from %d to %d in the credits_balance table. Rows Affected: %v", time.Since(queryStart), minSeq, tmpMax, result)
 
 		minSeq += deleteMaxRecord
 		count++
@@ -531,14 +772,14 @@ func (s *ApiServer) deleteDB() {
 func (s *ApiServer) collectStats() {
 	start := time.Now()
 	stats, err := s.backend.CollectStats(s.hashrateWindow, s.config.Blocks, s.config.Payments)
-	if err != nil {
-		log.Printf("Failed to fetch stats from backend: %v", err)
+	if metrics.RedisError(s.config.Name, "collect_stats", err) != nil {
+		log.Errorf("Failed to fetch stats from backend: %v", err)
 		return
 	}
 	if len(s.config.LuckWindow) > 0 {
 		stats["luck"], err = s.backend.CollectLuckStats(s.config.LuckWindow)
 		if err != nil {
-			log.Printf("Failed to fetch luck stats from backend: %v", err)
+			log.Errorf("Failed to fetch luck stats from backend: %v", err)
 			return
 		}
 	}
@@ -549,38 +790,81 @@ func (s *ApiServer) collectStats() {
 	depth := s.config.Depth * 2
 	minHeight := currentHeight-depth-100
 	stats["poolBalanceOnce"], sqlCount,_ = s.db.GetPoolBalanceByOnce(currentHeight-depth, minHeight, s.config.Coin)
+	if s.price != nil {
+		stats["price"] = s.priceStats()
+	}
+	if net, err := s.collectNetwork(); err != nil {
+		log.Errorf("Failed to collect the chain head: %v", err)
+	} else if net != nil {
+		s.network.Store(net)
+	}
+	if rates, err := s.collectPayoutRates(); err != nil {
+		log.Errorf("Failed to collect payout rates: %v", err)
+	} else if rates != nil {
+		stats["payoutRates"] = rates
+	}
+	stats[statsVersionKey] = util.MakeTimestamp()
 	s.stats.Store(stats)
+	s.reportMetrics(stats)
+	s.checkAlerts(stats)
+	s.detectExchanges(stats)
+	s.detectWithholding()
+	s.pushStats(stats)
 
-	log.Printf("Stats collection finished %s poolEarnPerDay(%v,%v,%v,%v)", time.Since(start), stats["poolBalanceOnce"], sqlCount, minHeight, currentHeight-depth)
+	log.Infof("Stats collection finished %s poolEarnPerDay(%v,%v,%v,%v)", time.Since(start), stats["poolBalanceOnce"], sqlCount, minHeight, currentHeight-depth)
+}
+
+func (s *ApiServer) reportMetrics(stats map[string]interface{}) {
+	if v, ok := stats["hashrate"].(int64); ok {
+		metrics.PoolHashrate.Set(float64(v), s.config.Name)
+	}
+	if v, ok := stats["minersTotal"].(int); ok {
+		metrics.PoolMiners.Set(float64(v), s.config.Name)
+	}
+	if v, ok := stats["candidatesTotal"].(int); ok {
+		metrics.PoolCandidates.Set(float64(v), s.config.Name)
+	}
+	if regions, ok := stats["regions"].(map[string]*redis.RegionStats); ok {
+		metrics.RegionHashrate.Reset(s.config.Name)
+		for region, v := range regions {
+			metrics.RegionHashrate.Set(float64(v.Hashrate), s.config.Name, region)
+		}
+	}
 }
 
 func (s *ApiServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Cache-Control", "no-cache")
-	w.WriteHeader(http.StatusOK)
 
 	reply := make(map[string]interface{})
 	nodes, err := s.backend.GetNodeStates()
 	if err != nil {
-		log.Printf("Failed to get nodes stats from backend: %v", err)
+		log.Errorf("Failed to get nodes stats from backend: %v", err)
 	}
 	reply["nodes"] = nodes
 
 	stats := s.getStats()
+	if notModified(w, r, statsVersion(stats), nodes) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 	if stats != nil {
 		reply["now"] = util.MakeTimestamp()
 		reply["stats"] = stats["stats"]
 		reply["poolCharts"] = stats["poolCharts"]
 		reply["hashrate"] = stats["hashrate"]
+		reply["regions"] = stats["regions"]
 		reply["minersTotal"] = stats["minersTotal"]
 		reply["maturedTotal"] = stats["maturedTotal"]
 		reply["immatureTotal"] = stats["immatureTotal"]
 		reply["candidatesTotal"] = stats["candidatesTotal"]
+		reply["price"] = stats["price"]
+		reply["payoutRates"] = stats["payoutRates"]
 	}
 
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -588,10 +872,13 @@ func (s *ApiServer) MinersIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	//w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cache-Control", "no-cache")
-	w.WriteHeader(http.StatusOK)
 
 	reply := make(map[string]interface{})
 	stats := s.getStats()
+	if notModified(w, r, statsVersion(stats)) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 	if stats != nil {
 		reply["now"] = util.MakeTimestamp()
 		reply["miners"] = stats["miners"]
@@ -601,7 +888,7 @@ func (s *ApiServer) MinersIndex(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -609,10 +896,13 @@ func (s *ApiServer) BlocksIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	//w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cache-Control", "no-cache")
-	w.WriteHeader(http.StatusOK)
 
 	reply := make(map[string]interface{})
 	stats := s.getStats()
+	if notModified(w, r, statsVersion(stats)) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 	if stats != nil {
 		reply["matured"] = stats["matured"]
 		reply["maturedTotal"] = stats["maturedTotal"]
@@ -625,7 +915,7 @@ func (s *ApiServer) BlocksIndex(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -633,10 +923,13 @@ func (s *ApiServer) PaymentsIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	//w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cache-Control", "no-cache")
-	w.WriteHeader(http.StatusOK)
 
 	reply := make(map[string]interface{})
 	stats := s.getStats()
+	if notModified(w, r, statsVersion(stats)) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 	if stats != nil {
 		reply["payments"] = stats["payments"]
 		reply["paymentsTotal"] = stats["paymentsTotal"]
@@ -644,7 +937,7 @@ func (s *ApiServer) PaymentsIndex(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -663,7 +956,26 @@ func (s *ApiServer) Health(w http.ResponseWriter, r *http.Request) {
 		"status":"ok",
 	})
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// I18nIndex returns status names, units and messages in the requested locale.
+func (s *ApiServer) I18nIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	locale := w.Header().Get("Content-Language")
+	reply := make(map[string]interface{})
+	reply["locale"] = locale
+	reply["default"] = s.i18n.Default()
+	reply["locales"] = s.i18n.Locales()
+	reply["messages"] = s.i18n.Messages(locale)
+
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(reply)
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -685,7 +997,7 @@ func (s *ApiServer) AccountIndex(w http.ResponseWriter, r *http.Request) {
 		exist, setPayout, err := s.db.IsMinerExists(login)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Failed to fetch stats from backend: %v", err)
+			log.Errorf("Failed to fetch stats from backend: %v", err)
 			return
 		}
 		if !exist {
@@ -696,14 +1008,14 @@ func (s *ApiServer) AccountIndex(w http.ResponseWriter, r *http.Request) {
 		stats, err := s.backend.GetMinerStats(login, s.config.Payments)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Failed to fetch stats from backend: %v", err)
+			log.Errorf("Failed to fetch stats from backend: %v", err)
 			return
 		}
 		reportedHash, _ := s.backend.GetReportedtHashrate(login)
 		workers, err := s.backend.CollectWorkersAllStats(s.hashrateWindow, s.hashrateLargeWindow, login, reportedHash)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Failed to fetch stats from backend: %v", err)
+			log.Errorf("Failed to fetch stats from backend: %v", err)
 			return
 		}
 
@@ -711,9 +1023,28 @@ func (s *ApiServer) AccountIndex(w http.ResponseWriter, r *http.Request) {
 			stats[key] = value
 		}
 		stats["pageSize"] = s.config.Payments
-		stats["minPayout"] = s.config.Threshold
-		stats["maxPayout"] = s.config.Threshold * 100
+		stats["minPayout"] = s.config.MinPayoutLimit
+		stats["maxPayout"] = s.config.MaxPayoutLimit
 		stats["setPayout"] = setPayout
+		stats["payoutMemo"], err = s.db.GetPayoutMemo(login)
+		if err != nil {
+			log.Errorf("Failed to fetch payout memo of %v: %v", login, err)
+		}
+		exchange, err := s.backend.IsExchangeAddress(login)
+		if err != nil {
+			log.Errorf("Failed to check exchange address %v: %v", login, err)
+		}
+		workerStats, _ := workers["workers"].(map[string]redis.Worker)
+		stats["exchange"] = exchange
+		stats["warnings"] = accountWarnings(exchange, workerStats)
+		stats["rejects"], err = s.backend.GetRejectHistory(login, ts)
+		if err != nil {
+			log.Errorf("Failed to fetch reject history of %v: %v", login, err)
+		}
+		stats["sessions"], err = s.backend.GetWorkerSessions(login)
+		if err != nil {
+			log.Errorf("Failed to fetch sessions of %v: %v", login, err)
+		}
 		stats["minerCharts"], err = s.db.GetMinerCharts(s.config.MinerChartsNum, s.minerPoolChartIntv, login, ts)
 		//stats["minerCharts"], err = s.backend.GetMinerCharts(s.config.MinerChartsNum, login)
 		//stats["paymentCharts"], err = s.backend.GetPaymentCharts(login)
@@ -723,16 +1054,24 @@ func (s *ApiServer) AccountIndex(w http.ResponseWriter, r *http.Request) {
 			stats["hashrateTotal"] = statsM["hashrate"]
 			stats["minersTotal"] = statsM["minersTotal"]
 			stats["poolBalanceOnce"] = statsM["poolBalanceOnce"]
+			stats["price"] = statsM["price"]
+			info, _ := stats["stats"].(map[string]interface{})
+			hashrate, _ := workers["currentHashrate"].(int64)
+			rates, _ := statsM["payoutRates"].(*payoutRates)
+			stats["payoutEta"] = s.estimatePayout(info, setPayout, hashrate, rates, time.Unix(ts, 0))
 		}
 
 		reply = &Entry{stats: stats, updatedAt: now}
 		s.miners[login] = reply
 	}
 
+	if notModified(w, r, reply.updatedAt) {
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(reply.stats)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -761,7 +1100,7 @@ func (s *ApiServer) AccountExIndex(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if !exist {
-			s.WirteResponseData(w, http.StatusNotFound, "non-existent minor:" + login)
+			s.WirteResponseData(w, http.StatusNotFound, "non-existent minor:%v", login)
 			return
 		}
 
@@ -774,7 +1113,7 @@ func (s *ApiServer) AccountExIndex(w http.ResponseWriter, r *http.Request) {
 		workers, err := s.backend.CollectWorkersStats(s.hashrateWindow, s.hashrateLargeWindow, login, reportedHash)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Failed to fetch stats from backend: %v", err)
+			log.Errorf("Failed to fetch stats from backend: %v", err)
 			return
 		}
 
@@ -785,8 +1124,8 @@ func (s *ApiServer) AccountExIndex(w http.ResponseWriter, r *http.Request) {
 			setPayout = s.config.Threshold
 		}
 		stats["pageSize"] = s.config.Payments
-		stats["minPayout"] = s.config.Threshold
-		stats["maxPayout"] = s.config.Threshold * 100
+		stats["minPayout"] = s.config.MinPayoutLimit
+		stats["maxPayout"] = s.config.MaxPayoutLimit
 		stats["setPayout"] = setPayout
 		stats["minerCharts"], err = s.db.GetMinerCharts(s.config.MinerChartsNum, s.minerPoolChartIntv, login, ts)
 		//stats["minerCharts"], err = s.backend.GetMinerCharts(s.config.MinerChartsNum, login)
@@ -798,6 +1137,7 @@ func (s *ApiServer) AccountExIndex(w http.ResponseWriter, r *http.Request) {
 			stats["hashrateTotal"] = statsM["hashrate"]
 			stats["minersTotal"] = statsM["minersTotal"]
 			stats["poolBalanceOnce"] = statsM["poolBalanceOnce"]
+			stats["price"] = statsM["price"]
 		}
 
 		reply = &Entry{stats: stats, updatedAt: now}
@@ -806,10 +1146,13 @@ func (s *ApiServer) AccountExIndex(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("test time: %v\n", time.Since(nowtime))
 
+	if notModified(w, r, reply.updatedAt) {
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(reply.stats)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -827,8 +1170,8 @@ func (s *ApiServer) PayoutLimitIndex(w http.ResponseWriter, r *http.Request) {
 		s.WirteResponseData(w, http.StatusBadRequest,"Failed to set payout value error:%v",err)
 		return
 	}
-	minPayout := s.config.Threshold
-	maxPayout := s.config.Threshold * 100
+	minPayout := s.config.MinPayoutLimit
+	maxPayout := s.config.MaxPayoutLimit
 	if setPayout != 0 {	// Default if 0
 		if setPayout < minPayout {
 			s.WirteResponseData(w, http.StatusBadRequest, "Failed to UpdatePayoutLimit:payout out of range(min:%v)", minPayout)
@@ -840,7 +1183,7 @@ func (s *ApiServer) PayoutLimitIndex(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if !s.db.UpdatePayoutLimit(login, value) {
+	if !s.db.UpdatePayoutLimit(login, strconv.FormatInt(setPayout, 10)) {
 		s.WirteResponseData(w, http.StatusInternalServerError, "Failed to UpdatePayoutLimit (%v)",login)
 		return
 	}
@@ -850,20 +1193,21 @@ func (s *ApiServer) PayoutLimitIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
 func (s *ApiServer) WirteResponseData(w http.ResponseWriter, status int, format string, v ...interface{}) {
 	msg := fmt.Sprintf(format, v...)
-	log.Printf(msg)
+	log.Infof(msg)
 
 	reply := make(map[string]interface{})
 	reply["msg"] = msg
+	reply["text"] = s.localize(w, format, v...)
 	w.WriteHeader(status)
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -885,20 +1229,20 @@ func (s *ApiServer) SignInIndex(w http.ResponseWriter, r *http.Request) {
 
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	passDb, access, err := s.db.GetAccountPassword(user.Username)
 	if err != nil {
-		log.Printf("failed to DB Connected: %v", err)
+		log.Errorf("failed to DB Connected: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if !util.CheckPasswordHash(passDb, user.Password) {
-		log.Printf("failed to password is different: %v", err)
+		log.Warnf("failed to password is different: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string {
 			"error": fmt.Sprintf("password is different: %v", err),
@@ -907,10 +1251,15 @@ func (s *ApiServer) SignInIndex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// permission check
-
+	role, err := s.db.GetAccountRole(user.Username)
+	if err != nil {
+		log.Errorf("failed to DB Connected: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	// Token Issuance
-	token, _ := s.CreateUserToken(user.Username, access, basicTokenExpiration)
+	token, _ := s.CreateUserToken(user.Username, access, role, basicTokenExpiration)
 
 	tokenSplit := strings.Split(token,".")
 	if len(tokenSplit) != 3 {
@@ -934,7 +1283,7 @@ func (s *ApiServer) SignInIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -956,7 +1305,7 @@ func (s *ApiServer) GetTokenIndex(w http.ResponseWriter, r *http.Request) {
 
 	var userToken UserToken
 	if err := json.NewDecoder(r.Body).Decode(&userToken); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -964,7 +1313,7 @@ func (s *ApiServer) GetTokenIndex(w http.ResponseWriter, r *http.Request) {
 	var tokenExp = basicTokenExpiration
 	if userToken.DevId != "all" {
 		if !util.IsValidHexAddress(userToken.DevId) {
-			log.Printf("failed to DevId: %v", userToken.DevId)
+			log.Warnf("failed to DevId: %v", userToken.DevId)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -975,13 +1324,13 @@ func (s *ApiServer) GetTokenIndex(w http.ResponseWriter, r *http.Request) {
 
 	passDb, access, err := s.db.GetAccountPassword(userToken.Username)
 	if err != nil {
-		log.Printf("failed to DB Connected: %v", err)
+		log.Errorf("failed to DB Connected: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if !util.CheckPasswordHash(passDb, userToken.Password) {
-		log.Printf("failed to password is different: %v", err)
+		log.Warnf("failed to password is different: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string {
 			"error": fmt.Sprintf("password is different: %v", err),
@@ -1017,7 +1366,7 @@ func (s *ApiServer) GetTokenIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1025,6 +1374,7 @@ type User struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Access	string `json:"access"`
+	Role	string `json:"role"`
 }
 
 type UserToken struct {
@@ -1056,7 +1406,7 @@ func (s *ApiServer) InboundListIndex(w http.ResponseWriter, r *http.Request) {
 	inboundList, err := s.db.GetIpInboundList()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Failed to GetIpInboundList()")
+		log.Errorf("Failed to GetIpInboundList()")
 		return
 	}
 
@@ -1066,7 +1416,7 @@ func (s *ApiServer) InboundListIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1077,13 +1427,13 @@ func (s *ApiServer) SaveInboundIndex(w http.ResponseWriter, r *http.Request) {
 
 	var ipInbound DbIPInbound
 	if err := json.NewDecoder(r.Body).Decode(&ipInbound); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	// validation data
 	if !util.StringInSlice(ipInbound.Rule,[]string{"allow", "deny"}) {
-		log.Printf("failed to incorrect value: %v", ipInbound.Rule)
+		log.Warnf("failed to incorrect value: %v", ipInbound.Rule)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1104,7 +1454,7 @@ func (s *ApiServer) SaveInboundIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1116,7 +1466,7 @@ func (s *ApiServer) DelInboundIndex(w http.ResponseWriter, r *http.Request) {
 
 	var ipInbound DbIPInbound
 	if err := json.NewDecoder(r.Body).Decode(&ipInbound); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1139,7 +1489,7 @@ func (s *ApiServer) DelInboundIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1153,7 +1503,7 @@ func (s *ApiServer) DevIdInboundListIndex(w http.ResponseWriter, r *http.Request
 	idboundList, err := s.db.GetIdInboundList()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Failed to GetIdInboundList()")
+		log.Errorf("Failed to GetIdInboundList()")
 		return
 	}
 
@@ -1163,7 +1513,7 @@ func (s *ApiServer) DevIdInboundListIndex(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1175,7 +1525,7 @@ func (s *ApiServer) SaveDevIdInboundIndex(w http.ResponseWriter, r *http.Request
 
 	var ipInbound DbIPInbound
 	if err := json.NewDecoder(r.Body).Decode(&ipInbound); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1189,7 +1539,7 @@ func (s *ApiServer) SaveDevIdInboundIndex(w http.ResponseWriter, r *http.Request
 	}
 	var ok bool
 	if ipInbound.Ip, ok = util.CheckValidHexAddress(ipInbound.Ip); !ok {
-		log.Printf("failed to DevId: %v", ipInbound.Ip)
+		log.Warnf("failed to DevId: %v", ipInbound.Ip)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1210,7 +1560,7 @@ func (s *ApiServer) SaveDevIdInboundIndex(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1222,7 +1572,7 @@ func (s *ApiServer) DelIDboundIndex(w http.ResponseWriter, r *http.Request) {
 
 	var idInbound DbIPInbound
 	if err := json.NewDecoder(r.Body).Decode(&idInbound); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1245,7 +1595,7 @@ func (s *ApiServer) DelIDboundIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1257,7 +1607,7 @@ func (s *ApiServer) GetLikeDevSubListIndex(w http.ResponseWriter, r *http.Reques
 
 	var devSubList DevSubList
 	if err := json.NewDecoder(r.Body).Decode(&devSubList); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1271,7 +1621,7 @@ func (s *ApiServer) GetLikeDevSubListIndex(w http.ResponseWriter, r *http.Reques
 
 	devList, err := s.db.GetLikeMinerSubList(devSubList.DevId)
 	if err != nil {
-		log.Printf("Failed to GetLikeMinerSubList()")
+		log.Errorf("Failed to GetLikeMinerSubList()")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -1282,7 +1632,7 @@ func (s *ApiServer) GetLikeDevSubListIndex(w http.ResponseWriter, r *http.Reques
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1294,7 +1644,7 @@ func (s *ApiServer) SaveSubIdIndex(w http.ResponseWriter, r *http.Request) {
 
 	var devSubList DevSubList
 	if err := json.NewDecoder(r.Body).Decode(&devSubList); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1302,13 +1652,13 @@ func (s *ApiServer) SaveSubIdIndex(w http.ResponseWriter, r *http.Request) {
 	ok := false
 	// validation data
 	if devSubList.DevId, ok = util.CheckValidHexAddress(devSubList.DevId); !ok {
-		log.Printf("failed to DevId: %v", devSubList.DevId)
+		log.Warnf("failed to DevId: %v", devSubList.DevId)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if devSubList.SubId, ok = util.CheckValidHexAddress(devSubList.SubId); !ok {
-		log.Printf("failed to SubId: %v", devSubList.SubId)
+		log.Warnf("failed to SubId: %v", devSubList.SubId)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1320,7 +1670,7 @@ func (s *ApiServer) SaveSubIdIndex(w http.ResponseWriter, r *http.Request) {
 	// Get the quantity and set the max value
 	devList, err := s.db.GetMinerSubInfo(lowerDevId)
 	if err != nil {
-		log.Printf("Failed to GetMinerSubInfo()")
+		log.Errorf("Failed to GetMinerSubInfo()")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -1345,7 +1695,7 @@ func (s *ApiServer) SaveSubIdIndex(w http.ResponseWriter, r *http.Request) {
 	addCount += amount
 	devTotalCount += amount
 	if devTotalCount > 18 || devTotalCount < 1{
-		log.Printf("Exceeding max dev count: %v",devTotalCount)
+		log.Warnf("Exceeding max dev count: %v",devTotalCount)
 		s.ErrorWrite(w, "Exceeding max dev count")
 		return
 	}
@@ -1370,7 +1720,7 @@ func (s *ApiServer) SaveSubIdIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1382,7 +1732,7 @@ func (s *ApiServer) DelSubIdIndex(w http.ResponseWriter, r *http.Request) {
 
 	var devSubList DevSubList
 	if err := json.NewDecoder(r.Body).Decode(&devSubList); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1390,13 +1740,13 @@ func (s *ApiServer) DelSubIdIndex(w http.ResponseWriter, r *http.Request) {
 	ok := false
 	// validation data
 	if devSubList.DevId, ok = util.CheckValidHexAddress(devSubList.DevId); !ok {
-		log.Printf("failed to DevId: %v", devSubList.DevId)
+		log.Warnf("failed to DevId: %v", devSubList.DevId)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if devSubList.SubId, ok = util.CheckValidHexAddress(devSubList.SubId); !ok {
-		log.Printf("failed to SubId: %v", devSubList.SubId)
+		log.Warnf("failed to SubId: %v", devSubList.SubId)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1415,7 +1765,7 @@ func (s *ApiServer) DelSubIdIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1426,27 +1776,27 @@ func (s *ApiServer) AddAccountIndex(w http.ResponseWriter, r *http.Request) {
 
 	var userToken UserToken
 	if err := json.NewDecoder(r.Body).Decode(&userToken); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// validation data
 	if !util.IsValidUsername(userToken.Username) {
-		log.Printf("failed to Username: %v", userToken.Username)
+		log.Warnf("failed to Username: %v", userToken.Username)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	hashedPassword, err := util.HashPassword(userToken.Password)
 	if err != nil {
-		log.Printf("failed to GenerateFromPassword: %v", err)
+		log.Errorf("failed to GenerateFromPassword: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	if !s.db.CreateAccount(userToken.Username, hashedPassword, "none") {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Failed to CreateAccount()")
+		log.Errorf("Failed to CreateAccount()")
 		return
 	}
 
@@ -1455,7 +1805,7 @@ func (s *ApiServer) AddAccountIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1467,26 +1817,26 @@ func (s *ApiServer) ChangeAccessIndex(w http.ResponseWriter, r *http.Request) {
 
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// validation data
 	if !util.IsValidUsername(user.Username) {
-		log.Printf("failed to Username: %v", user.Username)
+		log.Warnf("failed to Username: %v", user.Username)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	if !util.StringInSlice(user.Access,[]string{"none", "all", "user"}) {
-		log.Printf("failed to incorrect value: %v", user.Access)
+		log.Warnf("failed to incorrect value: %v", user.Access)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if !s.db.ChangeAccountAccess(user.Username, user.Access) {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Failed to ChangeAccountAccess()")
+		log.Errorf("Failed to ChangeAccountAccess()")
 		return
 	}
 
@@ -1495,7 +1845,7 @@ func (s *ApiServer) ChangeAccessIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1506,27 +1856,27 @@ func (s *ApiServer) ChangePasswordIndex(w http.ResponseWriter, r *http.Request)
 
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// validation data
 	if !util.IsValidUsername(user.Username) {
-		log.Printf("failed to Username: %v", user.Username)
+		log.Warnf("failed to Username: %v", user.Username)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	hashedPassword, err := util.HashPassword(user.Password)
 	if err != nil {
-		log.Printf("failed to GenerateFromPassword: %v", err)
+		log.Errorf("failed to GenerateFromPassword: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	if !s.db.ChangeAccountPassword(user.Username, hashedPassword) {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Failed to ChangePasswordIndex()")
+		log.Errorf("Failed to ChangePasswordIndex()")
 		return
 	}
 
@@ -1535,7 +1885,7 @@ func (s *ApiServer) ChangePasswordIndex(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1547,21 +1897,21 @@ func (s *ApiServer) DelAccounIndex(w http.ResponseWriter, r *http.Request) {
 
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// validation data
 	if !util.IsValidUsername(user.Username) {
-		log.Printf("failed to Username: %v", user.Username)
+		log.Warnf("failed to Username: %v", user.Username)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if !s.db.DeleteAccount(user.Username) {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Failed to DelAccounIndex()")
+		log.Errorf("Failed to DelAccounIndex()")
 		return
 	}
 
@@ -1570,7 +1920,7 @@ func (s *ApiServer) DelAccounIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1578,10 +1928,11 @@ func (s *ApiServer) ErrorWrite(w http.ResponseWriter, errorStr string) {
 	reply := make(map[string]interface{})
 	reply["state"] = "false"
 	reply["msg"] = errorStr
+	reply["text"] = s.localize(w, errorStr)
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1591,31 +1942,31 @@ func (s *ApiServer) SignupIndex(w http.ResponseWriter, r *http.Request) {
 	//w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cache-Control", "no-cache")
 
-	log.Println("Sign up")
+	log.Debug("Sign up")
 	var user User
 
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// validation data
 	if !util.IsValidUsername(user.Username) {
-		log.Printf("failed to Username: %v", user.Username)
+		log.Warnf("failed to Username: %v", user.Username)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	hashedPassword, err := util.HashPassword(user.Password)
 	if err != nil {
-		log.Printf("failed to GenerateFromPassword: %v", err)
+		log.Errorf("failed to GenerateFromPassword: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 
 	if !s.db.CreateAccount(user.Username, hashedPassword, "none") {
-		log.Printf("Failed to CreateAccount()")
+		log.Errorf("Failed to CreateAccount()")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -1625,7 +1976,7 @@ func (s *ApiServer) SignupIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1635,11 +1986,11 @@ func (s *ApiServer) GetAccountListIndex(w http.ResponseWriter, r *http.Request)
 	//w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cache-Control", "no-cache")
 
-	log.Println("GetAccountListIndex")
+	log.Debug("GetAccountListIndex")
 
 	userInfo, err:= s.db.GetAccountList()
 	if err != nil {
-		log.Printf("Failed to GetAccountList()")
+		log.Errorf("Failed to GetAccountList()")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -1653,7 +2004,7 @@ func (s *ApiServer) GetAccountListIndex(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1667,33 +2018,67 @@ func (s *ApiServer) getStats() map[string]interface{} {
 
 func (s *ApiServer) collectPoolCharts() {
 	ts := util.MakeTimestamp() / 1000
-	now := time.Now()
-	year, month, day := now.Date()
-	hour, min, _ := now.Clock()
-	t2 := fmt.Sprintf("%d-%02d-%02d %02d_%02d", year, month, day, hour, min)
+	t2 := util.FormatDisplayTime(ts, "2006-01-02 15_04")
 	stats := s.getStats()
 	hash := fmt.Sprint(stats["hashrate"])
-	log.Println("Pool Hash is ", ts, t2, hash)
+	log.Debug("Pool Hash is ", ts, t2, hash)
 	err := s.backend.WritePoolCharts(ts, t2, hash)
 	if err != nil {
-		log.Printf("Failed to fetch pool charts from backend: %v", err)
+		log.Errorf("Failed to fetch pool charts from backend: %v", err)
+		return
+	}
+	hashrate, _ := strconv.ParseInt(hash, 10, 64)
+	s.addHashrateSample("", ts, hashrate)
+	if s.anomaly != nil {
+		s.checkHashrateAnomaly(ts, hashrate)
+	}
+}
+
+// startAnomalyDetector seeds the hashrate baseline from the stored pool charts.
+func (s *ApiServer) startAnomalyDetector() {
+	s.anomaly = anomaly.New(s.config.Anomaly)
+	charts, err := s.backend.GetPoolCharts(int64(s.config.Anomaly.Window))
+	if err != nil {
+		log.Errorf("Failed to seed hashrate anomaly detector: %v", err)
+		return
+	}
+	hashrates := make([]int64, 0, len(charts))
+	for i := len(charts) - 1; i >= 0; i-- {
+		hashrates = append(hashrates, charts[i].PoolHash)
+	}
+	s.anomaly.Seed(hashrates)
+	log.Infof("Hashrate anomaly detection seeded with %v samples", len(hashrates))
+}
+
+func (s *ApiServer) checkHashrateAnomaly(ts int64, hashrate int64) {
+	a := s.anomaly.Add(ts, hashrate)
+	if a == nil {
 		return
 	}
+	err := s.backend.WritePoolChartAnomaly(a.Timestamp, a.Kind, a.Change)
+	if err != nil {
+		log.Errorf("Failed to annotate pool charts: %v", err)
+	}
+
+	key := "Pool hashrate %v: %+.1f%% (%v -> %v H/s)"
+	msg := fmt.Sprintf(key, a.Kind, a.Change*100, a.Previous, a.Hashrate)
+	s.logs.InsertLog(fmt.Sprintf("%v, %.1f sigma", msg, a.Sigma), plogger.LogTypeSystem, plogger.LogSubTypeHashrateAnomaly, 0, 0, "", "")
+	if s.alarm != nil {
+		s.alarm.Notify(key, s.alarm.Translate(a.Kind), a.Change*100, a.Previous, a.Hashrate)
+	}
 }
 
 func (s *ApiServer) collectMinerCharts(login string, hash int64, largeHash int64, workerOnline int64, share int64, report int64) {
 	ts := util.MakeTimestamp() / 1000
-	now := time.Now()
-	year, month, day := now.Date()
-	hour, min, _ := now.Clock()
-	t2 := fmt.Sprintf("%d-%02d-%02d %02d_%02d", year, month, day, hour, min)
+	t2 := util.FormatDisplayTime(ts, "2006-01-02 15_04")
 
 	//log.Println("Miner "+login+" Hash is", ts, t2, hash, largeHash, share, report)
 	err := s.db.WriteMinerCharts(ts, t2, login, hash, largeHash, workerOnline, share, report)
 	// err := s.backend.WriteMinerCharts(ts, t2, login, hash, largeHash, workerOnline, share, report)
 	if err != nil {
-		log.Printf("Failed to fetch miner %v charts from backend: %v", login, err)
+		log.Errorf("Failed to fetch miner %v charts from backend: %v", login, err)
 	}
+	s.addHashrateSample(login, ts, hash)
 }
 
 func (s *ApiServer) CreateToken(devId, access string, expirationMin int64) (string, error) {
@@ -1712,13 +2097,14 @@ func (s *ApiServer) CreateToken(devId, access string, expirationMin int64) (stri
 	return token, nil
 }
 
-func (s *ApiServer) CreateUserToken(id, access string, expirationMin int64) (string, error) {
+func (s *ApiServer) CreateUserToken(id, access, role string, expirationMin int64) (string, error) {
 	var err error
 	//Creating Access Token
 	atClaims := jwt.MapClaims{}
 	atClaims["authorized"] = true
 	atClaims["user_id"] = id
 	atClaims["access"] = access
+	atClaims["role"] = role
 	atClaims["exp"] = time.Now().Add(time.Minute * time.Duration(expirationMin)).Unix()
 	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
 	token, err := at.SignedString([]byte(s.config.AccessSecret))
@@ -1740,6 +2126,7 @@ func (s *ApiServer) ApplyInboundIDIndex(w http.ResponseWriter, r *http.Request)
 		err = json.NewEncoder(w).Encode(map[string]string {
 			"status":"fail",
 			"msg":"Failed to send to proxy server",
+			"text":s.localize(w, "Failed to send to proxy server"),
 		})
 		return
 	}
@@ -1763,7 +2150,7 @@ func (s *ApiServer) ApplyInboundIDIndex(w http.ResponseWriter, r *http.Request)
 		"status":"ok",
 	})
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1796,7 +2183,7 @@ func (s *ApiServer) ApplyInboundIPIndex(w http.ResponseWriter, r *http.Request)
 		"status":"ok",
 	})
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1820,7 +2207,463 @@ func (s *ApiServer) ApplyMinerSbuIndex(w http.ResponseWriter, r *http.Request) {
 		"status":"ok",
 	})
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// PayoutRunIndex asks the payout module to run now, outside of its payout windows.
+func (s *ApiServer) PayoutRunIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	receivers, err := s.backend.Publish(redis.ChannelPayout, redis.OpcodePayoutRun, "", redis.ChannelApi)
+	if err != nil || receivers == 0 {
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(map[string]string {
+			"status":"fail",
+			"msg":"Failed to send to payout server",
+			"text":s.localize(w, "Failed to send to payout server"),
+		})
+		return
+	}
+	s.logs.InsertLog(fmt.Sprintf("MANUAL PAYOUT RUN requested by %v", r.Header.Get("login")), plogger.LogTypePaymentWork, plogger.LogErrorNothing, 0, 0, "", "")
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string {
+		"status":"ok",
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// ConfigReloadIndex asks every process of the pool to reload its config file, like a SIGHUP.
+func (s *ApiServer) ConfigReloadIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	receivers, err := s.backend.Publish(redis.ChannelConfig, redis.OpcodeReload, "", redis.ChannelApi)
+	if err != nil || receivers == 0 {
+		if err != nil {
+			log.Errorf("Failed to publish a config reload: %v", err)
+		}
+		s.ErrorWrite(w, "No process received the reload")
+		return
+	}
+	s.logs.InsertLog(fmt.Sprintf("CONFIG RELOAD requested by %v", r.Header.Get("login")), plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "ok",
+		"receivers": receivers,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// PayoutReportsIndex lists the latest payout reports.
+func (s *ApiServer) PayoutReportsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	reports, err := s.db.GetPayoutReports(50)
+	if err != nil {
+		s.ErrorWrite(w, "Failed to load payout reports")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"reports": reports,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// PayoutReportIndex returns a payout report with its recipients.
+func (s *ApiServer) PayoutReportIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	report, err := s.db.GetPayoutReport(id)
+	if err != nil {
+		s.ErrorWrite(w, "Failed to load payout reports")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"summary": report,
+		"report":  json.RawMessage(report.Report),
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// PayoutReportActionIndex approves or rejects a pending payout report.
+// An approved report is paid right away when the payout module is running, otherwise on its next run.
+func (s *ApiServer) PayoutReportActionIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	action := mux.Vars(r)["action"]
+	login := r.Header.Get("login")
+
+	state := mysql.ReportApproved
+	if action == "reject" {
+		state = mysql.ReportRejected
+	}
+	ok, err := s.db.UpdatePayoutReportState(id, mysql.ReportPending, state, login)
+	if err != nil || !ok {
+		s.ErrorWrite(w, "Payout report is not waiting for approval")
+		return
+	}
+	s.logs.InsertLog(fmt.Sprintf("PAYOUT REPORT #%v %v by %v", id, state, login), plogger.LogTypePaymentWork, plogger.LogErrorNothing, 0, 0, "", "")
+
+	if state == mysql.ReportApproved {
+		receivers, err := s.backend.Publish(redis.ChannelPayout, redis.OpcodePayoutRun, "", redis.ChannelApi)
+		if err != nil || receivers == 0 {
+			log.Warnf("Payout report #%v approved, no payout module is running: %v", id, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string {
+		"status":"ok",
+		"state":state,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// CompensationImportIndex validates a correction file and stores it for approval.
+func (s *ApiServer) CompensationImportIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 16 << 20))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	file, err := payouts.ParseCorrection(data, s.config.CompensationMaxTotal)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(map[string]string {
+			"state":"false",
+			"msg":"Invalid correction file",
+			"text":s.localize(w, "Invalid correction file"),
+			"error":err.Error(),
+		})
+		if err != nil {
+			log.Error("Error serializing API response: ", err)
+		}
+		return
+	}
+
+	login := r.Header.Get("login")
+	compensation, items := file.Record(login)
+	id, err := s.db.WriteCompensation(compensation, items)
+	if err != nil {
+		log.Errorf("Failed to store compensation %v: %v", file.Key, err)
+		s.ErrorWrite(w, "Correction file already imported")
+		return
+	}
+	s.logs.InsertLog(fmt.Sprintf("COMPENSATION #%v %v imported by %v: %v miners, total %v", id, file.Key, login, len(items), file.Total),
+		plogger.LogTypePaymentWork, plogger.LogSubTypePaymentCompensation, 0, 0, "", "")
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"id":     id,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// CompensationsIndex lists the latest compensations.
+func (s *ApiServer) CompensationsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	list, err := s.db.GetCompensations("", 50)
+	if err != nil {
+		s.ErrorWrite(w, "Failed to load compensations")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"compensations": list,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// CompensationIndex returns a compensation with the state of each miner's delta.
+func (s *ApiServer) CompensationIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	compensation, err := s.db.GetCompensation(id)
+	if err != nil {
+		s.ErrorWrite(w, "Failed to load compensations")
+		return
+	}
+	items, err := s.db.GetCompensationItems(id, false)
+	if err != nil {
+		s.ErrorWrite(w, "Failed to load compensations")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"summary": compensation,
+		"items":   items,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// LedgerIndex returns a miner's ledger, newest first, with the totals by reason. Older entries are paged
+// with ?before=<seq of the last entry>.
+func (s *ApiServer) LedgerIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login := strings.ToLower(mux.Vars(r)["login"])
+	before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	entries, err := s.db.GetMinerLedger(login, before, limit)
+	if err != nil {
+		log.Errorf("Failed to load ledger of %v: %v", login, err)
+		s.ErrorWrite(w, "Failed to load ledger")
+		return
+	}
+	totals, sum, err := s.db.GetMinerLedgerTotals(login)
+	if err != nil {
+		log.Errorf("Failed to load ledger of %v: %v", login, err)
+		s.ErrorWrite(w, "Failed to load ledger")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"totals":  totals,
+		"sum":     sum,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// AdjustmentIndex requests a manual credit or debit of one miner's balance. It is stored as a compensation
+// and applied by the payout module once someone else approved it.
+func (s *ApiServer) AdjustmentIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 64 << 10))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	adjustment, err := payouts.ParseAdjustment(data, s.config.CompensationMaxTotal)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(map[string]string {
+			"state":"false",
+			"msg":"Invalid adjustment",
+			"text":s.localize(w, "Invalid adjustment"),
+			"error":err.Error(),
+		})
+		if err != nil {
+			log.Error("Error serializing API response: ", err)
+		}
+		return
+	}
+
+	login := r.Header.Get("login")
+	compensation, items := adjustment.Record(login)
+	id, err := s.db.WriteCompensation(compensation, items)
+	if err != nil {
+		log.Errorf("Failed to store adjustment of %v: %v", adjustment.Login, err)
+		s.ErrorWrite(w, "Failed to store adjustment")
+		return
+	}
+	s.logs.InsertLog(fmt.Sprintf("COMPENSATION #%v %v of %v Shannon requested by %v: %v", id, adjustment.Kind, adjustment.Amount, login, adjustment.Reason),
+		plogger.LogTypePaymentWork, plogger.LogSubTypePaymentCompensation, 0, 0, adjustment.Login, "")
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":"ok",
+		"id":id,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// CompensationActionIndex approves or rejects an imported compensation. The payout module applies approved ones.
+func (s *ApiServer) CompensationActionIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	action := mux.Vars(r)["action"]
+	login := r.Header.Get("login")
+
+	state := mysql.CompensationApproved
+	if action == "reject" {
+		state = mysql.CompensationRejected
+	} else {
+		compensation, err := s.db.GetCompensation(id)
+		if err != nil {
+			s.ErrorWrite(w, "Compensation is not waiting for approval")
+			return
+		}
+		if compensation.CreatedBy == login {
+			s.ErrorWrite(w, "Compensation needs a second approver")
+			return
+		}
+	}
+	ok, err := s.db.UpdateCompensationState(id, mysql.CompensationPending, state, login)
+	if err != nil || !ok {
+		s.ErrorWrite(w, "Compensation is not waiting for approval")
+		return
+	}
+	s.logs.InsertLog(fmt.Sprintf("COMPENSATION #%v %v by %v", id, state, login), plogger.LogTypePaymentWork, plogger.LogSubTypePaymentCompensation, 0, 0, "", "")
+
+	if state == mysql.CompensationApproved {
+		receivers, err := s.backend.Publish(redis.ChannelPayout, redis.OpcodeCompensation, "", redis.ChannelApi)
+		if err != nil || receivers == 0 {
+			log.Warnf("Compensation #%v approved, no payout module is running: %v", id, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string {
+		"status":"ok",
+		"state":state,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// FeaturesIndex lists the feature flags with their defaults and runtime overrides.
+func (s *ApiServer) FeaturesIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"features": s.backend.Features().States(),
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// FeatureToggleIndex overrides a feature flag at runtime, or resets it to the configured default.
+func (s *ApiServer) FeatureToggleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	name := mux.Vars(r)["name"]
+	action := mux.Vars(r)["action"]
+	if !feature.IsKnown(name) {
+		s.ErrorWrite(w, fmt.Sprintf("Unknown feature:%v", name))
+		return
+	}
+
+	var err error
+	switch action {
+	case "enable":
+		err = s.backend.SetFeatureFlag(name, true)
+	case "disable":
+		err = s.backend.SetFeatureFlag(name, false)
+	default:
+		err = s.backend.ResetFeatureFlag(name)
+	}
+	if err != nil {
+		s.ErrorWrite(w, "Failed to update feature")
+		return
+	}
+	// Every module reloads its flags, the refresh interval catches the ones that miss this.
+	_, err = s.backend.Publish(redis.ChannelFeature, redis.OpcodeFeature, name, redis.ChannelApi)
+	if err != nil {
+		log.Errorf("Failed to publish feature %v change: %v", name, err)
+	}
+	s.logs.InsertLog(fmt.Sprintf("FEATURE %v %v by %v", name, action, r.Header.Get("login")), plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string {
+		"status":"ok",
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// LogLevelsIndex lists the log modules with their configured level and runtime override.
+func (s *ApiServer) LogLevelsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"modules": xlog.States(),
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// LogLevelIndex overrides the log level of a module in every process, or resets it to the config.
+func (s *ApiServer) LogLevelIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	module := mux.Vars(r)["module"]
+	level := mux.Vars(r)["level"]
+	if !xlog.IsModule(module) {
+		s.ErrorWrite(w, fmt.Sprintf("Unknown log module:%v", module))
+		return
+	}
+
+	var err error
+	if level == "reset" {
+		err = s.backend.ResetLogLevel(module)
+	} else {
+		err = s.backend.SetLogLevel(module, level)
+	}
+	if err != nil {
+		s.ErrorWrite(w, "Failed to update log level")
+		return
+	}
+	_, err = s.backend.Publish(redis.ChannelLog, redis.OpcodeLogLevel, module, redis.ChannelApi)
+	if err != nil {
+		log.Errorf("Failed to publish log level of %v: %v", module, err)
+	}
+	s.logs.InsertLog(fmt.Sprintf("LOG LEVEL %v %v by %v", module, level, r.Header.Get("login")), plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string {
+		"status":"ok",
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1831,14 +2674,14 @@ func (s *ApiServer) ChangeAlarmIndex(w http.ResponseWriter, r *http.Request) {
 
 	var idInbound DbIPInbound
 	if err := json.NewDecoder(r.Body).Decode(&idInbound); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	var ok bool
 	if idInbound.Ip, ok = util.CheckValidHexAddress(idInbound.Ip); !ok {
-		log.Printf("failed to DevId: %v", idInbound.Ip)
+		log.Warnf("failed to DevId: %v", idInbound.Ip)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1853,7 +2696,7 @@ func (s *ApiServer) ChangeAlarmIndex(w http.ResponseWriter, r *http.Request) {
 		"status":"ok",
 	})
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
 
@@ -1865,14 +2708,14 @@ func (s *ApiServer) ChangeDescIndex(w http.ResponseWriter, r *http.Request) {
 
 	var idInbound DbIPInbound
 	if err := json.NewDecoder(r.Body).Decode(&idInbound); err != nil {
-		log.Printf("failed to Decode: %v", err)
+		log.Warnf("failed to Decode: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	var ok bool
 	if idInbound.Ip, ok = util.CheckValidHexAddress(idInbound.Ip); !ok {
-		log.Printf("failed to DevId: %v", idInbound.Ip)
+		log.Warnf("failed to DevId: %v", idInbound.Ip)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1884,6 +2727,6 @@ func (s *ApiServer) ChangeDescIndex(w http.ResponseWriter, r *http.Request) {
 		"status":"ok",
 	})
 	if err != nil {
-		log.Println("Error serializing API response: ", err)
+		log.Error("Error serializing API response: ", err)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// StartShared serves the APIs of several pools of one process on the listener of the first one
+// serving requests. Each pool is served under /{name}, like /{name}/api/stats, and the first one
+// at the root as well, so a dashboard of a single pool keeps working.
+func StartShared(servers []*ApiServer) {
+	r := mux.NewRouter()
+	var root *ApiServer
+	for _, s := range servers {
+		s.shared = true
+		s.Start()
+		if s.config.PurgeOnly {
+			continue
+		}
+		if root == nil {
+			root = s
+		}
+		prefix := "/" + s.config.Name
+		r.PathPrefix(prefix + "/").Handler(http.StripPrefix(prefix, s.handler()))
+		log.Infof("Serving the API of %v under %v", s.config.Name, prefix)
+	}
+	if root == nil {
+		return
+	}
+	r.PathPrefix("/").Handler(root.handler())
+
+	log.Infof("Starting the API of %v pools on %v", len(servers), root.config.Listen)
+	if err := root.serve(r); err != nil {
+		log.Fatalf("Failed to start API: %v", err)
+	}
+}
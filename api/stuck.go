@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+)
+
+// Age of the stuck blocks listed without unlocker.maxCandidateAge
+const defaultStuckBlockAge = 6 * time.Hour
+
+// StuckBlocksIndex lists the blocks neither matured nor orphaned after unlocker.maxCandidateAge,
+// or "?age=" like "2h", for operators to look into.
+func (s *ApiServer) StuckBlocksIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	age := defaultStuckBlockAge
+	value := r.URL.Query().Get("age")
+	if len(value) == 0 {
+		value = s.config.MaxCandidateAge
+	}
+	if len(value) > 0 {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			s.ErrorWrite(w, "Invalid age")
+			return
+		}
+		age = d
+	}
+
+	now := time.Now()
+	blocks, err := s.db.GetStuckBlocks(now.Add(-age).Unix())
+	if err != nil {
+		log.Errorf("Failed to load stuck blocks: %v", err)
+		s.ErrorWrite(w, "Failed to load stuck blocks")
+		return
+	}
+	result := make([]map[string]interface{}, 0, len(blocks))
+	for _, block := range blocks {
+		result = append(result, map[string]interface{}{
+			"roundHeight": block.RoundHeight,
+			"height":      block.Height,
+			"nonce":       block.Nonce,
+			"hash":        block.Hash,
+			"state":       block.State,
+			"stateName":   mysql.BlockStateName(block.State),
+			"timestamp":   block.Timestamp,
+			"age":         int64(now.Sub(time.Unix(block.Timestamp, 0)).Seconds()),
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"age":    age.String(),
+		"blocks": result,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type WithholdingConfig struct {
+	// Flag the workers with improbably few near-block shares or blocks for their work, as counted
+	// by the proxies with proxy.withholding
+	Enabled bool `json:"enabled"`
+	// Work the counts are summed over, "24h" by default, at most a week
+	Window string `json:"window"`
+	// Flag a worker when it had at most this probability to find so few, 1e-6 by default
+	Probability float64 `json:"probability"`
+}
+
+// The workers are checked at most this often, the counts are summed over the whole window.
+const withholdingCheckInterval = 10 * time.Minute
+
+// withholdingWorker is a worker of the withholding report.
+type withholdingWorker struct {
+	Login  string `json:"login"`
+	Worker string `json:"worker"`
+	*redis.WithholdingStats
+	// Probability of finding at most this many near-block shares and blocks for the work
+	NearProbability  float64 `json:"nearProbability"`
+	BlockProbability float64 `json:"blockProbability"`
+	Flagged          bool    `json:"flagged"`
+}
+
+type withholdingReport struct {
+	Window    string               `json:"window"`
+	Timestamp int64                `json:"timestamp"`
+	Workers   []*withholdingWorker `json:"workers"`
+}
+
+// withholdingDetector holds the last report, and the workers flagged in it to only alert on new ones.
+type withholdingDetector struct {
+	window    time.Duration
+	checkedAt time.Time
+	flagged   map[string]bool
+	// Latest *withholdingReport
+	report atomic.Value
+}
+
+func (s *ApiServer) initWithholding() {
+	cfg := s.config.Withholding
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+	d := &withholdingDetector{window: 24 * time.Hour, flagged: make(map[string]bool)}
+	if len(cfg.Window) > 0 {
+		d.window = util.MustParseDuration(cfg.Window)
+	}
+	if d.window <= 0 || d.window > 7*24*time.Hour {
+		log.Fatalf("Invalid api.withholding.window %v, at most a week", cfg.Window)
+	}
+	if cfg.Probability <= 0 {
+		cfg.Probability = 1e-6
+	}
+	s.withholding = d
+	log.Infof("Flagging share withholding over %v below a probability of %v", d.window, cfg.Probability)
+}
+
+// detectWithholding flags the workers whose near-block shares or blocks over the window had at most
+// the configured probability. Newly flagged workers are logged and alerted.
+func (s *ApiServer) detectWithholding() {
+	d := s.withholding
+	if d == nil || time.Since(d.checkedAt) < withholdingCheckInterval {
+		return
+	}
+	d.checkedAt = time.Now()
+
+	stats, err := s.backend.GetWithholdingStats(d.checkedAt.Add(-d.window).Unix())
+	if err != nil {
+		log.Errorf("Failed to load share withholding stats: %v", err)
+		return
+	}
+	threshold := s.config.Withholding.Probability
+	report := &withholdingReport{
+		Window:    d.window.String(),
+		Timestamp: d.checkedAt.Unix(),
+		Workers:   make([]*withholdingWorker, 0, len(stats)),
+	}
+	flagged := make(map[string]bool)
+	a := alerts.For(s.config.Name)
+	for key, st := range stats {
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			continue
+		}
+		w := &withholdingWorker{
+			Login:            key[:i],
+			Worker:           key[i+1:],
+			WithholdingStats: st,
+			NearProbability:  poissonCDF(st.Near, st.Expected),
+			BlockProbability: poissonCDF(st.Blocks, st.ExpectedBlocks),
+		}
+		w.Flagged = w.NearProbability <= threshold || w.BlockProbability <= threshold
+		report.Workers = append(report.Workers, w)
+		if !w.Flagged {
+			continue
+		}
+		flagged[key] = true
+		if d.flagged[key] {
+			continue
+		}
+		msg := fmt.Sprintf("Possible share withholding by %v: %v of %.1f near-block shares and %v of %.2f blocks in %v",
+			key, st.Near, st.Expected, st.Blocks, st.ExpectedBlocks, d.window)
+		s.logs.InsertLog(msg, plogger.LogTypeSystem, plogger.LogSubTypeWithholding, 0, 0, w.Login, "")
+		a.Fire(alerts.EventWithholding, key, "%v", msg)
+	}
+	d.flagged = flagged
+
+	sort.Slice(report.Workers, func(i, j int) bool {
+		return math.Min(report.Workers[i].NearProbability, report.Workers[i].BlockProbability) <
+			math.Min(report.Workers[j].NearProbability, report.Workers[j].BlockProbability)
+	})
+	d.report.Store(report)
+}
+
+// poissonCDF is the probability of at most k events when lambda are expected.
+func poissonCDF(k int64, lambda float64) float64 {
+	if lambda <= 0 {
+		return 1
+	}
+	if k < 0 {
+		return 0
+	}
+	// Summed in log space, scaled by the largest term: past lambda 745 exp(-lambda) is 0 in float64,
+	// the terms near lambda are not
+	logLambda := math.Log(lambda)
+	maxLog, sum := math.Inf(-1), 0.0
+	for i := int64(0); i <= k; i++ {
+		lg, _ := math.Lgamma(float64(i + 1))
+		t := float64(i)*logLambda - lambda - lg
+		if t > maxLog {
+			sum = sum*math.Exp(maxLog-t) + 1
+			maxLog = t
+		} else {
+			sum += math.Exp(t - maxLog)
+		}
+	}
+	return math.Min(math.Exp(maxLog+math.Log(sum)), 1)
+}
+
+// WithholdingIndex reports the workers flagged for share withholding in the last check, or every
+// worker with "?all=true", the least probable first.
+func (s *ApiServer) WithholdingIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if s.withholding == nil {
+		s.ErrorWrite(w, "Withholding detection is disabled")
+		return
+	}
+	report, _ := s.withholding.report.Load().(*withholdingReport)
+	if report == nil {
+		report = &withholdingReport{Window: s.withholding.window.String(), Workers: []*withholdingWorker{}}
+	}
+	if r.URL.Query().Get("all") != "true" {
+		flagged := make([]*withholdingWorker, 0)
+		for _, worker := range report.Workers {
+			if worker.Flagged {
+				flagged = append(flagged, worker)
+			}
+		}
+		report = &withholdingReport{Window: report.Window, Timestamp: report.Timestamp, Workers: flagged}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
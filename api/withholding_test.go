@@ -0,0 +1,33 @@
+package api
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPoissonCDF(t *testing.T) {
+	tests := []struct {
+		k        int64
+		lambda   float64
+		expected float64
+	}{
+		{0, 0, 1},
+		{-1, 2, 0},
+		{0, 1, 3.678794411714e-01},
+		{2, 2, 6.766764161831e-01},
+		{3, 0.5, 9.982483774437e-01},
+		// exp(-lambda) underflows past 745
+		{0, 800, 0},
+		{10, 1000, 0},
+		{700, 800, 1.660907855518e-04},
+		{900, 1000, 6.977673277963e-04},
+		{1000, 1000, 5.084093671685e-01},
+		{2000, 1000, 1},
+	}
+	for _, test := range tests {
+		p := poissonCDF(test.k, test.lambda)
+		if math.Abs(p-test.expected) > 1e-9*math.Max(test.expected, 1e-300) {
+			t.Errorf("P(X <= %v) with lambda %v must be %v, got %v", test.k, test.lambda, test.expected, p)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// Worker statistics summed by the workers endpoint.
+const workerStatsPeriod = 24 * 3600
+
+// rollupWorkerStats moves the share counters of login's workers from Redis to MySQL, with the hashrate over elapsed seconds.
+func (s *ApiServer) rollupWorkerStats(login string, ts int64, elapsed int64) {
+	workers, err := s.backend.TakeWorkerShares(login)
+	if err != nil {
+		log.Errorf("Failed to take worker shares of %v: %v", login, err)
+		return
+	}
+	for id, w := range workers {
+		if w.Valid+w.Stale+w.Invalid+w.Duplicate == 0 {
+			// Only last seen, nothing to roll up
+			delete(workers, id)
+			continue
+		}
+		w.Hashrate = w.Diff / elapsed
+	}
+	if err := s.db.WriteWorkerStats(login, ts, workers); err != nil {
+		log.Errorf("Failed to write worker stats of %v: %v", login, err)
+	}
+}
+
+// WorkersIndex returns the current hashrate, the shares of the last 24 hours and the last seen time of each worker of a login.
+func (s *ApiServer) WorkersIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login := strings.ToLower(mux.Vars(r)["login"])
+	ts := util.MakeTimestamp() / 1000
+
+	workers, err := s.db.GetWorkerStatsSums(login, ts-workerStatsPeriod)
+	if err != nil {
+		log.Errorf("Failed to fetch worker stats of %v: %v", login, err)
+		s.ErrorWrite(w, "Failed to fetch worker stats")
+		return
+	}
+	pending, err := s.backend.GetWorkerShares(login)
+	if err != nil {
+		log.Errorf("Failed to fetch worker shares of %v: %v", login, err)
+		s.ErrorWrite(w, "Failed to fetch worker stats")
+		return
+	}
+	for id, p := range pending {
+		if ws, ok := workers[id]; ok {
+			ws.Add(p)
+		} else {
+			workers[id] = p
+		}
+	}
+	var hashrates map[string]redis.Worker
+	current, err := s.backend.CollectWorkersStats(s.hashrateWindow, s.hashrateLargeWindow, login, nil)
+	if err != nil {
+		log.Errorf("Failed to fetch worker hashrates of %v: %v", login, err)
+	} else {
+		hashrates, _ = current["workers"].(map[string]redis.Worker)
+	}
+
+	result := make([]map[string]interface{}, 0, len(workers))
+	for id, ws := range workers {
+		entry := map[string]interface{}{
+			"worker":    id,
+			"valid":     ws.Valid,
+			"stale":     ws.Stale,
+			"invalid":   ws.Invalid,
+			"duplicate": ws.Duplicate,
+			"staleRate": ws.StaleRate(),
+			"lastSeen":  ws.LastSeen,
+			"online":    ws.LastSeen > ts-int64(s.hashrateWindow/time.Second)/2,
+		}
+		if hr, ok := hashrates[id]; ok {
+			entry["hashrate"] = hr.HR * hr.Size
+			entry["hashrateLarge"] = hr.TotalHR * hr.Size
+		}
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i]["worker"].(string) < result[j]["worker"].(string) })
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"workers": result,
+		"period":  workerStatsPeriod,
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
+
+// WorkerIndex returns the rollups of one worker over the last 24 hours, newest first, and its shares since the last one.
+func (s *ApiServer) WorkerIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login := strings.ToLower(mux.Vars(r)["login"])
+	worker := mux.Vars(r)["worker"]
+	ts := util.MakeTimestamp() / 1000
+
+	history, err := s.db.GetWorkerStatsHistory(login, worker, ts-workerStatsPeriod, 1000)
+	if err != nil {
+		log.Errorf("Failed to fetch worker stats of %v.%v: %v", login, worker, err)
+		s.ErrorWrite(w, "Failed to fetch worker stats")
+		return
+	}
+	pending, err := s.backend.GetWorkerShares(login)
+	if err != nil {
+		log.Errorf("Failed to fetch worker shares of %v: %v", login, err)
+		s.ErrorWrite(w, "Failed to fetch worker stats")
+		return
+	}
+	current, ok := pending[worker]
+	if !ok && len(history) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !ok {
+		current = &types.WorkerStats{Worker: worker}
+	}
+
+	total := &types.WorkerStats{Worker: worker}
+	total.Add(current)
+	for _, h := range history {
+		total.Add(h)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"current":   current,
+		"history":   history,
+		"staleRate": total.StaleRate(),
+	})
+	if err != nil {
+		log.Error("Error serializing API response: ", err)
+	}
+}
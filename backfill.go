@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cellcrypto/open-dangnn-pool/payouts"
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// backfillRewards credits the rounds the unlocker set aside without shares and prints a
+// reconciliation report of every such round. Nothing is written without apply. It returns false if
+// a round failed.
+func backfillRewards(apply bool) bool {
+	forks, err := types.ForksFor(cfg.NetId, cfg.Net, cfg.Forks)
+	if err != nil {
+		log.Fatalf("Invalid fork table: %v", err)
+	}
+	u := payouts.NewBlockUnlocker(&cfg.BlockUnlocker, backend, db, forks, cfg.NetId)
+
+	log.Printf("Looking for rounds without shares")
+	rounds, err := u.Backfill(apply)
+	if err != nil {
+		log.Fatalf("Failed to backfill rounds: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROUND\tSTATE\tHEIGHT\tHASH\tSOURCE\tMINERS\tREVENUE\tMINERS PROFIT\tPOOL PROFIT\tRESULT")
+	results := make(map[string]int)
+	totalRevenue := new(big.Rat)
+	failed := false
+	for _, r := range rounds {
+		state := "candidate"
+		if r.State == mysql.StateImmatureError {
+			state = "immature"
+		}
+		result := r.Result
+		if r.Err != nil {
+			result = fmt.Sprintf("%v: %v", r.Result, r.Err)
+			failed = true
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.Block.RoundKey(), state, r.Block.Height, r.Block.Hash,
+			r.Source, r.Miners, formatReward(r.Revenue), formatReward(r.MinersProfit), formatReward(r.PoolProfit), result)
+		results[r.Result]++
+		if r.Revenue != nil && (r.Result == payouts.BackfillCredited || r.Result == payouts.BackfillDryRun) {
+			totalRevenue.Add(totalRevenue, r.Revenue)
+		}
+	}
+	w.Flush()
+
+	log.Printf("%v rounds: %v credited, %v to credit, %v without shares, %v orphaned, %v unresolved, %v failed, revenue %v",
+		len(rounds), results[payouts.BackfillCredited], results[payouts.BackfillDryRun], results[payouts.BackfillNoShares],
+		results[payouts.BackfillOrphaned], results[payouts.BackfillUnresolved], results[payouts.BackfillFailed], util.FormatRatReward(totalRevenue))
+	if !apply && results[payouts.BackfillDryRun] > 0 {
+		log.Printf("Dry run, run with --apply to credit the rounds")
+	}
+	return !failed
+}
+
+func formatReward(r *big.Rat) string {
+	if r == nil {
+		return "-"
+	}
+	return util.FormatRatReward(r)
+}
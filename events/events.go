@@ -0,0 +1,152 @@
+// Package events publishes the pool's events to Kafka and NATS for downstream pipelines.
+//
+// Events are read from the push channel of Redis, where the proxies, unlocker and payouts
+// publish them, so only one instance of the pool should have events enabled.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+)
+
+// SchemaVersion is the version of Event. Fields are only added within a version.
+const SchemaVersion = 1
+
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Events queued per publisher while it's unavailable, further events are dropped
+	BufferSize int `json:"bufferSize"`
+	// Attempts to publish an event before it's dropped
+	Retries int         `json:"retries"`
+	Kafka   KafkaConfig `json:"kafka"`
+	Nats    NatsConfig  `json:"nats"`
+}
+
+// Event is the JSON payload of every published event.
+type Event struct {
+	Schema  string `json:"schema"`
+	Version int    `json:"version"`
+	// Random, for consumers to drop events published twice after a retry
+	Id        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Coin      string                 `json:"coin"`
+	Pool      string                 `json:"pool"`
+	Account   string                 `json:"account,omitempty"`
+	Timestamp int64                  `json:"timestamp"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+type Publisher interface {
+	Name() string
+	// Publish sends an event keyed by account, which may be empty.
+	Publish(typ, account string, payload []byte) error
+}
+
+type Bus struct {
+	config *Config
+	coin   string
+	pool   string
+	queues []*queue
+}
+
+type queue struct {
+	publisher Publisher
+	events    chan *Event
+}
+
+func Start(cfg *Config, backend *redis.RedisClient, coin, pool string) *Bus {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 1000
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = 5
+	}
+	b := &Bus{config: cfg, coin: coin, pool: pool}
+	if cfg.Kafka.Enabled {
+		b.add(NewKafkaPublisher(&cfg.Kafka))
+	}
+	if cfg.Nats.Enabled {
+		b.add(NewNatsPublisher(&cfg.Nats))
+	}
+	if len(b.queues) == 0 {
+		log.Println("Events are enabled but neither kafka nor nats is")
+		return b
+	}
+	backend.InitPubSub(redis.ChannelPush, b)
+	log.Printf("Publishing pool events to %v publishers", len(b.queues))
+	return b
+}
+
+func (b *Bus) add(p Publisher) {
+	q := &queue{publisher: p, events: make(chan *Event, b.config.BufferSize)}
+	b.queues = append(b.queues, q)
+	go b.run(q)
+}
+
+// RedisMessage publishes an event of ChannelPush.
+func (b *Bus) RedisMessage(payload string) {
+	e, ok := redis.ParsePushEvent(payload)
+	if !ok {
+		log.Printf("Malformed pool event: %v", payload)
+		return
+	}
+	event := b.newEvent(e.Type, e.Login, e.Data)
+	for _, q := range b.queues {
+		select {
+		case q.events <- event:
+		default:
+			log.Printf("Event queue of %v is full, dropped %v event", q.publisher.Name(), event.Type)
+			metrics.EventsPublished.Inc(b.pool, q.publisher.Name(), "dropped")
+		}
+	}
+}
+
+func (b *Bus) newEvent(typ, account string, data map[string]interface{}) *Event {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &Event{
+		Schema:    "pool.event",
+		Version:   SchemaVersion,
+		Id:        hex.EncodeToString(id),
+		Type:      typ,
+		Coin:      b.coin,
+		Pool:      b.pool,
+		Account:   account,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	}
+}
+
+func (b *Bus) run(q *queue) {
+	name := q.publisher.Name()
+	for event := range q.events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Failed to serialize %v event: %v", event.Type, err)
+			continue
+		}
+		backoff := time.Second
+		for attempt := 1; ; attempt++ {
+			err = q.publisher.Publish(event.Type, event.Account, payload)
+			if err == nil {
+				metrics.EventsPublished.Inc(b.pool, name, "published")
+				break
+			}
+			if attempt >= b.config.Retries {
+				log.Printf("Dropped %v event %v after %v attempts to publish to %v: %v", event.Type, event.Id, attempt, name, err)
+				metrics.EventsPublished.Inc(b.pool, name, "dropped")
+				break
+			}
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMurmur2(t *testing.T) {
+	// Values of org.apache.kafka.common.utils.Utils.murmur2
+	for key, want := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if got := murmur2([]byte(key)); got != want {
+			t.Errorf("murmur2(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestKafkaRecordBatch(t *testing.T) {
+	batch := kafkaRecordBatch([]byte("0xabc"), []byte(`{"type":"blockFound"}`), time.Unix(1600000000, 0))
+
+	r := &kafkaReader{buf: batch}
+	if r.int64() != 0 {
+		t.Error("Base offset must be 0")
+	}
+	if length := r.int32(); int(length) != len(batch)-12 {
+		t.Errorf("Batch length %v, want %v", length, len(batch)-12)
+	}
+	r.int32()
+	if r.int8() != 2 {
+		t.Error("Magic must be 2")
+	}
+	crc := uint32(r.int32())
+	if crc != crc32.Checksum(r.buf, crc32c) {
+		t.Error("CRC-32C doesn't match")
+	}
+	r.int16()
+	r.int32()
+	if r.int64() != 1600000000000 {
+		t.Error("First timestamp must be in milliseconds")
+	}
+	r.next(8 + 8 + 2 + 4)
+	if r.int32() != 1 {
+		t.Error("Batch must have one record")
+	}
+	length, n := binary.Varint(r.buf)
+	if int(length) != len(r.buf)-n {
+		t.Errorf("Record length %v, want %v", length, len(r.buf)-n)
+	}
+	if !strings.Contains(string(r.buf), "0xabc") || !strings.HasSuffix(string(r.buf), "}\x00") {
+		t.Errorf("Record is %q", r.buf)
+	}
+}
+
+func TestNatsPublisher(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "PING":
+				conn.Write([]byte("PONG\r\n"))
+			case strings.HasPrefix(line, "PUB "):
+				payload, _ := r.ReadString('\n')
+				received <- line + " " + strings.TrimSpace(payload)
+			case strings.HasPrefix(line, "CONNECT "):
+				if !strings.Contains(line, `"auth_token":"secret"`) {
+					conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+					return
+				}
+			}
+		}
+	}()
+
+	p := NewNatsPublisher(&NatsConfig{URL: ln.Addr().String(), Subject: "pool.dgc", Token: "secret", Timeout: "2s"})
+	if err := p.Publish("paymentSent", "0xabc", []byte(`{"id":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish("blockMatured", "", []byte(`{"id":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`PUB pool.dgc.paymentSent.0xabc 8 {"id":1}`, `PUB pool.dgc.blockMatured 8 {"id":2}`} {
+		if got := <-received; got != want {
+			t.Errorf("Server received %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,461 @@
+package events
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type KafkaConfig struct {
+	Enabled bool `json:"enabled"`
+	// host:port of bootstrap brokers
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+	// 1 waits for the leader, -1 for all in-sync replicas
+	Acks     int16  `json:"acks"`
+	ClientId string `json:"clientId"`
+	TLS      bool   `json:"tls"`
+	Timeout  string `json:"timeout"`
+}
+
+// KafkaPublisher speaks the Kafka protocol (Metadata v1, Produce v3 with record batches, Kafka 0.11
+// and later). Events of an account go to the partition of its murmur2 hash, as with the Java client,
+// so they stay in order. Events of no account are spread round robin.
+type KafkaPublisher struct {
+	config      *KafkaConfig
+	timeout     time.Duration
+	correlation int32
+	roundRobin  uint32
+
+	mu         sync.Mutex
+	conns      map[int32]*kafkaConn
+	brokers    map[int32]string
+	partitions []kafkaPartition
+}
+
+type kafkaPartition struct {
+	id     int32
+	leader int32
+}
+
+type kafkaConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Kafka API keys used.
+const (
+	kafkaProduce  = 0
+	kafkaMetadata = 3
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+func NewKafkaPublisher(cfg *KafkaConfig) *KafkaPublisher {
+	if len(cfg.Topic) == 0 {
+		cfg.Topic = "pool-events"
+	}
+	if len(cfg.ClientId) == 0 {
+		cfg.ClientId = "open-dangnn-pool"
+	}
+	if cfg.Acks == 0 {
+		cfg.Acks = 1
+	}
+	p := &KafkaPublisher{config: cfg, timeout: 10 * time.Second, conns: make(map[int32]*kafkaConn)}
+	if len(cfg.Timeout) > 0 {
+		p.timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	return p
+}
+
+func (p *KafkaPublisher) Name() string {
+	return "kafka"
+}
+
+func (p *KafkaPublisher) Publish(typ, account string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.partitions) == 0 {
+		if err := p.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+
+	var key []byte
+	var part kafkaPartition
+	if len(account) > 0 {
+		key = []byte(account)
+		part = p.partitions[kafkaPartitionOf(key, len(p.partitions))]
+	} else {
+		p.roundRobin++
+		part = p.partitions[int(p.roundRobin%uint32(len(p.partitions)))]
+	}
+
+	err := p.produce(part, key, payload)
+	if err != nil {
+		// Leaders move, find them again before the retry
+		p.partitions = nil
+		p.closeAll()
+	}
+	return err
+}
+
+// kafkaPartitionOf is the partition of key as chosen by the Java client's default partitioner.
+func kafkaPartitionOf(key []byte, partitions int) int {
+	return int(murmur2(key)&0x7fffffff) % partitions
+}
+
+func murmur2(data []byte) int32 {
+	const m = 0x5bd1e995
+	length := len(data)
+	h := uint32(0x9747b28c) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+func (p *KafkaPublisher) refreshMetadata() error {
+	var lastErr error
+	for _, addr := range p.config.Brokers {
+		c, err := p.dial(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		err = p.metadata(c)
+		c.conn.Close()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("kafka: no brokers configured")
+	}
+	return lastErr
+}
+
+func (p *KafkaPublisher) metadata(c *kafkaConn) error {
+	w := &kafkaWriter{}
+	w.int32(1)
+	w.string(p.config.Topic)
+	r, err := p.request(c, kafkaMetadata, 1, w.buf)
+	if err != nil {
+		return err
+	}
+
+	brokers := make(map[int32]string)
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		id := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.int32() // controller
+	var partitions []kafkaPartition
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		code := r.int16()
+		name := r.string()
+		r.int8() // internal
+		if name == p.config.Topic && code != 0 {
+			return fmt.Errorf("kafka: metadata of %v: error %v", name, code)
+		}
+		for m := r.int32(); m > 0 && r.err == nil; m-- {
+			r.int16()
+			part := kafkaPartition{id: r.int32(), leader: r.int32()}
+			r.skipInt32Array() // replicas
+			r.skipInt32Array() // isr
+			if name == p.config.Topic {
+				partitions = append(partitions, part)
+			}
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("kafka: topic %v has no partitions", p.config.Topic)
+	}
+	// Partition ids are 0..n-1, index them by id for the partitioner
+	sorted := make([]kafkaPartition, len(partitions))
+	for _, part := range partitions {
+		if int(part.id) >= len(sorted) {
+			return fmt.Errorf("kafka: unexpected partition %v of %v", part.id, p.config.Topic)
+		}
+		sorted[part.id] = part
+	}
+	p.brokers = brokers
+	p.partitions = sorted
+	return nil
+}
+
+func (p *KafkaPublisher) produce(part kafkaPartition, key, value []byte) error {
+	c, ok := p.conns[part.leader]
+	if !ok {
+		addr, ok := p.brokers[part.leader]
+		if !ok {
+			return fmt.Errorf("kafka: partition %v has no leader", part.id)
+		}
+		var err error
+		if c, err = p.dial(addr); err != nil {
+			return err
+		}
+		p.conns[part.leader] = c
+	}
+
+	batch := kafkaRecordBatch(key, value, time.Now())
+	w := &kafkaWriter{}
+	w.int16(-1) // transactional id
+	w.int16(p.config.Acks)
+	w.int32(int32(p.timeout / time.Millisecond))
+	w.int32(1)
+	w.string(p.config.Topic)
+	w.int32(1)
+	w.int32(part.id)
+	w.int32(int32(len(batch)))
+	w.buf = append(w.buf, batch...)
+
+	r, err := p.request(c, kafkaProduce, 3, w.buf)
+	if err != nil {
+		return err
+	}
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		r.string()
+		for m := r.int32(); m > 0 && r.err == nil; m-- {
+			r.int32()
+			code := r.int16()
+			r.int64() // offset
+			r.int64() // log append time
+			if code != 0 {
+				return fmt.Errorf("kafka: produce to %v/%v: error %v", p.config.Topic, part.id, code)
+			}
+		}
+	}
+	return r.err
+}
+
+// kafkaRecordBatch encodes a record batch (magic 2) of one record.
+func kafkaRecordBatch(key, value []byte, ts time.Time) []byte {
+	rec := &kafkaWriter{}
+	rec.int8(0)   // attributes
+	rec.varint(0) // timestamp delta
+	rec.varint(0) // offset delta
+	if key == nil {
+		rec.varint(-1)
+	} else {
+		rec.varint(int64(len(key)))
+		rec.buf = append(rec.buf, key...)
+	}
+	rec.varint(int64(len(value)))
+	rec.buf = append(rec.buf, value...)
+	rec.varint(0) // headers
+
+	// Everything after the crc, which covers it
+	body := &kafkaWriter{}
+	body.int16(0) // attributes
+	body.int32(0) // last offset delta
+	millis := ts.UnixNano() / int64(time.Millisecond)
+	body.int64(millis)
+	body.int64(millis)
+	body.int64(-1) // producer id
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(1)
+	body.varint(int64(len(rec.buf)))
+	body.buf = append(body.buf, rec.buf...)
+
+	w := &kafkaWriter{}
+	w.int64(0) // base offset
+	w.int32(int32(4 + 1 + 4 + len(body.buf)))
+	w.int32(-1) // partition leader epoch
+	w.int8(2)   // magic
+	w.int32(int32(crc32.Checksum(body.buf, crc32c)))
+	w.buf = append(w.buf, body.buf...)
+	return w.buf
+}
+
+func (p *KafkaPublisher) dial(addr string) (*kafkaConn, error) {
+	dialer := &net.Dialer{Timeout: p.timeout}
+	var conn net.Conn
+	var err error
+	if p.config.TLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaConn{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (p *KafkaPublisher) closeAll() {
+	for id, c := range p.conns {
+		c.conn.Close()
+		delete(p.conns, id)
+	}
+}
+
+// request sends a request with header v1 and returns a reader of the response after its header.
+func (p *KafkaPublisher) request(c *kafkaConn, apiKey, version int16, body []byte) (*kafkaReader, error) {
+	correlation := atomic.AddInt32(&p.correlation, 1)
+	h := &kafkaWriter{}
+	h.int32(0) // size, set below
+	h.int16(apiKey)
+	h.int16(version)
+	h.int32(correlation)
+	h.string(p.config.ClientId)
+	h.buf = append(h.buf, body...)
+	binary.BigEndian.PutUint32(h.buf, uint32(len(h.buf)-4))
+
+	c.conn.SetDeadline(time.Now().Add(p.timeout * 2))
+	if _, err := c.conn.Write(h.buf); err != nil {
+		return nil, err
+	}
+	var size int32
+	if err := binary.Read(c.r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 || size > 64<<20 {
+		return nil, fmt.Errorf("kafka: bad response size %v", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	r := &kafkaReader{buf: resp}
+	if r.int32() != correlation {
+		return nil, errors.New("kafka: response out of order")
+	}
+	return r, nil
+}
+
+type kafkaWriter struct {
+	buf []byte
+}
+
+func (w *kafkaWriter) int8(v int8) {
+	w.buf = append(w.buf, byte(v))
+}
+
+func (w *kafkaWriter) int16(v int16) {
+	w.buf = append(w.buf, byte(v>>8), byte(v))
+}
+
+func (w *kafkaWriter) int32(v int32) {
+	w.buf = append(w.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *kafkaWriter) int64(v int64) {
+	w.int32(int32(v >> 32))
+	w.int32(int32(v))
+}
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// varint is zigzag encoded, as binary.PutVarint does.
+func (w *kafkaWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	w.buf = append(w.buf, b[:n]...)
+}
+
+// kafkaReader reads a response, the first error is kept and later reads return zeros.
+type kafkaReader struct {
+	buf []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = errors.New("kafka: short response")
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *kafkaReader) int8() int8 {
+	if b := r.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a nullable string, null as empty.
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n <= 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *kafkaReader) skipInt32Array() {
+	n := r.int32()
+	if n > 0 {
+		r.next(int(n) * 4)
+	}
+}
@@ -0,0 +1,184 @@
+package events
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type NatsConfig struct {
+	Enabled bool `json:"enabled"`
+	// host:port of a NATS server
+	URL string `json:"url"`
+	// Events are published to <subject>.<type>, or <subject>.<type>.<account> for events of an account
+	Subject  string `json:"subject"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+	TLS      bool   `json:"tls"`
+	Timeout  string `json:"timeout"`
+}
+
+// NatsPublisher speaks the NATS client protocol. Every publish is followed by a PING, and the
+// PONG confirms the server processed it.
+type NatsPublisher struct {
+	config  *NatsConfig
+	timeout time.Duration
+
+	mu    sync.Mutex
+	conn  net.Conn
+	pongs chan error
+}
+
+func NewNatsPublisher(cfg *NatsConfig) *NatsPublisher {
+	if len(cfg.Subject) == 0 {
+		cfg.Subject = "pool.events"
+	}
+	p := &NatsPublisher{config: cfg, timeout: 5 * time.Second}
+	if len(cfg.Timeout) > 0 {
+		p.timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	return p
+}
+
+func (p *NatsPublisher) Name() string {
+	return "nats"
+}
+
+// natsSubject returns the subject of an event. Accounts are hex addresses, safe as subject tokens.
+func natsSubject(prefix, typ, account string) string {
+	if len(account) == 0 {
+		return prefix + "." + typ
+	}
+	return prefix + "." + typ + "." + account
+}
+
+func (p *NatsPublisher) Publish(typ, account string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	subject := natsSubject(p.config.Subject, typ, account)
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload)
+	if err := p.roundTrip(msg); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+// roundTrip writes msg, which ends with a PING, and waits for the PONG.
+func (p *NatsPublisher) roundTrip(msg string) error {
+	p.conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	if _, err := p.conn.Write([]byte(msg)); err != nil {
+		return err
+	}
+	select {
+	case err := <-p.pongs:
+		return err
+	case <-time.After(p.timeout):
+		return errors.New("nats: no PONG from server")
+	}
+}
+
+func (p *NatsPublisher) connect() error {
+	dialer := &net.Dialer{Timeout: p.timeout}
+	var conn net.Conn
+	var err error
+	if p.config.TLS {
+		host, _, _ := net.SplitHostPort(p.config.URL)
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.config.URL, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", p.config.URL)
+	}
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(p.timeout))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(line))
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "open-dangnn-pool",
+		"lang":     "go",
+		"version":  "1.0.0",
+		"protocol": 0,
+	}
+	if len(p.config.User) > 0 {
+		opts["user"] = p.config.User
+		opts["pass"] = p.config.Password
+	}
+	if len(p.config.Token) > 0 {
+		opts["auth_token"] = p.config.Token
+	}
+	connect, _ := json.Marshal(opts)
+
+	p.conn = conn
+	p.pongs = make(chan error, 1)
+	go p.read(conn, r, p.pongs)
+	if err := p.roundTrip("CONNECT " + string(connect) + "\r\nPING\r\n"); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+// read answers the server's PINGs and reports PONGs and errors to the waiting publish.
+func (p *NatsPublisher) read(conn net.Conn, r *bufio.Reader, pongs chan<- error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			select {
+			case pongs <- err:
+			default:
+			}
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			conn.SetWriteDeadline(time.Now().Add(p.timeout))
+			conn.Write([]byte("PONG\r\n"))
+		case line == "PONG":
+			select {
+			case pongs <- nil:
+			default:
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			select {
+			case pongs <- fmt.Errorf("nats: %v", strings.TrimPrefix(line, "-ERR ")):
+			default:
+			}
+		}
+	}
+}
+
+func (p *NatsPublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
@@ -0,0 +1,170 @@
+// Package feature gates new behaviors per environment. Defaults come from the config,
+// runtime overrides are kept in Redis so a flag can be flipped without a redeploy.
+package feature
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/xlog"
+)
+
+var log = xlog.Module("feature")
+
+const (
+	// Credit rounds from the last N shares instead of the shares of the current round
+	Pplns = "pplns"
+	// Charge miners the EIP-1559 base fee of their payout, the pool absorbs it when off
+	BaseFeeDeduction = "baseFeeDeduction"
+	// Pay through the multisend contract when it is configured
+	BatchedPayouts = "batchedPayouts"
+)
+
+// Built-in defaults keep the behavior the pool had before the flag existed.
+var known = map[string]bool{
+	Pplns:            true,
+	BaseFeeDeduction: true,
+	BatchedPayouts:   true,
+}
+
+type Config struct {
+	// Default state per flag in this environment
+	Flags map[string]bool `json:"flags"`
+	// Overrides are reloaded on change notifications and at this interval
+	RefreshInterval string `json:"refreshInterval"`
+}
+
+// Store keeps runtime overrides.
+type Store interface {
+	GetFeatureFlags() (map[string]bool, error)
+}
+
+type State struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Default  bool   `json:"default"`
+	Override *bool  `json:"override"`
+}
+
+type Flags struct {
+	mu        sync.RWMutex
+	defaults  map[string]bool
+	overrides map[string]bool
+	store     Store
+}
+
+var defaultFlags = newFlags(nil, nil)
+
+func newFlags(cfg *Config, store Store) *Flags {
+	f := &Flags{defaults: make(map[string]bool), overrides: make(map[string]bool), store: store}
+	for name, enabled := range known {
+		f.defaults[name] = enabled
+	}
+	if cfg != nil {
+		for name, enabled := range cfg.Flags {
+			if !IsKnown(name) {
+				log.Warnf("Unknown feature flag %v in config", name)
+				continue
+			}
+			f.defaults[name] = enabled
+		}
+	}
+	return f
+}
+
+// New sets up the flags of a pool and loads the overrides of store.
+func New(cfg *Config, store Store) *Flags {
+	f := newFlags(cfg, store)
+	if err := f.Reload(); err != nil {
+		log.Errorf("Failed to load feature flags, using defaults: %v", err)
+	}
+	interval := time.Minute
+	if len(cfg.RefreshInterval) > 0 {
+		interval = util.MustParseDuration(cfg.RefreshInterval)
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := f.Reload(); err != nil {
+				log.Errorf("Failed to reload feature flags: %v", err)
+			}
+		}
+	}()
+	return f
+}
+
+// Defaults returns the flags at their defaults, for a pool whose flags aren't set up.
+func Defaults() *Flags {
+	return defaultFlags
+}
+
+func IsKnown(name string) bool {
+	_, ok := known[name]
+	return ok
+}
+
+func (f *Flags) Enabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if enabled, ok := f.overrides[name]; ok {
+		return enabled
+	}
+	return f.defaults[name]
+}
+
+// Reload replaces the overrides with the stored ones.
+func (f *Flags) Reload() error {
+	if f.store == nil {
+		return nil
+	}
+	stored, err := f.store.GetFeatureFlags()
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]bool)
+	for name, enabled := range stored {
+		if IsKnown(name) {
+			overrides[name] = enabled
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, enabled := range overrides {
+		if previous, ok := f.overrides[name]; !ok || previous != enabled {
+			log.Infof("Feature %v set to %v", name, enabled)
+		}
+	}
+	for name := range f.overrides {
+		if _, ok := overrides[name]; !ok {
+			log.Infof("Feature %v reset to %v", name, f.defaults[name])
+		}
+	}
+	f.overrides = overrides
+	return nil
+}
+
+// RedisMessage reloads the overrides when they are changed through the API.
+func (f *Flags) RedisMessage(payload string) {
+	if err := f.Reload(); err != nil {
+		log.Errorf("Failed to reload feature flags: %v", err)
+	}
+}
+
+func (f *Flags) States() []*State {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var result []*State
+	for name, enabled := range f.defaults {
+		state := &State{Name: name, Enabled: enabled, Default: enabled}
+		if override, ok := f.overrides[name]; ok {
+			state.Enabled = override
+			state.Override = &override
+		}
+		result = append(result, state)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
@@ -9,6 +9,8 @@ require (
 	github.com/ethereum/go-ethereum v1.6.1
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.5.0
+	github.com/lib/pq v1.10.9
 	github.com/yvasiyarov/gorelic v0.0.7
 	golang.org/x/crypto v0.0.0-20170930174604-9419663f5a44
 	gopkg.in/redis.v3 v3.6.4
@@ -18,7 +20,6 @@ require (
 	github.com/btcsuite/btcd v0.20.1-beta // indirect
 	github.com/garyburd/redigo v1.6.2 // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/rs/cors v1.8.2 // indirect
 	github.com/slack-go/slack v0.10.2 // indirect
 	github.com/yvasiyarov/go-metrics v0.0.0-20150112132944-c25f46c4b940 // indirect
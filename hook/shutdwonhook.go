@@ -5,32 +5,83 @@ import (
 	"os"
 	"os/signal"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 )
 
+// Phases of a shutdown, run in order. The hooks of a phase run at once.
+const (
+	// Stop taking work, e.g. drain the stratum connections
+	PhaseDrain = iota
+	// Finish the work in flight, e.g. unlock and payout passes. The phase of RegistryHook
+	PhaseStop
+)
+
+type Config struct {
+	// Time the shutdown may take before the process exits anyway, "60s" by default. "0" waits for
+	// every hook
+	Timeout string `json:"timeout"`
+}
+
+type shutdownFn struct {
+	phase int
+	fn    func(os.Signal)
+}
+
 type ShutdownHook struct {
-	hooks     map[string]func(os.Signal)
+	hooks     map[string]*shutdownFn
 	hooksMain func(os.Signal)
+	// Run on SIGHUP, the process keeps running
+	reloads   []func()
 	mutex     *sync.Mutex
+	deadline  time.Duration
+	// Hooks of the shutdown that haven't returned yet
+	running   map[string]bool
 }
 
-var defaultHook = &ShutdownHook{ mutex: &sync.Mutex{},hooks: map[string]func(os.Signal){}}
+var defaultHook = &ShutdownHook{ mutex: &sync.Mutex{},hooks: map[string]*shutdownFn{}, deadline: 60 * time.Second}
 
 func Listen(signals ...os.Signal) {
 	defaultHook.Listen(signals...)
 }
 
+func Configure(cfg *Config) error {
+	return defaultHook.Configure(cfg)
+}
 
 func RegistryHook(name string, fn func(string)) {
 	defaultHook.RegistryHook(name, fn)
 }
 
+func RegistryPhaseHook(phase int, name string, fn func(string)) {
+	defaultHook.RegistryPhaseHook(phase, name, fn)
+}
+
 func RegistryMainHook(fn func()) {
 	defaultHook.RegistryMainHook(fn)
 }
 
+func RegistryReloadHook(fn func()) {
+	defaultHook.RegistryReloadHook(fn)
+}
+
+func (s *ShutdownHook) Configure(cfg *Config) error {
+	if len(cfg.Timeout) == 0 {
+		return nil
+	}
+	deadline, err := time.ParseDuration(cfg.Timeout)
+	if err != nil || deadline < 0 {
+		return fmt.Errorf("invalid shutdown timeout %v", cfg.Timeout)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.deadline = deadline
+	return nil
+}
+
 func (s *ShutdownHook) RegistryMainHook(fn func()) {
 	s.RegistryMainHookWithParam(func(os.Signal) {
 		fmt.Printf("[####] main shutdown process start...\n")
@@ -41,7 +92,11 @@ func (s *ShutdownHook) RegistryMainHook(fn func()) {
 
 
 func (s *ShutdownHook) RegistryHook(name string, fn func(string)) {
-	s.RegistryHookWithParam(name, func(os.Signal) {
+	s.RegistryPhaseHook(PhaseStop, name, fn)
+}
+
+func (s *ShutdownHook) RegistryPhaseHook(phase int, name string, fn func(string)) {
+	s.RegistryHookWithParam(phase, name, func(os.Signal) {
 		fmt.Printf("[####] %v shutdown process start...\n", name)
 		fn(name)
 		fmt.Printf("[####] %v shutdown process end...\n", name)
@@ -49,27 +104,81 @@ func (s *ShutdownHook) RegistryHook(name string, fn func(string)) {
 }
 
 
-func (s *ShutdownHook) RegistryHookWithParam(name string, fn func(os.Signal)) {
+func (s *ShutdownHook) RegistryHookWithParam(phase int, name string, fn func(os.Signal)) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.hooks[name] = fn
+	s.hooks[name] = &shutdownFn{phase: phase, fn: fn}
 }
 
 func (s *ShutdownHook) RegistryMainHookWithParam( fn func(os.Signal)) {
 	s.hooksMain = fn
 }
 
+func (s *ShutdownHook) RegistryReloadHook(fn func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reloads = append(s.reloads, fn)
+}
 
-func (s *ShutdownHook) Hooks() map[string]func(os.Signal) {
+func (s *ShutdownHook) reload() {
+	s.mutex.Lock()
+	fns := append([]func(){}, s.reloads...)
+	s.mutex.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+
+// Hooks returns the hooks of a phase by name.
+func (s *ShutdownHook) Hooks(phase int) map[string]func(os.Signal) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	fns := map[string]func(os.Signal){}
 	for k, v := range s.hooks {
-		fns[k] = v
+		if v.phase == phase {
+			fns[k] = v.fn
+		}
 	}
 	return fns
 }
 
+func (s *ShutdownHook) phases() []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	seen := map[int]bool{}
+	var phases []int
+	for _, v := range s.hooks {
+		if !seen[v.phase] {
+			seen[v.phase] = true
+			phases = append(phases, v.phase)
+		}
+	}
+	sort.Ints(phases)
+	return phases
+}
+
+func (s *ShutdownHook) setRunning(name string, running bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if running {
+		s.running[name] = true
+	} else {
+		delete(s.running, name)
+	}
+}
+
+func (s *ShutdownHook) stillRunning() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var names []string
+	for name := range s.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (s *ShutdownHook) Listen(signals ...os.Signal) {
 	ch := make(chan os.Signal, 1)
 
@@ -91,34 +200,69 @@ func (s *ShutdownHook) Listen(signals ...os.Signal) {
 			timeGap = time.Now().UnixNano()
 		} else if sig == syscall.SIGTERM || sig == syscall.SIGKILL {
 			break
+		} else if sig == syscall.SIGHUP {
+			s.reload()
 		}
 	}
 
 	fmt.Println("[######] shutdown process start... ", sig.String())
 
+	done := make(chan struct{})
+	go func() {
+		s.shutdown(sig)
+		close(done)
+	}()
+
+	s.mutex.Lock()
+	deadline := s.deadline
+	s.mutex.Unlock()
+	var timeout <-chan time.Time
+	if deadline > 0 {
+		timeout = time.After(deadline)
+	}
+	for {
+		select {
+		case <-done:
+			fmt.Println("[######] shutdown process complete...")
+			return
+		case <-timeout:
+			fmt.Printf("[######] shutdown process timed out after %v, still running: %v\n", deadline, strings.Join(s.stillRunning(), ", "))
+			os.Exit(1)
+		case sig = <-ch:
+			// Stopping again doesn't wait any longer
+			if sig == syscall.SIGINT || sig == syscall.SIGTERM {
+				fmt.Printf("[######] shutdown process interrupted by %v, still running: %v\n", sig.String(), strings.Join(s.stillRunning(), ", "))
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// shutdown runs the hooks phase after phase, then the main hook.
+func (s *ShutdownHook) shutdown(sig os.Signal) {
+	s.mutex.Lock()
+	s.running = map[string]bool{}
+	s.mutex.Unlock()
+
 	// SUB HOOK PROCESS
 	var wg sync.WaitGroup
-	for _, fn := range s.Hooks() {
-		wg.Add(1)
-		go func(sig os.Signal, fn func(os.Signal)) {
-			defer wg.Done()
-			fn(sig)
-		}(sig, fn)
+	for _, phase := range s.phases() {
+		for name, fn := range s.Hooks(phase) {
+			wg.Add(1)
+			s.setRunning(name, true)
+			go func(name string, sig os.Signal, fn func(os.Signal)) {
+				defer wg.Done()
+				defer s.setRunning(name, false)
+				fn(sig)
+			}(name, sig, fn)
+		}
+		wg.Wait()
 	}
 
-	wg.Wait()
-
 	// MAIN HOOK PROCESS
 	if s.hooksMain != nil {
-		wg.Add(1)
-		go func(sig os.Signal, fn func(os.Signal)) {
-			defer wg.Done()
-			fn(sig)
-		}(sig, s.hooksMain)
+		s.setRunning("main", true)
+		s.hooksMain(sig)
+		s.setRunning("main", false)
 	}
-
-	wg.Wait()
-
-	fmt.Println("[######] shutdown process complete...")
 }
-
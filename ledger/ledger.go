@@ -0,0 +1,124 @@
+// Package ledger streams the pool's ledger entries to external accounting systems.
+package ledger
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// How often new entries are looked for
+	Interval string `json:"interval"`
+	// Entries sent per request at most
+	BatchSize int          `json:"batchSize"`
+	Sinks     []SinkConfig `json:"sinks"`
+}
+
+type SinkConfig struct {
+	// The cursor of a sink is stored by its name, renaming it sends every entry again
+	Name string `json:"name"`
+	// webhook, kafka or file
+	Type string `json:"type"`
+	// webhook: URL batches are POSTed to. kafka: Kafka REST Proxy URL
+	URL string `json:"url"`
+	// kafka: topic records are produced to
+	Topic string `json:"topic"`
+	// file: entries are appended to it, one JSON object per line
+	Path string `json:"path"`
+	// webhook and kafka: sent as the Authorization header
+	Authorization string `json:"authorization"`
+	// webhook and kafka: request timeout
+	Timeout string `json:"timeout"`
+}
+
+// Exporter delivers the entries to every sink at least once, in order. Each sink has its own
+// cursor, advanced only after a batch was delivered, so a failing sink doesn't hold back the others.
+type Exporter struct {
+	config *Config
+	db     *mysql.Database
+	sinks  map[string]Sink
+}
+
+func NewExporter(cfg *Config, db *mysql.Database) *Exporter {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	e := &Exporter{config: cfg, db: db, sinks: make(map[string]Sink)}
+	for i := range cfg.Sinks {
+		sc := &cfg.Sinks[i]
+		if len(sc.Name) == 0 {
+			log.Fatalf("Ledger export sink %v has no name", i)
+		}
+		if _, ok := e.sinks[sc.Name]; ok {
+			log.Fatalf("Ledger export sink %v is configured twice", sc.Name)
+		}
+		sink, err := newSink(sc)
+		if err != nil {
+			log.Fatalf("Ledger export sink %v: %v", sc.Name, err)
+		}
+		e.sinks[sc.Name] = sink
+	}
+	return e
+}
+
+func (e *Exporter) Start() {
+	intv := 10 * time.Second
+	if len(e.config.Interval) > 0 {
+		intv = util.MustParseDuration(e.config.Interval)
+	}
+	log.Printf("Exporting ledger entries to %v sinks every %v", len(e.sinks), intv)
+
+	for name, sink := range e.sinks {
+		go e.run(name, sink, intv)
+	}
+}
+
+func (e *Exporter) run(name string, sink Sink, intv time.Duration) {
+	timer := time.NewTimer(0)
+	failing := false
+	for {
+		<-timer.C
+		err := e.export(name, sink)
+		if err != nil && !failing {
+			log.Printf("Failed to export ledger entries to %v, retrying every %v: %v", name, intv, err)
+		} else if err == nil && failing {
+			log.Printf("Exporting ledger entries to %v again", name)
+		}
+		failing = err != nil
+		timer.Reset(intv)
+	}
+}
+
+// export sends the entries after the cursor of a sink, batch by batch, until it caught up.
+func (e *Exporter) export(name string, sink Sink) error {
+	cursor, err := e.db.GetLedgerCursor(name)
+	if err != nil {
+		return err
+	}
+	for {
+		entries, err := e.db.GetLedgerEntries(cursor, e.config.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		if err := sink.Send(entries); err != nil {
+			return err
+		}
+		last := entries[len(entries)-1].Seq
+		// Entries sent again after a crash here are told apart by seq.
+		if err := e.db.SetLedgerCursor(name, last); err != nil {
+			return fmt.Errorf("sent up to %v but failed to store the cursor: %v", last, err)
+		}
+		cursor = last
+		if len(entries) < e.config.BatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// Sink delivers a batch of entries. It either delivers all of them or returns an error.
+type Sink interface {
+	Send(entries []*mysql.LedgerEntry) error
+}
+
+func newSink(cfg *SinkConfig) (Sink, error) {
+	timeout := 10 * time.Second
+	if len(cfg.Timeout) > 0 {
+		timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	client := &http.Client{Timeout: timeout}
+
+	switch cfg.Type {
+	case "webhook":
+		if len(cfg.URL) == 0 {
+			return nil, fmt.Errorf("webhook needs a url")
+		}
+		return &webhookSink{url: cfg.URL, authorization: cfg.Authorization, client: client}, nil
+	case "kafka":
+		if len(cfg.URL) == 0 || len(cfg.Topic) == 0 {
+			return nil, fmt.Errorf("kafka needs the url of a REST proxy and a topic")
+		}
+		url := strings.TrimSuffix(cfg.URL, "/") + "/topics/" + cfg.Topic
+		return &kafkaSink{url: url, authorization: cfg.Authorization, client: client}, nil
+	case "file":
+		if len(cfg.Path) == 0 {
+			return nil, fmt.Errorf("file needs a path")
+		}
+		return &fileSink{path: cfg.Path}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", cfg.Type)
+}
+
+// webhookSink POSTs {"entries": [...]} and expects a 2xx status.
+type webhookSink struct {
+	url           string
+	authorization string
+	client        *http.Client
+}
+
+func (s *webhookSink) Send(entries []*mysql.LedgerEntry) error {
+	body, err := json.Marshal(map[string]interface{}{"entries": entries})
+	if err != nil {
+		return err
+	}
+	return post(s.client, s.url, "application/json", s.authorization, body)
+}
+
+// kafkaSink produces one record per entry through the Kafka REST Proxy (v2 API), keyed by login
+// so the entries of a miner stay in order within a partition.
+type kafkaSink struct {
+	url           string
+	authorization string
+	client        *http.Client
+}
+
+type kafkaRecord struct {
+	Key   string             `json:"key"`
+	Value *mysql.LedgerEntry `json:"value"`
+}
+
+func (s *kafkaSink) Send(entries []*mysql.LedgerEntry) error {
+	records := make([]kafkaRecord, len(entries))
+	for i, e := range entries {
+		records[i] = kafkaRecord{Key: e.Login, Value: e}
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+	return post(s.client, s.url, "application/vnd.kafka.json.v2+json", s.authorization, body)
+}
+
+func post(client *http.Client, url, contentType, authorization string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%v replied %v: %s", url, resp.Status, msg)
+	}
+	return nil
+}
+
+// fileSink appends the entries as JSON lines and syncs before the cursor is advanced.
+type fileSink struct {
+	path string
+}
+
+func (s *fileSink) Send(entries []*mysql.LedgerEntry) error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
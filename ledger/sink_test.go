@@ -0,0 +1,125 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+)
+
+var testEntries = []*mysql.LedgerEntry{
+	{Seq: 1, Coin: "dgc", Kind: mysql.LedgerCredit, Login: "0xa", Amount: 100, Ref: "0xblock", Height: 10, Timestamp: 1},
+	{Seq: 2, Coin: "dgc", Kind: mysql.LedgerDebit, Login: "0xa", Amount: -90, Ref: "0xtx", Timestamp: 2},
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got struct {
+		Entries []*mysql.LedgerEntry `json:"entries"`
+	}
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer x" {
+			t.Errorf("Authorization header is %q", r.Header.Get("Authorization"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink, err := newSink(&SinkConfig{Name: "hook", Type: "webhook", URL: srv.URL, Authorization: "Bearer x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testEntries); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entries) != 2 || got.Entries[1].Amount != -90 {
+		t.Errorf("Webhook received %+v", got.Entries)
+	}
+
+	status = http.StatusInternalServerError
+	if err := sink.Send(testEntries); err == nil {
+		t.Error("A failed delivery must return an error")
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	var path, contentType string
+	var got struct {
+		Records []kafkaRecord `json:"records"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	sink, err := newSink(&SinkConfig{Name: "kafka", Type: "kafka", URL: srv.URL + "/", Topic: "ledger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testEntries); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/topics/ledger" || contentType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("Produced to %v as %v", path, contentType)
+	}
+	if len(got.Records) != 2 || got.Records[0].Key != "0xa" || got.Records[0].Value.Seq != 1 {
+		t.Errorf("Kafka received %+v", got.Records)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := newSink(&SinkConfig{Name: "file", Type: "file", Path: filepath.Join(dir, "ledger.jsonl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sink.Send(testEntries); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(dir, "ledger.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var seqs []int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e mysql.LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, e.Seq)
+	}
+	if len(seqs) != 4 || seqs[0] != 1 || seqs[3] != 2 {
+		t.Errorf("File has entries %v, want 1 2 1 2", seqs)
+	}
+}
+
+func TestNewSinkValidates(t *testing.T) {
+	for _, cfg := range []SinkConfig{
+		{Name: "a", Type: "webhook"},
+		{Name: "b", Type: "kafka", URL: "http://localhost"},
+		{Name: "c", Type: "file"},
+		{Name: "d", Type: "smtp"},
+	} {
+		if _, err := newSink(&cfg); err == nil {
+			t.Errorf("Sink %v must be rejected", cfg.Name)
+		}
+	}
+}
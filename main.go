@@ -4,9 +4,9 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/cellcrypto/open-dangnn-pool/hook"
 	"github.com/cellcrypto/open-dangnn-pool/util"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
@@ -16,42 +16,17 @@ import (
 
 	"github.com/yvasiyarov/gorelic"
 
-	"github.com/cellcrypto/open-dangnn-pool/api"
-	"github.com/cellcrypto/open-dangnn-pool/payouts"
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
 	"github.com/cellcrypto/open-dangnn-pool/proxy"
 	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
 	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
-	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger/sinks"
+	"github.com/cellcrypto/open-dangnn-pool/util/xlog"
 )
 
 var cfg proxy.Config
 var backend *redis.RedisClient
 var db *mysql.Database
-var logger *plogger.Logger
-
-func startProxy() {
-	s := proxy.NewProxy(&cfg, backend, db)
-	s.Start()
-}
-
-func startApi() {
-	s := api.NewApiServer(&cfg.Api, cfg.Coin, cfg.Name, backend, db)
-	s.Start()
-}
-
-func startBlockUnlocker() {
-	if util.StringInSlice(cfg.Net,[]string{"mainnet", "testnet"}) == false {
-		fmt.Println("config file error MainNet or testnet cannot be set")
-		return
-	}
-	u := payouts.NewBlockUnlocker(&cfg.BlockUnlocker, backend, db, cfg.Net, cfg.NetId)
-	u.Start()
-}
-
-func startPayoutsProcessor() {
-	u := payouts.NewPayoutsProcessor(&cfg.Payouts, backend, db, cfg.NetId)
-	u.Start()
-}
 
 func startNewrelic() {
 	if cfg.NewrelicEnabled {
@@ -63,38 +38,66 @@ func startNewrelic() {
 	}
 }
 
-func readConfig(cfg *proxy.Config) {
-	configFileName := "config.json"
+// Absolute path of the config file, read again on reloads
+var configFileName string
+
+func readConfig(cfg *proxy.Config) []*proxy.Config {
+	configFileName = "config.json"
 	if len(os.Args) > 1 {
 		configFileName = os.Args[1]
 	}
 	configFileName, _ = filepath.Abs(configFileName)
 	log.Printf("Loading config: %v", configFileName)
 
-	configFile, err := os.Open(configFileName)
+	data, err := ioutil.ReadFile(configFileName)
 	if err != nil {
 		log.Fatal("File error: ", err.Error())
 	}
-	defer configFile.Close()
-	jsonParser := json.NewDecoder(configFile)
-	if err := jsonParser.Decode(&cfg); err != nil {
+	if err := json.Unmarshal(data, cfg); err != nil {
 		log.Fatal("Config error: ", err.Error())
 	}
+	deriveConfig(cfg)
+	configs, err := poolConfigs(cfg, data)
+	if err != nil {
+		log.Fatal("Config error: ", err.Error())
+	}
+	if len(configs) > 1 {
+		checkPools(configs)
+	}
+	return configs
+}
 
+// deriveConfig fills the settings of a module taken from the others.
+func deriveConfig(cfg *proxy.Config) {
 	if cfg.Mysql.Coin == "" {
 		cfg.Mysql.Coin = cfg.Coin
 		cfg.Mysql.Threshold = cfg.Payouts.Threshold
 	}
 
 	cfg.Api.Threshold = cfg.Payouts.Threshold
+	cfg.Api.MinPayoutLimit, cfg.Api.MaxPayoutLimit = cfg.Payouts.PayoutLimits()
+	cfg.Api.CompensationMaxTotal = cfg.Payouts.Compensation.MaxTotal
 	cfg.Api.Coin = cfg.Coin
 	cfg.Api.Name = cfg.Name
 	cfg.Api.Depth = cfg.BlockUnlocker.Depth
+	cfg.Api.MaxCandidateAge = cfg.BlockUnlocker.MaxCandidateAge
+	cfg.Api.PoolFee = cfg.BlockUnlocker.PoolFee
+	cfg.Api.PayoutInterval = cfg.Payouts.Interval
+	cfg.Api.PayoutWindows = cfg.Payouts.Windows
+	cfg.Api.Daemon = cfg.BlockUnlocker.Daemon
+	cfg.Api.DaemonTimeout = cfg.BlockUnlocker.Timeout
+	cfg.Api.NetId = cfg.NetId
+	cfg.Mysql.Pool = cfg.Name
+	cfg.BlockUnlocker.Name = cfg.Name
+	cfg.Payouts.Name = cfg.Name
 }
 
 func main() {
-	readConfig(&cfg)
+	configs := readConfig(&cfg)
 	rand.Seed(time.Now().UnixNano())
+	if err := util.SetDisplayLocation(cfg.Timezone); err != nil {
+		log.Fatalf("Invalid timezone %v: %v", cfg.Timezone, err)
+	}
 
 	if cfg.Threads > 0 {
 		runtime.GOMAXPROCS(cfg.Threads)
@@ -107,40 +110,55 @@ func main() {
 
 	startNewrelic()
 
-	backend = redis.NewRedisClient(&cfg.Redis, cfg.Coin, cfg.Proxy.Difficulty, cfg.Pplns)
-	pong, err := backend.Check()
-	if err != nil {
-		log.Printf("Can't establish connection to backend: %v", err)
-	} else {
-		log.Printf("Backend check reply: %v", pong)
+	pools := make([]*pool, len(configs))
+	for i, config := range configs {
+		pools[i] = openPool(config)
 	}
-
-	if db, err = mysql.New(&cfg.Mysql, cfg.Proxy.Difficulty, backend); err != nil {
-		log.Printf("Can't establish connection to mysql: %v", err)
-		os.Exit(1)
+	// The first pool is the top-level config, it also holds the log levels and runs the maintenance commands
+	backend, db = pools[0].backend, pools[0].db
+	levels := xlog.Init(&cfg.Log, backend)
+
+	// Maintenance: <config> rebuild-stats [--apply]
+	if len(os.Args) > 2 && os.Args[2] == "rebuild-stats" {
+		rebuildStats(len(os.Args) > 3 && os.Args[3] == "--apply")
+		os.Exit(0)
 	}
-	backend.SetDB(db)
-
-	log.Printf("connected mysql host:%v",cfg.Mysql.Endpoint)
 
+	if err := hook.Configure(&cfg.Shutdown); err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+	for _, p := range pools {
+		p.openServices()
+	}
 	hook.RegistryMainHook(func() {
-		logger.Close()	// Save all logs.
+		closeLoggers(pools)	// Save all logs.
 	})
-
-	// logger is pooling
-	logger = plogger.New(db, cfg.Coin, cfg.Mysql.LogTableName)
-
-	if cfg.Proxy.Enabled {
-		go startProxy()
+	if cfg.LogSinks.Enabled {
+		if err := sinks.Start(&cfg.LogSinks); err != nil {
+			log.Fatalf("Failed to start log sinks: %v", err)
+		}
 	}
-	if cfg.Api.Enabled {
-		go startApi()
+	backend.InitPubSub(redis.ChannelLog, levels)
+
+	// Maintenance: <config> backfill-rewards [--apply]
+	if len(os.Args) > 2 && os.Args[2] == "backfill-rewards" {
+		ok := backfillRewards(len(os.Args) > 3 && os.Args[3] == "--apply")
+		closeLoggers(pools)
+		if !ok {
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
-	if cfg.BlockUnlocker.Enabled {
-		go startBlockUnlocker()
+
+	for _, p := range pools {
+		if err := p.start(); err != nil {
+			log.Fatalf("Failed to start pool %v: %v", p.cfg.Name, err)
+		}
 	}
-	if cfg.Payouts.Enabled {
-		go startPayoutsProcessor()
+	startConfigManager(pools)
+	go startApis(pools)
+	if cfg.Metrics.Enabled {
+		go metrics.Start(&cfg.Metrics)
 	}
 
 	hook.Listen()
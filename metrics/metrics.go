@@ -0,0 +1,293 @@
+// Package metrics exposes the pool's metrics in the Prometheus text format.
+//
+// Every module records into the package-level registry of its process, which is served
+// on /metrics when enabled.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util/xlog"
+)
+
+var log = xlog.Module("metrics")
+
+type Config struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen"`
+}
+
+// Start serves /metrics on the configured address.
+func Start(cfg *Config) {
+	if len(cfg.Listen) == 0 {
+		cfg.Listen = "127.0.0.1:9100"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", Handler)
+	log.Infof("Starting metrics on %v", cfg.Listen)
+	if err := http.ListenAndServe(cfg.Listen, mux); err != nil {
+		log.Fatalf("Failed to start metrics: %v", err)
+	}
+}
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+var (
+	mu      sync.Mutex
+	metrics = make(map[string]metric)
+)
+
+func register(m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := metrics[m.name()]; ok {
+		panic("metrics: " + m.name() + " registered twice")
+	}
+	metrics[m.name()] = m
+}
+
+// Handler writes all registered metrics, sorted by name.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	list := make([]metric, 0, len(metrics))
+	for _, m := range metrics {
+		list = append(list, m)
+	}
+	mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name() < list[j].name() })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, m := range list {
+		m.write(bw)
+	}
+	bw.Flush()
+}
+
+// desc is the name, help and label names shared by all kinds of metrics.
+type desc struct {
+	Name   string
+	Help   string
+	Labels []string
+}
+
+func (d *desc) name() string {
+	return d.Name
+}
+
+func (d *desc) header(w *bufio.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.Name, d.Help, d.Name, kind)
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.Labels) {
+		panic(fmt.Sprintf("metrics: %v takes %v label values, got %v", d.Name, len(d.Labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labels formats the label pairs of a series, plus an optional extra pair.
+func (d *desc) labels(key string, extra ...string) string {
+	var pairs []string
+	if len(d.Labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.Labels[i]+"="+strconv.Quote(v))
+		}
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, extra[0]+"="+strconv.Quote(extra[1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// values is a series per combination of label values.
+type values struct {
+	desc
+	mu     sync.Mutex
+	series map[string]float64
+}
+
+func (v *values) writeSeries(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.Name, v.labels(k), formatFloat(v.series[k]))
+	}
+}
+
+type Counter struct {
+	values
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{values{desc: desc{name, help, labels}, series: make(map[string]float64)}}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	k := c.key(labelValues)
+	c.mu.Lock()
+	c.series[k] += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w, "counter")
+	c.writeSeries(w)
+}
+
+type Gauge struct {
+	values
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{values{desc: desc{name, help, labels}, series: make(map[string]float64)}}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	k := g.key(labelValues)
+	g.mu.Lock()
+	g.series[k] = value
+	g.mu.Unlock()
+}
+
+// Reset drops the series whose leading label values are labelValues, all of them without any,
+// so labels gone since the last collection stop being reported.
+func (g *Gauge) Reset(labelValues ...string) {
+	prefix := strings.Join(labelValues, "\xff")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for k := range g.series {
+		if len(labelValues) == 0 || k == prefix || strings.HasPrefix(k, prefix+"\xff") {
+			delete(g.series, k)
+		}
+	}
+}
+
+func (g *Gauge) SetBool(value bool, labelValues ...string) {
+	if value {
+		g.Set(1, labelValues...)
+	} else {
+		g.Set(0, labelValues...)
+	}
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.header(w, "gauge")
+	g.writeSeries(w)
+}
+
+// GaugeFunc is a gauge read when the metrics are scraped.
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{Name: name, Help: help}, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.header(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.Name, formatFloat(g.fn()))
+}
+
+// DefBuckets suit latencies in seconds of requests to nodes and storage.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name, help, labels}, buckets: buckets, series: make(map[string]*histogramSeries)}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	k := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	for i, b := range h.buckets {
+		if value <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// Since observes the seconds passed since start.
+func (h *Histogram) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.Name, h.labels(k, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.Name, h.labels(k, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.Name, h.labels(k), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.Name, h.labels(k), s.count)
+	}
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type is %q", ct)
+	}
+	return w.Body.String()
+}
+
+func expectLines(t *testing.T, body string, lines ...string) {
+	for _, l := range lines {
+		if !strings.Contains(body, l+"\n") {
+			t.Errorf("Missing %q in:\n%s", l, body)
+		}
+	}
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	c := NewCounter("test_shares_total", "Shares.", "result")
+	c.Inc("valid")
+	c.Add(2, "valid")
+	c.Inc("stale")
+	g := NewGauge("test_halted", "Halted.")
+	g.SetBool(true)
+
+	expectLines(t, scrape(t),
+		"# TYPE test_shares_total counter",
+		`test_shares_total{result="stale"} 1`,
+		`test_shares_total{result="valid"} 3`,
+		"# HELP test_halted Halted.",
+		"test_halted 1",
+	)
+}
+
+func TestGaugeReset(t *testing.T) {
+	g := NewGauge("test_region_hashrate", "Hashrate.", "pool", "region")
+	g.Set(1, "main", "eu")
+	g.Set(2, "main", "asia")
+	g.Set(3, "solo", "eu")
+	g.Reset("main")
+	g.Set(4, "main", "eu")
+
+	body := scrape(t)
+	expectLines(t, body, `test_region_hashrate{pool="main",region="eu"} 4`, `test_region_hashrate{pool="solo",region="eu"} 3`)
+	if strings.Contains(body, `region="asia"`) {
+		t.Errorf("Must drop the reset series, got:\n%s", body)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "method")
+	h.Observe(0.05, "eth_getWork")
+	h.Observe(0.5, "eth_getWork")
+	h.Observe(3, "eth_getWork")
+
+	expectLines(t, scrape(t),
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{method="eth_getWork",le="0.1"} 1`,
+		`test_latency_seconds_bucket{method="eth_getWork",le="1"} 2`,
+		`test_latency_seconds_bucket{method="eth_getWork",le="+Inf"} 3`,
+		`test_latency_seconds_sum{method="eth_getWork"} 3.55`,
+		`test_latency_seconds_count{method="eth_getWork"} 3`,
+	)
+}
+
+func TestStorageErrors(t *testing.T) {
+	if MysqlError("main", "test_op", nil) != nil {
+		t.Error("nil must pass through")
+	}
+	MysqlError("main", "test_op", errTest{})
+	expectLines(t, scrape(t), `storage_errors_total{pool="main",backend="mysql",op="test_op"} 1`)
+}
+
+type errTest struct{}
+
+func (errTest) Error() string { return "test" }
@@ -0,0 +1,73 @@
+package metrics
+
+// Metrics of the pool's modules, labeled by the pool they belong to. A process only reports the
+// modules it runs.
+var (
+	// api
+	PoolHashrate   = NewGauge("pool_hashrate", "Pool hashrate in H/s, as of the last stats collection.", "pool")
+	PoolMiners     = NewGauge("pool_miners", "Miners online, as of the last stats collection.", "pool")
+	PoolCandidates = NewGauge("pool_candidates", "Block candidates waiting for the unlocker, as of the last stats collection.", "pool")
+	RegionHashrate = NewGauge("pool_region_hashrate", "Hashrate in H/s of the shares submitted through the stratum listeners of a region, as of the last stats collection.", "pool", "region")
+
+	// proxy
+	ProxySessions = NewGauge("proxy_sessions", "Stratum sessions connected to this proxy.", "pool")
+	Shares        = NewCounter("proxy_shares_total", "Shares submitted to this proxy by result: valid, stale_credited, buffered, duplicate, outage or the reject class.", "pool", "result")
+	BlocksFound   = NewCounter("proxy_blocks_found_total", "Blocks found and accepted by the node.", "pool")
+	ShareAudits   = NewCounter("proxy_share_audits_total", "Accepted shares sampled for background revalidation by result: valid, mismatch or dropped when the queue is full.", "pool", "result")
+	Bans          = NewCounter("proxy_bans_total", "Temporary bans applied by kind, ip or login, and reason.", "pool", "kind", "reason")
+	SlowConsumers = NewCounter("proxy_slow_consumers_total", "Stratum connections dropped as their send queue was full.", "pool")
+	ShareLog      = NewCounter("proxy_share_log_total", "Accepted shares for the share log by result: written, failed or dropped when the queue is full.", "pool", "result")
+
+	// unlocker
+	UnlockerHalted      = NewGauge("unlocker_halted", "1 if the block unlocker stopped after a critical error.", "pool")
+	UnlockerCandidates  = NewGauge("unlocker_pending_candidates", "Block candidates deep enough to unlock in the last unlock pass.", "pool")
+	UnlockerSkipped     = NewCounter("unlocker_skipped_passes_total", "Unlock passes skipped by reason: syncing, peers, halted or error, as the node couldn't be trusted.", "pool", "reason")
+	UnlockerStuck       = NewGauge("unlocker_stuck_blocks", "Blocks neither matured nor orphaned after maxCandidateAge, as of the last unlock pass.", "pool")
+	UnlockerChainHalted = NewGauge("unlocker_chain_halted", "1 while the unlocker daemon's head hasn't moved for haltedBlocks block times.", "pool")
+	UnlockerRechecks    = NewCounter("unlocker_orphan_rechecks_total", "Blocks missing from the chain in an unlock pass and left to check again before orphaning them.", "pool")
+	UnlockerArchived    = NewCounter("unlocker_archived_total", "Entries moved out of Redis by the archiver by kind: round, or member of a stats set.", "pool", "kind")
+
+	// payouts
+	PayoutsHalted        = NewGauge("payouts_halted", "1 if payouts stopped after a critical error.", "pool")
+	PayoutQueue          = NewGauge("payouts_queue_depth", "Payees over the threshold at the start of the last payout run.", "pool")
+	PayoutsSent          = NewCounter("payouts_sent_total", "Payout transactions sent.", "pool")
+	PayoutsGasSkipped    = NewCounter("payouts_gas_skipped_total", "Payout runs skipped with the gas price over the ceiling.", "pool")
+	PayoutNonceResyncs   = NewCounter("payouts_nonce_resyncs_total", "Payout nonces resynced to the node by kind: gap, or outside for txs sent outside the payer.", "pool", "kind")
+	PayoutWalletBalance  = NewGauge("payouts_wallet_balance_shannon", "Balance of the payout address in Shannon, as of the last wallet check.", "pool")
+	PayoutWalletRequired = NewGauge("payouts_wallet_required_shannon", "Balance the payees over their threshold and the reserve need in Shannon, as of the last wallet check.", "pool")
+	PayoutContracts      = NewCounter("payouts_contract_payees_total", "Payees found to be contracts by state: detected, paid with the contract gas limit, or held for review.", "pool", "state")
+	PayoutsSwept         = NewCounter("payouts_swept_total", "Sweeps of the payout address to the cold wallet sent.", "pool")
+
+	// rpc
+	RPCDuration = NewHistogram("rpc_request_duration_seconds", "Latency of JSON-RPC requests to nodes.", DefBuckets, "pool", "client", "method")
+	RPCErrors   = NewCounter("rpc_errors_total", "Failed JSON-RPC requests to nodes.", "pool", "client", "method")
+
+	// share journal
+	JournalReplayed = NewCounter("share_journal_replayed_total", "Unwritten shares replayed from the journal at start, by result: written or failed.", "pool", "result")
+	JournalPending  = NewGauge("share_journal_pending", "Shares in the journal not written to both backends yet.", "pool")
+	JournalBytes    = NewGauge("share_journal_bytes", "Size of the share journal file.", "pool")
+
+	// plogger sinks
+	LogSinkEntries = NewCounter("log_sink_entries_total", "System log entries by sink, table for the log table, and result: sent, overflow (to the overflow file) or dropped.", "pool", "sink", "result")
+
+	// events
+	EventsPublished = NewCounter("events_published_total", "Pool events by publisher and result: published or dropped.", "pool", "publisher", "result")
+
+	// storage
+	StorageErrors = NewCounter("storage_errors_total", "Failed MySQL and Redis operations on the share, block and payout paths.", "pool", "backend", "op")
+)
+
+// MysqlError and RedisError count err, if any, and return it.
+func MysqlError(pool, op string, err error) error {
+	if err != nil {
+		StorageErrors.Inc(pool, "mysql", op)
+	}
+	return err
+}
+
+func RedisError(pool, op string, err error) error {
+	if err != nil {
+		StorageErrors.Inc(pool, "redis", op)
+	}
+	return err
+}
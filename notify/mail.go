@@ -0,0 +1,271 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type SmtpConfig struct {
+	Host string `json:"host"`
+	// 587 by default, 465 with tls
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Sender address, e.g. "Pool <noreply@example.com>"
+	From string `json:"from"`
+	// Connect with TLS instead of upgrading with STARTTLS
+	TLS     bool   `json:"tls"`
+	Timeout string `json:"timeout"`
+}
+
+// Data is what the templates are executed with. Fields of other kinds are empty.
+type Data struct {
+	Pool  string
+	Coin  string
+	URL   string
+	Login string
+	// payout: in coins, and the transaction
+	Amount string
+	Tx     string
+	// offline: the workers and how long they sent no shares
+	Workers    []string
+	OfflineFor string
+	// hashrate: in H/s, the drop in percent
+	Hashrate int64
+	Average  int64
+	Drop     float64
+}
+
+// Built-in templates. The first line is the subject, after a blank line comes the body.
+var defaultTemplates = map[string]string{
+	KindPayout: `Subject: {{.Pool}}: payout of {{.Amount}} {{.Coin}} sent
+
+A payout of {{.Amount}} {{.Coin}} was sent to {{.Login}}.
+Transaction: {{.Tx}}
+{{template "footer" .}}`,
+	KindOffline: `Subject: {{.Pool}}: {{len .Workers}} worker(s) offline
+
+These workers of {{.Login}} sent no shares for {{.OfflineFor}}:
+{{range .Workers}}
+  {{.}}{{end}}
+{{template "footer" .}}`,
+	KindHashrate: `Subject: {{.Pool}}: hashrate down {{printf "%.0f" .Drop}}%
+
+The hashrate of {{.Login}} dropped to {{hashrate .Hashrate}}, {{printf "%.0f" .Drop}}% below its recent average of {{hashrate .Average}}.
+{{template "footer" .}}`,
+}
+
+const footerTemplate = `{{define "footer"}}
+{{if .URL}}Your account: {{.URL}}/#/account/{{.Login}}
+{{end}}You get this email because you turned on notifications of {{.Pool}} for {{.Login}}.{{end}}`
+
+type templates struct {
+	byKind map[string]*template.Template
+}
+
+// loadTemplates parses the built-in templates, replaced by <kind>.tmpl files in dir if it has them.
+func loadTemplates(dir string) (*templates, error) {
+	funcs := template.FuncMap{"hashrate": formatHashrate}
+	t := &templates{byKind: make(map[string]*template.Template)}
+	for kind, text := range defaultTemplates {
+		if len(dir) > 0 {
+			custom, err := ioutil.ReadFile(filepath.Join(dir, kind+".tmpl"))
+			if err == nil {
+				text = string(custom)
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		tmpl, err := template.New(kind).Funcs(funcs).Parse(footerTemplate)
+		if err == nil {
+			tmpl, err = tmpl.Parse(text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", kind, err)
+		}
+		t.byKind[kind] = tmpl
+	}
+	return t, nil
+}
+
+// render executes the template of kind and splits the result into subject and body.
+func (t *templates) render(kind string, data *Data) (string, string, error) {
+	tmpl, ok := t.byKind[kind]
+	if !ok {
+		return "", "", fmt.Errorf("no template for %v", kind)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	text := strings.Replace(buf.String(), "\r\n", "\n", -1)
+	parts := strings.SplitN(text, "\n\n", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "Subject:") {
+		return "", "", errors.New("template must start with a Subject: line and a blank line")
+	}
+	return strings.TrimSpace(strings.TrimPrefix(parts[0], "Subject:")), strings.TrimSpace(parts[1]) + "\n", nil
+}
+
+func formatHashrate(h int64) string {
+	units := []string{"H/s", "KH/s", "MH/s", "GH/s", "TH/s", "PH/s"}
+	value := float64(h)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + " " + units[i]
+}
+
+type email struct {
+	kind    string
+	to      string
+	subject string
+	body    string
+}
+
+// mailer sends the queued emails one by one, retrying while the SMTP server is unavailable.
+type mailer struct {
+	config  *SmtpConfig
+	from    string
+	timeout time.Duration
+	emails  chan *email
+}
+
+const mailRetries = 3
+
+func newMailer(cfg *SmtpConfig, queueSize int) (*mailer, error) {
+	if len(cfg.Host) == 0 || len(cfg.From) == 0 {
+		return nil, errors.New("smtp needs a host and a from address")
+	}
+	if cfg.Port <= 0 {
+		cfg.Port = 587
+		if cfg.TLS {
+			cfg.Port = 465
+		}
+	}
+	if queueSize <= 0 {
+		queueSize = 1000
+	}
+	m := &mailer{config: cfg, timeout: 30 * time.Second, emails: make(chan *email, queueSize)}
+	if len(cfg.Timeout) > 0 {
+		m.timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, err
+	}
+	m.from = from.Address
+	go m.run()
+	return m, nil
+}
+
+func (m *mailer) queue(e *email) {
+	select {
+	case m.emails <- e:
+	default:
+		log.Printf("Notification queue is full, dropped %v email to %v", e.kind, e.to)
+	}
+}
+
+func (m *mailer) run() {
+	for e := range m.emails {
+		backoff := 5 * time.Second
+		for attempt := 1; ; attempt++ {
+			err := m.send(e)
+			if err == nil {
+				break
+			}
+			if attempt >= mailRetries {
+				log.Printf("Dropped %v email to %v after %v attempts: %v", e.kind, e.to, attempt, err)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (m *mailer) send(e *email) error {
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	tlsConfig := &tls.Config{ServerName: m.config.Host}
+
+	var conn net.Conn
+	var err error
+	if m.config.TLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: m.timeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, m.timeout)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(m.timeout))
+	c, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && !m.config.TLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if len(m.config.Username) > 0 {
+		if err := c.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(e.to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(composeMessage(m.config.From, e)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// composeMessage builds a plain text UTF-8 message.
+func composeMessage(from string, e *email) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %v\r\n", from)
+	fmt.Fprintf(&buf, "To: %v\r\n", e.to)
+	fmt.Fprintf(&buf, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", e.subject))
+	fmt.Fprintf(&buf, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(strings.Replace(e.body, "\n", "\r\n", -1)))
+	qp.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,256 @@
+// Package notify emails miners about their own account: payouts sent, workers gone offline and
+// hashrate drops. Miners opt in to each through the API, with the email they set there.
+//
+// Payouts are read from the push channel of Redis and notifications are recorded in Redis so they
+// aren't sent twice, but only one instance of the pool should have notifications enabled.
+package notify
+
+import (
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type Config struct {
+	Enabled bool       `json:"enabled"`
+	Smtp    SmtpConfig `json:"smtp"`
+	// Directory with payout.tmpl, offline.tmpl or hashrate.tmpl replacing the built-in templates
+	Templates string `json:"templates"`
+	// Link to the pool in the emails
+	URL string `json:"url"`
+	// How often workers and hashrates are checked
+	CheckInterval string `json:"checkInterval"`
+	// A worker without shares for this long is offline
+	OfflineAfter string `json:"offlineAfter"`
+	// Drop from the average of the previous samples that is notified, in percent
+	HashrateDrop float64 `json:"hashrateDrop"`
+	// Miner chart samples the average is taken over
+	HashrateWindow int `json:"hashrateWindow"`
+	// A hashrate drop is notified again after this long at the earliest
+	Cooldown string `json:"cooldown"`
+	// Emails queued while the SMTP server is unavailable, further ones are dropped
+	QueueSize int `json:"queueSize"`
+}
+
+// Kinds of notifications, also the names of their templates
+const (
+	KindPayout   = "payout"
+	KindOffline  = "offline"
+	KindHashrate = "hashrate"
+)
+
+// Workers last seen longer ago than this aren't notified anymore, they were retired.
+const offlineHorizon = 24 * time.Hour
+
+type Notifier struct {
+	config    *Config
+	backend   *redis.RedisClient
+	db        *mysql.Database
+	mailer    *mailer
+	templates *templates
+	coin      string
+	pool      string
+
+	offlineAfter time.Duration
+	cooldown     time.Duration
+}
+
+func Start(cfg *Config, backend *redis.RedisClient, db *mysql.Database, coin, pool string) *Notifier {
+	if cfg.HashrateDrop <= 0 {
+		cfg.HashrateDrop = 50
+	}
+	if cfg.HashrateWindow <= 0 {
+		cfg.HashrateWindow = 12
+	}
+	n := &Notifier{
+		config:       cfg,
+		backend:      backend,
+		db:           db,
+		coin:         coin,
+		pool:         pool,
+		offlineAfter: 30 * time.Minute,
+		cooldown:     6 * time.Hour,
+	}
+	if len(cfg.OfflineAfter) > 0 {
+		n.offlineAfter = util.MustParseDuration(cfg.OfflineAfter)
+	}
+	if len(cfg.Cooldown) > 0 {
+		n.cooldown = util.MustParseDuration(cfg.Cooldown)
+	}
+
+	tmpl, err := loadTemplates(cfg.Templates)
+	if err != nil {
+		log.Fatalf("Failed to load notification templates: %v", err)
+	}
+	n.templates = tmpl
+	m, err := newMailer(&cfg.Smtp, cfg.QueueSize)
+	if err != nil {
+		log.Fatalf("Invalid notification SMTP settings: %v", err)
+	}
+	n.mailer = m
+
+	backend.InitPubSub(redis.ChannelPush, n)
+	go n.run()
+	log.Printf("Emailing miner notifications through %v", cfg.Smtp.Host)
+	return n
+}
+
+func (n *Notifier) run() {
+	intv := time.Minute
+	if len(n.config.CheckInterval) > 0 {
+		intv = util.MustParseDuration(n.config.CheckInterval)
+	}
+	timer := time.NewTimer(intv)
+	for {
+		<-timer.C
+		n.check()
+		timer.Reset(intv)
+	}
+}
+
+// RedisMessage notifies the payments of ChannelPush.
+func (n *Notifier) RedisMessage(payload string) {
+	e, ok := redis.ParsePushEvent(payload)
+	if !ok || e.Type != redis.PushPaymentSent {
+		return
+	}
+	settings, err := n.db.GetMinerSettings(e.Login)
+	if err != nil {
+		log.Printf("Failed to load notification settings of %v: %v", e.Login, err)
+		return
+	}
+	if settings == nil || !settings.Notify.Payout || len(settings.NotifyEmail) == 0 {
+		return
+	}
+	amount, _ := e.Data["amount"].(int64)
+	tx, _ := e.Data["tx"].(string)
+	data := n.newData(e.Login)
+	data.Amount = formatShannon(amount)
+	data.Tx = tx
+	n.send(KindPayout, settings.NotifyEmail, data)
+}
+
+// check looks for offline workers and hashrate drops of the miners that opted in.
+func (n *Notifier) check() {
+	subscribers, err := n.db.GetNotifySubscribers()
+	if err != nil {
+		log.Printf("Failed to load notification subscribers: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, s := range subscribers {
+		if s.Options.Offline {
+			n.checkOffline(s, now)
+		}
+		if s.Options.Hashrate {
+			n.checkHashrate(s)
+		}
+	}
+}
+
+func (n *Notifier) checkOffline(s *mysql.NotifySubscriber, now time.Time) {
+	workers, err := n.backend.GetWorkerShares(s.Login)
+	if err != nil {
+		log.Printf("Failed to load workers of %v: %v", s.Login, err)
+		return
+	}
+	offlineSince := now.Add(-n.offlineAfter).Unix()
+	retiredSince := now.Add(-offlineHorizon).Unix()
+
+	var offline []string
+	for id, w := range workers {
+		if w.LastSeen >= offlineSince || w.LastSeen < retiredSince {
+			continue
+		}
+		// Once per outage, the next one has another last share
+		first, err := n.backend.MarkNotified(KindOffline, util.Join(s.Login, id, w.LastSeen), offlineHorizon+n.offlineAfter)
+		if err != nil {
+			log.Printf("Failed to record offline notification of %v.%v: %v", s.Login, id, err)
+			continue
+		}
+		if first {
+			offline = append(offline, id)
+		}
+	}
+	if len(offline) == 0 {
+		return
+	}
+	data := n.newData(s.Login)
+	data.Workers = offline
+	data.OfflineFor = n.offlineAfter.String()
+	n.send(KindOffline, s.Email, data)
+}
+
+func (n *Notifier) checkHashrate(s *mysql.NotifySubscriber) {
+	charts, err := n.backend.GetMinerCharts(int64(n.config.HashrateWindow), s.Login)
+	if err != nil {
+		log.Printf("Failed to load hashrate of %v: %v", s.Login, err)
+		return
+	}
+	if len(charts) == 0 {
+		return
+	}
+	previous := make([]int64, 0, len(charts)-1)
+	for _, c := range charts[1:] {
+		previous = append(previous, c.MinerHash)
+	}
+	current := charts[0].MinerHash
+	average, dropped := hashrateDropped(current, previous, n.config.HashrateDrop)
+	if !dropped {
+		return
+	}
+	first, err := n.backend.MarkNotified(KindHashrate, s.Login, n.cooldown)
+	if err != nil {
+		log.Printf("Failed to record hashrate notification of %v: %v", s.Login, err)
+		return
+	}
+	if !first {
+		return
+	}
+	data := n.newData(s.Login)
+	data.Hashrate = current
+	data.Average = average
+	data.Drop = 100 - float64(current)*100/float64(average)
+	n.send(KindHashrate, s.Email, data)
+}
+
+// hashrateDropped tells whether current is drop percent or more below the average of previous, the
+// samples before it. A few samples are needed for an average.
+func hashrateDropped(current int64, previous []int64, drop float64) (int64, bool) {
+	if len(previous) < 3 {
+		return 0, false
+	}
+	var sum int64
+	for _, h := range previous {
+		sum += h
+	}
+	average := sum / int64(len(previous))
+	if average <= 0 {
+		return average, false
+	}
+	return average, float64(current) <= float64(average)*(1-drop/100)
+}
+
+func (n *Notifier) newData(login string) *Data {
+	return &Data{Pool: n.pool, Coin: strings.ToUpper(n.coin), URL: n.config.URL, Login: login}
+}
+
+func (n *Notifier) send(kind, to string, data *Data) {
+	subject, body, err := n.templates.render(kind, data)
+	if err != nil {
+		log.Printf("Failed to render %v notification of %v: %v", kind, data.Login, err)
+		return
+	}
+	n.mailer.queue(&email{kind: kind, to: to, subject: subject, body: body})
+}
+
+// formatShannon formats Shannon in whole coins, without trailing zeros.
+func formatShannon(amount int64) string {
+	s := new(big.Rat).SetFrac(big.NewInt(amount), util.Shannon).FloatString(9)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashrateDropped(t *testing.T) {
+	tests := []struct {
+		current  int64
+		previous []int64
+		average  int64
+		dropped  bool
+	}{
+		{40, []int64{100, 100, 100}, 100, true},
+		{50, []int64{100, 100, 100}, 100, true},
+		{51, []int64{100, 100, 100}, 100, false},
+		{0, []int64{100, 100}, 0, false},
+		{0, []int64{0, 0, 0}, 0, false},
+	}
+	for i, tt := range tests {
+		average, dropped := hashrateDropped(tt.current, tt.previous, 50)
+		if average != tt.average || dropped != tt.dropped {
+			t.Errorf("%v: expected %v, %v got %v, %v", i, tt.average, tt.dropped, average, dropped)
+		}
+	}
+}
+
+func TestFormatShannon(t *testing.T) {
+	for amount, expected := range map[int64]string{1000000000: "1", 1500000000: "1.5", 1: "0.000000001", 0: "0"} {
+		if s := formatShannon(amount); s != expected {
+			t.Errorf("%v: expected %v got %v", amount, expected, s)
+		}
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	tmpl, err := loadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &Data{Pool: "Pool", Coin: "DGN", URL: "https://pool.example", Login: "0xabc", Amount: "1.5", Tx: "0xtx"}
+	subject, body, err := tmpl.render(KindPayout, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Pool: payout of 1.5 DGN sent" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	if !strings.Contains(body, "Transaction: 0xtx") || !strings.Contains(body, "https://pool.example/#/account/0xabc") {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	data = &Data{Pool: "Pool", Login: "0xabc", Hashrate: 40000000, Average: 100000000, Drop: 60}
+	subject, body, err = tmpl.render(KindHashrate, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Pool: hashrate down 60%" || !strings.Contains(body, "40.00 MH/s") || strings.Contains(body, "Your account") {
+		t.Errorf("unexpected hashrate email %q %q", subject, body)
+	}
+}
+
+func TestCustomTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	custom := "Subject: {{len .Workers}} down\n\n{{range .Workers}}{{.}} {{end}}{{template \"footer\" .}}"
+	if err := ioutil.WriteFile(filepath.Join(dir, KindOffline+".tmpl"), []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := loadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, body, err := tmpl.render(KindOffline, &Data{Pool: "Pool", Login: "0xabc", Workers: []string{"rig1", "rig2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "2 down" || !strings.HasPrefix(body, "rig1 rig2") {
+		t.Errorf("unexpected offline email %q %q", subject, body)
+	}
+	// The others stay built in
+	if _, _, err := tmpl.render(KindPayout, &Data{}); err != nil {
+		t.Error(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, KindPayout+".tmpl"), []byte("no subject"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err = loadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tmpl.render(KindPayout, &Data{}); err == nil {
+		t.Error("expected an error without a subject")
+	}
+}
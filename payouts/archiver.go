@@ -0,0 +1,178 @@
+package payouts
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type ArchiverConfig struct {
+	// Move the shares of settled rounds and old stats out of Redis, to keep its memory bounded
+	Enabled bool `json:"enabled"`
+	// Time between archive runs, on the unlock passes. 1h by default
+	Interval string `json:"interval"`
+	// Time data is kept in Redis after its block was found. 168h by default
+	Retention string `json:"retention"`
+	// Also write what is archived to gzipped JSON lines in this directory, one file a day
+	Dir string `json:"dir"`
+	// Keys scanned for rounds in a run, 100000 by default
+	MaxKeys int64 `json:"maxKeys"`
+}
+
+// archivedRound is a round as written to the archive files.
+type archivedRound struct {
+	Type   string           `json:"type"`
+	Height int64            `json:"height"`
+	Nonce  string           `json:"nonce"`
+	Shares map[string]int64 `json:"shares"`
+}
+
+type archivedSet struct {
+	Type string `json:"type"`
+	*redis.ArchivedSet
+}
+
+type archiver struct {
+	config    *ArchiverConfig
+	interval  time.Duration
+	retention time.Duration
+	lastRun   time.Time
+}
+
+func newArchiver(cfg *ArchiverConfig) *archiver {
+	if !cfg.Enabled {
+		return nil
+	}
+	a := &archiver{config: cfg, interval: time.Hour, retention: 7 * 24 * time.Hour}
+	if len(cfg.Interval) > 0 {
+		a.interval = util.MustParseDuration(cfg.Interval)
+	}
+	if len(cfg.Retention) > 0 {
+		a.retention = util.MustParseDuration(cfg.Retention)
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = 100000
+	}
+	if len(cfg.Dir) > 0 {
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			log.Fatalf("Failed to create the archive directory %v: %v", cfg.Dir, err)
+		}
+	}
+	log.Infof("Archiving Redis rounds and stats older than %v every %v", a.retention, a.interval)
+	return a
+}
+
+// archiveRedis moves the rounds and stats of the blocks settled over retention ago from Redis to
+// MySQL, and to the archive files if set, once every archive interval. The shares of a round are
+// only dropped from Redis once MySQL and the files have them.
+func (u *BlockUnlocker) archiveRedis() {
+	a := u.archiver
+	if a == nil || time.Since(a.lastRun) < a.interval {
+		return
+	}
+	a.lastRun = time.Now()
+	foundBefore := time.Now().Add(-a.retention).Unix()
+
+	rounds, err := u.backend.ScanRounds(a.config.MaxKeys)
+	if err != nil {
+		log.Errorf("Failed to scan Redis for rounds to archive: %v", err)
+		return
+	}
+	var records []interface{}
+	var archived []*redis.RoundKey
+	for _, round := range rounds {
+		ts, err := u.db.GetRoundSettledAt(round.Height, round.Nonce)
+		if err != nil {
+			log.Errorf("Failed to look up round %v:%v to archive: %v", round.Height, round.Nonce, err)
+			return
+		}
+		if ts == 0 || ts >= foundBefore {
+			continue
+		}
+		shares, err := u.backend.GetRoundShares(round.Height, round.Nonce)
+		if err != nil {
+			log.Errorf("Failed to read round %v:%v to archive: %v", round.Height, round.Nonce, err)
+			return
+		}
+		if err := u.db.ArchiveRoundShares(round.Height, round.Nonce, shares); err != nil {
+			log.Errorf("Failed to archive round %v:%v: %v", round.Height, round.Nonce, err)
+			return
+		}
+		records = append(records, &archivedRound{Type: "round", Height: round.Height, Nonce: round.Nonce, Shares: shares})
+		archived = append(archived, round)
+	}
+	if err := a.write(u.config.Name, records); err != nil {
+		log.Errorf("Failed to write %v archived rounds: %v", len(records), err)
+		return
+	}
+	for _, round := range archived {
+		if err := u.backend.DeleteRoundBlock(round.Height, round.Nonce).Err(); err != nil {
+			log.Errorf("Failed to drop archived round %v:%v from Redis: %v", round.Height, round.Nonce, err)
+			return
+		}
+	}
+	metrics.UnlockerArchived.Add(float64(len(archived)), u.config.Name, "round")
+
+	height, err := u.db.GetSettledHeight(foundBefore)
+	if err != nil || height == 0 {
+		if err != nil {
+			log.Errorf("Failed to look up the blocks to archive: %v", err)
+		}
+		return
+	}
+	sets, err := u.backend.TrimHeightSets(height)
+	if err != nil {
+		log.Errorf("Failed to trim Redis stats up to height %v: %v", height, err)
+		return
+	}
+	members := 0
+	records = records[:0]
+	for _, set := range sets {
+		members += len(set.Members)
+		records = append(records, &archivedSet{Type: "set", ArchivedSet: set})
+	}
+	// Trimmed already, their blocks are still in MySQL
+	if err := a.write(u.config.Name, records); err != nil {
+		log.Errorf("Failed to write %v archived stats: %v", members, err)
+	}
+	metrics.UnlockerArchived.Add(float64(members), u.config.Name, "member")
+	if len(archived) > 0 || members > 0 {
+		log.Infof("Archived %v rounds and %v stats up to height %v from Redis", len(archived), members, height)
+	}
+}
+
+// write appends the records to the archive file of the day as a new gzip member, readers of gzip
+// read the members of a file as one stream.
+func (a *archiver) write(name string, records []interface{}) error {
+	if len(a.config.Dir) == 0 || len(records) == 0 {
+		return nil
+	}
+	if len(name) == 0 {
+		name = "pool"
+	}
+	file := filepath.Join(a.config.Dir, fmt.Sprintf("%v-%v.jsonl.gz", name, time.Now().UTC().Format("20060102")))
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,71 @@
+package payouts
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiverWriteAppends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := &archiver{config: &ArchiverConfig{Dir: dir}}
+	first := []interface{}{&archivedRound{Type: "round", Height: 10, Nonce: "0x1", Shares: map[string]int64{"0xa": 3}}}
+	second := []interface{}{
+		&archivedRound{Type: "round", Height: 11, Nonce: "0x2", Shares: map[string]int64{"0xb": 5}},
+		&archivedRound{Type: "round", Height: 12, Nonce: "0x3", Shares: map[string]int64{"0xa": 1, "0xb": 2}},
+	}
+	if err := a.write("dgn", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.write("dgn", second); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.write("dgn", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "dgn-*.jsonl.gz"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one archive file, got %v", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var heights []int64
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		var round archivedRound
+		if err := json.Unmarshal(scanner.Bytes(), &round); err != nil {
+			t.Fatal(err)
+		}
+		heights = append(heights, round.Height)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(heights) != 3 || heights[0] != 10 || heights[2] != 12 {
+		t.Errorf("Expected the rounds of both writes in order, got %v", heights)
+	}
+}
+
+func TestArchiverWithoutDir(t *testing.T) {
+	a := &archiver{config: &ArchiverConfig{}}
+	if err := a.write("dgn", []interface{}{&archivedRound{Type: "round"}}); err != nil {
+		t.Errorf("Expected no error without a directory, got %v", err)
+	}
+}
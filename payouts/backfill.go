@@ -0,0 +1,117 @@
+package payouts
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+// Outcomes of a round in Backfill
+const (
+	BackfillCredited = "credited"
+	BackfillDryRun   = "dry run"
+	BackfillNoShares = "no shares"
+	BackfillOrphaned = "orphaned"
+	// Timed out on the node or resolved to a block credited by another round
+	BackfillUnresolved = "unresolved"
+	BackfillFailed     = "failed"
+)
+
+// BackfillRound is a round the unlocker found no shares for, and what Backfill did with it.
+type BackfillRound struct {
+	Block *types.BlockData
+	// mysql.StateCandidateError or mysql.StateImmatureError
+	State  int
+	Result string
+	// Where the shares were read from, empty without shares
+	Source       string
+	Miners       int
+	Revenue      *big.Rat
+	MinersProfit *big.Rat
+	PoolProfit   *big.Rat
+	Err          error
+}
+
+// Backfill recomputes the rewards of the rounds the unlocker set aside for want of shares, from Redis
+// or the MySQL snapshot of the round. With apply, a candidate is credited as immature and an
+// immature round as matured, as the unlocker would have. The rounds are resolved on the node again.
+func (u *BlockUnlocker) Backfill(apply bool) ([]*BackfillRound, error) {
+	if !u.nodeReady("backfilling rewards") {
+		return nil, errors.New("the node is syncing or has too few peers")
+	}
+	blocks, err := u.db.GetErrorBlocks()
+	if err != nil {
+		return nil, err
+	}
+	rounds := make([]*BackfillRound, 0, len(blocks))
+	for _, block := range blocks {
+		round := &BackfillRound{Block: block, State: block.State}
+		rounds = append(rounds, round)
+		if block.State == mysql.StateCandidateError {
+			// Resolved like a pending candidate
+			block.State = 0
+		}
+		result, err := u.unlockCandidates([]*types.BlockData{block})
+		if err != nil {
+			round.Result, round.Err = BackfillFailed, err
+			continue
+		}
+		if len(result.maturedBlocks) == 0 {
+			round.Result = BackfillUnresolved
+			if result.orphans > 0 {
+				round.Result = BackfillOrphaned
+			}
+			continue
+		}
+		u.backfillRound(round, apply)
+	}
+	return rounds, nil
+}
+
+func (u *BlockUnlocker) backfillRound(round *BackfillRound, apply bool) {
+	block := round.Block
+	shares, source, err := u.roundShares(block)
+	if err != nil {
+		round.Result, round.Err = BackfillFailed, err
+		return
+	}
+	if len(shares) == 0 {
+		round.Result = BackfillNoShares
+		return
+	}
+	round.Source, round.Miners = source, len(shares)
+
+	r, err := u.calculateRewards(block)
+	if err != nil {
+		round.Result, round.Err = BackfillFailed, err
+		return
+	}
+	round.Revenue, round.MinersProfit, round.PoolProfit = r.revenue, r.minersProfit, r.poolProfit
+	if !apply {
+		round.Result = BackfillDryRun
+		return
+	}
+
+	logType := plogger.LogTypeMaturedBlock
+	if round.State == mysql.StateCandidateError {
+		logType = plogger.LogTypePendingBlock
+		if err = u.db.RestoreCandidate(block); err == nil {
+			err = u.db.WriteImmatureBlock(block, r.rewards, r.percents)
+		}
+	} else {
+		err = u.db.WriteMaturedBlock(block, r.rewards, r.roundWei, r.percents, r.credits, r.distribution, blockProfit(block, r.revenue, r.minersProfit, r.poolProfit, r.credits), r.remainder)
+	}
+	if err != nil {
+		round.Result, round.Err = BackfillFailed, err
+		return
+	}
+	round.Result = BackfillCredited
+	u.logs.InsertLog(fmt.Sprintf("BACKFILL %v: %v miners from %v shares, revenue %v, miners profit %v, pool profit %v",
+		block.RoundKey(), len(r.rewards), source, util.FormatRatReward(r.revenue), util.FormatRatReward(r.minersProfit), util.FormatRatReward(r.poolProfit)),
+		logType, plogger.LogErrorNothing, block.RoundHeight, block.Height, "", "")
+}
@@ -0,0 +1,98 @@
+package payouts
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/cellcrypto/open-dangnn-pool/rpc"
+)
+
+const defaultBlockCacheSize = 128
+
+// blockCache keeps the most recently used blocks and uncles fetched in an unlock pass. Candidates
+// found at nearby heights search overlapping windows, with it each block is fetched once. It is
+// cleared before every pass, a block at a height may change between passes.
+type blockCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	hits    int
+	misses  int
+}
+
+type blockCacheEntry struct {
+	key   string
+	block *rpc.GetBlockReply
+}
+
+func newBlockCache(size int) *blockCache {
+	if size <= 0 {
+		size = defaultBlockCacheSize
+	}
+	return &blockCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the cached block of key, or fetches and caches it. Errors and missing blocks
+// aren't cached.
+func (c *blockCache) get(key string, fetch func() (*rpc.GetBlockReply, error)) (*rpc.GetBlockReply, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		c.hits++
+		c.mu.Unlock()
+		return e.Value.(*blockCacheEntry).block, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	block, err := fetch()
+	if err != nil || block == nil {
+		return block, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		// Fetched meanwhile by a candidate resolving in the background
+		c.order.MoveToFront(e)
+		return block, nil
+	}
+	c.entries[key] = c.order.PushFront(&blockCacheEntry{key: key, block: block})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*blockCacheEntry).key)
+	}
+	return block, nil
+}
+
+// reset empties the cache and returns its hits and misses since the last reset.
+func (c *blockCache) reset() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hits, misses := c.hits, c.misses
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.hits, c.misses = 0, 0
+	return hits, misses
+}
+
+func (u *BlockUnlocker) getBlockByHeight(height int64) (*rpc.GetBlockReply, error) {
+	return u.blocks.get(fmt.Sprintf("height:%v", height), func() (*rpc.GetBlockReply, error) {
+		return u.rpc.GetBlockByHeight(height)
+	})
+}
+
+func (u *BlockUnlocker) getBlockByHash(hash string) (*rpc.GetBlockReply, error) {
+	return u.blocks.get("hash:"+hash, func() (*rpc.GetBlockReply, error) {
+		return u.rpc.GetBlockByHash(hash)
+	})
+}
+
+func (u *BlockUnlocker) getUncle(height int64, index int) (*rpc.GetBlockReply, error) {
+	return u.blocks.get(fmt.Sprintf("uncle:%v:%v", height, index), func() (*rpc.GetBlockReply, error) {
+		return u.rpc.GetUncleByBlockNumberAndIndex(height, index)
+	})
+}
@@ -0,0 +1,65 @@
+package payouts
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cellcrypto/open-dangnn-pool/rpc"
+)
+
+func TestBlockCache(t *testing.T) {
+	c := newBlockCache(2)
+	fetches := 0
+	fetch := func(hash string) func() (*rpc.GetBlockReply, error) {
+		return func() (*rpc.GetBlockReply, error) {
+			fetches++
+			return &rpc.GetBlockReply{Hash: hash}, nil
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		block, err := c.get("height:1", fetch("0x1"))
+		if err != nil || block.Hash != "0x1" {
+			t.Fatalf("Unexpected %v, %v", block, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected one fetch, got %v", fetches)
+	}
+
+	// The least recently used block is dropped
+	c.get("height:2", fetch("0x2"))
+	c.get("height:1", fetch("0x1"))
+	c.get("height:3", fetch("0x3"))
+	c.get("height:1", fetch("0x1"))
+	if fetches != 3 {
+		t.Errorf("Expected 3 fetches, got %v", fetches)
+	}
+	c.get("height:2", fetch("0x2"))
+	if fetches != 4 {
+		t.Errorf("Expected block 2 fetched again, got %v fetches", fetches)
+	}
+
+	// Errors and missing blocks are fetched again
+	failure := errors.New("unavailable")
+	for i := 0; i < 2; i++ {
+		if _, err := c.get("height:4", func() (*rpc.GetBlockReply, error) { fetches++; return nil, failure }); err != failure {
+			t.Errorf("Expected the error, got %v", err)
+		}
+		if block, _ := c.get("height:5", func() (*rpc.GetBlockReply, error) { fetches++; return nil, nil }); block != nil {
+			t.Errorf("Expected no block, got %v", block)
+		}
+	}
+	if fetches != 8 {
+		t.Errorf("Expected 8 fetches, got %v", fetches)
+	}
+
+	hits, misses := c.reset()
+	if hits != 4 || misses != 8 {
+		t.Errorf("Expected 4 hits and 8 misses, got %v and %v", hits, misses)
+	}
+	c.get("height:1", fetch("0x1"))
+	if fetches != 9 {
+		t.Errorf("Expected a fetch after reset, got %v fetches", fetches)
+	}
+}
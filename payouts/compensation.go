@@ -0,0 +1,229 @@
+package payouts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type CompensationConfig struct {
+	// Items applied per database transaction
+	BatchSize int `json:"batchSize"`
+	// Largest sum of absolute deltas a correction file may move, in Shannon. 0 for no limit
+	MaxTotal int64 `json:"maxTotal"`
+}
+
+// CorrectionFile lists per-miner balance deltas, e.g. the output of a reward recalculation.
+type CorrectionFile struct {
+	// Unique per file, importing the same key twice is refused
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+	// Sum of the deltas in Shannon, must match them
+	Total  int64              `json:"total"`
+	Deltas []*CorrectionDelta `json:"deltas"`
+}
+
+type CorrectionDelta struct {
+	Login string `json:"login"`
+	// In Shannon, negative to debit
+	Amount int64 `json:"amount"`
+}
+
+var correctionKeyPattern = regexp.MustCompile("^[0-9a-zA-Z._-]{1,100}$")
+
+const maxCorrectionReason = 300
+
+// ParseCorrection decodes and validates a correction file. maxTotal bounds the sum of absolute deltas, 0 for no limit.
+func ParseCorrection(data []byte, maxTotal int64) (*CorrectionFile, error) {
+	var file CorrectionFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	if !correctionKeyPattern.MatchString(file.Key) {
+		return nil, errors.New("key must be 1-100 letters, digits, '.', '_' or '-'")
+	}
+	if len(file.Reason) == 0 || len(file.Reason) > maxCorrectionReason {
+		return nil, fmt.Errorf("reason must be 1-%v characters", maxCorrectionReason)
+	}
+	if len(file.Deltas) == 0 {
+		return nil, errors.New("no deltas")
+	}
+
+	seen := make(map[string]bool)
+	var sum, moved int64
+	for i, delta := range file.Deltas {
+		delta.Login = strings.ToLower(delta.Login)
+		if !util.IsValidHexAddress(delta.Login) {
+			return nil, fmt.Errorf("delta %v: invalid login %v", i, delta.Login)
+		}
+		if seen[delta.Login] {
+			return nil, fmt.Errorf("delta %v: duplicate login %v", i, delta.Login)
+		}
+		seen[delta.Login] = true
+		if delta.Amount == 0 {
+			return nil, fmt.Errorf("delta %v: zero amount for %v", i, delta.Login)
+		}
+		sum += delta.Amount
+		if delta.Amount < 0 {
+			moved -= delta.Amount
+		} else {
+			moved += delta.Amount
+		}
+	}
+	if sum != file.Total {
+		return nil, fmt.Errorf("deltas sum to %v, total is %v", sum, file.Total)
+	}
+	if maxTotal > 0 && moved > maxTotal {
+		return nil, fmt.Errorf("deltas move %v, more than the limit of %v", moved, maxTotal)
+	}
+	return &file, nil
+}
+
+// Record converts the file into a compensation and its items. Item idempotency keys are the file key and the login.
+func (f *CorrectionFile) Record(createdBy string) (*mysql.Compensation, []*mysql.CompensationItem) {
+	c := &mysql.Compensation{
+		Key:       f.Key,
+		Kind:      mysql.CompensationCorrection,
+		Reason:    f.Reason,
+		Miners:    len(f.Deltas),
+		Total:     f.Total,
+		CreatedBy: createdBy,
+		Timestamp: util.MakeTimestamp() / 1000,
+	}
+	items := make([]*mysql.CompensationItem, 0, len(f.Deltas))
+	for _, delta := range f.Deltas {
+		items = append(items, &mysql.CompensationItem{
+			IdemKey: f.Key + ":" + delta.Login,
+			Login:   delta.Login,
+			Amount:  delta.Amount,
+		})
+	}
+	return c, items
+}
+
+// Adjustment credits or debits one miner's balance by hand, e.g. a refund.
+type Adjustment struct {
+	Login string `json:"login"`
+	// In Shannon, negative to debit
+	Amount int64 `json:"amount"`
+	// refund or compensation
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+}
+
+// ParseAdjustment decodes and validates an adjustment. maxAmount bounds its absolute amount, 0 for no limit.
+func ParseAdjustment(data []byte, maxAmount int64) (*Adjustment, error) {
+	var a Adjustment
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&a); err != nil {
+		return nil, err
+	}
+	a.Login = strings.ToLower(a.Login)
+	if !util.IsValidHexAddress(a.Login) {
+		return nil, fmt.Errorf("invalid login %v", a.Login)
+	}
+	if a.Kind != mysql.CompensationRefund && a.Kind != mysql.CompensationCredit {
+		return nil, fmt.Errorf("kind must be %v or %v", mysql.CompensationRefund, mysql.CompensationCredit)
+	}
+	if len(a.Reason) == 0 || len(a.Reason) > maxCorrectionReason {
+		return nil, fmt.Errorf("reason must be 1-%v characters", maxCorrectionReason)
+	}
+	if a.Amount == 0 {
+		return nil, errors.New("zero amount")
+	}
+	moved := a.Amount
+	if moved < 0 {
+		moved = -moved
+	}
+	if maxAmount > 0 && moved > maxAmount {
+		return nil, fmt.Errorf("amount moves %v, more than the limit of %v", moved, maxAmount)
+	}
+	return &a, nil
+}
+
+// Record converts the adjustment into a compensation of one item, keyed by the time it was requested.
+func (a *Adjustment) Record(createdBy string) (*mysql.Compensation, []*mysql.CompensationItem) {
+	ts := util.MakeTimestamp()
+	key := fmt.Sprintf("adjustment-%v-%v", ts, a.Login)
+	c := &mysql.Compensation{
+		Key:       key,
+		Kind:      a.Kind,
+		Reason:    a.Reason,
+		Miners:    1,
+		Total:     a.Amount,
+		CreatedBy: createdBy,
+		Timestamp: ts / 1000,
+	}
+	return c, []*mysql.CompensationItem{{IdemKey: key, Login: a.Login, Amount: a.Amount}}
+}
+
+// applyCompensations applies approved compensations. It runs between payouts on the payout goroutine,
+// so balances are not corrected while a payout debits them.
+func (u *PayoutsProcessor) applyCompensations() {
+	list, err := u.db.GetCompensations(mysql.CompensationApproved, 10)
+	if err != nil {
+		log.Error("Error while retrieving compensations from mysql:", err)
+		return
+	}
+	for _, c := range list {
+		if !u.applyCompensation(c) {
+			return
+		}
+	}
+}
+
+func (u *PayoutsProcessor) applyCompensation(c *mysql.Compensation) bool {
+	items, err := u.db.GetCompensationItems(c.Id, true)
+	if err != nil {
+		log.Errorf("Failed to load compensation #%v: %v", c.Id, err)
+		return false
+	}
+
+	batchSize := u.config.Compensation.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	var applied, failed int
+	for start := 0; start < len(items); start += batchSize {
+		end := start + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		changed, err := u.db.ApplyCompensationItems(c.Id, items[start:end])
+		if err != nil {
+			// Applied batches stay applied, the rest is retried on the next run.
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, "", "", "Failed to apply compensation #%v: %v", c.Id, err)
+			return false
+		}
+		for _, item := range changed {
+			if item.State == mysql.CompensationItemApplied {
+				applied++
+				u.logs.InsertLog(fmt.Sprintf("COMPENSATION #%v %v Shannon: %v", c.Id, item.Amount, c.Reason),
+					plogger.LogTypePaymentWork, plogger.LogSubTypePaymentCompensation, 0, 0, item.Login, "")
+			} else {
+				failed++
+				u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, item.Login, "",
+					"Compensation #%v debit of %v Shannon exceeds the balance of %v", c.Id, -item.Amount, item.Login)
+			}
+		}
+	}
+
+	_, err = u.db.UpdateCompensationState(c.Id, mysql.CompensationApproved, mysql.CompensationApplied, "")
+	if err != nil {
+		log.Errorf("Failed to close compensation #%v: %v", c.Id, err)
+		return false
+	}
+	u.logs.InsertLog(fmt.Sprintf("COMPENSATION #%v %v applied: %v miners, %v failed", c.Id, c.Key, applied, failed),
+		plogger.LogTypePaymentWork, plogger.LogSubTypePaymentCompensation, 0, 0, "", "")
+	return true
+}
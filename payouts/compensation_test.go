@@ -0,0 +1,79 @@
+package payouts
+
+import (
+	"testing"
+)
+
+func TestParseCorrection(t *testing.T) {
+	data := []byte(`{"key":"incident-42","reason":"Missed shares on 2021-12-01","total":500,"deltas":[
+		{"login":"0xB85150eb365e7df0941f0cf08235f987ba91506a","amount":800},
+		{"login":"0x2a65aca4d5fc5b5c859090a6c34d164135398226","amount":-300}]}`)
+
+	file, err := ParseCorrection(data, 0)
+	if err != nil {
+		t.Fatalf("Must accept a valid file: %v", err)
+	}
+	if file.Deltas[0].Login != "0xb85150eb365e7df0941f0cf08235f987ba91506a" {
+		t.Errorf("Must lowercase logins, got %v", file.Deltas[0].Login)
+	}
+
+	c, items := file.Record("admin")
+	if c.Miners != 2 || c.Total != 500 || c.CreatedBy != "admin" {
+		t.Errorf("Unexpected compensation %+v", c)
+	}
+	if items[1].IdemKey != "incident-42:0x2a65aca4d5fc5b5c859090a6c34d164135398226" || items[1].Amount != -300 {
+		t.Errorf("Unexpected item %+v", items[1])
+	}
+
+	if _, err := ParseCorrection(data, 1000); err == nil {
+		t.Error("Must refuse deltas moving more than maxTotal")
+	}
+}
+
+func TestParseCorrectionInvalid(t *testing.T) {
+	files := map[string]string{
+		"total mismatch": `{"key":"k","reason":"r","total":1,"deltas":[{"login":"0xb85150eb365e7df0941f0cf08235f987ba91506a","amount":2}]}`,
+		"duplicate":      `{"key":"k","reason":"r","total":2,"deltas":[{"login":"0xb85150eb365e7df0941f0cf08235f987ba91506a","amount":1},{"login":"0xB85150eb365e7df0941f0cf08235f987ba91506a","amount":1}]}`,
+		"zero amount":    `{"key":"k","reason":"r","total":0,"deltas":[{"login":"0xb85150eb365e7df0941f0cf08235f987ba91506a","amount":0}]}`,
+		"invalid login":  `{"key":"k","reason":"r","total":1,"deltas":[{"login":"0xb851","amount":1}]}`,
+		"no key":         `{"reason":"r","total":1,"deltas":[{"login":"0xb85150eb365e7df0941f0cf08235f987ba91506a","amount":1}]}`,
+		"no reason":      `{"key":"k","total":1,"deltas":[{"login":"0xb85150eb365e7df0941f0cf08235f987ba91506a","amount":1}]}`,
+		"no deltas":      `{"key":"k","reason":"r","total":0,"deltas":[]}`,
+		"unknown field":  `{"key":"k","reason":"r","total":1,"amount":1,"deltas":[{"login":"0xb85150eb365e7df0941f0cf08235f987ba91506a","amount":1}]}`,
+	}
+	for name, data := range files {
+		if _, err := ParseCorrection([]byte(data), 0); err == nil {
+			t.Errorf("Must refuse file with %v", name)
+		}
+	}
+}
+
+func TestParseAdjustment(t *testing.T) {
+	data := []byte(`{"login":"0xB85150eb365e7df0941f0cf08235f987ba91506a","amount":-300,"kind":"refund","reason":"Double payout"}`)
+
+	a, err := ParseAdjustment(data, 0)
+	if err != nil {
+		t.Fatalf("Must accept a valid adjustment: %v", err)
+	}
+	c, items := a.Record("admin")
+	if c.Kind != "refund" || c.Miners != 1 || c.Total != -300 || c.CreatedBy != "admin" {
+		t.Errorf("Unexpected compensation %+v", c)
+	}
+	if len(items) != 1 || items[0].IdemKey != c.Key || items[0].Login != "0xb85150eb365e7df0941f0cf08235f987ba91506a" {
+		t.Errorf("Unexpected items %+v", items)
+	}
+
+	if _, err := ParseAdjustment(data, 100); err == nil {
+		t.Error("Must refuse an amount over maxAmount")
+	}
+	invalid := map[string]string{
+		"unknown kind": `{"login":"0xb85150eb365e7df0941f0cf08235f987ba91506a","amount":1,"kind":"gift","reason":"r"}`,
+		"zero amount":  `{"login":"0xb85150eb365e7df0941f0cf08235f987ba91506a","amount":0,"kind":"refund","reason":"r"}`,
+		"no reason":    `{"login":"0xb85150eb365e7df0941f0cf08235f987ba91506a","amount":1,"kind":"refund"}`,
+	}
+	for name, data := range invalid {
+		if _, err := ParseAdjustment([]byte(data), 0); err == nil {
+			t.Errorf("Must refuse adjustment with %v", name)
+		}
+	}
+}
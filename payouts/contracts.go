@@ -0,0 +1,112 @@
+package payouts
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+const (
+	ContractPayeeGas    = "gas"
+	ContractPayeeReview = "review"
+)
+
+type ContractPayeesConfig struct {
+	// Payees with code, found with eth_getCode. "gas" pays them with gas, "review" holds them until
+	// approved through the API and then pays them with gas. Empty pays them like any payee
+	Action string `json:"action"`
+	// Gas limit of payouts to contracts, "100000" by default. Unused with autoGas on the node signer,
+	// the node estimates it, or with estimateGas
+	Gas string `json:"gas"`
+}
+
+func (c *ContractPayeesConfig) check() error {
+	switch c.Action {
+	case "", ContractPayeeGas, ContractPayeeReview:
+	default:
+		return fmt.Errorf("invalid action %v, must be %v or %v", c.Action, ContractPayeeGas, ContractPayeeReview)
+	}
+	if len(c.Gas) == 0 {
+		c.Gas = "100000"
+	}
+	if util.String2Big(c.Gas).Sign() <= 0 {
+		return fmt.Errorf("invalid gas %v", c.Gas)
+	}
+	return nil
+}
+
+// screenContracts finds the contracts among the payees over their threshold. With the review action
+// the ones not approved are held and left out, a payee whose code can't be checked waits for the
+// next run. The contracts paid are kept for the run.
+func (u *PayoutsProcessor) screenContracts(payees []*mysql.Payees) []*mysql.Payees {
+	u.contracts = make(map[string]bool)
+	if len(u.config.ContractPayees.Action) == 0 {
+		return payees
+	}
+	result := make([]*mysql.Payees, 0, len(payees))
+	for _, payee := range payees {
+		if !u.payeeReachedThreshold(payee) {
+			continue
+		}
+		login := payee.Addr
+		contract, err := u.payoutContract(login)
+		if err != nil {
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
+				"Failed to check whether %v is a contract, paid on the next run: %v", login, err)
+			continue
+		}
+		if contract != nil {
+			if u.config.ContractPayees.Action == ContractPayeeReview && contract.State != mysql.ContractApproved {
+				log.Infof("Payout to contract %v held for review", login)
+				continue
+			}
+			u.contracts[login] = true
+		}
+		result = append(result, payee)
+	}
+	return result
+}
+
+// payoutContract returns the contract record of login, adding it the first time login has code. An
+// account without code is checked on every run, it may get code later.
+func (u *PayoutsProcessor) payoutContract(login string) (*mysql.PayoutContract, error) {
+	contract, err := u.db.GetPayoutContract(login)
+	if metrics.MysqlError(u.config.Name, "get_payout_contract", err) != nil || contract != nil {
+		return contract, err
+	}
+	code, err := u.rpc.GetCode(login)
+	if err != nil {
+		return nil, err
+	}
+	if len(code) <= 2 {
+		return nil, nil
+	}
+
+	state := mysql.ContractDetected
+	if u.config.ContractPayees.Action == ContractPayeeReview {
+		state = mysql.ContractHeld
+	}
+	if err := u.db.AddPayoutContract(login, state); metrics.MysqlError(u.config.Name, "add_payout_contract", err) != nil {
+		return nil, err
+	}
+	metrics.PayoutContracts.Inc(u.config.Name, state)
+	if state == mysql.ContractHeld {
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
+			"Payee %v is a contract, its payouts are held until approved", login)
+	} else {
+		log.Infof("Payee %v is a contract, paid with a gas limit of %v", login, u.config.ContractPayees.Gas)
+	}
+	return &mysql.PayoutContract{Address: login, State: state}, nil
+}
+
+// payeeGas is the gas limit of a payout to login.
+func (u *PayoutsProcessor) payeeGas(login string) *big.Int {
+	if u.contracts[login] {
+		return util.String2Big(u.config.ContractPayees.Gas)
+	}
+	return util.String2Big(u.config.Gas)
+}
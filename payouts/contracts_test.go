@@ -0,0 +1,31 @@
+package payouts
+
+import "testing"
+
+func TestContractPayeesConfig(t *testing.T) {
+	cfg := &ContractPayeesConfig{}
+	if err := cfg.check(); err != nil || cfg.Gas != "100000" {
+		t.Errorf("Must default the contract gas limit, got %v %v", cfg.Gas, err)
+	}
+	cfg = &ContractPayeesConfig{Action: ContractPayeeReview, Gas: "60000"}
+	if err := cfg.check(); err != nil || cfg.Gas != "60000" {
+		t.Errorf("Must keep the configured gas limit, got %v %v", cfg.Gas, err)
+	}
+	if err := (&ContractPayeesConfig{Action: "skip"}).check(); err == nil {
+		t.Error("Must reject an unknown action")
+	}
+	if err := (&ContractPayeesConfig{Gas: "0"}).check(); err == nil {
+		t.Error("Must reject a zero gas limit")
+	}
+}
+
+func TestPayeeGas(t *testing.T) {
+	u := &PayoutsProcessor{config: &PayoutsConfig{Gas: "21000", ContractPayees: ContractPayeesConfig{Gas: "100000"}}}
+	u.contracts = map[string]bool{"0xc0": true}
+	if gas := u.payeeGas("0xc0"); gas.Int64() != 100000 {
+		t.Errorf("Must pay a contract with the contract gas limit, got %v", gas)
+	}
+	if gas := u.payeeGas("0xe0"); gas.Int64() != 21000 {
+		t.Errorf("Must pay an account with gas, got %v", gas)
+	}
+}
@@ -0,0 +1,84 @@
+package payouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// FeeSplit is an operator wallet credited with percent of the pool fee.
+type FeeSplit struct {
+	Address string  `json:"address"`
+	Percent float64 `json:"percent"`
+}
+
+// FeeSplits are the wallets the pool fee is split across. The config takes a single address too,
+// credited with all of it.
+type FeeSplits []FeeSplit
+
+func (s *FeeSplits) UnmarshalJSON(data []byte) error {
+	var address string
+	if err := json.Unmarshal(data, &address); err == nil {
+		*s = nil
+		if len(address) != 0 {
+			*s = FeeSplits{{Address: address, Percent: 100}}
+		}
+		return nil
+	}
+	var splits []FeeSplit
+	if err := json.Unmarshal(data, &splits); err != nil {
+		return fmt.Errorf("poolFeeAddress is neither an address nor a list of {address, percent}: %v", err)
+	}
+	*s = splits
+	return nil
+}
+
+// validate checks the addresses and that the percents sum to 100, none means the fee stays on the
+// coinbase address.
+func (s FeeSplits) validate() error {
+	if len(s) == 0 {
+		return nil
+	}
+	total := 0.0
+	seen := make(map[string]bool)
+	for _, split := range s {
+		if !util.IsValidHexAddress(split.Address) {
+			return fmt.Errorf("invalid poolFeeAddress %v", split.Address)
+		}
+		address := strings.ToLower(split.Address)
+		if seen[address] {
+			return fmt.Errorf("poolFeeAddress %v is listed twice", split.Address)
+		}
+		seen[address] = true
+		if split.Percent <= 0 || split.Percent > 100 {
+			return fmt.Errorf("invalid percent %v of poolFeeAddress %v", split.Percent, split.Address)
+		}
+		total += split.Percent
+	}
+	if math.Abs(total-100) > 1e-9 {
+		return fmt.Errorf("percents of poolFeeAddress sum to %v, not 100", total)
+	}
+	return nil
+}
+
+func (s FeeSplits) percents() []float64 {
+	percents := make([]float64, len(s))
+	for i, split := range s {
+		percents[i] = split.Percent
+	}
+	return percents
+}
+
+func (s FeeSplits) String() string {
+	if len(s) == 0 {
+		return "the coinbase address"
+	}
+	parts := make([]string, len(s))
+	for i, split := range s {
+		parts[i] = fmt.Sprintf("%v %v%%", split.Address, split.Percent)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,55 @@
+package payouts
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// FinderBonusConfig credits the login that submitted the winning share of a block on top of its
+// share of the round. The bonus is taken from the miners' profit before it is split.
+type FinderBonusConfig struct {
+	// Percent of the block reward
+	Percent float64 `json:"percent"`
+	// Flat amount in Shannon, when percent is 0
+	Amount int64 `json:"amount"`
+}
+
+func (c FinderBonusConfig) enabled() bool {
+	return c.Percent > 0 || c.Amount > 0
+}
+
+func (c FinderBonusConfig) validate() error {
+	if c.Percent < 0 || c.Percent > 100 {
+		return fmt.Errorf("invalid finderBonus.percent %v", c.Percent)
+	}
+	if c.Amount < 0 {
+		return fmt.Errorf("invalid finderBonus.amount %v", c.Amount)
+	}
+	if c.Percent > 0 && c.Amount > 0 {
+		return fmt.Errorf("finderBonus takes either a percent or an amount")
+	}
+	return nil
+}
+
+// bonus is the finder bonus of a block with reward, at most the miners' profit.
+func (c FinderBonusConfig) bonus(reward, minersProfit *big.Rat) *big.Rat {
+	var bonus *big.Rat
+	if c.Percent > 0 {
+		_, bonus = chargeFee(reward, c.Percent)
+	} else {
+		bonus = new(big.Rat).SetInt(new(big.Int).Mul(big.NewInt(c.Amount), util.Shannon))
+	}
+	if bonus.Cmp(minersProfit) > 0 {
+		bonus.Set(minersProfit)
+	}
+	return bonus
+}
+
+func (c FinderBonusConfig) String() string {
+	if c.Percent > 0 {
+		return fmt.Sprintf("%v%% of the block reward", c.Percent)
+	}
+	return fmt.Sprintf("%v Shannon", c.Amount)
+}
@@ -0,0 +1,284 @@
+package payouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/cellcrypto/open-dangnn-pool/feature"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	GasStrategyLegacy  = "legacy"
+	GasStrategyEIP1559 = "eip1559"
+)
+
+// Max fee is set to twice the current base fee plus tip, so a tx survives several full blocks.
+const baseFeeMultiplier = 2
+
+type gasQuote struct {
+	dynamic        bool
+	gas            *big.Int
+	gasPrice       *big.Int
+	maxFee         *big.Int
+	maxPriorityFee *big.Int
+	// Price per gas the miner is charged for: gasPrice or baseFee+tip, only the tip without base fee deduction
+	effectivePrice *big.Int
+}
+
+// Fee charged to a miner, in Shannon.
+func (q *gasQuote) FeeInShannon() int64 {
+	fee := new(big.Int).Mul(q.gas, q.effectivePrice)
+	return fee.Div(fee, util.Shannon).Int64()
+}
+
+func (q *gasQuote) String() string {
+	if q.dynamic {
+		return fmt.Sprintf("eip1559 gas: %v maxFee: %v tip: %v", q.gas, q.maxFee, q.maxPriorityFee)
+	}
+	return fmt.Sprintf("legacy gas: %v gasPrice: %v", q.gas, q.gasPrice)
+}
+
+func (self PayoutsConfig) GasStrategyName() string {
+	if len(self.GasStrategy) == 0 {
+		return GasStrategyLegacy
+	}
+	return self.GasStrategy
+}
+
+func (self PayoutsConfig) capFee(value *big.Int, capValue string) *big.Int {
+	if len(capValue) == 0 {
+		return value
+	}
+	limit := util.String2Big(capValue)
+	if limit.Sign() > 0 && value.Cmp(limit) > 0 {
+		return limit
+	}
+	return value
+}
+
+// quoteGas builds gas parameters for a payout according to the configured strategy.
+func (u *PayoutsProcessor) quoteGas(to string, value *big.Int) (*gasQuote, error) {
+	return u.quoteGasLimit(to, value, nil, util.String2Big(u.config.Gas))
+}
+
+func (u *PayoutsProcessor) quoteGasLimit(to string, value *big.Int, data []byte, gas *big.Int) (*gasQuote, error) {
+	if u.config.EstimateGas {
+		estimated, err := u.rpc.EstimateGas(u.config.Address, to, hexutil.EncodeBig(value), encodeData(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to estimate gas: %v", err)
+		}
+		gas = estimated
+	}
+
+	switch u.config.GasStrategyName() {
+	case GasStrategyLegacy:
+		gasPrice, err := u.legacyGasPrice()
+		if err != nil {
+			return nil, err
+		}
+		if err := u.config.checkGasCeiling(gasPrice); err != nil {
+			return nil, err
+		}
+		gasPrice = u.config.capFee(gasPrice, u.config.MaxFeePerGas)
+		return &gasQuote{gas: gas, gasPrice: gasPrice, effectivePrice: gasPrice}, nil
+
+	case GasStrategyEIP1559:
+		baseFee, tip, err := u.dynamicFees()
+		if err != nil {
+			return nil, err
+		}
+		if err := u.config.checkGasCeiling(new(big.Int).Add(baseFee, tip)); err != nil {
+			return nil, err
+		}
+		tip = u.config.capFee(tip, u.config.MaxPriorityFeePerGas)
+
+		maxFee := new(big.Int).Mul(baseFee, big.NewInt(baseFeeMultiplier))
+		maxFee.Add(maxFee, tip)
+		maxFee = u.config.capFee(maxFee, u.config.MaxFeePerGas)
+
+		effectivePrice := new(big.Int).Add(baseFee, tip)
+		if effectivePrice.Cmp(maxFee) > 0 {
+			return nil, fmt.Errorf("base fee %v + tip %v exceeds maxFeePerGas cap %v", baseFee, tip, maxFee)
+		}
+		if !u.backend.Features().Enabled(feature.BaseFeeDeduction) {
+			// The pool absorbs the base fee, miners are only charged the tip.
+			effectivePrice = new(big.Int).Set(tip)
+		}
+		return &gasQuote{dynamic: true, gas: gas, maxFee: maxFee, maxPriorityFee: tip, effectivePrice: effectivePrice}, nil
+	}
+	return nil, fmt.Errorf("unknown gas strategy: %v", u.config.GasStrategy)
+}
+
+// legacyGasPrice is the configured gas price, or the one suggested by the gas oracle or the node.
+func (u *PayoutsProcessor) legacyGasPrice() (*big.Int, error) {
+	gasPrice := util.String2Big(u.config.GasPrice)
+	if gasPrice.Sign() > 0 {
+		return gasPrice, nil
+	}
+	if u.gasOracle != nil {
+		return u.gasOracle.gasPrice()
+	}
+	suggested, err := u.rpc.GetGasPrice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gas price: %v", err)
+	}
+	return suggested, nil
+}
+
+// dynamicFees is the base fee and the tip before any cap, suggested by the gas oracle or the node.
+func (u *PayoutsProcessor) dynamicFees() (*big.Int, *big.Int, error) {
+	var baseFee, tip *big.Int
+	if u.gasOracle != nil {
+		var err error
+		if baseFee, tip, err = u.gasOracle.fees(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if baseFee == nil {
+		block, err := u.rpc.GetLatestBlock()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get latest block: %v", err)
+		}
+		if block == nil || len(block.BaseFeePerGas) == 0 {
+			return nil, nil, fmt.Errorf("node does not report baseFeePerGas, use %v gas strategy", GasStrategyLegacy)
+		}
+		baseFee = util.String2Big(block.BaseFeePerGas)
+	}
+	if tip == nil {
+		var err error
+		if tip, err = u.rpc.GetMaxPriorityFeePerGas(); err != nil {
+			return nil, nil, fmt.Errorf("failed to get max priority fee: %v", err)
+		}
+	}
+	return baseFee, tip, nil
+}
+
+// marketGasPrice is the price per gas of a payout before any cap: the legacy gas price, or the base
+// fee plus tip.
+func (u *PayoutsProcessor) marketGasPrice() (*big.Int, error) {
+	if u.config.GasStrategyName() == GasStrategyEIP1559 {
+		baseFee, tip, err := u.dynamicFees()
+		if err != nil {
+			return nil, err
+		}
+		return baseFee.Add(baseFee, tip), nil
+	}
+	return u.legacyGasPrice()
+}
+
+func (u *PayoutsProcessor) sendPayment(to string, value *big.Int, quote *gasQuote) (*outgoingTx, error) {
+	return u.sendTransaction(to, value, nil, quote)
+}
+
+func (u *PayoutsProcessor) sendTransaction(to string, value *big.Int, data []byte, quote *gasQuote) (*outgoingTx, error) {
+	tx := &outgoingTx{to: to, value: value, data: data, quote: quote}
+	txHash, err := u.broadcast(tx)
+	if err != nil {
+		return nil, err
+	}
+	tx.sent(txHash)
+	return tx, nil
+}
+
+// broadcast sends tx with its current quote, reusing its nonce if it has one.
+func (u *PayoutsProcessor) broadcast(tx *outgoingTx) (string, error) {
+	if u.nonces != nil && !tx.hasNonce {
+		return u.nonces.send(func(nonce uint64) (string, string, error) {
+			tx.nonce, tx.hasNonce = nonce, true
+			txHash, err := u.broadcast(tx)
+			if err != nil {
+				tx.hasNonce = false
+				return "", "", err
+			}
+			return txHash, u.txRecord(tx), nil
+		})
+	}
+	if u.signer != nil {
+		return u.sendSignedTransaction(tx)
+	}
+	quote := tx.quote
+	if tx.hasNonce {
+		return u.rpc.SendTransactionParams(u.transactionParams(tx))
+	}
+	if quote == nil {
+		return u.rpc.SendTransaction(u.config.Address, tx.to, u.config.GasHex(), u.config.GasPriceHex(), hexutil.EncodeBig(tx.value), true)
+	}
+	if quote.dynamic {
+		return u.rpc.SendDynamicFeeTransaction(u.config.Address, tx.to, hexutil.EncodeBig(quote.gas),
+			hexutil.EncodeBig(quote.maxFee), hexutil.EncodeBig(quote.maxPriorityFee), hexutil.EncodeBig(tx.value), encodeData(tx.data))
+	}
+	if len(tx.data) > 0 {
+		return u.rpc.SendContractTransaction(u.config.Address, tx.to, hexutil.EncodeBig(quote.gas), hexutil.EncodeBig(quote.gasPrice), hexutil.EncodeBig(tx.value), encodeData(tx.data))
+	}
+	return u.rpc.SendTransaction(u.config.Address, tx.to, hexutil.EncodeBig(quote.gas), hexutil.EncodeBig(quote.gasPrice), hexutil.EncodeBig(tx.value), false)
+}
+
+// transactionParams builds eth_sendTransaction fields for a tx with a fixed nonce. Without a quote,
+// with autoGas, the node picks the gas.
+func (u *PayoutsProcessor) transactionParams(tx *outgoingTx) map[string]string {
+	quote := tx.quote
+	params := map[string]string{
+		"from":  u.config.Address,
+		"to":    tx.to,
+		"value": hexutil.EncodeBig(tx.value),
+		"nonce": hexutil.EncodeUint64(tx.nonce),
+	}
+	if quote == nil {
+		return params
+	}
+	params["gas"] = hexutil.EncodeBig(quote.gas)
+	if quote.dynamic {
+		params["type"] = "0x2"
+		params["maxFeePerGas"] = hexutil.EncodeBig(quote.maxFee)
+		params["maxPriorityFeePerGas"] = hexutil.EncodeBig(quote.maxPriorityFee)
+	} else {
+		params["gasPrice"] = hexutil.EncodeBig(quote.gasPrice)
+	}
+	if len(tx.data) > 0 {
+		params["data"] = encodeData(tx.data)
+	}
+	return params
+}
+
+// txRecord is what the nonce manager needs to send tx again: its signed payload, or the
+// eth_sendTransaction fields the node signed.
+func (u *PayoutsProcessor) txRecord(tx *outgoingTx) string {
+	if len(tx.raw) > 0 {
+		return hexutil.Encode(tx.raw)
+	}
+	params, _ := json.Marshal(u.transactionParams(tx))
+	return string(params)
+}
+
+func (u *PayoutsProcessor) sendSignedTransaction(tx *outgoingTx) (string, error) {
+	if !tx.hasNonce {
+		nonce, err := u.rpc.GetPendingNonce(u.config.Address)
+		if err != nil {
+			return "", fmt.Errorf("failed to get nonce: %v", err)
+		}
+		tx.nonce, tx.hasNonce = nonce, true
+	}
+	quote := tx.quote
+	var rawTx []byte
+	var err error
+	if quote.dynamic {
+		rawTx, err = u.signer.signDynamicFee(tx.nonce, tx.to, tx.value, quote.gas, quote.maxFee, quote.maxPriorityFee, tx.data)
+	} else {
+		rawTx, err = u.signer.signLegacy(tx.nonce, tx.to, tx.value, quote.gas, quote.gasPrice, tx.data)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to sign payment: %v", err)
+	}
+	tx.raw = rawTx
+	return u.rpc.SendRawTransaction(hexutil.Encode(rawTx))
+}
+
+func encodeData(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	return hexutil.Encode(data)
+}
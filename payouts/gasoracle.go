@@ -0,0 +1,210 @@
+package payouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/rpc"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+const (
+	GasOracleNode     = "node"
+	GasOracleExternal = "url"
+)
+
+type GasOracleConfig struct {
+	// "node" suggests fees from eth_feeHistory of the payout daemon, "url" from an external oracle.
+	// Empty uses eth_gasPrice, or the latest base fee and eth_maxPriorityFeePerGas
+	Source string `json:"source"`
+	// eth_feeHistory: blocks looked at and the percentile of their tips, 20 and 50 by default
+	Blocks     int     `json:"blocks"`
+	Percentile float64 `json:"percentile"`
+	// External oracle returning JSON, with the dotted paths of the gas price and of the priority fee
+	// in Gwei, e.g. "result.ProposeGasPrice". The tip is needed by the eip1559 strategy only
+	Url        string `json:"url"`
+	PriceField string `json:"priceField"`
+	TipField   string `json:"tipField"`
+	Timeout    string `json:"timeout"`
+}
+
+// gasOracle suggests the gas price of the legacy strategy, and the base fee and tip of eip1559.
+type gasOracle struct {
+	config *GasOracleConfig
+	rpc    *rpc.RPCClient
+	client *http.Client
+}
+
+func newGasOracle(cfg *GasOracleConfig, client *rpc.RPCClient, strategy string) (*gasOracle, error) {
+	o := &gasOracle{config: cfg, rpc: client}
+	switch cfg.Source {
+	case GasOracleNode:
+		if cfg.Blocks <= 0 {
+			cfg.Blocks = 20
+		}
+		if cfg.Percentile <= 0 {
+			cfg.Percentile = 50
+		}
+		if cfg.Percentile > 100 {
+			return nil, fmt.Errorf("invalid percentile %v", cfg.Percentile)
+		}
+	case GasOracleExternal:
+		if len(cfg.Url) == 0 {
+			return nil, fmt.Errorf("url is required")
+		}
+		if strategy == GasStrategyEIP1559 && len(cfg.TipField) == 0 {
+			return nil, fmt.Errorf("tipField is required by the %v strategy", GasStrategyEIP1559)
+		}
+		if strategy == GasStrategyLegacy && len(cfg.PriceField) == 0 {
+			return nil, fmt.Errorf("priceField is required by the %v strategy", GasStrategyLegacy)
+		}
+		timeout := 10 * time.Second
+		if len(cfg.Timeout) > 0 {
+			timeout = util.MustParseDuration(cfg.Timeout)
+		}
+		o.client = &http.Client{Timeout: timeout}
+	default:
+		return nil, fmt.Errorf("invalid source %v, must be %v or %v", cfg.Source, GasOracleNode, GasOracleExternal)
+	}
+	return o, nil
+}
+
+// gasPrice is the suggested legacy gas price: the next base fee plus the tip with eth_feeHistory.
+func (o *gasOracle) gasPrice() (*big.Int, error) {
+	if o.config.Source == GasOracleExternal {
+		return o.fetch(o.config.PriceField)
+	}
+	baseFee, tip, err := o.feeHistory()
+	if err != nil {
+		return nil, err
+	}
+	return baseFee.Add(baseFee, tip), nil
+}
+
+// fees are the suggested base fee and tip. The base fee is nil from an external oracle, the one of
+// the latest block is used then.
+func (o *gasOracle) fees() (*big.Int, *big.Int, error) {
+	if o.config.Source == GasOracleExternal {
+		tip, err := o.fetch(o.config.TipField)
+		return nil, tip, err
+	}
+	return o.feeHistory()
+}
+
+// feeHistory is the base fee of the next block and the mean tip at the percentile of the last blocks.
+func (o *gasOracle) feeHistory() (*big.Int, *big.Int, error) {
+	history, err := o.rpc.GetFeeHistory(o.config.Blocks, o.config.Percentile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get fee history: %v", err)
+	}
+	if history == nil || len(history.BaseFeePerGas) == 0 {
+		return nil, nil, fmt.Errorf("node reports no fee history")
+	}
+	baseFee := util.String2Big(history.BaseFeePerGas[len(history.BaseFeePerGas)-1])
+	tip, n := big.NewInt(0), int64(0)
+	for _, rewards := range history.Reward {
+		if len(rewards) > 0 {
+			tip.Add(tip, util.String2Big(rewards[0]))
+			n++
+		}
+	}
+	if n > 0 {
+		tip.Div(tip, big.NewInt(n))
+	}
+	return baseFee, tip, nil
+}
+
+// fetch reads the price in Gwei at field of the external oracle, in Wei.
+func (o *gasOracle) fetch(field string) (*big.Int, error) {
+	resp, err := o.client.Get(o.config.Url)
+	if err != nil {
+		return nil, fmt.Errorf("gas oracle: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gas oracle: unexpected status %v", resp.Status)
+	}
+	var reply interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&reply); err != nil {
+		return nil, fmt.Errorf("gas oracle: %v", err)
+	}
+	gwei, err := priceField(reply, strings.Split(field, "."))
+	if err != nil {
+		return nil, fmt.Errorf("gas oracle: %v", err)
+	}
+	wei := gwei.Mul(gwei, new(big.Rat).SetInt(util.Shannon))
+	return new(big.Int).Quo(wei.Num(), wei.Denom()), nil
+}
+
+// gasCeilingError refuses a payout while the gas price is over payouts.gasCeiling.
+type gasCeilingError struct {
+	price   *big.Int
+	ceiling *big.Int
+}
+
+func (e *gasCeilingError) Error() string {
+	return fmt.Sprintf("gas price %v Wei is over the ceiling of %v Wei", e.price, e.ceiling)
+}
+
+func isGasCeiling(err error) bool {
+	_, ok := err.(*gasCeilingError)
+	return ok
+}
+
+// checkGasCeiling fails with a gasCeilingError when price, the gas price or the base fee plus tip
+// before any cap, is over the ceiling.
+func (self PayoutsConfig) checkGasCeiling(price *big.Int) error {
+	if len(self.GasCeiling) == 0 {
+		return nil
+	}
+	ceiling := util.String2Big(self.GasCeiling)
+	if ceiling.Sign() > 0 && price.Cmp(ceiling) > 0 {
+		return &gasCeilingError{price: price, ceiling: ceiling}
+	}
+	return nil
+}
+
+// belowGasCeiling checks the gas price against payouts.gasCeiling before a run. Over it, or when the
+// price can't be told, the run is skipped and retried after gasRetryInterval.
+func (u *PayoutsProcessor) belowGasCeiling() bool {
+	if len(u.config.GasCeiling) == 0 {
+		return true
+	}
+	price, err := u.marketGasPrice()
+	if err == nil {
+		err = u.config.checkGasCeiling(price)
+	}
+	if err != nil {
+		u.skipForGas(err)
+		return false
+	}
+	u.gasRetry = nil
+	return true
+}
+
+// skipForGas stops the run and retries it after gasRetryInterval.
+func (u *PayoutsProcessor) skipForGas(err error) {
+	metrics.PayoutsGasSkipped.Inc(u.config.Name)
+	u.gasRetry = time.After(u.gasRetryIntv)
+	u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, "", "",
+		"Payouts skipped, retrying in %v: %v", u.gasRetryIntv, err)
+}
+
+// retryGas runs the payouts skipped over the gas ceiling again, unless their window closed meanwhile.
+func (u *PayoutsProcessor) retryGas() {
+	u.gasRetry = nil
+	if !u.inPayoutWindow(time.Now()) {
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, "", "",
+			"Payout window closed with the gas price over the ceiling of %v Wei, payouts wait for the next window", u.config.GasCeiling)
+		return
+	}
+	u.process()
+}
@@ -0,0 +1,51 @@
+package payouts
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGasOracleExternal(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result":{"ProposeGasPrice":"31.5","tip":2}}`)
+	}))
+	defer server.Close()
+
+	cfg := &GasOracleConfig{Source: GasOracleExternal, Url: server.URL, PriceField: "result.ProposeGasPrice", TipField: "result.tip"}
+	oracle, err := newGasOracle(cfg, nil, GasStrategyLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	price, err := oracle.gasPrice()
+	if err != nil || price.Cmp(big.NewInt(31500000000)) != 0 {
+		t.Errorf("Must read the gas price in Gwei, got %v %v", price, err)
+	}
+	baseFee, tip, err := oracle.fees()
+	if err != nil || baseFee != nil || tip.Cmp(big.NewInt(2000000000)) != 0 {
+		t.Errorf("Must read the tip only, got %v %v %v", baseFee, tip, err)
+	}
+
+	if _, err := newGasOracle(&GasOracleConfig{Source: GasOracleExternal, Url: server.URL}, nil, GasStrategyEIP1559); err == nil {
+		t.Error("Must require tipField with eip1559")
+	}
+	if _, err := newGasOracle(&GasOracleConfig{Source: "etherscan"}, nil, GasStrategyLegacy); err == nil {
+		t.Error("Must reject an unknown source")
+	}
+}
+
+func TestGasCeiling(t *testing.T) {
+	cfg := PayoutsConfig{}
+	if err := cfg.checkGasCeiling(big.NewInt(1000)); err != nil {
+		t.Errorf("Must pay without a ceiling, got %v", err)
+	}
+	cfg.GasCeiling = "100"
+	if err := cfg.checkGasCeiling(big.NewInt(100)); err != nil {
+		t.Errorf("Must pay at the ceiling, got %v", err)
+	}
+	if err := cfg.checkGasCeiling(big.NewInt(101)); !isGasCeiling(err) {
+		t.Errorf("Must refuse over the ceiling, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package payouts
+
+import (
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+)
+
+// reportHealth records the state of the unlocker after a pass for the admin API. A pass skipped
+// because of the node or ended by a halt doesn't count as completed.
+func (u *BlockUnlocker) reportHealth() {
+	now := time.Now().Unix()
+	if !u.halt && !u.skipped {
+		u.lastPass = now
+	}
+	u.skipped = false
+	h := &redis.ModuleHealth{Halted: u.halt, LastRun: now, LastPass: u.lastPass}
+	if u.lastFail != nil {
+		h.LastFail = u.lastFail.Error()
+	}
+	if err := u.backend.SetModuleHealth(redis.HealthUnlocker, h); err != nil {
+		log.Errorf("Failed to record the unlocker health: %v", err)
+	}
+}
+
+// reportHealth records the state of the payer after a payout run for the admin API, with the payees
+// it found.
+func (u *PayoutsProcessor) reportHealth() {
+	now := time.Now().Unix()
+	if !u.halt && !u.failed {
+		u.lastPass = now
+	}
+	u.failed = false
+	h := &redis.ModuleHealth{Halted: u.halt, LastRun: now, LastPass: u.lastPass, Queue: u.queue}
+	if u.lastFail != nil {
+		h.LastFail = u.lastFail.Error()
+	}
+	if err := u.backend.SetModuleHealth(redis.HealthPayer, h); err != nil {
+		log.Errorf("Failed to record the payer health: %v", err)
+	}
+}
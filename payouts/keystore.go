@@ -0,0 +1,113 @@
+package payouts
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// keystoreJSON is a version 3 keystore file, as written by geth and most wallets.
+type keystoreJSON struct {
+	Crypto struct {
+		Cipher       string `json:"cipher"`
+		CipherText   string `json:"ciphertext"`
+		CipherParams struct {
+			IV string `json:"iv"`
+		} `json:"cipherparams"`
+		KDF       string `json:"kdf"`
+		KDFParams struct {
+			DKLen int    `json:"dklen"`
+			Salt  string `json:"salt"`
+			// scrypt
+			N int `json:"n"`
+			R int `json:"r"`
+			P int `json:"p"`
+			// pbkdf2
+			C   int    `json:"c"`
+			PRF string `json:"prf"`
+		} `json:"kdfparams"`
+		MAC string `json:"mac"`
+	} `json:"crypto"`
+	Version int `json:"version"`
+}
+
+var errKeystorePassword = errors.New("could not decrypt key with given password")
+
+// decryptKeystore returns the private key of a version 3 keystore file. The go-ethereum keystore
+// package isn't used, it drags in the whole account manager.
+func decryptKeystore(keyJson []byte, password string) (*ecdsa.PrivateKey, error) {
+	var ks keystoreJSON
+	if err := json.Unmarshal(keyJson, &ks); err != nil {
+		return nil, err
+	}
+	if ks.Version != 3 {
+		return nil, fmt.Errorf("keystore version %v not supported", ks.Version)
+	}
+	if ks.Crypto.Cipher != "aes-128-ctr" {
+		return nil, fmt.Errorf("cipher %v not supported", ks.Crypto.Cipher)
+	}
+	mac, err := hex.DecodeString(ks.Crypto.MAC)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(ks.Crypto.CipherParams.IV)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(ks.Crypto.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(ks.Crypto.KDFParams.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	params := ks.Crypto.KDFParams
+	if params.DKLen < 32 {
+		return nil, fmt.Errorf("invalid dklen %v", params.DKLen)
+	}
+	var derivedKey []byte
+	switch ks.Crypto.KDF {
+	case "scrypt":
+		derivedKey, err = scrypt.Key([]byte(password), salt, params.N, params.R, params.P, params.DKLen)
+		if err != nil {
+			return nil, err
+		}
+	case "pbkdf2":
+		if params.PRF != "hmac-sha256" {
+			return nil, fmt.Errorf("pbkdf2 prf %v not supported", params.PRF)
+		}
+		derivedKey = pbkdf2.Key([]byte(password), salt, params.C, params.DKLen, sha256.New)
+	default:
+		return nil, fmt.Errorf("kdf %v not supported", ks.Crypto.KDF)
+	}
+
+	if !bytes.Equal(crypto.Keccak256(derivedKey[16:32], cipherText), mac) {
+		return nil, errKeystorePassword
+	}
+	block, err := aes.NewCipher(derivedKey[:16])
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("invalid iv length %v", len(iv))
+	}
+	plainText := make([]byte, len(cipherText))
+	cipher.NewCTR(block, iv).XORKeyStream(plainText, cipherText)
+	// Keys written by old clients may miss their leading zero bytes
+	if len(plainText) == 0 || len(plainText) > 32 {
+		return nil, fmt.Errorf("invalid key length %v", len(plainText))
+	}
+	return crypto.ToECDSA(plainText), nil
+}
@@ -0,0 +1,272 @@
+package payouts
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/cellcrypto/open-dangnn-pool/feature"
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Default contract interface: multisend(address[] recipients, uint256[] amounts) payable
+const defaultMultisendAbi = `[{"constant":false,"inputs":[{"name":"recipients","type":"address[]"},{"name":"amounts","type":"uint256[]"}],"name":"multisend","outputs":[],"payable":true,"type":"function"}]`
+
+type MultisendConfig struct {
+	Enabled bool   `json:"enabled"`
+	Address string `json:"address"`
+	// Contract ABI JSON, the method must take (address[], uint256[])
+	Abi           string `json:"abi"`
+	Method        string `json:"method"`
+	MaxRecipients int    `json:"maxRecipients"`
+	// Gas limit is baseGas + gasPerRecipient * recipients unless estimateGas is set
+	BaseGas         string `json:"baseGas"`
+	GasPerRecipient string `json:"gasPerRecipient"`
+}
+
+type multisend struct {
+	config   *MultisendConfig
+	contract abi.ABI
+}
+
+func newMultisend(cfg *MultisendConfig) (*multisend, error) {
+	if !util.IsValidHexAddress(cfg.Address) {
+		return nil, fmt.Errorf("invalid multisend contract address %v", cfg.Address)
+	}
+	abiJson := cfg.Abi
+	if len(abiJson) == 0 {
+		abiJson = defaultMultisendAbi
+	}
+	contract, err := abi.JSON(strings.NewReader(abiJson))
+	if err != nil {
+		return nil, fmt.Errorf("invalid multisend abi: %v", err)
+	}
+	if len(cfg.Method) == 0 {
+		cfg.Method = "multisend"
+	}
+	if _, ok := contract.Methods[cfg.Method]; !ok {
+		return nil, fmt.Errorf("multisend abi has no method %v", cfg.Method)
+	}
+	if cfg.MaxRecipients <= 0 {
+		cfg.MaxRecipients = 100
+	}
+	return &multisend{config: cfg, contract: contract}, nil
+}
+
+func (m *multisend) gasLimit(recipients int) *big.Int {
+	gas := new(big.Int).Mul(util.String2Big(m.config.GasPerRecipient), big.NewInt(int64(recipients)))
+	return gas.Add(gas, util.String2Big(m.config.BaseGas))
+}
+
+func (m *multisend) pack(logins []string, amounts []*big.Int) ([]byte, error) {
+	recipients := make([]common.Address, len(logins))
+	for i, login := range logins {
+		recipients[i] = common.HexToAddress(login)
+	}
+	return m.contract.Pack(m.config.Method, recipients, amounts)
+}
+
+type batchPayee struct {
+	login  string
+	coin   string
+	amount int64
+	// Value paid, amount is rounded down to Shannon
+	wei  *big.Int
+	memo string
+}
+
+// batched reports whether payouts go through the multisend contract.
+func (u *PayoutsProcessor) batched() bool {
+	return u.multisend != nil && u.backend.Features().Enabled(feature.BatchedPayouts)
+}
+
+// processBatches aggregates payees into multisend calls of at most maxRecipients each. Contracts are
+// paid one by one after the batches, a contract reverting would revert its whole batch.
+func (u *PayoutsProcessor) processBatches(payees []*mysql.Payees, totalAmount *big.Int, txReceipts chan<- *TxReceipt) (int, int) {
+	mustPay := 0
+	minersPaid := 0
+
+	var batch, contracts []*mysql.Payees
+	for _, payee := range payees {
+		if !u.payeeReachedThreshold(payee) {
+			continue
+		}
+		if u.contracts[payee.Addr] {
+			contracts = append(contracts, payee)
+			continue
+		}
+		mustPay++
+		batch = append(batch, payee)
+
+		if len(batch) >= u.multisend.config.MaxRecipients {
+			paid, ok := u.processBatch(batch, totalAmount, txReceipts)
+			minersPaid += paid
+			if !ok {
+				return mustPay, minersPaid
+			}
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		paid, ok := u.processBatch(batch, totalAmount, txReceipts)
+		minersPaid += paid
+		if !ok {
+			return mustPay, minersPaid
+		}
+	}
+	if len(contracts) > 0 {
+		must, paid := u.processPayees(contracts, totalAmount, txReceipts)
+		mustPay += must
+		minersPaid += paid
+	}
+	return mustPay, minersPaid
+}
+
+// processBatch pays one batch in a single contract call. Returns false if payouts must stop.
+func (u *PayoutsProcessor) processBatch(batch []*mysql.Payees, totalAmount *big.Int, txReceipts chan<- *TxReceipt) (int, bool) {
+	// Require active peers before processing
+	if !u.checkPeers() {
+		return 0, false
+	}
+	// Require unlocked account
+	if !u.isUnlockedAccount() {
+		return 0, false
+	}
+
+	contract := u.multisend.config.Address
+	grossWei := big.NewInt(0)
+	grossLogins := make([]string, len(batch))
+	grossAmounts := make([]*big.Int, len(batch))
+	for i, payee := range batch {
+		grossLogins[i] = payee.Addr
+		grossAmounts[i] = u.payoutWei(payee, 0)
+		grossWei.Add(grossWei, grossAmounts[i])
+	}
+
+	// The batch fee is shared equally by recipients.
+	grossData, err := u.multisend.pack(grossLogins, grossAmounts)
+	if err != nil {
+		u.halt = true
+		u.lastFail = err
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, contract, "",
+			"Failed to pack multisend call for %v payees: %v", len(batch), err)
+		return 0, false
+	}
+	quote, err := u.quoteGasLimit(contract, grossWei, grossData, u.multisend.gasLimit(len(batch)))
+	if isGasCeiling(err) {
+		u.skipForGas(err)
+		return 0, false
+	}
+	if err != nil {
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, contract, "",
+			"Unable to quote gas for multisend: %v", err)
+		return 0, false
+	}
+	gasFee := int64(0)
+	if !u.config.AutoGas {
+		gasFee = quote.FeeInShannon() / int64(len(batch))
+	}
+
+	poolBalance, err := u.rpc.GetBalance(u.config.Address)
+	if err != nil {
+		u.halt = true
+		u.lastFail = err
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, contract, "",
+			"rpc connection failed addr:%v err:%v", u.config.Address, err)
+		return 0, false
+	}
+	if poolBalance.Cmp(grossWei) < 0 {
+		err := fmt.Errorf("not enough balance for payment, need %s Wei, pool has %s Wei",
+			grossWei.String(), poolBalance.String())
+		u.halt = true
+		u.lastFail = err
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, contract, "",
+			"not enough coins. addr:%v err:%v", u.config.Address, err)
+		return 0, false
+	}
+
+	// Lock payments for current payout
+	var locked []*batchPayee
+	for _, payee := range batch {
+		amountWei := u.payoutWei(payee, gasFee)
+		amount := new(big.Int).Quo(amountWei, util.Shannon).Int64()
+		if amount <= 0 {
+			continue
+		}
+		ret, err := u.db.UpdateBalance(payee.Addr, amount, amountWei, gasFee, payee.Coin)
+		if err != nil || ret > 0 {
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, payee.Addr, "",
+				"Error: %v Already Locked payment for %s, %v Shannon", err, payee.Addr, amount)
+			continue
+		}
+		log.Infof("Locked batch payment for %s, %v Shannon gas fee: %v Shannon", payee.Addr, amount, gasFee)
+		locked = append(locked, &batchPayee{login: payee.Addr, coin: payee.Coin, amount: amount, wei: amountWei, memo: payee.Memo})
+	}
+	if len(locked) == 0 {
+		return 0, true
+	}
+
+	logins := make([]string, len(locked))
+	amounts := make([]*big.Int, len(locked))
+	valueWei := big.NewInt(0)
+	for i, payee := range locked {
+		logins[i] = payee.login
+		amounts[i] = payee.wei
+		valueWei.Add(valueWei, amounts[i])
+	}
+	data, err := u.multisend.pack(logins, amounts)
+	if err != nil {
+		u.halt = true
+		u.lastFail = err
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, contract, "",
+			"Failed to pack multisend call for %v payees: %v", len(locked), err)
+		return 0, false
+	}
+
+	tx, err := u.sendTransaction(contract, valueWei, data, quote)
+	if err != nil {
+		u.halt = true
+		u.lastFail = err
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, contract, "",
+			"Failed to send multisend payment to %v payees: %v. Check outgoing tx for %s in block explorer and docs/PAYOUTS.md",
+			len(locked), err, contract)
+		return 0, false
+	}
+
+	txHash := tx.hash()
+	paid := 0
+	for _, payee := range locked {
+		runPostPayoutHook(payee.login, hexutil.EncodeBig(payee.wei), payee.memo)
+
+		// Log transaction hash
+		err = u.db.WritePayment(payee.login, txHash, payee.amount, gasFee, payee.coin, u.config.Address, u.rate, u.config.PriceFeed.Currency)
+		if metrics.MysqlError(u.config.Name, "write_payment", err) != nil {
+			u.halt = true
+			u.lastFail = err
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, payee.login, "",
+				"Failed to log payment data for %s, %v Shannon, tx: %s: %v", payee.login, payee.amount, txHash, err)
+			return paid, false
+		}
+		if err := u.backend.PublishPaymentSent(payee.login, payee.amount, txHash); err != nil {
+			log.Errorf("Failed to publish payment to %v: %v", payee.login, err)
+		}
+		paid++
+		totalAmount.Add(totalAmount, big.NewInt(payee.amount))
+	}
+	metrics.PayoutsSent.Inc(u.config.Name)
+	log.Infof("Paid %v payees with multisend, TxHash: %v", paid, txHash)
+
+	// TxReceipt verification operation
+	txReceipts <- &TxReceipt{
+		txHash: txHash,
+		login:  contract,
+		tx:     tx,
+	}
+	return paid, true
+}
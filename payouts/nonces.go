@@ -0,0 +1,153 @@
+package payouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+// nonceStore keeps the nonces of the payout account, the mysql.Database.
+type nonceStore interface {
+	GetNextPayoutNonce(address string) (uint64, bool, error)
+	ReservePayoutNonce(address string, nonce uint64) error
+	SetPayoutNonce(address string, nonce uint64, state int, txHash string, tx string) error
+	GetPayoutNonces(address string, state int) ([]*mysql.PayoutNonce, error)
+	GetPayoutNonce(address string, nonce uint64) (*mysql.PayoutNonce, error)
+}
+
+// nonceNode is the payout daemon, the rpc.RPCClient.
+type nonceNode interface {
+	GetPendingNonce(address string) (uint64, error)
+	SendRawTransaction(rawTx string) (string, error)
+	SendTransactionParams(params map[string]string) (string, error)
+}
+
+// nonceManager gives out the nonces of the payout account one tx at a time. Every nonce is written
+// to payout_nonces before its tx is sent, with the tx once sent, and the node's pending count is
+// checked each time:
+//
+//   - below the next nonce, the node dropped the txs of the missing nonces, or never got them, and
+//     the txs after them are stuck. The recorded txs are sent again, their nonces stay with their
+//     payments. A nonce reserved without a tx, its payer stopped before sending it, is given out again.
+//   - above it, the account sent txs outside the payer, which is followed.
+type nonceManager struct {
+	name    string
+	address string
+	db      nonceStore
+	rpc     nonceNode
+	logs    *plogger.Logger
+
+	mu sync.Mutex
+}
+
+func newNonceManager(cfg *PayoutsConfig, db nonceStore, client nonceNode, logs *plogger.Logger) *nonceManager {
+	return &nonceManager{name: cfg.Name, address: cfg.Address, db: db, rpc: client, logs: logs}
+}
+
+// recover reports the nonces a payer that stopped left reserved: their tx may or may not have been
+// sent. The next nonce given out settles it, as a gap if the node never got the tx.
+func (m *nonceManager) recover() error {
+	reserved, err := m.db.GetPayoutNonces(m.address, mysql.NonceReserved)
+	if err != nil {
+		return err
+	}
+	for _, n := range reserved {
+		m.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, m.address, "",
+			"Nonce %v of %v was reserved before a restart, its tx may not have been sent", n.Nonce, m.address)
+	}
+	return nil
+}
+
+// send sends a tx with the next nonce, no other tx is built meanwhile. The nonce is reserved before
+// send is called, and marked sent with the hash and the tx it returns, or failed.
+func (m *nonceManager) send(send func(nonce uint64) (string, string, error)) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nonce, err := m.next()
+	if err != nil {
+		return "", err
+	}
+	if err := m.db.ReservePayoutNonce(m.address, nonce); metrics.MysqlError(m.name, "reserve_nonce", err) != nil {
+		return "", fmt.Errorf("failed to reserve nonce %v: %v", nonce, err)
+	}
+	txHash, tx, err := send(nonce)
+	if err != nil {
+		if dbErr := m.db.SetPayoutNonce(m.address, nonce, mysql.NonceFailed, "", ""); metrics.MysqlError(m.name, "set_nonce", dbErr) != nil {
+			log.Errorf("Failed to free nonce %v of %v: %v", nonce, m.address, dbErr)
+		}
+		return "", err
+	}
+	// Still reserved if this fails, the next nonce stays right either way
+	m.replaced(nonce, txHash, tx)
+	return txHash, nil
+}
+
+// replaced records the tx sent with nonce, by send or as a replacement by the tracker.
+func (m *nonceManager) replaced(nonce uint64, txHash string, tx string) {
+	if err := m.db.SetPayoutNonce(m.address, nonce, mysql.NonceSent, txHash, tx); metrics.MysqlError(m.name, "set_nonce", err) != nil {
+		log.Errorf("Failed to record nonce %v of tx %v: %v", nonce, txHash, err)
+	}
+}
+
+// next is the node's pending nonce, after checking it against the one after the last reserved or
+// sent. The txs of a gap are sent again first.
+func (m *nonceManager) next() (uint64, error) {
+	pending, err := m.rpc.GetPendingNonce(m.address)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get nonce: %v", err)
+	}
+	stored, ok, err := m.db.GetNextPayoutNonce(m.address)
+	if metrics.MysqlError(m.name, "get_nonce", err) != nil {
+		return 0, fmt.Errorf("failed to load nonce: %v", err)
+	}
+	if !ok || stored == pending {
+		return pending, nil
+	}
+
+	if stored < pending {
+		metrics.PayoutNonceResyncs.Inc(m.name, "outside")
+		log.Warnf("Nonces %v to %v of %v were used outside the payer, resynced", stored, pending-1, m.address)
+		return pending, nil
+	}
+	metrics.PayoutNonceResyncs.Inc(m.name, "gap")
+	for nonce := pending; nonce < stored; nonce++ {
+		missing, err := m.db.GetPayoutNonce(m.address, nonce)
+		if metrics.MysqlError(m.name, "get_nonce", err) != nil {
+			return 0, fmt.Errorf("failed to load nonce %v: %v", nonce, err)
+		}
+		if missing == nil || len(missing.Tx) == 0 {
+			m.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, m.address, "",
+				"Nonce gap on %v: the node is at nonce %v, the payer at %v. Nonce %v has no tx sent, giving it out again",
+				m.address, pending, stored, nonce)
+			return nonce, nil
+		}
+		if err := m.resend(missing.Tx); err != nil {
+			m.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, m.address, "",
+				"Nonce gap on %v: the node is at nonce %v, the payer at %v. Failed to send tx %v of nonce %v again: %v",
+				m.address, pending, stored, missing.TxHash, nonce, err)
+			return 0, fmt.Errorf("nonce %v is missing on the node: %v", nonce, err)
+		}
+		log.Warnf("Sent tx %v of nonce %v of %v again, the node dropped it", missing.TxHash, nonce, m.address)
+	}
+	return stored, nil
+}
+
+// resend sends a recorded tx again: a signed payload in hex, or eth_sendTransaction fields.
+func (m *nonceManager) resend(tx string) error {
+	if !strings.HasPrefix(tx, "{") {
+		_, err := m.rpc.SendRawTransaction(tx)
+		return err
+	}
+	var params map[string]string
+	if err := json.Unmarshal([]byte(tx), &params); err != nil {
+		return err
+	}
+	_, err := m.rpc.SendTransactionParams(params)
+	return err
+}
@@ -0,0 +1,143 @@
+package payouts
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+)
+
+type fakeNonceStore struct {
+	nonces map[uint64]*mysql.PayoutNonce
+}
+
+func (s *fakeNonceStore) GetNextPayoutNonce(address string) (uint64, bool, error) {
+	next, ok := uint64(0), false
+	for nonce, n := range s.nonces {
+		if n.State != mysql.NonceFailed && nonce+1 > next {
+			next, ok = nonce+1, true
+		}
+	}
+	return next, ok, nil
+}
+
+func (s *fakeNonceStore) ReservePayoutNonce(address string, nonce uint64) error {
+	s.nonces[nonce] = &mysql.PayoutNonce{Nonce: nonce, State: mysql.NonceReserved}
+	return nil
+}
+
+func (s *fakeNonceStore) SetPayoutNonce(address string, nonce uint64, state int, txHash string, tx string) error {
+	s.nonces[nonce] = &mysql.PayoutNonce{Nonce: nonce, State: state, TxHash: txHash, Tx: tx}
+	return nil
+}
+
+func (s *fakeNonceStore) GetPayoutNonces(address string, state int) ([]*mysql.PayoutNonce, error) {
+	var result []*mysql.PayoutNonce
+	for _, n := range s.nonces {
+		if n.State == state {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeNonceStore) GetPayoutNonce(address string, nonce uint64) (*mysql.PayoutNonce, error) {
+	return s.nonces[nonce], nil
+}
+
+type fakeNonceNode struct {
+	pending uint64
+	// Txs sent again, raw payloads and the "to" of eth_sendTransaction fields
+	resent []string
+	fail   bool
+}
+
+func (n *fakeNonceNode) GetPendingNonce(address string) (uint64, error) {
+	return n.pending, nil
+}
+
+func (n *fakeNonceNode) SendRawTransaction(rawTx string) (string, error) {
+	if n.fail {
+		return "", fmt.Errorf("underpriced")
+	}
+	n.resent = append(n.resent, rawTx)
+	return "0xhash", nil
+}
+
+func (n *fakeNonceNode) SendTransactionParams(params map[string]string) (string, error) {
+	if n.fail {
+		return "", fmt.Errorf("underpriced")
+	}
+	n.resent = append(n.resent, params["to"])
+	return "0xhash", nil
+}
+
+func newTestNonceManager(pending uint64, sent ...*mysql.PayoutNonce) (*nonceManager, *fakeNonceStore, *fakeNonceNode) {
+	store := &fakeNonceStore{nonces: make(map[uint64]*mysql.PayoutNonce)}
+	for _, n := range sent {
+		store.nonces[n.Nonce] = n
+	}
+	node := &fakeNonceNode{pending: pending}
+	return newNonceManager(&PayoutsConfig{Name: "test", Address: "0xpayer"}, store, node, nil), store, node
+}
+
+func TestNonceManagerNext(t *testing.T) {
+	m, _, _ := newTestNonceManager(7)
+	if nonce, err := m.next(); err != nil || nonce != 7 {
+		t.Errorf("Must follow the node without nonces, got %v %v", nonce, err)
+	}
+
+	m, _, node := newTestNonceManager(5, &mysql.PayoutNonce{Nonce: 4, State: mysql.NonceSent, TxHash: "0x4", Tx: "0xraw4"})
+	if nonce, err := m.next(); err != nil || nonce != 5 || len(node.resent) != 0 {
+		t.Errorf("Must give out the pending nonce when equal, got %v %v %v", nonce, err, node.resent)
+	}
+
+	m, _, _ = newTestNonceManager(9, &mysql.PayoutNonce{Nonce: 4, State: mysql.NonceSent, TxHash: "0x4", Tx: "0xraw4"})
+	if nonce, err := m.next(); err != nil || nonce != 9 {
+		t.Errorf("Must follow txs sent outside the payer, got %v %v", nonce, err)
+	}
+}
+
+func TestNonceManagerGap(t *testing.T) {
+	m, _, node := newTestNonceManager(3,
+		&mysql.PayoutNonce{Nonce: 3, State: mysql.NonceSent, TxHash: "0x3", Tx: "0xraw3"},
+		&mysql.PayoutNonce{Nonce: 4, State: mysql.NonceSent, TxHash: "0x4", Tx: `{"to":"0xminer","nonce":"0x4"}`})
+	nonce, err := m.next()
+	if err != nil || nonce != 5 {
+		t.Errorf("Must keep the nonces of a gap with their txs, got %v %v", nonce, err)
+	}
+	if len(node.resent) != 2 || node.resent[0] != "0xraw3" || node.resent[1] != "0xminer" {
+		t.Errorf("Must send the txs of the gap again, got %v", node.resent)
+	}
+
+	m, _, node = newTestNonceManager(3,
+		&mysql.PayoutNonce{Nonce: 3, State: mysql.NonceSent, TxHash: "0x3", Tx: "0xraw3"})
+	node.fail = true
+	if _, err := m.next(); err == nil {
+		t.Error("Must not give out a nonce while a gap can't be filled")
+	}
+
+	m, _, node = newTestNonceManager(3,
+		&mysql.PayoutNonce{Nonce: 3, State: mysql.NonceReserved},
+		&mysql.PayoutNonce{Nonce: 4, State: mysql.NonceSent, TxHash: "0x4", Tx: "0xraw4"})
+	if nonce, err := m.next(); err != nil || nonce != 3 || len(node.resent) != 0 {
+		t.Errorf("Must give out a nonce reserved without a tx again, got %v %v %v", nonce, err, node.resent)
+	}
+}
+
+func TestNonceManagerSend(t *testing.T) {
+	m, store, _ := newTestNonceManager(2)
+	txHash, err := m.send(func(nonce uint64) (string, string, error) {
+		return "0x2", "0xraw2", nil
+	})
+	if err != nil || txHash != "0x2" || store.nonces[2].State != mysql.NonceSent || store.nonces[2].Tx != "0xraw2" {
+		t.Errorf("Must record the tx sent, got %v %v %+v", txHash, err, store.nonces[2])
+	}
+
+	m, store, _ = newTestNonceManager(3)
+	if _, err := m.send(func(nonce uint64) (string, string, error) {
+		return "", "", fmt.Errorf("rejected")
+	}); err == nil || store.nonces[3].State != mysql.NonceFailed {
+		t.Errorf("Must free the nonce of a refused tx, got %v %+v", err, store.nonces[3])
+	}
+}
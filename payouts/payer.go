@@ -2,27 +2,34 @@ package payouts
 
 import (
 	"fmt"
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
 	"github.com/cellcrypto/open-dangnn-pool/hook"
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
 	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
 	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
 	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
-	"log"
 	"math/big"
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cellcrypto/open-dangnn-pool/rpc"
 	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/xlog"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
+var log = xlog.Module("payouts")
+
 const txCheckInterval = 5 * time.Second
 
 type PayoutsConfig struct {
 	Enabled      bool   `json:"enabled"`
+	// Name of the pool, set from the pool config
+	Name         string `json:"-"`
 	RequirePeers int64  `json:"requirePeers"`
 	Interval     string `json:"interval"`
 	Daemon       string `json:"daemon"`
@@ -33,8 +40,57 @@ type PayoutsConfig struct {
 	AutoGas      bool   `json:"autoGas"`
 	// In Shannon
 	Threshold int64 `json:"threshold"`
+	// Bounds of thresholds miners set for themselves, in Shannon.
+	// Default to threshold and 100 * threshold.
+	MinPayoutLimit int64 `json:"minPayoutLimit"`
+	MaxPayoutLimit int64 `json:"maxPayoutLimit"`
 	BgSave    bool  `json:"bgsave"`
 	ConcurrentTx int   `json:"concurrentTx"`
+
+	// "legacy" (default) or "eip1559", used when autoGas is off
+	GasStrategy string `json:"gasStrategy"`
+	// In Wei, caps the legacy gas price and the EIP-1559 max fee
+	MaxFeePerGas string `json:"maxFeePerGas"`
+	// In Wei, caps the EIP-1559 priority fee
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas"`
+	EstimateGas          bool   `json:"estimateGas"`
+	// Suggested gas prices from eth_feeHistory or an external oracle
+	GasOracle GasOracleConfig `json:"gasOracle"`
+	// In Wei, runs are skipped while the gas price or base fee plus tip is above it, and retried
+	// after gasRetryInterval ("10m" by default) within the payout window
+	GasCeiling       string `json:"gasCeiling"`
+	GasRetryInterval string `json:"gasRetryInterval"`
+
+	// "node" (default) signs with eth_sendTransaction on an unlocked account,
+	// "keystore" or "privateKey" sign locally and use eth_sendRawTransaction
+	Signer           string `json:"signer"`
+	KeystoreFile     string `json:"keystoreFile"`
+	KeystorePassword string `json:"keystorePassword"`
+	PrivateKey       string `json:"privateKey"`
+
+	// Nonces of the payout account kept in MySQL, one tx built at a time, resynced on gaps
+	NonceManager bool `json:"nonceManager"`
+
+	Multisend MultisendConfig `json:"multisend"`
+	Tracker   TrackerConfig   `json:"tracker"`
+	// Payouts to contracts, with a higher gas limit or held for review
+	ContractPayees ContractPayeesConfig `json:"contractPayees"`
+	// Low balance alerts and sweeps to a cold wallet
+	Wallet WalletConfig `json:"wallet"`
+
+	// Threshold in the price feed currency, e.g. "10.00". Overrides threshold while a rate is available.
+	FiatThreshold string          `json:"fiatThreshold"`
+	PriceFeed     PriceFeedConfig `json:"priceFeed"`
+
+	// UTC times scheduled payouts may run in, e.g. "02:00-04:00" or "Sat,Sun 00:00-06:00".
+	// Empty runs every interval. Manual runs ignore windows.
+	Windows []string `json:"windows"`
+
+	// Dry-run report stored before each run, optionally waiting for operator approval
+	Report PayoutReportConfig `json:"report"`
+
+	// Balance corrections imported through the API
+	Compensation CompensationConfig `json:"compensation"`
 }
 
 func (self PayoutsConfig) GasHex() string {
@@ -59,6 +115,7 @@ func (self PayoutsConfig) GasFeeInShannon() int64 {
 type TxReceipt struct {
 	txHash string
 	login string
+	tx *outgoingTx
 }
 
 type PayoutsProcessor struct {
@@ -66,18 +123,146 @@ type PayoutsProcessor struct {
 	backend  *redis.RedisClient
 	db 		 *mysql.Database
 	rpc      *rpc.RPCClient
+	logs     *plogger.Logger
+	alerts   *alerts.Alerts
+	signer   *txSigner
+	multisend *multisend
+	// Nil without nonceManager
+	nonces   *nonceManager
+	// Nil without wallet.enabled
+	wallet   *walletMonitor
+	windows  []*payoutWindow
+	trigger  chan struct{}
+	compensate chan struct{}
+	priceFeed *PriceFeed
+	// Nil without payouts.gasOracle.source
+	gasOracle *gasOracle
+	// Fires to retry a run skipped over the gas ceiling, nil otherwise
+	gasRetry     <-chan time.Time
+	gasRetryIntv time.Duration
+	// Pool threshold in Shannon and the coin price of the current run
+	threshold int64
+	rate      string
+	// Payees of the current run found to be contracts
+	contracts map[string]bool
+	approvalTimeout time.Duration
 	halt     bool
 	lastFail error
+	// Reloaded config, applied between runs
+	reload chan *PayoutsConfig
+	// The current run failed to read its payees, the payees of the last run and when the last one completed
+	failed   bool
+	queue    int
+	lastPass int64
 }
 
 func NewPayoutsProcessor(cfg *PayoutsConfig, backend *redis.RedisClient, db *mysql.Database, netId int64) *PayoutsProcessor {
-	u := &PayoutsProcessor{config: cfg, backend: backend, db: db}
-	u.rpc = rpc.NewRPCClient("PayoutsProcessor", cfg.Daemon, cfg.Timeout, netId)
+	switch cfg.GasStrategyName() {
+	case GasStrategyLegacy, GasStrategyEIP1559:
+	default:
+		log.Fatalf("Invalid gasStrategy %v, must be %v or %v", cfg.GasStrategy, GasStrategyLegacy, GasStrategyEIP1559)
+	}
+	u := &PayoutsProcessor{config: cfg, backend: backend, db: db, logs: plogger.For(cfg.Name), alerts: alerts.For(cfg.Name), trigger: make(chan struct{}, 1), compensate: make(chan struct{}, 1), reload: make(chan *PayoutsConfig, 1)}
+	windows, err := parsePayoutWindows(cfg.Windows)
+	if err != nil {
+		log.Fatalf("Invalid payout windows: %v", err)
+	}
+	u.windows = windows
+
+	if cfg.Report.RequireApproval && !cfg.Report.Enabled {
+		log.Fatalf("report.requireApproval requires report to be enabled")
+	}
+	u.approvalTimeout = 24 * time.Hour
+	if len(cfg.Report.ApprovalTimeout) > 0 {
+		u.approvalTimeout = util.MustParseDuration(cfg.Report.ApprovalTimeout)
+	}
+
+	if cfg.PriceFeed.Enabled {
+		feed, err := NewPriceFeed(&cfg.PriceFeed)
+		if err != nil {
+			log.Fatalf("Failed to set up price feed: %v", err)
+		}
+		u.priceFeed = feed
+	}
+	if len(cfg.FiatThreshold) > 0 {
+		if u.priceFeed == nil {
+			log.Fatalf("fiatThreshold requires priceFeed to be enabled")
+		}
+		if amount, ok := new(big.Rat).SetString(cfg.FiatThreshold); !ok || amount.Sign() <= 0 {
+			log.Fatalf("Invalid fiatThreshold %v", cfg.FiatThreshold)
+		}
+	}
+	u.rpc = rpc.NewRPCClient(cfg.Name, "PayoutsProcessor", cfg.Daemon, cfg.Timeout, netId)
+
+	if len(cfg.GasOracle.Source) > 0 {
+		oracle, err := newGasOracle(&cfg.GasOracle, u.rpc, cfg.GasStrategyName())
+		if err != nil {
+			log.Fatalf("Invalid gasOracle: %v", err)
+		}
+		u.gasOracle = oracle
+		log.Infof("Suggested gas prices from the %v gas oracle", cfg.GasOracle.Source)
+	}
+	if len(cfg.GasCeiling) > 0 && util.String2Big(cfg.GasCeiling).Sign() <= 0 {
+		log.Fatalf("Invalid gasCeiling %v", cfg.GasCeiling)
+	}
+	u.gasRetryIntv = 10 * time.Minute
+	if len(cfg.GasRetryInterval) > 0 {
+		u.gasRetryIntv = util.MustParseDuration(cfg.GasRetryInterval)
+	}
+
+	if cfg.SignerName() != SignerNode {
+		chainId, err := u.rpc.GetChainId()
+		if err != nil {
+			log.Fatalf("Unable to get chain id from node to sign payouts: %v", err)
+		}
+		if chainId.Sign() <= 0 {
+			// The network id isn't the chain id on every network, a tx signed with it could be replayed.
+			log.Fatalf("Node reported invalid chain id %v to sign payouts", chainId)
+		}
+		signer, err := newTxSigner(cfg, chainId)
+		if err != nil {
+			log.Fatalf("Failed to load payout signer: %v", err)
+		}
+		u.signer = signer
+		log.Infof("Signing payouts locally for %v with chain id %v", signer.address.Hex(), chainId)
+	}
+
+	if cfg.NonceManager {
+		u.nonces = newNonceManager(cfg, db, u.rpc, u.logs)
+		if err := u.nonces.recover(); err != nil {
+			log.Fatalf("Failed to load payout nonces: %v", err)
+		}
+		log.Infof("Payout nonces of %v kept in payout_nonces", cfg.Address)
+	}
+
+	if err := cfg.ContractPayees.check(); err != nil {
+		log.Fatalf("Invalid payouts.contractPayees: %v", err)
+	}
+
+	if cfg.Wallet.Enabled {
+		wallet, err := newWalletMonitor(cfg)
+		if err != nil {
+			log.Fatalf("Invalid payouts.wallet: %v", err)
+		}
+		u.wallet = wallet
+		if len(cfg.Wallet.ColdAddress) > 0 {
+			log.Infof("Sweeping %v above %v Wei to %v", cfg.Address, cfg.Wallet.SweepAbove, cfg.Wallet.ColdAddress)
+		}
+	}
+
+	if cfg.Multisend.Enabled {
+		m, err := newMultisend(&cfg.Multisend)
+		if err != nil {
+			log.Fatalf("Failed to set up multisend payouts: %v", err)
+		}
+		u.multisend = m
+		log.Infof("Batch payouts via multisend contract %v, up to %v recipients", cfg.Multisend.Address, cfg.Multisend.MaxRecipients)
+	}
 	return u
 }
 
 func (u *PayoutsProcessor) Start() {
-	log.Println("Starting payouts")
+	log.Info("Starting payouts")
 
 	//if u.mustResolvePayout() {
 	//	log.Println("Running with env RESOLVE_PAYOUT=1, now trying to resolve locked payouts")
@@ -88,7 +273,7 @@ func (u *PayoutsProcessor) Start() {
 
 	intv := util.MustParseDuration(u.config.Interval)
 	timer := time.NewTimer(intv)
-	log.Printf("Set payouts interval to %v", intv)
+	log.Infof("Set payouts interval to %v", intv)
 
 	//payments := u.backend.GetPendingPayments()
 	//if len(payments) > 0 {
@@ -99,23 +284,36 @@ func (u *PayoutsProcessor) Start() {
 
 	locked, err := u.backend.IsPayoutsLocked()
 	if err != nil {
-		log.Println("Unable to start payouts:", err)
+		log.Error("Unable to start payouts:", err)
 		return
 	}
 	if locked {
-		log.Println("Unable to start payouts because they are locked")
+		log.Error("Unable to start payouts because they are locked")
 		return
 	}
 
+	if len(u.windows) > 0 {
+		log.Infof("Scheduled payouts only run in %v UTC", u.config.Windows)
+	}
+	u.backend.InitPubSub(redis.ChannelPayout, u)
+
 	// Immediately process payouts after start
-	u.process()
+	u.applyCompensations()
+	u.scheduledProcess()
 	timer.Reset(intv)
 	quit := make(chan struct{})
 	hooks := make(chan struct{})
+	// Fires the hot wallet checks, never without wallet.enabled
+	var walletCheck <-chan time.Time
+	if u.wallet != nil {
+		ticker := time.NewTicker(u.wallet.interval)
+		walletCheck = ticker.C
+		u.checkWallet()
+	}
 
-	plogger.InsertLog("START PAYMENT SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
-	hook.RegistryHook("payer.go", func(name string) {
-		plogger.InsertLog("SHUTDOWN PAYMENT SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+	u.logs.InsertLog("START PAYMENT SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+	hook.RegistryHook(util.Join("payer.go", u.config.Name), func(name string) {
+		u.logs.InsertLog("SHUTDOWN PAYMENT SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
 		close(quit)
 		<- hooks
 	})
@@ -127,36 +325,156 @@ func (u *PayoutsProcessor) Start() {
 				hooks <- struct{}{}
 				return
 			case <-timer.C:
+				u.applyCompensations()
+				u.scheduledProcess()
+				timer.Reset(intv)
+			case <-u.trigger:
+				u.logs.InsertLog("MANUAL PAYOUT RUN", plogger.LogTypePaymentWork, plogger.LogErrorNothing, 0, 0, "", "")
 				u.process()
+			case <-u.compensate:
+				u.applyCompensations()
+			case <-u.gasRetry:
+				u.retryGas()
+			case <-walletCheck:
+				u.checkWallet()
+			case next := <-u.reload:
+				intv = u.applyConfig(next)
+				if !timer.Stop() {
+					<-timer.C
+				}
 				timer.Reset(intv)
 			}
 		}
 	}()
 }
 
+// Reload hands the thresholds, interval and windows of a reloaded config to the payer, they apply
+// from its next run. The rest of the config needs a restart.
+func (u *PayoutsProcessor) Reload(cfg *PayoutsConfig) error {
+	if cfg.Threshold <= 0 {
+		return fmt.Errorf("invalid threshold %v", cfg.Threshold)
+	}
+	if intv, err := time.ParseDuration(cfg.Interval); err != nil || intv <= 0 {
+		return fmt.Errorf("invalid interval %v", cfg.Interval)
+	}
+	if _, err := parsePayoutWindows(cfg.Windows); err != nil {
+		return fmt.Errorf("invalid payout windows: %v", err)
+	}
+	// A reload not applied yet is replaced
+	select {
+	case <-u.reload:
+	default:
+	}
+	u.reload <- cfg
+	return nil
+}
+
+func (u *PayoutsProcessor) applyConfig(cfg *PayoutsConfig) time.Duration {
+	u.config.Threshold = cfg.Threshold
+	u.config.MinPayoutLimit = cfg.MinPayoutLimit
+	u.config.MaxPayoutLimit = cfg.MaxPayoutLimit
+	u.config.Interval = cfg.Interval
+	u.config.Windows = cfg.Windows
+	u.windows, _ = parsePayoutWindows(cfg.Windows)
+	intv := util.MustParseDuration(cfg.Interval)
+	log.Infof("Reloaded payouts: threshold %v Shannon, interval %v, windows %v", cfg.Threshold, intv, cfg.Windows)
+	return intv
+}
+
+func (u *PayoutsProcessor) scheduledProcess() {
+	now := time.Now()
+	// Payout ETAs of the API count intervals from here
+	if err := u.backend.SetLastPayoutRun(now.Unix()); err != nil {
+		log.Errorf("Failed to record the payout run: %v", err)
+	}
+	if !u.inPayoutWindow(now) {
+		log.Info("Outside of payout windows, skipping payouts")
+		return
+	}
+	u.process()
+}
+
+// RedisMessage handles requests published to the payout channel.
+func (u *PayoutsProcessor) RedisMessage(payload string) {
+	splitData := strings.Split(payload,":")
+	if len(splitData) != 3 {
+		return
+	}
+	opcode := splitData[0]
+	from := splitData[1]
+	switch opcode {
+	case redis.OpcodePayoutRun:
+		select {
+		case u.trigger <- struct{}{}:
+			log.Infof("Manual payout run requested")
+		default:
+			log.Infof("Manual payout run is already queued")
+		}
+	case redis.OpcodeCompensation:
+		select {
+		case u.compensate <- struct{}{}:
+		default:
+		}
+	default:
+		log.Errorf("not defined opcode: %v", opcode)
+	}
+
+	log.Debugf("Handled payout message opcode %v from %v", opcode, from)
+}
+
+// reportHalt alerts, once per cooldown, while payouts are halted. They stay halted until restarted.
+func (u *PayoutsProcessor) reportHalt() {
+	metrics.PayoutsHalted.SetBool(u.halt, u.config.Name)
+	if u.halt {
+		u.alerts.Fire(alerts.EventPayoutsFailed, "payouts", "Payouts halted: %v", u.lastFail)
+	}
+}
+
 func (u *PayoutsProcessor) process() {
+	defer u.reportHealth()
+	defer u.reportHalt()
 	if u.halt {
-		log.Println("Payments suspended due to last critical error:", u.lastFail)
+		log.Error("Payments suspended due to last critical error:", u.lastFail)
 		return
 	}
-	mustPay := 0
-	minersPaid := 0
+	var mustPay, minersPaid int
 	totalAmount := big.NewInt(0)
+	u.updateThreshold()
 	baseBalance := u.GetReachedThreshold()
+	if minLimit, _ := u.config.PayoutLimits(); minLimit < baseBalance.Int64() {
+		baseBalance = big.NewInt(minLimit)
+	}
 	payees, err := u.db.GetPayees(baseBalance.String())
 
 	// payees, err := u.backend.GetPayees()
-	if err != nil {
-		log.Println("Error while retrieving payees from mysql:", err)
+	if metrics.MysqlError(u.config.Name, "get_payees", err) != nil {
+		log.Error("Error while retrieving payees from mysql:", err)
+		u.failed = true
 		return
 	}
+	metrics.PayoutQueue.Set(float64(len(payees)), u.config.Name)
+	u.queue = len(payees)
 
-	log.Printf("Info: process payout count: %v\n", len(payees))
+	log.Infof("process payout count: %v", len(payees))
 
+	if len(payees) == 0 {
+		return
+	}
+	if !u.belowGasCeiling() {
+		return
+	}
+	payees = u.screenContracts(payees)
 	if len(payees) == 0 {
 		return
 	}
 
+	if u.config.Report.Enabled {
+		payees = u.reviewPayout(payees)
+		if len(payees) == 0 {
+			return
+		}
+	}
+
 	//waitingCount := 0
 	//var wg sync.WaitGroup
 
@@ -167,46 +485,44 @@ func (u *PayoutsProcessor) process() {
 		go func() {
 			defer wg.Done()
 			for receiptData := range txReceipts {
-				for {
-					log.Printf("Waiting for tx confirmation: %v", receiptData.txHash)
-					time.Sleep(txCheckInterval)
-					receipt, err := u.rpc.GetTxReceipt(receiptData.txHash)
-					if err != nil {
-						log.Printf("Failed to get tx receipt for %v: %v", receiptData.txHash, err)
-						continue
-					}
-					// Tx has been mined
-					if receipt != nil && receipt.Confirmed() {
-						if receipt.Successful() {
-							log.Printf("Payout tx successful for %s: %s", receiptData.login, receiptData.txHash)
-						} else {
-							//log.Printf("Payout tx failed for %s: %s. Address contract throws on incoming tx.", login, txHash)
-							plogger.InsertSystemPaymemtError(plogger.LogTypePaymentWork, receiptData.login, "",
-								"Payout tx failed for %s: %s. Address contract throws on incoming tx.", receiptData.login, receiptData.txHash)
-						}
-						break
-					}
-				}
+				u.trackPayment(receiptData)
 			}
 		}()
 	}
 
+	if u.batched() {
+		mustPay, minersPaid = u.processBatches(payees, totalAmount, txReceipts)
+	} else {
+		mustPay, minersPaid = u.processPayees(payees, totalAmount, txReceipts)
+	}
+
+	close(txReceipts)
+	wg.Wait()
+
+	if mustPay > 0 {
+		log.Infof("Paid total %v Shannon to %v of %v payees", totalAmount, minersPaid, mustPay)
+	} else {
+		log.Info("No payees that have reached payout threshold")
+	}
+
+	// Save redis state to disk
+	if minersPaid > 0 && u.config.BgSave {
+		u.bgSave()
+	}
+}
+
+// processPayees sends one transaction per payee.
+func (u *PayoutsProcessor) processPayees(payees []*mysql.Payees, totalAmount *big.Int, txReceipts chan<- *TxReceipt) (int, int) {
+	mustPay := 0
+	minersPaid := 0
+
 	for _, payee := range payees {
 		// amount, _ := u.backend.GetBalance(payee.Addr)
 		amount, login , coin := payee.Balance, payee.Addr, payee.Coin
-		amountInShannon := big.NewInt(amount)
-
-		// Shannon^2 = Wei
-		amountInWei := new(big.Int).Mul(amountInShannon, util.Shannon)
+		amountInWei := u.payoutWei(payee, 0)
 
-		if payee.Payout_limit > 0 {
-			if payee.Payout_limit > payee.Balance {
-				continue
-			}
-		} else {
-			if !u.reachedThreshold(amountInShannon) {
-				continue
-			}
+		if !u.payeeReachedThreshold(payee) {
+			continue
 		}
 
 		mustPay++
@@ -225,7 +541,7 @@ func (u *PayoutsProcessor) process() {
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
-			plogger.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
 				"rpc connection failed addr:%v err:%v", u.config.Address, err)
 			break
 		}
@@ -234,32 +550,50 @@ func (u *PayoutsProcessor) process() {
 				amountInWei.String(), poolBalance.String())
 			u.halt = true
 			u.lastFail = err
-			plogger.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
 				"not enough coins. addr:%v err:%v", u.config.Address, err)
 			break
 		}
 
 		// excluding gas fee
 		gasFee := u.config.GasFeeInShannon()
+		var quote *gasQuote
+		// Local signing needs explicit gas values even with autoGas.
+		if !u.config.AutoGas || u.signer != nil {
+			quote, err = u.quoteGasLimit(login, amountInWei, nil, u.payeeGas(login))
+			if isGasCeiling(err) {
+				u.skipForGas(err)
+				break
+			}
+			if err != nil {
+				// Fee market is out of bounds, try this payee again on the next run.
+				u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
+					"Unable to quote gas for %s: %v", login, err)
+				continue
+			}
+			if !u.config.AutoGas {
+				gasFee = quote.FeeInShannon()
+			}
+		}
 		totalamount := amount
+		charged := int64(0)
 		if !u.config.AutoGas {
-			amount -= gasFee
+			charged = gasFee
 		}
-		amountInShannon = big.NewInt(amount)
+		amountInWei = u.payoutWei(payee, charged)
+		amount = new(big.Int).Quo(amountInWei, util.Shannon).Int64()
 
 		if amount <= 0 {
-			return
+			break
 		}
 
-		// Shannon^2 = Wei
-		amountInWei = new(big.Int).Mul(amountInShannon, util.Shannon)
-		log.Printf("Locked payment for %s, %v Shannon gas fee: %v Shannon", login, totalamount,gasFee)
+		log.Infof("Locked payment for %s, %v Shannon gas fee: %v Shannon (%v)", login, totalamount,gasFee, quote)
 		// Lock payments for current payout
 		// Debit miner's balance and update stats
-		ret, err := u.db.UpdateBalance(login, amount, gasFee, coin)
+		ret, err := u.db.UpdateBalance(login, amount, amountInWei, gasFee, coin)
 		if err != nil {
 			//log.Printf("Error: %v Already Locked payment for %s, %v Shannon", err, login, amount)
-			plogger.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
 				"Error: %v Already Locked payment for %s, %v Shannon", err, login, amount)
 			continue
 		}
@@ -267,76 +601,66 @@ func (u *PayoutsProcessor) process() {
 		if ret > 0 {
 			// This is an already locked miner.
 			//log.Printf("Already Locked payment for %s, %v Shannon", login, amount)
-			plogger.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
 				"Already Locked payment for %s, %v Shannon", login, amount)
 			continue
 		}
 
 		value := hexutil.EncodeBig(amountInWei)
-		txHash, err := u.rpc.SendTransaction(u.config.Address, login, u.config.GasHex(), u.config.GasPriceHex(), value, u.config.AutoGas)
+		tx, err := u.sendPayment(login, amountInWei, quote)
 		if err != nil {
 			//log.Printf("Failed to send payment to %s, %v Shannon: %v. Check outgoing tx for %s in block explorer and docs/PAYOUTS.md",
 			//	login, amount, err, login)
 			u.halt = true
 			u.lastFail = err
-			plogger.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
 				"Failed to send payment to %s, %v Shannon: %v. Check outgoing tx for %s in block explorer and docs/PAYOUTS.md",
 				login, amount, err, login)
 			break
 		}
 
-		if postCommand, present := os.LookupEnv("POST_PAYOUT_HOOK"); present {
-			go func(postCommand string, login string, value string) {
-				out, err := exec.Command(postCommand, login, value).CombinedOutput()
-				if err != nil {
-					log.Printf("WARNING: Error running post payout hook: %s", err.Error())
-				}
-				log.Printf("Running post payout hook with result: %s", out)
-			}(postCommand, login, value)
-		}
+		txHash := tx.hash()
+
+		runPostPayoutHook(login, value, payee.Memo)
 
 		// Log transaction hash
-		err = u.db.WritePayment(login, txHash, amount, gasFee, coin, u.config.Address)
+		err = u.db.WritePayment(login, txHash, amount, gasFee, coin, u.config.Address, u.rate, u.config.PriceFeed.Currency)
 		// err = u.backend.WritePayment(login, txHash, amount)
-		if err != nil {
+		if metrics.MysqlError(u.config.Name, "write_payment", err) != nil {
 			//log.Printf("Failed to log payment data for %s, %v Shannon, tx: %s: %v", login, amount, txHash, err)
 			u.halt = true
 			u.lastFail = err
-			plogger.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, login, "",
 				"Failed to log payment data for %s, %v Shannon, tx: %s: %v", login, amount, txHash, err)
 			break
 		}
 
 		minersPaid++
+		metrics.PayoutsSent.Inc(u.config.Name)
 		totalAmount.Add(totalAmount, big.NewInt(amount))
-		log.Printf("Paid %v Shannon to %v, TxHash: %v", amount, login, txHash)
+		log.Infof("Paid %v Shannon to %v, TxHash: %v", amount, login, txHash)
+		if err := u.backend.PublishPaymentSent(login, amount, txHash); err != nil {
+			log.Errorf("Failed to publish payment to %v: %v", login, err)
+		}
 
 		// TxReceipt verification operation
 		txReceipts <- &TxReceipt{
 			txHash: txHash,
 			login:  login,
+			tx:     tx,
 		}
 	}
 
-	close(txReceipts)
-	wg.Wait()
-
-	if mustPay > 0 {
-		log.Printf("Paid total %v Shannon to %v of %v payees", totalAmount, minersPaid, mustPay)
-	} else {
-		log.Println("No payees that have reached payout threshold")
-	}
-
-	// Save redis state to disk
-	if minersPaid > 0 && u.config.BgSave {
-		u.bgSave()
-	}
+	return mustPay, minersPaid
 }
 
 func (self PayoutsProcessor) isUnlockedAccount() bool {
+	if self.signer != nil {
+		return true
+	}
 	_, err := self.rpc.Sign(self.config.Address, "0x0")
 	if err != nil {
-		log.Println("Unable to process payouts:", err)
+		log.Error("Unable to process payouts:", err)
 		return false
 	}
 	return true
@@ -345,24 +669,87 @@ func (self PayoutsProcessor) isUnlockedAccount() bool {
 func (self PayoutsProcessor) checkPeers() bool {
 	n, err := self.rpc.GetPeerCount()
 	if err != nil {
-		log.Println("Unable to start payouts, failed to retrieve number of peers from node:", err)
+		log.Error("Unable to start payouts, failed to retrieve number of peers from node:", err)
 		return false
 	}
 	if n < self.config.RequirePeers {
-		log.Printf("Unable to start payouts, number of peers on a node is less than required %v (current:%v)\n", self.config.RequirePeers, n)
+		log.Errorf("Unable to start payouts, number of peers on a node is less than required %v (current:%v)", self.config.RequirePeers, n)
 		return false
 	}
 	return true
 }
 
+// payoutWei is the value paid to payee, its balance less the gas fee charged to it. The balance is
+// exact with weiAccounting, in whole Shannon otherwise.
+func (u *PayoutsProcessor) payoutWei(payee *mysql.Payees, gasFee int64) *big.Int {
+	wei := new(big.Int).Mul(big.NewInt(payee.Balance), util.Shannon)
+	if u.db.Config.WeiAccounting && payee.BalanceWei != nil {
+		wei.Set(payee.BalanceWei)
+	}
+	return wei.Sub(wei, new(big.Int).Mul(big.NewInt(gasFee), util.Shannon))
+}
+
 func (self PayoutsProcessor) reachedThreshold(amount *big.Int) bool {
-	return big.NewInt(self.config.Threshold).Cmp(amount) < 0
+	return self.GetReachedThreshold().Cmp(amount) < 0
+}
+
+// PayoutLimits returns the range a miner's own payout threshold is kept in.
+func (self PayoutsConfig) PayoutLimits() (int64, int64) {
+	minLimit, maxLimit := self.MinPayoutLimit, self.MaxPayoutLimit
+	if minLimit <= 0 {
+		minLimit = self.Threshold
+	}
+	if maxLimit <= 0 {
+		maxLimit = self.Threshold * 100
+	}
+	return minLimit, maxLimit
+}
+
+// payeeReachedThreshold checks the miner's own threshold, clamped to the current limits, or the pool threshold.
+func (self PayoutsProcessor) payeeReachedThreshold(payee *mysql.Payees) bool {
+	if payee.Payout_limit <= 0 {
+		return self.reachedThreshold(big.NewInt(payee.Balance))
+	}
+	limit := payee.Payout_limit
+	minLimit, maxLimit := self.config.PayoutLimits()
+	if limit < minLimit {
+		limit = minLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return payee.Balance >= limit
 }
 
 func (self PayoutsProcessor) GetReachedThreshold() *big.Int {
+	if self.threshold > 0 {
+		return big.NewInt(self.threshold)
+	}
 	return big.NewInt(self.config.Threshold)
 }
 
+// updateThreshold converts fiatThreshold at the current rate. Without a rate the threshold in Shannon applies.
+func (u *PayoutsProcessor) updateThreshold() {
+	u.threshold = u.config.Threshold
+	u.rate = ""
+	if u.priceFeed == nil {
+		return
+	}
+	rate, err := u.priceFeed.Rate()
+	if err != nil {
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, "", "",
+			"Failed to get %v price, using threshold of %v Shannon: %v", u.config.PriceFeed.Currency, u.config.Threshold, err)
+		return
+	}
+	u.rate = rate.FloatString(8)
+	if len(u.config.FiatThreshold) == 0 {
+		return
+	}
+	amount, _ := new(big.Rat).SetString(u.config.FiatThreshold)
+	u.threshold = fiatToShannon(amount, rate)
+	log.Infof("Payout threshold %v %v is %v Shannon at %v", u.config.FiatThreshold, u.config.PriceFeed.Currency, u.threshold, u.rate)
+}
+
 
 func formatPendingPayments(list []*redis.PendingPayment) string {
 	var s string
@@ -375,42 +762,61 @@ func formatPendingPayments(list []*redis.PendingPayment) string {
 func (self PayoutsProcessor) bgSave() {
 	result, err := self.backend.BgSave()
 	if err != nil {
-		log.Println("Failed to perform BGSAVE on backend:", err)
+		log.Error("Failed to perform BGSAVE on backend:", err)
 		return
 	}
-	log.Println("Saving backend state to disk:", result)
+	log.Info("Saving backend state to disk:", result)
 }
 
 func (self PayoutsProcessor) resolvePayouts() {
 	payments := self.backend.GetPendingPayments()
 
 	if len(payments) > 0 {
-		log.Printf("Will credit back following balances:\n%s", formatPendingPayments(payments))
+		log.Infof("Will credit back following balances:\n%s", formatPendingPayments(payments))
 
 		for _, v := range payments {
 			err := self.backend.RollbackBalance(v.Address, v.Amount)
 			if err != nil {
-				log.Printf("Failed to credit %v Shannon back to %s, error is: %v", v.Amount, v.Address, err)
+				log.Errorf("Failed to credit %v Shannon back to %s, error is: %v", v.Amount, v.Address, err)
 				return
 			}
-			log.Printf("Credited %v Shannon back to %s", v.Amount, v.Address)
+			log.Infof("Credited %v Shannon back to %s", v.Amount, v.Address)
 		}
 		err := self.backend.UnlockPayouts()
 		if err != nil {
-			log.Println("Failed to unlock payouts:", err)
+			log.Error("Failed to unlock payouts:", err)
 			return
 		}
 	} else {
-		log.Println("No pending payments to resolve")
+		log.Info("No pending payments to resolve")
 	}
 
 	if self.config.BgSave {
 		self.bgSave()
 	}
-	log.Println("Payouts unlocked")
+	log.Info("Payouts unlocked")
 }
 
 func (self PayoutsProcessor) mustResolvePayout() bool {
 	v, _ := strconv.ParseBool(os.Getenv("RESOLVE_PAYOUT"))
 	return v
 }
+
+// runPostPayoutHook runs POST_PAYOUT_HOOK with the login and the value in Wei, and the payout memo if it has one.
+func runPostPayoutHook(login, value, memo string) {
+	postCommand, present := os.LookupEnv("POST_PAYOUT_HOOK")
+	if !present {
+		return
+	}
+	args := []string{login, value}
+	if len(memo) > 0 {
+		args = append(args, memo)
+	}
+	go func() {
+		out, err := exec.Command(postCommand, args...).CombinedOutput()
+		if err != nil {
+			log.Warnf("Error running post payout hook: %s", err.Error())
+		}
+		log.Infof("Running post payout hook with result: %s", out)
+	}()
+}
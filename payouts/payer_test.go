@@ -0,0 +1,35 @@
+package payouts
+
+import (
+	"testing"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+)
+
+func TestPayeeReachedThreshold(t *testing.T) {
+	u := PayoutsProcessor{config: &PayoutsConfig{Threshold: 500, MinPayoutLimit: 300, MaxPayoutLimit: 1000}}
+
+	cases := []struct {
+		balance, limit int64
+		expected       bool
+	}{
+		{500, 0, false}, // pool threshold is exclusive
+		{501, 0, true},
+		{400, 400, true}, // own threshold is inclusive
+		{399, 400, false},
+		{300, 100, true}, // raised to min limit
+		{299, 100, false},
+		{1000, 5000, true}, // lowered to max limit
+	}
+	for _, c := range cases {
+		payee := &mysql.Payees{Balance: c.balance, Payout_limit: c.limit}
+		if u.payeeReachedThreshold(payee) != c.expected {
+			t.Errorf("Balance %v with limit %v must be %v", c.balance, c.limit, c.expected)
+		}
+	}
+
+	minLimit, maxLimit := PayoutsConfig{Threshold: 500}.PayoutLimits()
+	if minLimit != 500 || maxLimit != 50000 {
+		t.Errorf("Limits must default to threshold and 100 * threshold, got %v and %v", minLimit, maxLimit)
+	}
+}
@@ -0,0 +1,310 @@
+package payouts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+const (
+	PriceProviderCoinGecko = "coingecko"
+	PriceProviderJson      = "json"
+)
+
+const defaultCoinGeckoUrl = "https://api.coingecko.com/api/v3"
+
+type PriceFeedConfig struct {
+	Enabled bool `json:"enabled"`
+	// "coingecko" (default) or "json" for any endpoint returning the price in a JSON field
+	Provider string `json:"provider"`
+	Url      string `json:"url"`
+	// CoinGecko coin id
+	CoinId   string `json:"coinId"`
+	Currency string `json:"currency"`
+	// Dotted path of the price in a "json" provider reply, e.g. "data.price"
+	Field   string `json:"field"`
+	Timeout string `json:"timeout"`
+	// The rate is refreshed after cacheTtl, a stale rate is still used until maxAge if the provider fails
+	CacheTtl string `json:"cacheTtl"`
+	MaxAge   string `json:"maxAge"`
+	// More providers, asked along with the one above if it is set. The rate is their median.
+	Sources []PriceSourceConfig `json:"sources"`
+	// Providers that must answer for a new rate, 1 by default
+	Quorum int `json:"quorum"`
+	// Prices further from the median than this, in percent, are left out. 0 keeps all.
+	MaxSpread float64 `json:"maxSpread"`
+}
+
+// PriceSourceConfig is a provider of PriceFeedConfig.Sources, with the same fields.
+type PriceSourceConfig struct {
+	Provider string `json:"provider"`
+	Url      string `json:"url"`
+	CoinId   string `json:"coinId"`
+	Field    string `json:"field"`
+}
+
+// PriceQuote is the coin price with where it stands. A stale quote is the last one the providers
+// agreed on, kept while they fail until it is maxAge old.
+type PriceQuote struct {
+	Rate      *big.Rat
+	Currency  string
+	UpdatedAt time.Time
+	// Providers the rate is the median of
+	Sources int
+	Stale   bool
+}
+
+// PriceFeed caches the coin price in the configured currency.
+type PriceFeed struct {
+	config    *PriceFeedConfig
+	sources   []*PriceSourceConfig
+	client    *http.Client
+	cacheTtl  time.Duration
+	maxAge    time.Duration
+	mu        sync.Mutex
+	rate      *big.Rat
+	updatedAt time.Time
+	count     int
+}
+
+func NewPriceFeed(cfg *PriceFeedConfig) (*PriceFeed, error) {
+	if len(cfg.Currency) == 0 {
+		cfg.Currency = "usd"
+	}
+	var sources []*PriceSourceConfig
+	if len(cfg.Provider) > 0 || len(cfg.Sources) == 0 {
+		sources = append(sources, &PriceSourceConfig{Provider: cfg.Provider, Url: cfg.Url, CoinId: cfg.CoinId, Field: cfg.Field})
+	}
+	for i := range cfg.Sources {
+		sources = append(sources, &cfg.Sources[i])
+	}
+	for _, src := range sources {
+		if err := checkPriceSource(src); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Quorum <= 0 {
+		cfg.Quorum = 1
+	}
+	if cfg.Quorum > len(sources) {
+		return nil, fmt.Errorf("quorum of %v with %v price providers", cfg.Quorum, len(sources))
+	}
+
+	p := &PriceFeed{config: cfg, sources: sources, cacheTtl: 10 * time.Minute, maxAge: time.Hour}
+	timeout := 10 * time.Second
+	if len(cfg.Timeout) > 0 {
+		timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	if len(cfg.CacheTtl) > 0 {
+		p.cacheTtl = util.MustParseDuration(cfg.CacheTtl)
+	}
+	if len(cfg.MaxAge) > 0 {
+		p.maxAge = util.MustParseDuration(cfg.MaxAge)
+	}
+	p.client = &http.Client{Timeout: timeout}
+	return p, nil
+}
+
+func checkPriceSource(src *PriceSourceConfig) error {
+	if len(src.Provider) == 0 {
+		src.Provider = PriceProviderCoinGecko
+	}
+	switch src.Provider {
+	case PriceProviderCoinGecko:
+		if len(src.CoinId) == 0 {
+			return fmt.Errorf("coinId is required for %v", src.Provider)
+		}
+		if len(src.Url) == 0 {
+			src.Url = defaultCoinGeckoUrl
+		}
+	case PriceProviderJson:
+		if len(src.Url) == 0 || len(src.Field) == 0 {
+			return fmt.Errorf("url and field are required for %v", src.Provider)
+		}
+	default:
+		return fmt.Errorf("unknown price provider %v", src.Provider)
+	}
+	return nil
+}
+
+// Rate returns the price of one coin in the configured currency.
+func (p *PriceFeed) Rate() (*big.Rat, error) {
+	quote, err := p.Quote()
+	if err != nil {
+		return nil, err
+	}
+	return quote.Rate, nil
+}
+
+// Quote returns the price of one coin in the configured currency, refreshed once it is cacheTtl old.
+// If too few providers answer, the last rate is returned as stale until it is maxAge old.
+func (p *PriceFeed) Quote() (*PriceQuote, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rate != nil && time.Since(p.updatedAt) < p.cacheTtl {
+		return p.quote(false), nil
+	}
+	rate, count, err := p.fetchAll()
+	if err != nil {
+		if p.rate != nil && time.Since(p.updatedAt) < p.maxAge {
+			log.Errorf("Failed to refresh %v price, using rate from %v: %v", p.config.Currency, p.updatedAt, err)
+			return p.quote(true), nil
+		}
+		return nil, err
+	}
+	p.rate = rate
+	p.updatedAt = time.Now()
+	p.count = count
+	return p.quote(false), nil
+}
+
+func (p *PriceFeed) quote(stale bool) *PriceQuote {
+	return &PriceQuote{Rate: p.rate, Currency: p.config.Currency, UpdatedAt: p.updatedAt, Sources: p.count, Stale: stale}
+}
+
+// fetchAll asks every provider at once and returns the median of the prices, with how many it is of.
+func (p *PriceFeed) fetchAll() (*big.Rat, int, error) {
+	type result struct {
+		rate *big.Rat
+		err  error
+	}
+	results := make([]result, len(p.sources))
+	var wg sync.WaitGroup
+	for i, src := range p.sources {
+		wg.Add(1)
+		go func(i int, src *PriceSourceConfig) {
+			defer wg.Done()
+			rate, err := p.fetch(src)
+			if err == nil && rate.Sign() <= 0 {
+				err = fmt.Errorf("invalid %v price %v", p.config.Currency, rate.FloatString(8))
+			}
+			results[i] = result{rate, err}
+		}(i, src)
+	}
+	wg.Wait()
+
+	var rates []*big.Rat
+	var lastErr error
+	for i, res := range results {
+		if res.err != nil {
+			log.Warnf("Price provider %v failed: %v", p.sources[i].Provider, res.err)
+			lastErr = res.err
+			continue
+		}
+		rates = append(rates, res.rate)
+	}
+	rates = withinSpread(rates, p.config.MaxSpread)
+	if len(rates) < p.config.Quorum {
+		if lastErr == nil {
+			lastErr = errors.New("prices too far apart")
+		}
+		return nil, len(rates), fmt.Errorf("%v of %v price providers agree, %v needed: %v", len(rates), len(p.sources), p.config.Quorum, lastErr)
+	}
+	return medianRate(rates), len(rates), nil
+}
+
+// medianRate returns the median of rates, the mean of the middle two for an even count.
+func medianRate(rates []*big.Rat) *big.Rat {
+	sorted := append([]*big.Rat(nil), rates...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cmp(sorted[j]) < 0 })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	sum := new(big.Rat).Add(sorted[mid-1], sorted[mid])
+	return sum.Quo(sum, big.NewRat(2, 1))
+}
+
+// withinSpread leaves out the rates more than spread percent from the median of rates.
+func withinSpread(rates []*big.Rat, spread float64) []*big.Rat {
+	if spread <= 0 || len(rates) < 3 {
+		return rates
+	}
+	median := medianRate(rates)
+	limit := new(big.Rat).Mul(median, new(big.Rat).SetFloat64(spread/100))
+	var kept []*big.Rat
+	for _, rate := range rates {
+		diff := new(big.Rat).Sub(rate, median)
+		if diff.Abs(diff).Cmp(limit) <= 0 {
+			kept = append(kept, rate)
+		}
+	}
+	return kept
+}
+
+func (p *PriceFeed) fetch(src *PriceSourceConfig) (*big.Rat, error) {
+	reqUrl := src.Url
+	path := strings.Split(src.Field, ".")
+	if src.Provider == PriceProviderCoinGecko {
+		params := url.Values{}
+		params.Set("ids", src.CoinId)
+		params.Set("vs_currencies", p.config.Currency)
+		reqUrl = strings.TrimRight(src.Url, "/") + "/simple/price?" + params.Encode()
+		// {"<coinId>":{"<currency>":1.23}}
+		path = []string{src.CoinId, p.config.Currency}
+	}
+
+	resp, err := p.client.Get(reqUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: unexpected status %v", src.Provider, resp.Status)
+	}
+
+	var reply interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&reply)
+	if err != nil {
+		return nil, err
+	}
+	return priceField(reply, path)
+}
+
+// priceField reads a number or numeric string at path.
+func priceField(reply interface{}, path []string) (*big.Rat, error) {
+	value := reply
+	for _, key := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("no price field %v", strings.Join(path, "."))
+		}
+		value, ok = m[key]
+		if !ok {
+			return nil, fmt.Errorf("no price field %v", strings.Join(path, "."))
+		}
+	}
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return nil, fmt.Errorf("price field %v is not a number", strings.Join(path, "."))
+	}
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid price %v", s)
+	}
+	return rate, nil
+}
+
+// fiatToShannon converts an amount in the feed currency to Shannon at rate.
+func fiatToShannon(amount, rate *big.Rat) int64 {
+	coins := new(big.Rat).Quo(amount, rate)
+	shannon := coins.Mul(coins, new(big.Rat).SetInt(util.Shannon))
+	return new(big.Int).Quo(shannon.Num(), shannon.Denom()).Int64()
+}
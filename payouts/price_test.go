@@ -0,0 +1,122 @@
+package payouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPriceField(t *testing.T) {
+	var reply interface{}
+	decoder := json.NewDecoder(strings.NewReader(`{"ethereum":{"usd":1834.25},"data":{"price":"0.0125"}}`))
+	decoder.UseNumber()
+	if err := decoder.Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+
+	rate, err := priceField(reply, []string{"ethereum", "usd"})
+	if err != nil || rate.Cmp(big.NewRat(183425, 100)) != 0 {
+		t.Errorf("Must read numeric price, got %v %v", rate, err)
+	}
+	rate, err = priceField(reply, []string{"data", "price"})
+	if err != nil || rate.Cmp(big.NewRat(125, 10000)) != 0 {
+		t.Errorf("Must read string price, got %v %v", rate, err)
+	}
+	if _, err = priceField(reply, []string{"ethereum", "eur"}); err == nil {
+		t.Error("Must fail on missing field")
+	}
+}
+
+func TestFiatToShannon(t *testing.T) {
+	// 10 USD at 2000 USD per coin is 0.005 coin
+	if shannon := fiatToShannon(big.NewRat(10, 1), big.NewRat(2000, 1)); shannon != 5000000 {
+		t.Errorf("Must convert to Shannon, got %v", shannon)
+	}
+	// Rounds down
+	if shannon := fiatToShannon(big.NewRat(1, 1), big.NewRat(3, 1)); shannon != 333333333 {
+		t.Errorf("Must round down, got %v", shannon)
+	}
+}
+
+func TestMedianRate(t *testing.T) {
+	rates := []*big.Rat{big.NewRat(3, 1), big.NewRat(1, 1), big.NewRat(2, 1)}
+	if median := medianRate(rates); median.Cmp(big.NewRat(2, 1)) != 0 {
+		t.Errorf("Must pick the middle rate, got %v", median)
+	}
+	rates = append(rates, big.NewRat(10, 1))
+	if median := medianRate(rates); median.Cmp(big.NewRat(5, 2)) != 0 {
+		t.Errorf("Must average the middle rates, got %v", median)
+	}
+	if rates[0].Cmp(big.NewRat(3, 1)) != 0 {
+		t.Error("Must not reorder the rates")
+	}
+}
+
+func TestWithinSpread(t *testing.T) {
+	rates := []*big.Rat{big.NewRat(100, 1), big.NewRat(102, 1), big.NewRat(150, 1)}
+	if kept := withinSpread(rates, 5); len(kept) != 2 {
+		t.Errorf("Must leave out the outlier, got %v", kept)
+	}
+	if kept := withinSpread(rates, 0); len(kept) != 3 {
+		t.Errorf("Must keep all rates without a spread, got %v", kept)
+	}
+}
+
+func TestPriceQuorum(t *testing.T) {
+	var down bool
+	price := func(value string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if down {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(w, `{"price":%v}`, value)
+		}))
+	}
+	a, b, c := price("10"), price("11"), price("30")
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	cfg := &PriceFeedConfig{
+		Enabled: true,
+		Sources: []PriceSourceConfig{
+			{Provider: PriceProviderJson, Url: a.URL, Field: "price"},
+			{Provider: PriceProviderJson, Url: b.URL, Field: "price"},
+			{Provider: PriceProviderJson, Url: c.URL, Field: "price"},
+		},
+		Quorum:   2,
+		CacheTtl: "1ns",
+	}
+	feed, err := NewPriceFeed(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote, err := feed.Quote()
+	if err != nil || quote.Rate.Cmp(big.NewRat(11, 1)) != 0 || quote.Sources != 3 || quote.Stale {
+		t.Fatalf("Must take the median of the providers, got %+v %v", quote, err)
+	}
+
+	// Too few providers answer: the last rate, flagged stale
+	down = true
+	quote, err = feed.Quote()
+	if err != nil || quote.Rate.Cmp(big.NewRat(11, 1)) != 0 || !quote.Stale {
+		t.Fatalf("Must fall back to the last rate, got %+v %v", quote, err)
+	}
+
+	// Until it is maxAge old
+	feed.maxAge = time.Nanosecond
+	if _, err = feed.Quote(); err == nil {
+		t.Error("Must fail once the last rate is too old")
+	}
+
+	cfg.Quorum = 4
+	if _, err := NewPriceFeed(cfg); err == nil {
+		t.Error("Must refuse a quorum above the provider count")
+	}
+}
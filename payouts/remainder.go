@@ -0,0 +1,52 @@
+package payouts
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// RemainderLargest credits the round-off dust to the largest shareholder of each round.
+const RemainderLargest = "largest"
+
+func validateRemainderTo(value string) error {
+	if len(value) == 0 || value == RemainderLargest || util.IsValidHexAddress(value) {
+		return nil
+	}
+	return fmt.Errorf("invalid remainderTo %v, an address or %q", value, RemainderLargest)
+}
+
+// creditRemainder credits the round-off dust of r and the one carried from the previous blocks to
+// remainderTo, in whole Shannon. What's left under a Shannon is carried to the next block.
+func (u *BlockUnlocker) creditRemainder(r *blockRewards, shares map[string]int64) error {
+	carry, err := u.db.GetRewardRemainder()
+	if err != nil {
+		return err
+	}
+	dust := new(big.Int).Add(carry, r.dust)
+	amount, left := new(big.Int).QuoRem(dust, util.Shannon, new(big.Int))
+	r.remainder = left
+	if amount.Sign() <= 0 {
+		return nil
+	}
+	login := strings.ToLower(u.config.RemainderTo)
+	if login == RemainderLargest {
+		login = largestShareholder(shares)
+	}
+	r.credit(login, mysql.ReasonRemainder, amount.Int64(), new(big.Int).Mul(amount, util.Shannon))
+	return nil
+}
+
+// largestShareholder is the login with the most shares, the first in order among equals.
+func largestShareholder(shares map[string]int64) string {
+	largest := ""
+	for login, n := range shares {
+		if len(largest) == 0 || n > shares[largest] || n == shares[largest] && login < largest {
+			largest = login
+		}
+	}
+	return largest
+}
@@ -0,0 +1,244 @@
+package payouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type PayoutReportConfig struct {
+	Enabled bool `json:"enabled"`
+	// Wait for an operator to approve the report through the API instead of paying right away
+	RequireApproval bool `json:"requireApproval"`
+	// Reports not executed by then expire and a new one is made on the next run
+	ApprovalTimeout string `json:"approvalTimeout"`
+}
+
+type reportRecipient struct {
+	Login string `json:"login"`
+	// Debited balance, fee charged to the miner and amount sent, in Shannon
+	Balance int64 `json:"balance"`
+	Fee     int64 `json:"fee"`
+	Amount  int64 `json:"amount"`
+}
+
+// payoutReport is the dry run of a payout, amounts in Shannon.
+type payoutReport struct {
+	Recipients []*reportRecipient `json:"recipients"`
+	Amount     int64              `json:"amount"`
+	// Estimated gas paid by the pool wallet
+	TxFee       int64  `json:"txFee"`
+	PoolBalance int64  `json:"poolBalance"`
+	PostBalance int64  `json:"postBalance"`
+	Threshold   int64  `json:"threshold"`
+	Rate        string `json:"rate,omitempty"`
+	Currency    string `json:"currency,omitempty"`
+}
+
+func (r *payoutReport) record(state string) (*mysql.PayoutReport, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return &mysql.PayoutReport{
+		State:       state,
+		Recipients:  len(r.Recipients),
+		Amount:      r.Amount,
+		TxFee:       r.TxFee,
+		PoolBalance: r.PoolBalance,
+		PostBalance: r.PostBalance,
+		Report:      string(data),
+		Timestamp:   util.MakeTimestamp() / 1000,
+	}, nil
+}
+
+// reviewPayout reports the run before paying and returns the payees that may be paid now.
+// Nothing is paid while a report waits for approval.
+func (u *PayoutsProcessor) reviewPayout(payees []*mysql.Payees) []*mysql.Payees {
+	if u.config.Report.RequireApproval {
+		open, err := u.db.GetOpenPayoutReport()
+		if err != nil {
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, "", "",
+				"Failed to load open payout report: %v", err)
+			return nil
+		}
+		if open != nil {
+			if time.Since(time.Unix(open.Timestamp, 0)) > u.approvalTimeout {
+				u.db.UpdatePayoutReportState(open.Id, open.State, mysql.ReportExpired, "")
+				log.Warnf("Payout report #%v expired while %v", open.Id, open.State)
+			} else if open.State == mysql.ReportPending {
+				log.Infof("Payout report #%v is waiting for approval", open.Id)
+				return nil
+			} else {
+				return u.executeReport(open, payees)
+			}
+		}
+	}
+
+	report, err := u.buildReport(payees)
+	if err != nil {
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, u.config.Address, "",
+			"Failed to build payout report: %v", err)
+		return nil
+	}
+	if len(report.Recipients) == 0 {
+		return nil
+	}
+
+	state := mysql.ReportAuto
+	if u.config.Report.RequireApproval {
+		state = mysql.ReportPending
+	}
+	record, err := report.record(state)
+	if err != nil {
+		log.Error("Failed to encode payout report:", err)
+		return nil
+	}
+	id, err := u.db.WritePayoutReport(record)
+	if err != nil {
+		// No payout without its audit record.
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, u.config.Address, "",
+			"Failed to store payout report: %v", err)
+		return nil
+	}
+	log.Infof("Payout report #%v: %v recipients, %v Shannon, fees %v Shannon, pool balance %v -> %v Shannon",
+		id, len(report.Recipients), report.Amount, report.TxFee, report.PoolBalance, report.PostBalance)
+
+	if u.config.Report.RequireApproval {
+		u.logs.InsertLog(fmt.Sprintf("PAYOUT REPORT #%v awaits approval", id), plogger.LogTypePaymentWork, plogger.LogErrorNothing, 0, 0, "", "")
+		return nil
+	}
+	return payees
+}
+
+// executeReport pays an approved report, at most the approved amount to each of its recipients.
+func (u *PayoutsProcessor) executeReport(open *mysql.PayoutReport, payees []*mysql.Payees) []*mysql.Payees {
+	var report payoutReport
+	err := json.Unmarshal([]byte(open.Report), &report)
+	if err != nil {
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, "", "",
+			"Invalid payout report #%v: %v", open.Id, err)
+		return nil
+	}
+	ok, err := u.db.UpdatePayoutReportState(open.Id, mysql.ReportApproved, mysql.ReportExecuted, "")
+	if err != nil || !ok {
+		log.Warnf("Payout report #%v is no longer approved: %v", open.Id, err)
+		return nil
+	}
+	log.Infof("Executing payout report #%v approved by %v", open.Id, open.ApprovedBy)
+	u.logs.InsertLog(fmt.Sprintf("PAYOUT REPORT #%v executed, approved by %v", open.Id, open.ApprovedBy), plogger.LogTypePaymentWork, plogger.LogErrorNothing, 0, 0, "", "")
+	return approvedPayees(&report, payees)
+}
+
+// approvedPayees keeps the payees listed in the report and caps their balance at the reported one.
+func approvedPayees(report *payoutReport, payees []*mysql.Payees) []*mysql.Payees {
+	approved := make(map[string]int64, len(report.Recipients))
+	for _, r := range report.Recipients {
+		approved[r.Login] = r.Balance
+	}
+	var result []*mysql.Payees
+	for _, payee := range payees {
+		balance, ok := approved[payee.Addr]
+		if !ok {
+			continue
+		}
+		if payee.Balance > balance {
+			payee.Balance = balance
+		}
+		result = append(result, payee)
+	}
+	return result
+}
+
+// buildReport estimates the run the same way processPayees and processBatches will make it.
+func (u *PayoutsProcessor) buildReport(payees []*mysql.Payees) (*payoutReport, error) {
+	report := &payoutReport{Threshold: u.GetReachedThreshold().Int64(), Rate: u.rate}
+	if len(u.rate) > 0 {
+		report.Currency = u.config.PriceFeed.Currency
+	}
+
+	var groups [][]*mysql.Payees
+	for _, payee := range payees {
+		if !u.payeeReachedThreshold(payee) {
+			continue
+		}
+		n := len(groups)
+		if u.batched() && n > 0 && len(groups[n-1]) < u.multisend.config.MaxRecipients {
+			groups[n-1] = append(groups[n-1], payee)
+		} else {
+			groups = append(groups, []*mysql.Payees{payee})
+		}
+	}
+
+	for _, group := range groups {
+		txFee, err := u.estimateFee(group)
+		if err != nil {
+			return nil, err
+		}
+		var charged int64
+		if !u.config.AutoGas {
+			charged = txFee / int64(len(group))
+		}
+		for _, payee := range group {
+			amount := payee.Balance - charged
+			if amount <= 0 {
+				continue
+			}
+			report.Recipients = append(report.Recipients, &reportRecipient{
+				Login:   payee.Addr,
+				Balance: payee.Balance,
+				Fee:     charged,
+				Amount:  amount,
+			})
+			report.Amount += amount
+		}
+		report.TxFee += txFee
+	}
+
+	poolBalance, err := u.rpc.GetBalance(u.config.Address)
+	if err != nil {
+		return nil, err
+	}
+	report.PoolBalance = new(big.Int).Div(poolBalance, util.Shannon).Int64()
+	report.PostBalance = report.PoolBalance - report.Amount - report.TxFee
+	return report, nil
+}
+
+// estimateFee quotes the gas of one payout transaction, in Shannon.
+func (u *PayoutsProcessor) estimateFee(group []*mysql.Payees) (int64, error) {
+	logins := make([]string, len(group))
+	amounts := make([]*big.Int, len(group))
+	value := big.NewInt(0)
+	for i, payee := range group {
+		logins[i] = payee.Addr
+		amounts[i] = new(big.Int).Mul(big.NewInt(payee.Balance), util.Shannon)
+		value.Add(value, amounts[i])
+	}
+
+	var (
+		quote *gasQuote
+		err   error
+	)
+	if u.batched() {
+		var data []byte
+		data, err = u.multisend.pack(logins, amounts)
+		if err != nil {
+			return 0, err
+		}
+		quote, err = u.quoteGasLimit(u.multisend.config.Address, value, data, u.multisend.gasLimit(len(group)))
+	} else if !u.config.AutoGas || u.signer != nil {
+		quote, err = u.quoteGas(logins[0], value)
+	} else {
+		// The node picks the gas, the configured one is the best guess.
+		return u.config.GasFeeInShannon(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return quote.FeeInShannon(), nil
+}
@@ -0,0 +1,30 @@
+package payouts
+
+import (
+	"testing"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+)
+
+func TestApprovedPayees(t *testing.T) {
+	report := &payoutReport{Recipients: []*reportRecipient{
+		{Login: "0x1", Balance: 5000000},
+		{Login: "0x2", Balance: 7000000},
+	}}
+	payees := []*mysql.Payees{
+		{Addr: "0x1", Balance: 6000000},
+		{Addr: "0x2", Balance: 4000000},
+		{Addr: "0x3", Balance: 9000000},
+	}
+
+	result := approvedPayees(report, payees)
+	if len(result) != 2 {
+		t.Fatalf("Must keep only reported payees, got %v", len(result))
+	}
+	if result[0].Balance != 5000000 {
+		t.Errorf("Must cap balance at the approved one, got %v", result[0].Balance)
+	}
+	if result[1].Balance != 4000000 {
+		t.Errorf("Must not raise balance to the approved one, got %v", result[1].Balance)
+	}
+}
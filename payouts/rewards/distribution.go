@@ -0,0 +1,54 @@
+package rewards
+
+import (
+	"sort"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+)
+
+// Upper bounds of the histogram buckets but the last, as fractions of the round shares
+var histogramBounds = []float64{0.001, 0.01, 0.1}
+
+// Distribute summarizes the shares of a round: the miner count, the Gini coefficient, the fraction of the
+// 10 largest miners and a histogram of the miners by fraction. It returns nil without shares.
+func Distribute(shares map[string]int64) *types.RoundDistribution {
+	values := make([]int64, 0, len(shares))
+	total := int64(0)
+	for _, n := range shares {
+		if n <= 0 {
+			continue
+		}
+		values = append(values, n)
+		total += n
+	}
+	if total == 0 {
+		return nil
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	d := &types.RoundDistribution{TotalShares: total, Miners: len(values)}
+	n := float64(len(values))
+	weighted, top := 0.0, int64(0)
+	for i, v := range values {
+		weighted += float64(i+1) * float64(v)
+		if i >= len(values)-10 {
+			top += v
+		}
+		fraction := float64(v) / float64(total)
+		bucket := len(histogramBounds)
+		for b, bound := range histogramBounds {
+			if fraction < bound {
+				bucket = b
+				break
+			}
+		}
+		d.Histogram[bucket]++
+	}
+	// With the shares sorted ascending, G = 2*sum(i*x_i)/(n*sum(x)) - (n+1)/n
+	d.Gini = 2*weighted/(n*float64(total)) - (n+1)/n
+	if d.Gini < 0 {
+		d.Gini = 0
+	}
+	d.Top10Share = float64(top) / float64(total)
+	return d
+}
@@ -0,0 +1,52 @@
+// Package rewards splits block rewards between the pool and miners.
+// Values are wei as *big.Rat and results are integers in a caller supplied unit,
+// so the same code works for any coin denomination.
+package rewards
+
+import (
+	"math/big"
+	"strconv"
+)
+
+// ChargeFee returns new value after fee deduction and fee value. fee is a percentage.
+func ChargeFee(value *big.Rat, fee float64) (*big.Rat, *big.Rat) {
+	feePercent := new(big.Rat).SetFloat64(fee / 100)
+	feeValue := new(big.Rat).Mul(value, feePercent)
+	return new(big.Rat).Sub(value, feeValue), feeValue
+}
+
+// ForShares splits reward proportionally to shares. Rewards are rounded to unit, percents are exact.
+func ForShares(shares map[string]int64, total int64, reward *big.Rat, unit *big.Int) (map[string]int64, map[string]*big.Rat) {
+	rewards := make(map[string]int64)
+	percents := make(map[string]*big.Rat)
+
+	for login, n := range shares {
+		percents[login] = big.NewRat(n, total)
+		workerReward := new(big.Rat).Mul(reward, percents[login])
+		rewards[login] += ToUnit(workerReward, unit)
+	}
+	return rewards, percents
+}
+
+// ToUnit converts wei into unit, rounded to the nearest integer.
+func ToUnit(wei *big.Rat, unit *big.Int) int64 {
+	inUnit := new(big.Rat).Quo(wei, new(big.Rat).SetInt(unit))
+	value, _ := strconv.ParseInt(inUnit.FloatString(0), 10, 64)
+	return value
+}
+
+// Split divides value by percents summing to 100. The last part is what's left of value, so the
+// parts sum to it exactly.
+func Split(value *big.Rat, percents []float64) []*big.Rat {
+	parts := make([]*big.Rat, len(percents))
+	left := new(big.Rat).Set(value)
+	for i, percent := range percents {
+		if i == len(percents)-1 {
+			parts[i] = left
+			break
+		}
+		parts[i] = new(big.Rat).Mul(value, new(big.Rat).SetFloat64(percent/100))
+		left.Sub(left, parts[i])
+	}
+	return parts
+}
@@ -0,0 +1,178 @@
+package rewards
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+var shannon = big.NewInt(1000000000)
+
+func randomShares(r *rand.Rand) (map[string]int64, int64) {
+	shares := make(map[string]int64)
+	total := int64(0)
+	miners := 1 + r.Intn(50)
+	for i := 0; i < miners; i++ {
+		n := 1 + r.Int63n(1000000)
+		shares[big.NewInt(int64(i)).String()] = n
+		total += n
+	}
+	return shares, total
+}
+
+func randomReward(r *rand.Rand) *big.Rat {
+	// Up to ~9.2 coins in wei, sums stay within int64 Shannon.
+	return new(big.Rat).SetInt(new(big.Int).Mul(big.NewInt(r.Int63n(9200000000)), shannon))
+}
+
+func TestForSharesSumsToReward(t *testing.T) {
+	property := func(seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		shares, total := randomShares(r)
+		reward := randomReward(r)
+
+		rewards, percents := ForShares(shares, total, reward, shannon)
+		sum := int64(0)
+		for _, amount := range rewards {
+			sum += amount
+		}
+		// Every miner is rounded to the nearest Shannon, so the sum is off by at most half a Shannon each.
+		diff := sum - ToUnit(reward, shannon)
+		if diff < 0 {
+			diff = -diff
+		}
+		if 2*diff > int64(len(shares)) {
+			t.Logf("sum %v of %v miners is too far from %v", sum, len(shares), reward.FloatString(0))
+			return false
+		}
+
+		totalPercent := new(big.Rat)
+		for _, percent := range percents {
+			totalPercent.Add(totalPercent, percent)
+		}
+		return totalPercent.Cmp(big.NewRat(1, 1)) == 0
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestForSharesMonotonic(t *testing.T) {
+	property := func(seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		shares, total := randomShares(r)
+		reward := randomReward(r)
+		before, _ := ForShares(shares, total, reward, shannon)
+
+		login := "0"
+		extra := 1 + r.Int63n(1000000)
+		shares[login] += extra
+		after, _ := ForShares(shares, total+extra, reward, shannon)
+
+		// More shares never pay less, and never pay others more.
+		if after[login] < before[login] {
+			return false
+		}
+		for other, amount := range after {
+			if other != login && amount > before[other] {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChargeFeeBounds(t *testing.T) {
+	property := func(seed int64, fee uint8) bool {
+		r := rand.New(rand.NewSource(seed))
+		value := randomReward(r)
+		orig := new(big.Rat).Set(value)
+		percent := float64(fee%101) + r.Float64()
+		if percent > 100 {
+			percent = 100
+		}
+
+		newValue, feeValue := ChargeFee(value, percent)
+		if value.Cmp(orig) != 0 {
+			return false
+		}
+		if feeValue.Sign() < 0 || feeValue.Cmp(value) > 0 || newValue.Sign() < 0 {
+			return false
+		}
+		return new(big.Rat).Add(newValue, feeValue).Cmp(value) == 0
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSplitSumsToValue(t *testing.T) {
+	value, _ := new(big.Rat).SetString("1000000000000000001")
+	parts := Split(value, []float64{33.3, 33.3, 33.4})
+	total := new(big.Rat)
+	for _, part := range parts {
+		total.Add(total, part)
+	}
+	if total.Cmp(value) != 0 {
+		t.Errorf("Parts must sum to %v, got %v", value.FloatString(0), total.FloatString(0))
+	}
+	if amount := ToUnit(parts[0], shannon); amount != 333000000 {
+		t.Errorf("First part must be 33.3%%, got %v", amount)
+	}
+	if parts := Split(value, []float64{100}); parts[0].Cmp(value) != 0 {
+		t.Error("A single part must be the whole value")
+	}
+}
+
+func TestToUnitRounds(t *testing.T) {
+	cases := map[string]int64{
+		"1000000000000000000": 1000000000,
+		"1499999999":          1,
+		"1500000000":          2,
+		"0":                   0,
+	}
+	for wei, expected := range cases {
+		value, _ := new(big.Rat).SetString(wei)
+		if amount := ToUnit(value, shannon); amount != expected {
+			t.Errorf("%v wei must be %v Shannon, got %v", wei, expected, amount)
+		}
+	}
+	// The unit is up to the caller.
+	value, _ := new(big.Rat).SetString("1000000000000000000")
+	if amount := ToUnit(value, big.NewInt(1000000)); amount != 1000000000000 {
+		t.Errorf("Must convert to the given unit, got %v", amount)
+	}
+}
+
+func TestDistribute(t *testing.T) {
+	if d := Distribute(map[string]int64{}); d != nil {
+		t.Errorf("expected nil without shares, got %+v", d)
+	}
+
+	even := make(map[string]int64)
+	for i := 0; i < 20; i++ {
+		even[big.NewInt(int64(i)).String()] = 100
+	}
+	d := Distribute(even)
+	if d.Miners != 20 || d.TotalShares != 2000 || math.Abs(d.Gini) > 1e-9 || math.Abs(d.Top10Share-0.5) > 1e-9 {
+		t.Errorf("unexpected distribution of even shares: %+v", d)
+	}
+	if d.Histogram != [4]int{0, 0, 20, 0} {
+		t.Errorf("unexpected histogram of even shares: %v", d.Histogram)
+	}
+
+	// One miner with almost everything
+	skewed := map[string]int64{"a": 1000000, "b": 1, "c": 1, "d": 1}
+	d = Distribute(skewed)
+	if d.Gini < 0.74 || d.Gini > 0.75 || d.Top10Share != 1 {
+		t.Errorf("unexpected distribution of skewed shares: %+v", d)
+	}
+	if d.Histogram != [4]int{3, 0, 0, 1} {
+		t.Errorf("unexpected histogram of skewed shares: %v", d.Histogram)
+	}
+}
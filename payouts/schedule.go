@@ -0,0 +1,153 @@
+package payouts
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// payoutWindow is a daily UTC time range, optionally limited to some weekdays.
+// The range may wrap past midnight, the weekday is the one the window starts on.
+type payoutWindow struct {
+	days  map[time.Weekday]bool
+	start int
+	end   int
+}
+
+// parsePayoutWindow reads "02:00-04:00" or "Sat,Sun 00:00-23:59".
+func parsePayoutWindow(spec string) (*payoutWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid payout window %q", spec)
+	}
+	w := &payoutWindow{}
+	if len(fields) == 2 {
+		w.days = make(map[time.Weekday]bool)
+		for _, day := range strings.Split(fields[0], ",") {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("invalid weekday %q in payout window %q", day, spec)
+			}
+			w.days[weekday] = true
+		}
+	}
+	bounds := strings.Split(fields[len(fields)-1], "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid payout window %q", spec)
+	}
+	var err error
+	if w.start, err = parseClock(bounds[0]); err != nil {
+		return nil, fmt.Errorf("invalid payout window %q: %v", spec, err)
+	}
+	if w.end, err = parseClock(bounds[1]); err != nil {
+		return nil, fmt.Errorf("invalid payout window %q: %v", spec, err)
+	}
+	return w, nil
+}
+
+// parseClock returns minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w *payoutWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end && w.onDay(day)
+	}
+	// Wraps past midnight: the late part belongs to the previous day.
+	if minute >= w.start {
+		return w.onDay(day)
+	}
+	return minute < w.end && w.onDay((day+6)%7)
+}
+
+func (w *payoutWindow) onDay(day time.Weekday) bool {
+	return w.days == nil || w.days[day]
+}
+
+func parsePayoutWindows(specs []string) ([]*payoutWindow, error) {
+	var windows []*payoutWindow
+	for _, spec := range specs {
+		w, err := parsePayoutWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// inPayoutWindow reports whether scheduled payouts may run at t, always true without windows.
+func (u *PayoutsProcessor) inPayoutWindow(t time.Time) bool {
+	return inPayoutWindows(u.windows, t)
+}
+
+func inPayoutWindows(windows []*payoutWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// PayoutSchedule tells when scheduled payouts run, for estimates made outside the payer.
+type PayoutSchedule struct {
+	interval time.Duration
+	windows  []*payoutWindow
+}
+
+func NewPayoutSchedule(interval string, windows []string) (*PayoutSchedule, error) {
+	intv, err := time.ParseDuration(interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payout interval %q: %v", interval, err)
+	}
+	if intv <= 0 {
+		return nil, fmt.Errorf("invalid payout interval %q", interval)
+	}
+	w, err := parsePayoutWindows(windows)
+	if err != nil {
+		return nil, err
+	}
+	return &PayoutSchedule{interval: intv, windows: w}, nil
+}
+
+// Next is the first run at or after t of a payer that last ran at last, or an interval after t
+// when that is unknown. Runs outside the windows pay nothing and are skipped, zero when none
+// falls in them within a week.
+func (s *PayoutSchedule) Next(last, t time.Time) time.Time {
+	if last.IsZero() || last.After(t) {
+		last = t
+	}
+	next := last.Add(s.interval)
+	if next.Before(t) {
+		next = next.Add((t.Sub(next) + s.interval - 1) / s.interval * s.interval)
+	}
+	for limit := next.Add(7 * 24 * time.Hour); !next.After(limit); next = next.Add(s.interval) {
+		if inPayoutWindows(s.windows, next) {
+			return next
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,93 @@
+package payouts
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPayoutWindow(t *testing.T) {
+	// 2021-06-05 is a Saturday
+	at := func(day int, clock string) time.Time {
+		ts, _ := time.Parse("2006-01-02 15:04", fmt.Sprintf("2021-06-%02d %v", day, clock))
+		return ts
+	}
+
+	cases := []struct {
+		spec     string
+		t        time.Time
+		expected bool
+	}{
+		{"02:00-04:00", at(5, "02:00"), true},
+		{"02:00-04:00", at(5, "03:59"), true},
+		{"02:00-04:00", at(5, "04:00"), false},
+		{"02:00-04:00", at(5, "01:59"), false},
+		{"22:00-02:00", at(5, "23:00"), true},
+		{"22:00-02:00", at(6, "01:00"), true},
+		{"22:00-02:00", at(6, "12:00"), false},
+		{"Sat,Sun 00:00-23:59", at(5, "12:00"), true},
+		{"Sat,Sun 00:00-23:59", at(7, "12:00"), false},
+		{"Sat 23:00-01:00", at(6, "00:30"), true},
+		{"Sat 23:00-01:00", at(7, "00:30"), false},
+	}
+	for _, c := range cases {
+		w, err := parsePayoutWindow(c.spec)
+		if err != nil {
+			t.Fatalf("Must parse %q: %v", c.spec, err)
+		}
+		if w.contains(c.t) != c.expected {
+			t.Errorf("%q at %v must be %v", c.spec, c.t, c.expected)
+		}
+	}
+
+	for _, spec := range []string{"", "2:00", "25:00-26:00", "Funday 01:00-02:00", "a b c"} {
+		if _, err := parsePayoutWindow(spec); err == nil {
+			t.Errorf("Must reject %q", spec)
+		}
+	}
+}
+
+func TestPayoutScheduleNext(t *testing.T) {
+	at := func(day int, clock string) time.Time {
+		ts, _ := time.Parse("2006-01-02 15:04", fmt.Sprintf("2021-06-%02d %v", day, clock))
+		return ts
+	}
+
+	s, err := NewPayoutSchedule("2h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		last, t, expected time.Time
+	}{
+		{at(5, "01:00"), at(5, "01:30"), at(5, "03:00")},
+		{at(5, "01:00"), at(5, "03:00"), at(5, "03:00")},
+		{at(5, "01:00"), at(5, "06:10"), at(5, "07:00")},
+		// Never ran
+		{time.Time{}, at(5, "06:10"), at(5, "08:10")},
+	}
+	for _, c := range cases {
+		if next := s.Next(c.last, c.t); !next.Equal(c.expected) {
+			t.Errorf("Last %v at %v: expected %v got %v", c.last, c.t, c.expected, next)
+		}
+	}
+
+	s, err = NewPayoutSchedule("2h", []string{"Sun 04:00-05:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(at(5, "00:30"), at(5, "01:00")); !next.Equal(at(6, "04:30")) {
+		t.Errorf("Expected the run in the window, got %v", next)
+	}
+	// The window is shorter than the interval and runs miss it
+	s, _ = NewPayoutSchedule("2h", []string{"Sun 03:00-04:00"})
+	if next := s.Next(at(5, "00:30"), at(5, "01:00")); !next.IsZero() {
+		t.Errorf("Expected no run, got %v", next)
+	}
+
+	for _, intv := range []string{"", "0s", "abc"} {
+		if _, err := NewPayoutSchedule(intv, nil); err == nil {
+			t.Errorf("Must reject interval %q", intv)
+		}
+	}
+}
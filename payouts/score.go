@@ -0,0 +1,35 @@
+package payouts
+
+import (
+	"fmt"
+	"time"
+)
+
+// ShareWeightingScore credits rounds by score: every share weighted by exp(age / scoreDecay), its
+// age since the start of the round, so the late shares of a round weigh more and hopping to the
+// pool late in a round doesn't pay.
+const ShareWeightingScore = "score"
+
+// Decay of the shares scored by default, a share 10 minutes older weighs e times less.
+const defaultScoreDecay = 10 * time.Minute
+
+// ShareScoreDecay is the decay the proxy scores shares with, 0 unless rounds are credited by
+// score. Shares are scored as they are written, so the proxies of a pool read it from the
+// unlocker config.
+func (c *UnlockerConfig) ShareScoreDecay() (time.Duration, error) {
+	switch c.ShareWeighting {
+	case "":
+		return 0, nil
+	case ShareWeightingScore:
+	default:
+		return 0, fmt.Errorf("invalid shareWeighting %v, must be %v or empty", c.ShareWeighting, ShareWeightingScore)
+	}
+	if len(c.ScoreDecay) == 0 {
+		return defaultScoreDecay, nil
+	}
+	decay, err := time.ParseDuration(c.ScoreDecay)
+	if err != nil || decay < time.Second {
+		return 0, fmt.Errorf("invalid scoreDecay %v", c.ScoreDecay)
+	}
+	return decay, nil
+}
@@ -0,0 +1,133 @@
+package payouts
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	SignerNode       = "node"
+	SignerKeystore   = "keystore"
+	SignerPrivateKey = "privateKey"
+)
+
+const dynamicFeeTxType = 0x02
+
+// txSigner signs payout transactions locally, so the pool wallet never has to be unlocked on the node.
+// Transactions are RLP encoded by hand: EIP-155 for legacy and EIP-2718/1559 for dynamic fee transactions.
+type txSigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+	chainId *big.Int
+}
+
+func (self PayoutsConfig) SignerName() string {
+	if len(self.Signer) == 0 {
+		return SignerNode
+	}
+	return self.Signer
+}
+
+// newTxSigner loads the payout key, returns nil for node signing.
+func newTxSigner(cfg *PayoutsConfig, chainId *big.Int) (*txSigner, error) {
+	var key *ecdsa.PrivateKey
+
+	switch cfg.SignerName() {
+	case SignerNode:
+		return nil, nil
+	case SignerKeystore:
+		keyJson, err := ioutil.ReadFile(cfg.KeystoreFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keystore file: %v", err)
+		}
+		password := cfg.KeystorePassword
+		if env, present := os.LookupEnv("PAYOUT_KEYSTORE_PASSWORD"); present {
+			password = env
+		}
+		decrypted, err := decryptKeystore(keyJson, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt keystore file: %v", err)
+		}
+		key = decrypted
+	case SignerPrivateKey:
+		hexKey := cfg.PrivateKey
+		if env, present := os.LookupEnv("PAYOUT_PRIVATE_KEY"); present {
+			hexKey = env
+		}
+		parsed, err := crypto.HexToECDSA(strings.TrimPrefix(hexKey, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %v", err)
+		}
+		key = parsed
+	default:
+		return nil, fmt.Errorf("unknown signer %v", cfg.Signer)
+	}
+
+	signer := &txSigner{
+		key:     key,
+		address: crypto.PubkeyToAddress(key.PublicKey),
+		chainId: chainId,
+	}
+	if !strings.EqualFold(signer.address.Hex(), cfg.Address) {
+		return nil, fmt.Errorf("signing key address %v doesn't match payout address %v", signer.address.Hex(), cfg.Address)
+	}
+	return signer, nil
+}
+
+// signLegacy returns a raw EIP-155 transaction.
+func (s *txSigner) signLegacy(nonce uint64, to string, value, gas, gasPrice *big.Int, data []byte) ([]byte, error) {
+	toAddr := common.HexToAddress(to)
+	sigHash, err := rlpHash(nil, []interface{}{nonce, gasPrice, gas, toAddr, value, data, s.chainId, uint(0), uint(0)})
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.Sign(sigHash, s.key)
+	if err != nil {
+		return nil, err
+	}
+	r, ss, recId := splitSignature(sig)
+	v := new(big.Int).Mul(s.chainId, big.NewInt(2))
+	v.Add(v, big.NewInt(int64(recId)+35))
+	return rlp.EncodeToBytes([]interface{}{nonce, gasPrice, gas, toAddr, value, data, v, r, ss})
+}
+
+// signDynamicFee returns a raw EIP-1559 (type 0x02) transaction.
+func (s *txSigner) signDynamicFee(nonce uint64, to string, value, gas, maxFee, maxPriorityFee *big.Int, data []byte) ([]byte, error) {
+	toAddr := common.HexToAddress(to)
+	fields := []interface{}{s.chainId, nonce, maxPriorityFee, maxFee, gas, toAddr, value, data, []interface{}{}}
+	sigHash, err := rlpHash([]byte{dynamicFeeTxType}, fields)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.Sign(sigHash, s.key)
+	if err != nil {
+		return nil, err
+	}
+	r, ss, recId := splitSignature(sig)
+	payload, err := rlp.EncodeToBytes(append(fields, uint(recId), r, ss))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{dynamicFeeTxType}, payload...), nil
+}
+
+func rlpHash(prefix []byte, fields []interface{}) ([]byte, error) {
+	payload, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256(append(prefix, payload...)), nil
+}
+
+// Signature is [R || S || V] with V as the 0/1 recovery id.
+func splitSignature(sig []byte) (*big.Int, *big.Int, byte) {
+	return new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64]), sig[64]
+}
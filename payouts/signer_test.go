@@ -0,0 +1,38 @@
+package payouts
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// https://github.com/ethereum/EIPs/blob/master/EIPS/eip-155.md
+func TestSignLegacyEIP155(t *testing.T) {
+	key, _ := crypto.HexToECDSA("4646464646464646464646464646464646464646464646464646464646464646")
+	signer := &txSigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey), chainId: big.NewInt(1)}
+	value, _ := new(big.Int).SetString("1000000000000000000", 10)
+
+	rawTx, err := signer.signLegacy(9, "0x3535353535353535353535353535353535353535", value, big.NewInt(21000), big.NewInt(20000000000), []byte{})
+	if err != nil {
+		t.Fatalf("Must sign transaction: %v", err)
+	}
+	expected := "0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+	if hexutil.Encode(rawTx) != expected {
+		t.Errorf("Incorrect raw transaction, expected %v vs %v", expected, hexutil.Encode(rawTx))
+	}
+}
+
+func TestSignDynamicFee(t *testing.T) {
+	key, _ := crypto.HexToECDSA("4646464646464646464646464646464646464646464646464646464646464646")
+	signer := &txSigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey), chainId: big.NewInt(1)}
+
+	rawTx, err := signer.signDynamicFee(0, "0x3535353535353535353535353535353535353535", big.NewInt(1), big.NewInt(21000), big.NewInt(2000000000), big.NewInt(1000000000), nil)
+	if err != nil {
+		t.Fatalf("Must sign transaction: %v", err)
+	}
+	if rawTx[0] != dynamicFeeTxType {
+		t.Errorf("Must be typed transaction 0x02, got %#x", rawTx[0])
+	}
+}
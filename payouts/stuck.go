@@ -0,0 +1,30 @@
+package payouts
+
+import (
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+)
+
+// checkStuckBlocks alerts about the blocks still unresolved maxCandidateAge after they were found,
+// pointing to a matching bug or a halt. Each is alerted again every alert cooldown until resolved.
+func (u *BlockUnlocker) checkStuckBlocks() {
+	if u.maxCandidateAge == 0 {
+		return
+	}
+	now := time.Now()
+	blocks, err := u.db.GetStuckBlocks(now.Add(-u.maxCandidateAge).Unix())
+	if err != nil {
+		log.Errorf("Failed to look for stuck blocks: %v", err)
+		return
+	}
+	metrics.UnlockerStuck.Set(float64(len(blocks)), u.config.Name)
+	for _, block := range blocks {
+		age := now.Sub(time.Unix(block.Timestamp, 0)).Truncate(time.Minute)
+		log.Warnf("Block %v is still %v after %v", block.RoundKey(), mysql.BlockStateName(block.State), age)
+		u.alerts.Fire(alerts.EventBlockStuck, block.RoundKey(), "Block %v is still %v %v after it was found, nonce %v",
+			block.RoundHeight, mysql.BlockStateName(block.State), age, block.Nonce)
+	}
+}
@@ -0,0 +1,104 @@
+package payouts
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+)
+
+// nodeReady tells whether the daemon is in sync and has minPeers peers. A node behind the chain
+// doesn't know the blocks after its head, and their candidates would be orphaned. The pass is
+// skipped without halting the unlocker.
+func (u *BlockUnlocker) nodeReady(pass string) (ready bool) {
+	defer func() {
+		if !ready {
+			u.skipped = true
+		}
+	}()
+	status, err := u.rpc.GetSyncing()
+	if err != nil {
+		log.Warnf("Skipped %v, failed to check whether the node is syncing: %v", pass, err)
+		metrics.UnlockerSkipped.Inc(u.config.Name, "error")
+		return false
+	}
+	if status != nil {
+		log.Warnf("Skipped %v, the node is syncing at height %v of %v", pass, hexHeight(status.CurrentBlock), hexHeight(status.HighestBlock))
+		metrics.UnlockerSkipped.Inc(u.config.Name, "syncing")
+		return false
+	}
+	if !u.headMoving(pass) {
+		return false
+	}
+	if u.config.MinPeers <= 0 {
+		return true
+	}
+	peers, err := u.rpc.GetPeerCount()
+	if err != nil {
+		log.Warnf("Skipped %v, failed to get the peer count of the node: %v", pass, err)
+		metrics.UnlockerSkipped.Inc(u.config.Name, "error")
+		return false
+	}
+	if peers < u.config.MinPeers {
+		log.Warnf("Skipped %v, the node has %v peers of %v needed", pass, peers, u.config.MinPeers)
+		metrics.UnlockerSkipped.Inc(u.config.Name, "peers")
+		return false
+	}
+	return true
+}
+
+// headMoving watches the daemon's head. Once it hasn't moved for haltedBlocks block times, no
+// block comes after the candidates, which would be orphaned or matured on a chain that may yet
+// change, so maturity decisions wait until the head moves again.
+func (u *BlockUnlocker) headMoving(pass string) bool {
+	if u.haltedAfter <= 0 {
+		return true
+	}
+	block, err := u.rpc.GetPendingBlock()
+	if err != nil || block == nil {
+		log.Warnf("Skipped %v, failed to get the head of the node: %v", pass, err)
+		metrics.UnlockerSkipped.Inc(u.config.Name, "error")
+		return false
+	}
+	height, err := strconv.ParseInt(strings.TrimPrefix(block.Number, "0x"), 16, 64)
+	if err != nil {
+		log.Warnf("Skipped %v, can't parse the head %v of the node: %v", pass, block.Number, err)
+		metrics.UnlockerSkipped.Inc(u.config.Name, "error")
+		return false
+	}
+
+	now := time.Now()
+	if height != u.head || u.headChangedAt.IsZero() {
+		u.head = height
+		u.headChangedAt = now
+		if u.chainHalted {
+			u.chainHalted = false
+			metrics.UnlockerChainHalted.Set(0, u.config.Name)
+			log.Infof("The chain moves again at height %v, resuming unlocking", height)
+			u.alerts.Fire(alerts.EventChainResumed, u.config.Daemon, "Chain moves again at height %v, resuming unlocking", height)
+		}
+		return true
+	}
+	stalled := now.Sub(u.headChangedAt)
+	if stalled < u.haltedAfter {
+		return true
+	}
+	if !u.chainHalted {
+		u.chainHalted = true
+		metrics.UnlockerChainHalted.Set(1, u.config.Name)
+		u.alerts.Fire(alerts.EventChainHalted, u.config.Daemon, "No new block after height %v for %v, unlocking paused", height, stalled.Truncate(time.Second))
+	}
+	log.Warnf("Skipped %v, no new block after height %v for %v", pass, height, stalled.Truncate(time.Second))
+	metrics.UnlockerSkipped.Inc(u.config.Name, "halted")
+	return false
+}
+
+func hexHeight(s string) string {
+	height, err := strconv.ParseInt(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return "?"
+	}
+	return strconv.FormatInt(height, 10)
+}
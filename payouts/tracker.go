@@ -0,0 +1,319 @@
+package payouts
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/rpc"
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Nodes reject a same-nonce replacement unless its fees are raised by at least 10%.
+const minGasBump = 10
+
+type TrackerConfig struct {
+	Enabled bool `json:"enabled"`
+	// Blocks including the tx block before a payment is confirmed
+	Confirmations int64 `json:"confirmations"`
+	// A tx pending longer than this is rebroadcast or replaced
+	StuckTimeout string `json:"stuckTimeout"`
+	// Fee increase of a replacement in percent
+	GasBump         int64 `json:"gasBump"`
+	MaxReplacements int   `json:"maxReplacements"`
+}
+
+// outgoingTx is a sent payout with everything needed to send it again with the same nonce.
+type outgoingTx struct {
+	to    string
+	value *big.Int
+	data  []byte
+	quote *gasQuote
+
+	nonce    uint64
+	hasNonce bool
+	// Signed payload, only for local signing
+	raw []byte
+
+	// Every hash sent for this nonce, the latest last
+	hashes       []string
+	sentAt       time.Time
+	replacements int
+	escalated    bool
+}
+
+func (tx *outgoingTx) sent(txHash string) {
+	tx.hashes = append(tx.hashes, txHash)
+	tx.sentAt = time.Now()
+}
+
+func (tx *outgoingTx) hash() string {
+	return tx.hashes[len(tx.hashes)-1]
+}
+
+// bump returns the quote with all fees raised by percent.
+func (q *gasQuote) bump(percent int64) *gasQuote {
+	raise := func(value *big.Int) *big.Int {
+		if value == nil {
+			return nil
+		}
+		v := new(big.Int).Mul(value, big.NewInt(100+percent))
+		return v.Div(v, big.NewInt(100))
+	}
+	return &gasQuote{
+		dynamic:        q.dynamic,
+		gas:            q.gas,
+		gasPrice:       raise(q.gasPrice),
+		maxFee:         raise(q.maxFee),
+		maxPriorityFee: raise(q.maxPriorityFee),
+		effectivePrice: raise(q.effectivePrice),
+	}
+}
+
+func (u *PayoutsProcessor) trackerConfirmations() int64 {
+	if !u.config.Tracker.Enabled || u.config.Tracker.Confirmations < 1 {
+		return 1
+	}
+	return u.config.Tracker.Confirmations
+}
+
+// trackPayment waits until a payout tx has enough confirmations and updates its payment state.
+// Stuck transactions are rebroadcast or replaced with higher fees when the tracker is enabled.
+func (u *PayoutsProcessor) trackPayment(receiptData *TxReceipt) {
+	var stuckTimeout time.Duration
+	if u.config.Tracker.Enabled && len(u.config.Tracker.StuckTimeout) > 0 {
+		stuckTimeout = util.MustParseDuration(u.config.Tracker.StuckTimeout)
+	}
+	tx := receiptData.tx
+
+	for {
+		log.Debugf("Waiting for tx confirmation: %v", receiptData.txHash)
+		time.Sleep(txCheckInterval)
+
+		receipt, err := u.findReceipt(tx.hashes)
+		if err != nil {
+			log.Errorf("Failed to get tx receipt for %v: %v", receiptData.txHash, err)
+			continue
+		}
+		// Tx has been mined
+		if receipt != nil && receipt.Confirmed() {
+			confirmed, err := u.hasConfirmations(receipt)
+			if err != nil {
+				log.Errorf("Failed to check confirmations of %v: %v", receipt.TxHash, err)
+				continue
+			}
+			if !confirmed {
+				continue
+			}
+			// An earlier hash of the same nonce won, point the payments back at it.
+			if !strings.EqualFold(receipt.TxHash, receiptData.txHash) {
+				u.movePayment(receiptData, receipt.TxHash)
+			}
+			if receipt.Successful() {
+				log.Infof("Payout tx successful for %s: %s", receiptData.login, receiptData.txHash)
+				err = u.db.UpdatePaymentState(receiptData.txHash, mysql.PaymentConfirmed)
+			} else {
+				err = u.db.UpdatePaymentState(receiptData.txHash, mysql.PaymentFailed)
+				u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, receiptData.login, "",
+					"Payout tx failed for %s: %s. Address contract throws on incoming tx.", receiptData.login, receiptData.txHash)
+			}
+			if err != nil {
+				u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, receiptData.login, "",
+					"Failed to update payment state for %s: %s: %v", receiptData.login, receiptData.txHash, err)
+			}
+			return
+		}
+
+		if receipt == nil && stuckTimeout > 0 && time.Since(tx.sentAt) > stuckTimeout {
+			u.unstick(receiptData)
+			if tx.escalated {
+				u.abandonPayment(receiptData)
+				return
+			}
+		}
+	}
+}
+
+// abandonPayment stops tracking a tx that can't be replaced anymore, so it doesn't hold up the
+// next payout runs, and leaves its payment for manual review.
+func (u *PayoutsProcessor) abandonPayment(receiptData *TxReceipt) {
+	err := u.db.UpdatePaymentState(receiptData.txHash, mysql.PaymentReview)
+	if err != nil {
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, receiptData.login, "",
+			"Failed to update payment state for %s: %s: %v", receiptData.login, receiptData.txHash, err)
+	}
+	log.Warnf("Stopped tracking payout tx %v for %v, its payment is left for manual review", receiptData.txHash, receiptData.login)
+}
+
+// findReceipt returns the receipt of whichever hash of the nonce has been mined.
+func (u *PayoutsProcessor) findReceipt(hashes []string) (*rpc.TxReceipt, error) {
+	for i := len(hashes) - 1; i >= 0; i-- {
+		receipt, err := u.rpc.GetTxReceipt(hashes[i])
+		if err != nil {
+			return nil, err
+		}
+		if receipt != nil && receipt.Confirmed() {
+			if len(receipt.TxHash) == 0 {
+				receipt.TxHash = hashes[i]
+			}
+			return receipt, nil
+		}
+	}
+	return nil, nil
+}
+
+func (u *PayoutsProcessor) hasConfirmations(receipt *rpc.TxReceipt) (bool, error) {
+	confirmations := u.trackerConfirmations()
+	if confirmations <= 1 {
+		return true, nil
+	}
+	block, err := u.rpc.GetLatestBlock()
+	if err != nil {
+		return false, err
+	}
+	if block == nil {
+		return false, fmt.Errorf("no latest block")
+	}
+	latest, err := strconv.ParseInt(strings.Replace(block.Number, "0x", "", -1), 16, 64)
+	if err != nil {
+		return false, err
+	}
+	mined, err := strconv.ParseInt(strings.Replace(receipt.BlockNumber, "0x", "", -1), 16, 64)
+	if err != nil {
+		return false, err
+	}
+	return latest-mined+1 >= confirmations, nil
+}
+
+// unstick rebroadcasts a dropped tx or replaces a pending one with the same nonce and higher fees.
+func (u *PayoutsProcessor) unstick(receiptData *TxReceipt) {
+	tx := receiptData.tx
+	cfg := u.config.Tracker
+
+	pending, err := u.rpc.GetTransactionByHash(tx.hash())
+	if err != nil {
+		log.Errorf("Failed to get stuck tx %v: %v", tx.hash(), err)
+		return
+	}
+
+	// Dropped from the mempool, signed payloads can be sent again as is, and so can txs the node
+	// signed with a nonce given by the nonce manager when the node picked their gas.
+	if pending == nil && (len(tx.raw) > 0 || tx.hasNonce && tx.quote == nil) {
+		if u.nonceUsed(tx) {
+			return
+		}
+		var err error
+		if len(tx.raw) > 0 {
+			_, err = u.rpc.SendRawTransaction(hexutil.Encode(tx.raw))
+		} else {
+			_, err = u.rpc.SendTransactionParams(u.transactionParams(tx))
+		}
+		if err != nil {
+			log.Errorf("Failed to rebroadcast tx %v: %v", tx.hash(), err)
+			return
+		}
+		tx.sentAt = time.Now()
+		log.Warnf("Rebroadcast payout tx %v for %v", tx.hash(), receiptData.login)
+		return
+	}
+
+	if tx.replacements >= cfg.MaxReplacements {
+		if !tx.escalated {
+			tx.escalated = true
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, receiptData.login, "",
+				"Payout tx %s for %s is still pending after %v replacements, check it manually", receiptData.txHash, receiptData.login, tx.replacements)
+		}
+		return
+	}
+
+	if !tx.hasNonce {
+		if pending == nil {
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, receiptData.login, "",
+				"Payout tx %s for %s was dropped by the node and can't be replaced, check it manually", tx.hash(), receiptData.login)
+			tx.replacements = cfg.MaxReplacements
+			tx.escalated = true
+			return
+		}
+		nonce, err := strconv.ParseUint(strings.Replace(pending.Nonce, "0x", "", -1), 16, 64)
+		if err != nil {
+			log.Errorf("Invalid nonce %v of tx %v: %v", pending.Nonce, tx.hash(), err)
+			return
+		}
+		tx.nonce, tx.hasNonce = nonce, true
+	}
+	if pending == nil && u.nonceUsed(tx) {
+		return
+	}
+
+	quote := tx.quote
+	if quote == nil {
+		// Node picked the gas values, replace from what it used.
+		gasPrice := util.String2Big(pending.GasPrice)
+		quote = &gasQuote{gas: util.String2Big(pending.Gas), gasPrice: gasPrice, effectivePrice: gasPrice}
+	}
+	bump := cfg.GasBump
+	if bump < minGasBump {
+		bump = minGasBump
+	}
+	quote = quote.bump(bump)
+	capped := quote.gasPrice
+	if quote.dynamic {
+		capped = quote.maxFee
+	}
+	if limit := util.String2Big(u.config.MaxFeePerGas); limit.Sign() > 0 && capped.Cmp(limit) > 0 {
+		if !tx.escalated {
+			tx.escalated = true
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, receiptData.login, "",
+				"Payout tx %s for %s is stuck and a replacement would exceed maxFeePerGas %v", tx.hash(), receiptData.login, u.config.MaxFeePerGas)
+		}
+		return
+	}
+
+	tx.quote = quote
+	txHash, err := u.broadcast(tx)
+	if err != nil {
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, receiptData.login, "",
+			"Failed to replace payout tx %s for %s: %v", tx.hash(), receiptData.login, err)
+		tx.sentAt = time.Now()
+		return
+	}
+	tx.sent(txHash)
+	tx.replacements++
+	if u.nonces != nil {
+		u.nonces.replaced(tx.nonce, txHash, u.txRecord(tx))
+	}
+	u.logs.InsertLog(fmt.Sprintf("Replaced stuck payout tx %v with %v (%v)", receiptData.txHash, txHash, quote),
+		plogger.LogTypePaymentWork, plogger.LogSubTypePaymentTxWait, 0, 0, receiptData.login, "")
+	u.movePayment(receiptData, txHash)
+}
+
+// nonceUsed reports a nonce taken by a tx the tracker doesn't know, which must not be replaced.
+func (u *PayoutsProcessor) nonceUsed(tx *outgoingTx) bool {
+	nonce, err := u.rpc.GetLatestNonce(u.config.Address)
+	if err != nil {
+		log.Errorf("Failed to get nonce of %v: %v", u.config.Address, err)
+		return true
+	}
+	if nonce > tx.nonce && !tx.escalated {
+		tx.escalated = true
+		tx.replacements = u.config.Tracker.MaxReplacements
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, tx.to, "",
+			"Nonce %v of payout tx %s was used by another tx, check it manually", tx.nonce, tx.hash())
+	}
+	return nonce > tx.nonce
+}
+
+func (u *PayoutsProcessor) movePayment(receiptData *TxReceipt, txHash string) {
+	err := u.db.UpdatePaymentTxHash(receiptData.txHash, txHash)
+	if err != nil {
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, receiptData.login, "",
+			"Failed to move payment of %s from %s to %s: %v", receiptData.login, receiptData.txHash, txHash, err)
+		return
+	}
+	receiptData.txHash = txHash
+}
@@ -0,0 +1,29 @@
+package payouts
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestGasQuoteBump(t *testing.T) {
+	legacy := &gasQuote{gas: big.NewInt(21000), gasPrice: big.NewInt(20000000000), effectivePrice: big.NewInt(20000000000)}
+	bumped := legacy.bump(15)
+	if bumped.gasPrice.Cmp(big.NewInt(23000000000)) != 0 {
+		t.Errorf("Must raise gas price by 15%%, got %v", bumped.gasPrice)
+	}
+	if bumped.gas.Cmp(legacy.gas) != 0 {
+		t.Error("Must keep gas limit")
+	}
+	if legacy.gasPrice.Cmp(big.NewInt(20000000000)) != 0 {
+		t.Error("Must not change original quote")
+	}
+
+	dynamic := &gasQuote{dynamic: true, gas: big.NewInt(21000), maxFee: big.NewInt(100), maxPriorityFee: big.NewInt(10), effectivePrice: big.NewInt(60)}
+	bumped = dynamic.bump(10)
+	if !bumped.dynamic || bumped.gasPrice != nil {
+		t.Error("Must stay a dynamic fee quote")
+	}
+	if bumped.maxFee.Int64() != 110 || bumped.maxPriorityFee.Int64() != 11 {
+		t.Errorf("Must raise max fee and tip, got %v and %v", bumped.maxFee, bumped.maxPriorityFee)
+	}
+}
@@ -1,16 +1,21 @@
 package payouts
 
 import (
+	"errors"
 	"fmt"
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
 	"github.com/cellcrypto/open-dangnn-pool/hook"
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/payouts/rewards"
 	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
 	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
 	"github.com/cellcrypto/open-dangnn-pool/storage/types"
 	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
-	"log"
 	"math/big"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cellcrypto/open-dangnn-pool/rpc"
@@ -19,40 +24,150 @@ import (
 
 type UnlockerConfig struct {
 	Enabled        bool    `json:"enabled"`
+	// Name of the pool, set from the pool config
+	Name           string  `json:"-"`
 	PoolFee        float64 `json:"poolFee"`
-	PoolFeeAddress string  `json:"poolFeeAddress"`
+	// An address, or {address, percent} pairs the fee is split across, percents summing to 100
+	PoolFeeAddress FeeSplits `json:"poolFeeAddress"`
+	// Credited with the round-off dust of the rewards once it adds up to a Shannon: an address, or
+	// "largest" for the largest shareholder of the round. Rewards are rounded to the nearest Shannon when empty
+	RemainderTo string `json:"remainderTo"`
+	// Bonus of the login that submitted the winning share of a block, none when empty
+	FinderBonus FinderBonusConfig `json:"finderBonus"`
 	Donate         bool    `json:"donate"`
+	// Credited with donationFee percent of the pool fee when donate is on, the developers' address if empty
+	DonationAddress string  `json:"donationAddress"`
+	DonationFee     float64 `json:"donationFee"`
 	Depth          int64   `json:"depth"`
 	ImmatureDepth  int64   `json:"immatureDepth"`
 	KeepTxFees     bool    `json:"keepTxFees"`
 	Interval       string  `json:"interval"`
+	// Also run a pass as soon as a new head brings blocks to depth, needs a ws:// or IPC daemon
+	NewHeads       bool    `json:"newHeads"`
 	Daemon         string  `json:"daemon"`
 	Timeout        string  `json:"timeout"`
+	// Optional node used for tx receipt scans, defaults to daemon
+	ArchiveDaemon  string  `json:"archiveDaemon"`
+	FeeSource      FeeSourceConfig `json:"feeSource"`
+	// Max time to resolve one candidate, empty to disable
+	CandidateTimeout    string `json:"candidateTimeout"`
+	MaxCandidateRetries int    `json:"maxCandidateRetries"`
+	// Blocks searched before and after a candidate's height, 16 by default, at most immatureDepth
+	SearchWindow int64 `json:"searchWindow"`
+	// Blocks kept from the node during a pass, for candidates searching overlapping windows. 128 by default
+	BlockCacheSize int `json:"blockCacheSize"`
+	// Candidates resolved against the node at once, 1 by default. The results are still
+	// written in the order of the candidates
+	Concurrency int `json:"concurrency"`
+	// Passes a block must be missing from the chain before it is orphaned, 3 by default.
+	// Until then it is checked again on every pass, so a node briefly on another fork doesn't orphan it.
+	OrphanChecks int `json:"orphanChecks"`
+	// A block neither matured nor orphaned this long after it was found is alerted as stuck, empty to disable
+	MaxCandidateAge string `json:"maxCandidateAge"`
+	// Unlock passes are skipped while the daemon is syncing or has fewer peers, 0 checks syncing only
+	MinPeers int64 `json:"minPeers"`
+	// Unlock passes are also skipped once the daemon's head hasn't moved for haltedBlocks times
+	// blockTime ("13s" by default), as the chain or the node stalled. 0 to disable
+	HaltedBlocks int64  `json:"haltedBlocks"`
+	BlockTime    string `json:"blockTime"`
+	// "round" (default) splits uncle rewards like blocks, "height" only among miners
+	// that submitted shares at the uncle's height
+	UncleRewards string `json:"uncleRewards"`
+	// "score" credits rounds by shares weighted by their age in the round, exp(age / scoreDecay)
+	// ("10m" by default). By the last shares or the shares of the round, per the pplns feature, when empty
+	ShareWeighting string `json:"shareWeighting"`
+	ScoreDecay     string `json:"scoreDecay"`
+	// Moves settled rounds and old stats from Redis to MySQL
+	Archive ArchiverConfig `json:"archive"`
+}
+
+const (
+	UncleRewardsRound  = "round"
+	UncleRewardsHeight = "height"
+)
+
+// FeeSourceConfig reads block fee totals from external indexers instead of per-tx receipts,
+// for nodes that prune receipts.
+type FeeSourceConfig struct {
+	Enabled  bool            `json:"enabled"`
+	Timeout  string          `json:"timeout"`
+	Indexers []IndexerConfig `json:"indexers"`
+	// Scan receipts on the node when every indexer fails
+	ReceiptFallback bool `json:"receiptFallback"`
+}
+
+type IndexerConfig struct {
+	Name   string `json:"name"`
+	Url    string `json:"url"`
+	ApiKey string `json:"apiKey"`
 }
 
 const minDepth = 16
-const byzantiumHardForkHeight = 0
 
-//var GenesisReword =   math.MustParseBig256("300000000000000000000")
-//var byzantiumReward = math.MustParseBig256("300000000000000000000")
+const defaultOrphanChecks = 3
 
-// Donate 10% from pool fees to developers
-const donationFee = 10.0
-const donationAccount = "0xb05146ed865f0ab592dd763bd84a2191700f3dfb"
+// Donate 10% from pool fees to developers unless configured otherwise
+const defaultDonationFee = 10.0
+const defaultDonationAddress = "0xb05146ed865f0ab592dd763bd84a2191700f3dfb"
 
 type BlockUnlocker struct {
 	config   *UnlockerConfig
 	backend  *redis.RedisClient
 	db 		 *mysql.Database
 	rpc      *rpc.RPCClient
+	archive  *rpc.RPCClient
+	indexers []*rpc.IndexerClient
+	logs     *plogger.Logger
+	alerts   *alerts.Alerts
 	halt     bool
 	lastFail error
-	mainNet  bool
+	// Block reward and fee rules by height
+	forks    *types.Forks
+	candidateTimeout time.Duration
+	maxCandidateAge  time.Duration
+	// Blocks fetched in the current pass
+	blocks *blockCache
+	// Head watchdog, see headMoving
+	haltedAfter   time.Duration
+	head          int64
+	headChangedAt time.Time
+	chainHalted   bool
+	// nil unless archive is enabled
+	archiver *archiver
+	// Reloaded config, applied between passes
+	reload chan *UnlockerConfig
+	// The current pass was skipped by nodeReady, and when the last one completed
+	skipped  bool
+	lastPass int64
 }
 
-func NewBlockUnlocker(cfg *UnlockerConfig, backend *redis.RedisClient, db *mysql.Database, mainnet string, netId int64) *BlockUnlocker {
-	if len(cfg.PoolFeeAddress) != 0 && !util.IsValidHexAddress(cfg.PoolFeeAddress) {
-		log.Fatalln("Invalid poolFeeAddress", cfg.PoolFeeAddress)
+func NewBlockUnlocker(cfg *UnlockerConfig, backend *redis.RedisClient, db *mysql.Database, forks *types.Forks, netId int64) *BlockUnlocker {
+	if err := cfg.PoolFeeAddress.validate(); err != nil {
+		log.Fatalf("Invalid unlocker config: %v", err)
+	}
+	if err := validateRemainderTo(cfg.RemainderTo); err != nil {
+		log.Fatalf("Invalid unlocker config: %v", err)
+	}
+	if err := cfg.FinderBonus.validate(); err != nil {
+		log.Fatalf("Invalid unlocker config: %v", err)
+	}
+	if cfg.FinderBonus.enabled() {
+		log.Infof("Crediting block finders with %v", cfg.FinderBonus)
+	}
+	if cfg.Donate {
+		if len(cfg.DonationAddress) == 0 {
+			cfg.DonationAddress = defaultDonationAddress
+		}
+		if cfg.DonationFee == 0 {
+			cfg.DonationFee = defaultDonationFee
+		}
+		if !util.IsValidHexAddress(cfg.DonationAddress) {
+			log.Fatalf("Invalid donationAddress %v", cfg.DonationAddress)
+		}
+		if cfg.DonationFee < 0 || cfg.DonationFee > 100 {
+			log.Fatalf("Invalid donationFee %v, must be a percentage of the pool fee", cfg.DonationFee)
+		}
+		log.Infof("Donating %v%% of the pool fee to %v", cfg.DonationFee, cfg.DonationAddress)
 	}
 	if cfg.Depth < minDepth*2 {
 		log.Fatalf("Block maturity depth can't be < %v, your depth is %v", minDepth*2, cfg.Depth)
@@ -60,39 +175,106 @@ func NewBlockUnlocker(cfg *UnlockerConfig, backend *redis.RedisClient, db *mysql
 	if cfg.ImmatureDepth < minDepth {
 		log.Fatalf("Immature depth can't be < %v, your depth is %v", minDepth, cfg.ImmatureDepth)
 	}
-	net := true
-	if mainnet != "testnet" {
-		net = true
-	} else {
-		net = false
+	if cfg.SearchWindow == 0 {
+		cfg.SearchWindow = minDepth
+	}
+	if cfg.SearchWindow < 0 || cfg.SearchWindow > cfg.ImmatureDepth {
+		log.Fatalf("Invalid searchWindow %v, must be between 1 and immatureDepth %v", cfg.SearchWindow, cfg.ImmatureDepth)
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.Concurrency < 0 {
+		log.Fatalf("Invalid concurrency %v", cfg.Concurrency)
+	}
+	if cfg.OrphanChecks == 0 {
+		cfg.OrphanChecks = defaultOrphanChecks
+	}
+	if cfg.OrphanChecks < 0 {
+		log.Fatalf("Invalid orphanChecks %v", cfg.OrphanChecks)
+	}
+	switch cfg.UncleRewards {
+	case "", UncleRewardsRound, UncleRewardsHeight:
+	default:
+		log.Fatalf("Invalid uncleRewards %v, must be %v or %v", cfg.UncleRewards, UncleRewardsRound, UncleRewardsHeight)
+	}
+	if decay, err := cfg.ShareScoreDecay(); err != nil {
+		log.Fatalf("Invalid unlocker config: %v", err)
+	} else if decay > 0 {
+		log.Infof("Crediting rounds by score, shares decaying every %v", decay)
 	}
-
 	u := &BlockUnlocker{
 		config: cfg,
 		backend: backend,
 		db: db,
-		mainNet: net,
+		logs: plogger.For(cfg.Name),
+		alerts: alerts.For(cfg.Name),
+		forks: forks,
+		blocks: newBlockCache(cfg.BlockCacheSize),
+		archiver: newArchiver(&cfg.Archive),
+		reload: make(chan *UnlockerConfig, 1),
+	}
+	u.rpc = rpc.NewRPCClient(cfg.Name, "BlockUnlocker", cfg.Daemon, cfg.Timeout, netId)
+	u.archive = u.rpc
+	if len(cfg.CandidateTimeout) > 0 {
+		u.candidateTimeout = util.MustParseDuration(cfg.CandidateTimeout)
+	}
+	if len(cfg.MaxCandidateAge) > 0 {
+		u.maxCandidateAge = util.MustParseDuration(cfg.MaxCandidateAge)
+	}
+	if cfg.HaltedBlocks > 0 {
+		blockTime := 13 * time.Second
+		if len(cfg.BlockTime) > 0 {
+			blockTime = util.MustParseDuration(cfg.BlockTime)
+		}
+		u.haltedAfter = time.Duration(cfg.HaltedBlocks) * blockTime
+	}
+	if len(cfg.ArchiveDaemon) > 0 {
+		u.archive = rpc.NewRPCClient(cfg.Name, "BlockUnlockerArchive", cfg.ArchiveDaemon, cfg.Timeout, netId)
+		u.archive.Role = rpc.RoleArchive
+	}
+	if cfg.FeeSource.Enabled {
+		if len(cfg.FeeSource.Indexers) == 0 {
+			log.Fatal("Fee source is enabled but no indexers are configured")
+		}
+		for _, v := range cfg.FeeSource.Indexers {
+			u.indexers = append(u.indexers, rpc.NewIndexerClient(v.Name, v.Url, v.ApiKey, cfg.FeeSource.Timeout))
+			log.Infof("Fee source indexer: %s => %s", v.Name, v.Url)
+		}
 	}
-	u.rpc = rpc.NewRPCClient("BlockUnlocker", cfg.Daemon, cfg.Timeout, netId)
 	return u
 }
 
 func (u *BlockUnlocker) Start() {
-	log.Println("Starting block unlocker")
+	log.Info("Starting block unlocker")
 	intv := util.MustParseDuration(u.config.Interval)
 	timer := time.NewTimer(intv)
-	log.Printf("Set block unlock interval to %v", intv)
+	log.Infof("Set block unlock interval to %v", intv)
 
 	// Immediately unlock after start
 	u.unlockPendingBlocks()
 	u.unlockAndCreditMiners()
+	u.reportHalt()
+	u.reportHealth()
+	u.checkStuckBlocks()
+	u.archiveRedis()
 	timer.Reset(intv)
 	quit := make(chan struct{})
 	hooks := make(chan struct{})
+	// Heads arriving during a pass are coalesced into the next one
+	heads := make(chan int64, 1)
+	if u.config.NewHeads {
+		if err := u.rpc.SubscribeNewHeads(heads); err != nil {
+			log.Errorf("Failed to subscribe to new heads of %v, unlocking every %v: %v", u.config.Daemon, intv, err)
+		} else {
+			log.Infof("Unlocking blocks as new heads bring them to depth, at least every %v", intv)
+		}
+	}
+	var lastHead int64
 
-	plogger.InsertLog("START UNLOCK SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
-	hook.RegistryHook("unlock.go", func(name string) {
-		plogger.InsertLog("SHUTDOWN UNLOCK SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+	u.logs.InsertLog("START UNLOCK SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+	hook.RegistryHook(util.Join("unlock.go", u.config.Name), func(name string) {
+		u.logs.InsertLog("SHUTDOWN UNLOCK SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
 		close(quit)
 		<- hooks
 	})
@@ -106,18 +288,145 @@ func (u *BlockUnlocker) Start() {
 			case <-timer.C:
 				u.unlockPendingBlocks()
 				u.unlockAndCreditMiners()
+				u.reportHalt()
+				u.reportHealth()
+				u.checkStuckBlocks()
+				u.archiveRedis()
+				timer.Reset(intv)
+			case next := <-u.reload:
+				intv = u.applyConfig(next)
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(intv)
+			case head := <-heads:
+				due := u.maturing(lastHead, head)
+				lastHead = head
+				if !due {
+					continue
+				}
+				if !timer.Stop() {
+					<-timer.C
+				}
+				u.unlockPendingBlocks()
+				u.unlockAndCreditMiners()
+				u.reportHalt()
+				u.reportHealth()
+				u.checkStuckBlocks()
+				u.archiveRedis()
 				timer.Reset(intv)
 			}
 		}
 	}()
 }
 
+// Reload hands the pool fee and interval of a reloaded config to the unlocker, they apply from its
+// next pass. The rest of the config needs a restart.
+func (u *BlockUnlocker) Reload(cfg *UnlockerConfig) error {
+	if err := cfg.PoolFeeAddress.validate(); err != nil {
+		return err
+	}
+	if cfg.PoolFee < 0 || cfg.PoolFee >= 100 {
+		return fmt.Errorf("invalid poolFee %v", cfg.PoolFee)
+	}
+	if intv, err := time.ParseDuration(cfg.Interval); err != nil || intv <= 0 {
+		return fmt.Errorf("invalid interval %v", cfg.Interval)
+	}
+	// A reload not applied yet is replaced
+	select {
+	case <-u.reload:
+	default:
+	}
+	u.reload <- cfg
+	return nil
+}
+
+func (u *BlockUnlocker) applyConfig(cfg *UnlockerConfig) time.Duration {
+	u.config.PoolFee = cfg.PoolFee
+	u.config.PoolFeeAddress = cfg.PoolFeeAddress
+	u.config.Interval = cfg.Interval
+	intv := util.MustParseDuration(cfg.Interval)
+	log.Infof("Reloaded unlocker: pool fee %v%% to %v, interval %v", cfg.PoolFee, cfg.PoolFeeAddress, intv)
+	return intv
+}
+
+// maturing tells whether the head moving from prev to head brought candidates to immatureDepth or
+// immature blocks to depth. Blocks due before, e.g. retried after a timeout, wait for the interval.
+func (u *BlockUnlocker) maturing(prev, head int64) bool {
+	if prev == 0 {
+		prev = head - 1
+	}
+	if head <= prev {
+		return false
+	}
+	count, err := u.db.CountMaturing(prev, head, u.config.ImmatureDepth, u.config.Depth)
+	if err != nil {
+		log.Errorf("Failed to count blocks reaching depth at height %v: %v", head, err)
+		return false
+	}
+	return count > 0
+}
+
+// reportHalt alerts, once per cooldown, while the unlocker is halted. It stays halted until restarted.
+func (u *BlockUnlocker) reportHalt() {
+	metrics.UnlockerHalted.SetBool(u.halt, u.config.Name)
+	if u.halt {
+		u.alerts.Fire(alerts.EventUnlockerHalted, "unlocker", "Block unlocker halted: %v", u.lastFail)
+	}
+}
+
 type UnlockResult struct {
-	maturedBlocks  []*types.BlockData
-	orphanedBlocks []*types.BlockData
-	orphans        int
-	uncles         int
-	blocks         int
+	maturedBlocks   []*types.BlockData
+	orphanedBlocks  []*types.BlockData
+	duplicateBlocks []*types.BlockData
+	orphans         int
+	uncles          int
+	blocks          int
+	duplicates      int
+	skipped         int
+	// Missing from the chain, checked again on the next pass
+	rechecks        int
+}
+
+// candidateDedupe remembers submissions and block hashes already resolved in one unlock pass,
+// so duplicate submissions (e.g. the same share written twice around a restart) are credited once.
+type candidateDedupe struct {
+	submissions map[string]struct{}
+	hashes      map[string]struct{}
+}
+
+func newCandidateDedupe() *candidateDedupe {
+	return &candidateDedupe{
+		submissions: make(map[string]struct{}),
+		hashes:      make(map[string]struct{}),
+	}
+}
+
+// seenSubmission returns true if a candidate with the same nonce and pow hash was already seen.
+func (d *candidateDedupe) seenSubmission(candidate *types.BlockData) bool {
+	key := strings.ToLower(util.Join(candidate.Nonce, candidate.PowHash))
+	if _, ok := d.submissions[key]; ok {
+		return true
+	}
+	d.submissions[key] = struct{}{}
+	return false
+}
+
+// seenHash returns true if a candidate already resolved to the given block hash.
+func (d *candidateDedupe) seenHash(hash string) bool {
+	key := strings.ToLower(hash)
+	if _, ok := d.hashes[key]; ok {
+		return true
+	}
+	d.hashes[key] = struct{}{}
+	return false
+}
+
+func (r *UnlockResult) markDuplicate(candidate *types.BlockData, hash string) {
+	candidate.Hash = hash
+	r.duplicates++
+	r.duplicateBlocks = append(r.duplicateBlocks, candidate)
+	log.Warnf("Duplicate block %v:%v, hash: %v", candidate.RoundHeight, candidate.Nonce, hash)
 }
 
 /* Geth does not provide consistent state when you need both new height and new job,
@@ -129,90 +438,320 @@ type UnlockResult struct {
  */
 func (u *BlockUnlocker) unlockCandidates(candidates []*types.BlockData) (*UnlockResult, error) {
 	result := &UnlockResult{}
+	dedupe := newCandidateDedupe()
+	u.blocks.reset()
+	defer func() {
+		hits, misses := u.blocks.reset()
+		log.Debugf("Resolved %v candidates with %v blocks fetched from the node, %v cached", len(candidates), misses, hits)
+	}()
+
+	// Submissions seen twice aren't resolved
+	duplicates := make([]bool, len(candidates))
+	for i, candidate := range candidates {
+		duplicates[i] = len(candidate.PowHash) > 0 && dedupe.seenSubmission(candidate)
+	}
+	resolved := u.resolveCandidates(candidates, duplicates)
 
 	// Data row is: "height:nonce:powHash:mixDigest:timestamp:diff:totalShares"
-	for _, candidate := range candidates {
-		orphan := true
+	for i, candidate := range candidates {
+		if duplicates[i] {
+			result.markDuplicate(candidate, candidate.Hash)
+			continue
+		}
 
-		/* Search for a normal block with wrong height here by traversing 16 blocks back and forward.
-		 * Also we are searching for a block that can include this one as uncle.
-		 */
-		for i := int64(minDepth * -1); i < minDepth; i++ {
-			height := candidate.Height + i
+		match, err := resolved[i].match, resolved[i].err
+		if err == errCandidateTimeout {
+			// Don't let one pathological block stall the batch, retry it on the next pass.
+			result.skipped++
+			u.recordCandidateTimeout(candidate)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
 
-			if height < 0 {
+		// Block is lost, we didn't find any valid block or uncle matching our data in a blockchain
+		if match.block == nil {
+			if !u.confirmOrphan(candidate) {
+				result.rechecks++
 				continue
 			}
+			result.orphans++
+			candidate.Orphan = true
+			result.orphanedBlocks = append(result.orphanedBlocks, candidate)
+			log.Warnf("Orphaned block %v:%v", candidate.RoundHeight, candidate.Nonce)
+			continue
+		}
+
+		if err := u.db.ResetOrphanChecks(candidate); err != nil {
+			return nil, fmt.Errorf("Error while resetting orphan checks of block %v: %v", candidate.RoundHeight, err)
+		}
+
+		duplicate, err := u.isDuplicateHash(dedupe, match.hash, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if duplicate {
+			result.markDuplicate(candidate, match.hash)
+			continue
+		}
+
+		*candidate = *match.block
+		result.maturedBlocks = append(result.maturedBlocks, candidate)
+		if match.uncle {
+			result.uncles++
+			log.Infof("Mature uncle %v/%v of reward %v with hash: %v", candidate.Height, candidate.UncleHeight,
+				util.FormatReward(candidate.Reward), candidate.Hash[0:10])
+		} else {
+			result.blocks++
+			log.Infof("Mature block %v with %v tx, hash: %v", candidate.Height, match.txs, candidate.Hash[0:10])
+		}
+	}
+	return result, nil
+}
+
+type candidateResolution struct {
+	match *candidateMatch
+	err   error
+}
+
+// resolveCandidates resolves the candidates that aren't skipped on up to concurrency workers. The
+// results are indexed like the candidates, so they are written in order whichever worker finishes
+// first. Once a candidate fails, the ones not started yet are left unresolved: they come after
+// it, and the pass stops at the failure.
+func (u *BlockUnlocker) resolveCandidates(candidates []*types.BlockData, skip []bool) []candidateResolution {
+	results := make([]candidateResolution, len(candidates))
+	next := make(chan int)
+	var failed int32
+	var wg sync.WaitGroup
+	for w := 0; w < u.config.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				match, err := u.resolveCandidateWithTimeout(candidates[i])
+				if err != nil && err != errCandidateTimeout {
+					atomic.StoreInt32(&failed, 1)
+				}
+				results[i] = candidateResolution{match, err}
+			}
+		}()
+	}
+	for i := range candidates {
+		if skip[i] {
+			continue
+		}
+		if atomic.LoadInt32(&failed) == 1 {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// candidateMatch is a candidate resolved against the chain. block is a resolved copy of the candidate, nil if orphaned.
+type candidateMatch struct {
+	block *types.BlockData
+	hash  string
+	uncle bool
+	txs   int
+}
+
+var errCandidateTimeout = errors.New("candidate processing timed out")
+
+// resolveCandidateWithTimeout gives up on a candidate after candidateTimeout. The candidate is resolved on a copy,
+// so a request still running in the background can't touch the batch.
+func (u *BlockUnlocker) resolveCandidateWithTimeout(candidate *types.BlockData) (*candidateMatch, error) {
+	if u.candidateTimeout <= 0 {
+		return u.resolveCandidate(*candidate)
+	}
+
+	type reply struct {
+		match *candidateMatch
+		err   error
+	}
+	done := make(chan reply, 1)
+	go func(c types.BlockData) {
+		match, err := u.resolveCandidate(c)
+		done <- reply{match, err}
+	}(*candidate)
+
+	timer := time.NewTimer(u.candidateTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.match, r.err
+	case <-timer.C:
+		return nil, errCandidateTimeout
+	}
+}
+
+func (u *BlockUnlocker) resolveCandidate(candidate types.BlockData) (*candidateMatch, error) {
+	// Immature blocks know their hash, look it up before scanning heights
+	if len(candidate.Hash) > 0 {
+		match, err := u.resolveByHash(candidate)
+		if err != nil || match != nil {
+			return match, err
+		}
+	}
+
+	/* Search for a normal block with wrong height here by traversing searchWindow blocks back and forward.
+	 * Also we are searching for a block that can include this one as uncle.
+	 */
+	for i := -u.config.SearchWindow; i < u.config.SearchWindow; i++ {
+		height := candidate.Height + i
+
+		if height < 0 {
+			continue
+		}
+
+		block, err := u.getBlockByHeight(height)
+		if err != nil {
+			log.Errorf("Error while retrieving block %v from node: %v", height, err)
+			return nil, err
+		}
+		if block == nil {
+			return nil, fmt.Errorf("Error while retrieving block %v from node, wrong node height", height)
+		}
 
-			block, err := u.rpc.GetBlockByHeight(height)
+		if matchCandidate(block, &candidate) {
+			err = u.handleBlock(block, &candidate)
 			if err != nil {
-				log.Printf("Error while retrieving block %v from node: %v", height, err)
 				return nil, err
 			}
-			if block == nil {
-				return nil, fmt.Errorf("Error while retrieving block %v from node, wrong node height", height)
-			}
+			return &candidateMatch{block: &candidate, hash: block.Hash, txs: len(block.Transactions)}, nil
+		}
 
-			if matchCandidate(block, candidate) {
-				orphan = false
-				result.blocks++
+		if len(block.Uncles) == 0 {
+			continue
+		}
 
-				err = u.handleBlock(block, candidate)
+		// Trying to find uncle in current block during our forward check
+		for uncleIndex, uncleHash := range block.Uncles {
+			uncle, err := u.getUncle(height, uncleIndex)
+			if err != nil {
+				return nil, fmt.Errorf("Error while retrieving uncle of block %v from node: %v", uncleHash, err)
+			}
+			if uncle == nil {
+				return nil, fmt.Errorf("Error while retrieving uncle of block %v from node", height)
+			}
+
+			// Found uncle
+			if matchCandidate(uncle, &candidate) {
+				err := u.handleUncle(height, uncle, &candidate)
 				if err != nil {
-					u.halt = true
-					u.lastFail = err
 					return nil, err
 				}
-				result.maturedBlocks = append(result.maturedBlocks, candidate)
-				log.Printf("Mature block %v with %v tx, hash: %v", candidate.Height, len(block.Transactions), candidate.Hash[0:10])
-				break
+				return &candidateMatch{block: &candidate, hash: uncle.Hash, uncle: true}, nil
 			}
+		}
+	}
+	return &candidateMatch{}, nil
+}
 
-			if len(block.Uncles) == 0 {
+// resolveByHash finds a block by the hash it was matched to, if it's still the canonical block at
+// its height, or an uncle of the block at the candidate's height. Nil otherwise, the heights are
+// scanned then.
+func (u *BlockUnlocker) resolveByHash(candidate types.BlockData) (*candidateMatch, error) {
+	if candidate.Uncle {
+		block, err := u.getBlockByHeight(candidate.Height)
+		if err != nil || block == nil {
+			return nil, err
+		}
+		for uncleIndex, uncleHash := range block.Uncles {
+			if !strings.EqualFold(uncleHash, candidate.Hash) {
 				continue
 			}
-
-			// Trying to find uncle in current block during our forward check
-			for uncleIndex, uncleHash := range block.Uncles {
-				uncle, err := u.rpc.GetUncleByBlockNumberAndIndex(height, uncleIndex)
-				if err != nil {
-					return nil, fmt.Errorf("Error while retrieving uncle of block %v from node: %v", uncleHash, err)
-				}
-				if uncle == nil {
-					return nil, fmt.Errorf("Error while retrieving uncle of block %v from node", height)
-				}
-
-				// Found uncle
-				if matchCandidate(uncle, candidate) {
-					orphan = false
-					result.uncles++
-
-					err := u.handleUncle(height, uncle, candidate)
-					if err != nil {
-						u.halt = true
-						u.lastFail = err
-						return nil, err
-					}
-					result.maturedBlocks = append(result.maturedBlocks, candidate)
-					log.Printf("Mature uncle %v/%v of reward %v with hash: %v", candidate.Height, candidate.UncleHeight,
-						util.FormatReward(candidate.Reward), uncle.Hash[0:10])
-					break
-				}
+			uncle, err := u.getUncle(candidate.Height, uncleIndex)
+			if err != nil || uncle == nil {
+				return nil, err
 			}
-			// Found block or uncle
-			if !orphan {
-				break
+			if err := u.handleUncle(candidate.Height, uncle, &candidate); err != nil {
+				return nil, err
 			}
+			return &candidateMatch{block: &candidate, hash: uncle.Hash, uncle: true}, nil
 		}
-		// Block is lost, we didn't find any valid block or uncle matching our data in a blockchain
-		if orphan {
-			result.orphans++
-			candidate.Orphan = true
-			result.orphanedBlocks = append(result.orphanedBlocks, candidate)
-			log.Printf("Orphaned block %v:%v", candidate.RoundHeight, candidate.Nonce)
-		}
+		return nil, nil
 	}
-	return result, nil
+
+	block, err := u.getBlockByHash(candidate.Hash)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	height, err := strconv.ParseInt(strings.Replace(block.Number, "0x", "", -1), 16, 64)
+	if err != nil {
+		return nil, err
+	}
+	// The node keeps blocks a reorg replaced, they must still be at their height
+	canonical, err := u.getBlockByHeight(height)
+	if err != nil || canonical == nil || !strings.EqualFold(canonical.Hash, candidate.Hash) {
+		return nil, err
+	}
+	if err := u.handleBlock(canonical, &candidate); err != nil {
+		return nil, err
+	}
+	return &candidateMatch{block: &candidate, hash: canonical.Hash, txs: len(canonical.Transactions)}, nil
+}
+
+// recordCandidateTimeout counts the retry and escalates once the candidate reaches maxCandidateRetries.
+func (u *BlockUnlocker) recordCandidateTimeout(candidate *types.BlockData) {
+	logType := plogger.LogTypeMaturedBlock
+	if candidate.State == 0 {
+		logType = plogger.LogTypePendingBlock
+	}
+
+	retries, err := u.db.IncrUnlockRetry(candidate)
+	if err != nil {
+		u.logs.InsertSystemError(logType, candidate.RoundHeight, candidate.Height, "Failed to record unlock retry: %v", err)
+		return
+	}
+	log.Warnf("Skipped block %v:%v after %v, retry %v", candidate.RoundHeight, candidate.Nonce, u.candidateTimeout, retries)
+
+	if u.config.MaxCandidateRetries > 0 && retries >= u.config.MaxCandidateRetries {
+		u.logs.InsertLog(fmt.Sprintf("Block %v:%v timed out %v times, check the node and the block manually", candidate.RoundHeight, candidate.Nonce, retries),
+			logType, plogger.LogSubTypeCandidateTimeout, candidate.RoundHeight, candidate.Height, "", "")
+	}
+}
+
+// confirmOrphan counts a pass that missed the candidate on the chain and tells whether it is
+// orphaned now. Before orphanChecks passes missed it, the candidate is left for the next pass.
+func (u *BlockUnlocker) confirmOrphan(candidate *types.BlockData) bool {
+	if u.config.OrphanChecks <= 1 {
+		return true
+	}
+	logType := plogger.LogTypeMaturedBlock
+	if candidate.State == 0 {
+		logType = plogger.LogTypePendingBlock
+	}
+
+	checks, err := u.db.IncrOrphanCheck(candidate)
+	if err != nil {
+		// Not orphaned on an uncounted miss
+		u.logs.InsertSystemError(logType, candidate.RoundHeight, candidate.Height, "Failed to record orphan check: %v", err)
+		return false
+	}
+	if checks >= u.config.OrphanChecks {
+		return true
+	}
+	metrics.UnlockerRechecks.Inc(u.config.Name)
+	log.Warnf("Block %v:%v not found on the chain, checking it again on the next pass (%v of %v)",
+		candidate.RoundHeight, candidate.Nonce, checks, u.config.OrphanChecks)
+	return false
+}
+
+// isDuplicateHash checks the block hash against candidates resolved earlier in this pass
+// and against blocks already credited in the database.
+func (u *BlockUnlocker) isDuplicateHash(dedupe *candidateDedupe, hash string, candidate *types.BlockData) (bool, error) {
+	if dedupe.seenHash(hash) {
+		return true, nil
+	}
+	credited, err := u.db.IsBlockHashCredited(candidate, hash)
+	if err != nil {
+		return false, fmt.Errorf("Error while checking duplicate block %v: %v", hash, err)
+	}
+	return credited, nil
 }
 
 func matchCandidate(block *rpc.GetBlockReply, candidate *types.BlockData) bool {
@@ -237,7 +776,7 @@ func (u *BlockUnlocker) handleBlock(block *rpc.GetBlockReply, candidate *types.B
 		return err
 	}
 	candidate.Height = correctHeight
-	reward := types.GetConstReward(candidate.Height, u.mainNet)
+	reward := u.forks.ConstReward(candidate.Height)
 
 	// Add TX fees
 	extraTxReward, err := u.getExtraRewardForTx(block)
@@ -251,13 +790,15 @@ func (u *BlockUnlocker) handleBlock(block *rpc.GetBlockReply, candidate *types.B
 	}
 
 	// Add reward for including uncles
-	uncleReward := types.GetRewardForUncle(candidate.Height, u.mainNet)
+	uncleReward := u.forks.RewardForUncle(candidate.Height)
 	rewardForUncles := big.NewInt(0).Mul(uncleReward, big.NewInt(int64(len(block.Uncles))))
 	reward.Add(reward, rewardForUncles)
 
 	candidate.Orphan = false
 	candidate.Hash = block.Hash
 	candidate.Reward = reward
+	candidate.TxFees = extraTxReward
+	candidate.UncleBonus = rewardForUncles
 	return nil
 }
 
@@ -266,7 +807,7 @@ func (u *BlockUnlocker) handleUncle(height int64, uncle *rpc.GetBlockReply, cand
 	if err != nil {
 		return err
 	}
-	reward := types.GetUncleReward(uncleHeight, height, u.mainNet)
+	reward := u.forks.UncleReward(uncleHeight, height)
 	if reward.Cmp(big.NewInt(0)) < 0 {
 		reward = big.NewInt(0)
 	}
@@ -280,7 +821,10 @@ func (u *BlockUnlocker) handleUncle(height int64, uncle *rpc.GetBlockReply, cand
 
 func (u *BlockUnlocker) unlockPendingBlocks() {
 	if u.halt {
-		log.Println("Unlocking suspended due to last critical error:", u.lastFail)
+		log.Error("Unlocking suspended due to last critical error:", u.lastFail)
+		return
+	}
+	if !u.nodeReady("unlocking pending blocks") {
 		return
 	}
 
@@ -289,7 +833,7 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 		u.halt = true
 		u.lastFail = err
 		//log.Printf("Unable to get current blockchain height from node: %v", err)
-		plogger.InsertSystemError(plogger.LogTypePendingBlock, 0, 0, "Unable to get current blockchain height from node: %v", err)
+		u.logs.InsertSystemError(plogger.LogTypePendingBlock, 0, 0, "Unable to get current blockchain height from node: %v", err)
 		return
 	}
 	currentHeight, err := strconv.ParseInt(strings.Replace(current.Number, "0x", "", -1), 16, 64)
@@ -297,22 +841,23 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 		u.halt = true
 		u.lastFail = err
 		//log.Printf("Can't parse pending block number: %v", err)
-		plogger.InsertSystemError(plogger.LogTypePendingBlock, 0, 0, "Can't parse pending block number: %v", err)
+		u.logs.InsertSystemError(plogger.LogTypePendingBlock, 0, 0, "Can't parse pending block number: %v", err)
 		return
 	}
 
 	candidates, err := u.db.GetCandidates(currentHeight - u.config.ImmatureDepth)
 	//candidates, err := u.backend.GetCandidates(currentHeight - u.config.ImmatureDepth)
-	if err != nil {
+	if metrics.MysqlError(u.config.Name, "get_candidates", err) != nil {
 		u.halt = true
 		u.lastFail = err
 		//log.Printf("Failed to get block candidates from backend: %v", err)
-		plogger.InsertSystemError(plogger.LogTypePendingBlock, 0, 0, "Failed to get block candidates from backend: %v", err)
+		u.logs.InsertSystemError(plogger.LogTypePendingBlock, 0, 0, "Failed to get block candidates from backend: %v", err)
 		return
 	}
 
+	metrics.UnlockerCandidates.Set(float64(len(candidates)), u.config.Name)
 	if len(candidates) == 0 {
-		log.Println("[Info] No block candidates to unlock")
+		log.Debug("No block candidates to unlock")
 		return
 	}
 
@@ -321,10 +866,14 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 		u.halt = true
 		u.lastFail = err
 		//log.Printf("Failed to unlock blocks: %v", err)
-		plogger.InsertSystemError(plogger.LogTypePendingBlock, 0, 0, "Failed to unlock blocks: %v", err)
+		u.logs.InsertSystemError(plogger.LogTypePendingBlock, 0, 0, "Failed to unlock blocks: %v", err)
+		return
+	}
+	log.Infof("Immature %v blocks, %v uncles, %v orphans, %v duplicates, %v skipped, %v to recheck", result.blocks, result.uncles, result.orphans, result.duplicates, result.skipped, result.rechecks)
+
+	if !u.writeDuplicateBlocks(result.duplicateBlocks, plogger.LogTypePendingBlock) {
 		return
 	}
-	log.Printf("Immature %v blocks, %v uncles, %v orphans", result.blocks, result.uncles, result.orphans)
 
 	err = u.db.WritePendingOrphans(result.orphanedBlocks)
 	//err = u.backend.WritePendingOrphans(result.orphanedBlocks)
@@ -332,10 +881,10 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 		u.halt = true
 		u.lastFail = err
 		//log.Printf("Failed to insert orphaned blocks into backend: %v", err)
-		plogger.InsertSystemError(plogger.LogTypePendingBlock, 0, 0, "Failed to insert orphaned blocks into backend: %v", err)
+		u.logs.InsertSystemError(plogger.LogTypePendingBlock, 0, 0, "Failed to insert orphaned blocks into backend: %v", err)
 		return
 	} else {
-		log.Printf("Inserted %v orphaned blocks to backend", result.orphans)
+		log.Infof("Inserted %v orphaned blocks to backend", result.orphans)
 	}
 
 	totalRevenue := new(big.Rat)
@@ -344,25 +893,31 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 
 	start := time.Now()
 	for _, block := range result.maturedBlocks {
-		revenue, minersProfit, poolProfit, roundRewards, percents, err := u.calculateRewards(block)
+		r, err := u.calculateRewards(block)
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
 			//log.Printf("Failed to calculate rewards for round %v: %v", block.RoundKey(), err)
-			plogger.InsertSystemError(plogger.LogTypePendingBlock, block.RoundHeight, block.Height, "Failed to calculate rewards for round %v: %v", block.RoundKey(), err)
+			u.logs.InsertSystemError(plogger.LogTypePendingBlock, block.RoundHeight, block.Height, "Failed to calculate rewards for round %v: %v", block.RoundKey(), err)
 			return
 		}
 
-		if roundRewards == nil {
+		if r == nil {
 			// If the list to receive the reward is not listed in Redis.
 			u.db.WriteImmatureError(block, 0, 1)
-			plogger.InsertLog("Failure: Redis has no one to share the rewards with", plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height,"", "")
+			u.logs.InsertLog("Failure: Redis has no one to share the rewards with", plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height,"", "")
 			continue
 		}
 
-		totalRevenue.Add(totalRevenue, revenue)
-		totalMinersProfit.Add(totalMinersProfit, minersProfit)
-		totalPoolProfit.Add(totalPoolProfit, poolProfit)
+		if block.Reward != nil {
+			if err := u.backend.PublishBlockMatured(block.Height, block.Hash, block.Uncle, block.Reward.String()); err != nil {
+				log.Errorf("Failed to publish matured block %v: %v", block.Height, err)
+			}
+		}
+
+		totalRevenue.Add(totalRevenue, r.revenue)
+		totalMinersProfit.Add(totalMinersProfit, r.minersProfit)
+		totalPoolProfit.Add(totalPoolProfit, r.poolProfit)
 
 		var hashName string
 		if block.UncleHeight > 0 {
@@ -374,28 +929,26 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 		logEntry := fmt.Sprintf(
 			"IMMATURE %v: size: %d,revenue %v, miners profit %v, pool profit: %v",
 			hashName,
-			len(roundRewards),
-			util.FormatRatReward(revenue),
-			util.FormatRatReward(minersProfit),
-			util.FormatRatReward(poolProfit),
+			len(r.rewards),
+			util.FormatRatReward(r.revenue),
+			util.FormatRatReward(r.minersProfit),
+			util.FormatRatReward(r.poolProfit),
 		)
 
-		err = u.db.WriteImmatureBlock(block, roundRewards, percents)
+		err = u.db.WriteImmatureBlock(block, r.rewards, r.percents)
 		//err = u.backend.WriteImmatureBlock(block, roundRewards)
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
 			//log.Printf("Failed to credit rewards for round %v: %v", block.RoundKey(), err)
-			plogger.InsertSystemError(plogger.LogTypePendingBlock, block.RoundHeight, block.Height, "Failed to credit rewards for round %v: %v", block.RoundKey(), err)
+			u.logs.InsertSystemError(plogger.LogTypePendingBlock, block.RoundHeight, block.Height, "Failed to credit rewards for round %v: %v", block.RoundKey(), err)
 			return
 		}
 
-		plogger.InsertLog(logEntry, plogger.LogTypePendingBlock, plogger.LogErrorNothing, block.RoundHeight, block.Height,"", "")
-
-		log.Println(logEntry)
+		u.logs.InsertLog(logEntry, plogger.LogTypePendingBlock, plogger.LogErrorNothing, block.RoundHeight, block.Height,"", "")
 	}
 
-	log.Printf(
+	log.Infof(
 		"(%v) IMMATURE SESSION: block size: %v,revenue %v, miners profit %v, pool profit: %v",
 		time.Since(start),
 		len(result.maturedBlocks),
@@ -407,7 +960,10 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 
 func (u *BlockUnlocker) unlockAndCreditMiners() {
 	if u.halt {
-		log.Println("unlockAndCreditMiners: Unlocking suspended due to last critical error:", u.lastFail)
+		log.Error("unlockAndCreditMiners: Unlocking suspended due to last critical error:", u.lastFail)
+		return
+	}
+	if !u.nodeReady("crediting matured blocks") {
 		return
 	}
 
@@ -416,7 +972,7 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 		u.halt = true
 		u.lastFail = err
 		//log.Printf("Unable to get current blockchain height from node: %v", err)
-		plogger.InsertSystemError(plogger.LogTypeMaturedBlock, 0, 0, "Unable to get current blockchain height from node: %v", err)
+		u.logs.InsertSystemError(plogger.LogTypeMaturedBlock, 0, 0, "Unable to get current blockchain height from node: %v", err)
 		return
 	}
 	currentHeight, err := strconv.ParseInt(strings.Replace(current.Number, "0x", "", -1), 16, 64)
@@ -424,7 +980,7 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 		u.halt = true
 		u.lastFail = err
 		//log.Printf("Can't parse pending block number: %v", err)
-		plogger.InsertSystemError(plogger.LogTypeMaturedBlock, 0, 0, "Can't parse pending block number: %v", err)
+		u.logs.InsertSystemError(plogger.LogTypeMaturedBlock, 0, 0, "Can't parse pending block number: %v", err)
 		return
 	}
 
@@ -434,12 +990,12 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 		u.halt = true
 		u.lastFail = err
 		//log.Printf("Failed to get block candidates from backend: %v", err)
-		plogger.InsertSystemError(plogger.LogTypeMaturedBlock, 0, 0, "Failed to get block candidates from backend: %v", err)
+		u.logs.InsertSystemError(plogger.LogTypeMaturedBlock, 0, 0, "Failed to get block candidates from backend: %v", err)
 		return
 	}
 
 	if len(immature) == 0 {
-		log.Println("[Info] No immature blocks to credit miners")
+		log.Debug("No immature blocks to credit miners")
 		return
 	}
 
@@ -448,10 +1004,14 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 		u.halt = true
 		u.lastFail = err
 		//log.Printf("Failed to unlock blocks: %v", err)
-		plogger.InsertSystemError(plogger.LogTypeMaturedBlock, 0, 0, "Failed to unlock blocks: %v", err)
+		u.logs.InsertSystemError(plogger.LogTypeMaturedBlock, 0, 0, "Failed to unlock blocks: %v", err)
+		return
+	}
+	log.Infof("Unlocked %v blocks, %v uncles, %v orphans, %v duplicates, %v skipped, %v to recheck", result.blocks, result.uncles, result.orphans, result.duplicates, result.skipped, result.rechecks)
+
+	if !u.writeDuplicateBlocks(result.duplicateBlocks, plogger.LogTypeMaturedBlock) {
 		return
 	}
-	log.Printf("Unlocked %v blocks, %v uncles, %v orphans", result.blocks, result.uncles, result.orphans)
 
 	for _, block := range result.orphanedBlocks {
 		err = u.db.WriteOrphan(block)
@@ -460,11 +1020,12 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 			u.halt = true
 			u.lastFail = err
 			// log.Printf("Failed to insert orphaned block into backend: %v", err)
-			plogger.InsertSystemError(plogger.LogTypeMaturedBlock, block.RoundHeight, block.Height, "Failed to insert orphaned block into backend: %v", err)
+			u.logs.InsertSystemError(plogger.LogTypeMaturedBlock, block.RoundHeight, block.Height, "Failed to insert orphaned block into backend: %v", err)
 			return
 		}
+		u.alerts.Fire(alerts.EventBlockOrphaned, strconv.FormatInt(block.RoundHeight, 10), "Block %v orphaned, nonce %v", block.RoundHeight, block.Nonce)
 	}
-	log.Printf("Inserted %v orphaned blocks to backend", result.orphans)
+	log.Infof("Inserted %v orphaned blocks to backend", result.orphans)
 
 	totalRevenue := new(big.Rat)
 	totalMinersProfit := new(big.Rat)
@@ -473,53 +1034,51 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 	start := time.Now()
 
 	for _, block := range result.maturedBlocks {
-		revenue, minersProfit, poolProfit, roundRewards, percents, err := u.calculateRewards(block)
+		r, err := u.calculateRewards(block)
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
 			//log.Printf("Failed to calculate rewards for round %v: %v", block.RoundKey(), err)
-			plogger.InsertSystemError(plogger.LogTypeMaturedBlock, block.RoundHeight, block.Height, "Failed to calculate rewards for round %v: %v", block.RoundKey(), err)
+			u.logs.InsertSystemError(plogger.LogTypeMaturedBlock, block.RoundHeight, block.Height, "Failed to calculate rewards for round %v: %v", block.RoundKey(), err)
 			return
 		}
 
-		if roundRewards == nil {
+		if r == nil {
 			// If the list to receive the reward is not listed in Redis.
 			u.db.WriteImmatureError(block, block.State, 2)
-			log.Printf("Failed: No round_block information for reward in Redis.")
-			plogger.InsertLog("Failed: No round_block information for reward in Redis.",
+			u.logs.InsertLog("Failed: No round_block information for reward in Redis.",
 				plogger.LogTypeMaturedBlock,plogger.LogSubTypeSystemRoundInfoRedis, block.RoundHeight, block.Height, "", "")
 			continue
 		}
 
-		err = u.db.WriteMaturedBlock(block, roundRewards, percents)
+		profit := blockProfit(block, r.revenue, r.minersProfit, r.poolProfit, r.credits)
+		err = u.db.WriteMaturedBlock(block, r.rewards, r.roundWei, r.percents, r.credits, r.distribution, profit, r.remainder)
 		// err = u.backend.WriteMaturedBlock(block, roundRewards)
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
 			//log.Printf("Failed to credit rewards for round %v: %v", block.RoundKey(), err)
-			plogger.InsertSystemError(plogger.LogTypeMaturedBlock, block.RoundHeight, block.Height, "Failed to credit rewards for round %v: %v", block.RoundKey(), err)
+			u.logs.InsertSystemError(plogger.LogTypeMaturedBlock, block.RoundHeight, block.Height, "Failed to credit rewards for round %v: %v", block.RoundKey(), err)
 			return
 		}
 
-		totalRevenue.Add(totalRevenue, revenue)
-		totalMinersProfit.Add(totalMinersProfit, minersProfit)
-		totalPoolProfit.Add(totalPoolProfit, poolProfit)
+		totalRevenue.Add(totalRevenue, r.revenue)
+		totalMinersProfit.Add(totalMinersProfit, r.minersProfit)
+		totalPoolProfit.Add(totalPoolProfit, r.poolProfit)
 
 		logEntry := fmt.Sprintf(
 			"MATURED %v: size %v,revenue %v, miners profit %v, pool profit: %v",
 			block.RoundKey(),
-			len(roundRewards),
-			util.FormatRatReward(revenue),
-			util.FormatRatReward(minersProfit),
-			util.FormatRatReward(poolProfit),
+			len(r.rewards),
+			util.FormatRatReward(r.revenue),
+			util.FormatRatReward(r.minersProfit),
+			util.FormatRatReward(r.poolProfit),
 		)
 
-		plogger.InsertLog(logEntry, plogger.LogTypeMaturedBlock, plogger.LogErrorNothing, block.RoundHeight, block.Height,"", "")
-
-		log.Println(logEntry)
+		u.logs.InsertLog(logEntry, plogger.LogTypeMaturedBlock, plogger.LogErrorNothing, block.RoundHeight, block.Height,"", "")
 	}
 
-	log.Printf(
+	log.Infof(
 		"(%s) MATURE SESSION: block size: %v,revenue %v, miners profit %v, pool profit: %v",
 		time.Since(start),
 		len(result.maturedBlocks),
@@ -529,18 +1088,61 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 	)
 }
 
-func (u *BlockUnlocker) calculateRewards(block *types.BlockData) (*big.Rat, *big.Rat, *big.Rat, map[string]int64, map[string]*big.Rat, error) {
+// writeDuplicateBlocks moves duplicate candidates into the duplicate state. Returns false if the unlocker halted.
+func (u *BlockUnlocker) writeDuplicateBlocks(blocks []*types.BlockData, logType int) bool {
+	for _, block := range blocks {
+		err := u.db.WriteDuplicateBlock(block)
+		if err != nil {
+			u.halt = true
+			u.lastFail = err
+			u.logs.InsertSystemError(logType, block.RoundHeight, block.Height, "Failed to mark duplicate block: %v", err)
+			return false
+		}
+		u.logs.InsertLog(fmt.Sprintf("DUPLICATE %v: hash: %v", block.RoundKey(), block.Hash), logType, plogger.LogSubTypeDuplicateBlock, block.RoundHeight, block.Height, "", "")
+	}
+	return true
+}
+
+// blockRewards is the split of a block's revenue.
+type blockRewards struct {
+	revenue      *big.Rat
+	minersProfit *big.Rat
+	poolProfit   *big.Rat
+	// Shannon credited to each login, its share of the round and the credits
+	rewards  map[string]int64
+	percents map[string]*big.Rat
+	// Exact wei of each login's share of the round
+	roundWei map[string]*big.Int
+	// Pool fee, donation and remainder, included in rewards
+	credits      []*mysql.PoolCredit
+	distribution *types.RoundDistribution
+	// Shannon credited to the block finder, included in rewards and credits
+	finderBonus int64
+	// Wei lost rounding to Shannon, and the dust left for the next block with remainderTo
+	dust      *big.Int
+	remainder *big.Int
+}
+
+func (r *blockRewards) credit(login, reason string, amount int64, wei *big.Int) {
+	r.rewards[login] += amount
+	r.credits = append(r.credits, &mysql.PoolCredit{Login: login, Reason: reason, Amount: amount, Wei: wei})
+	r.dust.Add(r.dust, roundOff(wei, amount))
+}
+
+// calculateRewards splits a block's revenue, the credits are the pool fee, donation and finder bonus included
+// in rewards. It also summarizes how the round shares were distributed. It returns nil without shares.
+func (u *BlockUnlocker) calculateRewards(block *types.BlockData) (*blockRewards, error) {
 	revenue := new(big.Rat).SetInt(block.Reward)
 	minersProfit, poolProfit := chargeFee(revenue, u.config.PoolFee)
 
-	shares, err := u.backend.GetRoundShares(block.RoundHeight, block.Nonce)
+	shares, _, err := u.roundShares(block)
 	if err != nil {
-		return nil, nil, nil, nil, nil, err
+		return nil, err
 	}
 
 	// shares are not in Redis.
 	if len(shares) == 0 {
-		return nil, nil, nil, nil, nil, nil
+		return nil, nil
 	}
 
 	totalShares := int64(0)
@@ -548,7 +1150,31 @@ func (u *BlockUnlocker) calculateRewards(block *types.BlockData) (*big.Rat, *big
 		totalShares += val
 	}
 
-	rewards, percents := calculateRewardsForShares(shares, totalShares, minersProfit)
+	r := &blockRewards{
+		revenue:      revenue,
+		minersProfit: minersProfit,
+		roundWei:     make(map[string]*big.Int, len(shares)),
+		distribution: rewards.Distribute(shares),
+		dust:         new(big.Int),
+	}
+	// The finder bonus comes out of the miners' profit before the round is split
+	roundProfit, bonus := minersProfit, (*big.Rat)(nil)
+	if len(block.Finder) != 0 && u.config.FinderBonus.enabled() {
+		bonus = u.config.FinderBonus.bonus(revenue, minersProfit)
+		roundProfit = new(big.Rat).Sub(minersProfit, bonus)
+	}
+	r.rewards, r.percents = calculateRewardsForShares(shares, totalShares, roundProfit)
+	for login, percent := range r.percents {
+		amount, wei := u.toShannon(new(big.Rat).Mul(roundProfit, percent))
+		r.rewards[login], r.roundWei[login] = amount, wei
+		r.dust.Add(r.dust, roundOff(wei, amount))
+	}
+	if bonus != nil {
+		amount, wei := u.toShannon(bonus)
+		r.credit(strings.ToLower(block.Finder), mysql.ReasonFinderBonus, amount, wei)
+		r.finderBonus = amount
+	}
+	block.FinderBonus = r.finderBonus
 
 	if block.ExtraReward != nil {
 		extraReward := new(big.Rat).SetInt(block.ExtraReward)
@@ -558,57 +1184,171 @@ func (u *BlockUnlocker) calculateRewards(block *types.BlockData) (*big.Rat, *big
 
 	if u.config.Donate {
 		var donation = new(big.Rat)
-		poolProfit, donation = chargeFee(poolProfit, donationFee)
-		login := strings.ToLower(donationAccount)
-		rewards[login] += weiToShannonInt64(donation)
+		poolProfit, donation = chargeFee(poolProfit, u.config.DonationFee)
+		amount, wei := u.toShannon(donation)
+		r.credit(strings.ToLower(u.config.DonationAddress), mysql.ReasonDonation, amount, wei)
 	}
 
-	if len(u.config.PoolFeeAddress) != 0 {
-		address := strings.ToLower(u.config.PoolFeeAddress)
-		rewards[address] += weiToShannonInt64(poolProfit)
+	if splits := u.config.PoolFeeAddress; len(splits) != 0 {
+		for i, part := range splitFee(poolProfit, splits) {
+			amount, wei := u.toShannon(part)
+			r.credit(strings.ToLower(splits[i].Address), mysql.ReasonPoolFee, amount, wei)
+		}
 	}
+	r.poolProfit = poolProfit
 
-	return revenue, minersProfit, poolProfit, rewards, percents, nil
+	if len(u.config.RemainderTo) != 0 {
+		if err := u.creditRemainder(r, shares); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
 }
 
-func calculateRewardsForShares(shares map[string]int64, total int64, reward *big.Rat) (map[string]int64, map[string]*big.Rat) {
-	rewards := make(map[string]int64)
-	percents := make(map[string]*big.Rat)
+// blockProfit is the split of a block's revenue calculateRewards returned, stored for the profit report.
+func blockProfit(block *types.BlockData, revenue, minersProfit, poolProfit *big.Rat, credits []*mysql.PoolCredit) *mysql.BlockProfit {
+	profit := &mysql.BlockProfit{
+		Revenue:      weiToShannonInt64(revenue),
+		MinersProfit: weiToShannonInt64(minersProfit),
+		PoolProfit:   weiToShannonInt64(poolProfit),
+	}
+	for _, credit := range credits {
+		if credit.Reason == mysql.ReasonDonation {
+			profit.Donation += credit.Amount
+		}
+	}
+	if block.TxFees != nil {
+		profit.TxFees = weiToShannonInt64(new(big.Rat).SetInt(block.TxFees))
+	}
+	if block.UncleBonus != nil {
+		profit.UncleBonus = weiToShannonInt64(new(big.Rat).SetInt(block.UncleBonus))
+	}
+	return profit
+}
 
-	for login, n := range shares {
-		percents[login] = big.NewRat(n, total)
-		workerReward := new(big.Rat).Mul(reward, percents[login])
-		rewards[login] += weiToShannonInt64(workerReward)
+// Where the shares of a round were read from
+const (
+	sharesFromHeight = "height"
+	sharesFromRedis  = "redis"
+	sharesFromMysql  = "mysql"
+)
+
+// roundShares returns the shares a block reward is split by and where they were read from.
+func (u *BlockUnlocker) roundShares(block *types.BlockData) (map[string]int64, string, error) {
+	if block.UncleHeight > 0 && u.config.UncleRewards == UncleRewardsHeight {
+		shares, err := u.backend.GetHeightShares(block.UncleHeight)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(shares) > 0 {
+			return shares, sharesFromHeight, nil
+		}
+		log.Warnf("No shares known at uncle height %v, using round %v shares", block.UncleHeight, block.RoundKey())
+	}
+	shares, err := u.backend.GetRoundShares(block.RoundHeight, block.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(shares) > 0 {
+		return shares, sharesFromRedis, nil
+	}
+	// Redis lost the round, use the snapshot taken with the candidate
+	shares, err = u.db.GetRoundShares(block.RoundHeight, block.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(shares) == 0 {
+		return nil, "", nil
 	}
-	return rewards, percents
+	log.Warnf("No shares in redis for round %v, using the %v miners of the mysql snapshot", block.RoundKey(), len(shares))
+	return shares, sharesFromMysql, nil
+}
+
+func calculateRewardsForShares(shares map[string]int64, total int64, reward *big.Rat) (map[string]int64, map[string]*big.Rat) {
+	return rewards.ForShares(shares, total, reward, util.Shannon)
 }
 
 // Returns new value after fee deduction and fee value.
 func chargeFee(value *big.Rat, fee float64) (*big.Rat, *big.Rat) {
-	feePercent := new(big.Rat).SetFloat64(fee / 100)
-	feeValue := new(big.Rat).Mul(value, feePercent)
-	return new(big.Rat).Sub(value, feeValue), feeValue
+	return rewards.ChargeFee(value, fee)
+}
+
+// toShannon rounds an exact amount to Shannon, down with remainderTo as the dust is credited, and
+// returns it with the amount in whole wei.
+func (u *BlockUnlocker) toShannon(value *big.Rat) (int64, *big.Int) {
+	wei := new(big.Int).Quo(value.Num(), value.Denom())
+	if len(u.config.RemainderTo) != 0 {
+		return new(big.Int).Quo(wei, util.Shannon).Int64(), wei
+	}
+	return weiToShannonInt64(value), wei
+}
+
+// roundOff is the wei lost rounding wei to amount Shannon, negative if it was rounded up.
+func roundOff(wei *big.Int, amount int64) *big.Int {
+	return new(big.Int).Sub(wei, new(big.Int).Mul(big.NewInt(amount), util.Shannon))
+}
+
+// Returns the parts of a fee credited to each of splits.
+func splitFee(fee *big.Rat, splits FeeSplits) []*big.Rat {
+	return rewards.Split(fee, splits.percents())
 }
 
 func weiToShannonInt64(wei *big.Rat) int64 {
-	shannon := new(big.Rat).SetInt(util.Shannon)
-	inShannon := new(big.Rat).Quo(wei, shannon)
-	value, _ := strconv.ParseInt(inShannon.FloatString(0), 10, 64)
-	return value
+	return rewards.ToUnit(wei, util.Shannon)
 }
 
 
 func (u *BlockUnlocker) getExtraRewardForTx(block *rpc.GetBlockReply) (*big.Int, error) {
+	if len(u.indexers) == 0 || len(block.Transactions) == 0 {
+		return u.getExtraRewardFromReceipts(block)
+	}
+
+	height, err := strconv.ParseInt(strings.Replace(block.Number, "0x", "", -1), 16, 64)
+	if err != nil {
+		return nil, err
+	}
+	constReward := u.forks.ConstReward(height)
+	for _, indexer := range u.indexers {
+		fees, err := indexer.GetBlockFees(height, constReward)
+		if err == nil {
+			return fees, nil
+		}
+		log.Errorf("Failed to get fees of block %v from %v: %v", height, indexer.Name, err)
+	}
+
+	if !u.config.FeeSource.ReceiptFallback {
+		return nil, fmt.Errorf("no fee source available for block %v", height)
+	}
+	return u.getExtraRewardFromReceipts(block)
+}
+
+func (u *BlockUnlocker) getExtraRewardFromReceipts(block *rpc.GetBlockReply) (*big.Int, error) {
 	amount := new(big.Int)
 
+	// Past London the base fee is burned, the miner only gets the tips
+	var baseFee *big.Int
+	height, err := strconv.ParseInt(strings.Replace(block.Number, "0x", "", -1), 16, 64)
+	if err != nil {
+		return nil, err
+	}
+	if u.forks.BurnsBaseFee(height) {
+		if len(block.BaseFeePerGas) == 0 {
+			return nil, fmt.Errorf("node reports no baseFeePerGas for block %v past London", height)
+		}
+		baseFee = util.String2Big(block.BaseFeePerGas)
+	}
+
 	for _, tx := range block.Transactions {
-		receipt, err := u.rpc.GetTxReceipt(tx.Hash)
+		receipt, err := u.archive.GetTxReceipt(tx.Hash)
 		if err != nil {
 			return nil, err
 		}
 		if receipt != nil {
 			gasUsed := util.String2Big(receipt.GasUsed)
 			gasPrice := util.String2Big(tx.GasPrice)
+			if baseFee != nil {
+				gasPrice.Sub(gasPrice, baseFee)
+			}
 			fee := new(big.Int).Mul(gasUsed, gasPrice)
 			amount.Add(amount, fee)
 		}
@@ -1,12 +1,15 @@
 package payouts
 
 import (
+	"encoding/json"
 	"github.com/cellcrypto/open-dangnn-pool/storage/types"
 	"math/big"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/cellcrypto/open-dangnn-pool/rpc"
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
 )
 
 func TestMain(m *testing.M) {
@@ -15,6 +18,17 @@ func TestMain(m *testing.M) {
 
 var mainnetFlag = bool(true)
 
+// Ethereum mainnet, with the Byzantium and Constantinople reward changes
+const byzantiumHardForkHeight = 4370000
+
+func testForks(t *testing.T, netId int64) *types.Forks {
+	forks, err := types.ForksFor(netId, "mainnet", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return forks
+}
+
 func TestCalculateRewards(t *testing.T) {
 	blockReward, _ := new(big.Rat).SetString("5000000000000000000")
 	shares := map[string]int64{"0x0": 1000000, "0x1": 20000, "0x2": 5000, "0x3": 10, "0x4": 1}
@@ -68,6 +82,112 @@ func TestWeiToShannonInt64(t *testing.T) {
 	}
 }
 
+func TestBlockProfit(t *testing.T) {
+	block := &types.BlockData{TxFees: big.NewInt(2000000000000000), UncleBonus: big.NewInt(62500000000000000)}
+	revenue, _ := new(big.Rat).SetString("2064500000000000000")
+	minersProfit, poolProfit := chargeFee(revenue, 1.0)
+	credits := []*mysql.PoolCredit{
+		{Login: "0x0", Reason: mysql.ReasonDonation, Amount: 100},
+		{Login: "0x1", Reason: mysql.ReasonPoolFee, Amount: 20545000},
+	}
+
+	profit := blockProfit(block, revenue, minersProfit, poolProfit, credits)
+	if profit.Revenue != 2064500000 || profit.MinersProfit != 2043855000 || profit.PoolProfit != 20645000 {
+		t.Errorf("Unexpected split %+v", profit)
+	}
+	if profit.Donation != 100 || profit.TxFees != 2000000 || profit.UncleBonus != 62500000 {
+		t.Errorf("Unexpected parts %+v", profit)
+	}
+}
+
+func TestFeeSplitsConfig(t *testing.T) {
+	var cfg UnlockerConfig
+	if err := json.Unmarshal([]byte(`{"poolFeeAddress": "0xb05146ed865f0ab592dd763bd84a2191700f3dfb"}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.PoolFeeAddress) != 1 || cfg.PoolFeeAddress[0].Percent != 100 || cfg.PoolFeeAddress.validate() != nil {
+		t.Errorf("A single address must take the whole fee, got %v", cfg.PoolFeeAddress)
+	}
+
+	list := `{"poolFeeAddress": [{"address": "0xb05146ed865f0ab592dd763bd84a2191700f3dfb", "percent": 70}, {"address": "0x0000000000000000000000000000000000000001", "percent": 30}]}`
+	if err := json.Unmarshal([]byte(list), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.PoolFeeAddress.validate(); err != nil {
+		t.Errorf("Must accept percents summing to 100: %v", err)
+	}
+	cfg.PoolFeeAddress[1].Percent = 20
+	if cfg.PoolFeeAddress.validate() == nil {
+		t.Error("Must refuse percents summing to 90")
+	}
+
+	fee, _ := new(big.Rat).SetString("10000000000000000")
+	parts := splitFee(fee, FeeSplits{{Percent: 70}, {Percent: 30}})
+	if weiToShannonInt64(parts[0]) != 7000000 || weiToShannonInt64(parts[1]) != 3000000 {
+		t.Errorf("Unexpected split %v %v", parts[0].FloatString(0), parts[1].FloatString(0))
+	}
+}
+
+func TestRemainderRoundsDown(t *testing.T) {
+	u := &BlockUnlocker{config: &UnlockerConfig{}}
+	value, _ := new(big.Rat).SetString("1999999999")
+	if amount, wei := u.toShannon(value); amount != 2 || roundOff(wei, amount).Int64() != -1 {
+		t.Errorf("Must round to the nearest Shannon without remainderTo, got %v", amount)
+	}
+	u.config.RemainderTo = RemainderLargest
+	if amount, wei := u.toShannon(value); amount != 1 || roundOff(wei, amount).Int64() != 999999999 {
+		t.Errorf("Must round down with remainderTo, got %v", amount)
+	}
+
+	shares := map[string]int64{"0x2": 10, "0x1": 10, "0x0": 5}
+	if login := largestShareholder(shares); login != "0x1" {
+		t.Errorf("Expected the first of the largest shareholders, got %v", login)
+	}
+	if validateRemainderTo("someone") == nil {
+		t.Error("Must refuse a remainderTo that isn't an address")
+	}
+}
+
+func TestShareScoreDecay(t *testing.T) {
+	cfg := &UnlockerConfig{}
+	if decay, err := cfg.ShareScoreDecay(); err != nil || decay != 0 {
+		t.Errorf("Must not score shares by default, got %v %v", decay, err)
+	}
+	cfg.ShareWeighting = ShareWeightingScore
+	if decay, _ := cfg.ShareScoreDecay(); decay != defaultScoreDecay {
+		t.Errorf("Must decay by %v by default, got %v", defaultScoreDecay, decay)
+	}
+	cfg.ScoreDecay = "30m"
+	if decay, _ := cfg.ShareScoreDecay(); decay != 30*time.Minute {
+		t.Errorf("Must decay by 30m, got %v", decay)
+	}
+	cfg.ScoreDecay = "10ms"
+	if _, err := cfg.ShareScoreDecay(); err == nil {
+		t.Error("Must reject a decay under a second")
+	}
+	cfg.ShareWeighting = "pps"
+	if _, err := cfg.ShareScoreDecay(); err == nil {
+		t.Error("Must reject an unknown weighting")
+	}
+}
+
+func TestFinderBonus(t *testing.T) {
+	reward, _ := new(big.Rat).SetString("2000000000000000000")
+	minersProfit, _ := chargeFee(reward, 1.0)
+
+	bonus := FinderBonusConfig{Percent: 5}.bonus(reward, minersProfit)
+	if weiToShannonInt64(bonus) != 100000000 {
+		t.Errorf("Expected 5%% of the block reward, got %v", bonus.FloatString(0))
+	}
+	bonus = FinderBonusConfig{Amount: 3000000000}.bonus(reward, minersProfit)
+	if bonus.Cmp(minersProfit) != 0 {
+		t.Errorf("Must cap the bonus to the miners' profit, got %v", bonus.FloatString(0))
+	}
+	if (FinderBonusConfig{Percent: 1, Amount: 1}).validate() == nil {
+		t.Error("Must refuse both a percent and an amount")
+	}
+}
+
 func TestGetUncleReward(t *testing.T) {
 	rewards := make(map[int64]string)
 	expectedRewards := map[int64]string{
@@ -80,7 +200,7 @@ func TestGetUncleReward(t *testing.T) {
 		7: "625000000000000000",
 	}
 	for i := int64(1); i < 8; i++ {
-		rewards[i] = types.GetUncleReward(1, i+1, mainnetFlag).String()
+		rewards[i] = testForks(t, 1).UncleReward(1, i+1).String()
 	}
 	for i, reward := range rewards {
 		if expectedRewards[i] != rewards[i] {
@@ -101,7 +221,7 @@ func TestGetByzantiumUncleReward(t *testing.T) {
 		7: "375000000000000000",
 	}
 	for i := int64(1); i < 8; i++ {
-		rewards[i] = types.GetUncleReward(byzantiumHardForkHeight, byzantiumHardForkHeight+i, mainnetFlag).String()
+		rewards[i] = testForks(t, 1).UncleReward(byzantiumHardForkHeight, byzantiumHardForkHeight+i).String()
 	}
 	for i, reward := range rewards {
 		if expectedRewards[i] != rewards[i] {
@@ -122,7 +242,7 @@ func TestGetCarrotUncleReward(t *testing.T) {
 		7: "412500000000000000",
 	}
 	for i := int64(1); i < 8; i++ {
-		rewards[i] = types.GetUncleReward(types.CarrathardforkheightMainnet, types.CarrathardforkheightMainnet+i).String()
+		rewards[i] = types.GetUncleReward(types.CarrathardforkheightMainnet, types.CarrathardforkheightMainnet+i, mainnetFlag).String()
 	}
 	for i, reward := range rewards {
 		if expectedRewards[i] != rewards[i] {
@@ -135,13 +255,13 @@ func TestGetCarrotRewardForUngle(t *testing.T) {
 	reward := types.GetRewardForUncle(types.CarrathardforkheightMainnet, mainnetFlag).String()
 	expectedReward := "103125000000000000"
 	if expectedReward != reward {
-		t.Errorf("Incorrect uncle bonus for height %v, expected %v vs %v", byzantiumHardForkHeight, expectedReward, reward)
+		t.Errorf("Incorrect uncle bonus for height %v, expected %v vs %v", types.CarrathardforkheightMainnet, expectedReward, reward)
 	}
 }
 
 
 func TestGetRewardForUngle(t *testing.T) {
-	reward := types.GetRewardForUncle(1, mainnetFlag).String()
+	reward := testForks(t, 1).RewardForUncle(1).String()
 	expectedReward := "156250000000000000"
 	if expectedReward != reward {
 		t.Errorf("Incorrect uncle bonus for height %v, expected %v vs %v", 1, expectedReward, reward)
@@ -149,13 +269,45 @@ func TestGetRewardForUngle(t *testing.T) {
 }
 
 func TestGetByzantiumRewardForUngle(t *testing.T) {
-	reward := types.GetRewardForUncle(byzantiumHardForkHeight, mainnetFlag).String()
+	reward := testForks(t, 1).RewardForUncle(byzantiumHardForkHeight).String()
 	expectedReward := "93750000000000000"
 	if expectedReward != reward {
 		t.Errorf("Incorrect uncle bonus for height %v, expected %v vs %v", byzantiumHardForkHeight, expectedReward, reward)
 	}
 }
 
+func TestConstantinopleReward(t *testing.T) {
+	forks := testForks(t, 1)
+	if reward := forks.ConstReward(7280000).String(); reward != "2000000000000000000" {
+		t.Errorf("Incorrect block reward at Constantinople, expected 2 ETH vs %v", reward)
+	}
+	if reward := forks.ConstReward(7279999).String(); reward != "3000000000000000000" {
+		t.Errorf("Incorrect block reward before Constantinople, expected 3 ETH vs %v", reward)
+	}
+	if forks.BurnsBaseFee(12964999) || !forks.BurnsBaseFee(12965000) {
+		t.Error("Base fee must be burned from London")
+	}
+}
+
+func TestConfiguredForks(t *testing.T) {
+	configured := map[string]*types.Forks{
+		"7": {GenesisReward: "4000000000000000000", Rewards: []types.ForkReward{{Name: "byzantium", Height: 100, Reward: "2000000000000000000"}}},
+	}
+	forks, err := types.ForksFor(7, "mainnet", configured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forks.ConstReward(99).String() != "4000000000000000000" || forks.ConstReward(100).String() != "2000000000000000000" {
+		t.Error("Must use the configured table of the netId")
+	}
+	if forks.BurnsBaseFee(1000) {
+		t.Error("Base fee must not be burned without London")
+	}
+	if reward := testForks(t, 7).ConstReward(types.CarrathardforkheightMainnet).String(); reward != types.CarratReward.String() {
+		t.Errorf("Unknown netId must use the Dangnn table, got %v", reward)
+	}
+}
+
 func TestMatchCandidate(t *testing.T) {
 	gethBlock := &rpc.GetBlockReply{Hash: "0x12345A", Nonce: "0x1A"}
 	parityBlock := &rpc.GetBlockReply{Hash: "0x12345A", SealFields: []string{"0x0A", "0x1A"}}
@@ -181,3 +333,27 @@ func TestMatchCandidate(t *testing.T) {
 		t.Error("Must match with hash")
 	}
 }
+
+func TestCandidateDedupe(t *testing.T) {
+	dedupe := newCandidateDedupe()
+	candidate := &types.BlockData{Nonce: "0x1A", PowHash: "0xABC"}
+	resubmit := &types.BlockData{Nonce: "0x1a", PowHash: "0xabc", RoundHeight: 10}
+	other := &types.BlockData{Nonce: "0x1a", PowHash: "0xdef"}
+
+	if dedupe.seenSubmission(candidate) {
+		t.Error("Must not mark first submission as duplicate")
+	}
+	if !dedupe.seenSubmission(resubmit) {
+		t.Error("Must mark same nonce and pow hash as duplicate")
+	}
+	if dedupe.seenSubmission(other) {
+		t.Error("Must not mark different pow hash as duplicate")
+	}
+
+	if dedupe.seenHash("0x12345A") {
+		t.Error("Must not mark first hash as duplicate")
+	}
+	if !dedupe.seenHash("0x12345a") {
+		t.Error("Must mark same block hash as duplicate")
+	}
+}
@@ -0,0 +1,189 @@
+package payouts
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type WalletConfig struct {
+	// Check the balance of the payout address, the hot wallet, against the payees over their threshold
+	Enabled bool `json:"enabled"`
+	// "10m" by default
+	Interval string `json:"interval"`
+	// In Wei, kept on top of the payees due, e.g. for gas. Alert when the balance is below both
+	MinReserve string `json:"minReserve"`
+	// Sweep what's above sweepAbove Wei, and above the payees due and the reserve, to coldAddress.
+	// Empty coldAddress only alerts
+	ColdAddress string `json:"coldAddress"`
+	SweepAbove  string `json:"sweepAbove"`
+	// In Wei, smaller sweeps aren't worth their gas
+	MinSweep string `json:"minSweep"`
+}
+
+// walletMonitor holds the state of the hot wallet checks, to only log a low balance when it falls.
+type walletMonitor struct {
+	config   *WalletConfig
+	interval time.Duration
+	low      bool
+}
+
+func newWalletMonitor(cfg *PayoutsConfig) (*walletMonitor, error) {
+	m := &walletMonitor{config: &cfg.Wallet, interval: 10 * time.Minute}
+	if len(cfg.Wallet.Interval) > 0 {
+		m.interval = util.MustParseDuration(cfg.Wallet.Interval)
+	}
+	if m.interval <= 0 {
+		return nil, fmt.Errorf("invalid interval %v", cfg.Wallet.Interval)
+	}
+	if len(cfg.Wallet.ColdAddress) == 0 {
+		return m, nil
+	}
+	if !util.IsValidHexAddress(cfg.Wallet.ColdAddress) {
+		return nil, fmt.Errorf("invalid coldAddress %v", cfg.Wallet.ColdAddress)
+	}
+	if strings.EqualFold(cfg.Wallet.ColdAddress, cfg.Address) {
+		return nil, fmt.Errorf("coldAddress is the payout address")
+	}
+	if util.String2Big(cfg.Wallet.SweepAbove).Sign() <= 0 {
+		return nil, fmt.Errorf("sweepAbove is required to sweep")
+	}
+	return m, nil
+}
+
+// payoutsDue is what the hot wallet must hold for the payees over their threshold and the reserve.
+func (u *PayoutsProcessor) payoutsDue() (*big.Int, error) {
+	baseBalance := u.GetReachedThreshold()
+	if minLimit, _ := u.config.PayoutLimits(); minLimit < baseBalance.Int64() {
+		baseBalance = big.NewInt(minLimit)
+	}
+	payees, err := u.db.GetPayees(baseBalance.String())
+	if metrics.MysqlError(u.config.Name, "get_payees", err) != nil {
+		return nil, err
+	}
+	required := util.String2Big(u.wallet.config.MinReserve)
+	for _, payee := range payees {
+		if u.payeeReachedThreshold(payee) {
+			required.Add(required, u.payoutWei(payee, 0))
+		}
+	}
+	return required, nil
+}
+
+// checkWallet alerts while the hot wallet holds less than the payees due need, and sweeps the
+// excess to the cold address.
+func (u *PayoutsProcessor) checkWallet() {
+	balance, err := u.rpc.GetBalance(u.config.Address)
+	if err != nil {
+		log.Errorf("Failed to get the balance of %v: %v", u.config.Address, err)
+		return
+	}
+	required, err := u.payoutsDue()
+	if err != nil {
+		log.Errorf("Failed to get the payees due: %v", err)
+		return
+	}
+	metrics.PayoutWalletBalance.Set(shannon(balance), u.config.Name)
+	metrics.PayoutWalletRequired.Set(shannon(required), u.config.Name)
+
+	if balance.Cmp(required) < 0 {
+		if !u.wallet.low {
+			u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, u.config.Address, "",
+				"Hot wallet %v holds %v Wei, the payees due need %v Wei", u.config.Address, balance, required)
+		}
+		u.wallet.low = true
+		u.alerts.Fire(alerts.EventWalletLow, u.config.Address, "Hot wallet %v holds %v Wei, the payees due need %v Wei",
+			u.config.Address, balance, required)
+		return
+	}
+	if u.wallet.low {
+		log.Infof("Hot wallet %v holds %v Wei again, the payees due need %v Wei", u.config.Address, balance, required)
+	}
+	u.wallet.low = false
+
+	if len(u.wallet.config.ColdAddress) > 0 {
+		u.sweep(balance, required)
+	}
+}
+
+// sweep sends the balance above sweepAbove and the payees due to the cold address, less the gas. It
+// waits for the payout txs still pending, their value isn't out of the balance yet.
+func (u *PayoutsProcessor) sweep(balance, required *big.Int) {
+	if u.halt {
+		return
+	}
+	keep := util.String2Big(u.wallet.config.SweepAbove)
+	if required.Cmp(keep) > 0 {
+		keep = required
+	}
+	if balance.Cmp(keep) <= 0 {
+		return
+	}
+	pending, err := u.rpc.GetPendingNonce(u.config.Address)
+	if err != nil {
+		log.Errorf("Failed to get nonce: %v", err)
+		return
+	}
+	latest, err := u.rpc.GetLatestNonce(u.config.Address)
+	if err != nil {
+		log.Errorf("Failed to get nonce: %v", err)
+		return
+	}
+	if pending != latest {
+		log.Infof("Sweep of %v waits for %v pending txs", u.config.Address, pending-latest)
+		return
+	}
+	if !u.checkPeers() || !u.isUnlockedAccount() {
+		return
+	}
+
+	to := u.wallet.config.ColdAddress
+	amount := new(big.Int).Sub(balance, keep)
+	var quote *gasQuote
+	fee := new(big.Int).Mul(util.String2Big(u.config.Gas), util.String2Big(u.config.GasPrice))
+	// Local signing needs explicit gas values even with autoGas.
+	if !u.config.AutoGas || u.signer != nil {
+		quote, err = u.quoteGas(to, amount)
+		if err != nil {
+			log.Warnf("Sweep of %v skipped: %v", u.config.Address, err)
+			return
+		}
+		fee = quote.maxCost()
+	}
+	amount.Sub(amount, fee)
+	if amount.Sign() <= 0 || amount.Cmp(util.String2Big(u.wallet.config.MinSweep)) < 0 {
+		return
+	}
+
+	tx, err := u.sendTransaction(to, amount, nil, quote)
+	if err != nil {
+		u.logs.InsertSystemPaymemtError(plogger.LogTypePaymentWork, u.config.Address, to,
+			"Failed to sweep %v Wei to %v: %v", amount, to, err)
+		return
+	}
+	metrics.PayoutsSwept.Inc(u.config.Name)
+	msg := fmt.Sprintf("Swept %v Wei to cold wallet %v, keeping %v Wei, TxHash: %v", amount, to, keep, tx.hash())
+	log.Info(msg)
+	u.logs.InsertLog(msg, plogger.LogTypePaymentWork, plogger.LogSubTypePaymentSweep, 0, 0, u.config.Address, to)
+}
+
+// maxCost is the most the tx may cost in gas, in Wei.
+func (q *gasQuote) maxCost() *big.Int {
+	price := q.gasPrice
+	if q.dynamic {
+		price = q.maxFee
+	}
+	return new(big.Int).Mul(q.gas, price)
+}
+
+// shannon is wei in Shannon, for the metrics.
+func shannon(wei *big.Int) float64 {
+	f, _ := new(big.Rat).SetFrac(wei, util.Shannon).Float64()
+	return f
+}
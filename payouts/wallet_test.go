@@ -0,0 +1,42 @@
+package payouts
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestWalletMonitorConfig(t *testing.T) {
+	cfg := &PayoutsConfig{Address: "0x0000000000000000000000000000000000000001"}
+	m, err := newWalletMonitor(cfg)
+	if err != nil || m.interval.Minutes() != 10 {
+		t.Errorf("Must check every 10 minutes by default, got %v %v", m, err)
+	}
+
+	cfg.Wallet.ColdAddress = "0x0000000000000000000000000000000000000002"
+	if _, err := newWalletMonitor(cfg); err == nil {
+		t.Error("Must require sweepAbove to sweep")
+	}
+	cfg.Wallet.SweepAbove = "1000000000000000000"
+	if _, err := newWalletMonitor(cfg); err != nil {
+		t.Errorf("Must accept a sweep config, got %v", err)
+	}
+	cfg.Wallet.ColdAddress = cfg.Address
+	if _, err := newWalletMonitor(cfg); err == nil {
+		t.Error("Must refuse to sweep to the payout address")
+	}
+	cfg.Wallet.ColdAddress = "cold"
+	if _, err := newWalletMonitor(cfg); err == nil {
+		t.Error("Must refuse an invalid coldAddress")
+	}
+}
+
+func TestGasQuoteMaxCost(t *testing.T) {
+	legacy := &gasQuote{gas: big.NewInt(21000), gasPrice: big.NewInt(10)}
+	if cost := legacy.maxCost(); cost.Cmp(big.NewInt(210000)) != 0 {
+		t.Errorf("Must cost gas * gasPrice, got %v", cost)
+	}
+	dynamic := &gasQuote{dynamic: true, gas: big.NewInt(21000), maxFee: big.NewInt(30), effectivePrice: big.NewInt(12)}
+	if cost := dynamic.maxCost(); cost.Cmp(big.NewInt(630000)) != 0 {
+		t.Errorf("Must cost gas * maxFee, got %v", cost)
+	}
+}
@@ -0,0 +1,49 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+// auditStats is the share audits of one worker since the last reset.
+type auditStats struct {
+	audited    int32
+	mismatched int32
+	reported   bool
+}
+
+// ApplyAuditPolicy counts an audited share of login.worker. Once the worker had auditCheckThreshold
+// audits, a mismatch rate of auditMismatchPercent or more reports it, and bans its IP with banning.
+func (s *PolicyServer) ApplyAuditPolicy(ip, login, worker string, mismatch bool) bool {
+	s.auditsMu.Lock()
+	key := login + "." + worker
+	st, ok := s.audits[key]
+	if !ok {
+		st = &auditStats{}
+		s.audits[key] = st
+	}
+	st.audited++
+	if mismatch {
+		st.mismatched++
+	}
+	audited, mismatched := st.audited, st.mismatched
+	threshold := s.cfg().Banning.AuditCheckThreshold
+	rate := float32(mismatched) / float32(audited) * 100
+	over := threshold > 0 && audited >= threshold && rate >= s.cfg().Banning.AuditMismatchPercent && mismatched > 0
+	report := over && !st.reported
+	if report {
+		st.reported = true
+	}
+	s.auditsMu.Unlock()
+
+	if !over {
+		return true
+	}
+	if report {
+		msg := fmt.Sprintf("AUDIT %v.%v@%v %v of %v audited shares don't verify", login, worker, ip, mismatched, audited)
+		s.logs.InsertLog(msg, plogger.LogTypeSystem, plogger.LogSubTypeFakeShare, 0, 0, login, "")
+	}
+	s.forceBan(s.Get(ip), ip, "audit mismatches")
+	return false
+}
@@ -0,0 +1,253 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+// ban is a temporary ban of an IP or a login for timeout seconds. Bans synced from Redis were
+// recorded by another proxy, or before a restart, and are only applied.
+type ban struct {
+	kind    string
+	value   string
+	reason  string
+	timeout int64
+	synced  bool
+}
+
+// loginStats is the check window of the shares of a login across its IPs.
+type loginStats struct {
+	sync.Mutex
+	valid   int32
+	invalid int32
+	stale   int32
+}
+
+func (s *PolicyServer) loginTimeout() int64 {
+	if s.cfg().Banning.LoginTimeout > 0 {
+		return s.cfg().Banning.LoginTimeout
+	}
+	return s.cfg().Banning.Timeout
+}
+
+func (s *PolicyServer) getLogin(login string) *loginStats {
+	s.loginsMu.Lock()
+	defer s.loginsMu.Unlock()
+	x, ok := s.logins[login]
+	if !ok {
+		x = &loginStats{}
+		s.logins[login] = x
+	}
+	return x
+}
+
+func (s *PolicyServer) countLoginStale(login string) {
+	if !s.cfg().Banning.LoginBan || len(login) == 0 {
+		return
+	}
+	x := s.getLogin(login)
+	x.Lock()
+	x.stale++
+	x.Unlock()
+}
+
+// applyLoginSharePolicy counts a share of the login, false if the login is or got banned.
+func (s *PolicyServer) applyLoginSharePolicy(login string, validShare bool) bool {
+	if len(login) == 0 {
+		return true
+	}
+	if s.IsLoginBanned(login) {
+		return false
+	}
+	if !s.cfg().Banning.LoginBan {
+		return true
+	}
+	x := s.getLogin(login)
+	x.Lock()
+	if validShare {
+		x.valid++
+	} else {
+		x.invalid++
+	}
+	if x.valid+x.invalid < s.cfg().Banning.CheckThreshold {
+		x.Unlock()
+		return true
+	}
+	valid, invalid, stale := x.valid, x.invalid, x.stale
+	x.valid, x.invalid, x.stale = 0, 0, 0
+	x.Unlock()
+
+	if reason := judgeShares(&s.cfg().Banning, valid, invalid, stale); len(reason) > 0 {
+		s.banLogin(login, reason)
+		return false
+	}
+	return true
+}
+
+// ApplyChurnPolicy counts a connection from ip, false if it got banned for reconnecting too often.
+func (s *PolicyServer) ApplyChurnPolicy(ip string) bool {
+	x := s.Get(ip)
+	n := atomic.AddInt32(&x.Connects, 1)
+	if limit := s.cfg().Banning.ChurnLimit; limit > 0 && n > limit {
+		s.forceBan(x, ip, "connection churn")
+		return false
+	}
+	return true
+}
+
+func (s *PolicyServer) IsLoginBanned(login string) bool {
+	s.loginsMu.Lock()
+	defer s.loginsMu.Unlock()
+	until, ok := s.loginBans[login]
+	return ok && until > util.MakeTimestamp()/1000
+}
+
+func (s *PolicyServer) banLogin(login, reason string) {
+	if !s.cfg().Banning.Enabled {
+		return
+	}
+	timeout := s.loginTimeout()
+	s.loginsMu.Lock()
+	until, banned := s.loginBans[login]
+	now := util.MakeTimestamp() / 1000
+	if banned && until > now {
+		s.loginsMu.Unlock()
+		return
+	}
+	s.loginBans[login] = now + timeout
+	s.loginsMu.Unlock()
+	s.banChannel <- &ban{kind: redis.BanKindLogin, value: login, reason: reason, timeout: timeout}
+}
+
+// resetLogins starts new check windows for the logins and drops the login bans over at now.
+func (s *PolicyServer) resetLogins(now int64) {
+	s.loginsMu.Lock()
+	defer s.loginsMu.Unlock()
+	s.logins = make(map[string]*loginStats)
+	for login, until := range s.loginBans {
+		if until <= now {
+			log.Printf("Ban dropped for login %v", login)
+			delete(s.loginBans, login)
+		}
+	}
+}
+
+// doBan applies a new ban to ipset, and records it in Redis for the other proxies and restarts.
+func (s *PolicyServer) doBan(b *ban) {
+	set := s.cfg().Banning.IPSet
+	if b.kind == redis.BanKindIP && len(set) > 0 {
+		cmd := fmt.Sprintf("sudo ipset add %s %s timeout %v -!", set, b.value, b.timeout)
+		args := strings.Fields(cmd)
+		head := args[0]
+		args = args[1:]
+
+		log.Printf("Banned %v with timeout %v on ipset %s", b.value, b.timeout, set)
+
+		_, err := exec.Command(head, args...).Output()
+		if err != nil {
+			log.Printf("CMD Error: %s", err)
+		}
+	} else {
+		log.Printf("Banned %v %v for %vs: %v", b.kind, b.value, b.timeout, b.reason)
+	}
+	if b.synced {
+		return
+	}
+
+	metrics.Bans.Inc(s.pool, b.kind, b.reason)
+	var login string
+	if b.kind == redis.BanKindLogin {
+		login = b.value
+	}
+	s.logs.InsertLog(fmt.Sprintf("BAN %v %v for %vs: %v", b.kind, b.value, b.timeout, b.reason), plogger.LogTypeSystem, plogger.LogSubTypeBan, 0, 0, login, "")
+
+	until := util.MakeTimestamp()/1000 + b.timeout
+	if err := s.storage.WriteBan(b.kind, b.value, b.reason, until); err != nil {
+		log.Printf("Failed to record the ban of %v %v: %v", b.kind, b.value, err)
+		return
+	}
+	if _, err := s.storage.Publish(redis.ChannelProxy, redis.OpcodeBans, "", redis.ChannelProxy); err != nil {
+		log.Printf("Failed to publish the ban of %v %v: %v", b.kind, b.value, err)
+	}
+}
+
+// RefreshBans applies the bans recorded in Redis that this proxy doesn't have yet. They end when
+// recorded, whatever the timeouts of this proxy.
+func (s *PolicyServer) RefreshBans() {
+	now := util.MakeTimestamp() / 1000
+	bans, err := s.storage.GetBans(now)
+	if err != nil {
+		log.Printf("Failed to get bans from backend: %v", err)
+		return
+	}
+	if !s.cfg().Banning.Enabled {
+		return
+	}
+	applied := 0
+	for _, b := range bans {
+		switch b.Kind {
+		case redis.BanKindIP:
+			if s.InForceBanWhiteList(b.Value) {
+				continue
+			}
+			x := s.Get(b.Value)
+			// resetStats drops the ban timeout after BannedAt
+			atomic.StoreInt64(&x.BannedAt, (b.Until-s.cfg().Banning.Timeout)*1000)
+			if atomic.CompareAndSwapInt32(&x.Banned, 0, 1) {
+				applied++
+				s.banChannel <- &ban{kind: b.Kind, value: b.Value, reason: b.Reason, timeout: b.Until - now, synced: true}
+			}
+		case redis.BanKindLogin:
+			s.loginsMu.Lock()
+			if s.loginBans[b.Value] < b.Until {
+				applied++
+				s.loginBans[b.Value] = b.Until
+			}
+			s.loginsMu.Unlock()
+		}
+	}
+	if applied > 0 {
+		log.Printf("Applied %v bans from backend", applied)
+	}
+}
+
+// LiftBan ends the ban of an IP or a login on this proxy, with a fresh start for its stats.
+func (s *PolicyServer) LiftBan(kind, value string) {
+	switch kind {
+	case redis.BanKindIP:
+		s.statsMu.Lock()
+		x, ok := s.stats[value]
+		delete(s.stats, value)
+		s.statsMu.Unlock()
+		if !ok || atomic.LoadInt32(&x.Banned) == 0 {
+			return
+		}
+		if set := s.cfg().Banning.IPSet; len(set) > 0 {
+			_, err := exec.Command("sudo", "ipset", "del", set, value, "-!").Output()
+			if err != nil {
+				log.Printf("CMD Error: %s", err)
+			}
+		}
+	case redis.BanKindLogin:
+		s.loginsMu.Lock()
+		_, ok := s.loginBans[value]
+		delete(s.loginBans, value)
+		delete(s.logins, value)
+		s.loginsMu.Unlock()
+		if !ok {
+			return
+		}
+	default:
+		return
+	}
+	log.Printf("Ban lifted for %v %v", kind, value)
+}
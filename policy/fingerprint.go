@@ -0,0 +1,50 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+// swarm is the IPs that logged in to one login with one client fingerprint since the last reset.
+type swarm struct {
+	ips      map[string]struct{}
+	reported bool
+}
+
+// ApplyFingerprintPolicy counts the IP under the login's fingerprint. Once more than swarmLimit IPs
+// share it, the swarm is reported, and with swarmBan the IPs over the limit are banned.
+func (s *PolicyServer) ApplyFingerprintPolicy(ip, login, fingerprint string) bool {
+	limit := s.cfg().Banning.SwarmLimit
+	if limit <= 0 {
+		return true
+	}
+
+	s.swarmsMu.Lock()
+	key := login + "/" + fingerprint
+	sw, ok := s.swarms[key]
+	if !ok {
+		sw = &swarm{ips: make(map[string]struct{})}
+		s.swarms[key] = sw
+	}
+	sw.ips[ip] = struct{}{}
+	n := len(sw.ips)
+	report := n > limit && !sw.reported
+	if report {
+		sw.reported = true
+	}
+	s.swarmsMu.Unlock()
+
+	if n <= limit {
+		return true
+	}
+	if report {
+		msg := fmt.Sprintf("SWARM %v IPs on %v with fingerprint %v, last %v", n, login, fingerprint, ip)
+		s.logs.InsertLog(msg, plogger.LogTypeSystem, plogger.LogSubTypeSwarm, 0, 0, login, "")
+	}
+	if !s.cfg().Banning.SwarmBan || !s.cfg().Banning.Enabled || s.InForceBanWhiteList(ip) {
+		return true
+	}
+	s.forceBan(s.Get(ip), ip, "swarm")
+	return false
+}
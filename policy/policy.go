@@ -1,17 +1,15 @@
 package policy
 
 import (
-	"fmt"
 	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
 	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
 	"log"
-	"os/exec"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
 	mapset "github.com/deckarep/golang-set"
 )
 
@@ -38,6 +36,24 @@ type Banning struct {
 	InvalidPercent float32 `json:"invalidPercent"`
 	CheckThreshold int32   `json:"checkThreshold"`
 	MalformedLimit int32   `json:"malformedLimit"`
+	// Duplicate shares of one worker per reset interval that ban its IP, 0 for no limit
+	DuplicateLimit int32 `json:"duplicateLimit"`
+	// IPs of one login with the same client fingerprint per reset interval that are reported as a swarm, 0 to disable
+	SwarmLimit int `json:"swarmLimit"`
+	// Ban the IPs over swarmLimit instead of only reporting them
+	SwarmBan bool `json:"swarmBan"`
+	// Share audits of one worker per reset interval before its mismatch rate is judged, 0 to only count them
+	AuditCheckThreshold int32 `json:"auditCheckThreshold"`
+	// Percent of audited shares not verifying that reports the worker and bans its IP
+	AuditMismatchPercent float32 `json:"auditMismatchPercent"`
+	// Percent of stale shares to valid ones per checkThreshold shares that bans, 0 counts them as invalid shares
+	StalePercent float32 `json:"stalePercent"`
+	// Connections from one IP per reset interval over which it is banned, 0 for no limit
+	ChurnLimit int32 `json:"churnLimit"`
+	// Also judge the shares of each login across its IPs, and ban the login from every IP when over the limits
+	LoginBan bool `json:"loginBan"`
+	// Seconds a login stays banned, timeout by default
+	LoginTimeout int64 `json:"loginTimeout"`
 }
 
 type Stats struct {
@@ -48,7 +64,9 @@ type Stats struct {
 	BannedAt      int64
 	ValidShares   int32
 	InvalidShares int32
+	StaleShares   int32
 	Malformed     int32
+	Connects      int32
 	ConnLimit     int32
 	Banned        int32
 	Logined		 bool
@@ -62,9 +80,10 @@ type AlarmBeat struct {
 type PolicyServer struct {
 	sync.RWMutex
 	statsMu    sync.Mutex
-	config     *Config
+	// *Config, swapped whole on a config reload
+	config     atomic.Value
 	stats      map[string]*Stats
-	banChannel chan string
+	banChannel chan *ban
 	startedAt  int64
 	grace      int64
 	timeout    int64
@@ -75,31 +94,52 @@ type PolicyServer struct {
 	allowAllId bool
 	storage   *redis.RedisClient
 	db 		   *mysql.Database
+	pool       string
+	logs       *plogger.Logger
+
+	duplicatesMu sync.Mutex
+	duplicates   map[string]int32
+
+	swarmsMu sync.Mutex
+	swarms   map[string]*swarm
+
+	auditsMu sync.Mutex
+	audits   map[string]*auditStats
+
+	loginsMu  sync.Mutex
+	logins    map[string]*loginStats
+	loginBans map[string]int64
 
 	alarmBeatsMu sync.RWMutex
 	alarmBeats map[string]*AlarmBeat
 	beatIntv time.Duration
 }
 
-func Start(cfg *Config, storage *redis.RedisClient, db *mysql.Database) *PolicyServer {
-	s := &PolicyServer{config: cfg, startedAt: util.MakeTimestamp()}
+func Start(cfg *Config, storage *redis.RedisClient, db *mysql.Database, pool string) *PolicyServer {
+	s := &PolicyServer{startedAt: util.MakeTimestamp(), pool: pool, logs: plogger.For(pool)}
+	s.config.Store(cfg)
 	grace := util.MustParseDuration(cfg.Limits.Grace)
 	s.grace = int64(grace / time.Millisecond)
-	s.banChannel = make(chan string, 64)
+	s.banChannel = make(chan *ban, 64)
 	s.stats = make(map[string]*Stats)
 	s.alarmBeats = make(map[string]*AlarmBeat)
+	s.duplicates = make(map[string]int32)
+	s.swarms = make(map[string]*swarm)
+	s.audits = make(map[string]*auditStats)
+	s.logins = make(map[string]*loginStats)
+	s.loginBans = make(map[string]int64)
 	s.storage = storage
 	s.db = db
 	s.refreshState()
 
-	timeout := util.MustParseDuration(s.config.ResetInterval)
+	timeout := util.MustParseDuration(s.cfg().ResetInterval)
 	s.timeout = int64(timeout / time.Millisecond)
 
-	resetIntv := util.MustParseDuration(s.config.ResetInterval)
+	resetIntv := util.MustParseDuration(s.cfg().ResetInterval)
 	resetTimer := time.NewTimer(resetIntv)
 	log.Printf("Set policy stats reset every %v", resetIntv)
 
-	minerShareCheckBeatIntv := util.MustParseDuration(s.config.MinerShareCheckBeatInterval)
+	minerShareCheckBeatIntv := util.MustParseDuration(s.cfg().MinerShareCheckBeatInterval)
 	s.beatIntv = minerShareCheckBeatIntv
 	s.InitAlarmBeat(minerShareCheckBeatIntv)
 
@@ -116,19 +156,36 @@ func Start(cfg *Config, storage *redis.RedisClient, db *mysql.Database) *PolicyS
 		}
 	}()
 
-	for i := 0; i < s.config.Workers; i++ {
+	for i := 0; i < s.cfg().Workers; i++ {
 		s.startPolicyWorker()
 	}
-	log.Printf("Running with %v policy workers", s.config.Workers)
+	log.Printf("Running with %v policy workers", s.cfg().Workers)
+	// After the workers, which apply the bans to ipset
+	s.RefreshBans()
 	return s
 }
 
+func (s *PolicyServer) cfg() *Config {
+	return s.config.Load().(*Config)
+}
+
+// Reload applies the banning and limits of cfg. Workers and intervals only change on a restart.
+func (s *PolicyServer) Reload(cfg *Config) {
+	next := *s.cfg()
+	next.Banning = cfg.Banning
+	next.Limits.Enabled = cfg.Limits.Enabled
+	next.Limits.Limit = cfg.Limits.Limit
+	next.Limits.LimitJump = cfg.Limits.LimitJump
+	s.config.Store(&next)
+	log.Printf("Reloaded policy: banning %v, limits %v", next.Banning.Enabled, next.Limits.Enabled)
+}
+
 func (s *PolicyServer) startPolicyWorker() {
 	go func() {
 		for {
 			select {
-			case ip := <-s.banChannel:
-				s.doBan(ip)
+			case b := <-s.banChannel:
+				s.doBan(b)
 			}
 		}
 	}()
@@ -136,7 +193,7 @@ func (s *PolicyServer) startPolicyWorker() {
 
 func (s *PolicyServer) resetStats() {
 	now := util.MakeTimestamp()
-	banningTimeout := s.config.Banning.Timeout * 1000
+	banningTimeout := s.cfg().Banning.Timeout * 1000
 	total := 0
 	s.statsMu.Lock()
 	defer s.statsMu.Unlock()
@@ -157,8 +214,23 @@ func (s *PolicyServer) resetStats() {
 			delete(s.stats, key)
 			total++
 		}
+		atomic.StoreInt32(&m.Connects, 0)
 	}
 	log.Printf("Flushed stats for %v IP addresses", total)
+
+	s.duplicatesMu.Lock()
+	s.duplicates = make(map[string]int32)
+	s.duplicatesMu.Unlock()
+
+	s.swarmsMu.Lock()
+	s.swarms = make(map[string]*swarm)
+	s.swarmsMu.Unlock()
+
+	s.auditsMu.Lock()
+	s.audits = make(map[string]*auditStats)
+	s.auditsMu.Unlock()
+
+	s.resetLogins(now / 1000)
 }
 
 func (s *PolicyServer) refreshState() {
@@ -300,7 +372,7 @@ func (s *PolicyServer) modifyAlarmList(tmpDeleteList []string, alarmBeat mapset.
 
 func (s *PolicyServer) NewStats() *Stats {
 	x := &Stats{
-		ConnLimit: s.config.Limits.Limit,
+		ConnLimit: s.cfg().Limits.Limit,
 	}
 	x.heartbeat()
 	return x
@@ -322,7 +394,7 @@ func (s *PolicyServer) Get(ip string) *Stats {
 
 func (s *PolicyServer) BanClient(ip string) {
 	x := s.Get(ip)
-	s.forceBan(x, ip)
+	s.forceBan(x, ip, "refused client")
 }
 
 func (s *PolicyServer) IsBanned(ip string) bool {
@@ -331,7 +403,7 @@ func (s *PolicyServer) IsBanned(ip string) bool {
 }
 
 func (s *PolicyServer) ApplyLimitPolicy(ip string) bool {
-	if !s.config.Limits.Enabled {
+	if !s.cfg().Limits.Enabled {
 		return true
 	}
 	now := util.MakeTimestamp()
@@ -345,9 +417,11 @@ func (s *PolicyServer) ApplyLoginPolicy(addy, ip string) bool {
 	if s.inboundId == nil {
 		// If you do not get blacklist information, you cannot log in.
 		return false
+	} else if s.IsLoginBanned(addy) {
+		return false
 	} else if s.InIdBlackList(addy) {
 		x := s.Get(ip)
-		s.forceBan(x, ip)
+		s.forceBan(x, ip, "blacklisted login")
 		log.Printf("Invalid addr : %v", addy)
 		return false
 	}
@@ -357,8 +431,23 @@ func (s *PolicyServer) ApplyLoginPolicy(addy, ip string) bool {
 func (s *PolicyServer) ApplyMalformedPolicy(ip string) bool {
 	x := s.Get(ip)
 	n := x.incrMalformed()
-	if n >= s.config.Banning.MalformedLimit {
-		s.forceBan(x, ip)
+	if n >= s.cfg().Banning.MalformedLimit {
+		s.forceBan(x, ip, "malformed requests")
+		return false
+	}
+	return true
+}
+
+// ApplyDuplicatePolicy counts a duplicate share of the worker, false if its IP got banned.
+func (s *PolicyServer) ApplyDuplicatePolicy(ip, login, worker string) bool {
+	s.duplicatesMu.Lock()
+	s.duplicates[login+"."+worker]++
+	n := s.duplicates[login+"."+worker]
+	s.duplicatesMu.Unlock()
+
+	if s.cfg().Banning.DuplicateLimit > 0 && n >= s.cfg().Banning.DuplicateLimit {
+		log.Printf("Duplicate share limit reached by %v.%v@%v", login, worker, ip)
+		s.forceBan(s.Get(ip), ip, "duplicate shares")
 		return false
 	}
 	return true
@@ -407,55 +496,80 @@ func (s *PolicyServer) InitAlarmBeat(beatIntv time.Duration) {
 	s.storage.InitAlarmBeat(list, beatIntv)
 }
 
-func (s *PolicyServer) ApplySharePolicy(ip string, validShare bool) bool {
+func (s *PolicyServer) ApplySharePolicy(ip, login string, validShare bool) bool {
+	if !s.applyLoginSharePolicy(login, validShare) {
+		return false
+	}
 	x := s.Get(ip)
 	x.Lock()
 
 	if validShare {
 		x.ValidShares++
-		if s.config.Limits.Enabled {
-			x.incrLimit(s.config.Limits.LimitJump)
+		if s.cfg().Limits.Enabled {
+			x.incrLimit(s.cfg().Limits.LimitJump)
 		}
 	} else {
 		x.InvalidShares++
 	}
 
 	totalShares := x.ValidShares + x.InvalidShares
-	if totalShares < s.config.Banning.CheckThreshold {
+	if totalShares < s.cfg().Banning.CheckThreshold {
 		x.Unlock()
 		return true
 	}
-	validShares := float32(x.ValidShares)
-	invalidShares := float32(x.InvalidShares)
+	validShares, invalidShares, staleShares := x.ValidShares, x.InvalidShares, x.StaleShares
 	x.resetShares()
 	x.Unlock()
 
-	ratio := invalidShares / validShares
-
-	if ratio >= s.config.Banning.InvalidPercent/100.0 {
-		s.forceBan(x, ip)
+	if reason := judgeShares(&s.cfg().Banning, validShares, invalidShares, staleShares); len(reason) > 0 {
+		s.forceBan(x, ip, reason)
 		return false
 	}
 	return true
 }
 
+// ApplyStalePolicy counts a rejected stale share of the login, before ApplySharePolicy counts it
+// as an invalid one.
+func (s *PolicyServer) ApplyStalePolicy(ip, login string) {
+	x := s.Get(ip)
+	x.Lock()
+	x.StaleShares++
+	x.Unlock()
+	s.countLoginStale(login)
+}
+
 func (x *Stats) resetShares() {
 	x.ValidShares = 0
 	x.InvalidShares = 0
+	x.StaleShares = 0
+}
+
+// judgeShares returns why the shares of a check window ban, empty if they don't. Stale shares are
+// also counted in invalid.
+func judgeShares(cfg *Banning, valid, invalid, stale int32) string {
+	if cfg.StalePercent > 0 {
+		if float32(stale)/float32(valid) >= cfg.StalePercent/100.0 {
+			return "stale shares"
+		}
+		invalid -= stale
+		if invalid < 0 {
+			invalid = 0
+		}
+	}
+	if float32(invalid)/float32(valid) >= cfg.InvalidPercent/100.0 {
+		return "invalid shares"
+	}
+	return ""
 }
 
-func (s *PolicyServer) forceBan(x *Stats, ip string) {
-	if !s.config.Banning.Enabled || s.InForceBanWhiteList(ip) {
+func (s *PolicyServer) forceBan(x *Stats, ip, reason string) {
+	if !s.cfg().Banning.Enabled || s.InForceBanWhiteList(ip) {
 		return
 	}
 	atomic.StoreInt64(&x.BannedAt, util.MakeTimestamp())
 
 	if atomic.CompareAndSwapInt32(&x.Banned, 0, 1) {
-		if len(s.config.Banning.IPSet) > 0 {
-			s.banChannel <- ip
-		} else {
-			log.Println("Banned peer", ip)
-		}
+		s.banChannel <- &ban{kind: redis.BanKindIP, value: ip, reason: reason, timeout: s.cfg().Banning.Timeout}
 	}
 }
 
@@ -510,21 +624,6 @@ func (s *PolicyServer) InForceBanWhiteList(ip string) bool {
 	return s.whitelist.Contains(ip)
 }
 
-func (s *PolicyServer) doBan(ip string) {
-	set, timeout := s.config.Banning.IPSet, s.config.Banning.Timeout
-	cmd := fmt.Sprintf("sudo ipset add %s %s timeout %v -!", set, ip, timeout)
-	args := strings.Fields(cmd)
-	head := args[0]
-	args = args[1:]
-
-	log.Printf("Banned %v with timeout %v on ipset %s", ip, timeout, set)
-
-	_, err := exec.Command(head, args...).Output()
-	if err != nil {
-		log.Printf("CMD Error: %s", err)
-	}
-}
-
 func (x *Stats) heartbeat() {
 	now := util.MakeTimestamp()
 	atomic.StoreInt64(&x.LastBeat, now)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
+	"github.com/cellcrypto/open-dangnn-pool/api"
+	"github.com/cellcrypto/open-dangnn-pool/events"
+	"github.com/cellcrypto/open-dangnn-pool/feature"
+	"github.com/cellcrypto/open-dangnn-pool/ledger"
+	"github.com/cellcrypto/open-dangnn-pool/notify"
+	"github.com/cellcrypto/open-dangnn-pool/payouts"
+	"github.com/cellcrypto/open-dangnn-pool/proxy"
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+// pool is a coin pool hosted by the process, with its own daemons, storage and services: the log
+// table, alerts, feature flags, events, notifications and ledger export. Its metrics carry its name
+// in the pool label. The log levels, log sinks and the metrics listener are shared.
+type pool struct {
+	cfg     *proxy.Config
+	backend *redis.RedisClient
+	db      *mysql.Database
+	logger  *plogger.Logger
+	// Fork table of the network, set by start for the unlocker and the API
+	forks *types.Forks
+
+	// Services of the pool running in the process, for config reloads
+	mu       sync.Mutex
+	proxy    *proxy.ProxyServer
+	unlocker *payouts.BlockUnlocker
+	payer    *payouts.PayoutsProcessor
+}
+
+// poolConfigs returns the configs of the pools hosted by the process: the top-level one, and one
+// per entry of "pools". Entries are decoded over the top-level config, so they only set what
+// differs, like the coin, daemons, stratum ports and storage.
+func poolConfigs(cfg *proxy.Config, data []byte) ([]*proxy.Config, error) {
+	configs := []*proxy.Config{cfg}
+	for i, raw := range cfg.Pools {
+		// A copy of the top-level config sharing nothing with it
+		pool := &proxy.Config{}
+		if err := json.Unmarshal(data, pool); err != nil {
+			return nil, err
+		}
+		pool.Pools = nil
+		if err := json.Unmarshal(raw, pool); err != nil {
+			return nil, fmt.Errorf("pool %v: %v", i+1, err)
+		}
+		// Entries the log table didn't take are replayed by the pool that saved them
+		if pool.LogWriter.OverflowFile == cfg.LogWriter.OverflowFile {
+			pool.LogWriter.OverflowFile = overflowFileOf(cfg.LogWriter.OverflowFile, pool.Name)
+		}
+		deriveConfig(pool)
+		configs = append(configs, pool)
+	}
+	return configs, nil
+}
+
+// overflowFileOf returns the log overflow file of a pool, next to file.
+func overflowFileOf(file, name string) string {
+	if len(file) == 0 {
+		file = "log-overflow.jsonl"
+	}
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "-" + name + ext
+}
+
+// checkPools makes sure the pools don't share their names, keys or rows.
+func checkPools(configs []*proxy.Config) {
+	names := make(map[string]bool)
+	keys := make(map[string]string)
+	channels := make(map[string]string)
+	rows := make(map[string]string)
+	for _, cfg := range configs {
+		if len(cfg.Name) == 0 || names[cfg.Name] {
+			log.Fatalf("Every pool needs a distinct name, %q is missing or taken", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		database := cfg.Redis.Location()
+		prefix := cfg.Redis.Prefix
+		if len(prefix) == 0 {
+			prefix = cfg.Coin
+		}
+		if other, ok := keys[database+"/"+prefix]; ok {
+			log.Fatalf("Pools %v and %v share the Redis keys %v, set a redis.prefix", other, cfg.Name, prefix)
+		}
+		keys[database+"/"+prefix] = cfg.Name
+		if !cfg.Redis.ScopeChannels {
+			if other, ok := channels[database]; ok {
+				log.Fatalf("Pools %v and %v share the Redis database %v, set redis.scopeChannels", other, cfg.Name, database)
+			}
+			channels[database] = cfg.Name
+		}
+
+		schema := fmt.Sprintf("%v:%v/%v/%v", cfg.Mysql.Endpoint, cfg.Mysql.Port, cfg.Mysql.Database, cfg.Mysql.Coin)
+		if other, ok := rows[schema]; ok {
+			log.Fatalf("Pools %v and %v share the MySQL rows of coin %v, set another mysql.database or coin", other, cfg.Name, cfg.Mysql.Coin)
+		}
+		rows[schema] = cfg.Name
+	}
+}
+
+func openPool(cfg *proxy.Config) *pool {
+	backend := redis.NewRedisClient(&cfg.Redis, cfg.Coin, cfg.Proxy.Difficulty, cfg.Pplns)
+	pong, err := backend.Check()
+	if err != nil {
+		log.Printf("Can't establish connection to backend of %v: %v", cfg.Name, err)
+	} else {
+		log.Printf("Backend check reply of %v: %v", cfg.Name, pong)
+	}
+
+	db, err := mysql.New(&cfg.Mysql, cfg.Proxy.Difficulty, backend)
+	if err != nil {
+		log.Printf("Can't establish connection to mysql of %v: %v", cfg.Name, err)
+		os.Exit(1)
+	}
+	backend.SetDB(db)
+	decay, err := cfg.BlockUnlocker.ShareScoreDecay()
+	if err != nil {
+		log.Printf("Invalid unlocker config of %v: %v", cfg.Name, err)
+		os.Exit(1)
+	}
+	backend.SetScoreDecay(decay)
+	log.Printf("connected mysql host:%v for %v", cfg.Mysql.Endpoint, cfg.Name)
+	return &pool{cfg: cfg, backend: backend, db: db}
+}
+
+// openServices starts the log table logger, alerts and feature flags of the pool, which its modules
+// look up by the pool name or get from its backend.
+func (p *pool) openServices() {
+	p.logger = plogger.New(p.db, p.cfg.Name, p.cfg.Coin, p.cfg.Mysql.LogTableName, &p.cfg.LogWriter)
+	alerts.Init(&p.cfg.Alerts, p.cfg.Coin, p.cfg.Name)
+	flags := feature.New(&p.cfg.Features, p.backend)
+	p.backend.SetFeatures(flags)
+	p.backend.InitPubSub(redis.ChannelFeature, flags)
+}
+
+func closeLoggers(pools []*pool) {
+	for _, p := range pools {
+		p.logger.Close()
+	}
+}
+
+// start starts the services of the pool but its API, served by startApis.
+func (p *pool) start() error {
+	if p.cfg.BlockUnlocker.Enabled && !util.StringInSlice(p.cfg.Net, []string{"mainnet", "testnet"}) {
+		return fmt.Errorf("net must be mainnet or testnet, not %q", p.cfg.Net)
+	}
+	if p.cfg.BlockUnlocker.Enabled || p.cfg.Api.Enabled {
+		forks, err := types.ForksFor(p.cfg.NetId, p.cfg.Net, p.cfg.Forks)
+		if err != nil {
+			return fmt.Errorf("invalid fork table: %v", err)
+		}
+		p.forks = forks
+	}
+
+	if p.cfg.Proxy.Enabled {
+		go p.startProxy()
+	}
+	if p.cfg.BlockUnlocker.Enabled {
+		go p.startBlockUnlocker()
+	}
+	if p.cfg.Payouts.Enabled {
+		go p.startPayoutsProcessor()
+	}
+	if p.cfg.LedgerExport.Enabled {
+		go ledger.NewExporter(&p.cfg.LedgerExport, p.db).Start()
+	}
+	if p.cfg.Events.Enabled {
+		events.Start(&p.cfg.Events, p.backend, p.cfg.Coin, p.cfg.Name)
+	}
+	if p.cfg.Notify.Enabled {
+		notify.Start(&p.cfg.Notify, p.backend, p.db, p.cfg.Coin, p.cfg.Name)
+	}
+	return nil
+}
+
+func (p *pool) startProxy() {
+	s := proxy.NewProxy(p.cfg, p.backend, p.db)
+	p.mu.Lock()
+	p.proxy = s
+	p.mu.Unlock()
+	s.Start()
+}
+
+func (p *pool) startBlockUnlocker() {
+	u := payouts.NewBlockUnlocker(&p.cfg.BlockUnlocker, p.backend, p.db, p.forks, p.cfg.NetId)
+	p.mu.Lock()
+	p.unlocker = u
+	p.mu.Unlock()
+	u.Start()
+}
+
+func (p *pool) startPayoutsProcessor() {
+	u := payouts.NewPayoutsProcessor(&p.cfg.Payouts, p.backend, p.db, p.cfg.NetId)
+	p.mu.Lock()
+	p.payer = u
+	p.mu.Unlock()
+	u.Start()
+}
+
+// startApis starts the APIs of the pools, on the listener of the first one when there are several.
+func startApis(pools []*pool) {
+	var servers []*api.ApiServer
+	for _, p := range pools {
+		if p.cfg.Api.Enabled {
+			servers = append(servers, api.NewApiServer(&p.cfg.Api, p.cfg.Coin, p.cfg.Name, p.backend, p.db, p.forks))
+		}
+	}
+	switch len(servers) {
+	case 0:
+	case 1:
+		servers[0].Start()
+	default:
+		api.StartShared(servers)
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type ShareAuditConfig struct {
+	Enabled bool `json:"enabled"`
+	// Percent of accepted shares revalidated in the background
+	SamplePercent float64 `json:"samplePercent"`
+	// Sampled shares waiting for revalidation, further samples are dropped. 1024 by default
+	QueueSize int `json:"queueSize"`
+	// Revalidate against the full DAG of the epoch, generated in memory (over 1 GB),
+	// instead of computing the dataset items from the verification cache
+	FullDag bool `json:"fullDag"`
+}
+
+// shareAuditor recomputes a sample of accepted shares from scratch, off the submit path. Shares
+// are accepted on the light verification of the ethash library; the audit recomputes the mix digest with the pool's own hashimoto, on the full DAG
+// when it's enabled and generated. Mismatches go to the policy per worker.
+type shareAuditor struct {
+	config *ShareAuditConfig
+	light  *lightHasher
+	dag    *dagStore
+	jobs   chan *auditJob
+	proxy  *ProxyServer
+}
+
+type auditJob struct {
+	login  string
+	worker string
+	ip     string
+	block  Block
+}
+
+func newShareAuditor(cfg *ShareAuditConfig, s *ProxyServer, light *lightHasher) *shareAuditor {
+	queueSize := 1024
+	if cfg.QueueSize > 0 {
+		queueSize = cfg.QueueSize
+	}
+	a := &shareAuditor{config: cfg, light: light, proxy: s, jobs: make(chan *auditJob, queueSize)}
+	if cfg.FullDag {
+		a.dag = &dagStore{}
+	}
+	go a.run()
+	return a
+}
+
+// sample queues the share for revalidation at samplePercent. block carries the share difficulty.
+func (a *shareAuditor) sample(login, worker, ip string, block Block) {
+	if rand.Float64()*100 >= a.config.SamplePercent {
+		return
+	}
+	select {
+	case a.jobs <- &auditJob{login: login, worker: worker, ip: ip, block: block}:
+	default:
+		metrics.ShareAudits.Inc(a.proxy.config.Name, "dropped")
+	}
+}
+
+func (a *shareAuditor) run() {
+	for job := range a.jobs {
+		ok, method := a.revalidate(job.block)
+		if ok {
+			metrics.ShareAudits.Inc(a.proxy.config.Name, "valid")
+		} else {
+			metrics.ShareAudits.Inc(a.proxy.config.Name, "mismatch")
+			log.Warnf("Audited share of %v.%v@%v at height %v doesn't verify on the %v", job.login, job.worker, job.ip, job.block.number, method)
+			a.proxy.logs.InsertLog(fmt.Sprintf("AUDIT MISMATCH %v.%v@%v at height %v on the %v", job.login, job.worker, job.ip, job.block.number, method),
+				plogger.LogTypeSystem, plogger.LogSubTypeFakeShare, 0, int64(job.block.number), job.login, "")
+		}
+		a.proxy.policy.ApplyAuditPolicy(job.ip, job.login, job.worker, !ok)
+	}
+}
+
+// revalidate recomputes the share and tells whether it matches its mix digest and meets its
+// difficulty, and what it was computed on.
+func (a *shareAuditor) revalidate(block Block) (bool, string) {
+	epoch := block.number / epochLength
+	var digest, result []byte
+	method := "verification cache"
+	if a.dag != nil {
+		if dataset := a.dag.get(epoch, a.light); dataset != nil {
+			digest, result = hashimotoFull(dataset, block.hashNoNonce.Bytes(), block.nonce)
+			method = "full DAG"
+		}
+	}
+	if digest == nil {
+		c := a.light.cache(epoch)
+		digest, result = hashimotoLight(c.datasetSize, c.cache, block.hashNoNonce.Bytes(), block.nonce)
+	}
+	if !bytes.Equal(digest, block.mixDigest.Bytes()) {
+		return false, method
+	}
+	return meetsTarget(new(big.Int).SetBytes(result), block.difficulty), method
+}
+
+// dagStore keeps the full dataset of one epoch. The dataset of a new epoch is generated in the
+// background, audits use the verification cache meanwhile.
+type dagStore struct {
+	mu         sync.Mutex
+	epoch      uint64
+	dataset    []uint32
+	generating bool
+}
+
+// get returns the dataset of epoch, nil while it's generated or for a past epoch.
+func (d *dagStore) get(epoch uint64, light *lightHasher) []uint32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dataset != nil && d.epoch == epoch {
+		return d.dataset
+	}
+	if !d.generating && (d.dataset == nil || epoch > d.epoch) {
+		d.generating = true
+		go d.generate(epoch, light)
+	}
+	return nil
+}
+
+func (d *dagStore) generate(epoch uint64, light *lightHasher) {
+	start := time.Now()
+	c := light.cache(epoch)
+	dataset := make([]uint32, c.datasetSize/4)
+	generateDataset(dataset, c.cache)
+	log.Infof("Generated the full DAG of epoch %v for share audits in %v", epoch, time.Since(start))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The previous dataset is released once the next one is ready
+	d.epoch = epoch
+	d.dataset = dataset
+	d.generating = false
+}
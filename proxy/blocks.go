@@ -2,7 +2,6 @@ package proxy
 
 import (
 	"github.com/ethereum/go-ethereum/common"
-	"log"
 	"math/big"
 	"strconv"
 	"strings"
@@ -60,18 +59,23 @@ func (s *ProxyServer) fetchBlockTemplate() {
 	t := s.currentBlockTemplate()
 	pendingReply, height, diff, err := s.fetchPendingBlock()
 	if err != nil {
-		log.Printf("Error while refreshing pending block on %s: %s", rpc.Name, err)
+		log.Errorf("Error while refreshing pending block on %s: %s", rpc.Name, err)
 		return
 	}
 	reply, err := rpc.GetWork()
 	if err != nil {
-		log.Printf("Error while refreshing block template on %s: %s", rpc.Name, err)
+		log.Errorf("Error while refreshing block template on %s: %s", rpc.Name, err)
 		return
 	}
 	// No need to update, we have fresh job
 	if t != nil && t.Header == reply[0] {
 		return
 	}
+	target, err := util.DecodeTarget(reply[2])
+	if err != nil {
+		log.Errorf("Error while reading block template target on %s: %s", rpc.Name, err)
+		return
+	}
 
 	pendingReply.Difficulty = util.ToHex(s.config.Proxy.Difficulty)
 
@@ -86,7 +90,7 @@ func (s *ProxyServer) fetchBlockTemplate() {
 	}
 	// Copy job backlog and add current one
 	newTemplate.headers[reply[0]] = heightDiffPair{
-		diff:   util.TargetHexToDiff(reply[2]),
+		diff:   target,
 		height: height,
 	}
 	if t != nil {
@@ -97,7 +101,13 @@ func (s *ProxyServer) fetchBlockTemplate() {
 		}
 	}
 	s.blockTemplate.Store(&newTemplate)
-	log.Printf("New block to mine on %s at height %d / %s %s %s", rpc.Name, height, reply[0][0:10], reply[1][0:10], reply[2][0:10])
+	if s.varDiff != nil {
+		s.varDiff.setNetworkDiff(diff)
+	}
+	if s.extranonces != nil {
+		s.extranonces.newHeight(height)
+	}
+	log.Infof("New block to mine on %s at height %d / %s %s %s", rpc.Name, height, reply[0][0:10], reply[1][0:10], reply[2][0:10])
 
 	// Stratum
 	if s.config.Proxy.Stratum.Enabled {
@@ -109,17 +119,17 @@ func (s *ProxyServer) fetchPendingBlock() (*rpc.GetBlockReplyPart, uint64, int64
 	rpc := s.rpc()
 	reply, err := rpc.GetPendingBlock()
 	if err != nil {
-		log.Printf("Error while refreshing pending block on %s: %s", rpc.Name, err)
+		log.Errorf("Error while refreshing pending block on %s: %s", rpc.Name, err)
 		return nil, 0, 0, err
 	}
 	blockNumber, err := strconv.ParseUint(strings.Replace(reply.Number, "0x", "", -1), 16, 64)
 	if err != nil {
-		log.Println("Can't parse pending block number")
+		log.Error("Can't parse pending block number")
 		return nil, 0, 0, err
 	}
 	blockDiff, err := strconv.ParseInt(strings.Replace(reply.Difficulty, "0x", "", -1), 16, 64)
 	if err != nil {
-		log.Println("Can't parse pending block difficulty")
+		log.Error("Can't parse pending block difficulty")
 		return nil, 0, 0, err
 	}
 	return reply, blockNumber, blockDiff, nil
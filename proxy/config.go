@@ -1,11 +1,24 @@
 package proxy
 
 import (
+	"encoding/json"
+
 	"github.com/cellcrypto/open-dangnn-pool/api"
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
+	"github.com/cellcrypto/open-dangnn-pool/events"
+	"github.com/cellcrypto/open-dangnn-pool/feature"
+	"github.com/cellcrypto/open-dangnn-pool/hook"
+	"github.com/cellcrypto/open-dangnn-pool/ledger"
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/notify"
 	"github.com/cellcrypto/open-dangnn-pool/payouts"
 	"github.com/cellcrypto/open-dangnn-pool/policy"
 	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
 	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger/sinks"
+	"github.com/cellcrypto/open-dangnn-pool/util/xlog"
 )
 
 type Config struct {
@@ -21,13 +34,37 @@ type Config struct {
 	Pplns int64        	`json:"pplns"`
 	Net string          `json:"net"`
 	NetId int64          `json:"netid"`
+	// Fork height tables by netId, replacing the embedded one of the network
+	Forks map[string]*types.Forks `json:"forks"`
+	// Time zone of the times in charts and reports, e.g. "Asia/Seoul". Stored times are UTC
+	Timezone string `json:"timezone"`
 
 	Redis redis.Config `json:"redis"`
 	Mysql mysql.Config `json:"mysql"`
 
+	Features feature.Config `json:"features"`
+	// Log format and levels per module
+	Log xlog.Config `json:"log"`
+	// Batched writes of system log entries to the log table
+	LogWriter plogger.WriterConfig `json:"logWriter"`
+	// Sinks of system log entries besides the log table
+	LogSinks sinks.Config `json:"logSinks"`
+
 	BlockUnlocker payouts.UnlockerConfig `json:"unlocker"`
 	Payouts       payouts.PayoutsConfig  `json:"payouts"`
 
+	LedgerExport ledger.Config `json:"ledgerExport"`
+	Metrics      metrics.Config `json:"metrics"`
+	Alerts       alerts.Config  `json:"alerts"`
+	Events       events.Config  `json:"events"`
+	Notify       notify.Config  `json:"notify"`
+	// Deadline of a graceful shutdown
+	Shutdown     hook.Config    `json:"shutdown"`
+
+	// More pools hosted by the process, each decoded over this config. They need distinct names,
+	// Redis keys and MySQL rows, and share the API listener, logs, alerts and metrics of this pool
+	Pools []json.RawMessage `json:"pools"`
+
 	NewrelicName    string `json:"newrelicName"`
 	NewrelicKey     string `json:"newrelicKey"`
 	NewrelicVerbose bool   `json:"newrelicVerbose"`
@@ -36,6 +73,7 @@ type Config struct {
 
 type Proxy struct {
 	Enabled              bool   `json:"enabled"`
+	// HTTP getwork listener, empty to only serve stratum
 	Listen               string `json:"listen"`
 	LimitHeadersSize     int    `json:"limitHeadersSize"`
 	LimitBodySize        int64  `json:"limitBodySize"`
@@ -44,9 +82,29 @@ type Proxy struct {
 	Difficulty           int64  `json:"difficulty"`
 	StateUpdateInterval  string `json:"stateUpdateInterval"`
 	HashrateExpiration   string `json:"hashrateExpiration"`
+	// How long a worker's job and nonce pairs are kept to reject resubmitted shares
+	DuplicateWindow string `json:"duplicateWindow"`
 	StratumHostname      string `json:"stratumHostname"`
+	// Region label of the shares of the HTTP listener and of stratum listeners without their own
+	Region string `json:"region"`
 
 	Policy policy.Config `json:"policy"`
+	// Full PoW verification of block candidates and a sample of the other shares
+	ShareValidation ShareValidationConfig `json:"shareValidation"`
+	// Background revalidation of a sample of accepted shares, mismatches per worker go to the policy
+	ShareAudit ShareAuditConfig `json:"shareAudit"`
+	// Partial credit for shares of older jobs
+	StaleShares StaleSharesConfig `json:"staleShares"`
+	// Write-ahead journal of shares, replayed after an unclean shutdown
+	ShareJournal ShareJournalConfig `json:"shareJournal"`
+	// Every accepted share per worker, for investigations of pool hopping or share withholding
+	ShareLog ShareLogConfig `json:"shareLog"`
+	// Near-block shares and blocks of every worker against its work, for the API to flag withholding
+	Withholding WithholdingConfig `json:"withholding"`
+	// Accept shares against the last job for a while when no upstream is reachable
+	BufferedMode BufferedModeConfig `json:"bufferedMode"`
+	// Reject unnamed workers of logins flagged as exchange deposit addresses
+	ExchangeWorkerNames bool `json:"exchangeWorkerNames"`
 
 	MaxFails    int64 `json:"maxFails"`
 	HealthCheck bool  `json:"healthCheck"`
@@ -57,12 +115,39 @@ type Proxy struct {
 type Stratum struct {
 	Enabled bool   `json:"enabled"`
 	Listen  string `json:"listen"`
+	// Protocol served on listen, "ethproxy" or "EthereumStratum/1.0.0", both when empty
+	Protocol string `json:"protocol"`
 	Timeout string `json:"timeout"`
 	MaxConn int    `json:"maxConn"`
+	// Messages queued per connection, a miner falling further behind is dropped. 256 by default
+	SendQueue int `json:"sendQueue"`
+	// Bytes of the nonce set by the pool on EthereumStratum, 1 to 3. 2 by default, 3 for more than 65536 sessions
+	ExtranonceSize int `json:"extranonceSize"`
+	// Served next to listen, e.g. TLS ports. listen may be empty to only serve these
+	Listeners []StratumListener `json:"listeners"`
+	// Per-IP and per-login connection limits and share rate limits
+	Connections ConnLimitsConfig `json:"connections"`
+
+	VarDiff VarDiffConfig `json:"varDiff"`
+	// Per-login history of stratum sessions
+	SessionLog SessionLogConfig `json:"sessionLog"`
+}
+
+type StratumListener struct {
+	Listen string `json:"listen"`
+	// Defaults to the stratum maxConn
+	MaxConn int        `json:"maxConn"`
+	TLS     *StratumTLS `json:"tls"`
+	// Region label of its shares, e.g. the GeoDNS region resolving to it. Defaults to the proxy region
+	Region string `json:"region"`
+	// "ethproxy" or "EthereumStratum/1.0.0" to serve only that protocol, both when empty
+	Protocol string `json:"protocol"`
 }
 
 type Upstream struct {
 	Name    string `json:"name"`
 	Url     string `json:"url"`
 	Timeout string `json:"timeout"`
+	// "submit" for low-latency block submission, "archive" for receipts, empty for both
+	Role    string `json:"role"`
 }
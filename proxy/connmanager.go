@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type ConnLimitsConfig struct {
+	Enabled bool `json:"enabled"`
+	// 0 for no limit
+	MaxPerIP           int `json:"maxPerIp"`
+	MaxWorkersPerLogin int `json:"maxWorkersPerLogin"`
+	// Shares a connection may submit per second, with bursts up to shareBurst
+	ShareRate  float64 `json:"shareRate"`
+	ShareBurst int     `json:"shareBurst"`
+	// Violations of the limits within banTime that ban the IP for banTime
+	MaxViolations int    `json:"maxViolations"`
+	BanTime       string `json:"banTime"`
+}
+
+// connManager enforces per-IP and per-login connection limits and share rates on stratum.
+type connManager struct {
+	sync.Mutex
+	config     *ConnLimitsConfig
+	logs       *plogger.Logger
+	banTime    time.Duration
+	ips        map[string]int
+	logins     map[string]int
+	violations map[string]*violations
+	bans       map[string]time.Time
+}
+
+type violations struct {
+	count int
+	since time.Time
+}
+
+// shareBucket is a token bucket of the shares a session may submit.
+type shareBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newConnManager(cfg *ConnLimitsConfig, logs *plogger.Logger) *connManager {
+	m := &connManager{
+		config:     cfg,
+		logs:       logs,
+		banTime:    10 * time.Minute,
+		ips:        make(map[string]int),
+		logins:     make(map[string]int),
+		violations: make(map[string]*violations),
+		bans:       make(map[string]time.Time),
+	}
+	if len(cfg.BanTime) > 0 {
+		m.banTime = util.MustParseDuration(cfg.BanTime)
+	}
+	if cfg.ShareRate > 0 && cfg.ShareBurst < 1 {
+		cfg.ShareBurst = 1
+	}
+	if cfg.MaxViolations <= 0 {
+		cfg.MaxViolations = 5
+	}
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			m.purge(time.Now())
+		}
+	}()
+	return m
+}
+
+// acquireIP counts a new connection, false if the IP is banned or at its limit.
+func (m *connManager) acquireIP(ip string, now time.Time) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	if until, ok := m.bans[ip]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(m.bans, ip)
+	}
+	if m.config.MaxPerIP > 0 && m.ips[ip] >= m.config.MaxPerIP {
+		m.violate(ip, "connections per IP", now)
+		return false
+	}
+	m.ips[ip]++
+	return true
+}
+
+// acquireLogin counts a session of login, false if the login has too many workers.
+func (m *connManager) acquireLogin(cs *Session, login string, now time.Time) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	if cs.limitLogin == login {
+		return true
+	}
+	if m.config.MaxWorkersPerLogin > 0 && m.logins[login] >= m.config.MaxWorkersPerLogin {
+		m.violate(cs.ip, fmt.Sprintf("workers of %v", login), now)
+		return false
+	}
+	m.releaseLogin(cs)
+	m.logins[login]++
+	cs.limitLogin = login
+	return true
+}
+
+func (m *connManager) release(cs *Session) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.ips[cs.ip]--; m.ips[cs.ip] <= 0 {
+		delete(m.ips, cs.ip)
+	}
+	m.releaseLogin(cs)
+}
+
+func (m *connManager) releaseLogin(cs *Session) {
+	if len(cs.limitLogin) == 0 {
+		return
+	}
+	if m.logins[cs.limitLogin]--; m.logins[cs.limitLogin] <= 0 {
+		delete(m.logins, cs.limitLogin)
+	}
+	cs.limitLogin = ""
+}
+
+// allowShare takes a token from the session's bucket, false if it submits too fast.
+func (m *connManager) allowShare(cs *Session, now time.Time) bool {
+	if m.config.ShareRate <= 0 {
+		return true
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	if until, ok := m.bans[cs.ip]; ok && now.Before(until) {
+		return false
+	}
+	b := cs.shares
+	if b == nil {
+		b = &shareBucket{tokens: float64(m.config.ShareBurst), last: now}
+		cs.shares = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * m.config.ShareRate
+	if b.tokens > float64(m.config.ShareBurst) {
+		b.tokens = float64(m.config.ShareBurst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		m.violate(cs.ip, "share rate", now)
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// violate counts a limit violation and bans the IP once it has too many. Must be called locked.
+func (m *connManager) violate(ip string, limit string, now time.Time) {
+	v, ok := m.violations[ip]
+	if !ok || now.Sub(v.since) > m.banTime {
+		v = &violations{since: now}
+		m.violations[ip] = v
+	}
+	v.count++
+	log.Warnf("Stratum limit of %v exceeded by %v (%v/%v)", limit, ip, v.count, m.config.MaxViolations)
+	if v.count < m.config.MaxViolations {
+		return
+	}
+	delete(m.violations, ip)
+	m.bans[ip] = now.Add(m.banTime)
+	m.logs.InsertLog(fmt.Sprintf("STRATUM BAN %v for %v: limit of %v exceeded", ip, m.banTime, limit),
+		plogger.LogTypeSystem, plogger.LogSubTypeConnLimit, 0, 0, "", "")
+}
+
+func (m *connManager) purge(now time.Time) {
+	m.Lock()
+	defer m.Unlock()
+
+	for ip, until := range m.bans {
+		if !now.Before(until) {
+			delete(m.bans, ip)
+		}
+	}
+	for ip, v := range m.violations {
+		if now.Sub(v.since) > m.banTime {
+			delete(m.violations, ip)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package proxy
+
+// loadExchanges reloads the logins flagged as exchange deposit addresses by the API.
+func (s *ProxyServer) loadExchanges() {
+	addresses, err := s.backend.GetExchangeAddresses()
+	if err != nil {
+		log.Errorf("Failed to load exchange addresses: %v", err)
+		return
+	}
+	exchanges := make(map[string]bool, len(addresses))
+	for login := range addresses {
+		exchanges[login] = true
+	}
+	s.exchangesMu.Lock()
+	s.exchanges = exchanges
+	s.exchangesMu.Unlock()
+}
+
+// requiresWorkerName tells whether a worker of login must be named but id is not.
+// Unnamed workers of an exchange deposit address can't be told apart by their owners.
+func (s *ProxyServer) requiresWorkerName(login, id string) bool {
+	if !s.config.Proxy.ExchangeWorkerNames || (workerPattern.MatchString(id) && id != "0") {
+		return false
+	}
+	s.exchangesMu.RLock()
+	defer s.exchangesMu.RUnlock()
+	return s.exchanges[login]
+}
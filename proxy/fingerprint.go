@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// fingerprintTLS returns config for one connection, recording the client's handshake on cs.
+func fingerprintTLS(cs *Session, config *tls.Config) *tls.Config {
+	c := config.Clone()
+	c.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		// Runs on the session's first read, before anything else reads the field.
+		cs.tlsFingerprint = tlsFingerprint(hello)
+		return nil, nil
+	}
+	return c
+}
+
+// tlsFingerprint condenses what the ClientHello offers, which depends on the miner's TLS library and
+// its version, like JA3 does. Extension order isn't exposed by crypto/tls, so it's coarser.
+func tlsFingerprint(hello *tls.ClientHelloInfo) string {
+	return shortHash(fmt.Sprint(hello.SupportedVersions, hello.CipherSuites, hello.SupportedCurves,
+		hello.SupportedPoints, hello.SignatureSchemes, hello.SupportedProtos))
+}
+
+// fingerprint identifies the miner software of cs by its TLS handshake and how it speaks stratum.
+// Workers of one rig type share it, so many IPs with one fingerprint on one login look like a swarm.
+func (cs *Session) fingerprint() string {
+	return shortHash(strings.Join([]string{cs.tlsFingerprint, cs.firstMethod, cs.protocol, cs.agent}, "|"))
+}
+
+func shortHash(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
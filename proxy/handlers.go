@@ -1,12 +1,14 @@
 package proxy
 
 import (
-	"log"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
 	"github.com/cellcrypto/open-dangnn-pool/rpc"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
 	"github.com/cellcrypto/open-dangnn-pool/util"
 )
 
@@ -14,6 +16,7 @@ import (
 var noncePattern = regexp.MustCompile("^0x[0-9a-f]{16}$")
 var hashPattern = regexp.MustCompile("^0x[0-9a-f]{64}$")
 var workerPattern = regexp.MustCompile("^[0-9a-zA-Z-_]{1,8}$")
+var regionPattern = regexp.MustCompile("^[0-9a-zA-Z-_]{0,32}$")
 
 // Stratum
 func (s *ProxyServer) handleLoginRPC(cs *Session, params []string, id string) (bool, *ErrorReply) {
@@ -28,18 +31,32 @@ func (s *ProxyServer) handleLoginRPC(cs *Session, params []string, id string) (b
 	if !s.policy.ApplyLoginPolicy(login, cs.ip) {
 		return false, &ErrorReply{Code: -1, Message: "You are blacklisted"}
 	}
+	if s.requiresWorkerName(login, id) {
+		return false, &ErrorReply{Code: -1, Message: "Worker name required"}
+	}
+	fingerprint := cs.fingerprint()
+	if !s.policy.ApplyFingerprintPolicy(cs.ip, login, fingerprint) {
+		return false, &ErrorReply{Code: -1, Message: "You are blacklisted"}
+	}
+	if s.conns != nil && !s.conns.acquireLogin(cs, login, time.Now()) {
+		return false, &ErrorReply{Code: -1, Message: "Too many workers"}
+	}
 	cs.login = login
+	cs.loginWorker = id
+	if s.varDiff != nil {
+		cs.vardiff = s.varDiff.newSession(time.Now())
+	}
 	s.registerSession(cs)
-	log.Printf("Stratum miner connected %v@%v", login, cs.ip)
+	log.Debugf("Stratum miner connected %v@%v, fingerprint %v", login, cs.ip, fingerprint)
 	return true, nil
 }
 
 func (s *ProxyServer) handleGetWorkRPC(cs *Session) ([]string, *ErrorReply) {
 	t := s.currentBlockTemplate()
-	if t == nil || len(t.Header) == 0 || s.isSick() {
+	if t == nil || len(t.Header) == 0 || s.isSick() || s.workUnavailable() {
 		return nil, &ErrorReply{Code: 0, Message: "Work not ready"}
 	}
-	return []string{t.Header, t.Seed, s.diff}, nil
+	return []string{t.Header, t.Seed, s.shareTarget(cs)}, nil
 }
 
 // Stratum
@@ -51,43 +68,59 @@ func (s *ProxyServer) handleTCPSubmitRPC(cs *Session, id string, params []string
 	if !ok {
 		return false, &ErrorReply{Code: 25, Message: "Not subscribed"}
 	}
-	return s.handleSubmitRPC(cs, cs.login, id, params)
+	diff := s.shareDiffs(cs)[0]
+	valid, errReply := s.handleSubmitRPC(cs, cs.login, id, params)
+	if valid {
+		cs.countSessionShare(diff)
+	}
+	return valid, errReply
 }
 
 func (s *ProxyServer) handleSubmitRPC(cs *Session, login, id string, params []string) (bool, *ErrorReply) {
 	if !workerPattern.MatchString(id) {
 		id = "0"
 	}
+	if s.requiresWorkerName(login, id) {
+		return false, &ErrorReply{Code: -1, Message: "Worker name required"}
+	}
 	if len(params) != 3 {
 		s.policy.ApplyMalformedPolicy(cs.ip)
-		log.Printf("Malformed params from %s@%s %v", login, cs.ip, params)
+		s.rejectShare(login, id, redis.RejectMalformed)
+		log.Warnf("Malformed params from %s@%s %v", login, cs.ip, params)
 		return false, &ErrorReply{Code: -1, Message: "Invalid params"}
 	}
 
 	if !noncePattern.MatchString(params[0]) || !hashPattern.MatchString(params[1]) || !hashPattern.MatchString(params[2]) {
 		s.policy.ApplyMalformedPolicy(cs.ip)
-		log.Printf("Malformed PoW result from %s@%s %v", login, cs.ip, params)
+		s.rejectShare(login, id, redis.RejectMalformed)
+		log.Warnf("Malformed PoW result from %s@%s %v", login, cs.ip, params)
 		return false, &ErrorReply{Code: -1, Message: "Malformed PoW result"}
 	}
+	if s.workUnavailable() {
+		metrics.Shares.Inc(s.config.Name, "outage")
+		return false, &ErrorReply{Code: -1, Message: "Pool nodes unavailable"}
+	}
 	t := s.currentBlockTemplate()
-	exist, validShare := s.processShare(login, id, cs.ip, t, params)
-	ok := s.policy.ApplySharePolicy(cs.ip, !exist && validShare)
+	exist, validShare := s.processShare(login, id, cs.ip, cs.region, t, params, s.shareDiffs(cs))
+	ok := s.policy.ApplySharePolicy(cs.ip, login, !exist && validShare)
 	s.policy.ApplyShareID(login, !exist && validShare)
 
 	if exist {
-		log.Printf("Duplicate share from %s@%s %v", login, cs.ip, params)
+		s.rejectShare(login, id, redis.RejectDuplicate)
+		s.policy.ApplyDuplicatePolicy(cs.ip, login, id)
+		log.Warnf("Duplicate share from %s@%s %v", login, cs.ip, params)
 		return false, &ErrorReply{Code: 22, Message: "Duplicate share"}
 	}
 
 	if !validShare {
-		log.Printf("Invalid share from %s@%s", login, cs.ip)
+		log.Debugf("Invalid share from %s@%s", login, cs.ip)
 		// Bad shares limit reached, return error and close
 		if !ok {
 			return false, &ErrorReply{Code: 23, Message: "Invalid share"}
 		}
 		return false, nil
 	}
-	log.Printf("Valid share from %s@%s", login, cs.ip)
+	log.Debugf("Valid share from %s@%s", login, cs.ip)
 
 	if !ok {
 		return true, &ErrorReply{Code: -1, Message: "High rate of invalid shares"}
@@ -105,7 +138,7 @@ func (s *ProxyServer) handleGetBlockByNumberRPC() *rpc.GetBlockReplyPart {
 }
 
 func (s *ProxyServer) handleUnknownRPC(cs *Session, m string) *ErrorReply {
-	log.Printf("Unknown request method %s from %s", m, cs.ip)
+	log.Warnf("Unknown request method %s from %s", m, cs.ip)
 	s.policy.ApplyMalformedPolicy(cs.ip)
 	return &ErrorReply{Code: -3, Message: "Method not found"}
 }
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/big"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+)
+
+// Ethash parameters, see https://github.com/ethereum/wiki/wiki/Ethash
+const (
+	epochLength        = 30000
+	cacheInitBytes     = 1 << 24
+	cacheGrowthBytes   = 1 << 17
+	datasetInitBytes   = 1 << 30
+	datasetGrowthBytes = 1 << 23
+	mixBytes           = 128
+	hashBytes          = 64
+	hashWords          = 16
+	datasetParents     = 256
+	cacheRounds        = 3
+	loopAccesses       = 64
+)
+
+// Verification caches kept in memory, the current epoch and the previous one.
+const lightCaches = 2
+
+// lightHasher computes the mix digest of a nonce from the verification cache. EthereumStratum/1.0.0
+// miners only send the nonce, the digest is needed to verify shares and submit blocks. The ethash
+// library only verifies a claimed digest and doesn't return the one it computes, so this is the
+// pool's one implementation that does, used by EthereumStratum shares and the share audits.
+type lightHasher struct {
+	mu     sync.Mutex
+	caches map[uint64]*lightCache
+}
+
+type lightCache struct {
+	once        sync.Once
+	epoch       uint64
+	cache       []uint32
+	datasetSize uint64
+	used        time.Time
+}
+
+func newLightHasher() *lightHasher {
+	return &lightHasher{caches: make(map[uint64]*lightCache)}
+}
+
+// compute returns the mix digest and the PoW result of nonce for the header hash at height.
+func (l *lightHasher) compute(height uint64, hashNoNonce common.Hash, nonce uint64) (common.Hash, common.Hash) {
+	c := l.cache(height / epochLength)
+	digest, result := hashimotoLight(c.datasetSize, c.cache, hashNoNonce.Bytes(), nonce)
+	return common.BytesToHash(digest), common.BytesToHash(result)
+}
+
+func (l *lightHasher) cache(epoch uint64) *lightCache {
+	l.mu.Lock()
+	c, ok := l.caches[epoch]
+	if !ok {
+		if len(l.caches) >= lightCaches {
+			var oldest *lightCache
+			for _, v := range l.caches {
+				if oldest == nil || v.used.Before(oldest.used) {
+					oldest = v
+				}
+			}
+			delete(l.caches, oldest.epoch)
+		}
+		c = &lightCache{epoch: epoch}
+		l.caches[epoch] = c
+	}
+	c.used = time.Now()
+	l.mu.Unlock()
+
+	// Generated outside of the lock, other epochs stay usable meanwhile.
+	c.once.Do(func() {
+		start := time.Now()
+		c.cache = make([]uint32, cacheSize(epoch)/4)
+		generateCache(c.cache, seedHash(epoch))
+		c.datasetSize = datasetSize(epoch)
+		log.Infof("Generated ethash verification cache for epoch %v in %v", epoch, time.Since(start))
+	})
+	return c
+}
+
+func cacheSize(epoch uint64) uint64 {
+	size := cacheInitBytes + cacheGrowthBytes*epoch - hashBytes
+	for !new(big.Int).SetUint64(size / hashBytes).ProbablyPrime(1) {
+		size -= 2 * hashBytes
+	}
+	return size
+}
+
+func datasetSize(epoch uint64) uint64 {
+	size := datasetInitBytes + datasetGrowthBytes*epoch - mixBytes
+	for !new(big.Int).SetUint64(size / mixBytes).ProbablyPrime(1) {
+		size -= 2 * mixBytes
+	}
+	return size
+}
+
+func seedHash(epoch uint64) []byte {
+	seed := make([]byte, 32)
+	keccak256 := sha3.NewKeccak256()
+	for i := uint64(0); i < epoch; i++ {
+		seed = sum(keccak256, seed)
+	}
+	return seed
+}
+
+func sum(h hash.Hash, data []byte) []byte {
+	h.Reset()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func generateCache(dest []uint32, seed []byte) {
+	keccak512 := sha3.NewKeccak512()
+	size := uint64(len(dest) * 4)
+	rows := size / hashBytes
+	cache := make([]byte, size)
+
+	copy(cache, sum(keccak512, seed))
+	for offset := uint64(hashBytes); offset < size; offset += hashBytes {
+		copy(cache[offset:], sum(keccak512, cache[offset-hashBytes:offset]))
+	}
+
+	temp := make([]byte, hashBytes)
+	for i := 0; i < cacheRounds; i++ {
+		for j := uint64(0); j < rows; j++ {
+			srcOff := ((j - 1 + rows) % rows) * hashBytes
+			dstOff := j * hashBytes
+			xorOff := (uint64(binary.LittleEndian.Uint32(cache[dstOff:])) % rows) * hashBytes
+			for k := 0; k < hashBytes; k++ {
+				temp[k] = cache[srcOff+uint64(k)] ^ cache[xorOff+uint64(k)]
+			}
+			copy(cache[dstOff:], sum(keccak512, temp))
+		}
+	}
+	for i := range dest {
+		dest[i] = binary.LittleEndian.Uint32(cache[i*4:])
+	}
+}
+
+func fnv(a, b uint32) uint32 {
+	return a*0x01000193 ^ b
+}
+
+func fnvHash(mix []uint32, data []uint32) {
+	for i := 0; i < len(mix); i++ {
+		mix[i] = mix[i]*0x01000193 ^ data[i]
+	}
+}
+
+func generateDatasetItem(cache []uint32, index uint32, keccak512 hash.Hash) []uint32 {
+	rows := uint32(len(cache) / hashWords)
+
+	mix := make([]byte, hashBytes)
+	binary.LittleEndian.PutUint32(mix, cache[(index%rows)*hashWords]^index)
+	for i := 1; i < hashWords; i++ {
+		binary.LittleEndian.PutUint32(mix[i*4:], cache[(index%rows)*hashWords+uint32(i)])
+	}
+	mix = sum(keccak512, mix)
+
+	intMix := make([]uint32, hashWords)
+	for i := 0; i < len(intMix); i++ {
+		intMix[i] = binary.LittleEndian.Uint32(mix[i*4:])
+	}
+	for i := uint32(0); i < datasetParents; i++ {
+		parent := fnv(index^i, intMix[i%hashWords]) % rows
+		fnvHash(intMix, cache[parent*hashWords:])
+	}
+	for i, val := range intMix {
+		binary.LittleEndian.PutUint32(mix[i*4:], val)
+	}
+	mix = sum(keccak512, mix)
+
+	for i := 0; i < len(intMix); i++ {
+		intMix[i] = binary.LittleEndian.Uint32(mix[i*4:])
+	}
+	return intMix
+}
+
+func hashimotoLight(size uint64, cache []uint32, hashNoNonce []byte, nonce uint64) ([]byte, []byte) {
+	keccak512 := sha3.NewKeccak512()
+	lookup := func(index uint32) []uint32 {
+		return generateDatasetItem(cache, index, keccak512)
+	}
+	return hashimoto(size, hashNoNonce, nonce, lookup)
+}
+
+func hashimotoFull(dataset []uint32, hashNoNonce []byte, nonce uint64) ([]byte, []byte) {
+	lookup := func(index uint32) []uint32 {
+		offset := index * hashWords
+		return dataset[offset : offset+hashWords]
+	}
+	return hashimoto(uint64(len(dataset))*4, hashNoNonce, nonce, lookup)
+}
+
+// hashimoto returns the mix digest and the PoW result of nonce, lookup returning the dataset
+// item at index, computed from the cache or read from the full dataset.
+func hashimoto(size uint64, hashNoNonce []byte, nonce uint64, lookup func(index uint32) []uint32) ([]byte, []byte) {
+	rows := uint32(size / mixBytes)
+
+	seed := make([]byte, 40)
+	copy(seed, hashNoNonce)
+	binary.LittleEndian.PutUint64(seed[32:], nonce)
+	seed = sum(sha3.NewKeccak512(), seed)
+	seedHead := binary.LittleEndian.Uint32(seed)
+
+	mix := make([]uint32, mixBytes/4)
+	for i := 0; i < len(mix); i++ {
+		mix[i] = binary.LittleEndian.Uint32(seed[i%16*4:])
+	}
+	temp := make([]uint32, len(mix))
+	for i := 0; i < loopAccesses; i++ {
+		parent := fnv(uint32(i)^seedHead, mix[i%len(mix)]) % rows
+		for j := uint32(0); j < mixBytes/hashBytes; j++ {
+			copy(temp[j*hashWords:], lookup(2*parent+j))
+		}
+		fnvHash(mix, temp)
+	}
+	for i := 0; i < len(mix); i += 4 {
+		mix[i/4] = fnv(fnv(fnv(mix[i], mix[i+1]), mix[i+2]), mix[i+3])
+	}
+	mix = mix[:len(mix)/4]
+
+	digest := make([]byte, common.HashLength)
+	for i, val := range mix {
+		binary.LittleEndian.PutUint32(digest[i*4:], val)
+	}
+	return digest, sum(sha3.NewKeccak256(), append(seed, digest...))
+}
+
+// generateDataset fills dest with the full dataset of the cache on all CPUs.
+func generateDataset(dest []uint32, cache []uint32) {
+	threads := runtime.NumCPU()
+	items := uint32(len(dest) / hashWords)
+	var wg sync.WaitGroup
+	for t := 0; t < threads; t++ {
+		wg.Add(1)
+		go func(first uint32) {
+			defer wg.Done()
+			keccak512 := sha3.NewKeccak512()
+			for i := first; i < items; i += uint32(threads) {
+				copy(dest[i*hashWords:], generateDatasetItem(cache, i, keccak512))
+			}
+		}(uint32(t))
+	}
+	wg.Wait()
+}
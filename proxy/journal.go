@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/journal"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type ShareJournalConfig struct {
+	// Journal shares to a local file before writing them, and replay the unwritten ones at start
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`
+	// Fsync the journal every interval instead of every share, e.g. "100ms". Only an OS crash loses the last interval
+	SyncInterval string `json:"syncInterval"`
+	// Rewrite the journal without written shares once it's larger, in MB
+	CompactSize int64 `json:"compactSize"`
+	// How often the journal size is checked and the backends forget written seqs
+	CompactInterval string `json:"compactInterval"`
+}
+
+// journaledShare is a share as journaled, everything needed to write it again.
+type journaledShare struct {
+	// Login credited, and the login the miner connected with
+	Login    string   `json:"login"`
+	DevId    string   `json:"devId"`
+	Id       string   `json:"id"`
+	Params   []string `json:"params"`
+	Diff     int64    `json:"diff"`
+	Height   uint64   `json:"height"`
+	Hostname string   `json:"hostname"`
+	Region   string   `json:"region,omitempty"`
+	LoginCnt int      `json:"loginCnt"`
+	Stale    bool     `json:"stale,omitempty"`
+	// Accepted against the last job while all upstreams were down
+	Buffered bool `json:"buffered,omitempty"`
+}
+
+// openJournal opens the share journal and writes the shares a crash left unwritten, before
+// any new share is accepted.
+func (s *ProxyServer) openJournal() {
+	cfg := &s.config.Proxy.ShareJournal
+	if !cfg.Enabled {
+		return
+	}
+	if len(cfg.Path) == 0 {
+		cfg.Path = "shares.journal"
+	}
+	if cfg.CompactSize <= 0 {
+		cfg.CompactSize = 64
+	}
+	var syncIntv time.Duration
+	if len(cfg.SyncInterval) > 0 {
+		syncIntv = util.MustParseDuration(cfg.SyncInterval)
+	}
+	j, err := journal.Open(cfg.Path, syncIntv)
+	if err != nil {
+		log.Fatalf("Failed to open share journal: %v", err)
+	}
+	s.journal = j
+	log.Infof("Journaling shares to %v (%v)", cfg.Path, j.Id())
+
+	s.replayJournal(util.MustParseDuration(s.config.Proxy.HashrateExpiration))
+
+	intv := time.Minute
+	if len(cfg.CompactInterval) > 0 {
+		intv = util.MustParseDuration(cfg.CompactInterval)
+	}
+	go func() {
+		for {
+			time.Sleep(intv)
+			s.compactJournal(cfg.CompactSize << 20)
+		}
+	}()
+}
+
+// replayJournal writes the uncommitted shares to the backends that don't have them yet.
+// A share that fails again stays in the journal for the next start.
+func (s *ProxyServer) replayJournal(window time.Duration) {
+	pending := s.journal.Pending()
+	if len(pending) == 0 {
+		return
+	}
+	log.Infof("Replaying %v unwritten shares from the journal", len(pending))
+	id := s.journal.Id()
+	replayed := 0
+	for _, e := range pending {
+		var sh journaledShare
+		if err := json.Unmarshal(e.Data, &sh); err != nil {
+			log.Warnf("Dropping malformed share %v of the journal: %v", e.Seq, err)
+			s.journal.Commit(e.Seq)
+			continue
+		}
+		// MySQL skips a seq it has
+		err := s.db.WriteShare(sh.Login, sh.Id, sh.Params, sh.Diff, sh.Height, window, sh.Hostname, id, e.Seq)
+		if err != nil {
+			log.Errorf("Failed to replay share %v to mysql: %v", e.Seq, err)
+			metrics.JournalReplayed.Inc(s.config.Name, "failed")
+			continue
+		}
+
+		done, err := s.backend.JournalApplied(id, e.Seq)
+		if err == nil && !done {
+			if sh.Stale {
+				err = s.backend.WriteStaleShare(sh.Login, sh.DevId, sh.Id, sh.Params, sh.Diff, sh.Height, window, sh.Hostname, sh.Region, sh.LoginCnt, id, e.Seq)
+			} else {
+				_, err = s.backend.WriteShare(sh.Login, sh.DevId, sh.Id, sh.Params, sh.Diff, sh.Height, window, sh.Hostname, sh.Region, sh.LoginCnt, id, e.Seq)
+			}
+		}
+		if err != nil {
+			log.Errorf("Failed to replay share %v to redis: %v", e.Seq, err)
+			metrics.JournalReplayed.Inc(s.config.Name, "failed")
+			continue
+		}
+		s.journal.Commit(e.Seq)
+		metrics.JournalReplayed.Inc(s.config.Name, "written")
+		replayed++
+	}
+	log.Infof("Replayed %v of %v unwritten shares", replayed, len(pending))
+}
+
+func (s *ProxyServer) compactJournal(maxSize int64) {
+	j := s.journal
+	if j.Size() > maxSize {
+		if err := j.Compact(); err != nil {
+			log.Errorf("Failed to compact share journal: %v", err)
+		}
+	}
+	metrics.JournalPending.Set(float64(len(j.Pending())), s.config.Name)
+	metrics.JournalBytes.Set(float64(j.Size()), s.config.Name)
+
+	checkpoint := j.Checkpoint()
+	if _, err := s.db.CompactShareJournal(j.Id(), checkpoint); err != nil {
+		log.Errorf("Failed to compact share journal in mysql: %v", err)
+	}
+	if _, err := s.backend.CompactJournal(j.Id(), checkpoint); err != nil {
+		log.Errorf("Failed to compact share journal in redis: %v", err)
+	}
+}
+
+// writeShare credits a share in MySQL, then Redis. The share is journaled first and committed once
+// both have it. A share that failed in Redis is written again at the next start.
+func (s *ProxyServer) writeShare(sh *journaledShare) error {
+	var id string
+	var seq uint64
+	if s.journal != nil {
+		id = s.journal.Id()
+		data, _ := json.Marshal(sh)
+		var err error
+		if seq, err = s.journal.Append(data); err != nil {
+			log.Errorf("Failed to journal share, writing it unjournaled: %v", err)
+		}
+	}
+
+	err := s.db.WriteShare(sh.Login, sh.Id, sh.Params, sh.Diff, sh.Height, s.hashrateExpiration, sh.Hostname, id, seq)
+	if metrics.MysqlError(s.config.Name, "write_share", err) != nil {
+		return err
+	}
+	if sh.Stale {
+		err = s.backend.WriteStaleShare(sh.Login, sh.DevId, sh.Id, sh.Params, sh.Diff, sh.Height, s.hashrateExpiration, sh.Hostname, sh.Region, sh.LoginCnt, id, seq)
+	} else {
+		_, err = s.backend.WriteShare(sh.Login, sh.DevId, sh.Id, sh.Params, sh.Diff, sh.Height, s.hashrateExpiration, sh.Hostname, sh.Region, sh.LoginCnt, id, seq)
+	}
+	if metrics.RedisError(s.config.Name, "write_share", err) != nil {
+		log.Error("Failed to insert share data into backend:", err)
+		return nil
+	}
+	if seq > 0 {
+		s.journal.Commit(seq)
+	}
+	return nil
+}
@@ -1,37 +1,45 @@
 package proxy
 
 import (
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
 	"github.com/ethereum/ethash"
 	"github.com/ethereum/go-ethereum/common"
-	"log"
 	"math/big"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 var hasher = ethash.New()
 var subMiner map[string]*MinerSubInfo
 
-func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, params []string) (bool, bool) {
+// processShare checks a share against diffs in order and credits it at the first one it meets.
+func (s *ProxyServer) processShare(login, id, ip, region string, t *BlockTemplate, params []string, diffs []int64) (bool, bool) {
 	nonceHex := params[0]
 	hashNoNonce := params[1]
 	mixDigest := params[2]
 	nonce, _ := strconv.ParseUint(strings.Replace(nonceHex, "0x", "", -1), 16, 64)
-	shareDiff := s.config.Proxy.Difficulty
 	stratumHostname := s.config.Proxy.StratumHostname
 
 	h, ok := t.headers[hashNoNonce]
 	if !ok {
-		log.Printf("Stale share from %v@%v", login, ip)
+		log.Warnf("Stale share from %v@%v", login, ip)
+		s.rejectShare(login, id, redis.RejectStale)
+		s.policy.ApplyStalePolicy(ip, login)
 		return false, false
 	}
 
-	share := Block{
-		number:      h.height,
-		hashNoNonce: common.HexToHash(hashNoNonce),
-		difficulty:  big.NewInt(shareDiff),
-		nonce:       nonce,
-		mixDigest:   common.HexToHash(mixDigest),
+	// Cheaper than verifying the PoW, and catches resubmissions whose pair was swept from the backlog.
+	dup, err := s.backend.CheckDuplicateShare(login, hashNoNonce, nonceHex, s.duplicateWindow)
+	if metrics.RedisError(s.config.Name, "check_duplicate_share", err) != nil {
+		log.Error("Error: duplicate share redis err:", err)
+		return false, false
+	}
+	if dup {
+		return true, false
 	}
 
 	block := Block{
@@ -42,9 +50,16 @@ func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, param
 		mixDigest:   common.HexToHash(mixDigest),
 	}
 
-	if !hasher.Verify(share) {
+	shareDiff, isBlock := s.verifyShare(login, id, ip, block, diffs)
+	if shareDiff == 0 {
+		s.rejectShare(login, id, redis.RejectInvalid)
 		return false, false
 	}
+	if s.auditor != nil {
+		share := block
+		share.difficulty = big.NewInt(shareDiff)
+		s.auditor.sample(login, id, ip, share)
+	}
 
 	subLogin := login
 	subLogin , count := s.ChoiceSubLogin(login, ok, subLogin)
@@ -52,19 +67,26 @@ func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, param
 
 	println("subLogin" ,subLogin, "count",count)
 
-	if hasher.Verify(block) {
-		ok, err := s.rpc().SubmitBlock(params)
+	kind := shareKindValid
+	if isBlock {
+		ok, err := s.submitBlock(params)
 		if err != nil {
-			log.Printf("Block submission failure at height %v for %v: %v", h.height, t.Header, err)
+			log.Errorf("Block submission failure at height %v for %v: %v", h.height, t.Header, err)
+			if s.buffering() {
+				// No node to take the block, credit the work like any share
+				return s.writeBufferedShare(subLogin, login, id, ip, region, params, shareDiff, h.height, count)
+			}
 		} else if !ok {
-			log.Printf("Block rejected at height %v for %v", h.height, t.Header)
+			log.Warnf("Block rejected at height %v for %v", h.height, t.Header)
 			return false, false
 		} else {
+			metrics.BlocksFound.Inc(s.config.Name)
 			s.fetchBlockTemplate()
+			kind = shareKindBlock
 
 			exist, err := s.backend.CheckPoWExist(h.height, params)
-			if err != nil {
-				log.Println("Error: duplicate share redis err:", err)
+			if metrics.RedisError(s.config.Name, "check_pow", err) != nil {
+				log.Error("Error: duplicate share redis err:", err)
 				return false, false
 			}
 			// Duplicate share, (nonce, powHash, mixDigest) pair exist
@@ -75,21 +97,27 @@ func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, param
 			s.db.WriteBlock(subLogin, id, params, shareDiff, h.diff.Int64(), h.height, s.hashrateExpiration, stratumHostname)
 
 			//log.Printf("[test code] Block rejected at height %v for %v", h.height, t.Header , params[0])
-			exist, err = s.backend.WriteBlock(subLogin, login, id, params, shareDiff, h.diff.Int64(), h.height, s.hashrateExpiration, stratumHostname, count)
+			exist, err = s.backend.WriteBlock(subLogin, login, id, params, shareDiff, h.diff.Int64(), h.height, s.hashrateExpiration, stratumHostname, region, count)
 			if exist {
 				return true, false
 			}
-			if err != nil {
-				log.Println("Failed to insert block candidate into backend:", err)
+			if metrics.RedisError(s.config.Name, "write_block", err) != nil {
+				log.Error("Failed to insert block candidate into backend:", err)
 			} else {
-				log.Printf("Inserted block %v to backend", h.height)
+				log.Infof("Inserted block %v to backend", h.height)
+				s.alerts.Fire(alerts.EventBlockFound, strconv.FormatUint(h.height, 10), "Block %v found by %v", h.height, subLogin)
+				if err := s.backend.PublishBlockFound(h.height, subLogin, h.diff.Int64()); err != nil {
+					log.Errorf("Failed to publish block %v: %v", h.height, err)
+				}
 			}
-			log.Printf("Block found by miner %v@%v at height %d nonce %v hashNoNonce %v", login, ip, h.height, params[0], hashNoNonce)
+			log.Infof("Block found by miner %v@%v at height %d nonce %v hashNoNonce %v", login, ip, h.height, params[0], hashNoNonce)
 		}
+	} else if s.isStale(t, h.height) {
+		return s.processStaleShare(subLogin, login, id, ip, region, params, shareDiff, h.height, count)
 	} else {
 		exist, err := s.backend.CheckPoWExist(h.height, params)
-		if err != nil {
-			log.Println("Error: duplicate share redis err:", err)
+		if metrics.RedisError(s.config.Name, "check_pow", err) != nil {
+			log.Error("Error: duplicate share redis err:", err)
 			return false, false
 		}
 		// Duplicate share, (nonce, powHash, mixDigest) pair exist
@@ -97,19 +125,34 @@ func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, param
 			return true, false
 		}
 
-		err = s.db.WriteShare(subLogin, id, params, shareDiff, h.height, s.hashrateExpiration, stratumHostname)
+		if s.buffering() {
+			return s.writeBufferedShare(subLogin, login, id, ip, region, params, shareDiff, h.height, count)
+		}
+		err = s.writeShare(&journaledShare{
+			Login: subLogin, DevId: login, Id: id, Params: params, Diff: shareDiff,
+			Height: h.height, Hostname: stratumHostname, Region: region, LoginCnt: count,
+		})
 		if err != nil {
 			return true, false
 		}
+	}
+	s.logShare(subLogin, id, ip, hashNoNonce, shareDiff, h.height, kind)
+	metrics.Shares.Inc(s.config.Name, "valid")
+	return false, true
+}
 
-		exist, err = s.backend.WriteShare(subLogin, login, id, params, shareDiff, h.height, s.hashrateExpiration, stratumHostname, count)
-		if exist {
-			return true, false
-		}
-		if err != nil {
-			log.Println("Failed to insert share data into backend:", err)
-		}
+// writeBufferedShare credits a share accepted against the last job while all upstreams are down.
+func (s *ProxyServer) writeBufferedShare(subLogin, login, id, ip, region string, params []string, shareDiff int64, height uint64, count int) (bool, bool) {
+	err := s.writeShare(&journaledShare{
+		Login: subLogin, DevId: login, Id: id, Params: params, Diff: shareDiff,
+		Height: height, Hostname: s.config.Proxy.StratumHostname, Region: region, LoginCnt: count, Buffered: true,
+	})
+	if err != nil {
+		return true, false
 	}
+	atomic.AddInt64(&s.bufferedShares, 1)
+	s.logShare(subLogin, id, ip, params[1], shareDiff, height, shareKindBuffered)
+	metrics.Shares.Inc(s.config.Name, "buffered")
 	return false, true
 }
 
@@ -134,4 +177,18 @@ func (s *ProxyServer) ChoiceSubLogin(login string, ok bool, subLogin string) (st
 		minerSubInfo.lock.Unlock()
 	}
 	return subLogin, resultCount
-}
\ No newline at end of file
+}
+
+// rejectShare counts a rejected share in the miner's reject history.
+func (s *ProxyServer) rejectShare(login, id, class string) {
+	metrics.Shares.Inc(s.config.Name, class)
+	if len(login) == 0 {
+		return
+	}
+	if !workerPattern.MatchString(id) {
+		id = "0"
+	}
+	if err := s.backend.WriteReject(login, id, class, util.MakeTimestamp()/1000); metrics.RedisError(s.config.Name, "write_reject", err) != nil {
+		log.Errorf("Failed to write %v reject of %v.%v: %v", class, login, id, err)
+	}
+}
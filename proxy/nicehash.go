@@ -0,0 +1,348 @@
+package proxy
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// EthereumStratum is the NiceHash stratum protocol, negotiated in mining.subscribe.
+const EthereumStratum = "EthereumStratum/1.0.0"
+
+// Job ids are the first bytes of the header hash.
+const jobIdLength = 16
+
+// Share difficulty encodings of the stratum dialects, eth_getWork target hashes for the others
+var difficultyEncoders = map[string]util.DifficultyEncoder{
+	EthereumStratum: util.StratumDifficulty,
+}
+
+func (cs *Session) isEthereumStratum() bool {
+	return cs.protocol == EthereumStratum
+}
+
+// difficultyEncoder is how share difficulties are sent to cs.
+func (cs *Session) difficultyEncoder() util.DifficultyEncoder {
+	if e, ok := difficultyEncoders[cs.protocol]; ok {
+		return e
+	}
+	return util.TargetHash
+}
+
+// Extranonce sizes in bytes. Sessions get the configured size unless they ask for fewer.
+const (
+	defaultExtranonceSize = 2
+	maxExtranonceSize     = 3
+)
+
+// releasedExtranonce waits until the jobs its session worked on are stale.
+type releasedExtranonce struct {
+	size   int
+	value  uint32
+	height uint64
+}
+
+// extranonceLevel is the bookkeeping of the extranonces of one size, bitmaps of its 2^(8*size) values.
+type extranonceLevel struct {
+	held []uint64
+	// Held, or prefixing a held extranonce of a longer size
+	taken []uint64
+	// Number of held extranonces of longer sizes under each value
+	below map[uint32]int
+	// Where the next search starts, after the last value handed out
+	next uint32
+}
+
+func bitSet(bits []uint64, v uint32) bool {
+	return bits[v/64]&(1<<(v%64)) != 0
+}
+
+func setBit(bits []uint64, v uint32, on bool) {
+	if on {
+		bits[v/64] |= 1 << (v % 64)
+	} else {
+		bits[v/64] &^= 1 << (v % 64)
+	}
+}
+
+// extranoncePool hands out the extranonces of EthereumStratum sessions. They are hex strings of
+// one to maxExtranonceSize bytes, and none held is a prefix of another, so no two miners search
+// the same nonces whatever their sizes. A released extranonce is only handed out again once the
+// jobs sent with it are stale, so a new session doesn't find the shares of the closed one again.
+type extranoncePool struct {
+	mu       sync.Mutex
+	size     int
+	levels   [maxExtranonceSize + 1]*extranonceLevel
+	released []releasedExtranonce
+	height   uint64
+}
+
+func newExtranoncePool(size int) *extranoncePool {
+	if size <= 0 {
+		size = defaultExtranonceSize
+	}
+	return &extranoncePool{size: size}
+}
+
+// negotiate is the size of a session asking for at most maxSize bytes, the pool's size when it
+// doesn't ask.
+func (p *extranoncePool) negotiate(maxSize string) (int, bool) {
+	if len(maxSize) == 0 {
+		return p.size, true
+	}
+	size, err := strconv.Atoi(maxSize)
+	if err != nil || size < 1 {
+		return 0, false
+	}
+	if size > p.size {
+		size = p.size
+	}
+	return size, true
+}
+
+// level returns the bookkeeping of size, allocated on first use: 2MB of bitmaps for 3 bytes.
+func (p *extranoncePool) level(size int) *extranonceLevel {
+	if p.levels[size] == nil {
+		words := (uint32(1) << uint(8*size)) / 64
+		p.levels[size] = &extranonceLevel{held: make([]uint64, words), taken: make([]uint64, words), below: make(map[uint32]int)}
+	}
+	return p.levels[size]
+}
+
+// heldPrefix is the size of the held extranonce prefixing v of size bytes, 0 if none is.
+func (p *extranoncePool) heldPrefix(size int, v uint32) int {
+	for k := 1; k < size; k++ {
+		if l := p.levels[k]; l != nil && bitSet(l.held, v>>uint(8*(size-k))) {
+			return k
+		}
+	}
+	return 0
+}
+
+// free tells whether v of size bytes can be held: neither v, a prefix of it nor an extranonce it
+// prefixes is.
+func (p *extranoncePool) free(size int, v uint32) bool {
+	return p.heldPrefix(size, v) == 0 && !bitSet(p.level(size).taken, v)
+}
+
+// acquire returns a free extranonce of size bytes, false while there's none. The search skips 64
+// taken values at a time, and the values under a held prefix at once.
+func (p *extranoncePool) acquire(size int) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l := p.level(size)
+	space := uint32(1) << uint(8*size)
+	for n := uint32(0); n < space; {
+		v := (l.next + n) % space
+		if free := ^l.taken[v/64] >> (v % 64); free == 0 {
+			n += 64 - v%64
+			continue
+		} else if skip := uint32(bits.TrailingZeros64(free)); skip > 0 {
+			n += skip
+			continue
+		}
+		if k := p.heldPrefix(size, v); k > 0 {
+			span := uint32(1) << uint(8*(size-k))
+			n += span - v%span
+			continue
+		}
+		p.hold(size, v)
+		l.next = (v + 1) % space
+		return fmt.Sprintf("%0*x", 2*size, v), true
+	}
+	return "", false
+}
+
+func (p *extranoncePool) hold(size int, v uint32) {
+	l := p.level(size)
+	setBit(l.held, v, true)
+	setBit(l.taken, v, true)
+	for k := 1; k < size; k++ {
+		prefix, pl := v>>uint(8*(size-k)), p.level(k)
+		pl.below[prefix]++
+		setBit(pl.taken, prefix, true)
+	}
+}
+
+func (p *extranoncePool) unhold(size int, v uint32) {
+	l := p.levels[size]
+	setBit(l.held, v, false)
+	setBit(l.taken, v, l.below[v] > 0)
+	for k := 1; k < size; k++ {
+		prefix, pl := v>>uint(8*(size-k)), p.levels[k]
+		if pl.below[prefix]--; pl.below[prefix] == 0 {
+			delete(pl.below, prefix)
+			// Prefix free, none held is a prefix of another
+			setBit(pl.taken, prefix, false)
+		}
+	}
+}
+
+// release takes back the extranonce of a closed session, it's free again after maxBacklog blocks.
+func (p *extranoncePool) release(extranonce string) {
+	size := len(extranonce) / 2
+	v, err := strconv.ParseUint(extranonce, 16, 32)
+	if err != nil || size < 1 || size > maxExtranonceSize {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l := p.levels[size]; l != nil && bitSet(l.held, uint32(v)) {
+		p.released = append(p.released, releasedExtranonce{size, uint32(v), p.height})
+	}
+}
+
+// newHeight frees the released extranonces whose jobs are out of the backlog at height.
+func (p *extranoncePool) newHeight(height uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.height = height
+	n := 0
+	for _, r := range p.released {
+		if height < r.height+maxBacklog {
+			p.released[n] = r
+			n++
+			continue
+		}
+		p.unhold(r.size, r.value)
+	}
+	p.released = p.released[:n]
+}
+
+func (s *ProxyServer) handleSubscribeRPC(cs *Session, params []string) ([]interface{}, *ErrorReply) {
+	if len(params) < 2 || params[1] != EthereumStratum {
+		return nil, &ErrorReply{Code: 20, Message: "Unsupported protocol"}
+	}
+	if len(cs.extranonce) == 0 {
+		// The first bytes of the nonce are set by the pool, the miner searches the rest. A third
+		// param is the most bytes the miner takes.
+		var maxSize string
+		if len(params) > 2 {
+			maxSize = params[2]
+		}
+		size, ok := s.extranonces.negotiate(maxSize)
+		if !ok {
+			return nil, &ErrorReply{Code: -1, Message: "Invalid extranonce size"}
+		}
+		extranonce, ok := s.extranonces.acquire(size)
+		if !ok {
+			log.Warnf("No free %v-byte extranonce for %v", size, cs.ip)
+			return nil, &ErrorReply{Code: -1, Message: "Too many sessions"}
+		}
+		cs.extranonce = extranonce
+	}
+	cs.protocol = EthereumStratum
+	cs.agent = params[0]
+	sessionId := fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64())
+	log.Debugf("%v subscribe from %v, agent %v, extranonce %v", EthereumStratum, cs.ip, params[0], cs.extranonce)
+	return []interface{}{[]string{"mining.notify", sessionId, EthereumStratum}, cs.extranonce}, nil
+}
+
+// handleAuthorizeRPC logs in "<login>.<worker>".
+func (s *ProxyServer) handleAuthorizeRPC(cs *Session, params []string) (bool, *ErrorReply) {
+	if !cs.isEthereumStratum() {
+		return false, &ErrorReply{Code: 25, Message: "Not subscribed"}
+	}
+	if len(params) == 0 {
+		return false, &ErrorReply{Code: -1, Message: "Invalid params"}
+	}
+	login, worker := splitWorker(params[0])
+	reply, errReply := s.handleLoginRPC(cs, []string{login}, worker)
+	if errReply != nil {
+		return false, errReply
+	}
+	cs.worker = worker
+	return reply, nil
+}
+
+// handleSubmitShareRPC completes the miner's nonce and computes the mix digest, then checks the share
+// like an eth_submitWork one.
+func (s *ProxyServer) handleSubmitShareRPC(cs *Session, params []string) (bool, *ErrorReply) {
+	if len(params) != 3 {
+		s.policy.ApplyMalformedPolicy(cs.ip)
+		s.rejectShare(cs.login, cs.worker, redis.RejectMalformed)
+		log.Warnf("Malformed params from %s@%s %v", cs.login, cs.ip, params)
+		return false, &ErrorReply{Code: -1, Message: "Invalid params"}
+	}
+	worker := cs.worker
+	if _, w := splitWorker(params[0]); w != "0" {
+		worker = w
+	}
+
+	t := s.currentBlockTemplate()
+	if t == nil {
+		return false, &ErrorReply{Code: 0, Message: "Work not ready"}
+	}
+	header, h, ok := t.job(params[1])
+	if !ok {
+		log.Warnf("Stale share from %v@%v", cs.login, cs.ip)
+		s.rejectShare(cs.login, worker, redis.RejectStale)
+		s.policy.ApplyStalePolicy(cs.ip, cs.login)
+		return false, &ErrorReply{Code: 21, Message: "Job not found"}
+	}
+
+	nonceHex := "0x" + cs.extranonce + strings.ToLower(params[2])
+	if !noncePattern.MatchString(nonceHex) {
+		s.policy.ApplyMalformedPolicy(cs.ip)
+		s.rejectShare(cs.login, worker, redis.RejectMalformed)
+		log.Warnf("Malformed nonce from %s@%s %v", cs.login, cs.ip, params)
+		return false, &ErrorReply{Code: -1, Message: "Malformed PoW result"}
+	}
+	nonce, _ := strconv.ParseUint(nonceHex[2:], 16, 64)
+	// Off the session goroutine, on the bounded PoW workers.
+	mixDigest := s.validator.mixDigest(s.light, h.height, common.HexToHash(header), nonce)
+
+	return s.handleTCPSubmitRPC(cs, worker, []string{nonceHex, header, mixDigest.Hex()})
+}
+
+// pushEthereumStratumJob sends the share difficulty when it changed and the job.
+func (s *ProxyServer) pushEthereumStratumJob(cs *Session, m *jobMessages) error {
+	diff := s.shareDiffs(cs)[0]
+	notify, err := m.notifyMessage()
+	if err != nil {
+		return err
+	}
+
+	cs.Lock()
+	defer cs.Unlock()
+	if diff != cs.notifiedDiff {
+		data, err := m.difficultyMessage(cs, diff)
+		if err != nil {
+			return err
+		}
+		if err := cs.queue.send(data); err != nil {
+			return err
+		}
+		cs.notifiedDiff = diff
+	}
+	return cs.queue.send(notify)
+}
+
+// job finds the header hash of a job id among the recent templates.
+func (t *BlockTemplate) job(jobId string) (string, heightDiffPair, bool) {
+	if len(jobId) != jobIdLength {
+		return "", heightDiffPair{}, false
+	}
+	for header, h := range t.headers {
+		if len(header) > 2+jobIdLength && header[2:2+jobIdLength] == jobId {
+			return header, h, true
+		}
+	}
+	return "", heightDiffPair{}, false
+}
+
+func splitWorker(name string) (string, string) {
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) == 2 && len(parts[1]) > 0 {
+		return parts[0], parts[1]
+	}
+	return parts[0], "0"
+}
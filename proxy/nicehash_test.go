@@ -0,0 +1,87 @@
+package proxy
+
+import "testing"
+
+func TestExtranonceNegotiate(t *testing.T) {
+	p := newExtranoncePool(2)
+	for maxSize, want := range map[string]int{"": 2, "1": 1, "2": 2, "3": 2} {
+		if size, ok := p.negotiate(maxSize); !ok || size != want {
+			t.Errorf("Must negotiate %v bytes for %q, got %v %v", want, maxSize, size, ok)
+		}
+	}
+	for _, maxSize := range []string{"0", "-1", "x"} {
+		if _, ok := p.negotiate(maxSize); ok {
+			t.Errorf("Must refuse %q", maxSize)
+		}
+	}
+}
+
+func TestExtranoncePrefixFree(t *testing.T) {
+	p := newExtranoncePool(2)
+
+	if v, ok := p.acquire(1); !ok || v != "00" {
+		t.Fatalf("Must hand out 00 first, got %v %v", v, ok)
+	}
+	// Everything under 00 is taken by it
+	if v, ok := p.acquire(2); !ok || v != "0100" {
+		t.Fatalf("Must skip the extranonces under 00, got %v %v", v, ok)
+	}
+	// 01 prefixes 0100
+	if v, ok := p.acquire(1); !ok || v != "02" {
+		t.Fatalf("Must skip the prefix of 0100, got %v %v", v, ok)
+	}
+
+	if p.free(2, 0x0005) {
+		t.Error("Must not free an extranonce under a held one")
+	}
+	if p.free(1, 0x01) {
+		t.Error("Must not free the prefix of a held extranonce")
+	}
+	if p.levels[1].below[0x01] != 1 || len(p.levels[1].below) != 1 {
+		t.Errorf("Must count 0100 under 01 only, got %v", p.levels[1].below)
+	}
+	if !p.free(2, 0x0101) || !p.free(1, 0x03) {
+		t.Error("Must free the extranonces not overlapping a held one")
+	}
+}
+
+func TestExtranonceExhausted(t *testing.T) {
+	p := newExtranoncePool(2)
+	for i := 0; i < 256; i++ {
+		if _, ok := p.acquire(1); !ok {
+			t.Fatalf("Must hand out 256 1-byte extranonces, got %v", i)
+		}
+	}
+	if v, ok := p.acquire(1); ok {
+		t.Errorf("Must run out of 1-byte extranonces, got %v", v)
+	}
+	if v, ok := p.acquire(2); ok {
+		t.Errorf("Must not hand out extranonces under the 1-byte ones, got %v", v)
+	}
+}
+
+func TestExtranonceRecycle(t *testing.T) {
+	p := newExtranoncePool(2)
+	p.newHeight(100)
+	v, _ := p.acquire(2)
+	p.release(v)
+
+	p.newHeight(100 + maxBacklog - 1)
+	if p.free(2, 0x0000) || p.free(1, 0x00) {
+		t.Error("Must hold a released extranonce while its jobs are in the backlog")
+	}
+	p.newHeight(100 + maxBacklog)
+	if !p.free(2, 0x0000) || !p.free(1, 0x00) {
+		t.Error("Must free a released extranonce once its jobs are stale")
+	}
+	if len(p.levels[1].below) != 0 || len(p.released) != 0 {
+		t.Errorf("Must drop the bookkeeping of a freed extranonce, got %v %v", p.levels[1].below, p.released)
+	}
+	// The search goes on after the last one handed out
+	if v, ok := p.acquire(2); !ok || v != "0001" {
+		t.Errorf("Must hand out the next extranonce, got %v %v", v, ok)
+	}
+	if v, ok := p.acquire(1); !ok || v != "01" {
+		t.Errorf("Must hand out a 1-byte extranonce over the freed one, got %v %v", v, ok)
+	}
+}
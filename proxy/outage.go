@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type BufferedModeConfig struct {
+	// Keep accepting shares against the last job while no upstream is reachable
+	Enabled bool `json:"enabled"`
+	// How long after the last upstream went down shares are still accepted, "10m" by default
+	Window string `json:"window"`
+}
+
+func (s *ProxyServer) initBufferedMode() {
+	cfg := &s.config.Proxy.BufferedMode
+	if !cfg.Enabled {
+		return
+	}
+	s.bufferWindow = 10 * time.Minute
+	if len(cfg.Window) > 0 {
+		s.bufferWindow = util.MustParseDuration(cfg.Window)
+	}
+	log.Infof("Buffering shares for up to %v while all upstreams are down", s.bufferWindow)
+}
+
+// startOutage records when every upstream went down, once per outage.
+func (s *ProxyServer) startOutage() {
+	now := time.Now().Unix()
+	if !atomic.CompareAndSwapInt64(&s.outageSince, 0, now) {
+		return
+	}
+	msg := "All upstreams are down"
+	if s.bufferWindow > 0 {
+		msg = fmt.Sprintf("%v, buffering shares against the last job for %v", msg, s.bufferWindow)
+	}
+	log.Errorf("%v", msg)
+	s.logs.InsertLog(msg, plogger.LogTypeSystem, plogger.LogSubTypeNodeOutage, 0, 0, "", "")
+}
+
+// endOutage resumes normal operation once an upstream is back and sends miners a fresh job.
+func (s *ProxyServer) endOutage() {
+	since := atomic.SwapInt64(&s.outageSince, 0)
+	if since == 0 {
+		return
+	}
+	buffered := atomic.SwapInt64(&s.bufferedShares, 0)
+	msg := fmt.Sprintf("Upstream %v is back after %v, %v shares buffered", s.rpc().Name, time.Since(time.Unix(since, 0)).Round(time.Second), buffered)
+	log.Warnf("%v", msg)
+	s.logs.InsertLog(msg, plogger.LogTypeSystem, plogger.LogSubTypeNodeOutage, 0, 0, "", "")
+	s.fetchBlockTemplate()
+}
+
+// buffering tells whether shares are accepted during an outage, and marked as buffered.
+func (s *ProxyServer) buffering() bool {
+	since := atomic.LoadInt64(&s.outageSince)
+	return since > 0 && s.bufferWindow > 0 && time.Since(time.Unix(since, 0)) <= s.bufferWindow
+}
+
+// workUnavailable tells whether an outage outlasted the buffering window. Sessions stay open,
+// but the last job is too old to credit.
+func (s *ProxyServer) workUnavailable() bool {
+	since := atomic.LoadInt64(&s.outageSince)
+	return since > 0 && s.bufferWindow > 0 && time.Since(time.Unix(since, 0)) > s.bufferWindow
+}
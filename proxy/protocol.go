@@ -0,0 +1,27 @@
+package proxy
+
+// EthProxy is the eth-proxy protocol of eth_submitLogin, eth_getWork and eth_submitWork, the
+// same as HTTP getwork over a stratum connection.
+const EthProxy = "ethproxy"
+
+// Protocols a stratum listener can be limited to, it serves both without one.
+var stratumProtocols = map[string]bool{"": true, EthProxy: true, EthereumStratum: true}
+
+// Protocol of the methods only one of them has, eth_submitHashrate is sent over both.
+var protocolMethods = map[string]string{
+	"eth_submitLogin":             EthProxy,
+	"eth_getWork":                 EthProxy,
+	"eth_submitWork":              EthProxy,
+	"mining.subscribe":            EthereumStratum,
+	"mining.extranonce.subscribe": EthereumStratum,
+	"mining.authorize":            EthereumStratum,
+	"mining.submit":               EthereumStratum,
+}
+
+var errProtocol = &ErrorReply{Code: 20, Message: "Unsupported protocol"}
+
+// allowMethod tells whether the listener of cs serves the protocol of method.
+func (cs *Session) allowMethod(method string) bool {
+	protocol, ok := protocolMethods[method]
+	return !ok || len(cs.listenerProtocol) == 0 || protocol == cs.listenerProtocol
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/cellcrypto/open-dangnn-pool/hook"
 	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
 	"io"
-	"log"
 	"math/rand"
 	"net"
 	"net/http"
@@ -17,23 +16,32 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/cellcrypto/open-dangnn-pool/alerts"
 	"github.com/cellcrypto/open-dangnn-pool/policy"
 	"github.com/cellcrypto/open-dangnn-pool/rpc"
+	"github.com/cellcrypto/open-dangnn-pool/storage/journal"
 	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
 	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
 	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/xlog"
 )
 
+var log = xlog.Module("proxy")
+
 type ProxyServer struct {
 	config             *Config
 	blockTemplate      atomic.Value
 	upstream           int32
 	upstreams          []*rpc.RPCClient
+	roles              *rpc.Upstreams
 	backend            *redis.RedisClient
 	db 				   *mysql.Database
+	logs               *plogger.Logger
+	alerts             *alerts.Alerts
 	diff               string
 	policy             *policy.PolicyServer
 	hashrateExpiration time.Duration
+	duplicateWindow    time.Duration
 	failsCount         int64
 	reportRatesMu sync.RWMutex
 	reportRates		   map[string]*ReportedRate
@@ -43,9 +51,57 @@ type ProxyServer struct {
 	sessions   map[*Session]struct{}
 	timeout    time.Duration
 
+	// Listeners and connections closed by the shutdown, logged in or not
+	stratumMu  sync.Mutex
+	listeners  []net.Listener
+	clients    map[*Session]struct{}
+	clientsWg  sync.WaitGroup
+	httpServer *http.Server
+	// Held by every stratum request, the shutdown takes it to wait for those in flight
+	requestsMu sync.RWMutex
+	draining   int32
+
 	subMinerMu sync.RWMutex
 	subMiner map[string]*MinerSubInfo
 
+	// Logins flagged as exchange deposit addresses
+	exchangesMu sync.RWMutex
+	exchanges   map[string]bool
+
+	// Stratum share difficulty per connection, nil for fixed difficulty
+	varDiff *varDiff
+
+	// Stratum connection limits, nil when disabled
+	conns *connManager
+
+	// Sampled full PoW verification, nil to fully verify every share
+	validator *shareValidator
+	// Background revalidation of accepted shares, nil when disabled
+	auditor *shareAuditor
+
+	// Write-ahead journal of shares, nil when disabled
+	journal *journal.Journal
+	// Accepted shares logged for investigations, nil when disabled
+	shareLog *shareLogger
+	// Near-block shares counted per worker, nil when disabled
+	withholding *withholdingCounter
+
+	// Stratum sessions are logged this long, 0 when disabled
+	sessionRetention time.Duration
+
+	// Unix time every upstream went down, 0 while one is up
+	outageSince    int64
+	bufferedShares int64
+	// Shares are accepted against the last job this long into an outage, 0 when disabled
+	bufferWindow time.Duration
+
+	// Messages queued per stratum connection before dropping it
+	sendQueueSize int
+
+	// EthereumStratum/1.0.0
+	extranonces *extranoncePool
+	light       *lightHasher
+
 	// alarm
 	minerBeatIntv int64
 }
@@ -57,45 +113,137 @@ type ReportedRate struct {
 
 type Session struct {
 	ip  string
-	enc *json.Encoder
+	// Getwork replies, stratum ones go through the queue
+	enc   *json.Encoder
+	queue *sendQueue
 
 	// Stratum
 	sync.Mutex
-	conn  *net.TCPConn
+	conn  net.Conn
 	login string
+	vardiff *sessionDiff
+
+	// EthereumStratum/1.0.0
+	protocol     string
+	extranonce   string
+	worker       string
+	notifiedDiff int64
+
+	// Connection limits
+	limitLogin string
+	shares     *shareBucket
+
+	// Client fingerprint
+	tlsFingerprint string
+	firstMethod    string
+	agent          string
+
+	// Region of the listener the miner connected to
+	region string
+	// Protocol the listener is limited to, empty for both
+	listenerProtocol string
+
+	// Session log
+	connectedAt time.Time
+	loginWorker string
+	validShares int64
+	diffSum     int64
 }
 
 func NewProxy(cfg *Config, backend *redis.RedisClient, db *mysql.Database) *ProxyServer {
 	if len(cfg.Name) == 0 {
 		log.Fatal("You must set instance name")
 	}
-	policy := policy.Start(&cfg.Proxy.Policy, backend, db)
-	proxy := &ProxyServer{config: cfg, backend: backend, db: db, policy: policy}
-	proxy.diff = util.GetTargetHex(cfg.Proxy.Difficulty)
+	if !regionPattern.MatchString(cfg.Proxy.Region) {
+		log.Fatalf("Invalid proxy region %q", cfg.Proxy.Region)
+	}
+	policy := policy.Start(&cfg.Proxy.Policy, backend, db, cfg.Name)
+	proxy := &ProxyServer{config: cfg, backend: backend, db: db, policy: policy, clients: make(map[*Session]struct{})}
+	proxy.logs = plogger.For(cfg.Name)
+	proxy.alerts = alerts.For(cfg.Name)
+	proxy.diff = util.EncodeTargetHash(cfg.Proxy.Difficulty)
 
 	proxy.upstreams = make([]*rpc.RPCClient, len(cfg.Upstream))
 	for i, v := range cfg.Upstream {
-		proxy.upstreams[i] = rpc.NewRPCClient(v.Name, v.Url, v.Timeout, cfg.NetId)
-		log.Printf("Upstream: %s => %s", v.Name, v.Url)
+		if !rpc.IsValidRole(v.Role) {
+			log.Fatalf("Invalid role %v for upstream %v", v.Role, v.Name)
+		}
+		proxy.upstreams[i] = rpc.NewRPCClient(cfg.Name, v.Name, v.Url, v.Timeout, cfg.NetId)
+		proxy.upstreams[i].Role = v.Role
+		log.Infof("Upstream: %s => %s (role: %s)", v.Name, v.Url, v.Role)
+	}
+	proxy.roles = rpc.NewUpstreams(proxy.upstreams)
+
+	if cfg.Proxy.ShareValidation.Enabled || cfg.Proxy.Stratum.Enabled {
+		proxy.validator = newShareValidator(&cfg.Proxy.ShareValidation)
+		proxy.light = newLightHasher()
+	}
+	if cfg.Proxy.ShareValidation.Enabled {
+		log.Infof("Share validation: %v workers, %v%% of shares and all block candidates fully verified", cfg.Proxy.ShareValidation.Workers, cfg.Proxy.ShareValidation.SamplePercent)
+	}
+	proxy.upstream = proxy.firstWorkUpstream()
+	log.Infof("Default upstream: %s => %s", proxy.rpc().Name, proxy.rpc().Url)
+
+	proxy.openJournal()
+	proxy.initBufferedMode()
+	if cfg.Proxy.ShareLog.Enabled {
+		proxy.shareLog = newShareLogger(&cfg.Proxy.ShareLog, cfg.Name, db)
+		log.Infof("Logging accepted shares to %v, kept %v", cfg.Proxy.ShareLog.Sink, proxy.shareLog.retention)
+	}
+	if cfg.Proxy.Withholding.Enabled {
+		proxy.withholding = newWithholdingCounter(&cfg.Proxy.Withholding, cfg.Name, backend)
+		log.Infof("Counting near-block shares at 1/%v of the block difficulty", cfg.Proxy.Withholding.NearBlockFactor)
 	}
-	log.Printf("Default upstream: %s => %s", proxy.rpc().Name, proxy.rpc().Url)
 
 	if cfg.Proxy.Stratum.Enabled {
+		if cfg.Proxy.Stratum.VarDiff.Enabled {
+			proxy.varDiff = newVarDiff(&cfg.Proxy.Stratum.VarDiff, cfg.Proxy.Difficulty)
+			log.Infof("Stratum vardiff: %v-%v, a share every %v", proxy.varDiff.minDiff, proxy.varDiff.maxDiff, proxy.varDiff.targetTime)
+		}
+		if cfg.Proxy.Stratum.Connections.Enabled {
+			proxy.conns = newConnManager(&cfg.Proxy.Stratum.Connections, proxy.logs)
+		}
 		proxy.sessions = make(map[*Session]struct{})
+		proxy.initSessionLog()
+		proxy.extranonces = newExtranoncePool(cfg.Proxy.Stratum.ExtranonceSize)
+		if size := proxy.extranonces.size; size > maxExtranonceSize {
+			log.Fatalf("proxy.stratum.extranonceSize is %v bytes, at most %v", size, maxExtranonceSize)
+		}
+		proxy.sendQueueSize = cfg.Proxy.Stratum.SendQueue
+		if proxy.sendQueueSize <= 0 {
+			proxy.sendQueueSize = defaultSendQueue
+		}
 		go proxy.ListenTCP()
 	}
 
+	if cfg.Proxy.ShareAudit.Enabled {
+		if proxy.light == nil {
+			proxy.light = newLightHasher()
+		}
+		proxy.auditor = newShareAuditor(&cfg.Proxy.ShareAudit, proxy, proxy.light)
+		log.Infof("Share audits: %v%% of accepted shares revalidated, full DAG: %v", cfg.Proxy.ShareAudit.SamplePercent, cfg.Proxy.ShareAudit.FullDag)
+	}
+
 	proxy.reportRates = make(map[string]*ReportedRate,0)
 	proxy.subMiner = make(map[string]*MinerSubInfo,0)
 
 	proxy.InitSubLogin()
+	proxy.loadExchanges()
 	proxy.fetchBlockTemplate()
+	if t := proxy.currentBlockTemplate(); proxy.light != nil && t != nil {
+		// Verification cache for EthereumStratum shares takes a while to build.
+		go proxy.light.cache(t.Height / epochLength)
+	}
 
 	proxy.hashrateExpiration = util.MustParseDuration(cfg.Proxy.HashrateExpiration)
+	proxy.duplicateWindow = 10 * time.Minute
+	if len(cfg.Proxy.DuplicateWindow) > 0 {
+		proxy.duplicateWindow = util.MustParseDuration(cfg.Proxy.DuplicateWindow)
+	}
 
 	refreshIntv := util.MustParseDuration(cfg.Proxy.BlockRefreshInterval)
 	refreshTimer := time.NewTimer(refreshIntv)
-	log.Printf("Set block refresh every %v", refreshIntv)
+	log.Infof("Set block refresh every %v", refreshIntv)
 
 	checkIntv := util.MustParseDuration(cfg.UpstreamCheckInterval)
 	checkTimer := time.NewTimer(checkIntv)
@@ -106,9 +254,13 @@ func NewProxy(cfg *Config, backend *redis.RedisClient, db *mysql.Database) *Prox
 	quit := make(chan struct{})
 	hooks := make(chan struct{})
 
-	plogger.InsertLog("START PROXY SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
-	hook.RegistryHook("proxy.go", func(name string) {
-		plogger.InsertLog("SHUTDOWN PROXY SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+	proxy.logs.InsertLog("START PROXY SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+	hook.RegistryPhaseHook(hook.PhaseDrain, util.Join("proxy.go", cfg.Name), func(name string) {
+		proxy.logs.InsertLog("SHUTDOWN PROXY SERVER", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+		proxy.drain()
+		if proxy.journal != nil {
+			proxy.journal.Close()
+		}
 		close(quit)
 		<- hooks
 	})
@@ -144,7 +296,7 @@ func NewProxy(cfg *Config, backend *redis.RedisClient, db *mysql.Database) *Prox
 				if t != nil {
 					err := backend.WriteNodeState(cfg.Name, t.Height, t.Difficulty)
 					if err != nil {
-						log.Printf("Failed to write node state to backend: %v", err)
+						log.Errorf("Failed to write node state to backend: %v", err)
 						proxy.markSick()
 					} else {
 						proxy.markOk()
@@ -175,15 +327,30 @@ func (s *ProxyServer) RedisMessage(payload string) {
 		s.policy.RefreshBanWhiteList()
 	case redis.OpcodeMinerSub:
 		s.InitSubLogin()
+	case redis.OpcodeExchange:
+		s.loadExchanges()
+	case redis.OpcodeBans:
+		s.policy.RefreshBans()
+	case redis.OpcodeBanLift:
+		if kind, value, ok := redis.ParseBanPayload(msg); ok {
+			s.policy.LiftBan(kind, value)
+		}
 	default:
-		log.Printf("not defined opcode: %v", opcode)
+		log.Errorf("not defined opcode: %v", opcode)
 	}
 
 	fmt.Printf("(opcode:%v from:%s)RedisMessage: %s\n", opcode, from, msg)
 }
 
 func (s *ProxyServer) Start() {
-	log.Printf("Starting proxy on %v", s.config.Proxy.Listen)
+	// Stratum needs the pool messages too, e.g. bans and exchange flags
+	s.backend.InitPubSub("proxy",s)
+
+	if len(s.config.Proxy.Listen) == 0 {
+		log.Infof("HTTP getwork is disabled, serving stratum only")
+		return
+	}
+	log.Infof("Starting proxy on %v", s.config.Proxy.Listen)
 	r := mux.NewRouter()
 	r.Handle("/{login:0x[0-9a-fA-F]{40}}/{id:[0-9a-zA-Z-_]{1,8}}", s)
 	r.Handle("/{login:0x[0-9a-fA-F]{40}}", s)
@@ -193,10 +360,11 @@ func (s *ProxyServer) Start() {
 		MaxHeaderBytes: s.config.Proxy.LimitHeadersSize,
 	}
 
-	s.backend.InitPubSub("proxy",s)
-
+	if !s.trackHttpServer(srv) {
+		return
+	}
 	err := srv.ListenAndServe()
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Failed to start proxy: %v", err)
 	}
 }
@@ -206,21 +374,71 @@ func (s *ProxyServer) rpc() *rpc.RPCClient {
 	return s.upstreams[i]
 }
 
-func (s *ProxyServer) checkUpstreams() {
-	candidate := int32(0)
-	backup := false
+// submitRpc returns the upstream used to submit found blocks.
+func (s *ProxyServer) submitRpc() *rpc.RPCClient {
+	return s.roles.Submit()
+}
+
+// submitBlock sends a solution to a submit node. Work is bound to the node that issued it,
+// so a rejected solution is retried on the current work upstream.
+func (s *ProxyServer) submitBlock(params []string) (bool, error) {
+	work := s.rpc()
+	submit := s.submitRpc()
+	ok, err := submit.SubmitBlock(params)
+	if (err != nil || !ok) && submit != work {
+		log.Warnf("Block submission on %v failed, retrying on %v", submit.Name, work.Name)
+		return work.SubmitBlock(params)
+	}
+	return ok, err
+}
+
+// Work comes from submit nodes first, archive nodes only when nothing else is configured.
+func (s *ProxyServer) workUpstreamRank(v *rpc.RPCClient) int {
+	switch v.Role {
+	case rpc.RoleSubmit:
+		return 0
+	case rpc.RoleArchive:
+		return 2
+	}
+	return 1
+}
 
+func (s *ProxyServer) firstWorkUpstream() int32 {
+	candidate := int32(0)
 	for i, v := range s.upstreams {
-		if v.Check() && !backup {
+		if s.workUpstreamRank(v) < s.workUpstreamRank(s.upstreams[candidate]) {
 			candidate = int32(i)
-			backup = true
+		}
+	}
+	return candidate
+}
+
+func (s *ProxyServer) checkUpstreams() {
+	candidate := s.firstWorkUpstream()
+	backup := false
+
+	for rank := 0; rank <= 2 && !backup; rank++ {
+		for i, v := range s.upstreams {
+			if s.workUpstreamRank(v) != rank {
+				continue
+			}
+			if v.Check() && !backup {
+				candidate = int32(i)
+				backup = true
+			}
 		}
 	}
 
 	if s.upstream != candidate {
-		log.Printf("Switching to %v upstream", s.upstreams[candidate].Name)
+		log.Warnf("Switching to %v upstream", s.upstreams[candidate].Name)
 		atomic.StoreInt32(&s.upstream, candidate)
 	}
+
+	if backup {
+		s.endOutage()
+	} else {
+		s.startOutage()
+	}
 }
 
 func (s *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -230,7 +448,7 @@ func (s *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	ip := s.remoteAddr(r)
 	if !s.policy.CheckInboundIP(ip) {
-		log.Printf("Invalid Ip : %s", ip)
+		log.Warnf("Invalid Ip : %s", ip)
 		s.writeError(w, 404, "rpc: authenticationError, received "+r.Method)
 		return
 	}
@@ -252,7 +470,7 @@ func (s *ProxyServer) remoteAddr(r *http.Request) string {
 
 func (s *ProxyServer) handleClient(w http.ResponseWriter, r *http.Request, ip string) {
 	if r.ContentLength > s.config.Proxy.LimitBodySize {
-		log.Printf("Socket flood from %s", ip)
+		log.Warnf("Socket flood from %s", ip)
 		s.policy.ApplyMalformedPolicy(ip)
 		http.Error(w, "Request too large", http.StatusExpectationFailed)
 		return
@@ -260,14 +478,14 @@ func (s *ProxyServer) handleClient(w http.ResponseWriter, r *http.Request, ip st
 	r.Body = http.MaxBytesReader(w, r.Body, s.config.Proxy.LimitBodySize)
 	defer r.Body.Close()
 
-	cs := &Session{ip: ip, enc: json.NewEncoder(w)}
+	cs := &Session{ip: ip, enc: json.NewEncoder(w), region: s.config.Proxy.Region}
 	dec := json.NewDecoder(r.Body)
 	for {
 		var req JSONRpcReq
 		if err := dec.Decode(&req); err == io.EOF {
 			break
 		} else if err != nil {
-			log.Printf("Malformed request from %v: %v", ip, err)
+			log.Warnf("Malformed request from %v: %v", ip, err)
 			s.policy.ApplyMalformedPolicy(ip)
 			return
 		}
@@ -277,7 +495,7 @@ func (s *ProxyServer) handleClient(w http.ResponseWriter, r *http.Request, ip st
 
 func (cs *Session) handleMessage(s *ProxyServer, r *http.Request, req *JSONRpcReq) {
 	if req.Id == nil {
-		log.Printf("Missing RPC id from %s", cs.ip)
+		log.Warnf("Missing RPC id from %s", cs.ip)
 		s.policy.ApplyMalformedPolicy(cs.ip)
 		return
 	}
@@ -310,7 +528,7 @@ func (cs *Session) handleMessage(s *ProxyServer, r *http.Request, req *JSONRpcRe
 			var params []string
 			err := json.Unmarshal(req.Params, &params)
 			if err != nil {
-				log.Printf("Unable to parse params from %v", cs.ip)
+				log.Errorf("Unable to parse params from %v", cs.ip)
 				s.policy.ApplyMalformedPolicy(cs.ip)
 				break
 			}
@@ -332,7 +550,7 @@ func (cs *Session) handleMessage(s *ProxyServer, r *http.Request, req *JSONRpcRe
 		var params []string
 		err := json.Unmarshal(req.Params, &params)
 		if err != nil {
-			log.Println("Malformed stratum request params from", cs.ip)
+			log.Warn("Malformed stratum request params from", cs.ip)
 			return
 		}
 		s.handleSubmitHashRateRPC(cs, cs.login, params[0], "")
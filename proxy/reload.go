@@ -0,0 +1,24 @@
+package proxy
+
+import "fmt"
+
+// Reload applies the vardiff parameters and the ban policy of cfg to the running proxy, without
+// dropping stratum connections. Turning vardiff on or off needs a restart.
+func (s *ProxyServer) Reload(cfg *Config) error {
+	if s.varDiff != nil {
+		if !cfg.Proxy.Stratum.VarDiff.Enabled {
+			log.Warnf("Stratum vardiff stays on until a restart")
+		} else if err := s.varDiff.configure(&cfg.Proxy.Stratum.VarDiff); err != nil {
+			return fmt.Errorf("invalid varDiff: %v", err)
+		} else {
+			v := s.varDiff
+			v.mu.RLock()
+			log.Infof("Reloaded stratum vardiff: %v-%v, a share every %v", v.minDiff, v.maxDiff, v.targetTime)
+			v.mu.RUnlock()
+		}
+	} else if cfg.Proxy.Stratum.VarDiff.Enabled {
+		log.Warnf("Stratum vardiff stays off until a restart")
+	}
+	s.policy.Reload(&cfg.Proxy.Policy)
+	return nil
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Messages queued per stratum connection by default before it's dropped as a slow consumer.
+const defaultSendQueue = 256
+
+var (
+	errSlowConsumer = errors.New("send queue full")
+	errQueueClosed  = errors.New("send queue closed")
+)
+
+// sendQueue writes the messages of a stratum connection from its own goroutine, so a job broadcast
+// never waits on a miner. Messages queued while a write is in progress go out in the next one.
+type sendQueue struct {
+	mu     sync.Mutex
+	closed bool
+	out    chan []byte
+	done   chan struct{}
+	conn   net.Conn
+}
+
+func newSendQueue(conn net.Conn, size int) *sendQueue {
+	q := &sendQueue{conn: conn, out: make(chan []byte, size), done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+func (q *sendQueue) run() {
+	defer close(q.done)
+	w := bufio.NewWriter(q.conn)
+	for msg := range q.out {
+		w.Write(msg)
+		for n := len(q.out); n > 0; n-- {
+			w.Write(<-q.out)
+		}
+		if err := w.Flush(); err != nil {
+			// Unblocks the reader of the session, which closes the queue.
+			q.conn.Close()
+			for range q.out {
+			}
+			return
+		}
+	}
+}
+
+// send queues a message, errSlowConsumer when the connection doesn't keep up.
+func (q *sendQueue) send(msg []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return errQueueClosed
+	}
+	select {
+	case q.out <- msg:
+		return nil
+	default:
+		return errSlowConsumer
+	}
+}
+
+// close stops taking messages and waits until the queued ones are written.
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.out)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
+
+// closeBy closes the queue, giving up on the messages not written by deadline.
+func (q *sendQueue) closeBy(deadline time.Time) {
+	q.conn.SetWriteDeadline(deadline)
+	q.close()
+}
+
+// encodeMessage serializes a message like json.Encoder, with the newline ending it.
+func encodeMessage(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// write sends a message to cs, through its queue on stratum. Must be called locked.
+func (cs *Session) write(v interface{}) error {
+	if cs.queue == nil {
+		return cs.enc.Encode(v)
+	}
+	data, err := encodeMessage(v)
+	if err != nil {
+		return err
+	}
+	return cs.queue.send(data)
+}
+
+// jobMessages are the messages of a job serialized once for all the sessions it goes to. They
+// only differ by the share difficulty.
+type jobMessages struct {
+	mu sync.Mutex
+	t  *BlockTemplate
+	// eth-proxy jobs by share target
+	work map[string][]byte
+	// EthereumStratum mining.notify and mining.set_difficulty by share difficulty
+	notify []byte
+	diffs  map[int64][]byte
+}
+
+func newJobMessages(t *BlockTemplate) *jobMessages {
+	return &jobMessages{t: t, work: make(map[string][]byte), diffs: make(map[int64][]byte)}
+}
+
+func (m *jobMessages) workMessage(target string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if data, ok := m.work[target]; ok {
+		return data, nil
+	}
+	// FIXME: Temporarily add ID for Claymore compliance
+	data, err := encodeMessage(&JSONPushMessage{Version: "2.0", Result: []string{m.t.Header, m.t.Seed, target}, Id: 0})
+	if err == nil {
+		m.work[target] = data
+	}
+	return data, err
+}
+
+func (m *jobMessages) notifyMessage() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.notify != nil {
+		return m.notify, nil
+	}
+	t := m.t
+	params := []interface{}{t.Header[2 : 2+jobIdLength], strings.TrimPrefix(t.Seed, "0x"), strings.TrimPrefix(t.Header, "0x"), true}
+	data, err := encodeMessage(&JSONNotification{Method: "mining.notify", Params: params})
+	if err == nil {
+		m.notify = data
+	}
+	return data, err
+}
+
+func (m *jobMessages) difficultyMessage(cs *Session, diff int64) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if data, ok := m.diffs[diff]; ok {
+		return data, nil
+	}
+	data, err := encodeMessage(&JSONNotification{Method: "mining.set_difficulty", Params: []interface{}{cs.difficultyEncoder().Encode(diff)}})
+	if err == nil {
+		m.diffs[diff] = data
+	}
+	return data, err
+}
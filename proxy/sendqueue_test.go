@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSendQueueWritesInOrder(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	q := newSendQueue(server, 8)
+
+	lines := make(chan string, 3)
+	go func() {
+		r := bufio.NewReader(client)
+		for i := 0; i < 3; i++ {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			lines <- line
+		}
+		close(lines)
+	}()
+	for _, msg := range []string{"a\n", "b\n", "c\n"} {
+		if err := q.send([]byte(msg)); err != nil {
+			t.Fatalf("Must queue %q, got %v", msg, err)
+		}
+	}
+	q.close()
+
+	var got []string
+	for line := range lines {
+		got = append(got, line)
+	}
+	if len(got) != 3 || got[0] != "a\n" || got[1] != "b\n" || got[2] != "c\n" {
+		t.Errorf("Must write the messages in order, got %q", got)
+	}
+	if err := q.send([]byte("d\n")); err != errQueueClosed {
+		t.Errorf("Must refuse messages once closed, got %v", err)
+	}
+}
+
+func TestSendQueueSlowConsumer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	q := newSendQueue(server, 2)
+	defer q.closeBy(time.Now())
+
+	// Nothing reads the pipe: the writer holds one message and the queue the next ones
+	var err error
+	for i := 0; i < 4 && err == nil; i++ {
+		err = q.send([]byte("job\n"))
+	}
+	if err != errSlowConsumer {
+		t.Errorf("Must drop a connection that doesn't read, got %v", err)
+	}
+}
+
+func TestSendQueueCloseBy(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	q := newSendQueue(server, 8)
+	q.send([]byte("job\n"))
+
+	closed := make(chan struct{})
+	go func() {
+		q.closeBy(time.Now().Add(50 * time.Millisecond))
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Must give up on a connection that doesn't read by the deadline")
+	}
+	if _, err := client.Write([]byte("x")); err == nil {
+		t.Error("Must close the connection after a failed write")
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	data, err := encodeMessage(&JSONNotification{Method: "client.reconnect", Params: []interface{}{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"id":null,"method":"client.reconnect","params":[]}`+"\n" {
+		t.Errorf("Must end the message with a newline, got %q", data)
+	}
+}
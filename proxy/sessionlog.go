@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type SessionLogConfig struct {
+	// Record every stratum session of a login when it ends, returned by the account API
+	Enabled bool `json:"enabled"`
+	// Sessions kept per login, 100 by default
+	MaxSessions int64 `json:"maxSessions"`
+	// Sessions that ended longer ago are dropped, "168h" by default
+	Retention string `json:"retention"`
+}
+
+func (s *ProxyServer) initSessionLog() {
+	cfg := &s.config.Proxy.Stratum.SessionLog
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = 100
+	}
+	s.sessionRetention = 168 * time.Hour
+	if len(cfg.Retention) > 0 {
+		s.sessionRetention = util.MustParseDuration(cfg.Retention)
+	}
+}
+
+// countSessionShare adds a valid share of diff to the session's average difficulty.
+func (cs *Session) countSessionShare(diff int64) {
+	atomic.AddInt64(&cs.validShares, 1)
+	atomic.AddInt64(&cs.diffSum, diff)
+}
+
+// logSession records the session of a logged in miner once its connection is closed.
+func (s *ProxyServer) logSession(cs *Session, err error) {
+	if s.sessionRetention == 0 || len(cs.login) == 0 {
+		return
+	}
+	worker := cs.worker
+	if len(worker) == 0 {
+		worker = cs.loginWorker
+	}
+	if !workerPattern.MatchString(worker) {
+		worker = "0"
+	}
+	protocol := cs.protocol
+	if len(protocol) == 0 {
+		protocol = "stratum"
+	}
+	record := &redis.WorkerSession{
+		Worker:         worker,
+		IP:             cs.ip,
+		Protocol:       protocol,
+		Hostname:       s.config.Proxy.StratumHostname,
+		Region:         cs.region,
+		ConnectedAt:    cs.connectedAt.Unix(),
+		DisconnectedAt: time.Now().Unix(),
+		Shares:         atomic.LoadInt64(&cs.validShares),
+		Reason:         disconnectReason(err),
+	}
+	if record.Shares > 0 {
+		record.AvgDiff = atomic.LoadInt64(&cs.diffSum) / record.Shares
+	}
+	err = s.backend.WriteWorkerSession(cs.login, record, s.config.Proxy.Stratum.SessionLog.MaxSessions, s.sessionRetention)
+	if metrics.RedisError(s.config.Name, "write_session", err) != nil {
+		log.Errorf("Failed to write session of %v.%v: %v", cs.login, worker, err)
+	}
+}
+
+// disconnectReason tells how a stratum session ended from the error it was closed with.
+func disconnectReason(err error) string {
+	if err == nil {
+		return "closed"
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return "timeout"
+	}
+	return err.Error()
+}
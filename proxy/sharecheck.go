@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type ShareValidationConfig struct {
+	Enabled bool `json:"enabled"`
+	// Goroutines running full PoW verification, defaults to the number of CPUs
+	Workers int `json:"workers"`
+	// Percent of regular shares fully verified, 0 for block candidates only. Block candidates always are
+	SamplePercent float64 `json:"samplePercent"`
+}
+
+// shareValidator verifies block candidates and a sample of the other shares a second time, on a
+// bounded pool of workers, by recomputing their mix digest with the pool's own hashimoto. Every
+// share is still verified by the ethash library first. A share whose digest doesn't match bans its IP.
+// The workers also compute the mix digests of EthereumStratum shares, with validation disabled too.
+type shareValidator struct {
+	config *ShareValidationConfig
+	jobs   chan func()
+
+	verified int64
+	failed   int64
+}
+
+func newShareValidator(cfg *ShareValidationConfig) *shareValidator {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	v := &shareValidator{config: cfg, jobs: make(chan func(), cfg.Workers*64)}
+	for i := 0; i < cfg.Workers; i++ {
+		go v.worker()
+	}
+	return v
+}
+
+func (v *shareValidator) worker() {
+	for job := range v.jobs {
+		job()
+	}
+}
+
+// verify waits for a worker to recompute the mix digest of the share and check it against the
+// claimed one and the share difficulty, sessions block while all are busy.
+func (v *shareValidator) verify(light *lightHasher, share Block) bool {
+	result := make(chan bool, 1)
+	v.jobs <- func() {
+		digest, pow := light.compute(share.number, share.hashNoNonce, share.nonce)
+		result <- digest == share.mixDigest && meetsTarget(pow.Big(), share.difficulty)
+	}
+	ok := <-result
+	atomic.AddInt64(&v.verified, 1)
+	if !ok {
+		atomic.AddInt64(&v.failed, 1)
+	}
+	return ok
+}
+
+// mixDigest waits for a worker to compute the mix digest of nonce for the header hash at height.
+func (v *shareValidator) mixDigest(light *lightHasher, height uint64, hashNoNonce common.Hash, nonce uint64) common.Hash {
+	result := make(chan common.Hash, 1)
+	v.jobs <- func() {
+		digest, _ := light.compute(height, hashNoNonce, nonce)
+		result <- digest
+	}
+	return <-result
+}
+
+func (v *shareValidator) sample() bool {
+	return rand.Float64()*100 < v.config.SamplePercent
+}
+
+// verifyShare returns the first of diffs the share meets and whether it meets the block difficulty.
+func (s *ProxyServer) verifyShare(login, id, ip string, block Block, diffs []int64) (int64, bool) {
+	var shareDiff int64
+	for _, diff := range diffs {
+		share := block
+		share.difficulty = big.NewInt(diff)
+		if hasher.Verify(share) {
+			shareDiff = diff
+			break
+		}
+	}
+	if shareDiff == 0 {
+		return 0, false
+	}
+	isBlock := s.verifyBlock(login, id, block, shareDiff)
+	if s.validator == nil || !s.validator.config.Enabled || (!isBlock && !s.validator.sample()) {
+		return shareDiff, isBlock
+	}
+
+	share := block
+	share.difficulty = big.NewInt(shareDiff)
+	if !s.validator.verify(s.light, share) {
+		verified, failed := atomic.LoadInt64(&s.validator.verified), atomic.LoadInt64(&s.validator.failed)
+		log.Warnf("Forged mix digest from %v@%v at height %v, %v of %v verified shares failed", login, ip, block.number, failed, verified)
+		s.logs.InsertLog(fmt.Sprintf("FAKE SHARE %v@%v at height %v", login, ip, block.number),
+			plogger.LogTypeSystem, plogger.LogSubTypeFakeShare, 0, int64(block.number), login, "")
+		s.policy.BanClient(ip)
+		return 0, false
+	}
+	return shareDiff, isBlock
+}
+
+func meetsTarget(result *big.Int, diff *big.Int) bool {
+	return diff.Sign() > 0 && result.Cmp(util.DiffToTarget(diff)) <= 0
+}
@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type ShareLogConfig struct {
+	// Log every accepted share with its login, worker, IP, difficulty and job, for investigations
+	Enabled bool `json:"enabled"`
+	// "mysql" (default) inserts batches into share_log, "file" appends JSON lines to a file a day in path
+	Sink string `json:"sink"`
+	Path string `json:"path"`
+	// Shares written at once, 1000 by default, and how long one waits at most, "1s" by default
+	BatchSize     int    `json:"batchSize"`
+	FlushInterval string `json:"flushInterval"`
+	// Shares waiting to be written, further ones are dropped. 65536 by default
+	QueueSize int `json:"queueSize"`
+	// Logged shares are dropped this long after, "720h" by default
+	Retention string `json:"retention"`
+}
+
+const (
+	ShareLogMysql = "mysql"
+	ShareLogFile  = "file"
+)
+
+// Kinds of the logged shares
+const (
+	shareKindValid    = "valid"
+	shareKindBlock    = "block"
+	shareKindStale    = "stale"
+	shareKindBuffered = "buffered"
+)
+
+// How often the shares past the retention are dropped
+const shareLogPruneInterval = time.Hour
+
+type shareLogSink interface {
+	write(entries []*mysql.ShareLogEntry) error
+	// prune drops the shares logged before, returns how many or the files removed
+	prune(before time.Time) (int64, error)
+	close() error
+}
+
+// shareLogger writes the accepted shares to the sink in batches, from its own goroutine. Shares are
+// dropped rather than slowing down the submit path when the sink doesn't keep up.
+type shareLogger struct {
+	name      string
+	sink      shareLogSink
+	entries   chan *mysql.ShareLogEntry
+	batchSize int
+	flushIntv time.Duration
+	retention time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newShareLogger(cfg *ShareLogConfig, name string, db *mysql.Database) *shareLogger {
+	l := &shareLogger{
+		name:      name,
+		batchSize: cfg.BatchSize,
+		flushIntv: time.Second,
+		retention: 720 * time.Hour,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if l.batchSize <= 0 {
+		l.batchSize = 1000
+	}
+	if len(cfg.FlushInterval) > 0 {
+		l.flushIntv = util.MustParseDuration(cfg.FlushInterval)
+	}
+	if len(cfg.Retention) > 0 {
+		l.retention = util.MustParseDuration(cfg.Retention)
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 65536
+	}
+	l.entries = make(chan *mysql.ShareLogEntry, queueSize)
+
+	if len(cfg.Sink) == 0 {
+		cfg.Sink = ShareLogMysql
+	}
+	switch cfg.Sink {
+	case ShareLogMysql:
+		l.sink = &mysqlShareLog{db: db}
+	case ShareLogFile:
+		if len(cfg.Path) == 0 {
+			cfg.Path = "sharelog"
+		}
+		if err := os.MkdirAll(cfg.Path, 0755); err != nil {
+			log.Fatalf("Failed to create share log directory: %v", err)
+		}
+		l.sink = &fileShareLog{dir: cfg.Path}
+	default:
+		log.Fatalf("Invalid proxy.shareLog.sink %v, must be %v or %v", cfg.Sink, ShareLogMysql, ShareLogFile)
+	}
+	go l.run()
+	return l
+}
+
+// log queues an accepted share.
+func (l *shareLogger) log(e *mysql.ShareLogEntry) {
+	select {
+	case l.entries <- e:
+	default:
+		metrics.ShareLog.Inc(l.name, "dropped")
+	}
+}
+
+func (l *shareLogger) run() {
+	defer close(l.done)
+	flushTimer := time.NewTicker(l.flushIntv)
+	defer flushTimer.Stop()
+	pruneTimer := time.NewTicker(shareLogPruneInterval)
+	defer pruneTimer.Stop()
+
+	l.prune()
+	batch := make([]*mysql.ShareLogEntry, 0, l.batchSize)
+	for {
+		select {
+		case e := <-l.entries:
+			batch = append(batch, e)
+			if len(batch) < l.batchSize {
+				continue
+			}
+		case <-flushTimer.C:
+		case <-pruneTimer.C:
+			l.prune()
+			continue
+		case <-l.stop:
+			for n := len(l.entries); n > 0; n-- {
+				batch = append(batch, <-l.entries)
+			}
+			l.flush(batch)
+			if err := l.sink.close(); err != nil {
+				log.Errorf("Failed to close share log: %v", err)
+			}
+			return
+		}
+		l.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func (l *shareLogger) flush(batch []*mysql.ShareLogEntry) {
+	for len(batch) > 0 {
+		n := len(batch)
+		if n > l.batchSize {
+			n = l.batchSize
+		}
+		if err := l.sink.write(batch[:n]); err != nil {
+			log.Errorf("Failed to write %v shares to the share log: %v", n, err)
+			metrics.ShareLog.Add(float64(n), l.name, "failed")
+		} else {
+			metrics.ShareLog.Add(float64(n), l.name, "written")
+		}
+		batch = batch[n:]
+	}
+}
+
+func (l *shareLogger) prune() {
+	n, err := l.sink.prune(time.Now().Add(-l.retention))
+	if err != nil {
+		log.Errorf("Failed to prune share log: %v", err)
+	} else if n > 0 {
+		log.Infof("Pruned %v from the share log, older than %v", n, l.retention)
+	}
+}
+
+// close writes the queued shares and stops the logger.
+func (l *shareLogger) close() {
+	close(l.stop)
+	<-l.done
+}
+
+// logShare logs a share accepted at diff for the job of header.
+func (s *ProxyServer) logShare(login, worker, ip, header string, diff int64, height uint64, kind string) {
+	if s.shareLog == nil {
+		return
+	}
+	s.shareLog.log(&mysql.ShareLogEntry{
+		Login:     login,
+		Worker:    worker,
+		IP:        ip,
+		Diff:      diff,
+		Job:       header,
+		Height:    height,
+		Kind:      kind,
+		Timestamp: util.MakeTimestamp(),
+	})
+}
+
+type mysqlShareLog struct {
+	db *mysql.Database
+}
+
+func (m *mysqlShareLog) write(entries []*mysql.ShareLogEntry) error {
+	return m.db.WriteShareLog(entries)
+}
+
+func (m *mysqlShareLog) prune(before time.Time) (int64, error) {
+	return m.db.DeleteShareLog(before.UnixNano() / int64(time.Millisecond))
+}
+
+func (m *mysqlShareLog) close() error {
+	return nil
+}
+
+// Files of the file sink, one a day in UTC
+const (
+	shareLogPrefix = "shares-"
+	shareLogSuffix = ".log"
+	shareLogDay    = "2006-01-02"
+)
+
+// fileShareLog appends the shares to the file of the day as JSON lines. Files are only used from the
+// logger's goroutine.
+type fileShareLog struct {
+	dir  string
+	day  string
+	file *os.File
+	w    *bufio.Writer
+}
+
+func (f *fileShareLog) write(entries []*mysql.ShareLogEntry) error {
+	day := time.Now().UTC().Format(shareLogDay)
+	if day != f.day {
+		if err := f.close(); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(filepath.Join(f.dir, shareLogPrefix+day+shareLogSuffix), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		f.day, f.file, f.w = day, file, bufio.NewWriter(file)
+	}
+	enc := json.NewEncoder(f.w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return f.w.Flush()
+}
+
+// prune removes the files of the days that ended before.
+func (f *fileShareLog) prune(before time.Time) (int64, error) {
+	names, err := filepath.Glob(filepath.Join(f.dir, shareLogPrefix+"*"+shareLogSuffix))
+	if err != nil {
+		return 0, err
+	}
+	removed := int64(0)
+	for _, name := range names {
+		day := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), shareLogPrefix), shareLogSuffix)
+		start, err := time.Parse(shareLogDay, day)
+		if err != nil || !start.Add(24*time.Hour).Before(before) || day == f.day {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (f *fileShareLog) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.w.Flush()
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	f.day, f.file, f.w = "", nil, nil
+	return err
+}
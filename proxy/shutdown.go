@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reason of the sessions closed by a shutdown, and error of the requests turned away
+var errShuttingDown = errors.New("shutdown")
+
+// Time the getwork requests in flight get to finish
+const httpDrainTimeout = 10 * time.Second
+
+// Time the stratum connections get to write their queued messages, a miner not reading them is cut off
+const stratumDrainTimeout = 5 * time.Second
+
+func (s *ProxyServer) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// trackListener keeps a stratum listener to close on shutdown. False once draining.
+func (s *ProxyServer) trackListener(l net.Listener) bool {
+	s.stratumMu.Lock()
+	defer s.stratumMu.Unlock()
+	if s.isDraining() {
+		return false
+	}
+	s.listeners = append(s.listeners, l)
+	return true
+}
+
+// trackClient keeps a stratum connection to close on shutdown. False once draining.
+func (s *ProxyServer) trackClient(cs *Session) bool {
+	s.stratumMu.Lock()
+	defer s.stratumMu.Unlock()
+	if s.isDraining() {
+		return false
+	}
+	s.clients[cs] = struct{}{}
+	s.clientsWg.Add(1)
+	return true
+}
+
+func (s *ProxyServer) untrackClient(cs *Session) {
+	s.stratumMu.Lock()
+	delete(s.clients, cs)
+	s.stratumMu.Unlock()
+	s.clientsWg.Done()
+}
+
+func (s *ProxyServer) trackHttpServer(srv *http.Server) bool {
+	s.stratumMu.Lock()
+	defer s.stratumMu.Unlock()
+	if s.isDraining() {
+		return false
+	}
+	s.httpServer = srv
+	return true
+}
+
+// drain stops taking connections and shares, waits for the shares in flight to be written, and
+// closes the stratum connections. EthereumStratum miners are asked to reconnect first, to this
+// pool once it is back or to their failover. It returns once every session is logged.
+func (s *ProxyServer) drain() {
+	s.stratumMu.Lock()
+	atomic.StoreInt32(&s.draining, 1)
+	listeners := s.listeners
+	srv := s.httpServer
+	s.stratumMu.Unlock()
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	// Returns once the requests in flight are done, the next ones see draining
+	s.requestsMu.Lock()
+	s.requestsMu.Unlock()
+
+	s.stratumMu.Lock()
+	clients := make([]*Session, 0, len(s.clients))
+	for cs := range s.clients {
+		clients = append(clients, cs)
+	}
+	s.stratumMu.Unlock()
+	// All at once, so the connections that don't keep up hold the others back by no more than the timeout
+	deadline := time.Now().Add(stratumDrainTimeout)
+	var wg sync.WaitGroup
+	for _, cs := range clients {
+		wg.Add(1)
+		go func(cs *Session) {
+			defer wg.Done()
+			if cs.isEthereumStratum() {
+				cs.sendReconnect()
+			}
+			if cs.queue != nil {
+				cs.queue.closeBy(deadline)
+			}
+			cs.conn.Close()
+		}(cs)
+	}
+	wg.Wait()
+
+	if srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), httpDrainTimeout)
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Warnf("Getwork requests still in flight after %v: %v", httpDrainTimeout, err)
+		}
+		cancel()
+	}
+	s.clientsWg.Wait()
+	log.Infof("Drained %v stratum connections", len(clients))
+	if s.shareLog != nil {
+		s.shareLog.close()
+	}
+	if s.withholding != nil {
+		s.withholding.close()
+	}
+}
+
+// sendReconnect sends client.reconnect without a host, the miner reconnects to the same one.
+func (cs *Session) sendReconnect() error {
+	cs.Lock()
+	defer cs.Unlock()
+	if cs.queue == nil {
+		return nil
+	}
+	message := JSONNotification{Method: "client.reconnect", Params: []interface{}{}}
+	return cs.write(&message)
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+)
+
+type StaleSharesConfig struct {
+	// Count shares of older jobs still in the backlog as stale instead of valid
+	Enabled bool `json:"enabled"`
+	// Fraction of a stale share's difficulty credited in round shares, e.g. 0.5. 0 rejects them
+	Credit float64 `json:"credit"`
+}
+
+// isStale tells whether a share for the job at height is for an older job than t's.
+func (s *ProxyServer) isStale(t *BlockTemplate, height uint64) bool {
+	return s.config.Proxy.StaleShares.Enabled && height < t.Height
+}
+
+// processStaleShare credits a verified share of an older job at the configured fraction of its difficulty.
+func (s *ProxyServer) processStaleShare(subLogin, login, id, ip, region string, params []string, shareDiff int64, height uint64, count int) (bool, bool) {
+	credit := int64(float64(shareDiff) * s.config.Proxy.StaleShares.Credit)
+	if credit <= 0 {
+		log.Warnf("Stale share from %v@%v", login, ip)
+		s.rejectShare(login, id, redis.RejectStale)
+		s.policy.ApplyStalePolicy(ip, login)
+		return false, false
+	}
+
+	exist, err := s.backend.CheckPoWExist(height, params)
+	if metrics.RedisError(s.config.Name, "check_pow", err) != nil {
+		log.Error("Error: duplicate share redis err:", err)
+		return false, false
+	}
+	if exist {
+		return true, false
+	}
+
+	stratumHostname := s.config.Proxy.StratumHostname
+	err = s.writeShare(&journaledShare{
+		Login: subLogin, DevId: login, Id: id, Params: params, Diff: credit,
+		Height: height, Hostname: stratumHostname, Region: region, LoginCnt: count, Stale: true,
+	})
+	if err != nil {
+		return true, false
+	}
+	s.logShare(subLogin, id, ip, params[1], credit, height, shareKindStale)
+	metrics.Shares.Inc(s.config.Name, "stale_credited")
+	return false, true
+}
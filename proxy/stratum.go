@@ -2,13 +2,16 @@ package proxy
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
 	"github.com/cellcrypto/open-dangnn-pool/util"
 )
 
@@ -16,11 +19,55 @@ const (
 	MaxReqSize = 1024
 )
 
+var errShareRate = &ErrorReply{Code: -1, Message: "Share rate limit exceeded"}
+
 func (s *ProxyServer) ListenTCP() {
 	timeout := util.MustParseDuration(s.config.Proxy.Stratum.Timeout)
 	s.timeout = timeout
 
-	addr, err := net.ResolveTCPAddr("tcp", s.config.Proxy.Stratum.Listen)
+	var listeners []StratumListener
+	if len(s.config.Proxy.Stratum.Listen) > 0 {
+		listeners = append(listeners, StratumListener{Listen: s.config.Proxy.Stratum.Listen, Protocol: s.config.Proxy.Stratum.Protocol})
+	}
+	listeners = append(listeners, s.config.Proxy.Stratum.Listeners...)
+	if len(listeners) == 0 {
+		log.Fatal("Stratum has no listen address")
+	}
+
+	var wg sync.WaitGroup
+	for i := range listeners {
+		l := &listeners[i]
+		if l.MaxConn <= 0 {
+			l.MaxConn = s.config.Proxy.Stratum.MaxConn
+		}
+		if len(l.Region) == 0 {
+			l.Region = s.config.Proxy.Region
+		}
+		if !regionPattern.MatchString(l.Region) {
+			log.Fatalf("Invalid region %q of stratum listener %s", l.Region, l.Listen)
+		}
+		if !stratumProtocols[l.Protocol] {
+			log.Fatalf("Invalid protocol %q of stratum listener %s", l.Protocol, l.Listen)
+		}
+		var tlsConfig *tls.Config
+		if l.TLS != nil {
+			var err error
+			tlsConfig, err = newTLSConfig(l.TLS)
+			if err != nil {
+				log.Fatalf("Stratum TLS on %s: %v", l.Listen, err)
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.listenStratum(l, tlsConfig)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *ProxyServer) listenStratum(l *StratumListener, tlsConfig *tls.Config) {
+	addr, err := net.ResolveTCPAddr("tcp", l.Listen)
 	if err != nil {
 		log.Fatalf("Error: %v", err)
 	}
@@ -29,46 +76,89 @@ func (s *ProxyServer) ListenTCP() {
 		log.Fatalf("Error: %v", err)
 	}
 	defer server.Close()
+	if !s.trackListener(server) {
+		return
+	}
 
-	log.Printf("Stratum listening on %s", s.config.Proxy.Stratum.Listen)
-	var accept = make(chan int, s.config.Proxy.Stratum.MaxConn)
+	protocol := l.Protocol
+	if len(protocol) == 0 {
+		protocol = "any protocol"
+	}
+	if tlsConfig != nil {
+		log.Infof("Stratum listening on %s with TLS, %s", l.Listen, protocol)
+	} else {
+		log.Infof("Stratum listening on %s, %s", l.Listen, protocol)
+	}
+	var accept = make(chan int, l.MaxConn)
 	n := 0
 
 	for {
 		conn, err := server.AcceptTCP()
 		if err != nil {
+			if s.isDraining() {
+				return
+			}
 			continue
 		}
 		conn.SetKeepAlive(true)
 
 		ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 
-		if s.policy.IsBanned(ip) || !s.policy.ApplyLimitPolicy(ip) {
+		if s.policy.IsBanned(ip) || !s.policy.ApplyLimitPolicy(ip) || !s.policy.ApplyChurnPolicy(ip) {
+			conn.Close()
+			continue
+		}
+		if s.conns != nil && !s.conns.acquireIP(ip, time.Now()) {
 			conn.Close()
 			continue
 		}
 		n += 1
-		cs := &Session{conn: conn, ip: ip}
+		cs := &Session{conn: conn, ip: ip, connectedAt: time.Now(), region: l.Region, listenerProtocol: l.Protocol}
+		if tlsConfig != nil {
+			// The handshake runs on the first read, under the session deadline.
+			cs.conn = tls.Server(conn, fingerprintTLS(cs, tlsConfig))
+		}
+
+		if !s.trackClient(cs) {
+			conn.Close()
+			if s.conns != nil {
+				s.conns.release(cs)
+			}
+			return
+		}
 
 		accept <- n
 		go func(cs *Session) {
-			err = s.handleTCPClient(cs)
+			err := s.handleTCPClient(cs)
+			// Writes what's queued, like the error sent to the miner, before closing.
+			cs.queue.close()
 			if err != nil {
 				s.removeSession(cs)
-				conn.Close()
+				cs.conn.Close()
+				if s.isDraining() {
+					err = errShuttingDown
+				}
+			}
+			s.logSession(cs, err)
+			if s.conns != nil {
+				s.conns.release(cs)
 			}
+			if len(cs.extranonce) > 0 {
+				s.extranonces.release(cs.extranonce)
+			}
+			s.untrackClient(cs)
 			<-accept
 		}(cs)
 	}
 }
 
 func (s *ProxyServer) handleTCPClient(cs *Session) error {
-	cs.enc = json.NewEncoder(cs.conn)
+	cs.queue = newSendQueue(cs.conn, s.sendQueueSize)
 	connbuff := bufio.NewReaderSize(cs.conn, MaxReqSize)
 	s.setDeadline(cs.conn)
 
 	if s.policy.CheckInboundIP(cs.ip) {
-		log.Printf("Invalid Ip : %s", cs.ip)
+		log.Warnf("Invalid Ip : %s", cs.ip)
 		s.policy.BanClient(cs.ip)
 		return errors.New("invalid IP")
 	}
@@ -76,15 +166,15 @@ func (s *ProxyServer) handleTCPClient(cs *Session) error {
 	for {
 		data, isPrefix, err := connbuff.ReadLine()
 		if isPrefix {
-			log.Printf("Socket flood detected from %s", cs.ip)
+			log.Warnf("Socket flood detected from %s", cs.ip)
 			s.policy.BanClient(cs.ip)
 			return err
 		} else if err == io.EOF {
-			log.Printf("Client %s disconnected", cs.ip)
+			log.Debugf("Client %s disconnected", cs.ip)
 			s.removeSession(cs)
 			break
 		} else if err != nil {
-			log.Printf(" Error reading from socket: %v", err)
+			log.Errorf(" Error reading from socket: %v", err)
 			return err
 		}
 
@@ -93,7 +183,7 @@ func (s *ProxyServer) handleTCPClient(cs *Session) error {
 			err = json.Unmarshal(data, &req)
 			if err != nil {
 				s.policy.ApplyMalformedPolicy(cs.ip)
-				log.Printf("Malformed stratum request from %s: %v", cs.ip, err)
+				log.Warnf("Malformed stratum request from %s: %v", cs.ip, err)
 				return err
 			}
 			s.setDeadline(cs.conn)
@@ -107,13 +197,26 @@ func (s *ProxyServer) handleTCPClient(cs *Session) error {
 }
 
 func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
+	// The shutdown waits for the requests in flight, later ones are turned away
+	s.requestsMu.RLock()
+	defer s.requestsMu.RUnlock()
+	if s.isDraining() {
+		return errShuttingDown
+	}
+	if len(cs.firstMethod) == 0 {
+		cs.firstMethod = req.Method
+	}
+	if !cs.allowMethod(req.Method) {
+		log.Warnf("%v from %s on a %v listener", req.Method, cs.ip, cs.listenerProtocol)
+		return cs.sendTCPError(req.Id, errProtocol)
+	}
 	// Handle RPC methods
 	switch req.Method {
 	case "eth_submitLogin":
 		var params []string
 		err := json.Unmarshal(req.Params, &params)
 		if err != nil {
-			log.Println("Malformed stratum request params from", cs.ip)
+			log.Warn("Malformed stratum request params from", cs.ip)
 			return err
 		}
 		reply, errReply := s.handleLoginRPC(cs, params, req.Worker)
@@ -131,19 +234,79 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 		var params []string
 		err := json.Unmarshal(req.Params, &params)
 		if err != nil {
-			log.Println("Malformed stratum request params from", cs.ip)
+			log.Warn("Malformed stratum request params from", cs.ip)
 			return err
 		}
+		if s.conns != nil && !s.conns.allowShare(cs, time.Now()) {
+			s.rejectShare(cs.login, req.Worker, redis.RejectRate)
+			return cs.sendTCPError(req.Id, errShareRate)
+		}
 		reply, errReply := s.handleTCPSubmitRPC(cs, req.Worker, params)
 		if errReply != nil {
 			return cs.sendTCPError(req.Id, errReply)
 		}
-		return cs.sendTCPResult(req.Id, &reply)
+		err = cs.sendTCPResult(req.Id, &reply)
+		if err == nil && reply && cs.vardiff != nil && s.varDiff.share(cs.vardiff, time.Now()) {
+			// Hand out the new difficulty right away instead of waiting for the next block.
+			err = s.pushJob(cs)
+		}
+		return err
+	case "mining.subscribe":
+		var params []string
+		err := json.Unmarshal(req.Params, &params)
+		if err != nil {
+			log.Warn("Malformed stratum request params from", cs.ip)
+			return err
+		}
+		reply, errReply := s.handleSubscribeRPC(cs, params)
+		if errReply != nil {
+			return cs.sendTCPError(req.Id, errReply)
+		}
+		return cs.sendTCPResult(req.Id, reply)
+	case "mining.extranonce.subscribe":
+		// The extranonce never changes during a session.
+		return cs.sendTCPResult(req.Id, true)
+	case "mining.authorize":
+		var params []string
+		err := json.Unmarshal(req.Params, &params)
+		if err != nil {
+			log.Warn("Malformed stratum request params from", cs.ip)
+			return err
+		}
+		reply, errReply := s.handleAuthorizeRPC(cs, params)
+		if errReply != nil {
+			return cs.sendTCPError(req.Id, errReply)
+		}
+		err = cs.sendTCPResult(req.Id, reply)
+		if err != nil {
+			return err
+		}
+		return s.pushJob(cs)
+	case "mining.submit":
+		var params []string
+		err := json.Unmarshal(req.Params, &params)
+		if err != nil {
+			log.Warn("Malformed stratum request params from", cs.ip)
+			return err
+		}
+		if s.conns != nil && !s.conns.allowShare(cs, time.Now()) {
+			s.rejectShare(cs.login, cs.worker, redis.RejectRate)
+			return cs.sendTCPError(req.Id, errShareRate)
+		}
+		reply, errReply := s.handleSubmitShareRPC(cs, params)
+		if errReply != nil {
+			return cs.sendTCPError(req.Id, errReply)
+		}
+		err = cs.sendTCPResult(req.Id, reply)
+		if err == nil && reply && cs.vardiff != nil && s.varDiff.share(cs.vardiff, time.Now()) {
+			err = s.pushJob(cs)
+		}
+		return err
 	case "eth_submitHashrate":
 		var params []string
 		err := json.Unmarshal(req.Params, &params)
 		if err != nil {
-			log.Println("Malformed stratum request params from", cs.ip)
+			log.Warn("Malformed stratum request params from", cs.ip)
 			return err
 		}
 		Id := req.Worker
@@ -161,15 +324,7 @@ func (cs *Session) sendTCPResult(id json.RawMessage, result interface{}) error {
 	defer cs.Unlock()
 
 	message := JSONRpcResp{Id: id, Version: "2.0", Error: nil, Result: result}
-	return cs.enc.Encode(&message)
-}
-
-func (cs *Session) pushNewJob(result interface{}) error {
-	cs.Lock()
-	defer cs.Unlock()
-	// FIXME: Temporarily add ID for Claymore compliance
-	message := JSONPushMessage{Version: "2.0", Result: result, Id: 0}
-	return cs.enc.Encode(&message)
+	return cs.write(&message)
 }
 
 func (cs *Session) sendTCPError(id json.RawMessage, reply *ErrorReply) error {
@@ -177,14 +332,14 @@ func (cs *Session) sendTCPError(id json.RawMessage, reply *ErrorReply) error {
 	defer cs.Unlock()
 
 	message := JSONRpcResp{Id: id, Version: "2.0", Error: reply}
-	err := cs.enc.Encode(&message)
+	err := cs.write(&message)
 	if err != nil {
 		return err
 	}
 	return errors.New(reply.Message)
 }
 
-func (self *ProxyServer) setDeadline(conn *net.TCPConn) {
+func (self *ProxyServer) setDeadline(conn net.Conn) {
 	conn.SetDeadline(time.Now().Add(self.timeout))
 }
 
@@ -192,12 +347,14 @@ func (s *ProxyServer) registerSession(cs *Session) {
 	s.sessionsMu.Lock()
 	defer s.sessionsMu.Unlock()
 	s.sessions[cs] = struct{}{}
+	metrics.ProxySessions.Set(float64(len(s.sessions)), s.config.Name)
 }
 
 func (s *ProxyServer) removeSession(cs *Session) {
 	s.sessionsMu.Lock()
 	defer s.sessionsMu.Unlock()
 	delete(s.sessions, cs)
+	metrics.ProxySessions.Set(float64(len(s.sessions)), s.config.Name)
 }
 
 func (s *ProxyServer) broadcastNewJobs() {
@@ -205,32 +362,36 @@ func (s *ProxyServer) broadcastNewJobs() {
 	if t == nil || len(t.Header) == 0 || s.isSick() {
 		return
 	}
-	reply := []string{t.Header, t.Seed, s.diff}
-
 	s.sessionsMu.RLock()
-	defer s.sessionsMu.RUnlock()
-
 	count := len(s.sessions)
-	log.Printf("Broadcasting new job to %v stratum miners  t.Header: %v, t.Seed: %v, t.Difficulty: %v s.diff: %v", count, t.Header, t.Seed, t.Difficulty, s.diff)
+	log.Debugf("Broadcasting new job to %v stratum miners  t.Header: %v, t.Seed: %v, t.Difficulty: %v s.diff: %v", count, t.Header, t.Seed, t.Difficulty, s.diff)
 
+	// Sessions only queue the job, their writers send it.
 	start := time.Now()
-	bcast := make(chan int, 1024)
-	n := 0
-
-	for m, _ := range s.sessions {
-		n++
-		bcast <- n
+	m := newJobMessages(t)
+	var failed []*Session
+	for cs := range s.sessions {
+		if cs.vardiff != nil {
+			s.varDiff.idle(cs.vardiff, start)
+		}
+		err := s.sendJob(cs, m)
+		if err == nil {
+			s.setDeadline(cs.conn)
+			continue
+		}
+		if err == errSlowConsumer {
+			log.Warnf("Dropping %v@%v, it doesn't keep up with the jobs", cs.login, cs.ip)
+			metrics.SlowConsumers.Inc(s.config.Name)
+			cs.conn.Close()
+		} else {
+			log.Errorf("Job transmit error to %v@%v: %v", cs.login, cs.ip, err)
+		}
+		failed = append(failed, cs)
+	}
+	s.sessionsMu.RUnlock()
 
-		go func(cs *Session) {
-			err := cs.pushNewJob(&reply)
-			<-bcast
-			if err != nil {
-				log.Printf("Job transmit error to %v@%v: %v", cs.login, cs.ip, err)
-				s.removeSession(cs)
-			} else {
-				s.setDeadline(cs.conn)
-			}
-		}(m)
+	for _, cs := range failed {
+		s.removeSession(cs)
 	}
-	log.Printf("Jobs broadcast finished %s", time.Since(start))
+	log.Debugf("Jobs broadcast finished %s", time.Since(start))
 }
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type StratumTLS struct {
+	// PEM certificate chain and key, reloaded when the files change, e.g. after a certbot renewal
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// Obtain certificates from Let's Encrypt instead of the files
+	Autocert *AutocertConfig `json:"autocert"`
+}
+
+type AutocertConfig struct {
+	Hosts    []string `json:"hosts"`
+	Email    string   `json:"email"`
+	CacheDir string   `json:"cacheDir"`
+}
+
+// Certificate files are checked for changes at most this often.
+const certCheckInterval = time.Minute
+
+func newTLSConfig(cfg *StratumTLS) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.Autocert != nil {
+		if len(cfg.Autocert.Hosts) == 0 {
+			return nil, errors.New("autocert needs hosts")
+		}
+		if len(cfg.Autocert.CacheDir) == 0 {
+			return nil, errors.New("autocert needs a cacheDir, certificates are rate limited")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.Autocert.Hosts...),
+			Cache:      autocert.DirCache(cfg.Autocert.CacheDir),
+			Email:      cfg.Autocert.Email,
+		}
+		config.GetCertificate = m.GetCertificate
+		return config, nil
+	}
+
+	if len(cfg.CertFile) == 0 || len(cfg.KeyFile) == 0 {
+		return nil, errors.New("certFile and keyFile or autocert must be set")
+	}
+	c := &certFile{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	config.GetCertificate = c.getCertificate
+	return config, nil
+}
+
+// certFile serves a certificate from disk and picks up renewed files without a restart.
+type certFile struct {
+	sync.Mutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+}
+
+func (c *certFile) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = info.ModTime()
+	c.checked = time.Now()
+	return nil
+}
+
+func (c *certFile) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if time.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+	info, err := os.Stat(c.certFile)
+	if err != nil || !info.ModTime().After(c.modTime) {
+		return c.cert, nil
+	}
+	if err := c.load(); err != nil {
+		// Key and certificate may be written one after the other, keep the old pair until both match.
+		log.Errorf("Failed to reload certificate %v: %v", c.certFile, err)
+		return c.cert, nil
+	}
+	log.Infof("Reloaded certificate %v", c.certFile)
+	return c.cert, nil
+}
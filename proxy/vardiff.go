@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type VarDiffConfig struct {
+	Enabled bool `json:"enabled"`
+	// Bounds of a worker's share difficulty, minDiff defaults to proxy difficulty
+	MinDiff int64 `json:"minDiff"`
+	MaxDiff int64 `json:"maxDiff"`
+	// Share interval aimed for, e.g. "4s"
+	TargetTime string `json:"targetTime"`
+	// How often a worker's difficulty is reconsidered
+	RetargetTime string `json:"retargetTime"`
+	// Deviation from targetTime tolerated without retargeting, in percent
+	VariancePercent float64 `json:"variancePercent"`
+	// Raises minDiff to this fraction of the network difficulty, e.g. 0.000004. 0 keeps minDiff fixed
+	NetworkRatio float64 `json:"networkRatio"`
+}
+
+// A single retarget changes difficulty by at most this factor.
+const maxRetargetFactor = 4
+
+type varDiff struct {
+	// Guards the parameters below, replaced on a config reload
+	mu sync.RWMutex
+	// Difficulties are multiples of the proxy difficulty, so every share credits whole share units.
+	baseDiff     int64
+	minDiff      int64
+	maxDiff      int64
+	targetTime   time.Duration
+	retargetTime time.Duration
+	variance     float64
+	networkRatio float64
+	// minDiff raised by the network difficulty, read atomically
+	floor int64
+}
+
+// sessionDiff is the share difficulty of one stratum connection.
+type sessionDiff struct {
+	sync.Mutex
+	diff   int64
+	target string
+	// Shares of jobs sent before the last retarget are accepted at this difficulty
+	prevDiff int64
+	shares   int
+	since    time.Time
+}
+
+func newVarDiff(cfg *VarDiffConfig, baseDiff int64) *varDiff {
+	v := &varDiff{baseDiff: baseDiff}
+	if err := v.configure(cfg); err != nil {
+		log.Fatalf("Invalid varDiff: %v", err)
+	}
+	return v
+}
+
+// configure sets the parameters of cfg. Connected workers reach the new bounds through their
+// retargets, a raised network floor comes back with the next block.
+func (v *varDiff) configure(cfg *VarDiffConfig) error {
+	minDiff, maxDiff := cfg.MinDiff, cfg.MaxDiff
+	targetTime, retargetTime := 4*time.Second, 90*time.Second
+	variance := cfg.VariancePercent
+	if minDiff < v.baseDiff {
+		minDiff = v.baseDiff
+	}
+	if maxDiff <= 0 {
+		maxDiff = minDiff * 1000
+	}
+	if maxDiff < minDiff {
+		return fmt.Errorf("maxDiff %v is below minDiff %v", maxDiff, minDiff)
+	}
+	var err error
+	if len(cfg.TargetTime) > 0 {
+		if targetTime, err = time.ParseDuration(cfg.TargetTime); err != nil || targetTime <= 0 {
+			return fmt.Errorf("invalid targetTime %v", cfg.TargetTime)
+		}
+	}
+	if len(cfg.RetargetTime) > 0 {
+		if retargetTime, err = time.ParseDuration(cfg.RetargetTime); err != nil || retargetTime <= 0 {
+			return fmt.Errorf("invalid retargetTime %v", cfg.RetargetTime)
+		}
+	}
+	if variance <= 0 {
+		variance = 30
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.minDiff, v.maxDiff = minDiff, maxDiff
+	v.targetTime, v.retargetTime = targetTime, retargetTime
+	v.variance = variance
+	v.networkRatio = cfg.NetworkRatio
+	atomic.StoreInt64(&v.floor, minDiff)
+	return nil
+}
+
+// setNetworkDiff moves the difficulty floor with the network difficulty. Connected workers
+// reach a raised floor through their retargets instead of all at once.
+func (v *varDiff) setNetworkDiff(networkDiff int64) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.networkRatio <= 0 {
+		return
+	}
+	floor := int64(float64(networkDiff) * v.networkRatio)
+	if floor < v.minDiff {
+		floor = v.minDiff
+	}
+	if floor > v.maxDiff {
+		floor = v.maxDiff
+	}
+	floor -= floor % v.baseDiff
+	if old := atomic.SwapInt64(&v.floor, floor); old != floor {
+		log.Infof("Stratum vardiff floor moved from %v to %v at network difficulty %v", old, floor, networkDiff)
+	}
+}
+
+func (v *varDiff) newSession(now time.Time) *sessionDiff {
+	diff := atomic.LoadInt64(&v.floor)
+	return &sessionDiff{diff: diff, target: util.EncodeTargetHash(diff), since: now}
+}
+
+// share counts a valid share and retargets. Returns true if the difficulty changed.
+func (v *varDiff) share(d *sessionDiff, now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+	d.shares++
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.retarget(d, now)
+}
+
+// idle retargets a connection that may have stopped sending shares.
+func (v *varDiff) idle(d *sessionDiff, now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.retarget(d, now)
+}
+
+func (v *varDiff) retarget(d *sessionDiff, now time.Time) bool {
+	elapsed := now.Sub(d.since)
+	if elapsed < v.retargetTime {
+		return false
+	}
+	shares := d.shares
+	d.shares = 0
+	d.since = now
+
+	ratio := 1.0 / maxRetargetFactor
+	if shares > 0 {
+		interval := elapsed.Seconds() / float64(shares)
+		target := v.targetTime.Seconds()
+		ratio = 1
+		if math.Abs(interval-target)/target*100 > v.variance {
+			ratio = math.Max(1.0/maxRetargetFactor, math.Min(maxRetargetFactor, target/interval))
+		}
+	}
+	next := v.clamp(int64(float64(d.diff) * ratio))
+	// Only a raised floor can exceed this, it is approached a retarget at a time.
+	if max := d.diff * maxRetargetFactor; next > max {
+		next = max
+	}
+	if next == d.diff {
+		return false
+	}
+	d.prevDiff = d.diff
+	d.diff = next
+	d.target = util.EncodeTargetHash(next)
+	return true
+}
+
+func (v *varDiff) clamp(diff int64) int64 {
+	if floor := atomic.LoadInt64(&v.floor); diff < floor {
+		diff = floor
+	}
+	if diff > v.maxDiff {
+		diff = v.maxDiff
+	}
+	diff -= diff % v.baseDiff
+	if diff < v.baseDiff {
+		diff = v.baseDiff
+	}
+	return diff
+}
+
+// shareTarget is the target hash sent with eth_getWork jobs to cs.
+func (s *ProxyServer) shareTarget(cs *Session) string {
+	if cs.vardiff == nil {
+		return s.diff
+	}
+	cs.vardiff.Lock()
+	defer cs.vardiff.Unlock()
+	return cs.vardiff.target
+}
+
+// shareDiffs lists the difficulties a share from cs is checked against, highest credit first.
+func (s *ProxyServer) shareDiffs(cs *Session) []int64 {
+	if cs.vardiff == nil {
+		return []int64{s.config.Proxy.Difficulty}
+	}
+	cs.vardiff.Lock()
+	defer cs.vardiff.Unlock()
+	diffs := []int64{cs.vardiff.diff}
+	if cs.vardiff.prevDiff > 0 && cs.vardiff.prevDiff < cs.vardiff.diff {
+		diffs = append(diffs, cs.vardiff.prevDiff)
+	}
+	return diffs
+}
+
+// pushJob sends the current job to cs with its own share target.
+func (s *ProxyServer) pushJob(cs *Session) error {
+	t := s.currentBlockTemplate()
+	if t == nil || len(t.Header) == 0 || s.isSick() {
+		return nil
+	}
+	return s.sendJob(cs, newJobMessages(t))
+}
+
+// sendJob sends a job to cs from its messages, serialized once for every session with the same
+// share difficulty.
+func (s *ProxyServer) sendJob(cs *Session, m *jobMessages) error {
+	if cs.isEthereumStratum() {
+		return s.pushEthereumStratumJob(cs, m)
+	}
+	data, err := m.workMessage(s.shareTarget(cs))
+	if err != nil {
+		return err
+	}
+	cs.Lock()
+	defer cs.Unlock()
+	return cs.queue.send(data)
+}
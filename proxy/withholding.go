@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type WithholdingConfig struct {
+	// Count the shares of every worker meeting a near-block difficulty against those its work should
+	// have, for the API to flag the workers that withhold their blocks
+	Enabled bool `json:"enabled"`
+	// The near-block difficulty is the block difficulty divided by this, 16 by default
+	NearBlockFactor int64 `json:"nearBlockFactor"`
+	// How often the counts are added to Redis, "1m" by default
+	FlushInterval string `json:"flushInterval"`
+}
+
+// withholdingCounter sums the expected and found near-block shares and blocks of the workers, and
+// adds them to Redis on every flush.
+type withholdingCounter struct {
+	name      string
+	backend   *redis.RedisClient
+	factor    *big.Int
+	flushIntv time.Duration
+
+	mu    sync.Mutex
+	stats map[string]*redis.WithholdingStats
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newWithholdingCounter(cfg *WithholdingConfig, name string, backend *redis.RedisClient) *withholdingCounter {
+	if cfg.NearBlockFactor <= 0 {
+		cfg.NearBlockFactor = 16
+	}
+	c := &withholdingCounter{
+		name:      name,
+		backend:   backend,
+		factor:    big.NewInt(cfg.NearBlockFactor),
+		flushIntv: time.Minute,
+		stats:     make(map[string]*redis.WithholdingStats),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if len(cfg.FlushInterval) > 0 {
+		c.flushIntv = util.MustParseDuration(cfg.FlushInterval)
+	}
+	go c.run()
+	return c
+}
+
+// nearDiff is the near-block difficulty of the block difficulty.
+func (c *withholdingCounter) nearDiff(blockDiff *big.Int) *big.Int {
+	return new(big.Int).Div(blockDiff, c.factor)
+}
+
+// record counts a share of login.worker at shareDiff, and whether it met the near-block and the
+// block difficulty.
+func (c *withholdingCounter) record(login, worker string, shareDiff int64, blockDiff, nearDiff *big.Int, near, block bool) {
+	if !workerPattern.MatchString(worker) {
+		worker = "0"
+	}
+	s := &redis.WithholdingStats{
+		Expected:       shareOdds(shareDiff, nearDiff),
+		ExpectedBlocks: shareOdds(shareDiff, blockDiff),
+	}
+	if near {
+		s.Near = 1
+	}
+	if block {
+		s.Blocks = 1
+	}
+
+	key := login + "." + worker
+	c.mu.Lock()
+	if total, ok := c.stats[key]; ok {
+		total.Add(s)
+	} else {
+		c.stats[key] = s
+	}
+	c.mu.Unlock()
+}
+
+// shareOdds is the probability of a share at shareDiff meeting diff.
+func shareOdds(shareDiff int64, diff *big.Int) float64 {
+	if diff.Cmp(big.NewInt(shareDiff)) <= 0 {
+		return 1
+	}
+	odds, _ := new(big.Rat).SetFrac(big.NewInt(shareDiff), diff).Float64()
+	return odds
+}
+
+func (c *withholdingCounter) run() {
+	defer close(c.done)
+	timer := time.NewTicker(c.flushIntv)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			c.flush()
+		case <-c.stop:
+			c.flush()
+			return
+		}
+	}
+}
+
+// flush adds the counts to Redis, they are kept for the next flush if it fails.
+func (c *withholdingCounter) flush() {
+	c.mu.Lock()
+	stats := c.stats
+	c.stats = make(map[string]*redis.WithholdingStats)
+	c.mu.Unlock()
+
+	err := c.backend.WriteWithholdingStats(util.MakeTimestamp()/1000, stats)
+	if metrics.RedisError(c.name, "write_withholding", err) == nil {
+		return
+	}
+	log.Errorf("Failed to write share withholding stats of %v workers: %v", len(stats), err)
+	c.mu.Lock()
+	for key, s := range stats {
+		if total, ok := c.stats[key]; ok {
+			total.Add(s)
+		} else {
+			c.stats[key] = s
+		}
+	}
+	c.mu.Unlock()
+}
+
+// close writes the last counts and stops the counter.
+func (c *withholdingCounter) close() {
+	close(c.stop)
+	<-c.done
+}
+
+// verifyBlock reports whether a share meets the block difficulty. While counting near-block shares,
+// the near-block difficulty is checked first: most shares miss it and can't be blocks then, so it
+// takes no more hashing.
+func (s *ProxyServer) verifyBlock(login, id string, block Block, shareDiff int64) bool {
+	if s.withholding == nil {
+		return hasher.Verify(block)
+	}
+	nearDiff := s.withholding.nearDiff(block.difficulty)
+	near := nearDiff.Cmp(big.NewInt(shareDiff)) <= 0
+	if !near {
+		share := block
+		share.difficulty = nearDiff
+		near = hasher.Verify(share)
+	}
+	isBlock := near && hasher.Verify(block)
+	s.withholding.record(login, id, shareDiff, block.difficulty, nearDiff, near, isBlock)
+	return isBlock
+}
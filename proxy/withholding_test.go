@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+)
+
+func TestShareOdds(t *testing.T) {
+	tests := []struct {
+		shareDiff int64
+		diff      *big.Int
+		expected  float64
+	}{
+		{4000000000, big.NewInt(4000000000), 1},
+		{4000000000, big.NewInt(1000000000), 1},
+		{1000000000, big.NewInt(4000000000), 0.25},
+		{2000000000, big.NewInt(64000000000), 0.03125},
+		{1, new(big.Int).Lsh(big.NewInt(1), 100), 1 / float64(1<<50) / float64(1<<50)},
+	}
+	for _, test := range tests {
+		if odds := shareOdds(test.shareDiff, test.diff); odds != test.expected {
+			t.Errorf("Odds of %v meeting %v must be %v, got %v", test.shareDiff, test.diff, test.expected, odds)
+		}
+	}
+}
+
+func TestVerifyBlock(t *testing.T) {
+	light := newLightHasher()
+	hashNoNonce := common.HexToHash("0x5a2c8f4b3b3b0d5f4d1e7b9a3c2f1e0d9c8b7a6f5e4d3c2b1a0f9e8d7c6b5a49")
+	nonce := uint64(0x1234567890)
+	mixDigest, result := light.compute(1, hashNoNonce, nonce)
+	// The highest difficulty the nonce meets
+	diff := new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), result.Big())
+	times := func(n int64) *big.Int { return new(big.Int).Mul(diff, big.NewInt(n)) }
+
+	tests := []struct {
+		name      string
+		blockDiff *big.Int
+		shareDiff int64
+		mixDigest common.Hash
+		isBlock   bool
+	}{
+		{"block", diff, 1, mixDigest, true},
+		{"near block", times(2), 1, mixDigest, false},
+		{"below near block", times(64), 1, mixDigest, false},
+		{"share over near block", times(2), 1 << 62, mixDigest, false},
+		{"wrong digest", diff, 1, common.Hash{}, false},
+	}
+	for _, test := range tests {
+		block := Block{difficulty: test.blockDiff, hashNoNonce: hashNoNonce, nonce: nonce, mixDigest: test.mixDigest, number: 1}
+		s := &ProxyServer{withholding: &withholdingCounter{factor: big.NewInt(16), stats: make(map[string]*redis.WithholdingStats)}}
+		isBlock := s.verifyBlock("0x1", "rig", block, test.shareDiff)
+		if isBlock != test.isBlock || isBlock != hasher.Verify(block) {
+			t.Errorf("%v: must verify like ethash, got %v", test.name, isBlock)
+		}
+		if st := s.withholding.stats["0x1.rig"]; st == nil || (st.Blocks == 1) != test.isBlock {
+			t.Errorf("%v: must count the block, got %+v", test.name, st)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/mysql"
+)
+
+// rebuildStats recomputes miner and pool totals from the ledger and prints what differs from the
+// cached ones. Totals are only written with apply, then read back and compared again.
+func rebuildStats(apply bool) {
+	// Old credits_balance rows are deleted by the API, balances can't be summed up from what is left.
+	withBalance := cfg.Api.DeleteCheckInterval == "" || cfg.Api.DeleteKeepRecord == 0
+	if !withBalance {
+		log.Printf("credits_balance is pruned by api.deleteCheckInterval, balances are kept as they are")
+	}
+
+	log.Printf("Reading miner totals")
+	cached, err := db.GetMinerTotals()
+	if err != nil {
+		log.Fatalf("Failed to read miner totals: %v", err)
+	}
+	cachedPool, err := db.GetPoolTotals()
+	if err != nil {
+		log.Fatalf("Failed to read pool totals: %v", err)
+	}
+	log.Printf("Summing up credits, compensations and payments of %v miners", len(cached))
+	miners, err := db.LedgerMinerTotals(cached, withBalance)
+	if err != nil {
+		log.Fatalf("Failed to sum up the ledger: %v", err)
+	}
+	log.Printf("Summing up matured blocks")
+	pool, err := db.LedgerPoolTotals(cachedPool, miners)
+	if err != nil {
+		log.Fatalf("Failed to sum up the ledger: %v", err)
+	}
+
+	changed := diffMinerTotals(cached, miners)
+	poolChanged := diffPoolTotals(cachedPool, pool)
+	log.Printf("%v of %v miners and %v pool totals differ from the ledger", len(changed), len(miners), poolChanged)
+	if !apply {
+		log.Printf("Dry run, run with --apply to write the totals")
+		return
+	}
+	if len(changed) == 0 && poolChanged == 0 {
+		return
+	}
+
+	for i := 0; i < len(changed); i += 500 {
+		end := i + 500
+		if end > len(changed) {
+			end = len(changed)
+		}
+		if err := db.WriteMinerTotals(changed[i:end]); err != nil {
+			log.Fatalf("Failed to write miner totals: %v", err)
+		}
+		log.Printf("Wrote %v/%v miners", end, len(changed))
+	}
+	if err := db.WritePoolTotals(pool); err != nil {
+		log.Fatalf("Failed to write pool totals: %v", err)
+	}
+
+	log.Printf("Verifying")
+	cached, err = db.GetMinerTotals()
+	if err != nil {
+		log.Fatalf("Failed to read miner totals: %v", err)
+	}
+	cachedPool, err = db.GetPoolTotals()
+	if err != nil {
+		log.Fatalf("Failed to read pool totals: %v", err)
+	}
+	changed = diffMinerTotals(cached, miners)
+	poolChanged = diffPoolTotals(cachedPool, pool)
+	if len(changed) > 0 || poolChanged > 0 {
+		// Expected while the pool is running, e.g. a block matured or a payout was sent meanwhile
+		log.Printf("Verification failed: %v miners and %v pool totals still differ, was the pool running?", len(changed), poolChanged)
+		os.Exit(1)
+	}
+	log.Printf("Verified, totals match the ledger")
+}
+
+// diffMinerTotals prints the miners whose cached totals differ and returns their ledger totals.
+func diffMinerTotals(cached, ledger map[string]*mysql.MinerTotals) []*mysql.MinerTotals {
+	logins := make([]string, 0, len(ledger))
+	for login := range ledger {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+
+	var changed []*mysql.MinerTotals
+	for _, login := range logins {
+		l, c := ledger[login], cached[login]
+		if c == nil {
+			c = &mysql.MinerTotals{Login: login}
+		}
+		diff := diffField("balance", c.Balance, l.Balance) + diffField("paid", c.Paid, l.Paid) +
+			diffField("immature", c.Immature, l.Immature) + diffField("payout_cnt", c.PayoutCnt, l.PayoutCnt)
+		if len(diff) > 0 {
+			log.Printf("Miner %v differs from the ledger:%v", login, diff)
+			changed = append(changed, l)
+		}
+	}
+	return changed
+}
+
+func diffPoolTotals(c, l *mysql.PoolTotals) int {
+	fields := []struct {
+		name           string
+		cached, ledger int64
+	}{
+		{"immature", c.Immature, l.Immature},
+		{"pending", c.Pending, l.Pending},
+		{"balance", c.Balance, l.Balance},
+		{"paid", c.Paid, l.Paid},
+		{"payout_cnt", c.PayoutCnt, l.PayoutCnt},
+		{"gas_fee", c.GasFee, l.GasFee},
+		{"total_mined", c.TotalMined, l.TotalMined},
+		{"last_height", c.LastHeight, l.LastHeight},
+	}
+	n := 0
+	for _, f := range fields {
+		if diff := diffField(f.name, f.cached, f.ledger); len(diff) > 0 {
+			log.Printf("Pool totals differ from the ledger:%v", diff)
+			n++
+		}
+	}
+	return n
+}
+
+func diffField(name string, cached, ledger int64) string {
+	if cached == ledger {
+		return ""
+	}
+	return fmt.Sprintf(" %v: %v -> %v (%+d)", name, cached, ledger, ledger-cached)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+
+	"github.com/cellcrypto/open-dangnn-pool/hook"
+	"github.com/cellcrypto/open-dangnn-pool/proxy"
+	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+// configManager reloads the config file on SIGHUP or when asked through the API, and hands the
+// settings that can change while running to the services of each pool: the pool fee, payout
+// thresholds, intervals, vardiff and the ban policy. Stratum connections are kept and the unlocker
+// and payer pick the changes up between their runs. Anything else needs a restart.
+type configManager struct {
+	mu    sync.Mutex
+	file  string
+	pools []*pool
+}
+
+func startConfigManager(pools []*pool) {
+	m := &configManager{file: configFileName, pools: pools}
+	hook.RegistryReloadHook(m.Reload)
+	// Each pool publishes reloads on its own channels when they are scoped
+	for _, p := range pools {
+		p.backend.InitPubSub(redis.ChannelConfig, m)
+	}
+}
+
+func (m *configManager) RedisMessage(payload string) {
+	m.Reload()
+}
+
+func (m *configManager) Reload() {
+	log.Printf("Reloading config: %v", m.file)
+	if err := m.reload(); err != nil {
+		log.Printf("Config reload failed, keeping the running config: %v", err)
+		for _, p := range m.pools {
+			p.logger.InsertLog(fmt.Sprintf("CONFIG RELOAD FAILED: %v", err), plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+		}
+		return
+	}
+	for _, p := range m.pools {
+		p.logger.InsertLog("CONFIG RELOADED", plogger.LogTypeSystem, plogger.LogErrorNothing, 0, 0, "", "")
+	}
+}
+
+func (m *configManager) reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := ioutil.ReadFile(m.file)
+	if err != nil {
+		return err
+	}
+	cfg := &proxy.Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return err
+	}
+	deriveConfig(cfg)
+	configs, err := poolConfigs(cfg, data)
+	if err != nil {
+		return err
+	}
+	if len(configs) != len(m.pools) {
+		return fmt.Errorf("pools were added or removed, which needs a restart")
+	}
+	for i, p := range m.pools {
+		if configs[i].Name != p.cfg.Name {
+			return fmt.Errorf("pool %v was renamed to %v, which needs a restart", p.cfg.Name, configs[i].Name)
+		}
+	}
+
+	var failed error
+	for i, p := range m.pools {
+		if err := p.reload(configs[i]); err != nil {
+			log.Printf("Failed to reload pool %v: %v", p.cfg.Name, err)
+			failed = err
+		}
+	}
+	return failed
+}
+
+// reload applies next to the running services of the pool.
+func (p *pool) reload(next *proxy.Config) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var failed error
+	if p.proxy != nil {
+		if err := p.proxy.Reload(next); err != nil {
+			failed = fmt.Errorf("proxy: %v", err)
+		}
+	}
+	if p.unlocker != nil {
+		if err := p.unlocker.Reload(&next.BlockUnlocker); err != nil {
+			failed = fmt.Errorf("unlocker: %v", err)
+		}
+	}
+	if p.payer != nil {
+		if err := p.payer.Reload(&next.Payouts); err != nil {
+			failed = fmt.Errorf("payouts: %v", err)
+		}
+	}
+	return failed
+}
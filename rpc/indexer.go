@@ -0,0 +1,111 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// IndexerClient talks to an Etherscan-compatible API (Etherscan, Blockscout).
+type IndexerClient struct {
+	Name   string
+	Url    string
+	apiKey string
+	client *http.Client
+}
+
+type indexerResp struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
+}
+
+type BlockRewardReply struct {
+	BlockNumber          string `json:"blockNumber"`
+	BlockMiner           string `json:"blockMiner"`
+	BlockReward          string `json:"blockReward"`
+	UncleInclusionReward string `json:"uncleInclusionReward"`
+}
+
+func NewIndexerClient(name, rawUrl, apiKey, timeout string) *IndexerClient {
+	return &IndexerClient{
+		Name:   name,
+		Url:    rawUrl,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: util.MustParseDuration(timeout)},
+	}
+}
+
+// GetBlockReward returns the total miner reward of a block: static reward, tx fees and uncle inclusion reward.
+func (c *IndexerClient) GetBlockReward(height int64) (*BlockRewardReply, error) {
+	params := url.Values{}
+	params.Set("module", "block")
+	params.Set("action", "getblockreward")
+	params.Set("blockno", fmt.Sprintf("%d", height))
+
+	var reply *BlockRewardReply
+	err := c.doGet(params, &reply)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil || len(reply.BlockReward) == 0 {
+		return nil, fmt.Errorf("%v: no reward data for block %v", c.Name, height)
+	}
+	return reply, nil
+}
+
+// GetBlockFees derives tx fee income from the indexer's reward breakdown.
+func (c *IndexerClient) GetBlockFees(height int64, constReward *big.Int) (*big.Int, error) {
+	reply, err := c.GetBlockReward(height)
+	if err != nil {
+		return nil, err
+	}
+	total, ok := new(big.Int).SetString(reply.BlockReward, 10)
+	if !ok {
+		return nil, fmt.Errorf("%v: invalid block reward %v", c.Name, reply.BlockReward)
+	}
+	fees := new(big.Int).Sub(total, constReward)
+	if len(reply.UncleInclusionReward) > 0 {
+		uncleReward, ok := new(big.Int).SetString(reply.UncleInclusionReward, 10)
+		if !ok {
+			return nil, fmt.Errorf("%v: invalid uncle inclusion reward %v", c.Name, reply.UncleInclusionReward)
+		}
+		fees.Sub(fees, uncleReward)
+	}
+	if fees.Sign() < 0 {
+		return nil, fmt.Errorf("%v: block %v reward %v is below static reward %v", c.Name, height, total, constReward)
+	}
+	return fees, nil
+}
+
+func (c *IndexerClient) doGet(params url.Values, result interface{}) error {
+	if len(c.apiKey) > 0 {
+		params.Set("apikey", c.apiKey)
+	}
+	resp, err := c.client.Get(c.Url + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: unexpected status %v", c.Name, resp.Status)
+	}
+
+	var reply indexerResp
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	if err != nil {
+		return err
+	}
+	if reply.Status != "1" {
+		var msg string
+		json.Unmarshal(reply.Result, &msg)
+		return errors.New(c.Name + ": " + reply.Message + " " + msg)
+	}
+	return json.Unmarshal(reply.Result, result)
+}
@@ -12,9 +12,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
 	"github.com/cellcrypto/open-dangnn-pool/util"
 )
 
@@ -22,10 +24,15 @@ type RPCClient struct {
 	sync.RWMutex
 	Url         string
 	Name        string
+	// Pool the client's metrics are labeled with
+	Pool        string
+	Role        string
 	sick        bool
 	sickRate    int
 	successRate int
 	client      *http.Client
+	// WebSocket or IPC connection, nil over HTTP
+	stream *streamTransport
 }
 
 type GetBlockReply struct {
@@ -38,10 +45,19 @@ type GetBlockReply struct {
 	GasUsed      string   `json:"gasUsed"`
 	Transactions []Tx     `json:"transactions"`
 	Uncles       []string `json:"uncles"`
+	// EIP-1559, empty on pre-London chains
+	BaseFeePerGas string `json:"baseFeePerGas"`
 	// https://github.com/ethereum/EIPs/issues/95
 	SealFields []string `json:"sealFields"`
 }
 
+// SyncStatus is the progress of a syncing node, heights in hex.
+type SyncStatus struct {
+	StartingBlock string `json:"startingBlock"`
+	CurrentBlock  string `json:"currentBlock"`
+	HighestBlock  string `json:"highestBlock"`
+}
+
 type GetBlockReplyPart struct {
 	Number     string `json:"number"`
 	Difficulty string `json:"difficulty"`
@@ -73,6 +89,8 @@ type Tx struct {
 	Gas      string `json:"gas"`
 	GasPrice string `json:"gasPrice"`
 	Hash     string `json:"hash"`
+	Nonce    string `json:"nonce"`
+	BlockNumber string `json:"blockNumber"`
 }
 
 type JSONRpcResp struct {
@@ -81,12 +99,13 @@ type JSONRpcResp struct {
 	Error  map[string]interface{} `json:"error"`
 }
 
-func NewRPCClient(name, url, timeout string, netId int64) *RPCClient {
-	rpcClient := &RPCClient{Name: name, Url: url}
+func NewRPCClient(pool, name, url, timeout string, netId int64) *RPCClient {
+	rpcClient := &RPCClient{Pool: pool, Name: name, Url: url}
 	timeoutIntv := util.MustParseDuration(timeout)
 	rpcClient.client = &http.Client{
 		Timeout: timeoutIntv,
 	}
+	rpcClient.stream = newStreamTransport(name, url, timeoutIntv)
 	rpcNetId, err := rpcClient.GetNetVersion()
 	if err != nil {
 		log.Fatal("no rpc connection")
@@ -216,6 +235,24 @@ func (r *RPCClient) GetPeerCount() (int64, error) {
 	return strconv.ParseInt(strings.Replace(reply, "0x", "", -1), 16, 64)
 }
 
+// GetSyncing returns the sync progress of the node, nil once it's in sync.
+func (r *RPCClient) GetSyncing() (*SyncStatus, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_syncing", nil)
+	if err != nil {
+		return nil, err
+	}
+	var syncing bool
+	if err = json.Unmarshal(*rpcResp.Result, &syncing); err == nil {
+		if syncing {
+			return &SyncStatus{}, nil
+		}
+		return nil, nil
+	}
+	var reply *SyncStatus
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	return reply, err
+}
+
 func (r *RPCClient) GetNetVersion() (int64, error) {
 	rpcResp, err := r.doPost(r.Url, "net_version", nil)
 	if err != nil {
@@ -258,7 +295,207 @@ func (r *RPCClient) SendTransaction(from, to, gas, gasPrice, value string, autoG
 	return reply, err
 }
 
+func (r *RPCClient) SendDynamicFeeTransaction(from, to, gas, maxFeePerGas, maxPriorityFeePerGas, value, data string) (string, error) {
+	params := map[string]string{
+		"from":                 from,
+		"to":                   to,
+		"value":                value,
+		"type":                 "0x2",
+		"gas":                  gas,
+		"maxFeePerGas":         maxFeePerGas,
+		"maxPriorityFeePerGas": maxPriorityFeePerGas,
+	}
+	if len(data) > 0 {
+		params["data"] = data
+	}
+	return r.sendTransaction(params)
+}
+
+// SendContractTransaction is a legacy transaction carrying call data.
+func (r *RPCClient) SendContractTransaction(from, to, gas, gasPrice, value, data string) (string, error) {
+	params := map[string]string{
+		"from":     from,
+		"to":       to,
+		"value":    value,
+		"gas":      gas,
+		"gasPrice": gasPrice,
+		"data":     data,
+	}
+	return r.sendTransaction(params)
+}
+
+// SendTransactionParams sends a node signed transaction with arbitrary fields, e.g. an explicit nonce.
+func (r *RPCClient) SendTransactionParams(params map[string]string) (string, error) {
+	return r.sendTransaction(params)
+}
+
+func (r *RPCClient) sendTransaction(params map[string]string) (string, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_sendTransaction", []interface{}{params})
+	var reply string
+	if err != nil {
+		return reply, err
+	}
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return reply, err
+	}
+	if util.IsZeroHash(reply) {
+		err = errors.New("transaction is not yet available")
+	}
+	return reply, err
+}
+
+func (r *RPCClient) SendRawTransaction(rawTx string) (string, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_sendRawTransaction", []string{rawTx})
+	var reply string
+	if err != nil {
+		return reply, err
+	}
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return reply, err
+	}
+	if util.IsZeroHash(reply) {
+		err = errors.New("transaction is not yet available")
+	}
+	return reply, err
+}
+
+func (r *RPCClient) GetTransactionByHash(hash string) (*Tx, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_getTransactionByHash", []string{hash})
+	if err != nil {
+		return nil, err
+	}
+	if rpcResp.Result != nil {
+		var reply *Tx
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+		return reply, err
+	}
+	return nil, nil
+}
+
+func (r *RPCClient) GetPendingNonce(address string) (uint64, error) {
+	return r.getNonce(address, "pending")
+}
+
+func (r *RPCClient) GetLatestNonce(address string) (uint64, error) {
+	return r.getNonce(address, "latest")
+}
+
+func (r *RPCClient) getNonce(address, block string) (uint64, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_getTransactionCount", []string{address, block})
+	if err != nil {
+		return 0, err
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.Replace(reply, "0x", "", -1), 16, 64)
+}
+
+// GetCode returns the code at address in hex, "0x" for an account without code.
+func (r *RPCClient) GetCode(address string) (string, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_getCode", []string{address, "latest"})
+	if err != nil {
+		return "", err
+	}
+	if rpcResp.Result == nil {
+		return "", errors.New("no code reply")
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	return reply, err
+}
+
+func (r *RPCClient) GetChainId() (*big.Int, error) {
+	return r.getBigResult("eth_chainId", nil)
+}
+
+func (r *RPCClient) GetLatestBlock() (*GetBlockReply, error) {
+	params := []interface{}{"latest", false}
+	return r.getBlockBy("eth_getBlockByNumber", params)
+}
+
+func (r *RPCClient) GetGasPrice() (*big.Int, error) {
+	return r.getBigResult("eth_gasPrice", nil)
+}
+
+func (r *RPCClient) GetMaxPriorityFeePerGas() (*big.Int, error) {
+	return r.getBigResult("eth_maxPriorityFeePerGas", nil)
+}
+
+// FeeHistory is the eth_feeHistory of some blocks: their base fees, with the one of the next block
+// last, and the tips at the asked percentiles.
+type FeeHistory struct {
+	BaseFeePerGas []string   `json:"baseFeePerGas"`
+	Reward        [][]string `json:"reward"`
+}
+
+func (r *RPCClient) GetFeeHistory(blocks int, percentile float64) (*FeeHistory, error) {
+	params := []interface{}{"0x" + strconv.FormatInt(int64(blocks), 16), "latest", []float64{percentile}}
+	rpcResp, err := r.doPost(r.Url, "eth_feeHistory", params)
+	if err != nil {
+		return nil, err
+	}
+	if rpcResp.Result == nil {
+		return nil, errors.New("no fee history")
+	}
+	var reply *FeeHistory
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	return reply, err
+}
+
+func (r *RPCClient) EstimateGas(from, to, value, data string) (*big.Int, error) {
+	params := map[string]string{
+		"from":  from,
+		"to":    to,
+		"value": value,
+	}
+	if len(data) > 0 {
+		params["data"] = data
+	}
+	return r.getBigResult("eth_estimateGas", []interface{}{params})
+}
+
+func (r *RPCClient) getBigResult(method string, params interface{}) (*big.Int, error) {
+	rpcResp, err := r.doPost(r.Url, method, params)
+	if err != nil {
+		return nil, err
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return util.String2Big(reply), nil
+}
+
 func (r *RPCClient) doPost(url string, method string, params interface{}) (*JSONRpcResp, error) {
+	start := time.Now()
+	rpcResp, err := r.post(url, method, params)
+	metrics.RPCDuration.Since(start, r.Pool, r.Name, method)
+	if err != nil {
+		metrics.RPCErrors.Inc(r.Pool, r.Name, method)
+	}
+	return rpcResp, err
+}
+
+func (r *RPCClient) post(url string, method string, params interface{}) (*JSONRpcResp, error) {
+	if r.stream != nil {
+		rpcResp, err := r.stream.call(method, params)
+		if err != nil {
+			r.markSick()
+			return nil, err
+		}
+		if rpcResp.Error != nil {
+			r.markSick()
+			return nil, errors.New(rpcResp.Error["message"].(string))
+		}
+		return rpcResp, nil
+	}
+
 	jsonReq := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": 0}
 	data, _ := json.Marshal(jsonReq)
 
@@ -287,6 +524,30 @@ func (r *RPCClient) doPost(url string, method string, params interface{}) (*JSON
 	return rpcResp, err
 }
 
+// SubscribeNewHeads sends the height of every new chain head to heads, dropping it while heads is
+// full. Needs a ws:// or IPC connection, the subscription is renewed after reconnecting.
+func (r *RPCClient) SubscribeNewHeads(heads chan<- int64) error {
+	if r.stream == nil {
+		return ErrSubscriptionsUnsupported
+	}
+	return r.stream.subscribe([]interface{}{"newHeads"}, func(result json.RawMessage) {
+		var head GetBlockReplyPart
+		if err := json.Unmarshal(result, &head); err != nil {
+			log.Printf("Invalid new head from %v: %v", r.Name, err)
+			return
+		}
+		height, err := strconv.ParseInt(strings.Replace(head.Number, "0x", "", -1), 16, 64)
+		if err != nil {
+			log.Printf("Invalid new head height from %v: %v", r.Name, head.Number)
+			return
+		}
+		select {
+		case heads <- height:
+		default:
+		}
+	})
+}
+
 func (r *RPCClient) Check() bool {
 	_, err := r.GetWork()
 	if err != nil {
@@ -0,0 +1,272 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrSubscriptionsUnsupported = errors.New("subscriptions need a ws:// or IPC connection to the node")
+
+var errConnectionLost = errors.New("connection to the node lost")
+
+// streamConn is a WebSocket or IPC connection exchanging JSON-RPC messages.
+type streamConn interface {
+	WriteJSON(v interface{}) error
+	ReadJSON(v interface{}) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+type ipcConn struct {
+	net.Conn
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+func (c *ipcConn) WriteJSON(v interface{}) error { return c.enc.Encode(v) }
+func (c *ipcConn) ReadJSON(v interface{}) error  { return c.dec.Decode(v) }
+
+type streamMessage struct {
+	Id     *uint64                `json:"id"`
+	Result *json.RawMessage       `json:"result"`
+	Error  map[string]interface{} `json:"error"`
+	Method string                 `json:"method"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+type subscription struct {
+	params  []interface{}
+	handler func(json.RawMessage)
+}
+
+// streamTransport sends calls over one persistent connection, matching replies by id. A lost
+// connection is dialed again on the next call, and right away while there are subscriptions,
+// which are then renewed.
+type streamTransport struct {
+	name    string
+	url     string
+	timeout time.Duration
+	dial    func() (streamConn, error)
+
+	sync.Mutex
+	writeMu sync.Mutex
+	conn    streamConn
+	nextId  uint64
+	pending map[uint64]chan *streamMessage
+	// By the id the node gave them on the current connection
+	active        map[string]*subscription
+	subscriptions []*subscription
+	redialing     bool
+}
+
+// newStreamTransport returns the transport of a ws://, wss:// or IPC url, ipc:///path or a path
+// ending in .ipc, nil for HTTP.
+func newStreamTransport(name, rawUrl string, timeout time.Duration) *streamTransport {
+	t := &streamTransport{
+		name:    name,
+		url:     rawUrl,
+		timeout: timeout,
+		pending: make(map[uint64]chan *streamMessage),
+		active:  make(map[string]*subscription),
+	}
+	switch {
+	case strings.HasPrefix(rawUrl, "ws://") || strings.HasPrefix(rawUrl, "wss://"):
+		t.dial = func() (streamConn, error) {
+			dialer := websocket.Dialer{HandshakeTimeout: timeout}
+			conn, _, err := dialer.Dial(rawUrl, nil)
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		}
+	case strings.HasPrefix(rawUrl, "ipc://") || (!strings.Contains(rawUrl, "://") && strings.HasSuffix(rawUrl, ".ipc")):
+		path := strings.TrimPrefix(rawUrl, "ipc://")
+		t.dial = func() (streamConn, error) {
+			conn, err := net.DialTimeout("unix", path, timeout)
+			if err != nil {
+				return nil, err
+			}
+			return &ipcConn{Conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}, nil
+		}
+	default:
+		return nil
+	}
+	return t
+}
+
+func (t *streamTransport) call(method string, params interface{}) (*JSONRpcResp, error) {
+	conn, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+	t.Lock()
+	t.nextId++
+	id := t.nextId
+	reply := make(chan *streamMessage, 1)
+	t.pending[id] = reply
+	t.Unlock()
+
+	t.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(t.timeout))
+	err = conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": id})
+	t.writeMu.Unlock()
+	if err != nil {
+		t.lost(conn, err)
+		return nil, err
+	}
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case msg, ok := <-reply:
+		if !ok {
+			return nil, errConnectionLost
+		}
+		return &JSONRpcResp{Result: msg.Result, Error: msg.Error}, nil
+	case <-timer.C:
+		t.Lock()
+		delete(t.pending, id)
+		t.Unlock()
+		return nil, fmt.Errorf("%v: %v timed out after %v", t.name, method, t.timeout)
+	}
+}
+
+// subscribe calls eth_subscribe with params and passes every notification to handler, which must
+// not block. The subscription is renewed after reconnecting.
+func (t *streamTransport) subscribe(params []interface{}, handler func(json.RawMessage)) error {
+	sub := &subscription{params: params, handler: handler}
+	if err := t.establish(sub); err != nil {
+		return err
+	}
+	t.Lock()
+	t.subscriptions = append(t.subscriptions, sub)
+	t.Unlock()
+	return nil
+}
+
+func (t *streamTransport) establish(sub *subscription) error {
+	resp, err := t.call("eth_subscribe", sub.params)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%v", resp.Error["message"])
+	}
+	var id string
+	if resp.Result == nil {
+		return errors.New("no subscription id")
+	}
+	if err := json.Unmarshal(*resp.Result, &id); err != nil {
+		return err
+	}
+	t.Lock()
+	t.active[id] = sub
+	t.Unlock()
+	return nil
+}
+
+// connect returns the current connection, dialing a new one if it was lost.
+func (t *streamTransport) connect() (streamConn, error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.conn != nil {
+		return t.conn, nil
+	}
+	conn, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	go t.read(conn)
+	if len(t.subscriptions) > 0 {
+		go t.resubscribe(t.subscriptions)
+	}
+	return conn, nil
+}
+
+func (t *streamTransport) read(conn streamConn) {
+	for {
+		var msg streamMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.lost(conn, err)
+			return
+		}
+		t.Lock()
+		if msg.Method == "eth_subscription" {
+			sub := t.active[msg.Params.Subscription]
+			t.Unlock()
+			if sub != nil {
+				sub.handler(msg.Params.Result)
+			}
+			continue
+		}
+		var reply chan *streamMessage
+		if msg.Id != nil {
+			reply = t.pending[*msg.Id]
+			delete(t.pending, *msg.Id)
+		}
+		t.Unlock()
+		if reply != nil {
+			reply <- &msg
+		}
+	}
+}
+
+// lost drops a broken connection and fails the calls waiting on it.
+func (t *streamTransport) lost(conn streamConn, err error) {
+	conn.Close()
+	t.Lock()
+	defer t.Unlock()
+	if t.conn != conn {
+		return
+	}
+	log.Printf("Lost connection to %v at %v: %v", t.name, t.url, err)
+	t.conn = nil
+	for id, reply := range t.pending {
+		close(reply)
+		delete(t.pending, id)
+	}
+	t.active = make(map[string]*subscription)
+	if len(t.subscriptions) > 0 && !t.redialing {
+		t.redialing = true
+		go t.redial()
+	}
+}
+
+// redial reconnects with a growing backoff so subscriptions resume without waiting for a call.
+func (t *streamTransport) redial() {
+	backoff := time.Second
+	for {
+		_, err := t.connect()
+		if err == nil {
+			break
+		}
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+	t.Lock()
+	t.redialing = false
+	t.Unlock()
+	log.Printf("Reconnected to %v at %v", t.name, t.url)
+}
+
+func (t *streamTransport) resubscribe(subs []*subscription) {
+	for _, sub := range subs {
+		if err := t.establish(sub); err != nil {
+			log.Printf("Failed to renew %v subscription on %v: %v", sub.params[0], t.name, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"sync/atomic"
+)
+
+// Upstream roles. A node without a role serves every kind of call.
+const (
+	RoleAny     = ""
+	RoleSubmit  = "submit"
+	RoleArchive = "archive"
+)
+
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleAny, RoleSubmit, RoleArchive:
+		return true
+	}
+	return false
+}
+
+// Upstreams routes calls to nodes by role, round-robin over healthy nodes.
+// Solutions go to low-latency submit nodes, heavy reads such as receipt scans go to archive nodes.
+type Upstreams struct {
+	clients    []*RPCClient
+	submitNext uint32
+	readNext   uint32
+}
+
+func NewUpstreams(clients []*RPCClient) *Upstreams {
+	return &Upstreams{clients: clients}
+}
+
+func (u *Upstreams) Clients() []*RPCClient {
+	return u.clients
+}
+
+// Submit returns the next node for block submission.
+func (u *Upstreams) Submit() *RPCClient {
+	return u.next(&u.submitNext, RoleSubmit)
+}
+
+// Archive returns the next node for receipts and historical reads.
+func (u *Upstreams) Archive() *RPCClient {
+	return u.next(&u.readNext, RoleArchive)
+}
+
+// next picks a healthy node with the given role, then a healthy node without a role,
+// and finally any node with the role, so a call is never left without a target.
+func (u *Upstreams) next(counter *uint32, role string) *RPCClient {
+	if len(u.clients) == 0 {
+		return nil
+	}
+	n := atomic.AddUint32(counter, 1)
+	if c := u.pick(n, func(c *RPCClient) bool { return c.Role == role && !c.Sick() }); c != nil {
+		return c
+	}
+	if c := u.pick(n, func(c *RPCClient) bool { return c.Role == RoleAny && !c.Sick() }); c != nil {
+		return c
+	}
+	if c := u.pick(n, func(c *RPCClient) bool { return c.Role == role }); c != nil {
+		return c
+	}
+	return u.clients[int(n)%len(u.clients)]
+}
+
+func (u *Upstreams) pick(n uint32, match func(c *RPCClient) bool) *RPCClient {
+	var matched []*RPCClient
+	for _, c := range u.clients {
+		if match(c) {
+			matched = append(matched, c)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	return matched[int(n)%len(matched)]
+}
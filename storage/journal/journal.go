@@ -0,0 +1,290 @@
+// Package journal is a write-ahead log of records that must reach the backends after a crash.
+//
+// A record is appended, and fsynced, before it's written to the backends and committed after.
+// Records still uncommitted when the journal is opened again are replayed. The file is a line per
+// entry:
+//
+//	H <journal id> <next seq>
+//	A <seq> <crc32> <payload>
+//	C <seq>
+//
+// A torn last line, as left by a crash during a write, fails its checksum and is ignored.
+package journal
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	Seq  uint64
+	Data []byte
+}
+
+type Journal struct {
+	path string
+	// Fsync appends every interval instead of each one, 0 syncs every append
+	syncInterval time.Duration
+
+	mu      sync.Mutex
+	id      string
+	file    *os.File
+	w       *bufio.Writer
+	size    int64
+	nextSeq uint64
+	pending map[uint64][]byte
+	dirty   bool
+	closed  chan struct{}
+}
+
+// Open opens or creates the journal at path and loads its uncommitted entries.
+func Open(path string, syncInterval time.Duration) (*Journal, error) {
+	j := &Journal{path: path, syncInterval: syncInterval, nextSeq: 1, pending: make(map[uint64][]byte), closed: make(chan struct{})}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	// Rewriting drops committed entries and a torn last line before appending again
+	if err := j.rewrite(); err != nil {
+		return nil, err
+	}
+	if syncInterval > 0 {
+		go j.syncLoop()
+	}
+	return j, nil
+}
+
+func (j *Journal) load() error {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		id := make([]byte, 8)
+		rand.Read(id)
+		j.id = hex.EncodeToString(id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	for n := 1; ; n++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			// EOF, or a last line without newline, torn by a crash
+			break
+		}
+		fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 4)
+		switch fields[0] {
+		case "H":
+			if len(fields) != 3 || n != 1 {
+				return fmt.Errorf("journal %v: bad header at line %v", j.path, n)
+			}
+			j.id = fields[1]
+			j.nextSeq, _ = strconv.ParseUint(fields[2], 10, 64)
+		case "A":
+			if len(fields) != 4 {
+				continue
+			}
+			seq, _ := strconv.ParseUint(fields[1], 10, 64)
+			sum, _ := strconv.ParseUint(fields[2], 16, 32)
+			data := []byte(fields[3])
+			if seq == 0 || uint32(sum) != crc32.ChecksumIEEE(data) {
+				continue
+			}
+			j.pending[seq] = data
+			if seq >= j.nextSeq {
+				j.nextSeq = seq + 1
+			}
+		case "C":
+			if len(fields) != 2 {
+				continue
+			}
+			seq, _ := strconv.ParseUint(fields[1], 10, 64)
+			delete(j.pending, seq)
+		}
+	}
+	if len(j.id) == 0 {
+		return fmt.Errorf("journal %v: no header", j.path)
+	}
+	return nil
+}
+
+// rewrite replaces the file with one of the header and the uncommitted entries.
+func (j *Journal) rewrite() error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	size, _ := fmt.Fprintf(w, "H %s %d\n", j.id, j.nextSeq)
+	for _, e := range j.pendingLocked() {
+		n, _ := w.WriteString(appendLine(e.Seq, e.Data))
+		size += n
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	j.w = bufio.NewWriter(j.file)
+	j.size = int64(size)
+	j.dirty = false
+	return nil
+}
+
+func appendLine(seq uint64, data []byte) string {
+	return fmt.Sprintf("A %d %08x %s\n", seq, crc32.ChecksumIEEE(data), data)
+}
+
+// Id identifies the journal in the backends, seqs are only unique within a journal.
+func (j *Journal) Id() string {
+	return j.id
+}
+
+// Append journals data, which must not contain a newline, and returns its seq.
+// It's durable on return, unless a sync interval is set.
+func (j *Journal) Append(data []byte) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	seq := j.nextSeq
+	n, err := j.w.WriteString(appendLine(seq, data))
+	if err != nil {
+		return 0, err
+	}
+	j.nextSeq++
+	j.size += int64(n)
+	j.pending[seq] = data
+	if j.syncInterval > 0 {
+		j.dirty = true
+		return seq, j.w.Flush()
+	}
+	return seq, j.syncLocked()
+}
+
+// Commit marks seq as written to the backends. Commits aren't synced, a lost one only replays
+// an entry the backends skip.
+func (j *Journal) Commit(seq uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.pending[seq]; !ok {
+		return nil
+	}
+	delete(j.pending, seq)
+	n, err := fmt.Fprintf(j.w, "C %d\n", seq)
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return j.w.Flush()
+}
+
+// Pending returns the uncommitted entries in seq order.
+func (j *Journal) Pending() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pendingLocked()
+}
+
+func (j *Journal) pendingLocked() []Entry {
+	entries := make([]Entry, 0, len(j.pending))
+	for seq, data := range j.pending {
+		entries = append(entries, Entry{seq, data})
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+	return entries
+}
+
+// Checkpoint returns the lowest uncommitted seq, every seq below is committed.
+func (j *Journal) Checkpoint() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	low := j.nextSeq
+	for seq := range j.pending {
+		if seq < low {
+			low = seq
+		}
+	}
+	return low
+}
+
+// Size returns the size of the file in bytes.
+func (j *Journal) Size() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.size
+}
+
+// Compact rewrites the file with the uncommitted entries only.
+func (j *Journal) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	return j.rewrite()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	select {
+	case <-j.closed:
+		return nil
+	default:
+		close(j.closed)
+	}
+	if err := j.syncLocked(); err != nil {
+		return err
+	}
+	return j.file.Close()
+}
+
+func (j *Journal) syncLocked() error {
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	j.dirty = false
+	return j.file.Sync()
+}
+
+func (j *Journal) syncLoop() {
+	ticker := time.NewTicker(j.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.closed:
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			if j.dirty {
+				j.syncLocked()
+			}
+			j.mu.Unlock()
+		}
+	}
+}
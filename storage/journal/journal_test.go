@@ -0,0 +1,76 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares.journal")
+	j, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := j.Id()
+	for _, s := range []string{"a", "b", "c"} {
+		j.Append([]byte(s))
+	}
+	j.Commit(2)
+	j.Close()
+
+	// A crash tore the last write
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0640)
+	f.WriteString("A 4 00000000 d")
+	f.Close()
+
+	j, err = Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.Id() != id {
+		t.Errorf("Must keep the journal id, got %v, want %v", j.Id(), id)
+	}
+	pending := j.Pending()
+	if len(pending) != 2 || pending[0].Seq != 1 || string(pending[1].Data) != "c" {
+		t.Errorf("Must replay seqs 1 and 3, got %+v", pending)
+	}
+	if seq, _ := j.Append([]byte("e")); seq != 4 {
+		t.Errorf("Must continue at seq 4, got %v", seq)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares.journal")
+	j, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		seq, _ := j.Append([]byte("share"))
+		if seq != 50 {
+			j.Commit(seq)
+		}
+	}
+	size := j.Size()
+	if err := j.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if j.Size() >= size {
+		t.Errorf("Must shrink the journal, got %v from %v", j.Size(), size)
+	}
+	if j.Checkpoint() != 50 {
+		t.Errorf("Checkpoint must be 50, got %v", j.Checkpoint())
+	}
+	if seq, _ := j.Append([]byte("share")); seq != 101 {
+		t.Errorf("Must continue at seq 101 after compaction, got %v", seq)
+	}
+	j.Close()
+
+	j, _ = Open(path, 0)
+	defer j.Close()
+	if pending := j.Pending(); len(pending) != 2 || pending[0].Seq != 50 || pending[1].Seq != 101 {
+		t.Errorf("Must replay seqs 50 and 101, got %+v", pending)
+	}
+}
@@ -0,0 +1,105 @@
+package mysql
+
+import (
+	"database/sql"
+)
+
+// ApiKey is a key of the admin API. The key itself is only shown once, at creation.
+type ApiKey struct {
+	Id     int64  `json:"id"`
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	Role   string `json:"role"`
+	// Requests a minute, 0 for the configured default
+	RateLimit int    `json:"rateLimit"`
+	CreatedBy string `json:"createdBy"`
+	CreatedAt int64  `json:"createdAt"`
+	LastUsed  int64  `json:"lastUsed"`
+	Revoked   bool   `json:"revoked"`
+}
+
+const apiKeyColumns = "id,name,prefix,`role`,rate_limit,created_by,created_at,last_used,revoked"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanApiKey(row scanner) (*ApiKey, error) {
+	var key ApiKey
+	err := row.Scan(&key.Id, &key.Name, &key.Prefix, &key.Role, &key.RateLimit, &key.CreatedBy, &key.CreatedAt, &key.LastUsed, &key.Revoked)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// CreateApiKey stores a key by the SHA-256 hash of it and returns its id.
+func (d *Database) CreateApiKey(key *ApiKey, hash string) (int64, error) {
+	ret, err := d.Conn.Exec("INSERT INTO api_keys(name,key_hash,prefix,`role`,rate_limit,created_by,created_at) VALUES (?,?,?,?,?,?,?)",
+		key.Name, hash, key.Prefix, key.Role, key.RateLimit, key.CreatedBy, key.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return ret.LastInsertId()
+}
+
+// GetApiKey returns the key of a hash unless it was revoked, nil if there's none.
+func (d *Database) GetApiKey(hash string) (*ApiKey, error) {
+	row := d.Conn.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash=? AND revoked=0", hash)
+	key, err := scanApiKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// GetApiKeys lists the keys, the revoked ones too, newest first.
+func (d *Database) GetApiKeys() ([]*ApiKey, error) {
+	rows, err := d.Conn.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*ApiKey
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeApiKey disables a key for good, false if there's no such key.
+func (d *Database) RevokeApiKey(id int64) (bool, error) {
+	ret, err := d.Conn.Exec("UPDATE api_keys SET revoked=1 WHERE id=? AND revoked=0", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := ret.RowsAffected()
+	return n > 0, err
+}
+
+// TouchApiKey records when a key was last used.
+func (d *Database) TouchApiKey(id int64, ts int64) error {
+	_, err := d.Conn.Exec("UPDATE api_keys SET last_used=? WHERE id=?", ts, id)
+	return err
+}
+
+// GetAccountRole returns the role of an admin account, empty if there's no such account.
+func (d *Database) GetAccountRole(id string) (string, error) {
+	var role string
+	err := d.Conn.QueryRow("SELECT `role` FROM account WHERE id=?", id).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// ChangeAccountRole sets the role of an admin account.
+func (d *Database) ChangeAccountRole(id string, role string) error {
+	_, err := d.Conn.Exec("UPDATE account SET `role`=? WHERE id=?", role, id)
+	return err
+}
@@ -0,0 +1,61 @@
+package mysql
+
+import (
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+)
+
+// States of the rounds the unlocker found no shares for, set by WriteImmatureError.
+const (
+	StateCandidateError = constCandidatesBlockErr
+	StateImmatureError  = constImmaturedBlockErr
+)
+
+// GetErrorBlocks returns the rounds stuck in StateCandidateError or StateImmatureError, oldest first.
+func (d *Database) GetErrorBlocks() ([]*types.BlockData, error) {
+	return d.queryBlocks("state IN (?,?) AND coin=? ORDER BY round_height", constCandidatesBlockErr, constImmaturedBlockErr, d.Config.Coin)
+}
+
+// queryBlocks returns the blocks matching where, with the columns needed to resolve them again.
+func (d *Database) queryBlocks(where string, args ...interface{}) ([]*types.BlockData, error) {
+	rows, err := d.Conn.Query("SELECT state,round_height,height,uncle_height,orphan,nonce,hash,`timestamp`,round_diff,total_share,IFNULL(reward,''),IFNULL(hash_no_nonce,''),IFNULL(mix_digest,''),finder,finder_worker FROM blocks "+
+		"WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*types.BlockData
+	for rows.Next() {
+		var (
+			state                            int
+			height, roundHeight, uncleHeight int64
+			nonce, hash, orphan, reward      string
+			roundDiff, totalShare, timestamp int64
+			powHash, mixDigest               string
+			finder, finderWorker             string
+		)
+		err := rows.Scan(&state, &roundHeight, &height, &uncleHeight, &orphan, &nonce, &hash, &timestamp, &roundDiff, &totalShare, &reward, &powHash, &mixDigest,
+			&finder, &finderWorker)
+		if err != nil {
+			return nil, err
+		}
+		block := d.convertBlockResults(state, height, roundHeight, uncleHeight, orphan, nonce, hash, timestamp, roundDiff, totalShare, reward)
+		block.PowHash = powHash
+		block.MixDigest = mixDigest
+		block.Finder, block.FinderWorker = finder, finderWorker
+		result = append(result, &block)
+	}
+	return result, rows.Err()
+}
+
+// RestoreCandidate puts a round of StateCandidateError back to a candidate, so it can be credited
+// as immature again.
+func (d *Database) RestoreCandidate(block *types.BlockData) error {
+	_, err := d.Conn.Exec("UPDATE blocks SET `state`=? WHERE state=? AND round_height=? AND nonce=? AND coin=?",
+		constCandidatesBlock, constCandidatesBlockErr, block.RoundHeight, block.Nonce, d.Config.Coin)
+	if err != nil {
+		return err
+	}
+	block.State = constCandidatesBlock
+	return nil
+}
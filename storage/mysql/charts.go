@@ -0,0 +1,71 @@
+package mysql
+
+// Width in seconds of the hashrate chart buckets, by name.
+var ChartBuckets = map[string]int64{
+	"1m": 60,
+	"1h": 3600,
+	"1d": 86400,
+}
+
+// ChartPoint is the average hashrate of the samples of a bucket.
+type ChartPoint struct {
+	// Start of the bucket
+	Timestamp int64 `json:"timestamp"`
+	Hashrate  int64 `json:"hashrate"`
+}
+
+// AddHashrateSample adds a hashrate taken at ts to the buckets of login, the pool's when login is empty.
+func (d *Database) AddHashrateSample(login string, ts int64, hashrate int64) error {
+	conn := d.Conn
+	args := make([]interface{}, 0, len(ChartBuckets)*5)
+	values := ""
+	for _, width := range ChartBuckets {
+		if len(values) > 0 {
+			values += ","
+		}
+		values += "(?,?,?,?,?,1)"
+		args = append(args, d.Config.Coin, login, width, ts-ts%width, hashrate)
+	}
+	_, err := conn.Exec("INSERT INTO hashrate_rollups(coin,login_addr,bucket,`time`,hashrate_sum,samples) VALUES "+values+
+		" ON DUPLICATE KEY UPDATE hashrate_sum=hashrate_sum+VALUES(hashrate_sum),samples=samples+1", args...)
+	return err
+}
+
+// GetHashrateChart returns the buckets of width seconds of login, the pool's when login is empty, starting
+// from from up to before to, oldest first.
+func (d *Database) GetHashrateChart(login string, width, from, to int64) ([]ChartPoint, error) {
+	conn := d.Conn
+	rows, err := conn.Query("SELECT `time`,hashrate_sum,samples FROM hashrate_rollups "+
+		"WHERE coin=? AND login_addr=? AND bucket=? AND `time`>=? AND `time`<? ORDER BY `time`",
+		d.Config.Coin, login, width, from-from%width, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []ChartPoint{}
+	for rows.Next() {
+		var (
+			p            ChartPoint
+			sum, samples int64
+		)
+		if err := rows.Scan(&p.Timestamp, &sum, &samples); err != nil {
+			return nil, err
+		}
+		if samples > 0 {
+			p.Hashrate = sum / samples
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// DeleteHashrateRollups drops the buckets of width seconds older than before.
+func (d *Database) DeleteHashrateRollups(width, before int64) (int64, error) {
+	conn := d.Conn
+	ret, err := conn.Exec("DELETE FROM hashrate_rollups WHERE coin=? AND bucket=? AND `time`<?", d.Config.Coin, width, before)
+	if err != nil {
+		return 0, err
+	}
+	return ret.RowsAffected()
+}
@@ -0,0 +1,78 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// payout_contracts.state
+const (
+	// Paid with the contract gas limit
+	ContractDetected = "detected"
+	// Not paid until approved through the API
+	ContractHeld     = "held"
+	ContractApproved = "approved"
+)
+
+// PayoutContract is a payee found to be a contract.
+type PayoutContract struct {
+	Address    string `json:"address"`
+	State      string `json:"state"`
+	UpdatedBy  string `json:"updatedBy"`
+	DetectedAt int64  `json:"detectedAt"`
+	UpdatedAt  int64  `json:"updatedAt"`
+}
+
+// GetPayoutContract returns the contract payee address, nil if it wasn't found to be one.
+func (d *Database) GetPayoutContract(address string) (*PayoutContract, error) {
+	c := &PayoutContract{}
+	err := d.Conn.QueryRow("SELECT address,state,updated_by,detected_at,updated_at FROM payout_contracts WHERE coin=? AND address=?",
+		d.Config.Coin, strings.ToLower(address)).Scan(&c.Address, &c.State, &c.UpdatedBy, &c.DetectedAt, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AddPayoutContract records address as a contract payee in state.
+func (d *Database) AddPayoutContract(address string, state string) error {
+	now := time.Now().Unix()
+	_, err := d.Conn.Exec("INSERT INTO payout_contracts(coin,address,state,detected_at,updated_at) VALUES (?,?,?,?,?) "+
+		"ON DUPLICATE KEY UPDATE state=VALUES(state),updated_at=VALUES(updated_at)",
+		d.Config.Coin, strings.ToLower(address), state, now, now)
+	return err
+}
+
+// SetPayoutContractState moves the contract payee address to state, false if it isn't one.
+func (d *Database) SetPayoutContractState(address string, state string, by string) (bool, error) {
+	res, err := d.Conn.Exec("UPDATE payout_contracts SET state=?,updated_by=?,updated_at=? WHERE coin=? AND address=?",
+		state, by, time.Now().Unix(), d.Config.Coin, strings.ToLower(address))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetPayoutContracts returns the contract payees, the latest found first.
+func (d *Database) GetPayoutContracts() ([]*PayoutContract, error) {
+	rows, err := d.Conn.Query("SELECT address,state,updated_by,detected_at,updated_at FROM payout_contracts WHERE coin=? ORDER BY detected_at DESC",
+		d.Config.Coin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]*PayoutContract, 0)
+	for rows.Next() {
+		c := &PayoutContract{}
+		if err := rows.Scan(&c.Address, &c.State, &c.UpdatedBy, &c.DetectedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
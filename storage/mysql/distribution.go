@@ -0,0 +1,52 @@
+package mysql
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+)
+
+// writeRoundDistribution stores the share distribution of a round with its block, once it matured.
+func (d *Database) writeRoundDistribution(tx *sql.Tx, block *types.BlockData, distribution *types.RoundDistribution) error {
+	if distribution == nil {
+		return nil
+	}
+	histogram, err := json.Marshal(distribution.Histogram)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE blocks SET `miners`=?,`gini`=?,`top10_share`=?,`share_histogram`=? WHERE state=? AND round_height=? AND nonce=? AND coin=?",
+		distribution.Miners, distribution.Gini, distribution.Top10Share, string(histogram), constMatureBlock, block.RoundHeight, block.Nonce, d.Config.Coin)
+	return err
+}
+
+// GetRoundDistributions returns the share distributions of the blocks matured at height, usually one.
+// Blocks matured before distributions were stored are left out.
+func (d *Database) GetRoundDistributions(height int64) ([]*types.RoundDistribution, error) {
+	rows, err := d.Conn.Query("SELECT height,round_height,hash,total_share,miners,gini,top10_share,share_histogram FROM blocks "+
+		"WHERE coin=? AND state=? AND height=? AND miners>0 ORDER BY round_height", d.Config.Coin, constMatureBlock, height)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*types.RoundDistribution
+	for rows.Next() {
+		var (
+			dist      types.RoundDistribution
+			histogram string
+		)
+		err := rows.Scan(&dist.Height, &dist.RoundHeight, &dist.Hash, &dist.TotalShares, &dist.Miners, &dist.Gini, &dist.Top10Share, &histogram)
+		if err != nil {
+			return nil, err
+		}
+		if len(histogram) > 0 {
+			if err := json.Unmarshal([]byte(histogram), &dist.Histogram); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, &dist)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,45 @@
+package mysql
+
+// BlockCounts are the blocks of the pool by stage.
+type BlockCounts struct {
+	Candidates int64 `json:"candidates"`
+	Immature   int64 `json:"immature"`
+	Matured    int64 `json:"matured"`
+	Orphaned   int64 `json:"orphaned"`
+}
+
+// CountBlocks counts the blocks of the pool by stage.
+func (d *Database) CountBlocks() (*BlockCounts, error) {
+	conn := d.Conn
+	rows, err := conn.Query("SELECT state,COUNT(*) FROM blocks WHERE coin=? GROUP BY state", d.Config.Coin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := &BlockCounts{}
+	for rows.Next() {
+		var state, count int64
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		switch state {
+		case constCandidatesBlock:
+			counts.Candidates += count
+		case constImmatureBlock, constPeddingImmaturedBlock:
+			counts.Immature += count
+		case constMatureBlock:
+			counts.Matured += count
+		case constOrphanBlock:
+			counts.Orphaned += count
+		}
+	}
+	return counts, rows.Err()
+}
+
+// CountPendingPayments counts the payout txs waiting for their confirmations.
+func (d *Database) CountPendingPayments() (int64, error) {
+	var count int64
+	err := d.Conn.QueryRow("SELECT COUNT(*) FROM payments_all WHERE coin=? AND `state`=?", d.Config.Coin, PaymentPending).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,150 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+)
+
+// Payment is a payout as listed in payments_all, amounts in Shannon.
+type Payment struct {
+	Seq    int64  `json:"seq"`
+	Login  string `json:"login"`
+	From   string `json:"from"`
+	TxHash string `json:"tx"`
+	Amount int64  `json:"amount"`
+	TxFee  int64  `json:"txFee"`
+	State  int    `json:"state"`
+	// Coin price at payout time, empty if unknown
+	Rate         string `json:"rate,omitempty"`
+	RateCurrency string `json:"rateCurrency,omitempty"`
+	Timestamp    int64  `json:"timestamp"`
+}
+
+// Block states of each status a history page can be filtered by.
+var BlockStatuses = map[string][]int{
+	"immature": {constImmatureBlock, constPeddingImmaturedBlock},
+	"matured":  {constMatureBlock},
+	"orphaned": {constOrphanBlock},
+}
+
+// Payment states of each status a history page can be filtered by.
+var PaymentStatuses = map[string][]int{
+	"pending":   {PaymentPending},
+	"confirmed": {PaymentConfirmed},
+	"failed":    {PaymentFailed},
+	"review":    {PaymentReview},
+}
+
+// HistoryFilter narrows a block or payment history page.
+type HistoryFilter struct {
+	// States listed, all the list has when empty
+	States []int
+	// Unix time range, From included and To excluded, unbounded when 0
+	From, To int64
+	// Oldest first, the cursor is then the key after which the page starts
+	Ascending bool
+}
+
+// where returns the conditions of the filter on the key column and its arguments, the page starting after cursor.
+func (f *HistoryFilter) where(key string, cursor int64) (string, []interface{}) {
+	var (
+		sql  strings.Builder
+		args []interface{}
+	)
+	if len(f.States) > 0 {
+		sql.WriteString(" AND `state` IN (?" + strings.Repeat(",?", len(f.States)-1) + ")")
+		for _, state := range f.States {
+			args = append(args, state)
+		}
+	}
+	if f.From > 0 {
+		sql.WriteString(" AND `timestamp`>=?")
+		args = append(args, f.From)
+	}
+	if f.To > 0 {
+		sql.WriteString(" AND `timestamp`<?")
+		args = append(args, f.To)
+	}
+	if f.Ascending {
+		sql.WriteString(" AND " + key + ">? ORDER BY " + key + " ASC")
+	} else {
+		if cursor <= 0 {
+			cursor = 1<<63 - 1
+		}
+		sql.WriteString(" AND " + key + "<? ORDER BY " + key + " DESC")
+	}
+	args = append(args, cursor)
+	return sql.String(), args
+}
+
+// StreamBlockHistory calls fn with up to limit matured and orphaned blocks past the height cursor, newest first
+// unless the filter asks for the oldest, while it reads them. A cursor of 0 starts from the first. Immature
+// blocks are listed only when the filter asks for them.
+func (d *Database) StreamBlockHistory(cursor int64, limit int, filter HistoryFilter, fn func(*types.BlockData) error) error {
+	conn := d.Conn
+	if len(filter.States) == 0 {
+		filter.States = []int{constOrphanBlock, constMatureBlock}
+	}
+	where, args := filter.where("height", cursor)
+	args = append([]interface{}{d.Config.Coin}, append(args, limit)...)
+	rows, err := conn.Query("SELECT state,round_height,height,uncle_height,orphan,nonce,hash,`timestamp`,round_diff,total_share,reward,finder,finder_worker,finder_bonus FROM blocks "+
+		"WHERE coin=?"+where+" LIMIT ?", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			state                            int
+			height, roundHeight, uncleHeight int64
+			nonce, hash, orphan, reward      string
+			roundDiff, totalShare, timestamp int64
+			finder, finderWorker             string
+			finderBonus                      int64
+		)
+		err := rows.Scan(&state, &roundHeight, &height, &uncleHeight, &orphan, &nonce, &hash, &timestamp, &roundDiff, &totalShare, &reward,
+			&finder, &finderWorker, &finderBonus)
+		if err != nil {
+			return err
+		}
+		block := d.convertBlockResults(state, height, roundHeight, uncleHeight, orphan, nonce, hash, timestamp, roundDiff, totalShare, reward)
+		block.Finder, block.FinderWorker, block.FinderBonus = finder, finderWorker, finderBonus
+		if err := fn(&block); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// StreamPayments calls fn with up to limit payments past the seq cursor, newest first unless the filter asks
+// for the oldest, only the ones of login if it is set. A cursor of 0 starts from the first.
+func (d *Database) StreamPayments(login string, cursor int64, limit int, filter HistoryFilter, fn func(*Payment) error) error {
+	conn := d.Conn
+	where, args := filter.where("seq", cursor)
+	args = append([]interface{}{d.Config.Coin, login, login}, append(args, limit)...)
+	rows, err := conn.Query("SELECT seq,login_addr,`from`,tx_hash,IFNULL(amount,0),IFNULL(tx_fee,0),`state`,rate,rate_currency,IFNULL(`timestamp`,0) FROM payments_all "+
+		"WHERE coin=? AND (?='' OR login_addr=?)"+where+" LIMIT ?", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			p                      Payment
+			txHash, rate, currency sql.NullString
+		)
+		err := rows.Scan(&p.Seq, &p.Login, &p.From, &txHash, &p.Amount, &p.TxFee, &p.State, &rate, &currency, &p.Timestamp)
+		if err != nil {
+			return err
+		}
+		p.TxHash, p.Rate, p.RateCurrency = txHash.String, rate.String, currency.String
+		if err := fn(&p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,20 @@
+package mysql
+
+import "testing"
+
+func TestHistoryFilter(t *testing.T) {
+	filter := HistoryFilter{States: []int{PaymentPending, PaymentConfirmed}, From: 100, To: 200}
+	where, args := filter.where("seq", 0)
+	if where != " AND `state` IN (?,?) AND `timestamp`>=? AND `timestamp`<? AND seq<? ORDER BY seq DESC" {
+		t.Errorf("Unexpected conditions %q", where)
+	}
+	if len(args) != 5 || args[4] != int64(1<<63-1) {
+		t.Errorf("Unexpected arguments %v", args)
+	}
+
+	filter = HistoryFilter{Ascending: true}
+	where, args = filter.where("height", 10)
+	if where != " AND height>? ORDER BY height ASC" || len(args) != 1 || args[0] != int64(10) {
+		t.Errorf("Unexpected ascending conditions %q, %v", where, args)
+	}
+}
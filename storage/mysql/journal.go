@@ -0,0 +1,11 @@
+package mysql
+
+// CompactShareJournal forgets the seqs of journalId below seq, all of them are committed in the journal.
+func (d *Database) CompactShareJournal(journalId string, seq uint64) (int64, error) {
+	conn := d.Conn
+	res, err := conn.Exec("DELETE FROM share_journal WHERE coin=? AND journal_id=? AND seq<?", d.Config.Coin, journalId, seq)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,266 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+)
+
+// Kinds of ledger entries, each a change of a miner's balance.
+const (
+	LedgerCredit       = "credit"
+	LedgerDebit        = "debit"
+	LedgerFee          = "fee"
+	LedgerCompensation = "compensation"
+)
+
+// Reasons of ledger entries, why a balance changed.
+const (
+	ReasonBlockReward = "blockReward"
+	ReasonUncleReward = "uncleReward"
+	ReasonPoolFee     = "poolFee"
+	ReasonDonation    = "donation"
+	ReasonPayout      = "payout"
+	ReasonPayoutGas   = "payoutGasFee"
+	// A correction file imported through the API
+	ReasonAdjustment = "manualAdjustment"
+	// Manual adjustments of a single miner
+	ReasonRefund       = "refund"
+	ReasonCompensation = "compensation"
+	// Round-off dust of the block rewards, with unlocker.remainderTo
+	ReasonRemainder = "remainder"
+	// Bonus of the login that found a block, with unlocker.finderBonus
+	ReasonFinderBonus = "finderBonus"
+)
+
+// PoolCredit is a part of a login's block credit that isn't its share of the round.
+type PoolCredit struct {
+	Login  string
+	Reason string
+	Amount int64
+	// Exact amount before it was rounded to Shannon, nil if unknown
+	Wei *big.Int
+}
+
+// LedgerEntry is a change of a miner's balance in Shannon, negative for debits and fees.
+// Seq orders the entries and tells duplicates apart for consumers.
+type LedgerEntry struct {
+	Seq    int64  `json:"seq"`
+	Coin   string `json:"coin"`
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+	Login  string `json:"login"`
+	Amount int64  `json:"amount"`
+	// Exact wei of block credits, before they were rounded to Shannon
+	AmountWei string `json:"amountWei,omitempty"`
+	// Block hash of credits, tx hash of debits and fees, idempotency key of compensations
+	Ref       string `json:"ref"`
+	Height    int64  `json:"height,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// writeLedgerEntry records an entry in the transaction that changes the balance. An entry written twice,
+// e.g. by a retried transaction, is kept once.
+func (d *Database) writeLedgerEntry(tx *sql.Tx, kind, reason, login string, amount int64, ref string, height, ts int64) error {
+	_, err := tx.Exec("INSERT IGNORE INTO ledger_entries(coin,kind,reason,login_addr,amount,ref,height,`timestamp`) VALUES (?,?,?,?,?,?,?,?)",
+		d.Config.Coin, kind, reason, login, amount, ref, height, ts)
+	return err
+}
+
+// writeBlockLedgerEntries records the credits of a matured block, the pool credits apart from the
+// share of the round they are included in. roundWei are the exact shares of the round, if known.
+func (d *Database) writeBlockLedgerEntries(tx *sql.Tx, block *types.BlockData, roundRewards map[string]int64, roundWei map[string]*big.Int, poolCredits []*PoolCredit) error {
+	reason := ReasonBlockReward
+	if block.UncleHeight > 0 {
+		reason = ReasonUncleReward
+	}
+	shares := make(map[string]int64, len(roundRewards))
+	for login, amount := range roundRewards {
+		shares[login] = amount
+	}
+	for _, c := range poolCredits {
+		shares[c.Login] -= c.Amount
+	}
+
+	const batch = 1000
+	var (
+		query strings.Builder
+		args  []interface{}
+	)
+	flush := func() error {
+		if len(args) == 0 {
+			return nil
+		}
+		_, err := tx.Exec("INSERT IGNORE INTO ledger_entries(coin,kind,reason,login_addr,amount,amount_wei,ref,height,`timestamp`) VALUES "+query.String(), args...)
+		query.Reset()
+		args = args[:0]
+		return err
+	}
+	add := func(reason, login string, amount int64, wei *big.Int) error {
+		if query.Len() > 0 {
+			query.WriteByte(',')
+		}
+		query.WriteString("(?,?,?,?,?,?,?,?,?)")
+		args = append(args, d.Config.Coin, LedgerCredit, reason, login, amount, weiValue(wei), block.Hash, block.Height, block.Timestamp)
+		if len(args) >= batch*9 {
+			return flush()
+		}
+		return nil
+	}
+
+	for login, amount := range shares {
+		if amount == 0 {
+			continue
+		}
+		if err := add(reason, login, amount, roundWei[login]); err != nil {
+			return err
+		}
+	}
+	for _, c := range poolCredits {
+		if c.Amount == 0 {
+			continue
+		}
+		if err := add(c.Reason, c.Login, c.Amount, c.Wei); err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+// weiValue is the column value of an exact amount, NULL when it's unknown.
+func weiValue(wei *big.Int) interface{} {
+	if wei == nil {
+		return nil
+	}
+	return wei.String()
+}
+
+// GetLedgerEntries returns up to limit entries after seq, oldest first.
+func (d *Database) GetLedgerEntries(after int64, limit int) ([]*LedgerEntry, error) {
+	conn := d.Conn
+	rows, err := conn.Query("SELECT seq,coin,kind,reason,login_addr,amount,amount_wei,ref,height,`timestamp` FROM ledger_entries WHERE coin=? AND seq>? ORDER BY seq LIMIT ?",
+		d.Config.Coin, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanLedgerEntries(rows)
+}
+
+// GetMinerLedger returns up to limit entries of a login before seq, newest first. A seq of 0 starts from the newest.
+func (d *Database) GetMinerLedger(login string, before int64, limit int) ([]*LedgerEntry, error) {
+	conn := d.Conn
+	if before <= 0 {
+		before = 1<<63 - 1
+	}
+	rows, err := conn.Query("SELECT seq,coin,kind,reason,login_addr,amount,amount_wei,ref,height,`timestamp` FROM ledger_entries WHERE coin=? AND login_addr=? AND seq<? ORDER BY seq DESC LIMIT ?",
+		d.Config.Coin, login, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanLedgerEntries(rows)
+}
+
+// StreamMinerLedger calls fn with up to limit entries of a login of kind past the seq cursor, newest first unless
+// the filter asks for the oldest, while it reads them. A cursor of 0 starts from the first.
+func (d *Database) StreamMinerLedger(login, kind string, cursor int64, limit int, filter HistoryFilter, fn func(*LedgerEntry) error) error {
+	conn := d.Conn
+	filter.States = nil
+	where, args := filter.where("seq", cursor)
+	args = append([]interface{}{d.Config.Coin, login, kind}, append(args, limit)...)
+	rows, err := conn.Query("SELECT seq,coin,kind,reason,login_addr,amount,amount_wei,ref,height,`timestamp` FROM ledger_entries "+
+		"WHERE coin=? AND login_addr=? AND kind=?"+where+" LIMIT ?", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanLedgerEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func scanLedgerEntry(rows *sql.Rows) (*LedgerEntry, error) {
+	var (
+		e   LedgerEntry
+		wei sql.NullString
+	)
+	err := rows.Scan(&e.Seq, &e.Coin, &e.Kind, &e.Reason, &e.Login, &e.Amount, &wei, &e.Ref, &e.Height, &e.Timestamp)
+	e.AmountWei = wei.String
+	return &e, err
+}
+
+func scanLedgerEntries(rows *sql.Rows) ([]*LedgerEntry, error) {
+	defer rows.Close()
+
+	var result []*LedgerEntry
+	for rows.Next() {
+		e, err := scanLedgerEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// LedgerTotal sums the entries of a login with a reason.
+type LedgerTotal struct {
+	Reason  string `json:"reason"`
+	Entries int64  `json:"entries"`
+	Amount  int64  `json:"amount"`
+}
+
+// GetMinerLedgerTotals sums a login's entries by reason. Their sum matches the login's balance while no payout
+// of it is in progress.
+func (d *Database) GetMinerLedgerTotals(login string) ([]*LedgerTotal, int64, error) {
+	conn := d.Conn
+	rows, err := conn.Query("SELECT reason,COUNT(*),CAST(SUM(amount) AS SIGNED) FROM ledger_entries WHERE coin=? AND login_addr=? GROUP BY reason ORDER BY reason",
+		d.Config.Coin, login)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var (
+		result []*LedgerTotal
+		sum    int64
+	)
+	for rows.Next() {
+		var t LedgerTotal
+		if err := rows.Scan(&t.Reason, &t.Entries, &t.Amount); err != nil {
+			return nil, 0, fmt.Errorf("ledger totals of %v: %v", login, err)
+		}
+		sum += t.Amount
+		result = append(result, &t)
+	}
+	return result, sum, rows.Err()
+}
+
+// GetLedgerCursor returns the seq of the last entry delivered to sink, 0 before the first.
+func (d *Database) GetLedgerCursor(sink string) (int64, error) {
+	conn := d.Conn
+	var seq int64
+	err := conn.QueryRow("SELECT seq FROM ledger_cursors WHERE coin=? AND sink=?", d.Config.Coin, sink).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// SetLedgerCursor stores the seq of the last entry delivered to sink.
+func (d *Database) SetLedgerCursor(sink string, seq int64) error {
+	conn := d.Conn
+	_, err := conn.Exec("INSERT INTO ledger_cursors(coin,sink,seq) VALUES (?,?,?) ON DUPLICATE KEY UPDATE seq=VALUES(seq)",
+		d.Config.Coin, sink, seq)
+	return err
+}
@@ -3,6 +3,7 @@ package mysql
 import (
 	"database/sql"
 	"fmt"
+	"github.com/cellcrypto/open-dangnn-pool/storage/postgres"
 	"github.com/cellcrypto/open-dangnn-pool/storage/redis"
 	"github.com/cellcrypto/open-dangnn-pool/storage/types"
 	"github.com/cellcrypto/open-dangnn-pool/util"
@@ -12,12 +13,23 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"log"
 	"math/big"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// Databases the storage layer runs on
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+)
+
 type Config struct {
+	// mysql (MySQL or MariaDB, the default) or postgres
+	Driver string `json:"driver"`
+	// PostgreSQL sslmode, lib/pq's default when empty
+	SSLMode string `json:"sslMode"`
 	Endpoint string `json:"endpoint"`
 	UserName string `json:"user"`
 	Password string `json:"password"`
@@ -26,8 +38,14 @@ type Config struct {
 	PoolSize int    `json:"poolSize"`
 
 	Coin 	string  `json:"coin"`
+	// Pool the rows belong to, for its log table entries
+	Pool 	string  `json:"-"`
 	Threshold int64 `json:"threshold"`
 	LogTableName string `json:"logTableName"`
+	// Embedded schema migrations applied at start
+	Migrations MigrationsConfig `json:"migrations"`
+	// Credit and pay balances exact to the wei, not rounded to Shannon
+	WeiAccounting bool `json:"weiAccounting"`
 }
 
 type Database struct {
@@ -42,7 +60,11 @@ type Payees struct {
 	Coin string
 	Addr string
 	Balance int64
+	// Exact balance, paid with weiAccounting
+	BalanceWei *big.Int
 	Payout_limit int64
+	// Passed to the post payout hook, e.g. for exchange deposit addresses
+	Memo string
 }
 
 type MinerChartSelect struct {
@@ -65,6 +87,7 @@ const (
 	constPeddingImmaturedBlock = 2
 	constOrphanBlock=3
 	constMatureBlock = 4
+	constDuplicateBlock = 5
 )
 
 type ImmaturedState string
@@ -72,16 +95,96 @@ const (
 	eMaturedBlock = ImmaturedState("MaturedBlock")
 	eOrphanBlock  = ImmaturedState("OrphanBlock")
 	eLostBlock		= ImmaturedState("LostBlock")
+	eDuplicateBlock	= ImmaturedState("DuplicateBlock")
 )
 
+// payments_all.state
+const (
+	PaymentFailed    = -1
+	PaymentPending   = 0
+	PaymentConfirmed = 1
+	// The tracker gave up on a stuck tx, an operator checks whether it was mined
+	PaymentReview = 2
+)
+
+// payout_reports.state
+const (
+	ReportPending  = "pending"
+	ReportAuto     = "auto"
+	ReportApproved = "approved"
+	ReportRejected = "rejected"
+	ReportExpired  = "expired"
+	ReportExecuted = "executed"
+)
+
+type PayoutReport struct {
+	Id          int64  `json:"id"`
+	State       string `json:"state"`
+	Recipients  int    `json:"recipients"`
+	Amount      int64  `json:"amount"`
+	TxFee       int64  `json:"txFee"`
+	PoolBalance int64  `json:"poolBalance"`
+	PostBalance int64  `json:"postBalance"`
+	Report      string `json:"report"`
+	ApprovedBy  string `json:"approvedBy"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// compensations.state
+const (
+	CompensationPending  = "pending"
+	CompensationApproved = "approved"
+	CompensationRejected = "rejected"
+	CompensationApplied  = "applied"
+)
+
+// compensations.kind
+const (
+	// Imported correction file
+	CompensationCorrection = "correction"
+	// Manual adjustments of a miner's balance
+	CompensationRefund = "refund"
+	CompensationCredit = "compensation"
+)
+
+// compensation_items.state
+const (
+	CompensationItemPending = "pending"
+	CompensationItemApplied = "applied"
+	// A debit larger than the miner's balance
+	CompensationItemFailed = "failed"
+)
+
+// Compensation is a set of balance corrections, amounts in Shannon.
+type Compensation struct {
+	Id         int64  `json:"id"`
+	Key        string `json:"key"`
+	Kind       string `json:"kind"`
+	State      string `json:"state"`
+	Reason     string `json:"reason"`
+	Miners     int    `json:"miners"`
+	Total      int64  `json:"total"`
+	Applied    int64  `json:"applied"`
+	CreatedBy  string `json:"createdBy"`
+	ApprovedBy string `json:"approvedBy"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+type CompensationItem struct {
+	IdemKey   string `json:"-"`
+	Login     string `json:"login"`
+	Amount    int64  `json:"amount"`
+	State     string `json:"state"`
+	Reason    string `json:"reason,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
 const constInsertCountSqlMax = 2000
 
 
 func New(cfg *Config, proxyDiff int64,redis *redis.RedisClient) (*Database, error) {
 
-	url := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-		cfg.UserName, cfg.Password, cfg.Endpoint, cfg.Port, cfg.Database)
-	conn, err := sql.Open("mysql", url)
+	conn, err := open(cfg)
 	if err != nil {
 		println(err)
 		return nil, err
@@ -102,28 +205,62 @@ func New(cfg *Config, proxyDiff int64,redis *redis.RedisClient) (*Database, erro
 		return nil, err
 	}
 
+	if err := db.migrate(); err != nil {
+		return nil, fmt.Errorf("schema migration: %v", err)
+	}
 	return db, nil
 }
 
+// open connects to the database of cfg.Driver. Sessions run in UTC whatever the server's time zone,
+// so TIMESTAMP columns are written and read in UTC.
+func open(cfg *Config) (*sql.DB, error) {
+	switch cfg.Driver {
+	case "", DriverMySQL:
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?time_zone=%%27%%2B00%%3A00%%27",
+			cfg.UserName, cfg.Password, cfg.Endpoint, cfg.Port, cfg.Database)
+		return sql.Open("mysql", dsn)
+	case DriverPostgres:
+		params := url.Values{"timezone": {"UTC"}}
+		if len(cfg.SSLMode) > 0 {
+			params.Set("sslmode", cfg.SSLMode)
+		}
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(cfg.UserName, cfg.Password),
+			Host:     fmt.Sprintf("%s:%d", cfg.Endpoint, cfg.Port),
+			Path:     "/" + cfg.Database,
+			RawQuery: params.Encode(),
+		}
+		return postgres.Open(dsn.String())
+	}
+	return nil, fmt.Errorf("unknown driver %v", cfg.Driver)
+}
 
-func (d *Database) InsertSqlLog(sql *string) {
-	conn := d.Conn
-
-	_, err := conn.Exec(*sql)
-	if err != nil {
-		log.Fatal(err)
+// InsertLogs writes a batch of system log entries to table in one statement.
+func (d *Database) InsertLogs(table string, entries []*plogger.Entry) error {
+	if len(entries) == 0 {
+		return nil
 	}
-	return
+	var sql strings.Builder
+	sql.WriteString("INSERT INTO " + table + "(`msg_type`,`msg_err`,`where`,`round_height`,`height`,`addr`,`addr2`,`msg`,`insert_time`) VALUES ")
+	args := make([]interface{}, 0, len(entries)*9)
+	for i, e := range entries {
+		if i > 0 {
+			sql.WriteString(",")
+		}
+		sql.WriteString("(?,?,?,?,?,?,?,?,?)")
+		args = append(args, e.Type, e.SubType, e.Where, e.RoundHeight, e.Height, e.Addr, e.Addr2, e.Msg, util.FormatDBTime(e.Time))
+	}
+	_, err := d.Conn.Exec(sql.String(), args...)
+	return err
 }
 
 
 func (d *Database) WriteBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration, hostname string)  {
 	conn := d.Conn
 
+	// Vardiff shares count as many share units as their difficulty holds.
 	diffTimes := int(diff / d.DiffByShareValue)
-	if diffTimes > 1 {
-		diffTimes = 1	// fixed to 1
-	}
 	nowTime := time.Now()
 
 	tx, err := conn.Begin()
@@ -145,12 +282,11 @@ func (d *Database) WriteBlock(login, id string, params []string, diff, roundDiff
 	}
 }
 
-func (d *Database) WriteShare(login, id string, params []string, diff int64, height uint64, window time.Duration, hostname string) error {
+// WriteShare credits a share. A share of the journal with a seq above 0 is credited once, it's skipped
+// if the seq was already written.
+func (d *Database) WriteShare(login, id string, params []string, diff int64, height uint64, window time.Duration, hostname string, journalId string, seq uint64) error {
 	conn := d.Conn
 	diffTimes := int(diff / d.DiffByShareValue)
-	if diffTimes > 1 {
-		diffTimes = 1	// fixed to 1
-	}
 
 	nowTime := time.Now()
 
@@ -159,6 +295,15 @@ func (d *Database) WriteShare(login, id string, params []string, diff int64, hei
 		log.Fatal(err)
 	}
 	defer tx.Rollback()
+	if seq > 0 {
+		res, err := tx.Exec("INSERT IGNORE INTO share_journal(`coin`,`journal_id`,`seq`) VALUES (?,?,?)", d.Config.Coin, journalId, seq)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+	}
 	_, err = tx.Exec(
 		"INSERT INTO miner_info(`coin`,`login_addr`,`diff_times`,`hostname`,`share`,`last_share`) VALUES (?,?,?,?,?,?)  ON DUPLICATE KEY UPDATE diff_times=diff_times+VALUES(diff_times),hostname=VALUES(hostname),share=share+VALUES(share),last_share=VALUES(last_share)",
 		d.Config.Coin,login,diffTimes,hostname,diffTimes,nowTime)
@@ -175,7 +320,7 @@ func (d *Database) WriteShare(login, id string, params []string, diff int64, hei
 }
 
 
-func (d *Database) WriteCandidates(height uint64, params []string, nowTime string,ts int64, roundDiff int64, totalShares int64)  {
+func (d *Database) WriteCandidates(height uint64, params []string, finder, worker string, nowTime string,ts int64, roundDiff int64, totalShares int64, roundShares map[string]int64)  {
 	conn := d.Conn
 
 	tx, err := conn.Begin()
@@ -184,11 +329,14 @@ func (d *Database) WriteCandidates(height uint64, params []string, nowTime strin
 	}
 	defer tx.Rollback()
 	_, err = tx.Exec(
-		"INSERT INTO blocks(`state`, `coin`,`round_height`,`nonce`,`height`,`hash_no_nonce`,`mix_digest`,`round_diff`,`total_share`,`timestamp`,`insert_time`) VALUES (?,?,?,?,?,?,?,?,?,?,?)",
-		constCandidatesBlock, d.Config.Coin, height, params[0], height, params[1], params[2], roundDiff, totalShares, ts, nowTime)
+		"INSERT INTO blocks(`state`, `coin`,`round_height`,`nonce`,`height`,`hash_no_nonce`,`mix_digest`,`round_diff`,`total_share`,`timestamp`,`insert_time`,`finder`,`finder_worker`) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)",
+		constCandidatesBlock, d.Config.Coin, height, params[0], height, params[1], params[2], roundDiff, totalShares, ts, nowTime, finder, worker)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := d.writeRoundShares(tx, height, params[0], roundShares); err != nil {
+		log.Fatal(err)
+	}
 
 	err = tx.Commit()
 	if err != nil {
@@ -200,7 +348,7 @@ func (d *Database) WriteCandidates(height uint64, params []string, nowTime strin
 func (d *Database) GetCandidates(maxHeight int64) ([]*types.BlockData, error) {
 	conn := d.Conn
 
-	rows, err := conn.Query("SELECT round_height,nonce,hash_no_nonce,mix_digest,round_diff,total_share,insert_time,`timestamp` FROM blocks WHERE state=0 AND coin=? AND round_height < ?", d.Config.Coin, maxHeight)
+	rows, err := conn.Query("SELECT round_height,nonce,hash_no_nonce,mix_digest,round_diff,total_share,insert_time,`timestamp`,finder,finder_worker FROM blocks WHERE state=0 AND coin=? AND round_height < ?", d.Config.Coin, maxHeight)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -214,9 +362,10 @@ func (d *Database) GetCandidates(maxHeight int64) ([]*types.BlockData, error) {
 			roundDiff, totalShare       int64
 			insertTime                  string
 			timestamp					int64
+			finder, finderWorker		string
 		)
 
-		err := rows.Scan(&height,&nonce,&hashNoNonce,&mixDigest,&roundDiff,&totalShare,&insertTime,&timestamp)
+		err := rows.Scan(&height,&nonce,&hashNoNonce,&mixDigest,&roundDiff,&totalShare,&insertTime,&timestamp,&finder,&finderWorker)
 		if err != nil {
 			log.Printf("mysql GetCandidates:rows.Scan() error: %v",err)
 			return nil, err
@@ -231,6 +380,8 @@ func (d *Database) GetCandidates(maxHeight int64) ([]*types.BlockData, error) {
 		block.Timestamp = timestamp
 		block.Difficulty = roundDiff
 		block.TotalShares = totalShare
+		block.Finder = finder
+		block.FinderWorker = finderWorker
 		//block.candidateKey = v.Member.(string)
 		result = append(result, &block)
 	}
@@ -244,12 +395,12 @@ func (d *Database) WritePendingOrphans(blocks []*types.BlockData) error {
 	for _, block := range blocks {
 		exist, err := r.IsRoundNumber(block.RoundHeight, block.Nonce)
 		if err != nil {
-			plogger.InsertLog("WritePendingOrphans():Failed IsRoundNumber Error: " + err.Error(), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
+			plogger.For(d.Config.Pool).InsertLog("WritePendingOrphans():Failed IsRoundNumber Error: " + err.Error(), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
 		 	return err
 		}
 
 		if !exist {
-			plogger.InsertLog(fmt.Sprintf("WritePendingOrphans:IsRoundNumber not exist. block.RoundHeight: %v, block.Nonce:%v", block.RoundHeight, block.Nonce), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
+			plogger.For(d.Config.Pool).InsertLog(fmt.Sprintf("WritePendingOrphans:IsRoundNumber not exist. block.RoundHeight: %v, block.Nonce:%v", block.RoundHeight, block.Nonce), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
 			continue
 		}
 
@@ -323,25 +474,25 @@ func (d *Database) WriteImmatureBlock(block *types.BlockData, roundRewards map[s
 
 	exist, err := r.IsRoundNumber(block.RoundHeight, block.Nonce)
 	if err != nil {
-		plogger.InsertLog("writeImmatureBlock():Failed IsRoundNumber Error: " + err.Error(), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
+		plogger.For(d.Config.Pool).InsertLog("writeImmatureBlock():Failed IsRoundNumber Error: " + err.Error(), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
 		return err
 	}
 	if !exist {
-		plogger.InsertLog(fmt.Sprintf("WriteImmatureBlock:IsRoundNumber not exist. block.RoundHeight: %v, block.Nonce:%v", block.RoundHeight, block.Nonce), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
+		plogger.For(d.Config.Pool).InsertLog(fmt.Sprintf("WriteImmatureBlock:IsRoundNumber not exist. block.RoundHeight: %v, block.Nonce:%v", block.RoundHeight, block.Nonce), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
 		//return err
 	}
 
 	// Change the block to immaturedBlock.
 	err = d.writeImmatureBlock(block)
 	if err != nil {
-		plogger.InsertLog("writeImmatureBlock():Failed to change immatured block." + err.Error(), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
+		plogger.For(d.Config.Pool).InsertLog("writeImmatureBlock():Failed to change immatured block." + err.Error(), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
 		return err
 	}
 
 	// Write the reward in the DB. miner_info,credits
 	total, err := d.writeImmatureReward(block, roundRewards, percents)
 	if err != nil {
-		plogger.InsertLog("writeImmatureReward():Failed to enter immatured reward." + err.Error(), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
+		plogger.For(d.Config.Pool).InsertLog("writeImmatureReward():Failed to enter immatured reward." + err.Error(), plogger.LogTypePendingBlock, plogger.LogErrorNothingRoundBlock, block.RoundHeight, block.Height, "", "")
 		return err
 	}
 	// complete (finaces)
@@ -410,7 +561,7 @@ func (d *Database) writeImmatureReward(block *types.BlockData, roundRewards map[
 			insertCnt = 0
 
 			for _, logEntrie := range logEntries {
-				plogger.InsertLog(logEntrie.Entries, plogger.LogTypePendingBlock, plogger.LogErrorNothing, block.RoundHeight, block.Height, logEntrie.Addr, "")
+				plogger.For(d.Config.Pool).InsertLog(logEntrie.Entries, plogger.LogTypePendingBlock, plogger.LogErrorNothing, block.RoundHeight, block.Height, logEntrie.Addr, "")
 			}
 
 		}
@@ -425,7 +576,7 @@ func (d *Database) writeImmatureReward(block *types.BlockData, roundRewards map[
 		}
 		insertCnt = 0
 		for _, logEntrie := range logEntries {
-			plogger.InsertLog(logEntrie.Entries, plogger.LogTypePendingBlock, plogger.LogErrorNothing, block.RoundHeight, block.Height, logEntrie.Addr, "")
+			plogger.For(d.Config.Pool).InsertLog(logEntrie.Entries, plogger.LogTypePendingBlock, plogger.LogErrorNothing, block.RoundHeight, block.Height, logEntrie.Addr, "")
 		}
 	}
 	return total, nil
@@ -440,8 +591,8 @@ func (d *Database) writeImmatureBlock(block *types.BlockData) error {
 	}
 	defer tx.Rollback()
 	ret, err := tx.Exec(
-		"UPDATE blocks SET `state`=?,`height`=?,`uncle_height`=?,`orphan`=?,`hash`=?,`timestamp`=?,`reward`=? WHERE state=0 AND round_height=? AND nonce=? AND coin=?",
-		constImmatureBlock, block.Height,block.UncleHeight, block.Orphan, block.SerializeHash(), block.Timestamp, block.Reward.String(), block.RoundHeight, block.Nonce, d.Config.Coin)
+		"UPDATE blocks SET `state`=?,`height`=?,`uncle_height`=?,`orphan`=?,`hash`=?,`timestamp`=?,`reward`=?,`finder_bonus`=? WHERE state=0 AND round_height=? AND nonce=? AND coin=?",
+		constImmatureBlock, block.Height,block.UncleHeight, block.Orphan, block.SerializeHash(), block.Timestamp, block.Reward.String(), block.FinderBonus, block.RoundHeight, block.Nonce, d.Config.Coin)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -494,7 +645,7 @@ func (d *Database) insertImmaturedBlock(minerRewardSql string, creditsRewardSql
 func (d *Database) GetImmatureBlocks(maxHeight int64) ([]*types.BlockData, error) {
 	conn := d.Conn
 
-	rows, err := conn.Query("SELECT state,round_height,height,uncle_height,orphan,nonce,hash,`timestamp`,round_diff,total_share,reward FROM blocks WHERE state in (?,?) AND round_height < ? AND coin=?",constImmatureBlock, constPeddingImmaturedBlock, maxHeight, d.Config.Coin)
+	rows, err := conn.Query("SELECT state,round_height,height,uncle_height,orphan,nonce,hash,`timestamp`,round_diff,total_share,reward,finder,finder_worker,finder_bonus FROM blocks WHERE state in (?,?) AND round_height < ? AND coin=?",constImmatureBlock, constPeddingImmaturedBlock, maxHeight, d.Config.Coin)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -510,21 +661,36 @@ func (d *Database) GetImmatureBlocks(maxHeight int64) ([]*types.BlockData, error
 			timestamp                  		int64
 			orphan 							string
 			reward				string
+			finder, finderWorker		string
+			finderBonus			int64
 		)
 
-		err := rows.Scan(&state, &roundHeight, &height, &uncleHeight, &orphan, &nonce, &hash, &timestamp, &roundDiff, &totalShare, &reward)
+		err := rows.Scan(&state, &roundHeight, &height, &uncleHeight, &orphan, &nonce, &hash, &timestamp, &roundDiff, &totalShare, &reward, &finder, &finderWorker, &finderBonus)
 		if err != nil {
 			log.Printf("mysql GetImmatureBlocks:rows.Scan() error: %v",err)
 			return nil, err
 		}
 
 		block := d.convertBlockResults(state, height, roundHeight, uncleHeight, orphan, nonce, hash, timestamp, roundDiff, totalShare, reward)
+		block.Finder, block.FinderWorker, block.FinderBonus = finder, finderWorker, finderBonus
 		result = append(result, &block)
 	}
 
 	return result, nil
 }
 
+// CountMaturing counts the candidates and immature blocks that become due for unlocking after the
+// head moved from prevHeight to height, as GetCandidates and GetImmatureBlocks select them.
+func (d *Database) CountMaturing(prevHeight, height, immatureDepth, depth int64) (int64, error) {
+	var count int64
+	err := d.Conn.QueryRow("SELECT count(*) FROM blocks WHERE coin=? AND ("+
+		"(state=? AND round_height >= ? AND round_height < ?) OR (state in (?,?) AND round_height >= ? AND round_height < ?))",
+		d.Config.Coin,
+		constCandidatesBlock, prevHeight-immatureDepth, height-immatureDepth,
+		constImmatureBlock, constPeddingImmaturedBlock, prevHeight-depth, height-depth).Scan(&count)
+	return count, err
+}
+
 
 func (d *Database) writeOrphans(block *types.BlockData) error {
 	conn := d.Conn
@@ -626,6 +792,96 @@ func (d *Database) WriteOrphan(block *types.BlockData) error {
 	return nil
 }
 
+// IsBlockHashCredited reports whether another block row already resolved to the same hash
+// in a later state than the given block (immature or matured for candidates, matured otherwise).
+func (d *Database) IsBlockHashCredited(block *types.BlockData, hash string) (bool, error) {
+	conn := d.Conn
+
+	immatureState := constImmatureBlock
+	if block.State != constCandidatesBlock {
+		immatureState = constMatureBlock
+	}
+
+	var count int64
+	err := conn.QueryRow("SELECT count(*) FROM blocks WHERE coin=? AND hash=? AND state in (?,?) AND NOT (round_height=? AND nonce=?)",
+		d.Config.Coin, hash, immatureState, constMatureBlock, block.RoundHeight, block.Nonce).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// IncrUnlockRetry counts a skipped unlock attempt of the block and returns the new count.
+func (d *Database) IncrUnlockRetry(block *types.BlockData) (int, error) {
+	conn := d.Conn
+
+	_, err := conn.Exec("UPDATE blocks SET unlock_retry=unlock_retry+1 WHERE state=? AND round_height=? AND nonce=? AND coin=?",
+		block.State, block.RoundHeight, block.Nonce, d.Config.Coin)
+	if err != nil {
+		return 0, err
+	}
+
+	var retries int
+	err = conn.QueryRow("SELECT unlock_retry FROM blocks WHERE state=? AND round_height=? AND nonce=? AND coin=? LIMIT 1",
+		block.State, block.RoundHeight, block.Nonce, d.Config.Coin).Scan(&retries)
+	if err != nil {
+		return 0, err
+	}
+	return retries, nil
+}
+
+// IncrOrphanCheck counts an unlock pass that didn't find the block on the chain and returns the new count.
+func (d *Database) IncrOrphanCheck(block *types.BlockData) (int, error) {
+	conn := d.Conn
+
+	_, err := conn.Exec("UPDATE blocks SET orphan_checks=orphan_checks+1 WHERE state=? AND round_height=? AND nonce=? AND coin=?",
+		block.State, block.RoundHeight, block.Nonce, d.Config.Coin)
+	if err != nil {
+		return 0, err
+	}
+
+	var checks int
+	err = conn.QueryRow("SELECT orphan_checks FROM blocks WHERE state=? AND round_height=? AND nonce=? AND coin=? LIMIT 1",
+		block.State, block.RoundHeight, block.Nonce, d.Config.Coin).Scan(&checks)
+	if err != nil {
+		return 0, err
+	}
+	return checks, nil
+}
+
+// ResetOrphanChecks clears the misses of a block found on the chain again.
+func (d *Database) ResetOrphanChecks(block *types.BlockData) error {
+	conn := d.Conn
+
+	_, err := conn.Exec("UPDATE blocks SET orphan_checks=0 WHERE state=? AND round_height=? AND nonce=? AND coin=? AND orphan_checks>0",
+		block.State, block.RoundHeight, block.Nonce, d.Config.Coin)
+	return err
+}
+
+// WriteDuplicateBlock marks a candidate that resolved to an already credited block so it is never paid twice.
+// Immature credits written for the duplicate are reverted.
+func (d *Database) WriteDuplicateBlock(block *types.BlockData) error {
+	var immatureCredits []*types.CreditsImmatrue
+	if block.State == constImmatureBlock {
+		immatureCredits, _ = d.selectCreditsImmature(block.RoundHeight, block.Hash)
+	}
+
+	conn := d.Conn
+
+	_, err := conn.Exec("UPDATE blocks SET `state`=?,`hash`=? WHERE state=? AND round_height=? AND nonce=? AND coin=? LIMIT 1",
+		constDuplicateBlock, block.SerializeHash(), block.State, block.RoundHeight, block.Nonce, d.Config.Coin)
+	if err != nil {
+		return err
+	}
+
+	if len(immatureCredits) > 0 {
+		d.calcuCreditsImmature(block, immatureCredits, eDuplicateBlock)
+	}
+
+	return nil
+}
+
 func (d *Database) calcuCreditsImmature(block *types.BlockData, immatureCredits []*types.CreditsImmatrue, orphan ImmaturedState) {
 	conn := d.Conn
 
@@ -695,14 +951,15 @@ func (d *Database) calcuCreditsImmature(block *types.BlockData, immatureCredits
 		case eMaturedBlock: logSubType = plogger.LogSubTypeImmaturedBlock
 		case eOrphanBlock: logSubType = plogger.LogSubTypeOrphanBlcok
 		case eLostBlock: logSubType = plogger.LogSubTypeLostBlcok
+		case eDuplicateBlock: logSubType = plogger.LogSubTypeDuplicateBlock
 		}
 		for _, logEntrie := range logEntries {
-			plogger.InsertLog(logEntrie.Entries, plogger.LogTypeMaturedBlock, logSubType, block.RoundHeight, block.Height, logEntrie.Addr, "")
+			plogger.For(d.Config.Pool).InsertLog(logEntrie.Entries, plogger.LogTypeMaturedBlock, logSubType, block.RoundHeight, block.Height, logEntrie.Addr, "")
 		}
 	}
 }
 
-func (d *Database) makeMaturedBlcokSQL(block *types.BlockData,roundRewards map[string]int64, percents map[string]*big.Rat) (string, string, string){
+func (d *Database) makeMaturedBlcokSQL(block *types.BlockData,roundRewards map[string]int64, weis map[string]*big.Int, percents map[string]*big.Rat) (string, string, string){
 
 	var (
 		creditsBalanceSql strings.Builder
@@ -722,21 +979,33 @@ func (d *Database) makeMaturedBlcokSQL(block *types.BlockData,roundRewards map[s
 				per = val
 			}
 
+			wei := weis[login]
+			// The balance of a new miner, and the balance_wei credited in either case
+			balance := amount
+			if d.Config.WeiAccounting {
+				balance = new(big.Int).Quo(wei, util.Shannon).Int64()
+			}
+
 			if insertCnt == 0 {
 				creditsBalanceSql.Reset()
 				minerBalanceSql.Reset()
-				creditsBalanceSql.WriteString(fmt.Sprintf("INSERT INTO credits_balance(coin, round_height, height, hash, login_addr, amount, percent, `timestamp`) VALUES " +
-					"(\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\")", d.Config.Coin, block.RoundHeight, block.Height, block.Hash, login, strconv.FormatInt(amount, 10), per.FloatString(9), block.Timestamp))
-				minerBalanceSql.WriteString(fmt.Sprintf("INSERT INTO miner_info(coin, login_addr, balance) VALUES (\"%v\",\"%v\",\"%v\")",d.Config.Coin, login, strconv.FormatInt(amount, 10)))
+				creditsBalanceSql.WriteString(fmt.Sprintf("INSERT INTO credits_balance(coin, round_height, height, hash, login_addr, amount, amount_wei, percent, `timestamp`) VALUES " +
+					"(\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\")", d.Config.Coin, block.RoundHeight, block.Height, block.Hash, login, strconv.FormatInt(amount, 10), wei, per.FloatString(9), block.Timestamp))
+				minerBalanceSql.WriteString(fmt.Sprintf("INSERT INTO miner_info(coin, login_addr, balance, balance_wei) VALUES (\"%v\",\"%v\",\"%v\",\"%v\")",d.Config.Coin, login, strconv.FormatInt(balance, 10), wei))
 			} else {
-				creditsBalanceSql.WriteString(fmt.Sprintf(",(\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\")", d.Config.Coin, block.RoundHeight, block.Height, block.Hash, login, strconv.FormatInt(amount, 10), per.FloatString(9), block.Timestamp))
-				minerBalanceSql.WriteString(fmt.Sprintf(",(\"%v\",\"%v\",\"%v\")", d.Config.Coin, login, strconv.FormatInt(amount, 10)))
+				creditsBalanceSql.WriteString(fmt.Sprintf(",(\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\")", d.Config.Coin, block.RoundHeight, block.Height, block.Hash, login, strconv.FormatInt(amount, 10), wei, per.FloatString(9), block.Timestamp))
+				minerBalanceSql.WriteString(fmt.Sprintf(",(\"%v\",\"%v\",\"%v\",\"%v\")", d.Config.Coin, login, strconv.FormatInt(balance, 10), wei))
 			}
 			insertCnt++
 		}
 
-		creditsBalanceSql.WriteString(" ON DUPLICATE KEY UPDATE insert_cnt=insert_cnt+1,amount=VALUES(amount)")
-		minerBalanceSql.WriteString(" ON DUPLICATE KEY UPDATE balance=balance+VALUES(balance)")
+		creditsBalanceSql.WriteString(" ON DUPLICATE KEY UPDATE insert_cnt=insert_cnt+1,amount=VALUES(amount),amount_wei=VALUES(amount_wei)")
+		if d.Config.WeiAccounting {
+			// The balance in Shannon follows the exact one. Assigned first, as MySQL assigns in order
+			minerBalanceSql.WriteString(" ON DUPLICATE KEY UPDATE balance=FLOOR((balance_wei+VALUES(balance_wei))/1000000000),balance_wei=balance_wei+VALUES(balance_wei)")
+		} else {
+			minerBalanceSql.WriteString(" ON DUPLICATE KEY UPDATE balance=balance+VALUES(balance),balance_wei=balance_wei+VALUES(balance_wei)")
+		}
 		financesSql = fmt.Sprintf("UPDATE finances SET balance=balance+%v,last_height=%v,last_hash=\"%v\",total_mined=total_mined+%v WHERE coin=\"%v\"",
 							total, strconv.FormatInt(block.Height, 10), block.Hash, block.RewardInShannon(), d.Config.Coin)
 	} else {
@@ -747,7 +1016,7 @@ func (d *Database) makeMaturedBlcokSQL(block *types.BlockData,roundRewards map[s
 	return creditsBalanceSql.String(), minerBalanceSql.String(), financesSql
 }
 
-func (d *Database) writeMaturedBlock(block *types.BlockData, creditsBalanceSql, minerBalanceSql, financesSql string) error {
+func (d *Database) writeMaturedBlock(block *types.BlockData, roundRewards map[string]int64, roundWei map[string]*big.Int, poolCredits []*PoolCredit, distribution *types.RoundDistribution, profit *BlockProfit, remainder *big.Int, creditsBalanceSql, minerBalanceSql, financesSql string) error {
 	conn := d.Conn
 
 	txRound, err := conn.Begin()
@@ -766,6 +1035,18 @@ func (d *Database) writeMaturedBlock(block *types.BlockData, creditsBalanceSql,
 		return err
 	}
 
+	err = d.writeBlockLedgerEntries(txRound, block, roundRewards, roundWei, poolCredits)
+	if err != nil {
+		return err
+	}
+
+	if remainder != nil {
+		err = d.writeRewardRemainder(txRound, block.Height, remainder)
+		if err != nil {
+			return err
+		}
+	}
+
 	_, err = txRound.Exec(financesSql)
 	if err != nil {
 		return err
@@ -778,8 +1059,18 @@ func (d *Database) writeMaturedBlock(block *types.BlockData, creditsBalanceSql,
 	}
 
 	// blocksInfoSql = fmt.Sprintf("UPDATE blocks SET state=? WHERE state=? AND round_height=? AND nonce=?")
-	_, err = txRound.Exec("UPDATE blocks SET `state`=?,`height`=?,`uncle_height`=?,`orphan`=?,`hash`=?,`timestamp`=?,`diff`=?, `reward`=? WHERE state=? AND round_height=? AND nonce=? AND coin=?",
-		constMatureBlock, block.Height,	block.UncleHeight, block.Orphan, block.SerializeHash(), block.Timestamp, block.Difficulty, block.Reward.String(), block.State, block.RoundHeight, block.Nonce, d.Config.Coin)
+	_, err = txRound.Exec("UPDATE blocks SET `state`=?,`height`=?,`uncle_height`=?,`orphan`=?,`hash`=?,`timestamp`=?,`diff`=?, `reward`=?,`finder_bonus`=? WHERE state=? AND round_height=? AND nonce=? AND coin=?",
+		constMatureBlock, block.Height,	block.UncleHeight, block.Orphan, block.SerializeHash(), block.Timestamp, block.Difficulty, block.Reward.String(), block.FinderBonus, block.State, block.RoundHeight, block.Nonce, d.Config.Coin)
+	if err != nil {
+		return err
+	}
+
+	err = d.writeRoundDistribution(txRound, block, distribution)
+	if err != nil {
+		return err
+	}
+
+	err = d.writeBlockProfit(txRound, block, profit)
 	if err != nil {
 		return err
 	}
@@ -793,15 +1084,17 @@ func (d *Database) writeMaturedBlock(block *types.BlockData, creditsBalanceSql,
 }
 
 // WriteMaturedBlock If the reward miner is more than 20,000, you need to increase the query capacity or modify it!!
-func (d *Database) WriteMaturedBlock(block *types.BlockData, roundRewards map[string]int64, percents map[string]*big.Rat) error {
+// roundWei are the exact shares of the round for the ledger, remainder the round-off dust left for the
+// next block, nil unless the unlocker keeps it.
+func (d *Database) WriteMaturedBlock(block *types.BlockData, roundRewards map[string]int64, roundWei map[string]*big.Int, percents map[string]*big.Rat, poolCredits []*PoolCredit, distribution *types.RoundDistribution, profit *BlockProfit, remainder *big.Int) error {
 	start := time.Now()
 	immatureCredits, _:= d.selectCreditsImmature(block.RoundHeight, block.Hash)
 
 	// Let's write a query for the contents to be saved in advance.
-	creditsBalanceSql, minerBalanceSql, financesSql := d.makeMaturedBlcokSQL(block, roundRewards, percents)
+	creditsBalanceSql, minerBalanceSql, financesSql := d.makeMaturedBlcokSQL(block, roundRewards, d.creditedWei(roundRewards, roundWei, poolCredits), percents)
 
 	// commit to db
-	err := d.writeMaturedBlock(block, creditsBalanceSql, minerBalanceSql, financesSql)
+	err := d.writeMaturedBlock(block, roundRewards, roundWei, poolCredits, distribution, profit, remainder, creditsBalanceSql, minerBalanceSql, financesSql)
 	if err != nil {
 		return err
 	}
@@ -816,7 +1109,7 @@ func (d *Database) WriteMaturedBlock(block *types.BlockData, roundRewards map[st
 
 func (d *Database) CollectStats(maxBlocks int64) ([]*types.BlockData, []*types.BlockData, []*types.BlockData, int, []map[string]interface{}, int64, error) {
 	conn := d.Conn
-	rows, err := conn.Query("SELECT state,round_height,height,uncle_height,orphan,nonce,hash,`timestamp`,round_diff,total_share,reward FROM blocks WHERE state in (?,?) AND coin=? ORDER BY height DESC", constCandidatesBlock, constImmatureBlock, d.Config.Coin)
+	rows, err := conn.Query("SELECT state,round_height,height,uncle_height,orphan,nonce,hash,`timestamp`,round_diff,total_share,reward,finder,finder_worker,finder_bonus FROM blocks WHERE state in (?,?) AND coin=? ORDER BY height DESC", constCandidatesBlock, constImmatureBlock, d.Config.Coin)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -838,15 +1131,18 @@ func (d *Database) CollectStats(maxBlocks int64) ([]*types.BlockData, []*types.B
 			timestamp                        int64
 			orphan                           string
 			reward                           string
+			finder, finderWorker             string
+			finderBonus                      int64
 		)
 
-		err := rows.Scan(&state, &roundHeight, &height, &uncleHeight, &orphan, &nonce, &hash, &timestamp, &roundDiff, &totalShare, &reward)
+		err := rows.Scan(&state, &roundHeight, &height, &uncleHeight, &orphan, &nonce, &hash, &timestamp, &roundDiff, &totalShare, &reward, &finder, &finderWorker, &finderBonus)
 		if err != nil {
 			log.Printf("mysql CollectStats:rows.Scan() error: %v",err)
 			return nil, nil, nil, 0, nil, 0, err
 		}
 
 		block := d.convertBlockResults(state, height, roundHeight, uncleHeight, orphan, nonce, hash, timestamp, roundDiff, totalShare, reward)
+		block.Finder, block.FinderWorker, block.FinderBonus = finder, finderWorker, finderBonus
 		if block.State == constCandidatesBlock {
 			resultCandidates = append(resultCandidates, &block)
 		} else {
@@ -854,7 +1150,7 @@ func (d *Database) CollectStats(maxBlocks int64) ([]*types.BlockData, []*types.B
 		}
 	}
 
-	rows2, err := conn.Query("SELECT state,round_height,height,uncle_height,orphan,nonce,hash,`timestamp`,round_diff,total_share,reward FROM blocks WHERE coin=? AND state=? ORDER BY height DESC LIMIT ?", d.Config.Coin, constMatureBlock, maxBlocks)
+	rows2, err := conn.Query("SELECT state,round_height,height,uncle_height,orphan,nonce,hash,`timestamp`,round_diff,total_share,reward,finder,finder_worker,finder_bonus FROM blocks WHERE coin=? AND state=? ORDER BY height DESC LIMIT ?", d.Config.Coin, constMatureBlock, maxBlocks)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -869,15 +1165,18 @@ func (d *Database) CollectStats(maxBlocks int64) ([]*types.BlockData, []*types.B
 			timestamp                        int64
 			orphan                           string
 			reward                           string
+			finder, finderWorker             string
+			finderBonus                      int64
 		)
 
-		err := rows2.Scan(&state, &roundHeight, &height, &uncleHeight, &orphan, &nonce, &hash, &timestamp, &roundDiff, &totalShare, &reward)
+		err := rows2.Scan(&state, &roundHeight, &height, &uncleHeight, &orphan, &nonce, &hash, &timestamp, &roundDiff, &totalShare, &reward, &finder, &finderWorker, &finderBonus)
 		if err != nil {
 			log.Printf("mysql CollectStats:rows2.Scan() error: %v", err)
 			return nil, nil, nil, 0, nil, 0, err
 		}
 
 		block := d.convertBlockResults(state, height, roundHeight, uncleHeight, orphan, nonce, hash, timestamp, roundDiff, totalShare, reward)
+		block.Finder, block.FinderWorker, block.FinderBonus = finder, finderWorker, finderBonus
 		resultMatured = append(resultMatured, &block)
 	}
 
@@ -980,9 +1279,10 @@ func (d *Database) convertBlockResults(state int, height int64, roundHeight int6
 }
 
 
-func (d *Database) GetPayees(max string) ([]*Payees, error) {
+// GetPayees returns unlocked miners with at least min balance, the payer checks each miner's own threshold.
+func (d *Database) GetPayees(min string) ([]*Payees, error) {
 	conn := d.Conn
-	rows, err := conn.Query("SELECT coin,login_addr, balance, payout_limit FROM miner_info WHERE ((payout_limit = 0 AND balance > ?) or (payout_limit > 0 AND balance > payout_limit) ) AND coin=? AND payout_lock = 0", max, d.Config.Coin)
+	rows, err := conn.Query("SELECT coin,login_addr, balance, balance_wei, payout_limit, IFNULL(payout_memo,'') FROM miner_info WHERE balance >= ? AND coin=? AND payout_lock = 0", min, d.Config.Coin)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -994,20 +1294,28 @@ func (d *Database) GetPayees(max string) ([]*Payees, error) {
 			coin string
 			loginAddr string
 			balance     int64
+			balanceWei  string
 			payoutLimit int64
+			memo        string
 		)
 
-		err := rows.Scan(&coin, &loginAddr, &balance, &payoutLimit)
+		err := rows.Scan(&coin, &loginAddr, &balance, &balanceWei, &payoutLimit, &memo)
 		if err != nil {
 			log.Printf("mysql GetPayees:rows.Scan() error: %v",err)
 			return nil, err
 		}
+		wei, ok := new(big.Int).SetString(balanceWei, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid balance_wei %q of %v", balanceWei, loginAddr)
+		}
 
 		result = append(result, &Payees{
 			Coin: 		  coin,
 			Addr:         loginAddr,
 			Balance:      balance,
+			BalanceWei:   wei,
 			Payout_limit: payoutLimit,
+			Memo:         memo,
 		})
 	}
 
@@ -1015,7 +1323,8 @@ func (d *Database) GetPayees(max string) ([]*Payees, error) {
 }
 
 // UpdateBalance Confirm the reward coin with the miner's wallet address.
-func (d *Database) UpdateBalance(login string, amount int64, gasFee int64, coin string) (int, error) {
+// amountWei is the exact value of the payment, amount rounded down to Shannon.
+func (d *Database) UpdateBalance(login string, amount int64, amountWei *big.Int, gasFee int64, coin string) (int, error) {
 	conn := d.Conn
 
 	ts := util.MakeTimestamp()
@@ -1025,9 +1334,18 @@ func (d *Database) UpdateBalance(login string, amount int64, gasFee int64, coin
 		log.Fatal(err)
 	}
 	defer tx.Rollback()
-	ret, err := tx.Exec(
-		"UPDATE miner_info SET payout_lock=?,balance=balance-?,pending=pending+? WHERE coin=? AND login_addr=? AND payout_lock = 0",
-		ts, amount + gasFee, amount, coin, login)	// gasFee is also removed.
+	debitWei := new(big.Int).Add(amountWei, shannonToWei(gasFee)).String()
+	var ret sql.Result
+	if d.Config.WeiAccounting {
+		// The balance in Shannon follows the exact one. Assigned first, as MySQL assigns in order
+		ret, err = tx.Exec(
+			"UPDATE miner_info SET payout_lock=?,balance=FLOOR((balance_wei-"+weiParam+")/1000000000),balance_wei=balance_wei-"+weiParam+",pending=pending+? WHERE coin=? AND login_addr=? AND payout_lock = 0",
+			ts, debitWei, debitWei, amount, coin, login)
+	} else {
+		ret, err = tx.Exec(
+			"UPDATE miner_info SET payout_lock=?,balance=balance-?,balance_wei=balance_wei-"+weiParam+",pending=pending+? WHERE coin=? AND login_addr=? AND payout_lock = 0",
+			ts, amount + gasFee, debitWei, amount, coin, login)	// gasFee is also removed.
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1055,7 +1373,8 @@ func (d *Database) UpdateBalance(login string, amount int64, gasFee int64, coin
 	return 0, nil
 }
 
-func (d *Database) WritePayment(login, txHash string, amount int64,gasFee int64, coin string, from string) error {
+// WritePayment logs a sent payment. rate is the coin price in currency at payout time, empty if unknown.
+func (d *Database) WritePayment(login, txHash string, amount int64,gasFee int64, coin string, from string, rate string, currency string) error {
 	nowTime := util.MakeTimestamp() / 1000
 	conn := d.Conn
 
@@ -1077,11 +1396,22 @@ func (d *Database) WritePayment(login, txHash string, amount int64,gasFee int64,
 		log.Fatal(err)
 	}
 	_, err = tx.Exec(
-		"INSERT INTO payments_all(login_addr,`from`,tx_hash,amount,tx_fee,`timestamp`,coin) VALUE (?,?,?,?,?,?,?)",
-		login, from, txHash, amount, gasFee, nowTime, d.Config.Coin)
+		"INSERT INTO payments_all(login_addr,`from`,tx_hash,amount,tx_fee,`timestamp`,coin,`state`,rate,rate_currency) VALUE (?,?,?,?,?,?,?,?,?,?)",
+		login, from, txHash, amount, gasFee, nowTime, d.Config.Coin, PaymentPending,
+		sql.NullString{String: rate, Valid: len(rate) > 0}, sql.NullString{String: currency, Valid: len(rate) > 0})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = d.writeLedgerEntry(tx, LedgerDebit, ReasonPayout, login, -amount, txHash, 0, nowTime)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if gasFee > 0 {
+		err = d.writeLedgerEntry(tx, LedgerFee, ReasonPayoutGas, login, -gasFee, txHash, 0, nowTime)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	// defer stmt.Close() // danger!
 
 	rowsAffected, err := ret.RowsAffected()
@@ -1097,6 +1427,347 @@ func (d *Database) WritePayment(login, txHash string, amount int64,gasFee int64,
 	return nil
 }
 
+// UpdatePaymentState Set the state of a payment once its transaction receipt is known.
+func (d *Database) UpdatePaymentState(txHash string, state int) error {
+	conn := d.Conn
+
+	_, err := conn.Exec("UPDATE payments_all SET `state`=? WHERE tx_hash=? AND coin=?", state, txHash, d.Config.Coin)
+	return err
+}
+
+// UpdatePaymentTxHash moves payments to the hash of a replacement tx.
+func (d *Database) UpdatePaymentTxHash(oldHash, newHash string) error {
+	conn := d.Conn
+
+	_, err := conn.Exec("UPDATE payments_all SET tx_hash=? WHERE tx_hash=? AND coin=?", newHash, oldHash, d.Config.Coin)
+	return err
+}
+
+// WritePayoutReport stores a payout report for audit.
+func (d *Database) WritePayoutReport(report *PayoutReport) (int64, error) {
+	conn := d.Conn
+
+	ret, err := conn.Exec(
+		"INSERT INTO payout_reports(coin,`state`,recipients,amount,tx_fee,pool_balance,post_balance,report,`timestamp`) VALUE (?,?,?,?,?,?,?,?,?)",
+		d.Config.Coin, report.State, report.Recipients, report.Amount, report.TxFee, report.PoolBalance, report.PostBalance,
+		report.Report, report.Timestamp)
+	if err != nil {
+		return 0, err
+	}
+	return ret.LastInsertId()
+}
+
+func (d *Database) GetPayoutReport(id int64) (*PayoutReport, error) {
+	conn := d.Conn
+
+	var (
+		report     PayoutReport
+		approvedBy sql.NullString
+	)
+	err := conn.QueryRow("SELECT id,`state`,recipients,amount,tx_fee,pool_balance,post_balance,report,approved_by,`timestamp` FROM payout_reports WHERE id=? AND coin=?",
+		id, d.Config.Coin).Scan(&report.Id, &report.State, &report.Recipients, &report.Amount, &report.TxFee,
+		&report.PoolBalance, &report.PostBalance, &report.Report, &approvedBy, &report.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	report.ApprovedBy = approvedBy.String
+	return &report, nil
+}
+
+// GetOpenPayoutReport returns the latest report still waiting for approval or execution, nil if there is none.
+func (d *Database) GetOpenPayoutReport() (*PayoutReport, error) {
+	conn := d.Conn
+
+	var id int64
+	err := conn.QueryRow("SELECT id FROM payout_reports WHERE `state` IN (?,?) AND coin=? ORDER BY id DESC LIMIT 1",
+		ReportPending, ReportApproved, d.Config.Coin).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.GetPayoutReport(id)
+}
+
+// GetPayoutReports returns the latest reports without their recipient lists.
+func (d *Database) GetPayoutReports(limit int) ([]*PayoutReport, error) {
+	conn := d.Conn
+
+	rows, err := conn.Query("SELECT id,`state`,recipients,amount,tx_fee,pool_balance,post_balance,approved_by,`timestamp` FROM payout_reports WHERE coin=? ORDER BY id DESC LIMIT ?",
+		d.Config.Coin, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*PayoutReport
+	for rows.Next() {
+		var (
+			report     PayoutReport
+			approvedBy sql.NullString
+		)
+		err := rows.Scan(&report.Id, &report.State, &report.Recipients, &report.Amount, &report.TxFee,
+			&report.PoolBalance, &report.PostBalance, &approvedBy, &report.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		report.ApprovedBy = approvedBy.String
+		result = append(result, &report)
+	}
+	return result, nil
+}
+
+// UpdatePayoutReportState moves a report from one state to another, false if it was not in that state.
+func (d *Database) UpdatePayoutReportState(id int64, from, to string, by string) (bool, error) {
+	conn := d.Conn
+
+	ret, err := conn.Exec("UPDATE payout_reports SET `state`=?,approved_by=IFNULL(?,approved_by) WHERE id=? AND `state`=? AND coin=?",
+		to, sql.NullString{String: by, Valid: len(by) > 0}, id, from, d.Config.Coin)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := ret.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// WriteCompensation stores a correction file with its items, the key of an imported file is rejected.
+func (d *Database) WriteCompensation(c *Compensation, items []*CompensationItem) (int64, error) {
+	conn := d.Conn
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ret, err := tx.Exec(
+		"INSERT INTO compensations(coin,`key`,kind,`state`,reason,miners,total,created_by,`timestamp`) VALUE (?,?,?,?,?,?,?,?,?)",
+		d.Config.Coin, c.Key, c.Kind, CompensationPending, c.Reason, len(items), c.Total, c.CreatedBy, c.Timestamp)
+	if err != nil {
+		return 0, err
+	}
+	id, err := ret.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range items {
+		_, err = tx.Exec("INSERT INTO compensation_items(coin,idem_key,compensation_id,login_addr,amount,`state`,`timestamp`) VALUE (?,?,?,?,?,?,?)",
+			d.Config.Coin, item.IdemKey, id, item.Login, item.Amount, CompensationItemPending, c.Timestamp)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return id, nil
+}
+
+func (d *Database) GetCompensation(id int64) (*Compensation, error) {
+	conn := d.Conn
+
+	var (
+		c                     Compensation
+		createdBy, approvedBy sql.NullString
+	)
+	err := conn.QueryRow("SELECT id,`key`,kind,`state`,reason,miners,total,applied,created_by,approved_by,`timestamp` FROM compensations WHERE id=? AND coin=?",
+		id, d.Config.Coin).Scan(&c.Id, &c.Key, &c.Kind, &c.State, &c.Reason, &c.Miners, &c.Total, &c.Applied, &createdBy, &approvedBy, &c.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	c.CreatedBy = createdBy.String
+	c.ApprovedBy = approvedBy.String
+	return &c, nil
+}
+
+// GetCompensations returns the latest compensations, or the ones in state if it is set.
+func (d *Database) GetCompensations(state string, limit int) ([]*Compensation, error) {
+	conn := d.Conn
+
+	rows, err := conn.Query("SELECT id FROM compensations WHERE coin=? AND (?='' OR `state`=?) ORDER BY id DESC LIMIT ?",
+		d.Config.Coin, state, state, limit)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	var result []*Compensation
+	for _, id := range ids {
+		c, err := d.GetCompensation(id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+// GetCompensationItems returns the items of a compensation, only pending ones if pending is set.
+func (d *Database) GetCompensationItems(id int64, pending bool) ([]*CompensationItem, error) {
+	conn := d.Conn
+
+	rows, err := conn.Query("SELECT idem_key,login_addr,amount,`state`,`timestamp` FROM compensation_items WHERE compensation_id=? AND coin=? AND (?=0 OR `state`=?) ORDER BY login_addr",
+		id, d.Config.Coin, pending, CompensationItemPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*CompensationItem
+	for rows.Next() {
+		var item CompensationItem
+		err := rows.Scan(&item.IdemKey, &item.Login, &item.Amount, &item.State, &item.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &item)
+	}
+	return result, nil
+}
+
+// UpdateCompensationState moves a compensation from one state to another, false if it was not in that state.
+func (d *Database) UpdateCompensationState(id int64, from, to string, by string) (bool, error) {
+	conn := d.Conn
+
+	ret, err := conn.Exec("UPDATE compensations SET `state`=?,approved_by=IFNULL(?,approved_by) WHERE id=? AND `state`=? AND coin=?",
+		to, sql.NullString{String: by, Valid: len(by) > 0}, id, from, d.Config.Coin)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := ret.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// ApplyCompensationItems credits or debits the balances of one batch of items in a single transaction.
+// Items are claimed by their idempotency key, so a batch retried after a crash skips the applied ones.
+// A debit larger than the miner's balance is marked failed. Returns the items changed by this call.
+func (d *Database) ApplyCompensationItems(id int64, items []*CompensationItem) ([]*CompensationItem, error) {
+	conn := d.Conn
+	ts := util.MakeTimestamp() / 1000
+
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	var kind string
+	err = tx.QueryRow("SELECT kind FROM compensations WHERE id=? AND coin=?", id, d.Config.Coin).Scan(&kind)
+	if err != nil {
+		return nil, err
+	}
+	reason := ReasonAdjustment
+	switch kind {
+	case CompensationRefund:
+		reason = ReasonRefund
+	case CompensationCredit:
+		reason = ReasonCompensation
+	}
+
+	var (
+		result []*CompensationItem
+		total  int64
+	)
+	for _, item := range items {
+		ret, err := tx.Exec("UPDATE compensation_items SET `state`=?,`timestamp`=? WHERE coin=? AND idem_key=? AND `state`=?",
+			CompensationItemApplied, ts, d.Config.Coin, item.IdemKey, CompensationItemPending)
+		if err != nil {
+			return nil, err
+		}
+		if rowsAffected, _ := ret.RowsAffected(); rowsAffected <= 0 {
+			// Applied before
+			continue
+		}
+
+		state := CompensationItemApplied
+		if item.Amount >= 0 {
+			_, err = tx.Exec("INSERT INTO miner_info(coin, login_addr, balance, balance_wei) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE balance=balance+VALUES(balance),balance_wei=balance_wei+VALUES(balance_wei)",
+				d.Config.Coin, item.Login, item.Amount, shannonToWei(item.Amount).String())
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			ret, err = tx.Exec("UPDATE miner_info SET balance=balance+?,balance_wei=balance_wei+"+weiParam+" WHERE coin=? AND login_addr=? AND balance >= ?",
+				item.Amount, shannonToWei(item.Amount).String(), d.Config.Coin, item.Login, -item.Amount)
+			if err != nil {
+				return nil, err
+			}
+			if rowsAffected, _ := ret.RowsAffected(); rowsAffected <= 0 {
+				state = CompensationItemFailed
+				_, err = tx.Exec("UPDATE compensation_items SET `state`=? WHERE coin=? AND idem_key=?", state, d.Config.Coin, item.IdemKey)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if state == CompensationItemApplied {
+			total += item.Amount
+			err = d.writeLedgerEntry(tx, LedgerCompensation, reason, item.Login, item.Amount, item.IdemKey, 0, ts)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, &CompensationItem{IdemKey: item.IdemKey, Login: item.Login, Amount: item.Amount, State: state, Timestamp: ts})
+	}
+
+	_, err = tx.Exec("UPDATE finances SET balance=balance+? WHERE coin=?", total, d.Config.Coin)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE compensations SET applied=applied+? WHERE id=? AND coin=?", total, id, d.Config.Coin)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return result, nil
+}
+
+// getMinerCompensations lists the corrections applied to a miner's balance, with the reason given.
+func (d *Database) getMinerCompensations(login string, limit int64) ([]*CompensationItem, error) {
+	conn := d.Conn
+
+	rows, err := conn.Query("SELECT i.login_addr,i.amount,i.`state`,c.reason,i.`timestamp` FROM compensation_items i JOIN compensations c ON c.id=i.compensation_id "+
+		"WHERE i.coin=? AND i.login_addr=? AND i.`state`=? ORDER BY i.`timestamp` DESC LIMIT ?",
+		d.Config.Coin, login, CompensationItemApplied, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*CompensationItem
+	for rows.Next() {
+		var item CompensationItem
+		err := rows.Scan(&item.Login, &item.Amount, &item.State, &item.Reason, &item.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &item)
+	}
+	return result, nil
+}
+
 func (d *Database) GetAllMinerAccount(duration time.Duration, minerChartIntvSec int64) ([]*MinerChartSelect, error) {
 	ts := util.MakeTimestamp() / 1000 + minerChartIntvSec
 	now := time.Now()
@@ -1162,6 +1833,78 @@ func (d *Database) WriteMinerCharts(time1 int64, time2, k string, hash, largeHas
 	return nil
 }
 
+// WriteWorkerStats stores a rollup of login's workers at ts.
+func (d *Database) WriteWorkerStats(login string, ts int64, workers map[string]*types.WorkerStats) error {
+	if len(workers) == 0 {
+		return nil
+	}
+	conn := d.Conn
+	values := make([]string, 0, len(workers))
+	args := make([]interface{}, 0, len(workers)*10)
+	for _, w := range workers {
+		values = append(values, "(?,?,?,?,?,?,?,?,?,?,?)")
+		args = append(args, d.Config.Coin, login, w.Worker, ts, w.Hashrate, w.Valid, w.Stale, w.Invalid, w.Duplicate, w.LastSeen, w.Region)
+	}
+	_, err := conn.Exec("INSERT IGNORE INTO worker_stats(coin,login_addr,worker,`time`,hashrate,valid,stale,invalid,duplicate,last_seen,region) VALUES "+
+		strings.Join(values, ","), args...)
+	return err
+}
+
+// GetWorkerStatsSums sums the rollups of login's workers since from.
+func (d *Database) GetWorkerStatsSums(login string, from int64) (map[string]*types.WorkerStats, error) {
+	conn := d.Conn
+	rows, err := conn.Query("SELECT worker,SUM(valid),SUM(stale),SUM(invalid),SUM(duplicate),MAX(last_seen) FROM worker_stats "+
+		"WHERE coin=? AND login_addr=? AND `time` >= ? GROUP BY worker", d.Config.Coin, login, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]*types.WorkerStats)
+	for rows.Next() {
+		var w types.WorkerStats
+		err := rows.Scan(&w.Worker, &w.Valid, &w.Stale, &w.Invalid, &w.Duplicate, &w.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		result[w.Worker] = &w
+	}
+	return result, nil
+}
+
+// GetWorkerStatsHistory returns the rollups of one worker since from, newest first.
+func (d *Database) GetWorkerStatsHistory(login, worker string, from int64, limit int64) ([]*types.WorkerStats, error) {
+	conn := d.Conn
+	rows, err := conn.Query("SELECT worker,`time`,hashrate,valid,stale,invalid,duplicate,last_seen,region FROM worker_stats "+
+		"WHERE coin=? AND login_addr=? AND worker=? AND `time` >= ? ORDER BY `time` DESC LIMIT ?", d.Config.Coin, login, worker, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*types.WorkerStats
+	for rows.Next() {
+		var w types.WorkerStats
+		err := rows.Scan(&w.Worker, &w.Timestamp, &w.Hashrate, &w.Valid, &w.Stale, &w.Invalid, &w.Duplicate, &w.LastSeen, &w.Region)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &w)
+	}
+	return result, nil
+}
+
+// DeleteWorkerStats drops the rollups older than before.
+func (d *Database) DeleteWorkerStats(before int64) int64 {
+	conn := d.Conn
+	ret, err := conn.Exec("DELETE FROM worker_stats WHERE coin=? AND `time` < ?", d.Config.Coin, before)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n, _ := ret.RowsAffected()
+	return n
+}
+
 func (d *Database) GetMinerStats(login string, maxPayments int64) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
 	var (
@@ -1177,6 +1920,10 @@ func (d *Database) GetMinerStats(login string, maxPayments int64) (map[string]in
 		return nil, err
 	}
 	stats["paymentsTotal"] = paymentsTotal
+	stats["compensations"], err = d.getMinerCompensations(login, maxPayments)
+	if err != nil {
+		return nil, err
+	}
 
 	return stats, nil
 }
@@ -1222,6 +1969,15 @@ func (d *Database) getMinerInfo(login string) (map[string]interface{}, int64, er
 	return result, minerPaymentCnt, nil
 }
 
+// displayDBTime shows a UTC timestamp of MySQL in the display time zone.
+func displayDBTime(dbTimestamp string) string {
+	t, err := util.ParseDBTime(dbTimestamp)
+	if err != nil {
+		return dbTimestamp
+	}
+	return t.In(util.DisplayLocation()).Format("2006-01-02 15:04:05")
+}
+
 func (d *Database) getMinerPayments(login string, maxPayments int64) ([]map[string]interface{}, error) {
 	conn := d.Conn
 	rows, err := conn.Query("SELECT tx_hash, amount, tx_fee, `timestamp`, insert_time FROM payments_all WHERE coin=? AND login_addr=? ORDER BY seq DESC LIMIT ? ", d.Config.Coin, login, maxPayments)
@@ -1248,7 +2004,7 @@ func (d *Database) getMinerPayments(login string, maxPayments int64) ([]map[stri
 		//tx["address"] = login
 		//tx["amount"], _ = strconv.ParseInt(amount, 10, 64)
 		// timestamp := util.MakeTimestampDB2(insertTime) / 1000
-		d.convertStringMap(tx, "timeFormat", insertTime)
+		d.convertStringMap(tx, "timeFormat", displayDBTime(insertTime))
 		d.convertStringMap(tx, "timestamp", timestamp)
 		d.convertStringMap(tx, "x", timestamp)
 		d.convertStringMap(tx, "tx", txHash)
@@ -1286,7 +2042,7 @@ func (d *Database) GetAllPayments(maxPayments int64) ([]map[string]interface{},
 		//tx["tx"] = txHash
 		//tx["address"] = login
 		//tx["amount"], _ = strconv.ParseInt(amount, 10, 64)
-		d.convertStringMap(tx, "timeFormat", insertTime)
+		d.convertStringMap(tx, "timeFormat", displayDBTime(insertTime))
 		d.convertStringMap(tx, "timestamp", timestamp)
 		d.convertStringMap(tx, "x", timestamp)
 		d.convertStringMap(tx, "tx", txHash)
@@ -1887,6 +2643,60 @@ func (d *Database) UpdatePayoutLimit(login string,dgcValue string) bool {
 	return true
 }
 
+// UpdatePayoutMemo sets the memo passed along with the payouts of login, empty to clear it.
+func (d *Database) UpdatePayoutMemo(login string, memo string) bool {
+	conn := d.Conn
+	_,err := conn.Exec("UPDATE miner_info SET payout_memo=? WHERE coin=? AND login_addr=?", memo, d.Config.Coin, login)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return true
+}
+
+// GetPayoutMemo returns the payout memo of login.
+func (d *Database) GetPayoutMemo(login string) (string, error) {
+	conn := d.Conn
+	var memo string
+	err := conn.QueryRow("SELECT IFNULL(payout_memo,'') FROM miner_info WHERE coin=? AND login_addr=?", d.Config.Coin, login).Scan(&memo)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return memo, err
+}
+
+// UpdateNotifyEmail sets the address login's notifications are sent to, "" for none.
+func (d *Database) UpdateNotifyEmail(login string, email string) error {
+	conn := d.Conn
+	_, err := conn.Exec("UPDATE miner_info SET notify_email=? WHERE coin=? AND login_addr=?", email, d.Config.Coin, login)
+	return err
+}
+
+// MinerSettings are the settings a miner changes through the API.
+type MinerSettings struct {
+	// In Shannon, 0 for the pool's threshold
+	PayoutLimit int64  `json:"payoutLimit"`
+	PayoutMemo  string `json:"payoutMemo"`
+	NotifyEmail string `json:"notifyEmail"`
+	// Events emailed to NotifyEmail
+	Notify NotifyOptions `json:"notify"`
+}
+
+// GetMinerSettings returns the settings of login, nil if it isn't a miner.
+func (d *Database) GetMinerSettings(login string) (*MinerSettings, error) {
+	conn := d.Conn
+	var m MinerSettings
+	err := conn.QueryRow("SELECT IFNULL(payout_limit,0),IFNULL(payout_memo,''),IFNULL(notify_email,''),notify_payout,notify_offline,notify_hashrate FROM miner_info WHERE coin=? AND login_addr=?",
+		d.Config.Coin, login).Scan(&m.PayoutLimit, &m.PayoutMemo, &m.NotifyEmail, &m.Notify.Payout, &m.Notify.Offline, &m.Notify.Hashrate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
 func (d *Database) CreateAccount(user string,pass []byte, access string) bool {
 	conn := d.Conn
 	//The location (d.Config.Coin) does not need to be set.
@@ -1965,7 +2775,7 @@ func (d *Database) GetAccountPassword(id string) (string, string, error) {
 
 func (d *Database) GetAccountList() ([]*types.UserInfo, error) {
 	conn := d.Conn
-	rows, err := conn.Query("SELECT id,access FROM account")
+	rows, err := conn.Query("SELECT id,access,`role` FROM account")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1978,9 +2788,9 @@ func (d *Database) GetAccountList() ([]*types.UserInfo, error) {
 
 	for rows.Next() {
 		var (
-			id, access string
+			id, access, role string
 		)
-		err := rows.Scan(&id, &access)
+		err := rows.Scan(&id, &access, &role)
 		if err != nil {
 			log.Printf("mysql GetAccountPassword:rows.Scan() error: %v", err)
 			return nil, err
@@ -1989,6 +2799,7 @@ func (d *Database) GetAccountList() ([]*types.UserInfo, error) {
 		userInfo := &types.UserInfo{
 			Username: id,
 			Access:   access,
+			Role:     role,
 		}
 		result = append(result, userInfo)
 	}
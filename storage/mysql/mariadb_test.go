@@ -30,14 +30,22 @@ func TestMain(m *testing.M) {
 	os.Exit(c)
 }
 
+// needDB skips a test without the database of TestMain, its node is expected alongside.
+func needDB(t *testing.T) {
+	if db == nil {
+		t.Skip("MariaDB is not running on 127.0.0.1:3308")
+	}
+}
+
 var mainnetFlag = true
 var netId = int64(59003)
 
 func TestCreditsBlocksCheck(t *testing.T)  {
+	needDB(t)
 
 	Daemon := "http://127.0.0.1:8545"
 	Timeout := "10s"
-	rpc := rpc.NewRPCClient("BlockChecker", Daemon, Timeout, netId)
+	rpc := rpc.NewRPCClient("main", "BlockChecker", Daemon, Timeout, netId)
 
 	var (
 		countBlock int64
@@ -102,7 +110,7 @@ func TestCreditsBlocksCheck(t *testing.T)  {
 
 			uncleHeight, _ := strconv.ParseInt(strings.Replace(uncleBlock.Number, "0x", "", -1), 16, 64)
 			// Basic block creation reward
-			var createReward = types.GetUncleReward(uncleHeight , iHeight)
+			var createReward = types.GetUncleReward(uncleHeight, iHeight, mainnetFlag)
 
 			dbReward, boo := new(big.Int).SetString(reward, 10)
 			if !boo {
@@ -159,10 +167,11 @@ func TestCreditsBlocksCheck(t *testing.T)  {
 
 
 func TestPayoutTxCheck(t *testing.T)  {
+	needDB(t)
 
 	Daemon := "http://127.0.0.1:8545"
 	Timeout := "10s"
-	rpc := rpc.NewRPCClient("BlockChecker", Daemon, Timeout, netId)
+	rpc := rpc.NewRPCClient("main", "BlockChecker", Daemon, Timeout, netId)
 
 	var (
 		count int64
@@ -0,0 +1,254 @@
+package mysql
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/postgres"
+)
+
+// Schema migrations, applied in the order of the version their name starts with, like
+// 0002_worker_stats.sql. A migration is applied once and never changed afterwards: MySQL can't roll
+// DDL back, so one failing part way must be safe to run again.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type MigrationsConfig struct {
+	// Leave the schema to the operator. A database migrated by a newer binary is still refused
+	Disabled bool `json:"disabled"`
+	// Log the pending migrations instead of applying them
+	DryRun bool `json:"dryRun"`
+}
+
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// Held while migrating, so instances starting together apply each migration once
+const migrationLock = "open-dangnn-pool:migrations"
+
+// How long to wait for another instance migrating, a little over the 300s of MySQL's GET_LOCK.
+// PostgreSQL's advisory lock waits until it runs out.
+const migrationLockTimeout = 310 * time.Second
+
+// dialect holds what migrating differs in between the databases.
+type dialect struct {
+	name       string
+	migrations fs.FS
+	// Statements of the migrations as sent to the connection
+	native              func(string) string
+	lock                string
+	unlock              string
+	createSchemaVersion string
+	hasSchemaVersion    string
+}
+
+var mysqlDialect = &dialect{
+	name:       "MySQL",
+	migrations: migrationFiles,
+	native:     func(statement string) string { return statement },
+	lock:       "SELECT COALESCE(GET_LOCK(?, 300), 0)",
+	unlock:     "SELECT RELEASE_LOCK(?)",
+	createSchemaVersion: "CREATE TABLE IF NOT EXISTS `schema_version` (" +
+		"`version` INT(11) NOT NULL, " +
+		"`name` VARCHAR(100) NOT NULL, " +
+		"`applied_at` TIMESTAMP NOT NULL DEFAULT current_timestamp(), " +
+		"PRIMARY KEY (`version`)) COLLATE='utf8_general_ci' ENGINE=InnoDB",
+	hasSchemaVersion: "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'schema_version'",
+}
+
+// PostgreSQL waits on the advisory lock until the context times out.
+var postgresDialect = &dialect{
+	name:       "PostgreSQL",
+	migrations: postgres.Migrations,
+	native:     postgres.Native,
+	lock:       "SELECT 1 FROM (SELECT pg_advisory_lock(hashtext(?))) l",
+	unlock:     "SELECT pg_advisory_unlock(hashtext(?))",
+	createSchemaVersion: postgres.Native("CREATE TABLE IF NOT EXISTS schema_version (" +
+		"version INTEGER NOT NULL, " +
+		"name VARCHAR(100) NOT NULL, " +
+		"applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+		"PRIMARY KEY (version))"),
+	hasSchemaVersion: "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_version'",
+}
+
+func (d *Database) dialect() *dialect {
+	if d.Config.Driver == DriverPostgres {
+		return postgresDialect
+	}
+	return mysqlDialect
+}
+
+func loadMigrations(files fs.FS) ([]*migration, error) {
+	entries, err := fs.ReadDir(files, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]*migration, 0, len(entries))
+	versions := make(map[int]string)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %v has no version", entry.Name())
+		}
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("migrations %v and %v have the same version", other, name)
+		}
+		versions[version] = name
+		data, err := fs.ReadFile(files, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, &migration{version: version, name: name, statements: splitStatements(string(data))})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// splitStatements splits a migration into its statements, each ending a line with ";". Comment
+// lines are dropped.
+func splitStatements(sql string) []string {
+	var statements []string
+	var statement []string
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		statement = append(statement, strings.TrimRight(line, " \t\r"))
+		if strings.HasSuffix(trimmed, ";") {
+			s := strings.Join(statement, "\n")
+			statements = append(statements, strings.TrimSuffix(s, ";"))
+			statement = nil
+		}
+	}
+	if len(statement) > 0 {
+		statements = append(statements, strings.Join(statement, "\n"))
+	}
+	return statements
+}
+
+// pendingMigrations returns the migrations above the applied version. A database at a version the
+// binary doesn't know was migrated by a newer one, an older binary could corrupt it.
+func pendingMigrations(migrations []*migration, applied int) ([]*migration, error) {
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].version
+	}
+	if applied > latest {
+		return nil, fmt.Errorf("the schema is at version %v, newer than version %v of this binary", applied, latest)
+	}
+	var pending []*migration
+	for _, m := range migrations {
+		if m.version > applied {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// migrate brings the schema to the version of the binary.
+func (d *Database) migrate() error {
+	db := d.dialect()
+	migrations, err := loadMigrations(db.migrations)
+	if err != nil {
+		return err
+	}
+	cfg := d.Config.Migrations
+	apply := !cfg.Disabled && !cfg.DryRun
+
+	ctx := context.Background()
+	conn, err := d.Conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	lockCtx, cancel := context.WithTimeout(ctx, migrationLockTimeout)
+	var locked int
+	err = conn.QueryRowContext(lockCtx, db.lock, migrationLock).Scan(&locked)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to lock the schema: %v", err)
+	}
+	if locked != 1 {
+		return fmt.Errorf("timed out waiting for another instance to migrate the schema")
+	}
+	defer conn.ExecContext(ctx, db.unlock, migrationLock)
+
+	if apply {
+		if _, err := conn.ExecContext(ctx, db.createSchemaVersion); err != nil {
+			return fmt.Errorf("failed to create schema_version: %v", err)
+		}
+	}
+	var tables int
+	err = conn.QueryRowContext(ctx, db.hasSchemaVersion).Scan(&tables)
+	if err != nil {
+		return err
+	}
+	var applied int
+	if tables > 0 {
+		if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(`version`), 0) FROM `schema_version`").Scan(&applied); err != nil {
+			return err
+		}
+	}
+
+	pending, err := pendingMigrations(migrations, applied)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		log.Printf("%v schema is at version %v", db.name, applied)
+		return nil
+	}
+	if cfg.Disabled {
+		log.Printf("%v schema is at version %v, %v migrations are left to apply by hand", db.name, applied, len(pending))
+		return nil
+	}
+
+	logTable := d.Config.LogTableName
+	if len(logTable) == 0 {
+		logTable = "log"
+	}
+	for _, m := range pending {
+		if cfg.DryRun {
+			log.Printf("Dry run, migration %v would run:", m.name)
+		} else {
+			log.Printf("Applying migration %v", m.name)
+		}
+		for i, statement := range m.statements {
+			statement = strings.Replace(statement, "{{logTable}}", logTable, -1)
+			if cfg.DryRun {
+				log.Printf("%v;", statement)
+				continue
+			}
+			if _, err := conn.ExecContext(ctx, db.native(statement)); err != nil {
+				return fmt.Errorf("migration %v failed at statement %v: %v", m.name, i+1, err)
+			}
+		}
+		if cfg.DryRun {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, "INSERT INTO `schema_version` (`version`, `name`) VALUES (?, ?)", m.version, m.name); err != nil {
+			return fmt.Errorf("failed to record migration %v: %v", m.name, err)
+		}
+	}
+	if cfg.DryRun {
+		log.Printf("%v schema is at version %v, %v migrations weren't applied", db.name, applied, len(pending))
+	} else {
+		log.Printf("%v schema migrated from version %v to %v", db.name, applied, pending[len(pending)-1].version)
+	}
+	return nil
+}
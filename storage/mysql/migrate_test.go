@@ -0,0 +1,73 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements(`-- A comment
+CREATE TABLE a (
+    x INT
+);
+
+DROP TRIGGER IF EXISTS t;
+CREATE TRIGGER t BEFORE DELETE ON a FOR EACH ROW
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'no';
+`)
+	if len(statements) != 3 {
+		t.Fatalf("Expected 3 statements, got %v: %q", len(statements), statements)
+	}
+	if statements[0] != "CREATE TABLE a (\n    x INT\n)" {
+		t.Errorf("Unexpected statement %q", statements[0])
+	}
+	if !strings.HasSuffix(statements[2], "'no'") {
+		t.Errorf("Trigger body not kept: %q", statements[2])
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, db := range []*dialect{mysqlDialect, postgresDialect} {
+		migrations, err := loadMigrations(db.migrations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) == 0 || migrations[0].version != 1 {
+			t.Fatalf("Expected the initial %v migration first, got %v migrations", db.name, len(migrations))
+		}
+		for i, m := range migrations {
+			if i > 0 && m.version <= migrations[i-1].version {
+				t.Errorf("%v migration %v out of order", db.name, m.name)
+			}
+			for _, statement := range m.statements {
+				if strings.Contains(statement, "DELIMITER") {
+					t.Errorf("Migration %v uses DELIMITER, statements are sent one by one", m.name)
+				}
+				// Function bodies are split on their semicolons unless they're on one line.
+				if strings.Count(statement, "$$") == 1 {
+					t.Errorf("%v migration %v splits a function body: %q", db.name, m.name, statement)
+				}
+			}
+		}
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	migrations := []*migration{{version: 1, name: "0001_init"}, {version: 2, name: "0002_next"}}
+
+	pending, err := pendingMigrations(migrations, 0)
+	if err != nil || len(pending) != 2 {
+		t.Errorf("Expected both migrations on a new database, got %v, %v", len(pending), err)
+	}
+	pending, err = pendingMigrations(migrations, 1)
+	if err != nil || len(pending) != 1 || pending[0].version != 2 {
+		t.Errorf("Expected the second migration, got %v, %v", len(pending), err)
+	}
+	pending, err = pendingMigrations(migrations, 2)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("Expected nothing pending, got %v, %v", len(pending), err)
+	}
+	if _, err = pendingMigrations(migrations, 3); err == nil {
+		t.Error("Expected a database of a newer binary to be refused")
+	}
+}
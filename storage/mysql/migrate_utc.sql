@@ -0,0 +1,16 @@
+-- Moves the timestamps written before the pool's MySQL sessions ran in UTC to UTC. Run it once,
+-- with the pool stopped, after setting the time zones the pool hosts and the MySQL server ran in,
+-- e.g. '+09:00'. Nothing changes when both were UTC.
+SET @host_tz = '+00:00';
+SET @db_tz = '+00:00';
+SET time_zone = '+00:00';
+
+-- Sent as UTC and taken as server time
+UPDATE miner_info SET `last_share` = CONVERT_TZ(`last_share`, '+00:00', @db_tz) WHERE `last_share` IS NOT NULL;
+
+-- Sent as pool host time and taken as server time, `log` is the mysql.logTableName
+UPDATE `log` SET `insert_time` = CONVERT_TZ(`insert_time`, @host_tz, @db_tz);
+
+-- Strings of pool host time
+UPDATE blocks SET `insert_time` = LEFT(DATE_FORMAT(CONVERT_TZ(`insert_time`, @host_tz, '+00:00'), '%Y-%m-%d %H:%i:%s.%f'), 23)
+    WHERE `insert_time` IS NOT NULL AND `insert_time` <> '';
@@ -1,4 +1,7 @@
-CREATE TABLE `blocks` (
+-- The schema the pool shipped with before migrations, as storage/mysql/create.sql created it. Tables
+-- an existing database already has are left as they are. {{logTable}} is mysql.logTableName.
+
+CREATE TABLE IF NOT EXISTS `blocks` (
     `state` TINYINT(4) NULL DEFAULT NULL,
     `coin` VARCHAR(20) NULL DEFAULT '' COLLATE 'utf8_general_ci',
     `round_height` BIGINT(20) NULL DEFAULT NULL,
@@ -25,7 +28,7 @@ COLLATE='utf8_general_ci'
 ENGINE=InnoDB;
 
 
-CREATE TABLE `credits_balance` (
+CREATE TABLE IF NOT EXISTS `credits_balance` (
     `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
     `round_height` BIGINT(20) NOT NULL,
     `height` BIGINT(20) NOT NULL,
@@ -43,7 +46,7 @@ COLLATE='utf8_general_ci'
 ENGINE=InnoDB;
 
 
-CREATE TABLE `credits_blocks` (
+CREATE TABLE IF NOT EXISTS `credits_blocks` (
     `height` BIGINT(20) NOT NULL DEFAULT '0',
     `hash` VARCHAR(68) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
     `coin` VARCHAR(20) NULL DEFAULT NULL COLLATE 'utf8_general_ci',
@@ -55,7 +58,7 @@ CREATE TABLE `credits_blocks` (
 COLLATE='utf8_general_ci'
 ENGINE=InnoDB;
 
-CREATE TABLE `credits_immature` (
+CREATE TABLE IF NOT EXISTS `credits_immature` (
     `coin` VARCHAR(20) NULL DEFAULT NULL COLLATE 'utf8_general_ci',
     `round_height` BIGINT(20) NOT NULL,
     `height` BIGINT(20) NOT NULL,
@@ -71,7 +74,7 @@ COLLATE='utf8_general_ci'
 ENGINE=InnoDB;
 
 
-CREATE TABLE `finances` (
+CREATE TABLE IF NOT EXISTS `finances` (
     `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
     `immature` BIGINT(20) NULL DEFAULT '0',
     `pending` BIGINT(20) NULL DEFAULT '0',
@@ -87,7 +90,7 @@ CREATE TABLE `finances` (
 COLLATE='utf8_general_ci'
 ENGINE=InnoDB;
 
-CREATE TABLE `miner_charts` (
+CREATE TABLE IF NOT EXISTS `miner_charts` (
     `login_addr` VARCHAR(68) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
     `time` BIGINT(20) NOT NULL DEFAULT '0',
     `time2` TIMESTAMP NULL DEFAULT NULL,
@@ -102,7 +105,7 @@ CREATE TABLE `miner_charts` (
 COLLATE='utf8_general_ci'
 ENGINE=InnoDB;
 
-CREATE TABLE `miner_info` (
+CREATE TABLE IF NOT EXISTS `miner_info` (
     `coin` VARCHAR(20) NOT NULL COLLATE 'utf8_general_ci',
     `login_addr` VARCHAR(50) NOT NULL COLLATE 'utf8_general_ci',
     `balance` BIGINT(20) NULL DEFAULT '0',
@@ -130,7 +133,7 @@ COLLATE='utf8_general_ci'
 ENGINE=InnoDB;
 
 
-CREATE TABLE `payments_all` (
+CREATE TABLE IF NOT EXISTS `payments_all` (
     `seq` BIGINT(20) NOT NULL AUTO_INCREMENT,
     `login_addr` VARCHAR(68) NOT NULL DEFAULT '0x0' COLLATE 'utf8_general_ci',
     `from` VARCHAR(68) NOT NULL DEFAULT '0x0' COLLATE 'utf8_general_ci',
@@ -148,7 +151,7 @@ ENGINE=InnoDB
 AUTO_INCREMENT=1;
 
 
-CREATE TABLE `log` (
+CREATE TABLE IF NOT EXISTS `{{logTable}}` (
     `id` BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
     `msg_type` INT(10) UNSIGNED NOT NULL DEFAULT '0',
     `msg_err` INT(11) NULL DEFAULT NULL,
@@ -167,7 +170,7 @@ ENGINE=InnoDB
 AUTO_INCREMENT=1;
 
 
-CREATE TABLE `miner_sub` (
+CREATE TABLE IF NOT EXISTS `miner_sub` (
     `coin` varchar(30) NOT NULL,
     `login_addr` varchar(68) NOT NULL DEFAULT '',
     `sub_addr` varchar(68) NOT NULL DEFAULT '',
@@ -176,7 +179,7 @@ CREATE TABLE `miner_sub` (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
 
 
-CREATE TABLE `inbound_id` (
+CREATE TABLE IF NOT EXISTS `inbound_id` (
     `coin` varchar(20) NOT NULL,
     `id` varchar(68) NOT NULL,
     `rule` varchar(20) DEFAULT NULL,
@@ -185,7 +188,7 @@ CREATE TABLE `inbound_id` (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
 
 
-CREATE TABLE `inbound_ip` (
+CREATE TABLE IF NOT EXISTS `inbound_ip` (
     `coin` varchar(20) NOT NULL,
     `ip` varchar(50) NOT NULL,
     `rule` varchar(20) DEFAULT NULL,
@@ -193,14 +196,14 @@ CREATE TABLE `inbound_ip` (
     PRIMARY KEY (`coin`,`ip`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
 
-CREATE TABLE `ban_whitelist` (
+CREATE TABLE IF NOT EXISTS `ban_whitelist` (
     `coin` VARCHAR(20) NULL DEFAULT NULL COLLATE 'utf8_general_ci',
     `ip_addr` VARCHAR(50) NULL DEFAULT NULL COLLATE 'utf8_general_ci'
 )
 COLLATE='utf8_general_ci'
 ENGINE=InnoDB;
 
-CREATE TABLE `account` (
+CREATE TABLE IF NOT EXISTS `account` (
     `id` varchar(30) NOT NULL DEFAULT '',
     `password` varchar(255) DEFAULT NULL,
     `access` varchar(200) DEFAULT '',
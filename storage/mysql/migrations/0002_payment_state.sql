@@ -0,0 +1,4 @@
+-- Payout tx state of each payment, and lookups of a payment by its tx
+ALTER TABLE `payments_all`
+    ADD COLUMN `state` TINYINT(4) NOT NULL DEFAULT '0' AFTER `timestamp`,
+    ADD INDEX `tx_hash` (`tx_hash`) USING BTREE;
@@ -0,0 +1,2 @@
+-- Unlock passes a candidate timed out in
+ALTER TABLE `blocks` ADD COLUMN `unlock_retry` INT(11) NOT NULL DEFAULT '0' AFTER `total_immatured`;
@@ -0,0 +1,4 @@
+-- Price of the coin when a payment was made, for fiat thresholds
+ALTER TABLE `payments_all`
+    ADD COLUMN `rate` VARCHAR(40) NULL DEFAULT NULL COLLATE 'utf8_general_ci' AFTER `state`,
+    ADD COLUMN `rate_currency` VARCHAR(10) NULL DEFAULT NULL COLLATE 'utf8_general_ci' AFTER `rate`;
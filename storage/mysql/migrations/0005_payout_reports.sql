@@ -0,0 +1,20 @@
+-- Dry-run reports of payout runs awaiting approval
+CREATE TABLE IF NOT EXISTS `payout_reports` (
+    `id` BIGINT(20) NOT NULL AUTO_INCREMENT,
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `state` VARCHAR(10) NOT NULL DEFAULT 'pending' COLLATE 'utf8_general_ci',
+    `recipients` INT(11) NOT NULL DEFAULT '0',
+    `amount` BIGINT(20) NOT NULL DEFAULT '0',
+    `tx_fee` BIGINT(20) NOT NULL DEFAULT '0',
+    `pool_balance` BIGINT(20) NOT NULL DEFAULT '0',
+    `post_balance` BIGINT(20) NOT NULL DEFAULT '0',
+    `report` MEDIUMTEXT NULL DEFAULT NULL COLLATE 'utf8_general_ci',
+    `approved_by` VARCHAR(30) NULL DEFAULT NULL COLLATE 'utf8_general_ci',
+    `timestamp` BIGINT(20) NOT NULL DEFAULT '0',
+    `insert_time` TIMESTAMP NULL DEFAULT current_timestamp(),
+    PRIMARY KEY (`id`) USING BTREE,
+    INDEX `coin_state` (`coin`, `state`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB
+AUTO_INCREMENT=1;
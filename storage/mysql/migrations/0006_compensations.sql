@@ -0,0 +1,35 @@
+-- Compensation batches and the balance change of each miner
+CREATE TABLE IF NOT EXISTS `compensations` (
+    `id` BIGINT(20) NOT NULL AUTO_INCREMENT,
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `key` VARCHAR(100) NOT NULL COLLATE 'utf8_general_ci',
+    `state` VARCHAR(10) NOT NULL DEFAULT 'pending' COLLATE 'utf8_general_ci',
+    `reason` VARCHAR(300) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `miners` INT(11) NOT NULL DEFAULT '0',
+    `total` BIGINT(20) NOT NULL DEFAULT '0',
+    `applied` BIGINT(20) NOT NULL DEFAULT '0',
+    `created_by` VARCHAR(30) NULL DEFAULT NULL COLLATE 'utf8_general_ci',
+    `approved_by` VARCHAR(30) NULL DEFAULT NULL COLLATE 'utf8_general_ci',
+    `timestamp` BIGINT(20) NOT NULL DEFAULT '0',
+    `insert_time` TIMESTAMP NULL DEFAULT current_timestamp(),
+    PRIMARY KEY (`id`) USING BTREE,
+    UNIQUE INDEX `coin_key` (`coin`, `key`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB
+AUTO_INCREMENT=1;
+
+CREATE TABLE IF NOT EXISTS `compensation_items` (
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `idem_key` VARCHAR(160) NOT NULL COLLATE 'utf8_general_ci',
+    `compensation_id` BIGINT(20) NOT NULL,
+    `login_addr` VARCHAR(50) NOT NULL COLLATE 'utf8_general_ci',
+    `amount` BIGINT(20) NOT NULL DEFAULT '0',
+    `state` VARCHAR(10) NOT NULL DEFAULT 'pending' COLLATE 'utf8_general_ci',
+    `timestamp` BIGINT(20) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`coin`, `idem_key`) USING BTREE,
+    INDEX `compensation_idx` (`compensation_id`, `state`) USING BTREE,
+    INDEX `login_idx` (`login_addr`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
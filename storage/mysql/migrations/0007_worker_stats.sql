@@ -0,0 +1,17 @@
+-- Share rollups per worker
+CREATE TABLE IF NOT EXISTS `worker_stats` (
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `login_addr` VARCHAR(68) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `worker` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `time` BIGINT(20) NOT NULL DEFAULT '0',
+    `hashrate` BIGINT(20) NOT NULL DEFAULT '0',
+    `valid` INT(11) NOT NULL DEFAULT '0',
+    `stale` INT(11) NOT NULL DEFAULT '0',
+    `invalid` INT(11) NOT NULL DEFAULT '0',
+    `duplicate` INT(11) NOT NULL DEFAULT '0',
+    `last_seen` BIGINT(20) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`coin`, `login_addr`, `worker`, `time`) USING BTREE,
+    INDEX `time_idx` (`time`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
@@ -0,0 +1,2 @@
+-- Memo passed to the post payout hook, e.g. for exchange deposit addresses
+ALTER TABLE `miner_info` ADD COLUMN `payout_memo` VARCHAR(64) NULL DEFAULT '' COLLATE 'utf8_general_ci' AFTER `payout_last`;
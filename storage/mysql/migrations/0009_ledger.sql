@@ -0,0 +1,27 @@
+-- Ledger of balance changes and the position of each sink streaming it
+CREATE TABLE IF NOT EXISTS `ledger_entries` (
+    `seq` BIGINT(20) NOT NULL AUTO_INCREMENT,
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `kind` VARCHAR(20) NOT NULL COLLATE 'utf8_general_ci',
+    `login_addr` VARCHAR(68) NOT NULL COLLATE 'utf8_general_ci',
+    `amount` BIGINT(20) NOT NULL DEFAULT '0',
+    `ref` VARCHAR(160) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `height` BIGINT(20) NOT NULL DEFAULT '0',
+    `timestamp` BIGINT(20) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`seq`) USING BTREE,
+    UNIQUE INDEX `entry_idx` (`coin`, `kind`, `ref`, `login_addr`) USING BTREE,
+    INDEX `coin_seq` (`coin`, `seq`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB
+AUTO_INCREMENT=1;
+
+CREATE TABLE IF NOT EXISTS `ledger_cursors` (
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `sink` VARCHAR(50) NOT NULL COLLATE 'utf8_general_ci',
+    `seq` BIGINT(20) NOT NULL DEFAULT '0',
+    `update_time` TIMESTAMP NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+    PRIMARY KEY (`coin`, `sink`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
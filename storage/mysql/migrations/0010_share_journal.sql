@@ -0,0 +1,9 @@
+-- Journaled shares already credited
+CREATE TABLE IF NOT EXISTS `share_journal` (
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `journal_id` VARCHAR(32) NOT NULL COLLATE 'utf8_general_ci',
+    `seq` BIGINT(20) UNSIGNED NOT NULL,
+    PRIMARY KEY (`coin`, `journal_id`, `seq`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
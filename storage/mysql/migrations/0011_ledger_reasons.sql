@@ -0,0 +1,14 @@
+-- The ledger is append-only, a wrong entry is corrected by a compensation.
+DROP TRIGGER IF EXISTS `ledger_entries_no_update`;
+CREATE TRIGGER `ledger_entries_no_update` BEFORE UPDATE ON `ledger_entries` FOR EACH ROW
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'ledger_entries is append-only';
+DROP TRIGGER IF EXISTS `ledger_entries_no_delete`;
+CREATE TRIGGER `ledger_entries_no_delete` BEFORE DELETE ON `ledger_entries` FOR EACH ROW
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'ledger_entries is append-only';
+
+-- Reason of each ledger entry, part of its identity, and lookups per miner
+ALTER TABLE `ledger_entries`
+    ADD COLUMN `reason` VARCHAR(30) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci' AFTER `kind`,
+    DROP INDEX `entry_idx`,
+    ADD UNIQUE INDEX `entry_idx` (`coin`, `kind`, `reason`, `ref`, `login_addr`) USING BTREE,
+    ADD INDEX `login_seq` (`coin`, `login_addr`, `seq`) USING BTREE;
@@ -0,0 +1,2 @@
+-- Corrections and manual adjustments
+ALTER TABLE `compensations` ADD COLUMN `kind` VARCHAR(20) NOT NULL DEFAULT 'correction' COLLATE 'utf8_general_ci' AFTER `key`;
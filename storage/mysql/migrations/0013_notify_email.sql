@@ -0,0 +1,2 @@
+-- Email address a miner set for notifications
+ALTER TABLE `miner_info` ADD COLUMN `notify_email` VARCHAR(254) NULL DEFAULT '' COLLATE 'utf8_general_ci' AFTER `payout_memo`;
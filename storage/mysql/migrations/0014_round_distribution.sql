@@ -0,0 +1,6 @@
+-- How the shares of a matured round were spread
+ALTER TABLE `blocks`
+    ADD COLUMN `miners` INT(11) NOT NULL DEFAULT '0' AFTER `unlock_retry`,
+    ADD COLUMN `gini` DOUBLE NOT NULL DEFAULT '0' AFTER `miners`,
+    ADD COLUMN `top10_share` DOUBLE NOT NULL DEFAULT '0' AFTER `gini`,
+    ADD COLUMN `share_histogram` VARCHAR(64) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci' AFTER `top10_share`;
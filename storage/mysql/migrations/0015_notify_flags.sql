@@ -0,0 +1,5 @@
+-- Notifications a miner subscribed to
+ALTER TABLE `miner_info`
+    ADD COLUMN `notify_payout` TINYINT(1) NOT NULL DEFAULT '0' AFTER `notify_email`,
+    ADD COLUMN `notify_offline` TINYINT(1) NOT NULL DEFAULT '0' AFTER `notify_payout`,
+    ADD COLUMN `notify_hashrate` TINYINT(1) NOT NULL DEFAULT '0' AFTER `notify_offline`;
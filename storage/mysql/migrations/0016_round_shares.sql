@@ -0,0 +1,11 @@
+-- Shares of each miner in a block candidate's round
+CREATE TABLE IF NOT EXISTS `round_shares` (
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `round_height` BIGINT(20) UNSIGNED NOT NULL,
+    `nonce` VARCHAR(100) NOT NULL COLLATE 'utf8_general_ci',
+    `login_addr` VARCHAR(50) NOT NULL COLLATE 'utf8_general_ci',
+    `shares` BIGINT(20) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`coin`, `round_height`, `nonce`, `login_addr`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
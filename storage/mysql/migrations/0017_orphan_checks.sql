@@ -0,0 +1,2 @@
+-- Unlock passes that missed a block before it is orphaned
+ALTER TABLE `blocks` ADD COLUMN `orphan_checks` INT(11) NOT NULL DEFAULT '0' AFTER `unlock_retry`;
@@ -0,0 +1,2 @@
+-- Region of the stratum listener of each worker's last share in a rollup
+ALTER TABLE `worker_stats` ADD COLUMN `region` VARCHAR(32) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci' AFTER `last_seen`;
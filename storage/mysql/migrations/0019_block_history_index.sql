@@ -0,0 +1,2 @@
+-- Block history pages filtered by state
+ALTER TABLE `blocks` ADD INDEX `coin_state_height_idx` (`coin`, `state`, `height`) USING BTREE;
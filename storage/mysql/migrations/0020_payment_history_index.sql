@@ -0,0 +1,2 @@
+-- Payment history pages filtered by state
+ALTER TABLE `payments_all` ADD INDEX `coin_state_seq_idx` (`coin`, `state`, `seq`) USING BTREE;
@@ -0,0 +1,13 @@
+-- Pool and miner hashrate samples summed per 1m, 1h and 1d bucket for the charts
+CREATE TABLE IF NOT EXISTS `hashrate_rollups` (
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `login_addr` VARCHAR(68) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `bucket` INT(11) NOT NULL,
+    `time` BIGINT(20) NOT NULL,
+    `hashrate_sum` BIGINT(20) NOT NULL DEFAULT '0',
+    `samples` INT(11) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`coin`, `login_addr`, `bucket`, `time`) USING BTREE,
+    INDEX `bucket_time_idx` (`bucket`, `time`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
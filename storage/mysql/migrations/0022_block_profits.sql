@@ -0,0 +1,21 @@
+-- Revenue of each matured block and how it was split, in Shannon
+CREATE TABLE IF NOT EXISTS `block_profits` (
+    `seq` BIGINT(20) NOT NULL AUTO_INCREMENT,
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `round_height` BIGINT(20) NOT NULL,
+    `height` BIGINT(20) NOT NULL,
+    `hash` VARCHAR(100) NOT NULL COLLATE 'utf8_general_ci',
+    `uncle` TINYINT(4) NOT NULL DEFAULT '0',
+    `revenue` BIGINT(20) NOT NULL DEFAULT '0',
+    `miners_profit` BIGINT(20) NOT NULL DEFAULT '0',
+    `pool_profit` BIGINT(20) NOT NULL DEFAULT '0',
+    `donation` BIGINT(20) NOT NULL DEFAULT '0',
+    `tx_fees` BIGINT(20) NOT NULL DEFAULT '0',
+    `uncle_bonus` BIGINT(20) NOT NULL DEFAULT '0',
+    `timestamp` BIGINT(20) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`seq`) USING BTREE,
+    UNIQUE INDEX `block_idx` (`coin`, `round_height`, `hash`) USING BTREE,
+    INDEX `coin_seq_idx` (`coin`, `seq`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
@@ -0,0 +1,20 @@
+-- Keys of the scripts and dashboards calling the admin API, only the SHA-256 of a key is stored
+CREATE TABLE IF NOT EXISTS `api_keys` (
+    `id` BIGINT(20) NOT NULL AUTO_INCREMENT,
+    `name` VARCHAR(50) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `key_hash` CHAR(64) NOT NULL COLLATE 'utf8_general_ci',
+    `prefix` VARCHAR(16) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `role` VARCHAR(20) NOT NULL DEFAULT 'read-only' COLLATE 'utf8_general_ci',
+    `rate_limit` INT(11) NOT NULL DEFAULT '0',
+    `created_by` VARCHAR(30) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `created_at` BIGINT(20) NOT NULL DEFAULT '0',
+    `last_used` BIGINT(20) NOT NULL DEFAULT '0',
+    `revoked` TINYINT(4) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`id`) USING BTREE,
+    UNIQUE INDEX `key_hash_idx` (`key_hash`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
+
+-- Role of the admin accounts, the existing ones keep full access
+ALTER TABLE `account` ADD COLUMN `role` VARCHAR(20) NOT NULL DEFAULT 'admin' COLLATE 'utf8_general_ci' AFTER `access`;
@@ -0,0 +1,12 @@
+-- Round-off dust of the block rewards not credited yet, in wei, with unlocker.remainderTo
+CREATE TABLE IF NOT EXISTS `reward_remainders` (
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `wei` DECIMAL(38,0) NOT NULL DEFAULT '0',
+    `height` BIGINT(20) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`coin`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
+
+-- Exact wei of the block credits, before they are rounded to Shannon
+ALTER TABLE `ledger_entries` ADD COLUMN `amount_wei` DECIMAL(38,0) NULL DEFAULT NULL AFTER `amount`;
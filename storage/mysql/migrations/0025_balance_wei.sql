@@ -0,0 +1,2 @@
+-- Exact balance of each miner in wei, the one paid with mysql.weiAccounting
+ALTER TABLE `miner_info` ADD COLUMN `balance_wei` DECIMAL(38,0) NOT NULL DEFAULT '0' AFTER `balance`;
@@ -0,0 +1,5 @@
+-- Balances from before wei accounting, in whole Shannon
+UPDATE `miner_info` SET `balance_wei` = CAST(`balance` AS DECIMAL(38,0)) * 1000000000 WHERE `balance_wei` = 0 AND `balance` <> 0;
+
+-- Exact wei of each block credit
+ALTER TABLE `credits_balance` ADD COLUMN `amount_wei` DECIMAL(38,0) NULL DEFAULT NULL AFTER `amount`;
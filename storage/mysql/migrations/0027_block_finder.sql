@@ -0,0 +1,5 @@
+-- Login and worker that submitted the winning share, and the finder bonus it was credited in Shannon
+ALTER TABLE `blocks`
+    ADD COLUMN `finder` VARCHAR(50) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci' AFTER `share_histogram`,
+    ADD COLUMN `finder_worker` VARCHAR(100) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci' AFTER `finder`,
+    ADD COLUMN `finder_bonus` BIGINT(20) NOT NULL DEFAULT '0' AFTER `finder_worker`;
@@ -0,0 +1,18 @@
+-- Every accepted share with proxy.shareLog, kept for investigations until its retention
+CREATE TABLE IF NOT EXISTS `share_log` (
+    `seq` BIGINT(20) NOT NULL AUTO_INCREMENT,
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `login_addr` VARCHAR(50) NOT NULL COLLATE 'utf8_general_ci',
+    `worker` VARCHAR(100) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `ip` VARCHAR(45) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `diff` BIGINT(20) NOT NULL DEFAULT '0',
+    `job` VARCHAR(68) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `height` BIGINT(20) NOT NULL DEFAULT '0',
+    `kind` VARCHAR(10) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `timestamp` BIGINT(20) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`seq`) USING BTREE,
+    INDEX `coin_time_idx` (`coin`, `timestamp`) USING BTREE,
+    INDEX `login_time_idx` (`coin`, `login_addr`, `timestamp`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
@@ -0,0 +1,13 @@
+-- Nonces of the payout account with payouts.nonceManager, written before their tx is sent
+CREATE TABLE IF NOT EXISTS `payout_nonces` (
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `address` VARCHAR(50) NOT NULL COLLATE 'utf8_general_ci',
+    `nonce` BIGINT(20) UNSIGNED NOT NULL,
+    `tx_hash` VARCHAR(70) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `state` TINYINT(4) NOT NULL DEFAULT '0',
+    `updated_at` BIGINT(20) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`coin`, `address`, `nonce`) USING BTREE,
+    INDEX `state_idx` (`coin`, `address`, `state`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
@@ -0,0 +1,12 @@
+-- Contract payees found with payouts.contractPayees, held for review until approved
+CREATE TABLE IF NOT EXISTS `payout_contracts` (
+    `coin` VARCHAR(20) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `address` VARCHAR(50) NOT NULL COLLATE 'utf8_general_ci',
+    `state` VARCHAR(16) NOT NULL DEFAULT 'detected' COLLATE 'utf8_general_ci',
+    `updated_by` VARCHAR(64) NOT NULL DEFAULT '' COLLATE 'utf8_general_ci',
+    `detected_at` BIGINT(20) NOT NULL DEFAULT '0',
+    `updated_at` BIGINT(20) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`coin`, `address`) USING BTREE
+)
+COLLATE='utf8_general_ci'
+ENGINE=InnoDB;
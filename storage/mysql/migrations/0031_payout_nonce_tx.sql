@@ -0,0 +1,4 @@
+-- Tx sent with each payout nonce, sent again when the node drops it: the signed payload or the
+-- eth_sendTransaction fields
+ALTER TABLE `payout_nonces`
+    ADD COLUMN `tx` MEDIUMTEXT NULL DEFAULT NULL COLLATE 'utf8_general_ci' AFTER `tx_hash`;
@@ -0,0 +1,86 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// States of the payout nonces
+const (
+	// Written before the tx is sent, left so if the payer stopped in between
+	NonceReserved = 0
+	NonceSent     = 1
+	// The node refused the tx, the nonce is free again
+	NonceFailed = -1
+)
+
+// PayoutNonce is a nonce the payout account used or is about to use.
+type PayoutNonce struct {
+	Nonce  uint64
+	TxHash string
+	// Signed payload in hex or eth_sendTransaction fields in JSON, empty until sent
+	Tx        string
+	State     int
+	UpdatedAt int64
+}
+
+// GetNextPayoutNonce returns the nonce after the last one reserved or sent from address, and
+// false if there is none.
+func (d *Database) GetNextPayoutNonce(address string) (uint64, bool, error) {
+	var last sql.NullInt64
+	err := d.Conn.QueryRow("SELECT MAX(nonce) FROM payout_nonces WHERE coin=? AND address=? AND state IN (?,?)",
+		d.Config.Coin, strings.ToLower(address), NonceReserved, NonceSent).Scan(&last)
+	if err != nil || !last.Valid {
+		return 0, false, err
+	}
+	return uint64(last.Int64) + 1, true, nil
+}
+
+// ReservePayoutNonce records nonce as taken by a tx about to be sent from address.
+func (d *Database) ReservePayoutNonce(address string, nonce uint64) error {
+	_, err := d.Conn.Exec("INSERT INTO payout_nonces(coin,address,nonce,tx_hash,tx,state,updated_at) VALUES (?,?,?,'','',?,?) "+
+		"ON DUPLICATE KEY UPDATE tx_hash=VALUES(tx_hash),tx=VALUES(tx),state=VALUES(state),updated_at=VALUES(updated_at)",
+		d.Config.Coin, strings.ToLower(address), nonce, NonceReserved, time.Now().Unix())
+	return err
+}
+
+// SetPayoutNonce sets the state of nonce of address, and its tx and hash once sent.
+func (d *Database) SetPayoutNonce(address string, nonce uint64, state int, txHash string, tx string) error {
+	_, err := d.Conn.Exec("UPDATE payout_nonces SET state=?,tx_hash=?,tx=?,updated_at=? WHERE coin=? AND address=? AND nonce=?",
+		state, txHash, tx, time.Now().Unix(), d.Config.Coin, strings.ToLower(address), nonce)
+	return err
+}
+
+// GetPayoutNonces returns the nonces of address in state, lowest first.
+func (d *Database) GetPayoutNonces(address string, state int) ([]*PayoutNonce, error) {
+	rows, err := d.Conn.Query("SELECT nonce,tx_hash,IFNULL(tx,''),state,updated_at FROM payout_nonces WHERE coin=? AND address=? AND state=? ORDER BY nonce",
+		d.Config.Coin, strings.ToLower(address), state)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []*PayoutNonce
+	for rows.Next() {
+		n := &PayoutNonce{}
+		if err := rows.Scan(&n.Nonce, &n.TxHash, &n.Tx, &n.State, &n.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, rows.Err()
+}
+
+// GetPayoutNonce returns nonce of address, nil if it was never reserved.
+func (d *Database) GetPayoutNonce(address string, nonce uint64) (*PayoutNonce, error) {
+	n := &PayoutNonce{}
+	err := d.Conn.QueryRow("SELECT nonce,tx_hash,IFNULL(tx,''),state,updated_at FROM payout_nonces WHERE coin=? AND address=? AND nonce=?",
+		d.Config.Coin, strings.ToLower(address), nonce).Scan(&n.Nonce, &n.TxHash, &n.Tx, &n.State, &n.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
@@ -0,0 +1,44 @@
+package mysql
+
+// NotifyOptions are the events a miner opted in to be emailed about.
+type NotifyOptions struct {
+	Payout   bool `json:"payout"`
+	Offline  bool `json:"offline"`
+	Hashrate bool `json:"hashrate"`
+}
+
+// NotifySubscriber is a miner with a notification email and at least one event opted in.
+type NotifySubscriber struct {
+	Login   string
+	Email   string
+	Options NotifyOptions
+}
+
+// UpdateNotifyOptions sets the events login is emailed about.
+func (d *Database) UpdateNotifyOptions(login string, options NotifyOptions) error {
+	_, err := d.Conn.Exec("UPDATE miner_info SET notify_payout=?,notify_offline=?,notify_hashrate=? WHERE coin=? AND login_addr=?",
+		options.Payout, options.Offline, options.Hashrate, d.Config.Coin, login)
+	return err
+}
+
+// GetNotifySubscribers returns the miners with a notification email that opted in to offline workers or
+// hashrate drops, the events that are checked for.
+func (d *Database) GetNotifySubscribers() ([]*NotifySubscriber, error) {
+	rows, err := d.Conn.Query("SELECT login_addr,notify_email,notify_payout,notify_offline,notify_hashrate FROM miner_info "+
+		"WHERE coin=? AND notify_email<>'' AND (notify_offline=1 OR notify_hashrate=1)", d.Config.Coin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*NotifySubscriber
+	for rows.Next() {
+		var s NotifySubscriber
+		err := rows.Scan(&s.Login, &s.Email, &s.Options.Payout, &s.Options.Offline, &s.Options.Hashrate)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &s)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,65 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+)
+
+// BlockProfit is how the revenue of a matured block was split, amounts in Shannon.
+type BlockProfit struct {
+	Seq         int64  `json:"seq"`
+	RoundHeight int64  `json:"roundHeight"`
+	Height      int64  `json:"height"`
+	Hash        string `json:"hash"`
+	Uncle       bool   `json:"uncle"`
+	// Block reward, tx fees and uncle bonus included
+	Revenue      int64 `json:"revenue"`
+	MinersProfit int64 `json:"minersProfit"`
+	// Pool fee after the donation, with the tx fees the pool keeps
+	PoolProfit int64 `json:"poolProfit"`
+	Donation   int64 `json:"donation"`
+	TxFees     int64 `json:"txFees"`
+	UncleBonus int64 `json:"uncleBonus"`
+	Timestamp  int64 `json:"timestamp"`
+}
+
+// writeBlockProfit stores the split of a block with its credits. A block matured again keeps its first split.
+func (d *Database) writeBlockProfit(tx *sql.Tx, block *types.BlockData, profit *BlockProfit) error {
+	if profit == nil {
+		return nil
+	}
+	_, err := tx.Exec("INSERT IGNORE INTO block_profits(coin,round_height,height,hash,uncle,revenue,miners_profit,pool_profit,donation,tx_fees,uncle_bonus,`timestamp`) "+
+		"VALUES (?,?,?,?,?,?,?,?,?,?,?,?)",
+		d.Config.Coin, block.RoundHeight, block.Height, block.Hash, block.UncleHeight > 0, profit.Revenue, profit.MinersProfit, profit.PoolProfit,
+		profit.Donation, profit.TxFees, profit.UncleBonus, block.Timestamp)
+	return err
+}
+
+// StreamBlockProfits calls fn with up to limit block splits past the seq cursor, newest first unless the
+// filter asks for the oldest, while it reads them. A cursor of 0 starts from the first.
+func (d *Database) StreamBlockProfits(cursor int64, limit int, filter HistoryFilter, fn func(*BlockProfit) error) error {
+	conn := d.Conn
+	filter.States = nil
+	where, args := filter.where("seq", cursor)
+	args = append([]interface{}{d.Config.Coin}, append(args, limit)...)
+	rows, err := conn.Query("SELECT seq,round_height,height,hash,uncle,revenue,miners_profit,pool_profit,donation,tx_fees,uncle_bonus,`timestamp` FROM block_profits "+
+		"WHERE coin=?"+where+" LIMIT ?", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p BlockProfit
+		err := rows.Scan(&p.Seq, &p.RoundHeight, &p.Height, &p.Hash, &p.Uncle, &p.Revenue, &p.MinersProfit, &p.PoolProfit,
+			&p.Donation, &p.TxFees, &p.UncleBonus, &p.Timestamp)
+		if err != nil {
+			return err
+		}
+		if err := fn(&p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
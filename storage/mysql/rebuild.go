@@ -0,0 +1,230 @@
+package mysql
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// MinerTotals are the aggregates of miner_info that follow from the ledger.
+type MinerTotals struct {
+	Login     string
+	Balance   int64
+	Pending   int64
+	Paid      int64
+	Immature  int64
+	PayoutCnt int64
+	// A payout is in flight, its gas fee isn't in the ledger yet so the balance is left alone
+	Locked bool
+}
+
+// PoolTotals are the aggregates of finances that follow from the ledger.
+type PoolTotals struct {
+	Immature   int64
+	Pending    int64
+	Balance    int64
+	Paid       int64
+	PayoutCnt  int64
+	GasFee     int64
+	TotalMined int64
+	LastHeight int64
+	LastHash   string
+}
+
+// GetMinerTotals reads the cached aggregates of every miner.
+func (d *Database) GetMinerTotals() (map[string]*MinerTotals, error) {
+	conn := d.Conn
+	rows, err := conn.Query("SELECT login_addr,IFNULL(balance,0),IFNULL(pending,0),IFNULL(paid,0),IFNULL(immature,0),IFNULL(payout_cnt,0),IFNULL(payout_lock,0) FROM miner_info WHERE coin=?", d.Config.Coin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]*MinerTotals)
+	for rows.Next() {
+		var (
+			m          MinerTotals
+			payoutLock int64
+		)
+		err := rows.Scan(&m.Login, &m.Balance, &m.Pending, &m.Paid, &m.Immature, &m.PayoutCnt, &payoutLock)
+		if err != nil {
+			return nil, err
+		}
+		m.Locked = payoutLock > 0
+		result[m.Login] = &m
+	}
+	return result, nil
+}
+
+// LedgerMinerTotals recomputes the miners' aggregates from immature and matured credits, applied
+// compensations and payments. Pending amounts can't be told from the ledger and are taken from cached.
+// Without withBalance, e.g. when old credits_balance rows were deleted, balances are taken from cached too.
+func (d *Database) LedgerMinerTotals(cached map[string]*MinerTotals, withBalance bool) (map[string]*MinerTotals, error) {
+	result := make(map[string]*MinerTotals)
+	get := func(login string) *MinerTotals {
+		m, ok := result[login]
+		if !ok {
+			m = &MinerTotals{Login: login}
+			if c, ok := cached[login]; ok {
+				m.Pending, m.Locked = c.Pending, c.Locked
+			}
+			result[login] = m
+		}
+		return m
+	}
+	for login := range cached {
+		get(login)
+	}
+
+	err := d.sumByLogin("SELECT login_addr,SUM(CAST(amount AS SIGNED)) FROM credits_immature WHERE coin=? GROUP BY login_addr",
+		func(login string, sums []int64) { get(login).Immature = sums[0] })
+	if err != nil {
+		return nil, err
+	}
+	err = d.sumByLogin("SELECT login_addr,SUM(amount),SUM(tx_fee),COUNT(*) FROM payments_all WHERE coin=? GROUP BY login_addr",
+		func(login string, sums []int64) {
+			m := get(login)
+			m.Paid, m.PayoutCnt = sums[0], sums[2]
+			m.Balance -= sums[0] + sums[1]
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = d.sumByLogin("SELECT login_addr,SUM(CAST(amount AS SIGNED)) FROM credits_balance WHERE coin=? GROUP BY login_addr",
+		func(login string, sums []int64) { get(login).Balance += sums[0] })
+	if err != nil {
+		return nil, err
+	}
+	err = d.sumByLogin("SELECT login_addr,SUM(amount) FROM compensation_items WHERE coin=? AND `state`='"+CompensationItemApplied+"' GROUP BY login_addr",
+		func(login string, sums []int64) { get(login).Balance += sums[0] })
+	if err != nil {
+		return nil, err
+	}
+
+	for login, m := range result {
+		m.Balance -= m.Pending
+		if c, ok := cached[login]; ok && (!withBalance || m.Locked) {
+			m.Balance = c.Balance
+		}
+	}
+	return result, nil
+}
+
+func (d *Database) sumByLogin(query string, fn func(login string, sums []int64)) error {
+	conn := d.Conn
+	rows, err := conn.Query(query, d.Config.Coin)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var login string
+		sums := make([]sql.NullInt64, len(cols)-1)
+		dest := []interface{}{&login}
+		for i := range sums {
+			dest = append(dest, &sums[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		values := make([]int64, len(sums))
+		for i, s := range sums {
+			values[i] = s.Int64
+		}
+		fn(login, values)
+	}
+	return rows.Err()
+}
+
+// GetPoolTotals reads the cached aggregates of the pool.
+func (d *Database) GetPoolTotals() (*PoolTotals, error) {
+	conn := d.Conn
+	var (
+		p        PoolTotals
+		paid     sql.NullString
+		lastHash sql.NullString
+	)
+	err := conn.QueryRow("SELECT IFNULL(immature,0),IFNULL(pending,0),IFNULL(balance,0),IFNULL(paid,0),IFNULL(payout_cnt,0),IFNULL(gas_fee,0),IFNULL(total_mined,0),IFNULL(last_height,0),last_hash FROM finances WHERE coin=?", d.Config.Coin).
+		Scan(&p.Immature, &p.Pending, &p.Balance, &paid, &p.PayoutCnt, &p.GasFee, &p.TotalMined, &p.LastHeight, &lastHash)
+	if err == sql.ErrNoRows {
+		return &p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Paid, _ = strconv.ParseInt(paid.String, 10, 64)
+	p.LastHash = lastHash.String
+	return &p, nil
+}
+
+// LedgerPoolTotals recomputes the pool's aggregates from the miners' ones and the matured blocks.
+// The gas fee of payouts in flight isn't in the ledger, it is taken from cached while any miner is locked.
+func (d *Database) LedgerPoolTotals(cached *PoolTotals, miners map[string]*MinerTotals) (*PoolTotals, error) {
+	p := &PoolTotals{GasFee: cached.GasFee}
+	locked := false
+	for _, m := range miners {
+		p.Immature += m.Immature
+		p.Pending += m.Pending
+		p.Balance += m.Balance
+		p.Paid += m.Paid
+		p.PayoutCnt += m.PayoutCnt
+		locked = locked || m.Locked
+	}
+
+	conn := d.Conn
+	if !locked {
+		err := conn.QueryRow("SELECT IFNULL(SUM(tx_fee),0) FROM payments_all WHERE coin=?", d.Config.Coin).Scan(&p.GasFee)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// total_mined adds up each block's reward in Shannon, rounded down.
+	err := conn.QueryRow("SELECT IFNULL(SUM(FLOOR(CAST(reward AS DECIMAL(50))/1000000000)),0) FROM credits_blocks WHERE coin=?", d.Config.Coin).Scan(&p.TotalMined)
+	if err != nil {
+		return nil, err
+	}
+	var lastHash sql.NullString
+	err = conn.QueryRow("SELECT height,hash FROM credits_blocks WHERE coin=? ORDER BY height DESC LIMIT 1", d.Config.Coin).Scan(&p.LastHeight, &lastHash)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	p.LastHash = lastHash.String
+	return p, nil
+}
+
+// WriteMinerTotals stores the miners' aggregates. Only immature is written for miners locked by a payout,
+// also if they got locked since they were read.
+func (d *Database) WriteMinerTotals(miners []*MinerTotals) error {
+	conn := d.Conn
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range miners {
+		// The ledger is in Shannon, a rebuilt exact balance loses what was under a Shannon.
+		_, err := tx.Exec("INSERT INTO miner_info(coin,login_addr,balance,balance_wei,paid,immature,payout_cnt) VALUES (?,?,?,?,?,?,?) "+
+			"ON DUPLICATE KEY UPDATE balance=IF(payout_lock=0,VALUES(balance),balance),balance_wei=IF(payout_lock=0,VALUES(balance_wei),balance_wei),"+
+			"paid=IF(payout_lock=0,VALUES(paid),paid),immature=VALUES(immature),payout_cnt=IF(payout_lock=0,VALUES(payout_cnt),payout_cnt)",
+			d.Config.Coin, m.Login, m.Balance, shannonToWei(m.Balance).String(), m.Paid, m.Immature, m.PayoutCnt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// WritePoolTotals stores the pool's aggregates.
+func (d *Database) WritePoolTotals(p *PoolTotals) error {
+	conn := d.Conn
+	_, err := conn.Exec("INSERT INTO finances(coin,immature,pending,balance,paid,payout_cnt,gas_fee,total_mined,last_height,last_hash) VALUES (?,?,?,?,?,?,?,?,?,?) "+
+		"ON DUPLICATE KEY UPDATE immature=VALUES(immature),pending=VALUES(pending),balance=VALUES(balance),paid=VALUES(paid),"+
+		"payout_cnt=VALUES(payout_cnt),gas_fee=VALUES(gas_fee),total_mined=VALUES(total_mined),last_height=VALUES(last_height),last_hash=VALUES(last_hash)",
+		d.Config.Coin, p.Immature, p.Pending, p.Balance, p.Paid, p.PayoutCnt, p.GasFee, p.TotalMined, p.LastHeight, p.LastHash)
+	return err
+}
@@ -0,0 +1,32 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+)
+
+// GetRewardRemainder returns the round-off dust in wei left from the blocks credited so far.
+func (d *Database) GetRewardRemainder() (*big.Int, error) {
+	conn := d.Conn
+	var value string
+	err := conn.QueryRow("SELECT wei FROM reward_remainders WHERE coin=?", d.Config.Coin).Scan(&value)
+	if err == sql.ErrNoRows {
+		return new(big.Int), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	wei, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid reward remainder %q", value)
+	}
+	return wei, nil
+}
+
+// writeRewardRemainder stores the dust left after the block at height was credited.
+func (d *Database) writeRewardRemainder(tx *sql.Tx, height int64, wei *big.Int) error {
+	_, err := tx.Exec("INSERT INTO reward_remainders(coin,wei,height) VALUES (?,?,?) ON DUPLICATE KEY UPDATE wei=VALUES(wei),height=VALUES(height)",
+		d.Config.Coin, wei.String(), height)
+	return err
+}
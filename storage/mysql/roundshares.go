@@ -0,0 +1,75 @@
+package mysql
+
+import (
+	"database/sql"
+)
+
+// writeRoundShares snapshots the shares a round is rewarded by with its block candidate, a durable
+// copy of the round in Redis.
+func (d *Database) writeRoundShares(tx *sql.Tx, roundHeight uint64, nonce string, shares map[string]int64) error {
+	for login, n := range shares {
+		_, err := tx.Exec("INSERT INTO round_shares(`coin`,`round_height`,`nonce`,`login_addr`,`shares`) VALUES (?,?,?,?,?) ON DUPLICATE KEY UPDATE `shares`=VALUES(`shares`)",
+			d.Config.Coin, roundHeight, nonce, login, n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRoundShares returns the shares snapshotted with the candidate of a round, empty for rounds
+// found before snapshots were written.
+func (d *Database) GetRoundShares(roundHeight int64, nonce string) (map[string]int64, error) {
+	rows, err := d.Conn.Query("SELECT `login_addr`,`shares` FROM round_shares WHERE coin=? AND round_height=? AND nonce=?", d.Config.Coin, roundHeight, nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]int64)
+	for rows.Next() {
+		var (
+			login  string
+			shares int64
+		)
+		if err := rows.Scan(&login, &shares); err != nil {
+			return nil, err
+		}
+		result[login] = shares
+	}
+	return result, rows.Err()
+}
+
+// ArchiveRoundShares keeps the shares of a round moved out of Redis, over the snapshot of its
+// candidate if there is one.
+func (d *Database) ArchiveRoundShares(roundHeight int64, nonce string, shares map[string]int64) error {
+	tx, err := d.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := d.writeRoundShares(tx, uint64(roundHeight), nonce, shares); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRoundSettledAt returns when the block of a round was found if it is matured, orphaned or a
+// duplicate, 0 while it is unresolved or unknown. height is the round or the block height, the
+// round is renamed to the latter once its block is found.
+func (d *Database) GetRoundSettledAt(height int64, nonce string) (int64, error) {
+	var ts int64
+	err := d.Conn.QueryRow("SELECT IFNULL(MAX(`timestamp`),0) FROM blocks WHERE coin=? AND nonce=? AND (round_height=? OR height=?) AND state IN (?,?,?)",
+		d.Config.Coin, nonce, height, height, constOrphanBlock, constMatureBlock, constDuplicateBlock).Scan(&ts)
+	return ts, err
+}
+
+// GetSettledHeight returns the highest block matured or orphaned before the unix time foundBefore,
+// 0 if there is none.
+func (d *Database) GetSettledHeight(foundBefore int64) (int64, error) {
+	var height int64
+	err := d.Conn.QueryRow("SELECT IFNULL(MAX(height),0) FROM blocks WHERE coin=? AND state IN (?,?) AND `timestamp` < ?",
+		d.Config.Coin, constOrphanBlock, constMatureBlock, foundBefore).Scan(&height)
+	return height, err
+}
@@ -0,0 +1,61 @@
+package mysql
+
+import (
+	"strings"
+)
+
+// ShareLogEntry is an accepted share as logged for investigations. Timestamp is in milliseconds.
+type ShareLogEntry struct {
+	Login  string `json:"login"`
+	Worker string `json:"worker"`
+	IP     string `json:"ip"`
+	Diff   int64  `json:"diff"`
+	// Header of the job the share was computed on
+	Job    string `json:"job"`
+	Height uint64 `json:"height"`
+	// "valid", "block", "stale" or "buffered"
+	Kind      string `json:"kind"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// WriteShareLog inserts a batch of logged shares with one statement.
+func (d *Database) WriteShareLog(entries []*ShareLogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var query strings.Builder
+	args := make([]interface{}, 0, len(entries)*9)
+	for i, e := range entries {
+		if i > 0 {
+			query.WriteByte(',')
+		}
+		query.WriteString("(?,?,?,?,?,?,?,?,?)")
+		args = append(args, d.Config.Coin, e.Login, e.Worker, e.IP, e.Diff, e.Job, e.Height, e.Kind, e.Timestamp)
+	}
+	_, err := d.Conn.Exec("INSERT INTO share_log(coin,login_addr,worker,ip,diff,job,height,kind,`timestamp`) VALUES "+query.String(), args...)
+	return err
+}
+
+// Rows of share_log deleted per statement, so the retention never locks the table for long
+const shareLogDeleteChunk = 10000
+
+// DeleteShareLog drops the logged shares older than before, in milliseconds, a chunk at a time. The
+// chunk is picked by a subquery, PostgreSQL has no DELETE ... LIMIT.
+func (d *Database) DeleteShareLog(before int64) (int64, error) {
+	var total int64
+	for {
+		ret, err := d.Conn.Exec("DELETE FROM share_log WHERE seq IN (SELECT seq FROM (SELECT seq FROM share_log WHERE coin=? AND `timestamp`<? ORDER BY seq LIMIT ?) AS chunk)",
+			d.Config.Coin, before, shareLogDeleteChunk)
+		if err != nil {
+			return total, err
+		}
+		n, err := ret.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < shareLogDeleteChunk {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package mysql
+
+import (
+	"strconv"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+)
+
+// GetStuckBlocks returns the blocks found before the unix time foundBefore that are neither matured
+// nor orphaned yet, including the rounds set aside without shares, oldest first.
+func (d *Database) GetStuckBlocks(foundBefore int64) ([]*types.BlockData, error) {
+	return d.queryBlocks("state IN (?,?,?,?,?) AND `timestamp` < ? AND coin=? ORDER BY round_height",
+		constImmaturedBlockErr, constCandidatesBlockErr, constCandidatesBlock, constImmatureBlock, constPeddingImmaturedBlock,
+		foundBefore, d.Config.Coin)
+}
+
+// BlockStateName names a state of the blocks table.
+func BlockStateName(state int) string {
+	switch state {
+	case constImmaturedBlockErr:
+		return "immature error"
+	case constCandidatesBlockErr:
+		return "candidate error"
+	case constCandidatesBlock:
+		return "candidate"
+	case constImmatureBlock:
+		return "immature"
+	case constPeddingImmaturedBlock:
+		return "pending immature"
+	case constOrphanBlock:
+		return "orphan"
+	case constMatureBlock:
+		return "matured"
+	}
+	return strconv.Itoa(state)
+}
@@ -0,0 +1,45 @@
+package mysql
+
+import (
+	"math/big"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// A wei amount passed as a query param. MySQL would compute with the string as a DOUBLE otherwise.
+const weiParam = "CAST(? AS DECIMAL(38,0))"
+
+func shannonToWei(amount int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(amount), util.Shannon)
+}
+
+// creditedWei is the wei each login of a matured block is credited, its share of the round and its pool
+// credits. It is the Shannon amount without weiAccounting, or when a part of it isn't known.
+func (d *Database) creditedWei(roundRewards map[string]int64, roundWei map[string]*big.Int, poolCredits []*PoolCredit) map[string]*big.Int {
+	result := make(map[string]*big.Int, len(roundRewards))
+	exact := make(map[string]bool, len(roundRewards))
+	for login := range roundRewards {
+		wei, ok := roundWei[login]
+		exact[login] = d.Config.WeiAccounting && ok
+		result[login] = new(big.Int)
+		if ok {
+			result[login].Set(wei)
+		}
+	}
+	for _, c := range poolCredits {
+		if _, ok := result[c.Login]; !ok {
+			continue
+		}
+		if c.Wei == nil {
+			exact[c.Login] = false
+			continue
+		}
+		result[c.Login].Add(result[c.Login], c.Wei)
+	}
+	for login, amount := range roundRewards {
+		if !exact[login] {
+			result[login] = shannonToWei(amount)
+		}
+	}
+	return result
+}
@@ -0,0 +1,332 @@
+-- The schema of a new PostgreSQL database, the MySQL schema of storage/mysql/migrations column for
+-- column so rows can be copied over. Reserved words are quoted, and index names carry their table
+-- since PostgreSQL names indexes per schema. Flags stay SMALLINT and amounts keep their MySQL types,
+-- as the queries expect them. {{logTable}} is mysql.logTableName.
+
+CREATE TABLE IF NOT EXISTS blocks (
+    state SMALLINT NULL DEFAULT NULL,
+    coin VARCHAR(20) NULL DEFAULT '',
+    round_height BIGINT NULL DEFAULT NULL,
+    nonce VARCHAR(100) NULL DEFAULT NULL,
+    height BIGINT NULL DEFAULT 0,
+    hash_no_nonce VARCHAR(100) NULL DEFAULT NULL,
+    mix_digest VARCHAR(100) NULL DEFAULT NULL,
+    round_diff BIGINT NULL DEFAULT NULL,
+    total_share BIGINT NULL DEFAULT 0,
+    insert_time VARCHAR(100) NULL DEFAULT NULL,
+    uncle_height BIGINT NULL DEFAULT 0,
+    orphan SMALLINT NULL DEFAULT 0,
+    hash VARCHAR(68) NULL DEFAULT '',
+    "timestamp" BIGINT NULL DEFAULT 0,
+    diff BIGINT NULL DEFAULT 0,
+    total_diff BIGINT NULL DEFAULT 0,
+    reward VARCHAR(32) NULL DEFAULT '0',
+    total_immatured_cnt INTEGER NULL DEFAULT 0,
+    total_immatured BIGINT NULL DEFAULT 0,
+    unlock_retry INTEGER NOT NULL DEFAULT 0,
+    orphan_checks INTEGER NOT NULL DEFAULT 0,
+    miners INTEGER NOT NULL DEFAULT 0,
+    gini DOUBLE PRECISION NOT NULL DEFAULT 0,
+    top10_share DOUBLE PRECISION NOT NULL DEFAULT 0,
+    share_histogram VARCHAR(64) NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS blocks_nonce_idx ON blocks (state, round_height, nonce);
+CREATE INDEX IF NOT EXISTS blocks_height_idx ON blocks (state, height);
+
+
+CREATE TABLE IF NOT EXISTS credits_balance (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    round_height BIGINT NOT NULL,
+    height BIGINT NOT NULL,
+    hash VARCHAR(68) NOT NULL,
+    login_addr VARCHAR(50) NOT NULL,
+    amount VARCHAR(30) NULL DEFAULT NULL,
+    percent DECIMAL(20,9) NULL DEFAULT 0,
+    "timestamp" BIGINT NULL DEFAULT 0,
+    insert_cnt INTEGER NULL DEFAULT 1,
+    insert_time TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (height, hash, login_addr)
+);
+CREATE INDEX IF NOT EXISTS credits_balance_login_idx ON credits_balance (login_addr);
+
+
+CREATE TABLE IF NOT EXISTS credits_blocks (
+    height BIGINT NOT NULL DEFAULT 0,
+    hash VARCHAR(68) NOT NULL DEFAULT '',
+    coin VARCHAR(20) NULL DEFAULT NULL,
+    reward VARCHAR(32) NULL DEFAULT '0',
+    "timestamp" TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (height, hash)
+);
+CREATE INDEX IF NOT EXISTS credits_blocks_coin_idx ON credits_blocks (coin, height);
+
+CREATE TABLE IF NOT EXISTS credits_immature (
+    coin VARCHAR(20) NULL DEFAULT NULL,
+    round_height BIGINT NOT NULL,
+    height BIGINT NOT NULL,
+    hash VARCHAR(68) NOT NULL,
+    login_addr VARCHAR(50) NOT NULL,
+    amount VARCHAR(30) NULL DEFAULT NULL,
+    percent DECIMAL(20,9) NULL DEFAULT NULL,
+    "timestamp" BIGINT NULL DEFAULT NULL,
+    PRIMARY KEY (round_height, hash, login_addr)
+);
+CREATE INDEX IF NOT EXISTS credits_immature_login_idx ON credits_immature (login_addr);
+
+
+CREATE TABLE IF NOT EXISTS finances (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    immature BIGINT NULL DEFAULT 0,
+    pending BIGINT NULL DEFAULT 0,
+    balance BIGINT NULL DEFAULT 0,
+    paid DECIMAL(26,0) NULL DEFAULT NULL,
+    last_height BIGINT NULL DEFAULT 0,
+    last_hash VARCHAR(68) NULL DEFAULT NULL,
+    total_mined BIGINT NULL DEFAULT 0,
+    payout_cnt BIGINT NULL DEFAULT 0,
+    gas_fee BIGINT NULL DEFAULT 0,
+    PRIMARY KEY (coin)
+);
+
+CREATE TABLE IF NOT EXISTS miner_charts (
+    login_addr VARCHAR(68) NOT NULL DEFAULT '',
+    "time" BIGINT NOT NULL DEFAULT 0,
+    time2 TIMESTAMP NULL DEFAULT NULL,
+    hash BIGINT NULL DEFAULT 0,
+    large_hash BIGINT NULL DEFAULT 0,
+    report_hash BIGINT NULL DEFAULT 0,
+    share INTEGER NULL DEFAULT NULL,
+    work_online INTEGER NULL DEFAULT NULL,
+    coin VARCHAR(20) NULL DEFAULT '',
+    PRIMARY KEY (login_addr, "time")
+);
+
+CREATE TABLE IF NOT EXISTS worker_stats (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    login_addr VARCHAR(68) NOT NULL DEFAULT '',
+    worker VARCHAR(20) NOT NULL DEFAULT '',
+    "time" BIGINT NOT NULL DEFAULT 0,
+    hashrate BIGINT NOT NULL DEFAULT 0,
+    valid INTEGER NOT NULL DEFAULT 0,
+    stale INTEGER NOT NULL DEFAULT 0,
+    invalid INTEGER NOT NULL DEFAULT 0,
+    duplicate INTEGER NOT NULL DEFAULT 0,
+    last_seen BIGINT NOT NULL DEFAULT 0,
+    region VARCHAR(32) NOT NULL DEFAULT '',
+    PRIMARY KEY (coin, login_addr, worker, "time")
+);
+CREATE INDEX IF NOT EXISTS worker_stats_time_idx ON worker_stats ("time");
+
+CREATE TABLE IF NOT EXISTS miner_info (
+    coin VARCHAR(20) NOT NULL,
+    login_addr VARCHAR(50) NOT NULL,
+    balance BIGINT NULL DEFAULT 0,
+    pending BIGINT NULL DEFAULT 0,
+    paid BIGINT NULL DEFAULT 0,
+    blocks_found INTEGER NULL DEFAULT 0,
+    immature BIGINT NULL DEFAULT 0,
+    matured BIGINT NULL DEFAULT 0,
+    share INTEGER NULL DEFAULT 0,
+    share_check BIGINT NULL DEFAULT 0,
+    last_share TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    id VARCHAR(50) NULL DEFAULT '',
+    diff_times INTEGER NULL DEFAULT 0,
+    payout_lock BIGINT NULL DEFAULT 0,
+    payout_limit BIGINT NULL DEFAULT 0,
+    payout_cnt BIGINT NULL DEFAULT 0,
+    payout_last TIMESTAMP NULL DEFAULT NULL,
+    payout_memo VARCHAR(64) NULL DEFAULT '',
+    notify_email VARCHAR(254) NULL DEFAULT '',
+    notify_payout SMALLINT NOT NULL DEFAULT 0,
+    notify_offline SMALLINT NOT NULL DEFAULT 0,
+    notify_hashrate SMALLINT NOT NULL DEFAULT 0,
+    hostname VARCHAR(50) NULL DEFAULT '',
+    insert_time TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (coin, login_addr)
+);
+CREATE INDEX IF NOT EXISTS miner_info_time_idx ON miner_info (insert_time);
+CREATE INDEX IF NOT EXISTS miner_info_balance_idx ON miner_info (balance, payout_limit);
+
+
+CREATE TABLE IF NOT EXISTS payments_all (
+    seq BIGSERIAL NOT NULL,
+    login_addr VARCHAR(68) NOT NULL DEFAULT '0x0',
+    "from" VARCHAR(68) NOT NULL DEFAULT '0x0',
+    tx_hash VARCHAR(128) NULL DEFAULT NULL,
+    amount BIGINT NULL DEFAULT 0,
+    tx_fee BIGINT NULL DEFAULT 0,
+    coin VARCHAR(20) NULL DEFAULT '',
+    "timestamp" BIGINT NULL DEFAULT 0,
+    state SMALLINT NOT NULL DEFAULT 0,
+    rate VARCHAR(40) NULL DEFAULT NULL,
+    rate_currency VARCHAR(10) NULL DEFAULT NULL,
+    insert_time TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (seq)
+);
+CREATE INDEX IF NOT EXISTS payments_all_login_idx ON payments_all (login_addr);
+CREATE INDEX IF NOT EXISTS payments_all_tx_hash_idx ON payments_all (tx_hash);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    seq BIGSERIAL NOT NULL,
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    kind VARCHAR(20) NOT NULL,
+    reason VARCHAR(30) NOT NULL DEFAULT '',
+    login_addr VARCHAR(68) NOT NULL,
+    amount BIGINT NOT NULL DEFAULT 0,
+    ref VARCHAR(160) NOT NULL DEFAULT '',
+    height BIGINT NOT NULL DEFAULT 0,
+    "timestamp" BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (seq)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_entry_idx ON ledger_entries (coin, kind, reason, ref, login_addr);
+CREATE INDEX IF NOT EXISTS ledger_entries_coin_seq ON ledger_entries (coin, seq);
+CREATE INDEX IF NOT EXISTS ledger_entries_login_seq ON ledger_entries (coin, login_addr, seq);
+
+-- The ledger is append-only, a wrong entry is corrected by a compensation.
+CREATE OR REPLACE FUNCTION ledger_entries_append_only() RETURNS trigger AS $$
+    BEGIN RAISE EXCEPTION 'ledger_entries is append-only'; END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_no_update ON ledger_entries;
+CREATE TRIGGER ledger_entries_no_update BEFORE UPDATE ON ledger_entries
+    FOR EACH ROW EXECUTE PROCEDURE ledger_entries_append_only();
+DROP TRIGGER IF EXISTS ledger_entries_no_delete ON ledger_entries;
+CREATE TRIGGER ledger_entries_no_delete BEFORE DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE PROCEDURE ledger_entries_append_only();
+
+CREATE TABLE IF NOT EXISTS ledger_cursors (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    sink VARCHAR(50) NOT NULL,
+    seq BIGINT NOT NULL DEFAULT 0,
+    update_time TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (coin, sink)
+);
+
+-- MySQL's ON UPDATE current_timestamp()
+CREATE OR REPLACE FUNCTION touch_update_time() RETURNS trigger AS $$
+    BEGIN NEW.update_time = CURRENT_TIMESTAMP; RETURN NEW; END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_cursors_touch ON ledger_cursors;
+CREATE TRIGGER ledger_cursors_touch BEFORE UPDATE ON ledger_cursors
+    FOR EACH ROW EXECUTE PROCEDURE touch_update_time();
+
+
+-- Sequence numbers are unsigned 64 bits
+CREATE TABLE IF NOT EXISTS share_journal (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    journal_id VARCHAR(32) NOT NULL,
+    seq NUMERIC(20,0) NOT NULL,
+    PRIMARY KEY (coin, journal_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS round_shares (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    round_height BIGINT NOT NULL,
+    nonce VARCHAR(100) NOT NULL,
+    login_addr VARCHAR(50) NOT NULL,
+    shares BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (coin, round_height, nonce, login_addr)
+);
+
+CREATE TABLE IF NOT EXISTS payout_reports (
+    id BIGSERIAL NOT NULL,
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    state VARCHAR(10) NOT NULL DEFAULT 'pending',
+    recipients INTEGER NOT NULL DEFAULT 0,
+    amount BIGINT NOT NULL DEFAULT 0,
+    tx_fee BIGINT NOT NULL DEFAULT 0,
+    pool_balance BIGINT NOT NULL DEFAULT 0,
+    post_balance BIGINT NOT NULL DEFAULT 0,
+    report TEXT NULL DEFAULT NULL,
+    approved_by VARCHAR(30) NULL DEFAULT NULL,
+    "timestamp" BIGINT NOT NULL DEFAULT 0,
+    insert_time TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS payout_reports_coin_state ON payout_reports (coin, state);
+
+
+CREATE TABLE IF NOT EXISTS compensations (
+    id BIGSERIAL NOT NULL,
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    "key" VARCHAR(100) NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'correction',
+    state VARCHAR(10) NOT NULL DEFAULT 'pending',
+    reason VARCHAR(300) NOT NULL DEFAULT '',
+    miners INTEGER NOT NULL DEFAULT 0,
+    total BIGINT NOT NULL DEFAULT 0,
+    applied BIGINT NOT NULL DEFAULT 0,
+    created_by VARCHAR(30) NULL DEFAULT NULL,
+    approved_by VARCHAR(30) NULL DEFAULT NULL,
+    "timestamp" BIGINT NOT NULL DEFAULT 0,
+    insert_time TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS compensations_coin_key ON compensations (coin, "key");
+
+CREATE TABLE IF NOT EXISTS compensation_items (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    idem_key VARCHAR(160) NOT NULL,
+    compensation_id BIGINT NOT NULL,
+    login_addr VARCHAR(50) NOT NULL,
+    amount BIGINT NOT NULL DEFAULT 0,
+    state VARCHAR(10) NOT NULL DEFAULT 'pending',
+    "timestamp" BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (coin, idem_key)
+);
+CREATE INDEX IF NOT EXISTS compensation_items_compensation_idx ON compensation_items (compensation_id, state);
+CREATE INDEX IF NOT EXISTS compensation_items_login_idx ON compensation_items (login_addr);
+
+
+CREATE TABLE IF NOT EXISTS {{logTable}} (
+    id BIGSERIAL NOT NULL,
+    msg_type INTEGER NOT NULL DEFAULT 0,
+    msg_err INTEGER NULL DEFAULT NULL,
+    "where" VARCHAR(20) NOT NULL DEFAULT '',
+    round_height BIGINT NULL DEFAULT 0,
+    height BIGINT NULL DEFAULT 0,
+    addr VARCHAR(50) NOT NULL,
+    addr2 VARCHAR(50) NULL DEFAULT NULL,
+    msg VARCHAR(700) NULL DEFAULT '',
+    insert_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS {{logTable}}_time_idx ON {{logTable}} (insert_time);
+
+
+CREATE TABLE IF NOT EXISTS miner_sub (
+    coin VARCHAR(30) NOT NULL,
+    login_addr VARCHAR(68) NOT NULL DEFAULT '',
+    sub_addr VARCHAR(68) NOT NULL DEFAULT '',
+    weight INTEGER DEFAULT 0,
+    PRIMARY KEY (coin, login_addr, sub_addr)
+);
+
+
+CREATE TABLE IF NOT EXISTS inbound_id (
+    coin VARCHAR(20) NOT NULL,
+    id VARCHAR(68) NOT NULL,
+    rule VARCHAR(20) DEFAULT NULL,
+    "desc" BYTEA DEFAULT '',
+    PRIMARY KEY (coin, id)
+);
+
+
+CREATE TABLE IF NOT EXISTS inbound_ip (
+    coin VARCHAR(20) NOT NULL,
+    ip VARCHAR(50) NOT NULL,
+    rule VARCHAR(20) DEFAULT NULL,
+    "desc" BYTEA DEFAULT '',
+    PRIMARY KEY (coin, ip)
+);
+
+CREATE TABLE IF NOT EXISTS ban_whitelist (
+    coin VARCHAR(20) NULL DEFAULT NULL,
+    ip_addr VARCHAR(50) NULL DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS account (
+    id VARCHAR(30) NOT NULL DEFAULT '',
+    password VARCHAR(255) DEFAULT NULL,
+    access VARCHAR(200) DEFAULT '',
+    PRIMARY KEY (id)
+);
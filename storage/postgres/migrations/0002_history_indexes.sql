@@ -0,0 +1,3 @@
+-- Block and payment history pages filtered by state
+CREATE INDEX IF NOT EXISTS blocks_coin_state_height_idx ON blocks (coin, state, height);
+CREATE INDEX IF NOT EXISTS payments_all_coin_state_seq_idx ON payments_all (coin, state, seq);
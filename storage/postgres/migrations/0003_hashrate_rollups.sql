@@ -0,0 +1,11 @@
+-- Pool and miner hashrate samples summed per 1m, 1h and 1d bucket for the charts
+CREATE TABLE IF NOT EXISTS hashrate_rollups (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    login_addr VARCHAR(68) NOT NULL DEFAULT '',
+    bucket INTEGER NOT NULL,
+    "time" BIGINT NOT NULL,
+    hashrate_sum BIGINT NOT NULL DEFAULT 0,
+    samples INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (coin, login_addr, bucket, "time")
+);
+CREATE INDEX IF NOT EXISTS hashrate_rollups_bucket_time_idx ON hashrate_rollups (bucket, "time");
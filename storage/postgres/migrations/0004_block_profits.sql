@@ -0,0 +1,19 @@
+-- Revenue of each matured block and how it was split, in Shannon
+CREATE TABLE IF NOT EXISTS block_profits (
+    seq BIGSERIAL NOT NULL,
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    round_height BIGINT NOT NULL,
+    height BIGINT NOT NULL,
+    hash VARCHAR(100) NOT NULL,
+    uncle SMALLINT NOT NULL DEFAULT 0,
+    revenue BIGINT NOT NULL DEFAULT 0,
+    miners_profit BIGINT NOT NULL DEFAULT 0,
+    pool_profit BIGINT NOT NULL DEFAULT 0,
+    donation BIGINT NOT NULL DEFAULT 0,
+    tx_fees BIGINT NOT NULL DEFAULT 0,
+    uncle_bonus BIGINT NOT NULL DEFAULT 0,
+    "timestamp" BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (seq)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS block_profits_block_idx ON block_profits (coin, round_height, hash);
+CREATE INDEX IF NOT EXISTS block_profits_coin_seq_idx ON block_profits (coin, seq);
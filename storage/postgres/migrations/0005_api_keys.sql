@@ -0,0 +1,18 @@
+-- Keys of the scripts and dashboards calling the admin API, only the SHA-256 of a key is stored
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL NOT NULL,
+    name VARCHAR(50) NOT NULL DEFAULT '',
+    key_hash CHAR(64) NOT NULL,
+    prefix VARCHAR(16) NOT NULL DEFAULT '',
+    role VARCHAR(20) NOT NULL DEFAULT 'read-only',
+    rate_limit INTEGER NOT NULL DEFAULT 0,
+    created_by VARCHAR(30) NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL DEFAULT 0,
+    last_used BIGINT NOT NULL DEFAULT 0,
+    revoked SMALLINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_key_hash_idx ON api_keys (key_hash);
+
+-- Role of the admin accounts, the existing ones keep full access
+ALTER TABLE account ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'admin';
@@ -0,0 +1,10 @@
+-- Round-off dust of the block rewards not credited yet, in wei, with unlocker.remainderTo
+CREATE TABLE IF NOT EXISTS reward_remainders (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    wei NUMERIC(38,0) NOT NULL DEFAULT 0,
+    height BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (coin)
+);
+
+-- Exact wei of the block credits, before they are rounded to Shannon
+ALTER TABLE ledger_entries ADD COLUMN IF NOT EXISTS amount_wei NUMERIC(38,0) NULL;
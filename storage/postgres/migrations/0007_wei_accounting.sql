@@ -0,0 +1,8 @@
+-- Exact balance of each miner in wei, the one paid with mysql.weiAccounting
+ALTER TABLE miner_info ADD COLUMN IF NOT EXISTS balance_wei NUMERIC(38,0) NOT NULL DEFAULT 0;
+
+-- Balances from before wei accounting, in whole Shannon
+UPDATE miner_info SET balance_wei = balance::NUMERIC * 1000000000 WHERE balance_wei = 0 AND balance <> 0;
+
+-- Exact wei of each block credit
+ALTER TABLE credits_balance ADD COLUMN IF NOT EXISTS amount_wei NUMERIC(38,0) NULL;
@@ -0,0 +1,5 @@
+-- Login and worker that submitted the winning share, and the finder bonus it was credited in Shannon
+ALTER TABLE blocks
+    ADD COLUMN IF NOT EXISTS finder VARCHAR(50) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS finder_worker VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS finder_bonus BIGINT NOT NULL DEFAULT 0;
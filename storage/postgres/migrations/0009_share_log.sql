@@ -0,0 +1,16 @@
+-- Every accepted share with proxy.shareLog, kept for investigations until its retention
+CREATE TABLE IF NOT EXISTS share_log (
+    seq BIGSERIAL NOT NULL,
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    login_addr VARCHAR(50) NOT NULL,
+    worker VARCHAR(100) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    diff BIGINT NOT NULL DEFAULT 0,
+    job VARCHAR(68) NOT NULL DEFAULT '',
+    height BIGINT NOT NULL DEFAULT 0,
+    kind VARCHAR(10) NOT NULL DEFAULT '',
+    "timestamp" BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (seq)
+);
+CREATE INDEX IF NOT EXISTS share_log_coin_time_idx ON share_log (coin, "timestamp");
+CREATE INDEX IF NOT EXISTS share_log_login_time_idx ON share_log (coin, login_addr, "timestamp");
@@ -0,0 +1,11 @@
+-- Nonces of the payout account with payouts.nonceManager, written before their tx is sent
+CREATE TABLE IF NOT EXISTS payout_nonces (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    address VARCHAR(50) NOT NULL,
+    nonce BIGINT NOT NULL,
+    tx_hash VARCHAR(70) NOT NULL DEFAULT '',
+    state SMALLINT NOT NULL DEFAULT 0,
+    updated_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (coin, address, nonce)
+);
+CREATE INDEX IF NOT EXISTS payout_nonces_state_idx ON payout_nonces (coin, address, state);
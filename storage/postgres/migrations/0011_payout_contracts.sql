@@ -0,0 +1,10 @@
+-- Contract payees found with payouts.contractPayees, held for review until approved
+CREATE TABLE IF NOT EXISTS payout_contracts (
+    coin VARCHAR(20) NOT NULL DEFAULT '',
+    address VARCHAR(50) NOT NULL,
+    state VARCHAR(16) NOT NULL DEFAULT 'detected',
+    updated_by VARCHAR(64) NOT NULL DEFAULT '',
+    detected_at BIGINT NOT NULL DEFAULT 0,
+    updated_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (coin, address)
+);
@@ -0,0 +1,3 @@
+-- Tx sent with each payout nonce, sent again when the node drops it: the signed payload or the
+-- eth_sendTransaction fields
+ALTER TABLE payout_nonces ADD COLUMN IF NOT EXISTS tx TEXT NULL;
@@ -0,0 +1,215 @@
+// Package postgres runs the storage layer on PostgreSQL. Its queries are written for MySQL, the
+// connections opened here translate them on the way and return rows the way the MySQL driver does.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgreSQL migrations of the schema, kept in step with the MySQL ones.
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+// MySQL returns DATETIME and TIMESTAMP columns as text in this layout, the storage layer scans them into strings.
+const timeLayout = "2006-01-02 15:04:05"
+
+// Open returns a pool of connections to the PostgreSQL database of dsn, a lib/pq connection string.
+func Open(dsn string) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(&translator{connector}), nil
+}
+
+type translator struct {
+	connector *pq.Connector
+}
+
+func (t *translator) Connect(ctx context.Context) (driver.Conn, error) {
+	c, err := t.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c}, nil
+}
+
+func (t *translator) Driver() driver.Driver {
+	return t.connector.Driver()
+}
+
+// conn translates the queries sent to a lib/pq connection.
+type conn struct {
+	driver.Conn
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	st, err := translate(query)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, st.query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, returning: st.returning}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	st, err := translate(query)
+	if err != nil {
+		return nil, err
+	}
+	if st.returning {
+		rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, st.query, args)
+		if err != nil {
+			return nil, err
+		}
+		return returned(rows)
+	}
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, st.query, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	st, err := translate(query)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, st.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{r}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	return checkValue(nv)
+}
+
+// checkValue converts the arguments the MySQL driver takes and lib/pq doesn't: bools are stored in
+// SMALLINT flags as 0 or 1 and uint64 may not fit an int64.
+func checkValue(nv *driver.NamedValue) error {
+	switch v := nv.Value.(type) {
+	case bool:
+		if v {
+			nv.Value = int64(1)
+		} else {
+			nv.Value = int64(0)
+		}
+		return nil
+	case uint64:
+		nv.Value = strconv.FormatUint(v, 10)
+		return nil
+	}
+	value, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	nv.Value = value
+	return nil
+}
+
+type stmt struct {
+	driver.Stmt
+	returning bool
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if s.returning {
+		r, err := s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return returned(r)
+	}
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	r, err := s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{r}, nil
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	return checkValue(nv)
+}
+
+// rows returns times as MySQL text.
+type rows struct {
+	driver.Rows
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	for i, v := range dest {
+		if t, ok := v.(time.Time); ok {
+			dest[i] = []byte(t.UTC().Format(timeLayout))
+		}
+	}
+	return nil
+}
+
+// result of an INSERT ... RETURNING, the first key is the last insert id like MySQL's.
+type result struct {
+	id   int64
+	rows int64
+}
+
+func (r *result) LastInsertId() (int64, error) {
+	return r.id, nil
+}
+
+func (r *result) RowsAffected() (int64, error) {
+	return r.rows, nil
+}
+
+func returned(r driver.Rows) (driver.Result, error) {
+	defer r.Close()
+	res := &result{}
+	dest := make([]driver.Value, len(r.Columns()))
+	for {
+		err := r.Next(dest)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res.rows == 0 {
+			if id, ok := dest[0].(int64); ok {
+				res.id = id
+			}
+		}
+		res.rows++
+	}
+}
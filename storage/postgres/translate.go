@@ -0,0 +1,409 @@
+package postgres
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Columns of the primary key or unique index an ON DUPLICATE KEY UPDATE of a table hits.
+var conflictKeys = map[string]string{
+	"miner_info":        "coin, login_addr",
+	"finances":          "coin",
+	"credits_immature":  "round_height, hash, login_addr",
+	"credits_balance":   "height, hash, login_addr",
+	"miner_sub":         "coin, login_addr, sub_addr",
+	"round_shares":      "coin, round_height, nonce, login_addr",
+	"ledger_cursors":    "coin, sink",
+	"hashrate_rollups":  "coin, login_addr, bucket, \"time\"",
+	"reward_remainders": "coin",
+	"payout_nonces":     "coin, address, nonce",
+	"payout_contracts":  "coin, address",
+}
+
+// Serial key of the tables whose inserts are asked for LastInsertId.
+var serialKeys = map[string]string{
+	"payments_all":   "seq",
+	"payout_reports": "id",
+	"compensations":  "id",
+	"ledger_entries": "seq",
+	"api_keys":       "id",
+}
+
+// Queries starting with it are PostgreSQL already and sent as they are.
+const nativePrefix = "/* native */ "
+
+// Native marks a query written for PostgreSQL, like the migrations, so it isn't translated.
+func Native(query string) string {
+	return nativePrefix + query
+}
+
+type tokenKind int
+
+const (
+	tSpace tokenKind = iota
+	tWord
+	tNumber
+	tIdent
+	tString
+	tParam
+	tPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func (t token) is(word string) bool {
+	return t.kind == tWord && strings.EqualFold(t.text, word)
+}
+
+func (t token) isPunct(p string) bool {
+	return t.kind == tPunct && t.text == p
+}
+
+// statement is a query translated to PostgreSQL.
+type statement struct {
+	query string
+	// The INSERT returns the serial key of its rows, for LastInsertId
+	returning bool
+}
+
+// translate rewrites a query of the storage layer, written for MySQL, into PostgreSQL: placeholders
+// are numbered, identifiers quoted the standard way and the MySQL-only constructs the queries use are
+// replaced by their PostgreSQL counterparts.
+func translate(query string) (*statement, error) {
+	if strings.HasPrefix(query, nativePrefix) {
+		return &statement{query: strings.TrimPrefix(query, nativePrefix)}, nil
+	}
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	st := &statement{}
+	switch strings.ToUpper(firstWord(tokens)) {
+	case "INSERT":
+		tokens, st.returning, err = translateInsert(tokens)
+		if err != nil {
+			return nil, err
+		}
+	case "UPDATE", "DELETE":
+		tokens = dropLimit(tokens)
+	}
+	tokens = translateIf(tokens)
+
+	var sql strings.Builder
+	params := 0
+	for i, t := range tokens {
+		switch {
+		case t.kind == tParam:
+			params++
+			sql.WriteString("$" + strconv.Itoa(params))
+		case t.is("IFNULL"):
+			sql.WriteString("COALESCE")
+		case t.is("LIKE"):
+			// MySQL compares case insensitively in the pool's collation.
+			sql.WriteString("ILIKE")
+		case t.is("SIGNED") && prev(tokens, i).is("AS"):
+			sql.WriteString("BIGINT")
+		default:
+			sql.WriteString(t.text)
+		}
+	}
+	st.query = sql.String()
+	return st, nil
+}
+
+func tokenize(query string) ([]token, error) {
+	var tokens []token
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			for i < len(runes) && unicode.IsSpace(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tSpace, string(runes[start:i])})
+		case c == '_' || unicode.IsLetter(c):
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tWord, string(runes[start:i])})
+		case unicode.IsDigit(c):
+			for i < len(runes) && (runes[i] == '.' || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tNumber, string(runes[start:i])})
+		case c == '`':
+			i++
+			for i < len(runes) && runes[i] != '`' {
+				i++
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated identifier in %q", query)
+			}
+			i++
+			tokens = append(tokens, token{tIdent, `"` + string(runes[start+1:i-1]) + `"`})
+		case c == '\'' || c == '"':
+			value, n, err := unquote(runes[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%v in %q", err, query)
+			}
+			i += n
+			tokens = append(tokens, token{tString, "'" + strings.Replace(value, "'", "''", -1) + "'"})
+		case c == '?':
+			i++
+			tokens = append(tokens, token{tParam, "?"})
+		default:
+			i++
+			tokens = append(tokens, token{tPunct, string(c)})
+		}
+	}
+	return tokens, nil
+}
+
+// unquote reads the MySQL string literal at the start of runes, quoted with ' or ", and returns its
+// value and length.
+func unquote(runes []rune) (string, int, error) {
+	quote := runes[0]
+	var value strings.Builder
+	for i := 1; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\\' && i+1 < len(runes):
+			i++
+			switch runes[i] {
+			case 'n':
+				value.WriteRune('\n')
+			case 't':
+				value.WriteRune('\t')
+			case 'r':
+				value.WriteRune('\r')
+			case '0':
+				value.WriteRune(0)
+			default:
+				value.WriteRune(runes[i])
+			}
+		case c == quote && i+1 < len(runes) && runes[i+1] == quote:
+			i++
+			value.WriteRune(quote)
+		case c == quote:
+			return value.String(), i + 1, nil
+		default:
+			value.WriteRune(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func firstWord(tokens []token) string {
+	for _, t := range tokens {
+		if t.kind == tWord {
+			return t.text
+		}
+		if t.kind != tSpace {
+			break
+		}
+	}
+	return ""
+}
+
+// prev returns the token before i that isn't white space.
+func prev(tokens []token, i int) token {
+	for i--; i >= 0; i-- {
+		if tokens[i].kind != tSpace {
+			return tokens[i]
+		}
+	}
+	return token{}
+}
+
+// next returns the index of the token after i that isn't white space, len(tokens) if there's none.
+func next(tokens []token, i int) int {
+	for i++; i < len(tokens); i++ {
+		if tokens[i].kind != tSpace {
+			return i
+		}
+	}
+	return len(tokens)
+}
+
+func name(t token) string {
+	if t.kind == tIdent {
+		return strings.Trim(t.text, `"`)
+	}
+	return t.text
+}
+
+// translateInsert turns INSERT IGNORE and ON DUPLICATE KEY UPDATE into ON CONFLICT clauses, and
+// returns the serial key of tables whose inserts need it.
+func translateInsert(tokens []token) ([]token, bool, error) {
+	ignore := false
+	table := ""
+	out := make([]token, 0, len(tokens))
+	depth := 0
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			depth--
+		case t.is("IGNORE") && len(table) == 0:
+			ignore = true
+			i = next(tokens, i) - 1
+			continue
+		case t.is("INTO") && len(table) == 0:
+			if j := next(tokens, i); j < len(tokens) {
+				table = name(tokens[j])
+			}
+		case t.is("VALUE") && depth == 0:
+			t.text = "VALUES"
+		case t.is("ON") && depth == 0 && isDuplicateKeyUpdate(tokens, i):
+			keys, ok := conflictKeys[table]
+			if !ok {
+				return nil, false, fmt.Errorf("no conflict key known for table %v", table)
+			}
+			out = append(out, token{tWord, "ON CONFLICT (" + keys + ") DO UPDATE SET"})
+			for j := 0; j < 3; j++ {
+				i = next(tokens, i)
+			}
+			out = append(out, updateSet(tokens[i+1:], table)...)
+			i = len(tokens)
+			continue
+		}
+		out = append(out, t)
+	}
+	if ignore {
+		out = append(out, token{tWord, " ON CONFLICT DO NOTHING"})
+	}
+	key, returning := serialKeys[table]
+	if returning {
+		out = append(out, token{tWord, " RETURNING " + key})
+	}
+	return out, returning, nil
+}
+
+func isDuplicateKeyUpdate(tokens []token, i int) bool {
+	for _, word := range []string{"DUPLICATE", "KEY", "UPDATE"} {
+		i = next(tokens, i)
+		if i == len(tokens) || !tokens[i].is(word) {
+			return false
+		}
+	}
+	return true
+}
+
+// Words of the update clauses that aren't columns.
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "NULL": true, "IS": true, "IN": true,
+	"TRUE": true, "FALSE": true, "CASE": true, "WHEN": true, "THEN": true, "ELSE": true, "END": true,
+}
+
+// updateSet translates the assignments of ON DUPLICATE KEY UPDATE: VALUES(col) is the excluded row
+// and the other columns on the right are the existing row, which PostgreSQL wants qualified.
+func updateSet(tokens []token, table string) []token {
+	out := make([]token, 0, len(tokens))
+	depth := 0
+	lhs := true
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			depth--
+		case t.isPunct(",") && depth == 0:
+			lhs = true
+		case t.isPunct("=") && depth == 0 && lhs:
+			lhs = false
+		case t.is("VALUES") && next(tokens, i) < len(tokens) && tokens[next(tokens, i)].isPunct("("):
+			open := next(tokens, i)
+			col := next(tokens, open)
+			closing := next(tokens, col)
+			if closing < len(tokens) && tokens[closing].isPunct(")") {
+				out = append(out, token{tWord, "EXCLUDED." + tokens[col].text})
+				i = closing
+				continue
+			}
+		case (t.kind == tWord || t.kind == tIdent) && !lhs && !keywords[strings.ToUpper(t.text)]:
+			n := next(tokens, i)
+			if !prev(tokens, i).isPunct(".") && (n == len(tokens) || !(tokens[n].isPunct("(") || tokens[n].isPunct("."))) {
+				t.text = table + "." + t.text
+			}
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// dropLimit removes the LIMIT of an UPDATE or DELETE, which PostgreSQL doesn't have. The storage
+// layer only limits statements matching a single row.
+func dropLimit(tokens []token) []token {
+	end := len(tokens)
+	for end > 0 && tokens[end-1].kind == tSpace {
+		end--
+	}
+	if end < 3 || tokens[end-1].kind != tNumber {
+		return tokens
+	}
+	if !prev(tokens, end-1).is("LIMIT") {
+		return tokens
+	}
+	i := end - 2
+	for tokens[i].kind == tSpace {
+		i--
+	}
+	for i > 0 && tokens[i-1].kind == tSpace {
+		i--
+	}
+	return tokens[:i]
+}
+
+// translateIf rewrites IF(cond, a, b) as CASE WHEN cond THEN a ELSE b END.
+func translateIf(tokens []token) []token {
+	out := make([]token, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		open := next(tokens, i)
+		if !tokens[i].is("IF") || open == len(tokens) || !tokens[open].isPunct("(") {
+			out = append(out, tokens[i])
+			continue
+		}
+		var args [][]token
+		depth := 0
+		start := open + 1
+		closing := len(tokens)
+		for j := open + 1; j < len(tokens); j++ {
+			t := tokens[j]
+			if t.isPunct("(") {
+				depth++
+			} else if t.isPunct(")") && depth > 0 {
+				depth--
+			} else if t.isPunct(")") || (t.isPunct(",") && depth == 0) {
+				args = append(args, tokens[start:j])
+				start = j + 1
+				if t.isPunct(")") {
+					closing = j
+					break
+				}
+			}
+		}
+		if len(args) != 3 {
+			out = append(out, tokens[i])
+			continue
+		}
+		out = append(out, token{tWord, "CASE WHEN "})
+		out = append(out, translateIf(args[0])...)
+		out = append(out, token{tWord, " THEN "})
+		out = append(out, translateIf(args[1])...)
+		out = append(out, token{tWord, " ELSE "})
+		out = append(out, translateIf(args[2])...)
+		out = append(out, token{tWord, " END"})
+		i = closing
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		query, expected string
+		returning       bool
+	}{
+		{
+			"SELECT IFNULL(reward,''),`timestamp` FROM blocks WHERE coin=? AND nonce=? LIMIT ?",
+			"SELECT COALESCE(reward,''),\"timestamp\" FROM blocks WHERE coin=$1 AND nonce=$2 LIMIT $3", false,
+		},
+		{
+			"SELECT reason,CAST(SUM(amount) AS SIGNED) FROM ledger_entries WHERE login_addr like ? AND `state`=\"it's\"",
+			"SELECT reason,CAST(SUM(amount) AS BIGINT) FROM ledger_entries WHERE login_addr ILIKE $1 AND \"state\"='it''s'", false,
+		},
+		{
+			"UPDATE blocks SET `state`=?,`hash`=? WHERE state=? AND coin=? LIMIT 1",
+			"UPDATE blocks SET \"state\"=$1,\"hash\"=$2 WHERE state=$3 AND coin=$4", false,
+		},
+		{
+			"DELETE FROM share_log WHERE seq IN (SELECT seq FROM (SELECT seq FROM share_log WHERE coin=? AND `timestamp`<? ORDER BY seq LIMIT ?) AS chunk)",
+			"DELETE FROM share_log WHERE seq IN (SELECT seq FROM (SELECT seq FROM share_log WHERE coin=$1 AND \"timestamp\"<$2 ORDER BY seq LIMIT $3) AS chunk)", false,
+		},
+		{
+			"INSERT IGNORE INTO credits_blocks(height,hash,coin,reward) VALUE (?,?,?,?)",
+			"INSERT INTO credits_blocks(height,hash,coin,reward) VALUES ($1,$2,$3,$4) ON CONFLICT DO NOTHING", false,
+		},
+		{
+			"INSERT INTO payout_reports(coin,`state`) VALUE (?,?)",
+			"INSERT INTO payout_reports(coin,\"state\") VALUES ($1,$2) RETURNING id", true,
+		},
+		{
+			"INSERT INTO round_shares(`coin`,`shares`) VALUES (?,?) ON DUPLICATE KEY UPDATE `shares`=VALUES(`shares`)",
+			"INSERT INTO round_shares(\"coin\",\"shares\") VALUES ($1,$2) ON CONFLICT (coin, round_height, nonce, login_addr) DO UPDATE SET \"shares\"=EXCLUDED.\"shares\"", false,
+		},
+		{
+			"INSERT INTO miner_info(coin,login_addr,balance) VALUES (?,?,?) ON DUPLICATE KEY UPDATE balance=IF(payout_lock=0,VALUES(balance),balance),share=share+VALUES(share)",
+			"INSERT INTO miner_info(coin,login_addr,balance) VALUES ($1,$2,$3) ON CONFLICT (coin, login_addr) DO UPDATE SET " +
+				"balance=CASE WHEN miner_info.payout_lock=0 THEN EXCLUDED.balance ELSE miner_info.balance END,share=miner_info.share+EXCLUDED.share", false,
+		},
+		{
+			"INSERT INTO miner_info(coin,login_addr,balance,balance_wei) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE balance=FLOOR((balance_wei+VALUES(balance_wei))/1000000000),balance_wei=balance_wei+VALUES(balance_wei)",
+			"INSERT INTO miner_info(coin,login_addr,balance,balance_wei) VALUES ($1,$2,$3,$4) ON CONFLICT (coin, login_addr) DO UPDATE SET " +
+				"balance=FLOOR((miner_info.balance_wei+EXCLUDED.balance_wei)/1000000000),balance_wei=miner_info.balance_wei+EXCLUDED.balance_wei", false,
+		},
+		{
+			Native("CREATE TABLE t (\"where\" VARCHAR(20) DEFAULT '?')"),
+			"CREATE TABLE t (\"where\" VARCHAR(20) DEFAULT '?')", false,
+		},
+	}
+	for _, test := range tests {
+		st, err := translate(test.query)
+		if err != nil {
+			t.Errorf("Failed to translate %q: %v", test.query, err)
+			continue
+		}
+		if st.query != test.expected || st.returning != test.returning {
+			t.Errorf("Translated %q to\n%q, %v, expected\n%q, %v", test.query, st.query, st.returning, test.expected, test.returning)
+		}
+	}
+}
+
+func TestTranslateUnknownConflict(t *testing.T) {
+	if _, err := translate("INSERT INTO blocks(coin) VALUES (?) ON DUPLICATE KEY UPDATE coin=VALUES(coin)"); err == nil {
+		t.Error("Expected an upsert of a table without a known key to fail")
+	}
+}
+
+func TestCheckValue(t *testing.T) {
+	nv := &driver.NamedValue{Value: true}
+	if err := checkValue(nv); err != nil || nv.Value != int64(1) {
+		t.Errorf("Expected true as 1, got %v, %v", nv.Value, err)
+	}
+	nv = &driver.NamedValue{Value: uint64(1) << 63}
+	if err := checkValue(nv); err != nil || nv.Value != "9223372036854775808" {
+		t.Errorf("Expected a large uint64 as text, got %v, %v", nv.Value, err)
+	}
+	nv = &driver.NamedValue{Value: 5}
+	if err := checkValue(nv); err != nil || nv.Value != int64(5) {
+		t.Errorf("Expected an int as int64, got %v, %v", nv.Value, err)
+	}
+}
@@ -0,0 +1,89 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/redis.v3"
+)
+
+// RoundKey is the shares of a round left in Redis.
+type RoundKey struct {
+	Height int64
+	Nonce  string
+}
+
+// ArchivedSet is the members trimmed from a sorted set, with their scores.
+type ArchivedSet struct {
+	Key     string    `json:"key"`
+	Members []redis.Z `json:"members"`
+}
+
+// Sorted sets scored by block height that nothing trims, their blocks are also kept in MySQL.
+var heightSets = [][]interface{}{
+	{"blocks", "matured"},
+	{"credits", "all"},
+}
+
+// ScanRounds returns the rounds with shares in Redis, at most maxKeys keys are scanned. The current
+// round isn't one of them.
+func (r *RedisClient) ScanRounds(maxKeys int64) ([]*RoundKey, error) {
+	prefix := r.formatKey("shares", "round")
+	var rounds []*RoundKey
+	c, scanned := int64(0), int64(0)
+	for {
+		var keys []string
+		var err error
+		c, keys, err = r.client.Scan(c, prefix+"*", 1000).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			scanned++
+			// shares:round<height>:<nonce>
+			parts := strings.SplitN(strings.TrimPrefix(key, prefix), ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			height, err := strconv.ParseInt(parts[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			rounds = append(rounds, &RoundKey{Height: height, Nonce: parts[1]})
+		}
+		if c == 0 || scanned >= maxKeys {
+			break
+		}
+	}
+	return rounds, nil
+}
+
+// TrimHeightSets removes the members of the height-scored stats sets up to maxHeight, and returns
+// them.
+func (r *RedisClient) TrimHeightSets(maxHeight int64) ([]*ArchivedSet, error) {
+	max := strconv.FormatInt(maxHeight, 10)
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	var ranges []*redis.ZSliceCmd
+	_, err := tx.Exec(func() error {
+		for _, set := range heightSets {
+			key := r.formatKey(set...)
+			ranges = append(ranges, tx.ZRangeByScoreWithScores(key, redis.ZRangeByScore{Min: "-inf", Max: max}))
+			tx.ZRemRangeByScore(key, "-inf", max)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var sets []*ArchivedSet
+	for i, set := range heightSets {
+		members := ranges[i].Val()
+		if len(members) == 0 {
+			continue
+		}
+		sets = append(sets, &ArchivedSet{Key: r.formatKey(set...), Members: members})
+	}
+	return sets, nil
+}
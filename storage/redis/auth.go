@@ -0,0 +1,31 @@
+package redis
+
+import (
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// SetAuthChallenge stores the challenge login has to sign, replacing an earlier one.
+func (r *RedisClient) SetAuthChallenge(login, challenge string, ttl time.Duration) error {
+	return r.client.Set(r.formatKey("auth", "challenge", login), challenge, ttl).Err()
+}
+
+// TakeAuthChallenge returns the challenge of login and forgets it, so it is answered once. "" if there is none.
+func (r *RedisClient) TakeAuthChallenge(login string) (string, error) {
+	key := r.formatKey("auth", "challenge", login)
+	tx := r.client.Multi()
+	defer tx.Close()
+	cmds, err := tx.Exec(func() error {
+		tx.Get(key)
+		tx.Del(key)
+		return nil
+	})
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return cmds[0].(*redis.StringCmd).Val(), nil
+}
@@ -0,0 +1,129 @@
+package redis
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gopkg.in/redis.v3"
+)
+
+// Kinds of bans
+const (
+	BanKindIP    = "ip"
+	BanKindLogin = "login"
+)
+
+type Ban struct {
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+	// Unix time the ban ends
+	Until int64 `json:"until"`
+}
+
+// Bans are kept in a sorted set of kind/value scored by the time they end, and their reasons in a hash.
+func banMember(kind, value string) string {
+	return kind + "/" + value
+}
+
+// BanPayload is the data of a pub/sub message about the ban of value. Messages are split on colons,
+// which IPv6 addresses have.
+func BanPayload(kind, value string) string {
+	return banMember(kind, url.QueryEscape(value))
+}
+
+func ParseBanPayload(data string) (string, string, bool) {
+	parts := strings.SplitN(data, "/", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	value, err := url.QueryUnescape(parts[1])
+	if err != nil {
+		return "", "", false
+	}
+	return parts[0], value, true
+}
+
+// WriteBan records a ban of value until the unix time until, replacing any ban of it.
+func (r *RedisClient) WriteBan(kind, value, reason string, until int64) error {
+	member := banMember(kind, value)
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		tx.ZAdd(r.formatKey("bans"), redis.Z{Score: float64(until), Member: member})
+		tx.HSet(r.formatKey("bans", "reasons"), member, reason)
+		return nil
+	})
+	return err
+}
+
+// LiftBan removes the ban of value, false if there was none.
+func (r *RedisClient) LiftBan(kind, value string) (bool, error) {
+	member := banMember(kind, value)
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	cmds, err := tx.Exec(func() error {
+		tx.ZRem(r.formatKey("bans"), member)
+		tx.HDel(r.formatKey("bans", "reasons"), member)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return cmds[0].(*redis.IntCmd).Val() > 0, nil
+}
+
+// GetBans returns the bans still running at the unix time now, and drops the ended ones.
+func (r *RedisClient) GetBans(now int64) ([]*Ban, error) {
+	members, err := r.client.ZRangeWithScores(r.formatKey("bans"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	var bans []*Ban
+	var ended []string
+	for _, m := range members {
+		member := m.Member.(string)
+		if int64(m.Score) <= now {
+			ended = append(ended, member)
+			continue
+		}
+		parts := strings.SplitN(member, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		bans = append(bans, &Ban{Kind: parts[0], Value: parts[1], Until: int64(m.Score)})
+	}
+
+	if len(bans) > 0 {
+		fields := make([]string, len(bans))
+		for i, ban := range bans {
+			fields[i] = banMember(ban.Kind, ban.Value)
+		}
+		reasons, err := r.client.HMGet(r.formatKey("bans", "reasons"), fields...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i, reason := range reasons {
+			if reason != nil {
+				bans[i].Reason = reason.(string)
+			}
+		}
+	}
+
+	if len(ended) > 0 {
+		tx := r.client.Multi()
+		defer tx.Close()
+		_, err := tx.Exec(func() error {
+			tx.ZRemRangeByScore(r.formatKey("bans"), "-inf", strconv.FormatInt(now, 10))
+			tx.HDel(r.formatKey("bans", "reasons"), ended...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bans, nil
+}
@@ -0,0 +1,33 @@
+package redis
+
+import (
+	"strconv"
+)
+
+// FlagExchangeAddress marks login as an exchange deposit address flagged at ts. It returns false if it already was.
+func (r *RedisClient) FlagExchangeAddress(login string, ts int64) (bool, error) {
+	return r.client.HSetNX(r.formatKey("exchanges"), login, strconv.FormatInt(ts, 10)).Result()
+}
+
+// UnflagExchangeAddress removes login from the exchange deposit addresses.
+func (r *RedisClient) UnflagExchangeAddress(login string) error {
+	return r.client.HDel(r.formatKey("exchanges"), login).Err()
+}
+
+// GetExchangeAddresses returns the exchange deposit addresses and when each was flagged.
+func (r *RedisClient) GetExchangeAddresses() (map[string]int64, error) {
+	raw, err := r.client.HGetAllMap(r.formatKey("exchanges")).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(raw))
+	for login, ts := range raw {
+		result[login], _ = strconv.ParseInt(ts, 10, 64)
+	}
+	return result, nil
+}
+
+// IsExchangeAddress tells whether login is flagged as an exchange deposit address.
+func (r *RedisClient) IsExchangeAddress(login string) (bool, error) {
+	return r.client.HExists(r.formatKey("exchanges"), login).Result()
+}
@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"encoding/json"
+
+	"gopkg.in/redis.v3"
+)
+
+// Modules reporting their health
+const (
+	HealthUnlocker = "unlocker"
+	HealthPayer    = "payer"
+)
+
+// ModuleHealth is the state of the unlocker or the payer after its last pass, for the admin API.
+type ModuleHealth struct {
+	// Stopped after a critical error until restarted
+	Halted   bool   `json:"halted"`
+	LastFail string `json:"lastFail,omitempty"`
+	// Unix times of the last pass and of the last one that completed, 0 before one did
+	LastRun  int64 `json:"lastRun"`
+	LastPass int64 `json:"lastPass"`
+	// Payees of the last payout run, for the payer
+	Queue int `json:"queue,omitempty"`
+}
+
+// SetModuleHealth records the health of a module.
+func (r *RedisClient) SetModuleHealth(module string, h *ModuleHealth) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(r.formatKey("health", module), string(data), 0).Err()
+}
+
+// GetModuleHealth returns the last health a module recorded, nil if it never ran.
+func (r *RedisClient) GetModuleHealth(module string) (*ModuleHealth, error) {
+	data, err := r.client.Get(r.formatKey("health", module)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var h ModuleHealth
+	if err := json.Unmarshal([]byte(data), &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
@@ -0,0 +1,30 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/redis.v3"
+)
+
+// writeJournalSeq records, in the transaction of a share, that seq of journalId was written.
+func (r *RedisClient) writeJournalSeq(tx *redis.Multi, journalId string, seq uint64) {
+	if seq == 0 {
+		return
+	}
+	tx.ZAdd(r.formatKey("journal", journalId), redis.Z{Score: float64(seq), Member: strconv.FormatUint(seq, 10)})
+}
+
+// JournalApplied tells whether seq of journalId was written.
+func (r *RedisClient) JournalApplied(journalId string, seq uint64) (bool, error) {
+	err := r.client.ZScore(r.formatKey("journal", journalId), strconv.FormatUint(seq, 10)).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CompactJournal forgets the seqs of journalId below seq, all of them are committed in the journal.
+func (r *RedisClient) CompactJournal(journalId string, seq uint64) (int64, error) {
+	return r.client.ZRemRangeByScore(r.formatKey("journal", journalId), "-inf", fmt.Sprint("(", seq)).Result()
+}
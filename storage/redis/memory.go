@@ -0,0 +1,173 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/redis.v3"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+// KeyFamilyMemory is the estimated memory of the keys of one namespace, e.g. "shares:round".
+type KeyFamilyMemory struct {
+	Family  string `json:"family"`
+	Keys    int64  `json:"keys"`
+	Sampled int64  `json:"sampled"`
+	// Measured on the sampled keys, extrapolated to all keys of the family
+	SampledBytes int64 `json:"sampledBytes"`
+	Bytes        int64 `json:"bytes"`
+}
+
+type MemoryReport struct {
+	Timestamp  int64 `json:"timestamp"`
+	UsedMemory int64 `json:"usedMemory"`
+	// 0 if Redis has no memory limit
+	MaxMemory   int64 `json:"maxMemory"`
+	ScannedKeys int64 `json:"scannedKeys"`
+	// False if the scan stopped at the key limit, families are then underestimated
+	Complete bool               `json:"complete"`
+	Families []*KeyFamilyMemory `json:"families"`
+}
+
+// MEMORY USAGE through a script, the client predates the command.
+const memoryUsageScript = "return redis.call('MEMORY', 'USAGE', KEYS[1], 'SAMPLES', ARGV[1])"
+
+// Elements sampled by MEMORY USAGE in aggregate values.
+const memoryUsageSamples = "5"
+
+// MemoryUsage scans the pool's keys and measures one key in sampleEvery per family, at most maxKeys keys are scanned.
+func (r *RedisClient) MemoryUsage(sampleEvery int64, maxKeys int64) (*MemoryReport, error) {
+	report := &MemoryReport{Timestamp: util.MakeTimestamp() / 1000, Complete: true}
+	info, err := r.client.Info().Result()
+	if err != nil {
+		return nil, err
+	}
+	report.UsedMemory = parseInfoInt(info, "used_memory")
+	report.MaxMemory = parseInfoInt(info, "maxmemory")
+
+	families := make(map[string]*KeyFamilyMemory)
+	c := int64(0)
+	for {
+		var keys []string
+		c, keys, err = r.client.Scan(c, r.formatKey("*"), 1000).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			name := r.keyFamily(key)
+			f, ok := families[name]
+			if !ok {
+				f = &KeyFamilyMemory{Family: name}
+				families[name] = f
+			}
+			f.Keys++
+			report.ScannedKeys++
+			if (f.Keys-1)%sampleEvery != 0 {
+				continue
+			}
+			bytes, err := r.keyMemoryUsage(key)
+			if err != nil {
+				return nil, err
+			}
+			f.Sampled++
+			f.SampledBytes += bytes
+		}
+		if c == 0 {
+			break
+		}
+		if report.ScannedKeys >= maxKeys {
+			report.Complete = false
+			break
+		}
+	}
+
+	for _, f := range families {
+		if f.Sampled > 0 {
+			f.Bytes = f.SampledBytes * f.Keys / f.Sampled
+		}
+		report.Families = append(report.Families, f)
+	}
+	sort.Slice(report.Families, func(i, j int) bool { return report.Families[i].Bytes > report.Families[j].Bytes })
+	return report, nil
+}
+
+func (r *RedisClient) keyMemoryUsage(key string) (int64, error) {
+	res, err := r.client.Eval(memoryUsageScript, []string{key}, []string{memoryUsageSamples}).Result()
+	if err == redis.Nil {
+		// Expired since the scan
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	bytes, _ := res.(int64)
+	return bytes, nil
+}
+
+// keyFamily groups keys by their first segment after the prefix. The second one is kept
+// without trailing digits unless it is a login, so "shares:round123:0x.." is "shares:round".
+func (r *RedisClient) keyFamily(key string) string {
+	parts := strings.SplitN(strings.TrimPrefix(key, r.prefix+":"), ":", 3)
+	if len(parts) == 1 || strings.HasPrefix(parts[1], "0x") {
+		return parts[0]
+	}
+	second := strings.TrimRight(parts[1], "0123456789")
+	if len(second) == 0 {
+		return parts[0]
+	}
+	return parts[0] + ":" + second
+}
+
+func parseInfoInt(info string, field string) int64 {
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, field+":") {
+			n, _ := strconv.ParseInt(strings.TrimSpace(line[len(field)+1:]), 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// WriteMemoryCharts keeps the used memory and the size of each family for two days.
+func (r *RedisClient) WriteMemoryCharts(report *MemoryReport) error {
+	sizes := make(map[string]int64, len(report.Families))
+	for _, f := range report.Families {
+		sizes[f.Family] = f.Bytes
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"x":        report.Timestamp,
+		"used":     report.UsedMemory,
+		"max":      report.MaxMemory,
+		"families": sizes,
+	})
+	if err != nil {
+		return err
+	}
+	key := r.formatKey("charts", "redismemory")
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err = tx.Exec(func() error {
+		tx.ZRemRangeByScore(key, "-inf", fmt.Sprint("(", report.Timestamp-172800))
+		tx.ZAdd(key, redis.Z{Score: float64(report.Timestamp), Member: string(data)})
+		return nil
+	})
+	return err
+}
+
+// GetMemoryCharts returns the samples of WriteMemoryCharts, oldest first.
+func (r *RedisClient) GetMemoryCharts() ([]json.RawMessage, error) {
+	members, err := r.client.ZRange(r.formatKey("charts", "redismemory"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	charts := make([]json.RawMessage, 0, len(members))
+	for _, m := range members {
+		charts = append(charts, json.RawMessage(m))
+	}
+	return charts, nil
+}
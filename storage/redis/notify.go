@@ -0,0 +1,16 @@
+package redis
+
+import (
+	"time"
+)
+
+// MarkNotified records that a miner was notified of kind about id, e.g. a worker. It returns false
+// if that was already recorded within ttl, so the notification isn't sent again.
+func (r *RedisClient) MarkNotified(kind, id string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(r.formatKey("notified", kind, id), time.Now().Unix(), ttl).Result()
+}
+
+// ClearNotified forgets a notification of kind about id once it is resolved, e.g. the worker is back.
+func (r *RedisClient) ClearNotified(kind, id string) error {
+	return r.client.Del(r.formatKey("notified", kind, id)).Err()
+}
@@ -10,6 +10,10 @@ const (
 	ChannelUnlocker = "unlocker"
 	ChannelApi 		= "api"
 	ChannelPayout 	= "payout"
+	ChannelFeature 	= "feature"
+	ChannelPush 	= "push"
+	ChannelLog 		= "log"
+	ChannelConfig 	= "config"
 )
 
 const (
@@ -17,14 +21,31 @@ const (
 	OpcodeLoadIP 	= "inbound-ip"
 	OpcodeWhiteList = "white-list"
 	OpcodeMinerSub 	= "miner-sub"
+	OpcodePayoutRun = "payout-run"
+	OpcodeFeature 	= "feature"
+	OpcodeCompensation = "compensation"
+	OpcodeExchange 	= "exchange"
+	OpcodeLogLevel 	= "log-level"
+	OpcodeReload 	= "reload"
+	OpcodeBans 		= "bans"
+	OpcodeBanLift 	= "ban-lift"
 )
 
 type PubSub interface {
 	RedisMessage(string)
 }
 
+// channel is the pub/sub channel of name, prefixed like the keys when the channels are scoped.
+// Messages still carry the bare name.
+func (r *RedisClient) channel(name string) string {
+	if r.scopeChannels {
+		return r.formatKey(name)
+	}
+	return name
+}
+
 func (r *RedisClient) InitPubSub(name string, proc PubSub) {
-	psc, err := r.client.Subscribe(name)
+	psc, err := r.client.Subscribe(r.channel(name))
 	if err != nil {
 		log.Fatalf("redis pub/sub subscribe failed: %s",name)
 	}
@@ -43,7 +64,7 @@ func (r *RedisClient) InitPubSub(name string, proc PubSub) {
 
 func (r *RedisClient) Publish(channel string, opcode string, data string, reply string) (int64,error){
 	msg := opcode + ":" + channel + ":" + data
-	res, err := r.client.Publish(channel, msg).Result()
+	res, err := r.client.Publish(r.channel(channel), msg).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Events published on ChannelPush for the API to push to WebSocket clients.
+const (
+	PushBlockFound   = "blockFound"
+	PushBlockMatured = "blockMatured"
+	PushPaymentSent  = "paymentSent"
+)
+
+// PushEvent is an event of another module pushed to WebSocket clients.
+type PushEvent struct {
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data"`
+	// Miner the event is about, if any
+	Login string `json:"-"`
+}
+
+// Fields of each event, in the order they are published. Messages are split by ':', values are joined by ','.
+var pushFields = map[string][]string{
+	PushBlockFound:   {"height", "login", "difficulty"},
+	PushBlockMatured: {"height", "hash", "uncle", "reward"},
+	PushPaymentSent:  {"login", "amount", "tx"},
+}
+
+func (r *RedisClient) publishPush(event string, values ...string) error {
+	_, err := r.Publish(ChannelPush, event, strings.Join(values, ","), "")
+	return err
+}
+
+// PublishBlockFound announces a block candidate found by login.
+func (r *RedisClient) PublishBlockFound(height uint64, login string, difficulty int64) error {
+	return r.publishPush(PushBlockFound, strconv.FormatUint(height, 10), login, strconv.FormatInt(difficulty, 10))
+}
+
+// PublishBlockMatured announces a block credited to the miners, with its reward in Wei.
+func (r *RedisClient) PublishBlockMatured(height int64, hash string, uncle bool, reward string) error {
+	return r.publishPush(PushBlockMatured, strconv.FormatInt(height, 10), hash, strconv.FormatBool(uncle), reward)
+}
+
+// PublishPaymentSent announces a payment of amount Shannon to login.
+func (r *RedisClient) PublishPaymentSent(login string, amount int64, txHash string) error {
+	return r.publishPush(PushPaymentSent, login, strconv.FormatInt(amount, 10), txHash)
+}
+
+// ParsePushEvent parses a message of ChannelPush. Numbers and booleans are converted, other values stay strings.
+func ParsePushEvent(payload string) (*PushEvent, bool) {
+	parts := strings.SplitN(payload, ":", 3)
+	if len(parts) != 3 {
+		return nil, false
+	}
+	fields, ok := pushFields[parts[0]]
+	if !ok {
+		return nil, false
+	}
+	values := strings.Split(parts[2], ",")
+	if len(values) != len(fields) {
+		return nil, false
+	}
+
+	e := &PushEvent{Type: parts[0], Data: make(map[string]interface{}, len(fields))}
+	for i, field := range fields {
+		value := values[i]
+		switch field {
+		case "height", "difficulty", "amount":
+			n, _ := strconv.ParseInt(value, 10, 64)
+			e.Data[field] = n
+		case "uncle":
+			e.Data[field] = value == "true"
+		case "login":
+			e.Login = value
+			e.Data[field] = value
+		default:
+			e.Data[field] = value
+		}
+	}
+	return e, true
+}
@@ -2,6 +2,8 @@ package redis
 
 import (
 	"fmt"
+	"log"
+	"github.com/cellcrypto/open-dangnn-pool/feature"
 	"github.com/cellcrypto/open-dangnn-pool/storage/types"
 	"math"
 	"math/big"
@@ -15,24 +17,47 @@ import (
 )
 
 type Config struct {
+	// "single" (default), "sentinel" or "cluster"
+	Mode     string `json:"mode"`
 	Endpoint string `json:"endpoint"`
 	Password string `json:"password"`
 	Database int64  `json:"database"`
 	PoolSize int    `json:"poolSize"`
+	// Prefix of the keys, the coin by default
+	Prefix   string `json:"prefix"`
+	// Prefix the pub/sub channels as well, for pools sharing a Redis database. All processes of a
+	// pool must agree on it
+	ScopeChannels bool `json:"scopeChannels"`
+	// Masters found through Redis Sentinel, in sentinel mode
+	Sentinel SentinelConfig `json:"sentinel"`
+	// Nodes of a Redis Cluster, in cluster mode
+	Cluster ClusterConfig `json:"cluster"`
 }
 
+// Shares per height are kept long enough for uncles to mature.
+const heightSharesExpiration = 72 * time.Hour
+
 type RedisClient struct {
 	client *redis.Client
 	mysql IMysqlDB
 	prefix string
+	// Pub/sub channels are prefixed
+	scopeChannels bool
 	pplns  int64
+	// Feature flags of the pool, with their overrides kept in this database
+	features *feature.Flags
 	DiffByShareValue int64
+	// Shares are scored with this decay, rounds are credited by score. 0 when disabled
+	scoreDecay time.Duration
 }
 
 type PoolCharts struct {
 	Timestamp  int64  `json:"x"`
 	TimeFormat string `json:"timeFormat"`
 	PoolHash   int64  `json:"y"`
+	// Set when the sample was flagged as a sudden spike or drop
+	Anomaly       string  `json:"anomaly,omitempty"`
+	AnomalyChange float64 `json:"anomalyChange,omitempty"`
 }
 
 type PaymentCharts struct {
@@ -52,6 +77,8 @@ type Miner struct {
 	LastBeat  int64 `json:"lastBeat"`
 	HR        int64 `json:"hr"`
 	Offline   bool  `json:"offline"`
+	// Distinct workers in the window, only counted for miners
+	Workers   int   `json:"workers,omitempty"`
 	startedAt int64
 }
 
@@ -64,10 +91,12 @@ type Worker struct {
 	RoundShare		float32 `json:"rshare"`
 	Reported		int64 `json:"reported"`
 	DevId			string `json:"devid"`
+	// Region of the stratum listener of its last share
+	Region			string `json:"region"`
 }
 
 type IMysqlDB interface {
-	WriteCandidates(height uint64, params []string, nowTime string, ts int64, roundDiff int64, totalShares int64)
+	WriteCandidates(height uint64, params []string, finder, worker string, nowTime string, ts int64, roundDiff int64, totalShares int64, roundShares map[string]int64)
 	CollectLuckStats(windowMax int64) ([]*types.BlockData,error)
 	CollectStats(maxBlocks int64) ([]*types.BlockData, []*types.BlockData, []*types.BlockData, int, []map[string]interface{}, int64, error)
 	GetMinerStats(login string, maxPayments int64) (map[string]interface{}, error)
@@ -76,13 +105,14 @@ type IMysqlDB interface {
 }
 
 func NewRedisClient(cfg *Config, prefix string, proxyDiff int64, pplns int64) *RedisClient {
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.Endpoint,
-		Password: cfg.Password,
-		DB:       cfg.Database,
-		PoolSize: cfg.PoolSize,
-	})
-	return &RedisClient{client: client, prefix: prefix, pplns: pplns, DiffByShareValue: proxyDiff}
+	if len(cfg.Prefix) > 0 {
+		prefix = cfg.Prefix
+	}
+	client, err := newClient(cfg, prefix)
+	if err != nil {
+		log.Fatalf("Redis config error: %v", err)
+	}
+	return &RedisClient{client: client, prefix: keyPrefix(cfg, prefix), scopeChannels: cfg.ScopeChannels, pplns: pplns, DiffByShareValue: proxyDiff}
 }
 
 func (r *RedisClient) Client() *redis.Client {
@@ -122,6 +152,13 @@ func (r *RedisClient) WritePoolCharts(time1 int64, time2 string, poolHash string
 	return cmd.Err()
 }
 
+// WritePoolChartAnomaly annotates the pool chart sample at time1, change is relative to the previous sample.
+func (r *RedisClient) WritePoolChartAnomaly(time1 int64, kind string, change float64) error {
+	s := util.Join(time1, kind, strconv.FormatFloat(change, 'f', 4, 64))
+	cmd := r.client.ZAdd(r.formatKey("charts", "pool", "anomaly"), redis.Z{Score: float64(time1), Member: s})
+	return cmd.Err()
+}
+
 func (r *RedisClient) WriteMinerCharts(time1 int64, time2, k string, hash, largeHash, workerOnline int64, share int64, report int64) error {
 	s := util.Join(time1, time2, hash, largeHash, workerOnline, share, report)
 	cmd := r.client.ZAdd(r.formatKey("charts", "miner", k), redis.Z{Score: float64(time1), Member: s})
@@ -138,6 +175,8 @@ func (r *RedisClient) GetPoolCharts(poolHashLen int64) (stats []*PoolCharts, err
 	cmds, err := tx.Exec(func() error {
 		tx.ZRemRangeByScore(r.formatKey("charts", "pool"), "-inf", fmt.Sprint("(", now-172800))
 		tx.ZRevRangeWithScores(r.formatKey("charts", "pool"), 0, poolHashLen)
+		tx.ZRemRangeByScore(r.formatKey("charts", "pool", "anomaly"), "-inf", fmt.Sprint("(", now-172800))
+		tx.ZRangeWithScores(r.formatKey("charts", "pool", "anomaly"), 0, -1)
 		return nil
 	})
 
@@ -146,9 +185,31 @@ func (r *RedisClient) GetPoolCharts(poolHashLen int64) (stats []*PoolCharts, err
 	}
 
 	stats = convertPoolChartsResults(cmds[1].(*redis.ZSliceCmd))
+	annotatePoolCharts(stats, cmds[3].(*redis.ZSliceCmd))
 	return stats, nil
 }
 
+func annotatePoolCharts(stats []*PoolCharts, raw *redis.ZSliceCmd) {
+	anomalies := make(map[int64][]string)
+	for _, v := range raw.Val() {
+		// "Timestamp:Kind:Change"
+		fields := strings.Split(v.Member.(string), ":")
+		if len(fields) != 3 {
+			continue
+		}
+		anomalies[int64(v.Score)] = fields
+	}
+	if len(anomalies) == 0 {
+		return
+	}
+	for _, pc := range stats {
+		if fields, ok := anomalies[pc.Timestamp]; ok {
+			pc.Anomaly = fields[1]
+			pc.AnomalyChange, _ = strconv.ParseFloat(fields[2], 64)
+		}
+	}
+}
+
 func convertPoolChartsResults(raw *redis.ZSliceCmd) []*PoolCharts {
 	var result []*PoolCharts
 	for _, v := range raw.Val() {
@@ -269,7 +330,22 @@ func (r *RedisClient) GetNodeHeight(id string) (int64, error) {
 	return cmd.Int64()
 }
 
-func (r *RedisClient) GetNodeStates() ([]map[string]interface{}, error) {
+// GetNodeDifficulty is the network difficulty the node last reported, nil before it has.
+func (r *RedisClient) GetNodeDifficulty(id string) (*big.Int, error) {
+	value, err := r.client.HGet(r.formatKey("nodes"), util.Join(id, "difficulty")).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	diff, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid difficulty %q of node %v", value, id)
+	}
+	return diff, nil
+}
+
+func (r *RedisClient) GetNodeStates()([]map[string]interface{}, error) {
 	cmd := r.client.HGetAllMap(r.formatKey("nodes"))
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
@@ -301,7 +377,14 @@ func (r *RedisClient) CheckPoWExist(height uint64, params []string) (bool, error
 	return val == 0, err
 }
 
-func (r *RedisClient) WriteShare(login, devId, id string, params []string, diff int64, height uint64, window time.Duration, hostname string, loginCnt int) (bool, error) {
+// CheckDuplicateShare remembers the nonce of login's share for the job during window, true if it was already submitted.
+func (r *RedisClient) CheckDuplicateShare(login, jobId, nonce string, window time.Duration) (bool, error) {
+	ok, err := r.client.SetNX(r.formatKey("dup", login, jobId, nonce), "1", window).Result()
+	return !ok, err
+}
+
+// WriteShare credits a share. With a seq above 0, the seq of the journal is recorded with it.
+func (r *RedisClient) WriteShare(login, devId, id string, params []string, diff int64, height uint64, window time.Duration, hostname, region string, loginCnt int, journalId string, seq uint64) (bool, error) {
 	tx := r.client.Multi()
 	defer tx.Close()
 
@@ -309,14 +392,32 @@ func (r *RedisClient) WriteShare(login, devId, id string, params []string, diff
 	ts := ms / 1000
 
 	_, err := tx.Exec(func() error {
-		r.writeShare(tx, ms, ts, login, id, diff, window, hostname, loginCnt, devId)
+		r.writeShare(tx, ms, ts, login, id, diff, height, window, hostname, region, loginCnt, devId, workerValid)
 		tx.HIncrBy(r.formatKey("stats"), "roundShares", diff)
+		r.writeJournalSeq(tx, journalId, seq)
 		return nil
 	})
 	return false, err
 }
 
-func (r *RedisClient) WriteBlock(login, devId, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration, hostname string, loginCnt int) (bool, error) {
+// WriteStaleShare credits a share of an older job with diff, its partial credit, and counts it as stale for the worker.
+func (r *RedisClient) WriteStaleShare(login, devId, id string, params []string, diff int64, height uint64, window time.Duration, hostname, region string, loginCnt int, journalId string, seq uint64) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+
+	_, err := tx.Exec(func() error {
+		r.writeShare(tx, ms, ts, login, id, diff, height, window, hostname, region, loginCnt, devId, RejectStale)
+		tx.HIncrBy(r.formatKey("stats"), "roundShares", diff)
+		r.writeJournalSeq(tx, journalId, seq)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisClient) WriteBlock(login, devId, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration, hostname, region string, loginCnt int) (bool, error) {
 	tx := r.client.Multi()
 	defer tx.Close()
 
@@ -324,8 +425,15 @@ func (r *RedisClient) WriteBlock(login, devId, id string, params []string, diff,
 	ms := nowTime.UnixNano() / int64(time.Millisecond)
 	ts := ms / 1000
 
+	var scores *redis.StringStringMapCmd
 	cmds, err := tx.Exec(func() error {
-		r.writeShare(tx, ms, ts, login, id, diff, window, hostname, loginCnt, devId)
+		r.writeShare(tx, ms, ts, login, id, diff, height, window, hostname, region, loginCnt, devId, workerValid)
+		if r.scoreDecay > 0 {
+			scores = tx.HGetAllMap(r.formatKey("shares", "scoreCurrent"))
+			tx.Del(r.formatKey("shares", "scoreCurrent"))
+			// The next round starts with its first share
+			tx.HDel(r.formatKey("stats"), "scoreStart")
+		}
 		tx.HSet(r.formatKey("stats"), "lastBlockFound", strconv.FormatInt(ts, 10))
 		tx.HDel(r.formatKey("stats"), "roundShares")
 		tx.ZIncrBy(r.formatKey("finders"), 1, login)
@@ -340,13 +448,27 @@ func (r *RedisClient) WriteBlock(login, devId, id string, params []string, diff,
 	} else {
 
 		shares := cmds[len(cmds)-1].(*redis.StringSliceCmd).Val()
+		sharesMap, _ := cmds[len(cmds)-3].(*redis.StringStringMapCmd).Result()
 
 		tx2 := r.client.Multi()
 		defer tx2.Close()
 
 		totalshares := make(map[string]int64)
-		for _, val := range shares {
-			totalshares[val] += 1
+		if scores != nil {
+			// Score: the shares of the round weighted by their age, replacing PPLNS and proportional
+			totalshares = scoreWeights(scores.Val())
+		} else if r.Features().Enabled(feature.Pplns) {
+			for _, val := range shares {
+				totalshares[val] += 1
+			}
+		} else {
+			// Proportional: only the shares of the round that found the block.
+			for login, v := range sharesMap {
+				n, _ := strconv.ParseInt(v, 10, 64)
+				if n/r.DiffByShareValue > 0 {
+					totalshares[login] = n / r.DiffByShareValue
+				}
+			}
 		}
 
 		_, err := tx2.Exec(func() error {
@@ -358,37 +480,45 @@ func (r *RedisClient) WriteBlock(login, devId, id string, params []string, diff,
 		if err != nil {
 			return false, err
 		}
-		//r.mysql.WriteRoundShare(height, params[0], totalshares)
 
-		sharesMap, _ := cmds[len(cmds)-3].(*redis.StringStringMapCmd).Result()
 		totalShares := int64(0)
 		for _, v := range sharesMap {
 			n, _ := strconv.ParseInt(v, 10, 64)
 			totalShares += n
 		}
 
-		r.mysql.WriteCandidates(height, params, nowTime.Format("2006-01-02 15:04:05.000"), ts, roundDiff, totalShares)
+		r.mysql.WriteCandidates(height, params, login, id, util.FormatDBTime(nowTime), ts, roundDiff, totalShares, totalshares)
 		return false, nil
 	}
 }
 
-func (r *RedisClient) writeShare(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration, hostname string, loginCnt int, devId string) {
+func (r *RedisClient) writeShare(tx *redis.Multi, ms, ts int64, login, id string, diff int64, height uint64, expire time.Duration, hostname, region string, loginCnt int, devId, class string) {
 	times := int(diff / r.DiffByShareValue)
 
 	// Moved get hostname to stratums
 
-	if times > 0 {	// Share is incremented by one.
-		tx.LPush(r.formatKey("lastshares"), login)
+	if times > 0 {	// One entry per share unit, so vardiff shares weigh by their difficulty.
+		logins := make([]string, times)
+		for i := range logins {
+			logins[i] = login
+		}
+		tx.LPush(r.formatKey("lastshares"), logins...)
+		// Who worked on each height, so an uncle can be credited to the miners of its own height.
+		tx.HIncrBy(r.formatHeight(int64(height)), login, int64(times))
+		tx.Expire(r.formatHeight(int64(height)), heightSharesExpiration)
 	}
 	tx.LTrim(r.formatKey("lastshares"), 0, r.pplns)
 
 	tx.HIncrBy(r.formatKey("shares", "roundCurrent"), login, diff)
+	r.writeShareScore(tx, ms, login, diff)
 	// For aggregation of hashrate, to store value in hashrate key
-	tx.ZAdd(r.formatKey("hashrate"), redis.Z{Score: float64(ts), Member: util.Join(diff, login, id, ms, diff, hostname)})
+	tx.ZAdd(r.formatKey("hashrate"), redis.Z{Score: float64(ts), Member: util.Join(diff, login, id, ms, diff, hostname, region)})
 	// For separate miner's workers hashrate, to store under hashrate table under login key
-	tx.ZAdd(r.formatKey("hashrate", login), redis.Z{Score: float64(ts), Member: util.Join(diff, id, loginCnt, ms, diff, hostname, devId)})
+	tx.ZAdd(r.formatKey("hashrate", login), redis.Z{Score: float64(ts), Member: util.Join(diff, id, loginCnt, ms, diff, hostname, devId, region)})
 	// Will delete hashrates for miners that gone
 	tx.Expire(r.formatKey("hashrate", login), expire)
+	// Workers belong to the login they connected with, not the sub login credited.
+	r.writeWorkerShare(tx, devId, id, class, region, diff, ts)
 	//tx.HSet(r.formatKey("miners", login), "lastShare", strconv.FormatInt(ts, 10))
 }
 
@@ -400,6 +530,10 @@ func (r *RedisClient) formatRound(height int64, nonce string) string {
 	return r.formatKey("shares", "round"+strconv.FormatInt(height, 10), nonce)
 }
 
+func (r *RedisClient) formatHeight(height int64) string {
+	return r.formatKey("shares", "height"+strconv.FormatInt(height, 10))
+}
+
 
 func (r *RedisClient) GetCandidates(maxHeight int64) ([]*types.BlockData, error) {
 	option := redis.ZRangeByScore{Min: "0", Max: strconv.FormatInt(maxHeight, 10)}
@@ -443,6 +577,20 @@ func (r *RedisClient) GetRoundShares(height int64, nonce string) (map[string]int
 	return result, nil
 }
 
+// GetHeightShares returns the share units each miner submitted for work at height.
+func (r *RedisClient) GetHeightShares(height int64) (map[string]int64, error) {
+	result := make(map[string]int64)
+	sharesMap, err := r.client.HGetAllMap(r.formatHeight(height)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for login, v := range sharesMap {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		result[strings.ToLower(login)] += n
+	}
+	return result, nil
+}
+
 func (r *RedisClient) GetPayees() ([]string, error) {
 	payees := make(map[string]struct{})
 	var result []string
@@ -515,6 +663,64 @@ func (r *RedisClient) IsPayoutsLocked() (bool, error) {
 	}
 }
 
+// SetLastPayoutRun records when the payer last checked scheduled payouts, in unix seconds.
+func (r *RedisClient) SetLastPayoutRun(ts int64) error {
+	return r.client.Set(r.formatKey("payments", "lastRun"), strconv.FormatInt(ts, 10), 0).Err()
+}
+
+// GetLastPayoutRun is 0 until the payer has run.
+func (r *RedisClient) GetLastPayoutRun() (int64, error) {
+	ts, err := r.client.Get(r.formatKey("payments", "lastRun")).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return ts, err
+}
+
+// GetFeatureFlags returns the feature flags overridden at runtime.
+func (r *RedisClient) GetFeatureFlags() (map[string]bool, error) {
+	values, err := r.client.HGetAllMap(r.formatKey("features")).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(values))
+	for name, value := range values {
+		result[name] = value == "1"
+	}
+	return result, nil
+}
+
+func (r *RedisClient) SetFeatureFlag(name string, enabled bool) error {
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+	return r.client.HSet(r.formatKey("features"), name, value).Err()
+}
+
+// ResetFeatureFlag drops the override so the configured default applies again.
+func (r *RedisClient) ResetFeatureFlag(name string) error {
+	return r.client.HDel(r.formatKey("features"), name).Err()
+}
+
+// GetLogLevels returns the log levels of modules overridden at runtime.
+func (r *RedisClient) GetLogLevels() (map[string]string, error) {
+	values, err := r.client.HGetAllMap(r.formatKey("loglevels")).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (r *RedisClient) SetLogLevel(module, level string) error {
+	return r.client.HSet(r.formatKey("loglevels"), module, level).Err()
+}
+
+// ResetLogLevel drops the override so the configured level applies again.
+func (r *RedisClient) ResetLogLevel(module string) error {
+	return r.client.HDel(r.formatKey("loglevels"), module).Err()
+}
+
 type PendingPayment struct {
 	Timestamp int64  `json:"timestamp"`
 	Amount    int64  `json:"amount"`
@@ -905,6 +1111,7 @@ func (r *RedisClient) CollectStats(smallWindow time.Duration, maxBlocks, maxPaym
 	stats["miners"] = miners
 	stats["minersTotal"] = len(miners)
 	stats["hashrate"] = totalHashrate
+	stats["regions"] = convertRegionStats(window, cmds[1].(*redis.ZSliceCmd))
 	return stats, nil
 }
 
@@ -1287,6 +1494,9 @@ func convertWorkersStats(window int64, raw *redis.ZSliceCmd, divFlag bool) map[s
 		} else {
 			worker.DevId = "unknown"
 		}
+		if len(parts) > 7 && score >= worker.LastBeat {
+			worker.Region = parts[7]
+		}
 
 		worker.Size, _ = strconv.ParseInt(parts[2], 10, 64)
 		if worker.Size < 1 { worker.Size=1 }
@@ -1331,6 +1541,7 @@ func convertWorkersStats(window int64, raw *redis.ZSliceCmd, divFlag bool) map[s
 func convertMinersStats(window int64, raw *redis.ZSliceCmd) (int64, map[string]Miner) {
 	now := util.MakeTimestamp() / 1000
 	miners := make(map[string]Miner)
+	workers := make(map[string]map[string]struct{})
 	totalHashrate := int64(0)
 
 	for _, v := range raw.Val() {
@@ -1340,6 +1551,12 @@ func convertMinersStats(window int64, raw *redis.ZSliceCmd) (int64, map[string]M
 		score := int64(v.Score)
 		miner := miners[id]
 		miner.HR += share
+		if len(parts) > 2 {
+			if _, ok := workers[id]; !ok {
+				workers[id] = make(map[string]struct{})
+			}
+			workers[id][parts[2]] = struct{}{}
+		}
 
 		if miner.LastBeat < score {
 			miner.LastBeat = score
@@ -1361,6 +1578,7 @@ func convertMinersStats(window int64, raw *redis.ZSliceCmd) (int64, map[string]M
 			boundary = window
 		}
 		miner.HR = miner.HR / boundary
+		miner.Workers = len(workers[id])
 
 		if miner.LastBeat < (now - window/2) {
 			miner.Offline = true
@@ -1402,8 +1620,7 @@ func convertPaymentChartsResults(raw *redis.ZSliceCmd) []*PaymentCharts {
 	for _, v := range raw.Val() {
 		pc := PaymentCharts{}
 		pc.Timestamp = int64(v.Score)
-		tm := time.Unix(pc.Timestamp, 0)
-		pc.TimeFormat = tm.Format("2006-01-02") + " 00_00"
+		pc.TimeFormat = util.FormatDisplayTime(pc.Timestamp, "2006-01-02") + " 00_00"
 		fields := strings.Split(v.Member.(string), ":")
 		pc.Amount, _ = strconv.ParseInt(fields[1], 10, 64)
 		//fmt.Printf("%d : %s : %d \n", pc.Timestamp, pc.TimeFormat, pc.Amount)
@@ -1445,6 +1662,18 @@ func (r *RedisClient) SetDB(db IMysqlDB) {
 	r.mysql = db
 }
 
+func (r *RedisClient) SetFeatures(flags *feature.Flags) {
+	r.features = flags
+}
+
+// Features returns the feature flags of the pool, at their defaults until they are set.
+func (r *RedisClient) Features() *feature.Flags {
+	if r.features == nil {
+		return feature.Defaults()
+	}
+	return r.features
+}
+
 func (r *RedisClient) GetReportedtHashrate(login string) (map[string]int64, error) {
 	var result map[string]int64
 	reportedRate := r.client.HGetAllMap(r.formatKey("report", login))
@@ -1,53 +1,63 @@
 package redis
 
 import (
+	"math"
 	"os"
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
 	"gopkg.in/redis.v3"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
 )
 
 var r *RedisClient
 
+// Whether the Redis of the tests is up, the tests using it are skipped otherwise
+var redisUp bool
+
 const prefix = "test"
 
 func TestMain(m *testing.M) {
 	r = NewRedisClient(&Config{Endpoint: "127.0.0.1:6379"}, prefix, 0, 3000)
-	reset()
+	if _, err := r.Check(); err == nil {
+		redisUp = true
+	}
+	flush()
 	c := m.Run()
-	reset()
+	flush()
 	os.Exit(c)
 }
 
-func TestWriteShareCheckExist(t *testing.T) {
-	reset()
+func TestCheckPoWExist(t *testing.T) {
+	reset(t)
 
-	exist, _ := r.WriteShare("x", "x", []string{"0x0", "0x0", "0x0"}, 10, 1008, 0, "")
+	exist, _ := r.CheckPoWExist(1008, []string{"0x0", "0x0", "0x0"})
 	if exist {
 		t.Error("PoW must not exist")
 	}
-	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x1", "0x0"}, 10, 1008, 0, "")
+	exist, _ = r.CheckPoWExist(1008, []string{"0x0", "0x1", "0x0"})
 	if exist {
 		t.Error("PoW must not exist")
 	}
-	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x0", "0x1"}, 100, 1010, 0, "")
+	exist, _ = r.CheckPoWExist(1010, []string{"0x0", "0x0", "0x1"})
 	if exist {
 		t.Error("PoW must not exist")
 	}
-	exist, _ = r.WriteShare("z", "x", []string{"0x0", "0x0", "0x1"}, 100, 1016, 0, "")
+	exist, _ = r.CheckPoWExist(1016, []string{"0x0", "0x0", "0x1"})
 	if !exist {
 		t.Error("PoW must exist")
 	}
-	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x0", "0x1"}, 100, 1025, 0, "")
+	exist, _ = r.CheckPoWExist(1025, []string{"0x0", "0x0", "0x1"})
 	if exist {
 		t.Error("PoW must not exist")
 	}
 }
 
 func TestGetPayees(t *testing.T) {
-	reset()
+	reset(t)
 
 	n := 256
 	for i := 0; i < n; i++ {
@@ -69,7 +79,7 @@ func TestGetPayees(t *testing.T) {
 }
 
 func TestGetBalance(t *testing.T) {
-	reset()
+	reset(t)
 
 	r.client.HSet(r.formatKey("miners:x"), "balance", "750")
 
@@ -88,7 +98,7 @@ func TestGetBalance(t *testing.T) {
 }
 
 func TestLockPayouts(t *testing.T) {
-	reset()
+	reset(t)
 
 	r.LockPayouts("x", 1000)
 	v := r.client.Get("test:payments:lock").Val()
@@ -103,7 +113,7 @@ func TestLockPayouts(t *testing.T) {
 }
 
 func TestUnlockPayouts(t *testing.T) {
-	reset()
+	reset(t)
 
 	r.client.Set(r.formatKey("payments:lock"), "x:1000", 0)
 
@@ -115,7 +125,7 @@ func TestUnlockPayouts(t *testing.T) {
 }
 
 func TestIsPayoutsLocked(t *testing.T) {
-	reset()
+	reset(t)
 
 	r.LockPayouts("x", 1000)
 	if locked, _ := r.IsPayoutsLocked(); !locked {
@@ -124,7 +134,7 @@ func TestIsPayoutsLocked(t *testing.T) {
 }
 
 func TestUpdateBalance(t *testing.T) {
-	reset()
+	reset(t)
 
 	r.client.HMSetMap(
 		r.formatKey("miners:x"),
@@ -159,14 +169,14 @@ func TestUpdateBalance(t *testing.T) {
 		t.Error("Must not touch pool paid")
 	}
 
-	rank := r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Val()
+	rank := r.client.ZRank(r.formatKey("payments:pending"), util.Join("x", amount)).Val()
 	if rank != 0 {
 		t.Error("Must add pending payment")
 	}
 }
 
 func TestRollbackBalance(t *testing.T) {
-	reset()
+	reset(t)
 
 	r.client.HMSetMap(
 		r.formatKey("miners:x"),
@@ -202,14 +212,14 @@ func TestRollbackBalance(t *testing.T) {
 		t.Error("Must deduct pool pending")
 	}
 
-	err := r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Err()
+	err := r.client.ZRank(r.formatKey("payments:pending"), util.Join("x", amount)).Err()
 	if err != redis.Nil {
 		t.Errorf("Must remove pending payment")
 	}
 }
 
 func TestWritePayment(t *testing.T) {
-	reset()
+	reset(t)
 
 	r.client.HMSetMap(
 		r.formatKey("miners:x"),
@@ -249,22 +259,22 @@ func TestWritePayment(t *testing.T) {
 		t.Errorf("Must release lock")
 	}
 
-	err = r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Err()
+	err = r.client.ZRank(r.formatKey("payments:pending"), util.Join("x", amount)).Err()
 	if err != redis.Nil {
 		t.Error("Must remove pending payment")
 	}
-	err = r.client.ZRank(r.formatKey("payments:all"), join("0x0", "x", amount)).Err()
+	err = r.client.ZRank(r.formatKey("payments:all"), util.Join("0x0", "x", amount)).Err()
 	if err == redis.Nil {
 		t.Error("Must add payment to set")
 	}
-	err = r.client.ZRank(r.formatKey("payments:x"), join("0x0", amount)).Err()
+	err = r.client.ZRank(r.formatKey("payments:x"), util.Join("0x0", amount)).Err()
 	if err == redis.Nil {
 		t.Error("Must add payment to set")
 	}
 }
 
 func TestGetPendingPayments(t *testing.T) {
-	reset()
+	reset(t)
 
 	r.client.HMSetMap(
 		r.formatKey("miners:x"),
@@ -290,7 +300,7 @@ func TestGetPendingPayments(t *testing.T) {
 }
 
 func TestCollectLuckStats(t *testing.T) {
-	reset()
+	reset(t)
 
 	members := []redis.Z{
 		redis.Z{Score: 0, Member: "1:0:0x0:0x0:0:100:100:0"},
@@ -321,9 +331,252 @@ func TestCollectLuckStats(t *testing.T) {
 	}
 }
 
-func reset() {
+// reset empties the keys of the tests, or skips the test without Redis.
+func reset(t *testing.T) {
+	if !redisUp {
+		t.Skip("Redis is not running on 127.0.0.1:6379")
+	}
+	flush()
+}
+
+func flush() {
+	if !redisUp {
+		return
+	}
 	keys := r.client.Keys(r.prefix + ":*").Val()
 	for _, k := range keys {
 		r.client.Del(k)
 	}
 }
+
+func TestKeyFamily(t *testing.T) {
+	families := map[string]string{
+		r.formatRound(1008, "0x1"):                        "shares:round",
+		r.formatKey("shares", "roundCurrent"):             "shares:roundCurrent",
+		r.formatKey("hashrate", "0xb85150eb365e7df0941f"): "hashrate",
+		r.formatKey("charts", "miner", "0xb85150eb365e"):  "charts:miner",
+		r.formatKey("stats"):                              "stats",
+	}
+	for key, family := range families {
+		if f := r.keyFamily(key); f != family {
+			t.Errorf("Family of %v must be %v, got %v", key, family, f)
+		}
+	}
+}
+
+func TestRejectHistory(t *testing.T) {
+	reset(t)
+
+	ts := int64(1600000000)
+	r.WriteReject("x", "rig-1", RejectStale, ts)
+	r.WriteReject("x", "rig-1", RejectStale, ts)
+	r.WriteReject("x", "rig-2", RejectDuplicate, ts)
+	r.WriteReject("x", "rig-1", RejectInvalid, ts-3600)
+
+	history, err := r.GetRejectHistory("x", ts)
+	if err != nil {
+		t.Fatalf("Must read reject history: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Must return only the hours with rejects, got %v", len(history))
+	}
+	if history[0].Timestamp != ts-ts%3600 || history[0].Workers["rig-1"][RejectStale] != 2 || history[0].Workers["rig-2"][RejectDuplicate] != 1 {
+		t.Errorf("Unexpected current hour %+v", history[0])
+	}
+	if history[1].Workers["rig-1"][RejectInvalid] != 1 {
+		t.Errorf("Unexpected previous hour %+v", history[1])
+	}
+}
+
+func TestWorkerSessions(t *testing.T) {
+	reset(t)
+
+	now := time.Now().Unix()
+	for i := int64(0); i < 4; i++ {
+		s := &WorkerSession{Worker: "rig-1", ConnectedAt: now - 600 + i, DisconnectedAt: now - 300 + i, Reason: "timeout"}
+		if err := r.WriteWorkerSession("x", s, 3, time.Hour); err != nil {
+			t.Fatalf("Must write session: %v", err)
+		}
+	}
+	r.WriteWorkerSession("x", &WorkerSession{Worker: "rig-2", DisconnectedAt: now - 7200}, 3, time.Hour)
+
+	sessions, err := r.GetWorkerSessions("x")
+	if err != nil {
+		t.Fatalf("Must read sessions: %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("Must keep the newest 3 sessions, got %v", len(sessions))
+	}
+	if sessions[0].DisconnectedAt != now-297 || sessions[2].DisconnectedAt != now-299 {
+		t.Errorf("Unexpected order %+v %+v", sessions[0], sessions[2])
+	}
+}
+
+func TestCheckDuplicateShare(t *testing.T) {
+	reset(t)
+
+	dup, _ := r.CheckDuplicateShare("x", "0x1", "0x2", time.Minute)
+	if dup {
+		t.Error("First share must not be a duplicate")
+	}
+	dup, _ = r.CheckDuplicateShare("x", "0x1", "0x2", time.Minute)
+	if !dup {
+		t.Error("Resubmitted share must be a duplicate")
+	}
+	dup, _ = r.CheckDuplicateShare("y", "0x1", "0x2", time.Minute)
+	if dup {
+		t.Error("Share of another login must not be a duplicate")
+	}
+}
+
+func TestTakeWorkerShares(t *testing.T) {
+	reset(t)
+
+	r.WriteReject("x", "rig-1", RejectStale, 1600000000)
+	r.WriteReject("x", "rig-1", RejectRate, 1600000001)
+	r.WriteReject("x", "rig-2", RejectDuplicate, 1600000002)
+
+	workers, err := r.TakeWorkerShares("x")
+	if err != nil {
+		t.Fatalf("Must take worker shares: %v", err)
+	}
+	if w := workers["rig-1"]; w == nil || w.Stale != 1 || w.Invalid != 1 || w.LastSeen != 1600000001 {
+		t.Errorf("Unexpected rig-1 %+v", w)
+	}
+	if w := workers["rig-2"]; w == nil || w.Duplicate != 1 {
+		t.Errorf("Unexpected rig-2 %+v", w)
+	}
+
+	workers, _ = r.TakeWorkerShares("x")
+	if w := workers["rig-1"]; w == nil || w.Stale != 0 || w.LastSeen != 1600000001 {
+		t.Errorf("Must reset counters but keep last seen, got %+v", w)
+	}
+}
+
+func TestWriteStaleShare(t *testing.T) {
+	reset(t)
+
+	err := r.WriteStaleShare("x", "x", "rig-1", []string{"0x0", "0x0", "0x0"}, 50, 10, 10*time.Minute, "host", "eu", 1, "", 0)
+	if err != nil {
+		t.Fatalf("Must write stale share: %v", err)
+	}
+	if v, _ := r.client.HGet(r.formatKey("stats"), "roundShares").Int64(); v != 50 {
+		t.Errorf("Must credit 50 round shares, got %v", v)
+	}
+	workers, _ := r.GetWorkerShares("x")
+	if w := workers["rig-1"]; w == nil || w.Stale != 1 || w.Valid != 0 || w.Diff != 50 || w.Region != "eu" {
+		t.Errorf("Must count a stale share, got %+v", w)
+	}
+	regions := convertRegionStats(600, r.client.ZRangeWithScores(r.formatKey("hashrate"), 0, -1))
+	if region := regions["eu"]; region == nil || region.Miners != 1 || region.Workers != 1 {
+		t.Errorf("Must sum the share under its region, got %v", regions)
+	}
+}
+
+func TestFlagExchangeAddress(t *testing.T) {
+	reset(t)
+
+	if ok, _ := r.FlagExchangeAddress("x", 1600000000); !ok {
+		t.Error("Must flag a new address")
+	}
+	if ok, _ := r.FlagExchangeAddress("x", 1600000001); ok {
+		t.Error("Must not flag an address twice")
+	}
+	addresses, _ := r.GetExchangeAddresses()
+	if addresses["x"] != 1600000000 {
+		t.Errorf("Must keep the first flag time, got %v", addresses)
+	}
+	r.UnflagExchangeAddress("x")
+	if exchange, _ := r.IsExchangeAddress("x"); exchange {
+		t.Error("Must unflag the address")
+	}
+}
+
+func TestBans(t *testing.T) {
+	reset(t)
+
+	r.WriteBan(BanKindIP, "10.0.0.1", "invalid shares", 1600000100)
+	r.WriteBan(BanKindLogin, "0xabc", "stale shares", 1600000010)
+	bans, _ := r.GetBans(1600000050)
+	if len(bans) != 1 || bans[0].Value != "10.0.0.1" || bans[0].Reason != "invalid shares" || bans[0].Until != 1600000100 {
+		t.Errorf("Must list the running ban only, got %v", bans)
+	}
+	if n, _ := r.client.HLen(r.formatKey("bans", "reasons")).Result(); n != 1 {
+		t.Errorf("Must drop the reason of the ended ban, got %v", n)
+	}
+	if ok, _ := r.LiftBan(BanKindIP, "10.0.0.1"); !ok {
+		t.Error("Must lift the ban")
+	}
+	if ok, _ := r.LiftBan(BanKindIP, "10.0.0.1"); ok {
+		t.Error("Must not lift a ban twice")
+	}
+	if kind, value, ok := ParseBanPayload(BanPayload(BanKindIP, "::1")); !ok || kind != BanKindIP || value != "::1" {
+		t.Errorf("Must parse the payload back, got %v %v", kind, value)
+	}
+}
+
+func TestParsePushEvent(t *testing.T) {
+	e, ok := ParsePushEvent(PushPaymentSent + ":" + ChannelPush + ":0xabc,1500,0xdef")
+	if !ok {
+		t.Fatal("Must parse payment event")
+	}
+	if e.Login != "0xabc" || e.Data["amount"] != int64(1500) || e.Data["tx"] != "0xdef" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	e, ok = ParsePushEvent(PushBlockMatured + ":" + ChannelPush + ":100,0x01,true,2000000000000000000")
+	if !ok || e.Data["uncle"] != true || e.Data["height"] != int64(100) || len(e.Login) > 0 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if _, ok := ParsePushEvent(PushBlockFound + ":" + ChannelPush + ":100"); ok {
+		t.Error("Must not parse an event with missing fields")
+	}
+}
+
+func TestJournalSeq(t *testing.T) {
+	reset(t)
+
+	err := r.WriteStaleShare("x", "x", "rig-1", []string{"0x0", "0x0", "0x0"}, 50, 10, 10*time.Minute, "host", "", 1, "j1", 7)
+	if err != nil {
+		t.Fatalf("Must write stale share: %v", err)
+	}
+	if ok, _ := r.JournalApplied("j1", 7); !ok {
+		t.Error("Must record the journal seq with the share")
+	}
+	if ok, _ := r.JournalApplied("j1", 8); ok {
+		t.Error("Must not report an unwritten seq")
+	}
+	if n, _ := r.CompactJournal("j1", 7); n != 0 {
+		t.Error("Must keep the checkpoint seq")
+	}
+	if n, _ := r.CompactJournal("j1", 8); n != 1 {
+		t.Error("Must forget seqs below the checkpoint")
+	}
+}
+
+func TestScoreWeights(t *testing.T) {
+	weights := scoreWeights(map[string]string{
+		"a": "2e30",
+		"b": "1e30",
+		"c": "5e29",
+		// Rounds to 0 against the largest
+		"d": "1e15",
+		"e": "0",
+		"f": "-3",
+		"g": "+Inf",
+		"h": "NaN",
+		"i": "x",
+	})
+	expected := map[string]int64{"a": maxScoreWeight, "b": maxScoreWeight / 2, "c": maxScoreWeight / 4}
+	if !reflect.DeepEqual(weights, expected) {
+		t.Errorf("Must normalize to %v and drop the others, got %v", expected, weights)
+	}
+
+	weights = scoreWeights(map[string]string{"a": "3", "b": "1"})
+	if weights["a"] != maxScoreWeight || weights["b"] != int64(math.Round(maxScoreWeight/3.0)) {
+		t.Errorf("Must keep small scores proportional, got %v", weights)
+	}
+
+	if weights := scoreWeights(map[string]string{"a": "NaN", "b": "0"}); len(weights) != 0 {
+		t.Errorf("Must return no weights without a valid score, got %v", weights)
+	}
+}
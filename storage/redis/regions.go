@@ -0,0 +1,52 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/redis.v3"
+)
+
+// RegionStats is the hashrate of the shares submitted through the stratum listeners of a region.
+type RegionStats struct {
+	Hashrate int64 `json:"hashrate"`
+	Miners   int   `json:"miners"`
+	Workers  int   `json:"workers"`
+}
+
+// convertRegionStats sums the pool hashrate set by region. Shares written before regions were
+// recorded, or through listeners without one, count under "unknown".
+func convertRegionStats(window int64, raw *redis.ZSliceCmd) map[string]*RegionStats {
+	regions := make(map[string]*RegionStats)
+	miners := make(map[string]map[string]struct{})
+	workers := make(map[string]map[string]struct{})
+
+	for _, v := range raw.Val() {
+		// diff, login, id, ms, diff, hostname, region
+		parts := strings.Split(v.Member.(string), ":")
+		if len(parts) < 3 {
+			continue
+		}
+		region := "unknown"
+		if len(parts) > 6 && len(parts[6]) > 0 {
+			region = parts[6]
+		}
+		share, _ := strconv.ParseInt(parts[0], 10, 64)
+		stats, ok := regions[region]
+		if !ok {
+			stats = &RegionStats{}
+			regions[region] = stats
+			miners[region] = make(map[string]struct{})
+			workers[region] = make(map[string]struct{})
+		}
+		stats.Hashrate += share
+		miners[region][parts[1]] = struct{}{}
+		workers[region][parts[1]+"."+parts[2]] = struct{}{}
+	}
+	for region, stats := range regions {
+		stats.Hashrate /= window
+		stats.Miners = len(miners[region])
+		stats.Workers = len(workers[region])
+	}
+	return regions
+}
@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// Reject classes of shares, counted per worker and hour.
+const (
+	RejectStale     = "stale"
+	RejectDuplicate = "duplicate"
+	RejectInvalid   = "invalid"
+	RejectMalformed = "malformed"
+	RejectRate      = "rate"
+)
+
+// Hours of reject history kept per miner.
+const RejectHistoryHours = 48
+
+type RejectHour struct {
+	// Start of the hour
+	Timestamp int64 `json:"timestamp"`
+	// Worker to reject class to count
+	Workers map[string]map[string]int64 `json:"workers"`
+}
+
+// WriteReject counts a rejected share of the worker in the hour of ts.
+func (r *RedisClient) WriteReject(login, worker, class string, ts int64) error {
+	key := r.formatKey("rejects", login, ts-ts%3600)
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		tx.HIncrBy(key, worker+":"+class, 1)
+		tx.Expire(key, (RejectHistoryHours+1)*time.Hour)
+		r.writeWorkerShare(tx, login, worker, class, "", 0, ts)
+		return nil
+	})
+	return err
+}
+
+// GetRejectHistory returns the hours with rejected shares among the last RejectHistoryHours before ts, newest first.
+func (r *RedisClient) GetRejectHistory(login string, ts int64) ([]*RejectHour, error) {
+	hour := ts - ts%3600
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	cmds, err := tx.Exec(func() error {
+		for i := int64(0); i < RejectHistoryHours; i++ {
+			tx.HGetAllMap(r.formatKey("rejects", login, hour-i*3600))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	history := make([]*RejectHour, 0)
+	for i, cmd := range cmds {
+		counts, _ := cmd.(*redis.StringStringMapCmd).Result()
+		if len(counts) == 0 {
+			continue
+		}
+		h := &RejectHour{Timestamp: hour - int64(i)*3600, Workers: make(map[string]map[string]int64)}
+		for field, value := range counts {
+			sep := strings.LastIndex(field, ":")
+			if sep < 0 {
+				continue
+			}
+			worker, class := field[:sep], field[sep+1:]
+			if _, ok := h.Workers[worker]; !ok {
+				h.Workers[worker] = make(map[string]int64)
+			}
+			h.Workers[worker][class], _ = strconv.ParseInt(value, 10, 64)
+		}
+		history = append(history, h)
+	}
+	return history, nil
+}
@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// Scores of a share stop growing this many decays into a round, so they can't overflow.
+const maxScoreExponent = 600
+
+// Largest weight of a round credited by score, the other weights are proportional to it.
+const maxScoreWeight = 1e12
+
+// scoreShareScript adds the score of a share to the current round: its difficulty weighted by
+// exp(age / decay), the age since the first share of the round. It runs in Redis so every proxy
+// scores a round from the same start.
+const scoreShareScript = `
+local start = tonumber(redis.call('HGET', KEYS[1], 'scoreStart'))
+if not start then
+	start = tonumber(ARGV[3])
+	redis.call('HSET', KEYS[1], 'scoreStart', ARGV[3])
+end
+local exponent = math.min(math.max(tonumber(ARGV[3]) - start, 0) / tonumber(ARGV[4]), tonumber(ARGV[5]))
+return redis.call('HINCRBYFLOAT', KEYS[2], ARGV[1], tonumber(ARGV[2]) * math.exp(exponent))`
+
+// SetScoreDecay scores the shares of every round, rounds are then credited by score instead of
+// shares. 0 disables scoring.
+func (r *RedisClient) SetScoreDecay(decay time.Duration) {
+	r.scoreDecay = decay
+}
+
+func (r *RedisClient) writeShareScore(tx *redis.Multi, ms int64, login string, diff int64) {
+	if r.scoreDecay <= 0 {
+		return
+	}
+	tx.Eval(scoreShareScript, []string{r.formatKey("stats"), r.formatKey("shares", "scoreCurrent")}, []string{
+		login, strconv.FormatInt(diff, 10), strconv.FormatInt(ms, 10),
+		strconv.FormatInt(int64(r.scoreDecay/time.Millisecond), 10), strconv.Itoa(maxScoreExponent),
+	})
+}
+
+// scoreWeights turns the scores of a round into integer weights, the largest maxScoreWeight.
+// Logins whose score rounds to 0 get nothing.
+func scoreWeights(scores map[string]string) map[string]int64 {
+	values := make(map[string]float64, len(scores))
+	largest := 0.0
+	for login, v := range scores {
+		score, err := strconv.ParseFloat(v, 64)
+		// Not score > 0, which is false for NaN too
+		if err != nil || !(score > 0) || math.IsInf(score, 0) {
+			continue
+		}
+		values[login] = score
+		largest = math.Max(largest, score)
+	}
+	weights := make(map[string]int64, len(values))
+	for login, score := range values {
+		if w := int64(math.Round(score / largest * maxScoreWeight)); w > 0 {
+			weights[login] = w
+		}
+	}
+	return weights
+}
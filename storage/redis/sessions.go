@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// WorkerSession is one stratum connection of a miner, recorded when it ended.
+type WorkerSession struct {
+	Worker   string `json:"worker"`
+	IP       string `json:"ip"`
+	Protocol string `json:"protocol"`
+	// Stratum host of the proxy the worker was connected to, and the region of its listener
+	Hostname       string `json:"hostname"`
+	Region         string `json:"region,omitempty"`
+	ConnectedAt    int64  `json:"connectedAt"`
+	DisconnectedAt int64  `json:"disconnectedAt"`
+	// Valid shares of the session and their average difficulty
+	Shares  int64 `json:"shares"`
+	AvgDiff int64 `json:"avgDiff"`
+	// Why the session ended, e.g. "closed", "timeout" or the error sent to the miner
+	Reason string `json:"reason"`
+}
+
+// WriteWorkerSession records an ended session of login, keeping the newest max sessions of the last retention.
+func (r *RedisClient) WriteWorkerSession(login string, s *WorkerSession, max int64, retention time.Duration) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	key := r.formatKey("sessions", login)
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err = tx.Exec(func() error {
+		tx.ZAdd(key, redis.Z{Score: float64(s.DisconnectedAt), Member: string(data)})
+		tx.ZRemRangeByScore(key, "-inf", fmt.Sprint("(", time.Now().Add(-retention).Unix()))
+		tx.ZRemRangeByRank(key, 0, -max-1)
+		tx.Expire(key, retention)
+		return nil
+	})
+	return err
+}
+
+// GetWorkerSessions returns the recorded sessions of login, the last ended first.
+func (r *RedisClient) GetWorkerSessions(login string) ([]*WorkerSession, error) {
+	members, err := r.client.ZRevRange(r.formatKey("sessions", login), 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	sessions := make([]*WorkerSession, 0, len(members))
+	for _, m := range members {
+		var s WorkerSession
+		if err := json.Unmarshal([]byte(m), &s); err != nil {
+			continue
+		}
+		sessions = append(sessions, &s)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// Redis topologies the pool can run on
+const (
+	ModeSingle   = "single"
+	ModeSentinel = "sentinel"
+	ModeCluster  = "cluster"
+)
+
+type SentinelConfig struct {
+	// Name of the master the sentinels monitor
+	MasterName string `json:"masterName"`
+	// host:port of the sentinels
+	Addrs []string `json:"addrs"`
+}
+
+type ClusterConfig struct {
+	// host:port of some nodes of the cluster, the others are found from them
+	Addrs []string `json:"addrs"`
+	// Keys of the pool are hashed on this tag, the prefix by default. Pools given the same tag
+	// share a slot, and with it a node
+	HashTag string `json:"hashTag"`
+}
+
+// Location identifies the Redis database of the config, whatever the topology.
+func (c *Config) Location() string {
+	switch c.Mode {
+	case ModeSentinel:
+		return fmt.Sprintf("sentinel:%v/%v", c.Sentinel.MasterName, c.Database)
+	case ModeCluster:
+		return "cluster:" + strings.Join(c.Cluster.Addrs, ",")
+	default:
+		return fmt.Sprintf("%v/%v", c.Endpoint, c.Database)
+	}
+}
+
+// keyPrefix is the prefix of the keys. On a cluster it carries a hash tag so all keys of the pool
+// hash to one slot: rounds, shares and miners are updated together in MULTI, which a cluster
+// refuses across slots. The layout after the prefix is the same on every topology.
+func keyPrefix(cfg *Config, prefix string) string {
+	if cfg.Mode != ModeCluster {
+		return prefix
+	}
+	tag := cfg.Cluster.HashTag
+	if len(tag) == 0 || tag == prefix {
+		return "{" + prefix + "}"
+	}
+	return "{" + tag + "}" + prefix
+}
+
+func newClient(cfg *Config, prefix string) (*redis.Client, error) {
+	switch cfg.Mode {
+	case "", ModeSingle:
+		return redis.NewClient(&redis.Options{
+			Addr:     cfg.Endpoint,
+			Password: cfg.Password,
+			DB:       cfg.Database,
+			PoolSize: cfg.PoolSize,
+		}), nil
+	case ModeSentinel:
+		if len(cfg.Sentinel.MasterName) == 0 || len(cfg.Sentinel.Addrs) == 0 {
+			return nil, fmt.Errorf("redis sentinel mode needs sentinel.masterName and sentinel.addrs")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    cfg.Sentinel.MasterName,
+			SentinelAddrs: cfg.Sentinel.Addrs,
+			Password:      cfg.Password,
+			DB:            cfg.Database,
+			PoolSize:      cfg.PoolSize,
+		}), nil
+	case ModeCluster:
+		if len(cfg.Cluster.Addrs) == 0 {
+			return nil, fmt.Errorf("redis cluster mode needs cluster.addrs")
+		}
+		if cfg.Database != 0 {
+			return nil, fmt.Errorf("redis cluster only has database 0")
+		}
+		slotKey := keyPrefix(cfg, prefix)
+		return redis.NewClient(&redis.Options{
+			Dialer:   clusterDialer(cfg, slotKey),
+			Password: cfg.Password,
+			PoolSize: cfg.PoolSize,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", cfg.Mode)
+	}
+}
+
+// clusterDialer connects to the master serving the slot of the pool. The slot is looked up on
+// every new connection, so once a failover or resharding drops the connections they come back on
+// the new master.
+func clusterDialer(cfg *Config, slotKey string) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		addr, err := slotMaster(cfg, slotKey)
+		if err != nil {
+			return nil, err
+		}
+		return net.DialTimeout("tcp", addr, 5*time.Second)
+	}
+}
+
+// slotMaster asks the nodes of the config in turn for the master of the slot of the key.
+func slotMaster(cfg *Config, key string) (string, error) {
+	var lastErr error
+	for _, addr := range cfg.Cluster.Addrs {
+		node := redis.NewClient(&redis.Options{Addr: addr, Password: cfg.Password, PoolSize: 1})
+		addr, err := nodeSlotMaster(node, key)
+		node.Close()
+		if err == nil {
+			return addr, nil
+		}
+		lastErr = err
+	}
+	return "", fmt.Errorf("no redis cluster node knows the master of %v: %v", key, lastErr)
+}
+
+func nodeSlotMaster(node *redis.Client, key string) (string, error) {
+	slot, err := node.ClusterKeySlot(key).Result()
+	if err != nil {
+		return "", err
+	}
+	slots, err := node.ClusterSlots().Result()
+	if err != nil {
+		return "", err
+	}
+	for _, s := range slots {
+		// The master comes first
+		if int64(s.Start) <= slot && slot <= int64(s.End) && len(s.Addrs) > 0 {
+			return s.Addrs[0], nil
+		}
+	}
+	return "", fmt.Errorf("slot %v is not served", slot)
+}
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// The share withholding stats of the workers are kept in a hash an hour, for a week.
+const (
+	withholdingBucket    = time.Hour
+	withholdingRetention = 7 * 24 * time.Hour
+)
+
+// WithholdingStats is the work of a worker against the shares and blocks it found: its shares
+// expected to meet the near-block difficulty and the block difficulty, and those that did.
+type WithholdingStats struct {
+	Expected       float64 `json:"expected"`
+	Near           int64   `json:"near"`
+	ExpectedBlocks float64 `json:"expectedBlocks"`
+	Blocks         int64   `json:"blocks"`
+}
+
+// Add adds the stats of o.
+func (w *WithholdingStats) Add(o *WithholdingStats) {
+	w.Expected += o.Expected
+	w.Near += o.Near
+	w.ExpectedBlocks += o.ExpectedBlocks
+	w.Blocks += o.Blocks
+}
+
+// WriteWithholdingStats adds the stats of the workers, keyed "login.worker", to the hour of ts.
+func (r *RedisClient) WriteWithholdingStats(ts int64, stats map[string]*WithholdingStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	bucket := ts - ts%int64(withholdingBucket/time.Second)
+	key := r.formatKey("withholding", bucket)
+	tx := r.client.Multi()
+	defer tx.Close()
+	_, err := tx.Exec(func() error {
+		for worker, s := range stats {
+			tx.HIncrByFloat(key, worker+":expected", s.Expected)
+			tx.HIncrByFloat(key, worker+":expectedBlocks", s.ExpectedBlocks)
+			if s.Near > 0 {
+				tx.HIncrBy(key, worker+":near", s.Near)
+			}
+			if s.Blocks > 0 {
+				tx.HIncrBy(key, worker+":blocks", s.Blocks)
+			}
+		}
+		tx.Expire(key, withholdingRetention)
+		return nil
+	})
+	return err
+}
+
+// GetWithholdingStats sums the stats of the workers, keyed "login.worker", over the hours since
+// from, at most a week.
+func (r *RedisClient) GetWithholdingStats(from int64) (map[string]*WithholdingStats, error) {
+	now := time.Now().Unix()
+	step := int64(withholdingBucket / time.Second)
+	if oldest := now - int64(withholdingRetention/time.Second); from < oldest {
+		from = oldest
+	}
+	tx := r.client.Multi()
+	defer tx.Close()
+	cmds, err := tx.Exec(func() error {
+		for bucket := from - from%step; bucket <= now; bucket += step {
+			tx.HGetAllMap(r.formatKey("withholding", bucket))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*WithholdingStats)
+	for _, cmd := range cmds {
+		for field, value := range cmd.(*redis.StringStringMapCmd).Val() {
+			i := strings.LastIndex(field, ":")
+			if i < 0 {
+				continue
+			}
+			worker := field[:i]
+			s, ok := result[worker]
+			if !ok {
+				s = &WithholdingStats{}
+				result[worker] = s
+			}
+			switch field[i+1:] {
+			case "expected":
+				v, _ := strconv.ParseFloat(value, 64)
+				s.Expected += v
+			case "expectedBlocks":
+				v, _ := strconv.ParseFloat(value, 64)
+				s.ExpectedBlocks += v
+			case "near":
+				v, _ := strconv.ParseInt(value, 10, 64)
+				s.Near += v
+			case "blocks":
+				v, _ := strconv.ParseInt(value, 10, 64)
+				s.Blocks += v
+			}
+		}
+	}
+	return result, nil
+}
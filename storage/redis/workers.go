@@ -0,0 +1,108 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/redis.v3"
+
+	"github.com/cellcrypto/open-dangnn-pool/storage/types"
+)
+
+// Share counters of a worker are kept until rolled up, and its last share this long after it.
+const workerStatsExpiration = 30 * 24 * time.Hour
+
+// Counter of the valid shares, next to the reject classes.
+const workerValid = "valid"
+
+// Field of the region of the worker's last share, next to its counters.
+const workerRegion = "region"
+
+// writeWorkerShare counts a share of the worker by class until the next rollup.
+func (r *RedisClient) writeWorkerShare(tx *redis.Multi, login, id, class, region string, diff, ts int64) {
+	key := r.formatKey("workershares", login)
+	tx.HIncrBy(key, id+":"+class, 1)
+	if diff > 0 {
+		tx.HIncrBy(key, id+":diff", diff)
+	}
+	if len(region) > 0 {
+		tx.HSet(key, id+":"+workerRegion, region)
+	}
+	tx.Expire(key, workerStatsExpiration)
+	tx.HSet(r.formatKey("workerseen", login), id, strconv.FormatInt(ts, 10))
+	tx.Expire(r.formatKey("workerseen", login), workerStatsExpiration)
+}
+
+// TakeWorkerShares returns the share counters of login's workers since the last call and resets them.
+func (r *RedisClient) TakeWorkerShares(login string) (map[string]*types.WorkerStats, error) {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	cmds, err := tx.Exec(func() error {
+		tx.HGetAllMap(r.formatKey("workershares", login))
+		tx.Del(r.formatKey("workershares", login))
+		tx.HGetAllMap(r.formatKey("workerseen", login))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return convertWorkerShares(cmds[0].(*redis.StringStringMapCmd).Val(), cmds[2].(*redis.StringStringMapCmd).Val()), nil
+}
+
+// GetWorkerShares returns the share counters of login's workers since the last rollup and when each was last seen.
+func (r *RedisClient) GetWorkerShares(login string) (map[string]*types.WorkerStats, error) {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	cmds, err := tx.Exec(func() error {
+		tx.HGetAllMap(r.formatKey("workershares", login))
+		tx.HGetAllMap(r.formatKey("workerseen", login))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return convertWorkerShares(cmds[0].(*redis.StringStringMapCmd).Val(), cmds[1].(*redis.StringStringMapCmd).Val()), nil
+}
+
+func convertWorkerShares(counts, seen map[string]string) map[string]*types.WorkerStats {
+	workers := make(map[string]*types.WorkerStats)
+	get := func(id string) *types.WorkerStats {
+		w, ok := workers[id]
+		if !ok {
+			w = &types.WorkerStats{Worker: id}
+			workers[id] = w
+		}
+		return w
+	}
+	for id, ts := range seen {
+		get(id).LastSeen, _ = strconv.ParseInt(ts, 10, 64)
+	}
+	for field, value := range counts {
+		sep := strings.LastIndex(field, ":")
+		if sep < 0 {
+			continue
+		}
+		w := get(field[:sep])
+		if field[sep+1:] == workerRegion {
+			w.Region = value
+			continue
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		switch field[sep+1:] {
+		case workerValid:
+			w.Valid += n
+		case "diff":
+			w.Diff += n
+		case RejectStale:
+			w.Stale += n
+		case RejectDuplicate:
+			w.Duplicate += n
+		default:
+			w.Invalid += n
+		}
+	}
+	return workers
+}
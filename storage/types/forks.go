@@ -0,0 +1,123 @@
+package types
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+)
+
+// Forks is the fork height table of a network, the block reward and fee rules by height.
+type Forks struct {
+	// Block reward from genesis, in wei
+	GenesisReward string `json:"genesisReward"`
+	// Block reward changes, e.g. Byzantium and Constantinople
+	Rewards []ForkReward `json:"rewards"`
+	// Height from which the base fee of txs is burned (EIP-1559), 0 if never
+	London int64 `json:"london"`
+
+	genesisReward *big.Int
+}
+
+type ForkReward struct {
+	Name   string `json:"name"`
+	Height int64  `json:"height"`
+	// Block reward from this height, in wei
+	Reward string `json:"reward"`
+
+	reward *big.Int
+}
+
+// Embedded tables by netId
+var knownForks = map[int64]*Forks{
+	// Ethereum mainnet
+	1: {
+		GenesisReward: "5000000000000000000",
+		Rewards: []ForkReward{
+			{Name: "byzantium", Height: 4370000, Reward: "3000000000000000000"},
+			{Name: "constantinople", Height: 7280000, Reward: "2000000000000000000"},
+		},
+		London: 12965000,
+	},
+	// Ropsten
+	3: {
+		GenesisReward: "5000000000000000000",
+		Rewards: []ForkReward{
+			{Name: "byzantium", Height: 1700000, Reward: "3000000000000000000"},
+			{Name: "constantinople", Height: 4230000, Reward: "2000000000000000000"},
+		},
+		London: 10499401,
+	},
+}
+
+// dangnnForks is the table of Dangnn networks, which are told apart by name.
+func dangnnForks(net string) *Forks {
+	carrat := CarrathardforkheightMainnet
+	if net == "testnet" {
+		carrat = CarrathardforkheightTestnet
+	}
+	return &Forks{
+		GenesisReward: GenesisReword.String(),
+		Rewards:       []ForkReward{{Name: "carrat", Height: carrat, Reward: CarratReward.String()}},
+	}
+}
+
+// ForksFor returns the table of a network: the configured one, the embedded one of a known netId,
+// or else the Dangnn table of net.
+func ForksFor(netId int64, net string, configured map[string]*Forks) (*Forks, error) {
+	f, ok := configured[strconv.FormatInt(netId, 10)]
+	if !ok {
+		if f, ok = knownForks[netId]; !ok {
+			f = dangnnForks(net)
+		}
+	}
+	if err := f.parse(); err != nil {
+		return nil, fmt.Errorf("forks of netId %v: %v", netId, err)
+	}
+	return f, nil
+}
+
+func (f *Forks) parse() error {
+	var ok bool
+	if f.genesisReward, ok = new(big.Int).SetString(f.GenesisReward, 10); !ok {
+		return fmt.Errorf("invalid genesisReward %v", f.GenesisReward)
+	}
+	for i := range f.Rewards {
+		r := &f.Rewards[i]
+		if r.reward, ok = new(big.Int).SetString(r.Reward, 10); !ok {
+			return fmt.Errorf("invalid reward %v of %v", r.Reward, r.Name)
+		}
+	}
+	sort.SliceStable(f.Rewards, func(i, j int) bool { return f.Rewards[i].Height < f.Rewards[j].Height })
+	return nil
+}
+
+// ConstReward returns the static block reward at height.
+func (f *Forks) ConstReward(height int64) *big.Int {
+	reward := f.genesisReward
+	for i := range f.Rewards {
+		if height < f.Rewards[i].Height {
+			break
+		}
+		reward = f.Rewards[i].reward
+	}
+	return new(big.Int).Set(reward)
+}
+
+// RewardForUncle returns the reward for including an uncle in a block at height.
+func (f *Forks) RewardForUncle(height int64) *big.Int {
+	reward := f.ConstReward(height)
+	return reward.Div(reward, big.NewInt(32))
+}
+
+// UncleReward returns the reward of an uncle at uHeight included at height.
+func (f *Forks) UncleReward(uHeight, height int64) *big.Int {
+	reward := f.ConstReward(height)
+	reward.Mul(big.NewInt(8-(height-uHeight)), reward)
+	return reward.Div(reward, big.NewInt(8))
+}
+
+// BurnsBaseFee tells whether the miner of a block at height only gets the tips of its txs.
+func (f *Forks) BurnsBaseFee(height int64) bool {
+	return f.London > 0 && height >= f.London
+}
@@ -20,9 +20,16 @@ type BlockData struct {
 	MixDigest      string   `json:"-"`
 	Reward         *big.Int `json:"-"`
 	ExtraReward    *big.Int `json:"-"`
+	// Parts of the reward paid by the block's txs and for the uncles it includes, nil for uncles
+	TxFees         *big.Int `json:"-"`
+	UncleBonus     *big.Int `json:"-"`
 	ImmatureReward string   `json:"-"`
 	RewardString   string   `json:"reward"`
 	RoundHeight    int64    `json:"-"`
+	// Login and worker that submitted the winning share, and its finder bonus in Shannon
+	Finder         string   `json:"finder,omitempty"`
+	FinderWorker   string   `json:"finderWorker,omitempty"`
+	FinderBonus    int64    `json:"finderBonus,omitempty"`
 	CandidateKey   string
 	ImmatureKey    string
 	State		   int
@@ -38,6 +45,61 @@ type MinerCharts struct {
 	MinerReportHash int64 `json:"minerReportHash"`
 }
 
+// WorkerStats are the shares of one worker (login.worker) over a period, or since the last rollup.
+type WorkerStats struct {
+	Worker    string `json:"worker"`
+	Timestamp int64  `json:"x,omitempty"`
+	Hashrate  int64  `json:"hashrate"`
+	Valid     int64  `json:"valid"`
+	Stale     int64  `json:"stale"`
+	// Also malformed and rate limited shares
+	Invalid   int64 `json:"invalid"`
+	Duplicate int64 `json:"duplicate"`
+	LastSeen  int64 `json:"lastSeen"`
+	// Difficulty of the valid shares, the hashrate over the period
+	Diff int64 `json:"-"`
+	// Region of the stratum listener of the worker's last share in the period
+	Region string `json:"region,omitempty"`
+}
+
+func (w *WorkerStats) Add(o *WorkerStats) {
+	w.Valid += o.Valid
+	w.Stale += o.Stale
+	w.Invalid += o.Invalid
+	w.Duplicate += o.Duplicate
+	w.Diff += o.Diff
+	if len(o.Region) > 0 && (o.LastSeen >= w.LastSeen || len(w.Region) == 0) {
+		w.Region = o.Region
+	}
+	if o.LastSeen > w.LastSeen {
+		w.LastSeen = o.LastSeen
+	}
+}
+
+// StaleRate is the fraction of the worker's shares that were stale.
+func (w *WorkerStats) StaleRate() float64 {
+	total := w.Valid + w.Stale + w.Invalid + w.Duplicate
+	if total == 0 {
+		return 0
+	}
+	return float64(w.Stale) / float64(total)
+}
+
+// RoundDistribution tells how concentrated the shares of a matured round were.
+type RoundDistribution struct {
+	Height      int64  `json:"height"`
+	RoundHeight int64  `json:"roundHeight"`
+	Hash        string `json:"hash"`
+	TotalShares int64  `json:"shares"`
+	Miners      int    `json:"miners"`
+	// 0 when every miner had as many shares, close to 1 when one had them all
+	Gini float64 `json:"gini"`
+	// Fraction of the shares of the 10 largest miners
+	Top10Share float64 `json:"top10Share"`
+	// Miners by fraction of the shares: under 0.1%, under 1%, under 10%, 10% and over
+	Histogram [4]int `json:"histogram"`
+}
+
 type RewardData struct {
 	Height    int64   `json:"blockheight"`
 	Timestamp int64   `json:"timestamp"`
@@ -68,6 +130,7 @@ type InboundIdList struct {
 type UserInfo struct {
 	Username string `json:"username"`
 	Access string `json:"access"`
+	Role string `json:"role"`
 }
 
 type DevSubList struct {
@@ -84,31 +147,27 @@ var (
 	CarrathardforkheightTestnet = int64(641800)
 )
 
+// GetConstReward returns the static block reward of the Dangnn mainnet, or testnet, at height.
 func GetConstReward(height int64, mainnet bool) *big.Int {
-	if mainnet == true {
-		if height >= CarrathardforkheightMainnet {
-			return new(big.Int).Set(CarratReward)
-		}
-
-	} else {
-		if height >= CarrathardforkheightTestnet {
-			return new(big.Int).Set(CarratReward)
-		}
-	}
-	return new(big.Int).Set(GenesisReword)
+	return dangnn(mainnet).ConstReward(height)
 }
 
 func GetRewardForUncle(height int64, mainnet bool) *big.Int {
-	reward := GetConstReward(height, mainnet)
-	return new(big.Int).Div(reward, new(big.Int).SetInt64(32))
+	return dangnn(mainnet).RewardForUncle(height)
 }
 
 func GetUncleReward(uHeight, height int64, mainnet bool) *big.Int {
-	reward := GetConstReward(height, mainnet)
-	k := height - uHeight
-	reward.Mul(big.NewInt(8-k), reward)
-	reward.Div(reward, big.NewInt(8))
-	return reward
+	return dangnn(mainnet).UncleReward(uHeight, height)
+}
+
+func dangnn(mainnet bool) *Forks {
+	net := "mainnet"
+	if !mainnet {
+		net = "testnet"
+	}
+	f := dangnnForks(net)
+	f.parse()
+	return f
 }
 
 func (b *BlockData) RewardInShannon() int64 {
@@ -0,0 +1,137 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Share difficulties reach miners in one of three forms, depending on the stratum dialect:
+// a target hash as eth_getWork returns it, a bare boundary of 32 bytes, or a stratum
+// difficulty where 1 stands for 2^32 hashes.
+
+// StratumDiff1 is the share difficulty of stratum difficulty 1.
+const StratumDiff1 = 1 << 32
+
+var maxTarget = new(big.Int).Sub(pow256, big.NewInt(1))
+
+// DiffToTarget is the highest hash meeting diff, 2^256 / diff. Difficulties below 2 all get
+// the highest target of 256 bits.
+func DiffToTarget(diff *big.Int) *big.Int {
+	if diff.Cmp(big.NewInt(1)) <= 0 {
+		return new(big.Int).Set(maxTarget)
+	}
+	return new(big.Int).Div(pow256, diff)
+}
+
+// TargetToDiff is the difficulty of a target, 0 for a zero target.
+func TargetToDiff(target *big.Int) *big.Int {
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	return new(big.Int).Div(pow256, target)
+}
+
+// EncodeTargetHash is the target of diff as eth_getWork returns it, 0x and 64 hex digits.
+// Miners reading it as a hash reject shorter ones.
+func EncodeTargetHash(diff int64) string {
+	return "0x" + EncodeBoundary(diff)
+}
+
+// EncodeBoundary is the target of diff in 64 hex digits without a prefix.
+func EncodeBoundary(diff int64) string {
+	return fmt.Sprintf("%064x", DiffToTarget(big.NewInt(diff)))
+}
+
+// DecodeTarget reads the difficulty of a target hash or boundary, with or without 0x and
+// leading zeros.
+func DecodeTarget(targetHex string) (*big.Int, error) {
+	s := strings.TrimPrefix(strings.TrimPrefix(targetHex, "0x"), "0X")
+	if len(s) == 0 || len(s) > 64 {
+		return nil, fmt.Errorf("invalid target %q", targetHex)
+	}
+	target, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid target %q", targetHex)
+	}
+	if target.Sign() == 0 {
+		return nil, errors.New("zero target")
+	}
+	return TargetToDiff(target), nil
+}
+
+// EncodeStratumDifficulty is diff in stratum difficulty, as sent by mining.set_difficulty.
+func EncodeStratumDifficulty(diff int64) float64 {
+	return float64(diff) / StratumDiff1
+}
+
+// DecodeStratumDifficulty is the share difficulty of a stratum difficulty, rounded to a hash.
+func DecodeStratumDifficulty(d float64) (int64, error) {
+	if math.IsNaN(d) || d <= 0 || d*StratumDiff1 >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid stratum difficulty %v", d)
+	}
+	return int64(math.Round(d * StratumDiff1)), nil
+}
+
+// DifficultyEncoder is how a stratum dialect sends share difficulties to miners.
+type DifficultyEncoder interface {
+	// Encode is the value sent for diff
+	Encode(diff int64) interface{}
+	// Decode reads the difficulty back from a sent value, or one decoded from JSON
+	Decode(v interface{}) (int64, error)
+}
+
+var (
+	// 0x prefixed target hash, eth_getWork and the eth_submitLogin stratum
+	TargetHash DifficultyEncoder = targetEncoder{prefix: true}
+	// Target without a prefix
+	Boundary DifficultyEncoder = targetEncoder{}
+	// Stratum difficulty number, EthereumStratum/1.0.0
+	StratumDifficulty DifficultyEncoder = stratumEncoder{}
+)
+
+type targetEncoder struct {
+	prefix bool
+}
+
+func (e targetEncoder) Encode(diff int64) interface{} {
+	if e.prefix {
+		return EncodeTargetHash(diff)
+	}
+	return EncodeBoundary(diff)
+}
+
+func (e targetEncoder) Decode(v interface{}) (int64, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("target must be a string, got %T", v)
+	}
+	diff, err := DecodeTarget(s)
+	if err != nil {
+		return 0, err
+	}
+	if !diff.IsInt64() {
+		return 0, fmt.Errorf("difficulty of target %q is out of range", s)
+	}
+	return diff.Int64(), nil
+}
+
+type stratumEncoder struct{}
+
+func (stratumEncoder) Encode(diff int64) interface{} {
+	return EncodeStratumDifficulty(diff)
+}
+
+func (stratumEncoder) Decode(v interface{}) (int64, error) {
+	switch d := v.(type) {
+	case float64:
+		return DecodeStratumDifficulty(d)
+	case int64:
+		return DecodeStratumDifficulty(float64(d))
+	case int:
+		return DecodeStratumDifficulty(float64(d))
+	}
+	return 0, fmt.Errorf("stratum difficulty must be a number, got %T", v)
+}
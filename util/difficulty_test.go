@@ -0,0 +1,144 @@
+package util
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestDiffToTarget(t *testing.T) {
+	max := strings.Repeat("f", 64)
+	tests := []struct {
+		diff   int64
+		target string
+	}{
+		{-1, max},
+		{0, max},
+		{1, max},
+		{2, "8" + strings.Repeat("0", 63)},
+		{4, "4" + strings.Repeat("0", 63)},
+		{3, strings.Repeat("5", 63) + "5"},
+		{1 << 32, "00000001" + strings.Repeat("0", 56)},
+		{4000000000, "0000000112e0be826d694b2e62d01511f12a6061fbaec8bc02357593e70e52ba"},
+	}
+	for _, tt := range tests {
+		if target := EncodeBoundary(tt.diff); target != tt.target {
+			t.Errorf("%v: expected boundary %v got %v", tt.diff, tt.target, target)
+		}
+		if target := EncodeTargetHash(tt.diff); target != "0x"+tt.target {
+			t.Errorf("%v: expected target hash 0x%v got %v", tt.diff, tt.target, target)
+		}
+	}
+}
+
+func TestDecodeTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		diff   int64
+	}{
+		{"0x" + strings.Repeat("f", 64), 1},
+		{strings.Repeat("f", 64), 1},
+		{"0x8" + strings.Repeat("0", 63), 2},
+		// Without leading zeros, as the node may send it
+		{"0x1" + strings.Repeat("0", 56), 1 << 32},
+		{"0X00000001" + strings.Repeat("0", 56), 1 << 32},
+		{"0x0000000112e0be826d694b2e62d01511f12a6061fbaec8bc02357593e70e52ba", 4000000000},
+	}
+	for _, tt := range tests {
+		diff, err := DecodeTarget(tt.target)
+		if err != nil {
+			t.Errorf("%v: %v", tt.target, err)
+			continue
+		}
+		if diff.Int64() != tt.diff {
+			t.Errorf("%v: expected %v got %v", tt.target, tt.diff, diff)
+		}
+	}
+	for _, target := range []string{"", "0x", "0x0", "0x" + strings.Repeat("0", 64), "0xzz", "0x1" + strings.Repeat("0", 64)} {
+		if _, err := DecodeTarget(target); err == nil {
+			t.Errorf("%q: expected an error", target)
+		}
+	}
+}
+
+func TestTargetRoundTrip(t *testing.T) {
+	for _, diff := range []int64{2, 3, 7, 1000, 1 << 20, 1 << 32, 4000000000, 1 << 40, 123456789012345} {
+		for _, target := range []string{EncodeTargetHash(diff), EncodeBoundary(diff)} {
+			decoded, err := DecodeTarget(target)
+			if err != nil || decoded.Int64() != diff {
+				t.Errorf("%v: %v decoded to %v, %v", diff, target, decoded, err)
+			}
+		}
+	}
+	if diff := TargetToDiff(new(big.Int)); diff.Sign() != 0 {
+		t.Errorf("expected 0 for a zero target, got %v", diff)
+	}
+	// Hashes at the target meet the difficulty, one above doesn't
+	diff := big.NewInt(4000000000)
+	target := DiffToTarget(diff)
+	if TargetToDiff(target).Cmp(diff) != 0 {
+		t.Errorf("expected %v got %v", diff, TargetToDiff(target))
+	}
+	if TargetToDiff(new(big.Int).Add(target, big.NewInt(1))).Cmp(diff) >= 0 {
+		t.Errorf("a hash above the target meets %v", diff)
+	}
+}
+
+func TestStratumDifficulty(t *testing.T) {
+	tests := []struct {
+		diff    int64
+		stratum float64
+	}{
+		{1 << 32, 1},
+		{1 << 31, 0.5},
+		{4000000000, 0.9313225746154785},
+		{2 << 32, 2},
+		{1, 1.0 / (1 << 32)},
+	}
+	for _, tt := range tests {
+		if d := EncodeStratumDifficulty(tt.diff); d != tt.stratum {
+			t.Errorf("%v: expected %v got %v", tt.diff, tt.stratum, d)
+		}
+		diff, err := DecodeStratumDifficulty(tt.stratum)
+		if err != nil || diff != tt.diff {
+			t.Errorf("%v: expected %v got %v, %v", tt.stratum, tt.diff, diff, err)
+		}
+	}
+	for _, d := range []float64{0, -1, 1 << 40} {
+		if _, err := DecodeStratumDifficulty(d); err == nil {
+			t.Errorf("%v: expected an error", d)
+		}
+	}
+}
+
+func TestDifficultyEncoders(t *testing.T) {
+	tests := []struct {
+		encoder DifficultyEncoder
+		diff    int64
+		encoded interface{}
+	}{
+		{TargetHash, 1 << 32, "0x00000001" + strings.Repeat("0", 56)},
+		{Boundary, 1 << 32, "00000001" + strings.Repeat("0", 56)},
+		{StratumDifficulty, 1 << 32, 1.0},
+		{StratumDifficulty, 4000000000, 0.9313225746154785},
+	}
+	for i, tt := range tests {
+		encoded := tt.encoder.Encode(tt.diff)
+		if encoded != tt.encoded {
+			t.Errorf("%v: expected %v got %v", i, tt.encoded, encoded)
+		}
+		diff, err := tt.encoder.Decode(encoded)
+		if err != nil || diff != tt.diff {
+			t.Errorf("%v: expected %v got %v, %v", i, tt.diff, diff, err)
+		}
+	}
+	if diff, err := StratumDifficulty.Decode(2); err != nil || diff != 2<<32 {
+		t.Errorf("expected %v got %v, %v", 2<<32, diff, err)
+	}
+	if _, err := TargetHash.Decode(1.0); err == nil {
+		t.Error("expected an error for a number target")
+	}
+	if _, err := StratumDifficulty.Decode("1"); err == nil {
+		t.Error("expected an error for a string difficulty")
+	}
+}
@@ -2,47 +2,20 @@ package plogger
 
 import (
 	"fmt"
-	"log"
-	"strings"
 	"sync"
 	"time"
-)
-
-var logger *Logger
-
-type Msg struct {
-	content 	string
-	msgType 	int
-	msgErr      int
-	roundHeight int64
-	height      int64
-	addr    	string
-	addr2 		string
-	insertTime time.Time
-}
-
-type Logger struct {
-	MsgQueue chan Msg
-	Db LogDB
-
-	maxWorkers int
-	maxQueueSize int
 
-	where string
-	logTableName string
-
-	//logData []LogData
+	"github.com/cellcrypto/open-dangnn-pool/util/xlog"
+)
 
-	lock       sync.Mutex
-	InsertCnt  int             // insert count
-	sqlBuilder strings.Builder // SQL STATEMENT
-}
-const (
-	maxQueueSize = 20000
-	maxWorkers = 2
-	insertSize = 5000
+var (
+	loggersMu sync.RWMutex
+	loggers   = make(map[string]*Logger)
 )
 
+// Entries are logged through the "plogger" module as well as saved to the log table
+var log = xlog.Module("plogger")
+
 const (
 	LogTypePendingBlock = 1000
 	LogTypeMaturedBlock = 2000
@@ -56,154 +29,98 @@ const (
 	LogSubTypeImmaturedBlock = 201
 	LogSubTypeOrphanBlcok = 202
 	LogSubTypeLostBlcok = 203
+	LogSubTypeDuplicateBlock = 204
 	LogSubTypePaymentLock 			= 301
 	LogSubTypePaymentTransaction 	= 302
 	LogSubTypePaymentUnlock 		= 303
 	LogSubTypePaymentWriteDB 		= 304
 	LogSubTypePaymentTxWait 		= 305
 	LogSubTypePaymentTxComplete 	= 306
+	LogSubTypePaymentCompensation 	= 307
+	LogSubTypePaymentSweep 			= 308
 	LogSubTypeError = 10000
 	LogSubTypeSystemRoundInfoRedis = 10001
 	LogErrorNothingRoundBlock = 10002
+	LogSubTypeCandidateTimeout = 10003
+	LogSubTypeHashrateAnomaly = 10004
+	LogSubTypeConnLimit = 10005
+	LogSubTypeRedisMemory = 10006
+	LogSubTypeFakeShare = 10007
+	LogSubTypeSwarm = 10008
+	LogSubTypeExchange = 10009
+	LogSubTypeNodeOutage = 10010
+	LogSubTypeBan = 10011
+	LogSubTypeAdminAction = 10012
+	LogSubTypeWithholding = 10013
 )
 
-type LogDB interface {
-	InsertSqlLog(sql *string)
+// For returns the logger of pool, nil before it was created. A nil logger still logs the entries
+// through the "plogger" module and hands them to the sinks, but doesn't save them.
+func For(pool string) *Logger {
+	loggersMu.RLock()
+	defer loggersMu.RUnlock()
+	return loggers[pool]
 }
 
-func New(db LogDB, where string, logTableName string) *Logger {
-
-
-	// create job channel
-	jobs := make(chan Msg, maxQueueSize)
-
-	logger = &Logger{
-		MsgQueue:     jobs,
-		Db:           db,
-		maxWorkers:   maxWorkers,
-		maxQueueSize: maxQueueSize,
-		where : where,
-		logTableName: logTableName,
-		// logData: make([]LogData,maxWorkers),
-	}
-
-	// create workers
-	logger.init()
-
-	return logger
-}
-
-func Save() {
-
-}
-
-func (l *Logger) init() *Logger {
-	for i := 1; i <= l.maxWorkers; i++ {
-		go func(i int) {
-			for m := range l.MsgQueue {
-				l.doWork(i, m)
-				if len(l.MsgQueue) == 0 {
-					l.Save(i, 0)
-				}
-			}
-		}(i)
-	}
-	return l
-}
-
-
-func InsertSystemError(logType int, roundHeight int64, height int64, format string, v ...interface{}) {
+func (l *Logger) InsertSystemError(logType int, roundHeight int64, height int64, format string, v ...interface{}) {
 	s := fmt.Sprintf(format, v...)
-	log.Printf(s)
-	InsertLog(s, logType, LogSubTypeError,roundHeight, height,"","" )
+	l.InsertLog(s, logType, LogSubTypeError,roundHeight, height,"","" )
 }
 
-func InsertSystemPaymemtError(logType int, addr string, addr2 string, format string, v ...interface{}) {
+func (l *Logger) InsertSystemPaymemtError(logType int, addr string, addr2 string, format string, v ...interface{}) {
 	s := fmt.Sprintf(format, v...)
-	log.Printf(s)
-	InsertLog(s, logType, LogSubTypeError,0, 0,addr,addr2 )
+	l.InsertLog(s, logType, LogSubTypeError,0, 0,addr,addr2 )
 }
 
-func InsertLog(content string, msgType int, msgErr int, roundHeight int64, height int64, addr, addr2 string)  {
-	msg := Msg{
-		content:     content,
-		msgType:     msgType,
-		msgErr:      msgErr,
-		roundHeight: roundHeight,
-		height:      height,
-		addr:        addr,
-		addr2:       addr2,
-		insertTime:  time.Now(),
+func (l *Logger) InsertLog(content string, msgType int, msgErr int, roundHeight int64, height int64, addr, addr2 string)  {
+	e := &Entry{
+		Time:        time.Now(),
+		Type:        msgType,
+		SubType:     msgErr,
+		RoundHeight: roundHeight,
+		Height:      height,
+		Addr:        addr,
+		Addr2:       addr2,
+		Msg:         content,
 	}
-
-	logger.MsgQueue <- msg
-}
-
-func (l *Logger) insertLog(msg Msg) {
-	l.lock.Lock()
-	defer l.lock.Unlock()
-
-	if l.InsertCnt == 0 {
-		l.sqlBuilder = strings.Builder{}
-		l.sqlBuilder.WriteString(fmt.Sprintf("INSERT INTO %v(`msg_type`,`msg_err`, `where`, `round_height`, `height`, `addr`, `addr2`, `msg`, `insert_time`) VALUES (\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\")", l.logTableName, msg.msgType, msg.msgErr, l.where, msg.roundHeight, msg.height, msg.addr, msg.addr2, msg.content, msg.insertTime.Format("2006-01-02 15:04:05.000")))
-	} else {
-		l.sqlBuilder.WriteString(fmt.Sprintf(",(\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\",\"%v\")", msg.msgType, msg.msgErr, l.where, msg.roundHeight, msg.height, msg.addr, msg.addr2, msg.content, msg.insertTime.Format("2006-01-02 15:04:05.000")))
+	if l != nil {
+		e.Where, e.Pool = l.where, l.pool
 	}
-	l.InsertCnt++
-}
-
-func (l *Logger) doWork(id int, msg Msg) {
-	l.insertLog(msg)
-	if l.InsertCnt > insertSize {
-		l.Save(id, insertSize)
+	writeLog(e)
+	dispatch(e)
+	if l != nil {
+		l.enqueue(e)
 	}
 }
 
-func (l *Logger) Save(id int,insertSize int) {
-
-//	start := time.Now()
-	tmpString, size := l.checkIns(insertSize)
-	if size <= 0 {
+// writeLog logs an entry with its columns as fields. Errors are logged at error level, the
+// other system sub types, like anomalies and bans, at warn level.
+func writeLog(e *Entry) {
+	level := xlog.LevelInfo
+	if e.SubType == LogSubTypeError {
+		level = xlog.LevelError
+	} else if e.SubType > LogSubTypeError {
+		level = xlog.LevelWarn
+	}
+	if !log.Enabled(level) {
 		return
 	}
 
-	if tmpString != nil {
-		l.Db.InsertSqlLog(tmpString)
+	fields := []interface{}{"pool", e.Pool, "type", e.Type}
+	if e.SubType != LogErrorNothing {
+		fields = append(fields, "subType", e.SubType)
 	}
-
-//	log.Printf("id %v doWork (gap: %v). size:%v\n", id, time.Since(start), size)
-}
-
-func (l *Logger) Close() {
-	// Save all log messages.
-	time.Sleep(1*time.Second)
-	Loop:
-	for {
-		select {
-			case m := <- l.MsgQueue:
-				l.doWork(0, m)
-			default:
-				l.Save(0, 0)
-				break Loop
-		}
+	if e.RoundHeight != 0 {
+		fields = append(fields, "roundHeight", e.RoundHeight)
 	}
-}
-
-
-
-func (l *Logger) checkIns(insertSize int) (*string, int) {
-	var tmpString *string
-	l.lock.Lock()
-	defer l.lock.Unlock()
-
-	ret := l.InsertCnt
-	if l.InsertCnt > insertSize {
-		l.InsertCnt = 0
-		tmpLog := l.sqlBuilder.String()
-		tmpString = &tmpLog
-		l.sqlBuilder =strings.Builder{}
-	} else {
-		return nil, ret
+	if e.Height != 0 {
+		fields = append(fields, "height", e.Height)
+	}
+	if len(e.Addr) > 0 {
+		fields = append(fields, "addr", e.Addr)
 	}
-	return tmpString, ret
-}
\ No newline at end of file
+	if len(e.Addr2) > 0 {
+		fields = append(fields, "addr2", e.Addr2)
+	}
+	log.With(fields...).Log(level, e.Msg)
+}
@@ -0,0 +1,175 @@
+package plogger
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+)
+
+// Entry is a log entry as sinks receive it.
+type Entry struct {
+	Time        time.Time `json:"time"`
+	Pool        string    `json:"pool,omitempty"`
+	Where       string    `json:"where"`
+	Type        int       `json:"type"`
+	SubType     int       `json:"subType"`
+	RoundHeight int64     `json:"roundHeight,omitempty"`
+	Height      int64     `json:"height,omitempty"`
+	Addr        string    `json:"addr,omitempty"`
+	Addr2       string    `json:"addr2,omitempty"`
+	Msg         string    `json:"msg"`
+}
+
+// IsError tells whether the entry reports an error.
+func (e *Entry) IsError() bool {
+	return e.SubType == LogSubTypeError
+}
+
+// Sink receives entries besides the log table. Write delivers a batch, it either delivers all
+// of them or returns an error and the batch is retried.
+type Sink interface {
+	Name() string
+	Write(entries []*Entry) error
+}
+
+type SinkOptions struct {
+	// Entries queued while the sink is unavailable, further entries are dropped
+	BufferSize int
+	// Entries per Write
+	BatchSize int
+	// Attempts to write a batch before it's dropped
+	Retries int
+	// Only entries that report errors
+	ErrorsOnly bool
+	// Only entries of these types, all if empty
+	Types []int
+}
+
+type sinkQueue struct {
+	sink    Sink
+	opts    SinkOptions
+	entries chan *Entry
+	// Entries queued or being written
+	pending int64
+}
+
+var (
+	sinksMu sync.RWMutex
+	sinks   []*sinkQueue
+)
+
+// AddSink delivers the entries logged from now on to sink, from a queue of its own.
+func AddSink(sink Sink, opts SinkOptions) {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 10000
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = 5
+	}
+	q := &sinkQueue{sink: sink, opts: opts, entries: make(chan *Entry, opts.BufferSize)}
+	sinksMu.Lock()
+	sinks = append(sinks, q)
+	sinksMu.Unlock()
+	go q.run()
+}
+
+func (q *sinkQueue) accepts(e *Entry) bool {
+	if q.opts.ErrorsOnly && !e.IsError() {
+		return false
+	}
+	if len(q.opts.Types) == 0 {
+		return true
+	}
+	for _, t := range q.opts.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+func dispatch(e *Entry) {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	for _, q := range sinks {
+		if !q.accepts(e) {
+			continue
+		}
+		atomic.AddInt64(&q.pending, 1)
+		select {
+		case q.entries <- e:
+		default:
+			atomic.AddInt64(&q.pending, -1)
+			metrics.LogSinkEntries.Inc(e.Pool, q.sink.Name(), "dropped")
+		}
+	}
+}
+
+func (q *sinkQueue) run() {
+	name := q.sink.Name()
+	for e := range q.entries {
+		batch := []*Entry{e}
+	Batch:
+		for len(batch) < q.opts.BatchSize {
+			select {
+			case e := <-q.entries:
+				batch = append(batch, e)
+			default:
+				break Batch
+			}
+		}
+
+		backoff := time.Second
+		for attempt := 1; ; attempt++ {
+			err := q.sink.Write(batch)
+			if err == nil {
+				countEntries(batch, name, "sent")
+				break
+			}
+			if attempt >= q.opts.Retries {
+				log.Errorf("Dropped %v log entries after %v attempts to write to %v: %v", len(batch), attempt, name, err)
+				countEntries(batch, name, "dropped")
+				break
+			}
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+		atomic.AddInt64(&q.pending, -int64(len(batch)))
+	}
+}
+
+// countEntries counts a batch of a sink by the pools of its entries.
+func countEntries(batch []*Entry, sink, result string) {
+	counts := make(map[string]int)
+	for _, e := range batch {
+		counts[e.Pool]++
+	}
+	for pool, n := range counts {
+		metrics.LogSinkEntries.Add(float64(n), pool, sink, result)
+	}
+}
+
+// flushSinks waits until the sinks wrote the queued entries, or timeout.
+func flushSinks(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		pending := int64(0)
+		sinksMu.RLock()
+		for _, q := range sinks {
+			pending += atomic.LoadInt64(&q.pending)
+		}
+		sinksMu.RUnlock()
+		if pending == 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	log.Warnf("Log sinks didn't write their queued entries within %v", timeout)
+}
@@ -0,0 +1,48 @@
+package plogger
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSink struct {
+	mu      sync.Mutex
+	fails   int
+	written []*Entry
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) Write(entries []*Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("unavailable")
+	}
+	s.written = append(s.written, entries...)
+	return nil
+}
+
+func TestSink(t *testing.T) {
+	errorSink := &fakeSink{fails: 1}
+	blockSink := &fakeSink{}
+	AddSink(errorSink, SinkOptions{ErrorsOnly: true, Retries: 2})
+	AddSink(blockSink, SinkOptions{Types: []int{LogTypePendingBlock}})
+
+	dispatch(&Entry{Type: LogTypeSystem, SubType: LogSubTypeError, Msg: "failed"})
+	dispatch(&Entry{Type: LogTypePendingBlock, Msg: "immature"})
+	dispatch(&Entry{Type: LogTypeSystem, SubType: LogSubTypeSwarm, Msg: "swarm"})
+	flushSinks(5 * time.Second)
+
+	if len(errorSink.written) != 1 || errorSink.written[0].Msg != "failed" {
+		t.Errorf("Error sink must get the error after a retry, got %+v", errorSink.written)
+	}
+	if len(blockSink.written) != 1 || blockSink.written[0].Msg != "immature" {
+		t.Errorf("Block sink must only get pending blocks, got %+v", blockSink.written)
+	}
+}
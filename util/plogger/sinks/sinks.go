@@ -0,0 +1,188 @@
+// Package sinks delivers system log entries to operator-defined sinks besides the log table.
+package sinks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/events"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Entries queued per sink while it's unavailable, further entries are dropped
+	BufferSize int `json:"bufferSize"`
+	// Entries per write
+	BatchSize int `json:"batchSize"`
+	// Attempts to write a batch before it's dropped
+	Retries int          `json:"retries"`
+	Sinks   []SinkConfig `json:"sinks"`
+}
+
+type SinkConfig struct {
+	// file, syslog, webhook or kafka
+	Type string `json:"type"`
+	// Only entries that report errors
+	ErrorsOnly bool `json:"errorsOnly"`
+	// Only entries of these types, e.g. 1000 for pending blocks, all if empty
+	Types []int `json:"types"`
+
+	// file: JSON lines appended to path
+	Path string `json:"path"`
+	// syslog: the local daemon when address is empty
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Tag     string `json:"tag"`
+	// webhook: POSTs {"entries": [...]}
+	URL           string `json:"url"`
+	Authorization string `json:"authorization"`
+	Timeout       string `json:"timeout"`
+	// kafka: one record per entry, keyed by addr
+	Kafka events.KafkaConfig `json:"kafka"`
+}
+
+// Start adds the configured sinks to plogger.
+func Start(cfg *Config) error {
+	for i := range cfg.Sinks {
+		sc := &cfg.Sinks[i]
+		sink, err := newSink(sc)
+		if err != nil {
+			return fmt.Errorf("log sink %v: %v", i, err)
+		}
+		plogger.AddSink(sink, plogger.SinkOptions{
+			BufferSize: cfg.BufferSize,
+			BatchSize:  cfg.BatchSize,
+			Retries:    cfg.Retries,
+			ErrorsOnly: sc.ErrorsOnly,
+			Types:      sc.Types,
+		})
+	}
+	return nil
+}
+
+func newSink(cfg *SinkConfig) (plogger.Sink, error) {
+	switch cfg.Type {
+	case "file":
+		if len(cfg.Path) == 0 {
+			return nil, fmt.Errorf("file needs a path")
+		}
+		return &fileSink{path: cfg.Path}, nil
+	case "syslog":
+		return newSyslogSink(cfg)
+	case "webhook":
+		if len(cfg.URL) == 0 {
+			return nil, fmt.Errorf("webhook needs a url")
+		}
+		timeout := 10 * time.Second
+		if len(cfg.Timeout) > 0 {
+			timeout = util.MustParseDuration(cfg.Timeout)
+		}
+		return &webhookSink{url: cfg.URL, authorization: cfg.Authorization, client: &http.Client{Timeout: timeout}}, nil
+	case "kafka":
+		if len(cfg.Kafka.Brokers) == 0 {
+			return nil, fmt.Errorf("kafka needs brokers")
+		}
+		if len(cfg.Kafka.Topic) == 0 {
+			cfg.Kafka.Topic = "pool-logs"
+		}
+		return &kafkaSink{producer: events.NewKafkaPublisher(&cfg.Kafka)}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", cfg.Type)
+}
+
+// fileSink appends the entries as JSON lines.
+type fileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (s *fileSink) Name() string {
+	return "file"
+}
+
+func (s *fileSink) Write(entries []*plogger.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+type webhookSink struct {
+	url           string
+	authorization string
+	client        *http.Client
+}
+
+func (s *webhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *webhookSink) Write(entries []*plogger.Entry) error {
+	body, err := json.Marshal(map[string]interface{}{"entries": entries})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.authorization) > 0 {
+		req.Header.Set("Authorization", s.authorization)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook replied %v: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// kafkaSink produces an entry per record, so entries of an address stay in order on one partition.
+// A batch retried after a partial failure produces its first entries twice.
+type kafkaSink struct {
+	producer *events.KafkaPublisher
+}
+
+func (s *kafkaSink) Name() string {
+	return "kafka"
+}
+
+func (s *kafkaSink) Write(entries []*plogger.Entry) error {
+	for _, e := range entries {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := s.producer.Publish("log", e.Addr, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+package sinks
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(cfg *SinkConfig) (plogger.Sink, error) {
+	tag := cfg.Tag
+	if len(tag) == 0 {
+		tag = "open-dangnn-pool"
+	}
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: w}, nil
+}
+
+func (s *syslogSink) Name() string {
+	return "syslog"
+}
+
+// Write sends an entry per message, errors at err severity and the other system sub types at warning.
+func (s *syslogSink) Write(entries []*plogger.Entry) error {
+	for _, e := range entries {
+		msg := formatEntry(e)
+		var err error
+		switch {
+		case e.IsError():
+			err = s.writer.Err(msg)
+		case e.SubType > plogger.LogSubTypeError:
+			err = s.writer.Warning(msg)
+		default:
+			err = s.writer.Info(msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatEntry(e *plogger.Entry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s type=%v subType=%v", e.Where, e.Type, e.SubType)
+	if e.RoundHeight != 0 {
+		fmt.Fprintf(&b, " roundHeight=%v", e.RoundHeight)
+	}
+	if e.Height != 0 {
+		fmt.Fprintf(&b, " height=%v", e.Height)
+	}
+	if len(e.Addr) > 0 {
+		fmt.Fprintf(&b, " addr=%v", e.Addr)
+	}
+	if len(e.Addr2) > 0 {
+		fmt.Fprintf(&b, " addr2=%v", e.Addr2)
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Msg)
+	return b.String()
+}
@@ -0,0 +1,11 @@
+package sinks
+
+import (
+	"errors"
+
+	"github.com/cellcrypto/open-dangnn-pool/util/plogger"
+)
+
+func newSyslogSink(cfg *SinkConfig) (plogger.Sink, error) {
+	return nil, errors.New("syslog is not supported on windows")
+}
@@ -0,0 +1,261 @@
+package plogger
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/metrics"
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type WriterConfig struct {
+	// Entries waiting for the log table, further entries go to overflowFile. 20000 by default
+	QueueSize int `json:"queueSize"`
+	// Rows per INSERT, 500 by default
+	BatchSize int `json:"batchSize"`
+	// A partial batch is written after this long, "1s" by default
+	FlushInterval string `json:"flushInterval"`
+	// Entries that couldn't be queued or written, replayed into the log table at the next start.
+	// "log-overflow.jsonl" by default
+	OverflowFile string `json:"overflowFile"`
+}
+
+type LogDB interface {
+	InsertLogs(table string, entries []*Entry) error
+}
+
+// Logger writes the entries to the log table in batches from a goroutine of its own, so logging
+// never waits for MySQL. Entries it can't queue or write are appended to the overflow file.
+type Logger struct {
+	db    LogDB
+	pool  string
+	where string
+	table string
+
+	queue        chan *Entry
+	batchSize    int
+	flushIntv    time.Duration
+	overflowPath string
+	overflowMu   sync.Mutex
+
+	closed int32
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+const (
+	writeRetries = 3
+	closeTimeout = 10 * time.Second
+)
+
+// New starts the logger of pool, returned by For from now on.
+func New(db LogDB, pool, where string, logTableName string, cfg *WriterConfig) *Logger {
+	l := &Logger{
+		db:           db,
+		pool:         pool,
+		where:        where,
+		table:        logTableName,
+		batchSize:    500,
+		flushIntv:    time.Second,
+		overflowPath: "log-overflow.jsonl",
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	queueSize := 20000
+	if cfg.QueueSize > 0 {
+		queueSize = cfg.QueueSize
+	}
+	if cfg.BatchSize > 0 {
+		l.batchSize = cfg.BatchSize
+	}
+	if len(cfg.FlushInterval) > 0 {
+		l.flushIntv = util.MustParseDuration(cfg.FlushInterval)
+	}
+	if len(cfg.OverflowFile) > 0 {
+		l.overflowPath = cfg.OverflowFile
+	}
+	l.queue = make(chan *Entry, queueSize)
+	loggersMu.Lock()
+	loggers[pool] = l
+	loggersMu.Unlock()
+
+	go l.run(l.readOverflow())
+	return l
+}
+
+func (l *Logger) enqueue(e *Entry) {
+	if atomic.LoadInt32(&l.closed) == 0 {
+		select {
+		case l.queue <- e:
+			return
+		default:
+		}
+	}
+	l.overflow([]*Entry{e})
+}
+
+func (l *Logger) run(replay []*Entry) {
+	defer close(l.done)
+	if len(replay) > 0 {
+		log.Infof("Replaying %v log entries from %v", len(replay), l.overflowPath)
+		for len(replay) > 0 {
+			n := len(replay)
+			if n > l.batchSize {
+				n = l.batchSize
+			}
+			l.write(replay[:n])
+			replay = replay[n:]
+		}
+		os.Remove(l.replayPath())
+	}
+
+	batch := make([]*Entry, 0, l.batchSize)
+	ticker := time.NewTicker(l.flushIntv)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-l.queue:
+			batch = append(batch, e)
+			if len(batch) >= l.batchSize {
+				l.write(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				l.write(batch)
+				batch = batch[:0]
+			}
+		case <-l.quit:
+			// Write what was queued before Close
+			for {
+				select {
+				case e := <-l.queue:
+					batch = append(batch, e)
+					if len(batch) >= l.batchSize {
+						l.write(batch)
+						batch = batch[:0]
+					}
+				default:
+					if len(batch) > 0 {
+						l.write(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// write inserts a batch, retrying for a while before it goes to the overflow file.
+func (l *Logger) write(batch []*Entry) {
+	var err error
+	backoff := 500 * time.Millisecond
+	for attempt := 1; attempt <= writeRetries; attempt++ {
+		if err = l.db.InsertLogs(l.table, batch); err == nil {
+			metrics.LogSinkEntries.Add(float64(len(batch)), l.pool, "table", "sent")
+			return
+		}
+		if attempt < writeRetries {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	log.Errorf("Failed to write %v entries to the log table, saving them to %v: %v", len(batch), l.overflowPath, err)
+	l.overflow(batch)
+}
+
+// overflow appends entries to the overflow file, one JSON object per line.
+func (l *Logger) overflow(entries []*Entry) {
+	l.overflowMu.Lock()
+	defer l.overflowMu.Unlock()
+	f, err := os.OpenFile(l.overflowPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		enc := json.NewEncoder(f)
+		for _, e := range entries {
+			if err = enc.Encode(e); err != nil {
+				break
+			}
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Errorf("Lost %v log entries, failed to save them to %v: %v", len(entries), l.overflowPath, err)
+		metrics.LogSinkEntries.Add(float64(len(entries)), l.pool, "table", "dropped")
+		return
+	}
+	metrics.LogSinkEntries.Add(float64(len(entries)), l.pool, "table", "overflow")
+}
+
+func (l *Logger) replayPath() string {
+	return l.overflowPath + ".replay"
+}
+
+// readOverflow takes the entries saved by a previous run. The file is moved aside until they are
+// written, a replay cut short by a crash is done again and may write some entries twice.
+func (l *Logger) readOverflow() []*Entry {
+	replaying := l.replayPath()
+	if _, err := os.Stat(replaying); os.IsNotExist(err) {
+		if err := os.Rename(l.overflowPath, replaying); err != nil {
+			if !os.IsNotExist(err) {
+				log.Errorf("Failed to replay %v: %v", l.overflowPath, err)
+			}
+			return nil
+		}
+	}
+	f, err := os.Open(replaying)
+	if err != nil {
+		log.Errorf("Failed to replay %v: %v", replaying, err)
+		return nil
+	}
+	defer f.Close()
+
+	var entries []*Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Warnf("Skipped an unreadable entry of %v: %v", replaying, err)
+			continue
+		}
+		entries = append(entries, &e)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Errorf("Failed to read %v: %v", replaying, err)
+	}
+	return entries
+}
+
+// Close writes the queued entries, to the overflow file if the log table doesn't take them in
+// time, and waits for the sinks. Entries logged afterwards go to the overflow file.
+func (l *Logger) Close() {
+	if !atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+		return
+	}
+	close(l.quit)
+	select {
+	case <-l.done:
+	case <-time.After(closeTimeout):
+		log.Warnf("Log table writes didn't finish within %v", closeTimeout)
+	}
+	var rest []*Entry
+	for {
+		select {
+		case e := <-l.queue:
+			rest = append(rest, e)
+			continue
+		default:
+		}
+		break
+	}
+	if len(rest) > 0 {
+		l.overflow(rest)
+	}
+	flushSinks(5 * time.Second)
+}
@@ -0,0 +1,123 @@
+package plogger
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeDB struct {
+	mu      sync.Mutex
+	fail    bool
+	batches [][]*Entry
+}
+
+func (db *fakeDB) InsertLogs(table string, entries []*Entry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.fail {
+		return errors.New("unavailable")
+	}
+	db.batches = append(db.batches, append([]*Entry(nil), entries...))
+	return nil
+}
+
+func (db *fakeDB) written() []*Entry {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var entries []*Entry
+	for _, b := range db.batches {
+		entries = append(entries, b...)
+	}
+	return entries
+}
+
+func TestWriterBatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plogger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := &fakeDB{}
+	l := New(db, "main", "test", "log", &WriterConfig{BatchSize: 3, FlushInterval: "1h", OverflowFile: filepath.Join(dir, "overflow.jsonl")})
+	for i := 0; i < 7; i++ {
+		l.InsertLog("entry", LogTypeSystem, LogErrorNothing, 0, int64(i), "", "")
+	}
+	l.Close()
+
+	if n := len(db.written()); n != 7 {
+		t.Fatalf("expected 7 entries written, got %v", n)
+	}
+	if len(db.batches) != 3 || len(db.batches[0]) != 3 || len(db.batches[2]) != 1 {
+		t.Errorf("expected batches of 3, 3 and 1 on close, got %v", len(db.batches))
+	}
+	for i, e := range db.written() {
+		if e.Height != int64(i) || e.Where != "test" {
+			t.Errorf("unexpected entry %v: %+v", i, e)
+		}
+	}
+}
+
+func TestWriterOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plogger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "overflow.jsonl")
+
+	// The table is down: the entries are saved to the overflow file
+	db := &fakeDB{fail: true}
+	l := New(db, "main", "test", "log", &WriterConfig{FlushInterval: "10ms", OverflowFile: path})
+	l.InsertLog("lost block", LogTypeSystem, LogSubTypeError, 10, 11, "0xabc", "")
+	time.Sleep(2 * time.Second)
+	l.Close()
+	// Logged after close
+	l.InsertLog("late", LogTypeSystem, LogErrorNothing, 0, 0, "", "")
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected an overflow file: %v", err)
+	}
+
+	// Replayed into the table at the next start
+	db = &fakeDB{}
+	l = New(db, "main", "test", "log", &WriterConfig{FlushInterval: "10ms", OverflowFile: path})
+	l.Close()
+	written := db.written()
+	if len(written) != 2 || written[0].Msg != "lost block" || written[0].RoundHeight != 10 || written[0].Addr != "0xabc" || written[1].Msg != "late" {
+		t.Fatalf("expected the overflowed entries replayed, got %+v", written)
+	}
+	for _, p := range []string{path, path + ".replay"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %v removed after the replay", p)
+		}
+	}
+}
+
+func TestWriterQueueFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plogger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "overflow.jsonl")
+
+	db := &fakeDB{}
+	l := New(db, "main", "test", "log", &WriterConfig{QueueSize: 1, BatchSize: 100, FlushInterval: "1h", OverflowFile: path})
+	// Logging never blocks, what doesn't fit the queue goes to the file
+	for i := 0; i < 50; i++ {
+		l.InsertLog("entry", LogTypeSystem, LogErrorNothing, 0, int64(i), "", "")
+	}
+	l.Close()
+
+	l = New(db, "main", "test", "log", &WriterConfig{OverflowFile: path})
+	l.Close()
+	if n := len(db.written()); n != 50 {
+		t.Errorf("expected all 50 entries written eventually, got %v", n)
+	}
+}
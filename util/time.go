@@ -0,0 +1,45 @@
+package util
+
+import (
+	"time"
+)
+
+// Layout of the timestamps the pool writes to MySQL as strings. Stored times are UTC, the MySQL
+// sessions of the pool run in UTC too.
+const DBTimeLayout = "2006-01-02 15:04:05.000"
+
+// Time zone of the times shown in charts, reports and exports.
+var displayLocation = time.UTC
+
+// SetDisplayLocation sets the time zone times are shown in, e.g. "Asia/Seoul". Empty is UTC.
+func SetDisplayLocation(name string) error {
+	if len(name) == 0 {
+		displayLocation = time.UTC
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	displayLocation = loc
+	return nil
+}
+
+func DisplayLocation() *time.Location {
+	return displayLocation
+}
+
+// FormatDBTime formats t in UTC for MySQL.
+func FormatDBTime(t time.Time) string {
+	return t.UTC().Format(DBTimeLayout)
+}
+
+// ParseDBTime parses a MySQL timestamp as UTC. Fractional seconds are optional.
+func ParseDBTime(s string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04:05", s, time.UTC)
+}
+
+// FormatDisplayTime formats unix seconds with layout in the display time zone.
+func FormatDisplayTime(ts int64, layout string) string {
+	return time.Unix(ts, 0).In(displayLocation).Format(layout)
+}
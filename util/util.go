@@ -8,7 +8,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 )
 
@@ -54,29 +53,15 @@ func MakeTimestamp() int64 {
 	return time.Now().UnixNano() / int64(time.Millisecond)
 }
 
+// MakeTimestampDB returns the milliseconds of a UTC timestamp read from MySQL.
 func MakeTimestampDB(dbTimestamp string) int64 {
-	const layout = "2006-01-02 15:04:05.000"
-	t, _ := time.Parse(layout, dbTimestamp)
+	t, _ := ParseDBTime(dbTimestamp)
 
 	return t.UnixNano() / int64(time.Millisecond)
 }
 
 func MakeTimestampDB2(dbTimestamp string) int64 {
-	const layout = "2006-01-02 15:04:05"
-	t, _ := time.Parse(layout, dbTimestamp)
-
-	return t.UnixNano() / int64(time.Millisecond)
-}
-
-func GetTargetHex(diff int64) string {
-	difficulty := big.NewInt(diff)
-	diff1 := new(big.Int).Div(pow256, difficulty)
-	return string(common.ToHex(diff1.Bytes()))
-}
-
-func TargetHexToDiff(targetHex string) *big.Int {
-	targetBytes := common.FromHex(targetHex)
-	return new(big.Int).Div(pow256, new(big.Int).SetBytes(targetBytes))
+	return MakeTimestampDB(dbTimestamp)
 }
 
 func ToHex(n int64) string {
@@ -0,0 +1,384 @@
+// Package xlog is a leveled, structured logger with a level per module.
+//
+// Every package logs through its own module logger:
+//
+//	var log = xlog.Module("payouts")
+//	log.With("height", height).Warnf("Block %v orphaned", hash)
+//
+// Lines are written as text, or as one JSON object per line. Levels default to the config and
+// can be overridden at runtime, the overrides are kept in Redis so every process follows them.
+package xlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cellcrypto/open-dangnn-pool/util"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, bool) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), true
+		}
+	}
+	return LevelInfo, false
+}
+
+type Config struct {
+	// "text" (default) or "json", one object per line
+	Format string `json:"format"`
+	// Default level of every module: debug, info (default), warn or error
+	Level string `json:"level"`
+	// Level per module, e.g. {"proxy": "debug"}
+	Modules map[string]string `json:"modules"`
+	// Overrides are reloaded on change notifications and at this interval
+	RefreshInterval string `json:"refreshInterval"`
+}
+
+// Store keeps runtime overrides of module levels.
+type Store interface {
+	GetLogLevels() (map[string]string, error)
+}
+
+type module struct {
+	name  string
+	level int32
+	// Level without the runtime override
+	configured Level
+}
+
+var (
+	modulesMu sync.Mutex
+	modules   = make(map[string]*module)
+	// Level of modules the config doesn't name
+	defaultLevel = LevelInfo
+
+	outMu   sync.Mutex
+	out     io.Writer = os.Stderr
+	jsonOut int32
+)
+
+func getModule(name string) *module {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	m, ok := modules[name]
+	if !ok {
+		m = &module{name: name, level: int32(defaultLevel), configured: defaultLevel}
+		modules[name] = m
+	}
+	return m
+}
+
+// Logger logs for a module, with the fields added by With.
+type Logger struct {
+	m      *module
+	fields []interface{}
+}
+
+// Module returns the logger of a module, usually kept in a package variable.
+func Module(name string) *Logger {
+	return &Logger{m: getModule(name)}
+}
+
+// With returns a logger that adds key/value pairs to every line.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{m: l.m, fields: fields}
+}
+
+// Enabled tells whether lines of level are written, to skip building expensive ones.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(&l.m.level))
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) { l.logf(LevelDebug, format, v...) }
+func (l *Logger) Infof(format string, v ...interface{})  { l.logf(LevelInfo, format, v...) }
+func (l *Logger) Warnf(format string, v ...interface{})  { l.logf(LevelWarn, format, v...) }
+func (l *Logger) Errorf(format string, v ...interface{}) { l.logf(LevelError, format, v...) }
+
+// Debug, Info, Warn and Error format their operands like log.Println.
+func (l *Logger) Debug(v ...interface{}) { l.logln(LevelDebug, v...) }
+func (l *Logger) Info(v ...interface{})  { l.logln(LevelInfo, v...) }
+func (l *Logger) Warn(v ...interface{})  { l.logln(LevelWarn, v...) }
+func (l *Logger) Error(v ...interface{}) { l.logln(LevelError, v...) }
+
+// Log logs at a level chosen at runtime, formatting its operands like log.Println.
+func (l *Logger) Log(level Level, v ...interface{}) { l.logln(level, v...) }
+
+// Fatalf logs at error level whatever the module level, then exits.
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.write(LevelError, fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+// Fatal formats its operands like log.Fatal, logs at error level, then exits.
+func (l *Logger) Fatal(v ...interface{}) {
+	l.write(LevelError, fmt.Sprint(v...))
+	os.Exit(1)
+}
+
+func (l *Logger) logf(level Level, format string, v ...interface{}) {
+	if l.Enabled(level) {
+		l.write(level, fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger) logln(level Level, v ...interface{}) {
+	if l.Enabled(level) {
+		l.write(level, strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+	}
+}
+
+func (l *Logger) write(level Level, msg string) {
+	now := time.Now()
+	var buf bytes.Buffer
+	if atomic.LoadInt32(&jsonOut) == 1 {
+		buf.WriteString(`{"time":"`)
+		buf.WriteString(now.Format("2006-01-02T15:04:05.000Z07:00"))
+		buf.WriteString(`","level":"`)
+		buf.WriteString(level.String())
+		buf.WriteString(`","module":`)
+		writeJSON(&buf, l.m.name)
+		buf.WriteString(`,"msg":`)
+		writeJSON(&buf, msg)
+		for i := 0; i+1 < len(l.fields); i += 2 {
+			buf.WriteByte(',')
+			writeJSON(&buf, fmt.Sprint(l.fields[i]))
+			buf.WriteByte(':')
+			writeJSON(&buf, l.fields[i+1])
+		}
+		buf.WriteString("}\n")
+	} else {
+		buf.WriteString(now.Format("2006/01/02 15:04:05 "))
+		buf.WriteString(strings.ToUpper(level.String()))
+		buf.WriteByte(' ')
+		buf.WriteString(l.m.name)
+		buf.WriteString(": ")
+		buf.WriteString(msg)
+		for i := 0; i+1 < len(l.fields); i += 2 {
+			fmt.Fprintf(&buf, " %v=%s", l.fields[i], textValue(l.fields[i+1]))
+		}
+		buf.WriteByte('\n')
+	}
+	outMu.Lock()
+	out.Write(buf.Bytes())
+	outMu.Unlock()
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case error:
+		v = t.Error()
+	case fmt.Stringer:
+		v = t.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if len(s) == 0 || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// stdWriter takes the lines of the standard logger, for packages that still use it.
+type stdWriter struct {
+	l *Logger
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	w.l.logf(LevelInfo, "%s", bytes.TrimSuffix(p, []byte("\n")))
+	return len(p), nil
+}
+
+type LevelState struct {
+	Module   string  `json:"module"`
+	Level    string  `json:"level"`
+	Default  string  `json:"default"`
+	Override *string `json:"override"`
+}
+
+// Levels applies the configured levels and the runtime overrides to the modules.
+type Levels struct {
+	mu        sync.Mutex
+	overrides map[string]Level
+	store     Store
+}
+
+var defaultLevels = &Levels{overrides: make(map[string]Level)}
+
+// Init sets the output and levels of this process and loads the overrides. The standard logger
+// is routed to the "pool" module.
+func Init(cfg *Config, store Store) *Levels {
+	if cfg.Format == "json" {
+		atomic.StoreInt32(&jsonOut, 1)
+	}
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(stdWriter{Module("pool")})
+
+	if len(cfg.Level) > 0 {
+		level, ok := ParseLevel(cfg.Level)
+		if !ok {
+			stdlog.Printf("Unknown log level %v in config", cfg.Level)
+		}
+		defaultLevel = level
+	}
+	modulesMu.Lock()
+	for _, m := range modules {
+		m.configured = defaultLevel
+	}
+	modulesMu.Unlock()
+	for name, s := range cfg.Modules {
+		level, ok := ParseLevel(s)
+		if !ok {
+			stdlog.Printf("Unknown log level %v of module %v in config", s, name)
+			continue
+		}
+		getModule(name).configured = level
+	}
+
+	lv := &Levels{overrides: make(map[string]Level), store: store}
+	if err := lv.Reload(); err != nil {
+		stdlog.Printf("Failed to load log levels, using config: %v", err)
+		lv.apply()
+	}
+	interval := time.Minute
+	if len(cfg.RefreshInterval) > 0 {
+		interval = util.MustParseDuration(cfg.RefreshInterval)
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := lv.Reload(); err != nil {
+				stdlog.Printf("Failed to reload log levels: %v", err)
+			}
+		}
+	}()
+	defaultLevels = lv
+	return lv
+}
+
+func States() []*LevelState {
+	return defaultLevels.States()
+}
+
+// IsModule tells whether a module logs in this process.
+func IsModule(name string) bool {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	_, ok := modules[name]
+	return ok
+}
+
+// Reload replaces the overrides with the stored ones.
+func (lv *Levels) Reload() error {
+	if lv.store == nil {
+		return nil
+	}
+	stored, err := lv.store.GetLogLevels()
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]Level)
+	for name, s := range stored {
+		if level, ok := ParseLevel(s); ok {
+			overrides[name] = level
+		}
+	}
+
+	lv.mu.Lock()
+	for name, level := range overrides {
+		if previous, ok := lv.overrides[name]; !ok || previous != level {
+			stdlog.Printf("Log level of %v set to %v", name, level)
+		}
+	}
+	for name := range lv.overrides {
+		if _, ok := overrides[name]; !ok {
+			stdlog.Printf("Log level of %v reset", name)
+		}
+	}
+	lv.overrides = overrides
+	lv.mu.Unlock()
+	lv.apply()
+	return nil
+}
+
+func (lv *Levels) apply() {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	for name := range lv.overrides {
+		getModule(name)
+	}
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	for name, m := range modules {
+		level := m.configured
+		if override, ok := lv.overrides[name]; ok {
+			level = override
+		}
+		atomic.StoreInt32(&m.level, int32(level))
+	}
+}
+
+// RedisMessage reloads the overrides when they are changed through the API.
+func (lv *Levels) RedisMessage(payload string) {
+	if err := lv.Reload(); err != nil {
+		stdlog.Printf("Failed to reload log levels: %v", err)
+	}
+}
+
+// States lists the modules of this process with their level.
+func (lv *Levels) States() []*LevelState {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+
+	var result []*LevelState
+	for name, m := range modules {
+		state := &LevelState{Module: name, Level: Level(atomic.LoadInt32(&m.level)).String(), Default: m.configured.String()}
+		if override, ok := lv.overrides[name]; ok {
+			s := override.String()
+			state.Override = &s
+		}
+		result = append(result, state)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Module < result[j].Module })
+	return result
+}
@@ -0,0 +1,87 @@
+package xlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type fakeStore map[string]string
+
+func (s fakeStore) GetLogLevels() (map[string]string, error) {
+	return s, nil
+}
+
+func capture(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	out = &buf
+	t.Cleanup(func() {
+		out = os.Stderr
+		atomic.StoreInt32(&jsonOut, 0)
+	})
+	return &buf
+}
+
+func TestText(t *testing.T) {
+	buf := capture(t)
+	log := Module("text")
+	log.With("login", "0xabc", "reason", "low diff").Warnf("Rejected share from %v", "rig-1")
+	line := buf.String()
+	if !strings.Contains(line, ` WARN text: Rejected share from rig-1 login=0xabc reason="low diff"`) {
+		t.Errorf("Unexpected line %q", line)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	buf := capture(t)
+	atomic.StoreInt32(&jsonOut, 1)
+	Module("json").With("height", 10, "err", errors.New("timeout")).Error("Failed to submit block")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Line is not JSON: %v", err)
+	}
+	for key, want := range map[string]interface{}{
+		"level": "error", "module": "json", "msg": "Failed to submit block", "height": 10.0, "err": "timeout",
+	} {
+		if entry[key] != want {
+			t.Errorf("%v is %v, want %v", key, entry[key], want)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	buf := capture(t)
+	proxy, api := Module("lvproxy"), Module("lvapi")
+	store := fakeStore{}
+	lv := Init(&Config{Level: "warn", Modules: map[string]string{"lvproxy": "debug"}}, store)
+	out = buf
+
+	api.Infof("hidden")
+	proxy.Debugf("shown")
+	if s := buf.String(); strings.Contains(s, "hidden") || !strings.Contains(s, "shown") {
+		t.Errorf("Config levels not applied: %q", s)
+	}
+
+	store["lvapi"] = "debug"
+	store["lvproxy"] = "error"
+	lv.RedisMessage("")
+	if !api.Enabled(LevelDebug) || proxy.Enabled(LevelWarn) {
+		t.Error("Overrides not applied")
+	}
+	for _, state := range States() {
+		if state.Module == "lvproxy" && (state.Level != "error" || state.Default != "debug" || *state.Override != "error") {
+			t.Errorf("Unexpected state %+v", state)
+		}
+	}
+
+	delete(store, "lvproxy")
+	lv.Reload()
+	if !proxy.Enabled(LevelDebug) {
+		t.Error("Reset must restore the configured level")
+	}
+}